@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mesosphere/csilvm/pkg/csilvm"
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"github.com/mesosphere/csilvm/pkg/version"
+)
+
+// printVersion implements the `csilvm version` subcommand. It prints the
+// build-time version metadata and returns the process exit code.
+func printVersion(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	jsonF := fs.Bool("json", false, "If set, the version is printed as JSON")
+	fs.Parse(args)
+
+	v := version.Get()
+	if *jsonF {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot write version: err=%v\n", err)
+			return 1
+		}
+		return 0
+	}
+	unknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	fmt.Printf("%v %v\n", v.Product, v.Version)
+	fmt.Printf("build sha: %v\n", unknown(v.BuildSHA))
+	fmt.Printf("build time: %v\n", unknown(v.BuildTime))
+	return 0
+}
+
+// checkEnv implements the `csilvm check-env` subcommand. It runs the checks
+// of Setup and Probe without changing anything, prints a report and returns
+// a non-zero exit code if any check failed.
+func checkEnv(args []string) int {
+	fs := flag.NewFlagSet("check-env", flag.ExitOnError)
+	vgnameF := fs.String("volume-group", "", "The name of the volume group to check")
+	pvnamesF := fs.String("devices", "", "A comma-seperated list of devices in the volume group")
+	defaultFsF := fs.String("default-fs", defaultDefaultFs, "The default filesystem to format new volumes with")
+	var probeModulesF stringsFlag
+	fs.Var(&probeModulesF, "probe-module", "A kernel module that must be loaded (can be given multiple times)")
+	var probeToolsF stringsFlag
+	fs.Var(&probeToolsF, "probe-tool", "An executable that must be in $PATH, in addition to the tools the plugin always requires (can be given multiple times)")
+	minKernelVersionF := fs.String("min-kernel-version", "", "If set, the running kernel must be at least this version, e.g., 4.10")
+	publishDirF := fs.String("publish-dir", "", "The directory under which the CO publishes volumes, e.g., /var/lib/kubelet; if run in a container, it must be mounted with shared propagation")
+	lockFilePathF := fs.String("lockfile", defaultLockfilePathOrEnv(), "The path to the lock file used to prevent concurrent lvm invocation by multiple csilvm instances")
+	jsonF := fs.Bool("json", false, "If set, the report is printed as JSON")
+	fs.Parse(args)
+
+	// Logs go to stderr so that stdout only contains the report.
+	logger := log.New(os.Stderr, "[check-env]", log.LstdFlags|log.Lshortfile)
+	csilvm.SetLogger(logger)
+	lvm.SetLogger(logger)
+
+	if *minKernelVersionF != "" {
+		if err := csilvm.ValidateKernelVersion(*minKernelVersionF); err != nil {
+			logger.Fatalf("invalid -min-kernel-version: %v", err)
+		}
+	}
+	if *vgnameF != "" && *lockFilePathF != "" {
+		lvm.SetLockFilePath(*lockFilePathF)
+	}
+	opts := []csilvm.ServerOpt{
+		csilvm.ProbeModules(probeModulesF),
+		csilvm.ProbeTools(probeToolsF),
+	}
+	if *minKernelVersionF != "" {
+		opts = append(opts, csilvm.MinKernelVersion(*minKernelVersionF))
+	}
+	if *publishDirF != "" {
+		opts = append(opts, csilvm.PublishDir(*publishDirF))
+	}
+	var pvnames []string
+	if *pvnamesF != "" {
+		pvnames = strings.Split(*pvnamesF, ",")
+	}
+	s := csilvm.NewServer(*vgnameF, pvnames, *defaultFsF, opts...)
+	report := s.CheckEnv(context.Background())
+
+	if *jsonF {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot write report: err=%v\n", err)
+			return 1
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, check := range report.Checks {
+			result := "ok"
+			if !check.OK {
+				result = "FAIL"
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\n", result, check.Name, check.Detail)
+			if check.Remediation != "" {
+				fmt.Fprintf(w, "\t\t%v\n", check.Remediation)
+			}
+		}
+		w.Flush()
+	}
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
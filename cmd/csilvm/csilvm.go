@@ -3,12 +3,16 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -17,12 +21,7 @@ import (
 	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"github.com/mesosphere/csilvm/pkg/csilvm"
 	"github.com/mesosphere/csilvm/pkg/lvm"
-
-	datadogstatsd "github.com/DataDog/datadog-go/statsd"
-	"github.com/cactus/go-statsd-client/statsd"
-	"github.com/mesosphere/csilvm/pkg/ddstatsd"
-	"github.com/uber-go/tally"
-	tallystatsd "github.com/uber-go/tally/statsd"
+	"github.com/mesosphere/csilvm/pkg/wipe"
 )
 
 const (
@@ -51,29 +50,124 @@ func defaultLockfilePathOrEnv() string {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "version":
+			os.Exit(printVersion(os.Args[2:]))
+		case "check-env":
+			os.Exit(checkEnv(os.Args[2:]))
+		case "diagnose":
+			os.Exit(diagnose(os.Args[2:]))
+		case "restore-vg":
+			os.Exit(restoreVG(os.Args[2:]))
+		case "move-volume":
+			os.Exit(moveVolume(os.Args[2:]))
+		case "soak":
+			// The soak subcommand is intended for release testing
+			// and is not documented in the usage.
+			os.Exit(soak(os.Args[2:]))
+		}
+	}
 	rand.Seed(time.Now().UnixNano())
 
 	// Configure flags
+	flag.String(flagsFileFlag, "", "A JSON file of flag names and values, e.g., {\"volume-group\": \"data\", \"tag\": [\"a\", \"b\"]}, that sets flags not given on the command line or as environment variables; any flag can be set by a CSILVM_<FLAG> environment variable, e.g., CSILVM_VOLUME_GROUP, where repeatable flags are separated by whitespace")
 	requestLimitF := flag.Int("request-limit", defaultRequestLimit, "Limits backlog of pending requests.")
+	var methodRequestLimitsF stringsFlag
+	flag.Var(&methodRequestLimitsF, "method-request-limit", "Limits the pending requests of an RPC separately from the request-limit, e.g., CreateVolume=2 (can be given multiple times)")
+	softRequestLimitF := flag.Int("soft-request-limit", 0, "If set, a warning is logged when more than this many requests are pending so that the request-limit can be tuned before requests are rejected")
 	vgnameF := flag.String("volume-group", "", "The name of the volume group to manage")
 	pvnamesF := flag.String("devices", "", "A comma-seperated list of devices in the volume group")
+	cacheDeviceTagF := flag.String("cache-device-tag", "", "The LVM tag of the physical volumes, e.g., fast SSDs, on which the cache pools of volumes created with the cache parameter are allocated")
+	standbyDevicesF := flag.String("standby-devices", "", "A comma-seperated list of devices onto which the volume group is extended when it runs out of space")
 	defaultFsF := flag.String("default-fs", defaultDefaultFs, "The default filesystem to format new volumes with")
+	var detectFsF stringsFlag
+	flag.Var(&detectFsF, "detect-fs", "A filesystem, e.g., ext4, that is supported in addition to the default-fs if Setup finds mkfs.<fstype> in $PATH and the kernel supports it (can be given multiple times)")
 	defaultVolumeSizeF := flag.Uint64("default-volume-size", defaultDefaultVolumeSize, "The default volume size in bytes")
+	extentSizeF := flag.Uint64("extent-size", 0, "The physical extent size in bytes used when creating the volume group (must be a power of two, defaults to the LVM default)")
 	socketFileF := flag.String("unix-addr", "", "The path to the listening unix socket file")
 	socketFileEnvF := flag.String("unix-addr-env", "", "An optional environment variable from which to read the unix-addr")
+	var endpointsF stringsFlag
+	adminEndpointF := flag.String("admin-endpoint", "", "An address at which a read-only HTTP API serves the inventory of the volume group as JSON at /inventory and the mounts of its volumes at /mounts, e.g., unix:///run/csilvm-admin.sock")
+	flag.Var(&endpointsF, "endpoint", "An additional address to listen on, e.g., unix:///run/csilvm.sock, unix://@csilvm or tcp://127.0.0.1:5000, where a TCP address without a host binds 127.0.0.1 (can be given multiple times)")
+	allowRemoteEndpointsF := flag.Bool("allow-remote-endpoints", false, "If set, the TCP addresses of -endpoint and -admin-endpoint may be reachable from other hosts, e.g., tcp://0.0.0.0:5000; they are neither authenticated nor encrypted so access must be restricted by other means, e.g., a firewall")
+	skipAutoActivationF := flag.Bool("skip-auto-activation", false, "If set, the activation skip flag is set on new logical volumes so that only the plugin activates them, not the host's LVM at boot")
+	selinuxContextF := flag.String("selinux-context", "", "The SELinux context with which filesystem volumes are mounted, e.g., system_u:object_r:container_file_t:s0")
+	createTargetPathF := flag.Bool("create-target-path", false, "If set, NodePublishVolume creates the target path if it does not exist")
+	atomicPublishDirF := flag.String("atomic-publish-dir", "", "If set, NodePublishVolume mounts filesystems at a private staging directory beneath this directory and moves them to the target path once configured")
+	handoffTimeoutF := flag.Duration("handoff-timeout", time.Minute, "How long the plugin waits for its in-flight request to finish after receiving SIGUSR2, which makes it start a successor and hand off its listeners once the successor is set up, before aborting the handoff, and how long it waits for the remaining requests once it handed off before canceling them")
+	traceF := flag.Bool("trace", false, "If set, each RPC is traced along with the LVM and other commands it runs, including the traceparent sent by the CO, and recent traces are served at /debug/requests on the admin-endpoint")
+	debugGRPCF := flag.Bool("debug-grpc", false, "If set, the gRPC server reflection and channelz services are registered so that tools such as grpcurl can be used against the endpoints")
+	adoptionTagF := flag.String("adoption-tag", "", "If set, logical volumes created outside of the plugin that carry this tag, e.g., csilvm.adopt, are adopted at startup: they are renamed with the volume-prefix, if any, unless they are open, their name and layout are recorded as their CO name and layout and the tag is removed, after which they are managed like the plugin's own volumes")
+	lvPrefixF := flag.String("lv-prefix", "", "If set, the names of new logical volumes, and thus the ids of new volumes, start with this prefix, e.g., csi-, so that they can be told apart from other logical volumes on the host; unlike volume-prefix it does not change which volumes are managed")
+	volumePrefixF := flag.String("volume-prefix", "", "If set, the ids of new volumes start with this prefix and an underscore, e.g., tenantA_, and volumes without it are ignored so that several plugin instances can share the volume group")
+	volumeEventsF := flag.Int("volume-events", 16, "How many of the most recent CreateVolume, DeleteVolume, NodePublishVolume and NodeUnpublishVolume events of each volume, including errors, are kept in memory and served at /volume-events on the admin-endpoint, 0 disables them")
+	volumeUsageStatsF := flag.Bool("volume-usage-stats", false, "If set, ListVolumes reports the used and free bytes of the filesystem of each mounted volume as volume attributes")
 	removeF := flag.Bool("remove-volume-group", false, "If set, the volume group will be removed when ProbeNode is called.")
 	var tagsF stringsFlag
 	flag.Var(&tagsF, "tag", "Value to tag the volume group with (can be given multiple times)")
+	var metadataParamsF stringsFlag
+	flag.Var(&metadataParamsF, "metadata-param", "A CreateVolume parameter, e.g., owner, whose value is recorded as a volume tag and reported as the metadata.<param> volume attribute (can be given multiple times)")
 	var probeModulesF stringsFlag
 	flag.Var(&probeModulesF, "probe-module", "Probe checks that the kernel module is loaded")
+	loadModulesF := flag.Bool("load-modules", false, "If set, Setup and Probe load the modules given by -probe-module with modprobe if they are not loaded")
+	minKernelVersionF := flag.String("min-kernel-version", "", "If set, Setup and Probe check that the running kernel is at least this version, e.g., 4.10")
+	var probeToolsF stringsFlag
+	flag.Var(&probeToolsF, "probe-tool", "Setup and Probe check that the executable is in $PATH, in addition to blkid, blockdev, dd, file, mkfs and mkfs.<fstype> for each supported filesystem")
+	var readonlyMountOptionsF stringsFlag
+	flag.Var(&readonlyMountOptionsF, "readonly-mount-options", "Overrides the mount options added when a filesystem is published readonly, e.g., xfs=nouuid,norecovery, an empty list disables them (can be given multiple times, defaults to xfs=norecovery and ext4=noload)")
+	var timeoutsF stringsFlag
+	flag.Var(&timeoutsF, "timeout", "Overrides the timeout of an RPC, e.g., DeleteVolume=12h, a zero duration disables the timeout; the defaults are CreateVolume=2m, DeleteVolume=4h, NodePublishVolume=1h and NodeUnpublishVolume=30s while other RPCs have no timeout (can be given multiple times)")
+	metadataBackupDirF := flag.String("metadata-backup-dir", "", "If set, a backup of the volume group metadata is written to this directory after every CreateVolume and DeleteVolume")
+	metadataBackupHookF := flag.String("metadata-backup-hook", "", "A program that is executed with the path of each volume group metadata backup as its argument")
+	ioConcurrencyLimitF := flag.Int("io-concurrency-limit", 0, "The maximum number of volumes formatted or wiped concurrently on the node by all csilvm instances using the same io-lock-dir, zero means unlimited")
+	ioLockDirF := flag.String("io-lock-dir", "/run/csilvm", "The directory of the lock files used to enforce the io-concurrency-limit")
+	var wipeMethodsF stringsFlag
+	flag.Var(&wipeMethodsF, "wipe-method", "A method used to delete the data of a volume, one of zeroout, discard or copy, which are tried in the order given until one is supported by the device (can be given multiple times, defaults to zeroout and copy)")
+	skipWipeIfDeviceMissingF := flag.Bool("skip-wipe-if-device-missing", false, "If set, DeleteVolume removes a volume without zeroing its data, logging a warning, if LVM knows the volume but its device node does not exist, instead of failing until the node is created by hand")
+	unmountRetriesF := flag.Int("unmount-retries", 3, "How many times NodeUnpublishVolume retries an unmount that fails as the mount is busy, e.g., as a process has its working directory in it, with exponential backoff from 100ms within the request deadline")
+	lazyUnmountF := flag.Bool("lazy-unmount", false, "If set, NodeUnpublishVolume lazily detaches a mount that is still busy after the unmount-retries, so that the CO can finish cleaning up; the filesystem stays mounted until the processes using it exit")
+	trashRetentionF := flag.Duration("trash-retention", 0, "If set, DeleteVolume renames volumes with the _trash_ prefix instead of zeroing and removing them, and they are purged once they have been in the trash this long, e.g., 72h, so that deleted volumes can be restored in the meantime")
+	scrubIntervalF := flag.Duration("scrub-interval", 0, "If set, each raid1 volume is checked for mismatches between its images with lvchange --syncaction check once per this interval, e.g., 168h, one volume at a time, to detect silent corruption")
+	var scrubWindowsF stringsFlag
+	flag.Var(&scrubWindowsF, "scrub-window", "A daily time range in local time, e.g., 01:00-05:00, in which scrub-interval checks are started; a range may wrap around midnight (can be given multiple times, defaults to any time)")
+	trashReapIntervalF := flag.Duration("trash-reap-interval", 10*time.Minute, "How often volumes whose trash-retention has expired are purged")
+	wipeBlockSizeF := flag.Uint64("wipe-block-size", wipe.DefaultBlockSize, "The number of bytes a volume's data is deleted in at a time by each wipe-method, a multiple of 512; larger blocks speed up zeroout on NVMe devices but delay cancellation")
+	wipeRateLimitF := flag.Uint64("wipe-rate-limit", 0, "If set, each DeleteVolume wipes a volume at no more than this many MB/s (1 MB = 1000000 bytes), averaged over wipe-block-size blocks, to bound the impact of large deletes on the I/O of other volumes")
+	operationJournalF := flag.Bool("operation-journal", false, "If set, CreateVolume, DeleteVolume and NodePublishVolume are recorded in a journal in the state-dir and operations interrupted by a crash are finished or rolled back at startup")
+	operationJournalReplayTimeoutF := flag.Duration("operation-journal-replay-timeout", time.Hour, "How long each interrupted operation that is finished or rolled back at startup may take, e.g., to wipe a deleted volume, before it is canceled and kept in the operation-journal until the next start")
+	stateDirF := flag.String("state-dir", "", "If set, the target paths at which volumes are published are recorded in a state file in this directory so that they survive restarts")
+	configFileF := flag.String("config-file", "", "A JSON file with supported_filesystems, volume_tags and default_volume_size that are applied in addition to the flags and re-read on SIGHUP")
 	nodeIDF := flag.String("node-id", "", "The node ID reported via the CSI Node gRPC service")
+	topologyF := flag.Bool("topology", false, "If set, the ACCESSIBILITY_CONSTRAINTS plugin capability is advertised and volumes are reported as accessible only from the node's io.mesosphere.csi.lvm/nodeId topology segment, requires node-id")
+	nodeVolumeIDsF := flag.Bool("node-volume-ids", false, "If set, volume ids are prefixed with the node-id and a colon, e.g., node1:csilv..., so that volumes on nodes with volume groups of the same name have distinct ids for COs that are not topology-aware")
+	deviceWaitTimeoutF := flag.Duration("device-wait-timeout", 10*time.Second, "How long to wait for udev to create the device node of a volume after creating it and before publishing it")
+	maxVolumesF := flag.Int64("max-volumes", 0, "If set, NodeGetInfo reports this as the maximum number of volumes on the node and CreateVolume fails with RESOURCE_EXHAUSTED once the volume group has this many volumes, including those in the trash")
+	provisionerSecretFileF := flag.String("provisioner-secret-file", "", "If set, CreateVolume and DeleteVolume requests must present the contents of this file, without a trailing newline, as their provisioner-secret secret or they are rejected with PERMISSION_DENIED")
+	allowDeviceConflictsF := flag.Bool("allow-device-conflicts", false, "If set, the plugin only logs signs that the devices or volume group are also managed by another driver or a systemd mount, e.g., device holders that are not logical volumes, fstab entries or devices missing from the LVM devices file, instead of refusing to start; only use it for known false positives")
+	forceDeviceInitF := flag.Bool("force-device-init", false, "If set, all signatures are wiped from devices before they become physical volumes, unless they are mounted, in use or belong to another volume group")
+	publishDirF := flag.String("publish-dir", "", "The directory under which the CO publishes volumes, e.g., /var/lib/kubelet; if the plugin runs in a container, Setup and Probe check that it is mounted with shared propagation")
+	lvmDevicesFileF := flag.String("lvm-devices-file", "", "If set, LVM commands are run with --devicesfile, using this devices file in /etc/lvm/devices, e.g., csilvm, to which the devices and standby devices are added at startup, instead of the host's system.devices")
+	privateLVMConfigF := flag.Bool("private-lvm-config", false, "If set, LVM commands are run with a private configuration whose global_filter only accepts the devices and standby devices")
+	lvmLockContentionTimeoutF := flag.Duration("lvm-lock-contention-timeout", 10*time.Second, "How long an LVM command that fails as another process holds the volume group lock is retried, with exponential backoff, when it is not part of a request with a deadline, e.g., at startup; commands run by requests are retried until shortly before the request deadline and then fail with UNAVAILABLE")
+	lvmMinScanIntervalF := flag.Duration("lvm-min-scan-interval", 0, "If set, a pvscan or vgscan of a device or volume group is skipped if the same scan succeeded less than this long ago, e.g., 5s; concurrent scans are always coalesced")
+	lvmReportCacheTTLF := flag.Duration("lvm-report-cache-ttl", 0, "If set, the output of lvs, vgs and pvs is cached for this long, e.g., 2s, unless the plugin changes the LVM metadata, so that bursts of requests do not re-read the metadata; changes made by others go unnoticed for at most this long")
 	lockFilePathF := flag.String("lockfile", defaultLockfilePathOrEnv(), "The path to the lock file used to prevent concurrent lvm invocation by multiple csilvm instances")
 	// Metrics-related flags
 	statsdUDPHostEnvVarF := flag.String("statsd-udp-host-env-var", "", "The name of the environment variable containing the host where a statsd service is listening for stats over UDP")
 	statsdUDPPortEnvVarF := flag.String("statsd-udp-port-env-var", "", "The name of the environment variable containing the port where a statsd service is listening for stats over UDP")
+	statsdAddrF := flag.String("statsd-addr", "", "The host:port where a statsd service is listening for stats over UDP, overrides -statsd-udp-host-env-var and -statsd-udp-port-env-var; metrics are not emitted if no statsd service is configured")
 	statsdFormatF := flag.String("statsd-format", "datadog", "The statsd format to use (one of: classic, datadog)")
+	statsdPrefixF := flag.String("statsd-prefix", defaultStatsdPrefix, "The prefix of the names of all metrics")
+	var statsdTagsF stringsFlag
+	metricsVolumeSlicesF := flag.Int("metrics-volume-slices", 0, "If set, the request metrics of CreateVolume, DeleteVolume, NodePublishVolume and NodeUnpublishVolume are tagged with volume_slice, one of this many slices into which volume ids are hashed, e.g., 16, and with the layout of the volume")
+	flag.Var(&statsdTagsF, "statsd-tag", "A tag added to all metrics, e.g., cluster=prod, in addition to volume-group and node-id (can be given multiple times)")
+	statsdFlushIntervalF := flag.Duration("statsd-flush-interval", defaultStatsdFlushInterval, "The interval at which metrics are reported to the statsd service")
 	statsdMaxUDPSizeF := flag.Int("statsd-max-udp-size", 1432, "The size to buffer before transmitting a statsd UDP packet")
 	flag.Parse()
+	if err := applyFlagSources(flag.CommandLine, os.Getenv); err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
 	// Setup logging
 	logprefix := fmt.Sprintf("[%s]", *vgnameF)
 	logflags := log.LstdFlags | log.Lshortfile
@@ -87,6 +181,27 @@ func main() {
 	if *lockFilePathF != "" {
 		lvm.SetLockFilePath(*lockFilePathF)
 	}
+	lvm.SetContentionRetryTimeout(*lvmLockContentionTimeoutF)
+	if *lvmMinScanIntervalF > 0 {
+		lvm.SetMinScanInterval(*lvmMinScanIntervalF)
+	}
+	if *lvmReportCacheTTLF > 0 {
+		lvm.SetReportCacheTTL(*lvmReportCacheTTLF)
+	}
+	if *lvmDevicesFileF != "" {
+		if err := lvm.SetDevicesFile(*lvmDevicesFileF); err != nil {
+			logger.Fatalf("cannot configure lvm devices file: err=%v", err)
+		}
+	}
+	if *privateLVMConfigF {
+		devices := strings.Split(*pvnamesF, ",")
+		if *standbyDevicesF != "" {
+			devices = append(devices, strings.Split(*standbyDevicesF, ",")...)
+		}
+		if err := lvm.SetDeviceFilter(devices); err != nil {
+			logger.Fatalf("cannot configure private lvm config: err=%v", err)
+		}
+	}
 	// Determine listen address.
 	if *socketFileF != "" && *socketFileEnvF != "" {
 		logger.Fatalf("cannot specify -unix-addr and -unix-addr-env")
@@ -95,98 +210,178 @@ func main() {
 	if *socketFileEnvF != "" {
 		sock = os.Getenv(*socketFileEnvF)
 	}
-	sock = strings.TrimPrefix(sock, "unix://")
-	// Unlink the domain socket in case it is left lying around from a
-	// previous run. err return is not really interesting because it is
-	// normal for this to fail if the process is starting for the first time.
-	logger.Printf("Unlinking socket file in case it still exists: %q", sock)
-	if err := syscall.Unlink(sock); err != nil {
-		logger.Printf("Failed to unlink socket file: %v", err)
+	var endpoints []endpoint
+	if sock != "" {
+		endpoints = append(endpoints, endpoint{"unix", strings.TrimPrefix(sock, "unix://")})
+	}
+	for _, value := range endpointsF {
+		e, err := parseEndpoint(value)
+		if err != nil {
+			logger.Fatalf("invalid -endpoint: %v", err)
+		}
+		if e.isRemote() && !*allowRemoteEndpointsF {
+			logger.Fatalf("invalid -endpoint %v: the address is reachable from other hosts but the endpoint is neither authenticated nor encrypted, use a loopback address or set -allow-remote-endpoints", e)
+		}
+		endpoints = append(endpoints, e)
+	}
+	if len(endpoints) == 0 {
+		logger.Fatalf("at least one of -unix-addr, -unix-addr-env or -endpoint must be specified")
+	}
+	// Setup socket listeners, using those passed by systemd or a
+	// predecessor, if any.
+	inherited, err := inheritListeners()
+	if err != nil {
+		logger.Fatalf("Cannot inherit listeners: %v", err)
 	}
-	// Setup socket listener
-	lis, err := net.Listen("unix", sock)
+	pred, err := predecessorFromEnv()
 	if err != nil {
-		logger.Fatalf("Failed to listen: %v", err)
+		logger.Fatalf("Cannot inherit listeners: %v", err)
+	}
+	var listeners []net.Listener
+	for _, e := range endpoints {
+		lis, err := inherited.listen(e, logger)
+		if err != nil {
+			logger.Fatalf("Failed to listen on %v: %v", e, err)
+		}
+		listeners = append(listeners, lis)
+	}
+	var adminListener net.Listener
+	if *adminEndpointF != "" {
+		e, err := parseEndpoint(*adminEndpointF)
+		if err != nil {
+			logger.Fatalf("invalid -admin-endpoint: %v", err)
+		}
+		if e.isRemote() && !*allowRemoteEndpointsF {
+			logger.Fatalf("invalid -admin-endpoint %v: the address is reachable from other hosts but the endpoint is neither authenticated nor encrypted, use a loopback address or set -allow-remote-endpoints", e)
+		}
+		if adminListener, err = inherited.listen(e, logger); err != nil {
+			logger.Fatalf("Failed to listen on %v: %v", e, err)
+		}
+	}
+	inherited.closeUnused(logger)
+	if *handoffTimeoutF <= 0 {
+		logger.Fatalf("handoff-timeout must be positive: %v", *handoffTimeoutF)
 	}
 	// Setup server
 	if *requestLimitF < 1 {
 		logger.Fatalf("request-limit requires a positive, integer value instead of %d", *requestLimitF)
 	}
+	if *softRequestLimitF < 0 || *softRequestLimitF >= *requestLimitF {
+		logger.Fatalf("soft-request-limit must be smaller than request-limit (%d) instead of %d", *requestLimitF, *softRequestLimitF)
+	}
 	// TODO(jdef) at some point we should require the node-id flag since it's
 	// a required part of the CSI spec.
 	const defaultMaxStringLen = 128
 	if len(*nodeIDF) > defaultMaxStringLen {
 		logger.Fatalf("node-id cannot be longer than %d bytes: %q", defaultMaxStringLen, *nodeIDF)
 	}
-	scope := tally.NoopScope
-	var statsdHost, statsdPort string
-	if *statsdUDPHostEnvVarF != "" && *statsdUDPPortEnvVarF != "" {
-		statsdHost = os.Getenv(*statsdUDPHostEnvVarF)
-		statsdPort = os.Getenv(*statsdUDPPortEnvVarF)
+	if *nodeVolumeIDsF && *nodeIDF == "" {
+		logger.Fatalf("node-volume-ids requires a node-id")
 	}
-	if (statsdHost == "") != (statsdPort == "") {
-		logger.Fatalf("misconfiguration, either both (host,port) values are required or neither should be specified: "+
-			"-statsd-udp-host-env-var resolved to %q, -statsd-udp-port-env-var resolved to %q", statsdHost, statsdPort)
+	if *topologyF && *nodeIDF == "" {
+		logger.Fatalf("topology requires a node-id")
 	}
-	if statsdHost != "" && statsdPort != "" {
-		statsdServerAddr := net.JoinHostPort(statsdHost, statsdPort)
-		logger.Print("configuring statsd client to report metrics to server ", statsdServerAddr)
-
-		// Set no statsd prefix, tags are already prefixed using 'csilvm'.
-		const (
-			statsdPrefix     = ""
-			maxFlushInterval = time.Second
-		)
-		var reporter tally.StatsReporter
-		switch *statsdFormatF {
-		case "datadog":
-			// The datadog statsd client does not support setting a
-			// custom flush interval. It defaults to 100ms:
-			// https://github.com/DataDog/datadog-go/blob/40bafcb5f6c1d49df36deaf4ab019e44961d5e36/statsd/statsd.go#L150
-			client, err := datadogstatsd.NewBuffered(
-				statsdServerAddr,
-				*statsdMaxUDPSizeF,
-			)
-			if err != nil {
-				logger.Fatal(err)
-			}
-			client.Namespace = statsdPrefix
-			reporter = ddstatsd.NewReporter(client, ddstatsd.Options{
-				SampleRate: 1.0,
-			})
-		case "classic":
-			client, err := statsd.NewBufferedClient(
-				statsdServerAddr,
-				statsdPrefix,
-				maxFlushInterval,
-				*statsdMaxUDPSizeF,
-			)
-			if err != nil {
-				logger.Fatal(err)
-			}
-			reporter = tallystatsd.NewReporter(client, tallystatsd.Options{
-				SampleRate: 1.0,
-			})
-		default:
-			logger.Fatalf("unknown -statsd-format value: %q", *statsdFormatF)
-		}
-		var closer io.Closer
-		scope, closer = tally.NewRootScope(tally.ScopeOptions{
-			Prefix:   "csilvm",
-			Tags:     map[string]string{},
-			Reporter: reporter,
-		}, time.Second)
-		defer closer.Close()
+	statsdAddr := *statsdAddrF
+	if statsdAddr == "" {
+		var statsdHost, statsdPort string
+		if *statsdUDPHostEnvVarF != "" && *statsdUDPPortEnvVarF != "" {
+			statsdHost = os.Getenv(*statsdUDPHostEnvVarF)
+			statsdPort = os.Getenv(*statsdUDPPortEnvVarF)
+		}
+		if (statsdHost == "") != (statsdPort == "") {
+			logger.Fatalf("misconfiguration, either both (host,port) values are required or neither should be specified: "+
+				"-statsd-udp-host-env-var resolved to %q, -statsd-udp-port-env-var resolved to %q", statsdHost, statsdPort)
+		}
+		if statsdHost != "" && statsdPort != "" {
+			statsdAddr = net.JoinHostPort(statsdHost, statsdPort)
+		}
 	}
+	statsdTags, err := parseStatsdTags(statsdTagsF)
+	if err != nil {
+		logger.Fatalf("invalid -statsd-tag: %v", err)
+	}
+	if _, ok := statsdTags["node-id"]; !ok && *nodeIDF != "" {
+		statsdTags["node-id"] = *nodeIDF
+	}
+	if statsdAddr != "" {
+		logger.Print("configuring statsd client to report metrics to server ", statsdAddr)
+	}
+	scope, closer, err := newScope(statsdOptions{
+		addr:          statsdAddr,
+		format:        *statsdFormatF,
+		prefix:        *statsdPrefixF,
+		tags:          statsdTags,
+		flushInterval: *statsdFlushIntervalF,
+		maxUDPSize:    *statsdMaxUDPSizeF,
+	})
+	if err != nil {
+		logger.Fatalf("cannot configure statsd: %v", err)
+	}
+	defer closer.Close()
+	timeouts := csilvm.DefaultTimeouts()
+	for _, t := range timeoutsF {
+		parts := strings.SplitN(t, "=", 2)
+		if len(parts) != 2 {
+			logger.Fatalf("invalid -timeout %q, expected METHOD=DURATION", t)
+		}
+		if !csilvm.IsMethod(parts[0]) {
+			logger.Fatalf("invalid -timeout %q, unknown RPC %q", t, parts[0])
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			logger.Fatalf("invalid -timeout %q: %v", t, err)
+		}
+		timeouts[parts[0]] = d
+	}
+	methodRequestLimits := make(map[string]int)
+	for _, l := range methodRequestLimitsF {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 {
+			logger.Fatalf("invalid -method-request-limit %q, expected METHOD=LIMIT", l)
+		}
+		if !csilvm.IsMethod(parts[0]) {
+			logger.Fatalf("invalid -method-request-limit %q, unknown RPC %q", l, parts[0])
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			logger.Fatalf("invalid -method-request-limit %q, the limit must be a positive integer", l)
+		}
+		methodRequestLimits[parts[0]] = n
+	}
+	if *metricsVolumeSlicesF < 0 {
+		logger.Fatalf("metrics-volume-slices must not be negative: %v", *metricsVolumeSlicesF)
+	}
+	if *volumeEventsF < 0 {
+		logger.Fatalf("volume-events must not be negative: %v", *volumeEventsF)
+	}
+	serializer := csilvm.NewRequestSerializer()
+	var interceptors []grpc.UnaryServerInterceptor
+	if *traceF {
+		// Trace first so that the time spent queued is included.
+		interceptors = append(interceptors, csilvm.TracingInterceptor())
+	}
+	var volumeEvents *csilvm.VolumeEvents
+	if *volumeEventsF > 0 {
+		// Record events before requests are limited so that rejected
+		// requests are recorded too.
+		volumeEvents = csilvm.NewVolumeEvents(*volumeEventsF)
+		interceptors = append(interceptors, volumeEvents.Interceptor())
+	}
+	interceptors = append(interceptors,
+		csilvm.RequestLimitInterceptor(
+			*requestLimitF,
+			csilvm.InterceptorMetrics(scope),
+			csilvm.SoftRequestLimit(*softRequestLimitF),
+			csilvm.MethodRequestLimits(methodRequestLimits)),
+		csilvm.SerializingInterceptor(csilvm.InterceptorMetrics(scope), csilvm.InterceptorSerializer(serializer)),
+		csilvm.TimeoutInterceptor(timeouts),
+		csilvm.LoggingInterceptor(),
+		csilvm.MetricsInterceptor(scope, csilvm.VolumeMetricSlices(*metricsVolumeSlicesF)),
+	)
 	var grpcOpts []grpc.ServerOption
 	grpcOpts = append(grpcOpts,
 		grpc.UnaryInterceptor(
-			csilvm.ChainUnaryServer(
-				csilvm.RequestLimitInterceptor(*requestLimitF),
-				csilvm.SerializingInterceptor(),
-				csilvm.LoggingInterceptor(),
-				csilvm.MetricsInterceptor(scope),
-			),
+			csilvm.SkipDebugServices(csilvm.ChainUnaryServer(interceptors...)),
 		),
 	)
 	grpcServer := grpc.NewServer(grpcOpts...)
@@ -196,23 +391,311 @@ func main() {
 	opts = append(opts,
 		csilvm.DefaultVolumeSize(*defaultVolumeSizeF),
 		csilvm.ProbeModules(probeModulesF),
+		csilvm.ProbeTools(probeToolsF),
+		csilvm.DetectFilesystems(detectFsF),
 		csilvm.Metrics(scope),
+		csilvm.Serializer(serializer),
 	)
+	lvm.SetMetrics(scope)
+	if *loadModulesF {
+		opts = append(opts, csilvm.LoadModules())
+	}
+	if *minKernelVersionF != "" {
+		if err := csilvm.ValidateKernelVersion(*minKernelVersionF); err != nil {
+			logger.Fatalf("invalid -min-kernel-version: %v", err)
+		}
+		opts = append(opts, csilvm.MinKernelVersion(*minKernelVersionF))
+	}
+	if *extentSizeF != 0 {
+		if err := lvm.ValidateExtentSize(*extentSizeF); err != nil {
+			logger.Fatalf("invalid -extent-size %d: %v", *extentSizeF, err)
+		}
+		opts = append(opts, csilvm.ExtentSize(*extentSizeF))
+	}
+	if *cacheDeviceTagF != "" {
+		if err := lvm.ValidateTag(*cacheDeviceTagF); err != nil {
+			logger.Fatalf("invalid -cache-device-tag: %v", err)
+		}
+		opts = append(opts, csilvm.CacheDeviceTag(*cacheDeviceTagF))
+	}
+	if *standbyDevicesF != "" {
+		opts = append(opts, csilvm.StandbyDevices(strings.Split(*standbyDevicesF, ",")))
+	}
+	if *skipAutoActivationF {
+		opts = append(opts, csilvm.SkipAutoActivation())
+	}
+	if *selinuxContextF != "" {
+		opts = append(opts, csilvm.SELinuxContext(*selinuxContextF))
+	}
+	if *createTargetPathF {
+		opts = append(opts, csilvm.CreateTargetPath())
+	}
+	if *atomicPublishDirF != "" {
+		opts = append(opts, csilvm.AtomicPublish(*atomicPublishDirF))
+	}
+	if *volumePrefixF != "" {
+		if err := csilvm.ValidateVolumePrefix(*volumePrefixF); err != nil {
+			logger.Fatalf("invalid -volume-prefix %q: %v", *volumePrefixF, err)
+		}
+		if *removeF {
+			logger.Fatalf("-volume-prefix cannot be combined with -remove-volume-group")
+		}
+		opts = append(opts, csilvm.VolumePrefix(*volumePrefixF))
+	}
+	if *adoptionTagF != "" {
+		if err := lvm.ValidateTag(*adoptionTagF); err != nil {
+			logger.Fatalf("invalid -adoption-tag %q: %v", *adoptionTagF, err)
+		}
+		opts = append(opts, csilvm.AdoptionTag(*adoptionTagF))
+	}
+	if *lvPrefixF != "" {
+		if err := csilvm.ValidateLVPrefix(*lvPrefixF); err != nil {
+			logger.Fatalf("invalid -lv-prefix %q: %v", *lvPrefixF, err)
+		}
+		opts = append(opts, csilvm.LVPrefix(*lvPrefixF))
+	}
+	if *nodeVolumeIDsF {
+		opts = append(opts, csilvm.NodeVolumeIDs())
+	}
+	if *topologyF {
+		opts = append(opts, csilvm.Topology())
+	}
+	if *volumeUsageStatsF {
+		opts = append(opts, csilvm.VolumeUsageStats())
+	}
+	opts = append(opts, csilvm.DeviceWaitTimeout(*deviceWaitTimeoutF))
+	if *maxVolumesF < 0 {
+		logger.Fatalf("invalid -max-volumes %d: must not be negative", *maxVolumesF)
+	}
+	if *maxVolumesF > 0 {
+		opts = append(opts, csilvm.MaxVolumes(*maxVolumesF))
+	}
+	if *provisionerSecretFileF != "" {
+		buf, err := ioutil.ReadFile(*provisionerSecretFileF)
+		if err != nil {
+			logger.Fatalf("cannot read -provisioner-secret-file: %v", err)
+		}
+		secret := strings.TrimRight(string(buf), "\r\n")
+		if secret == "" {
+			logger.Fatalf("-provisioner-secret-file %v is empty", *provisionerSecretFileF)
+		}
+		opts = append(opts, csilvm.ProvisionerSecret(secret))
+	}
+	if *allowDeviceConflictsF {
+		opts = append(opts, csilvm.AllowDeviceConflicts())
+	}
+	if *forceDeviceInitF {
+		opts = append(opts, csilvm.ForceDeviceInit())
+	}
+	if *publishDirF != "" {
+		opts = append(opts, csilvm.PublishDir(*publishDirF))
+	}
 	if *removeF {
 		opts = append(opts, csilvm.RemoveVolumeGroup())
 	}
 	for _, tag := range tagsF {
 		opts = append(opts, csilvm.Tag(tag))
 	}
+	if *metadataBackupDirF != "" {
+		opts = append(opts, csilvm.MetadataBackupDir(*metadataBackupDirF))
+	}
+	if *metadataBackupHookF != "" {
+		opts = append(opts, csilvm.MetadataBackupHook(*metadataBackupHookF))
+	}
+	if *stateDirF != "" {
+		if err := os.MkdirAll(*stateDirF, 0755); err != nil {
+			logger.Fatalf("cannot create state-dir: %v", err)
+		}
+		opts = append(opts, csilvm.StateDir(*stateDirF))
+	}
+	if *operationJournalF {
+		if *stateDirF == "" {
+			logger.Fatalf("operation-journal requires a state-dir")
+		}
+		if *operationJournalReplayTimeoutF <= 0 {
+			logger.Fatalf("operation-journal-replay-timeout must be positive: %v", *operationJournalReplayTimeoutF)
+		}
+		opts = append(opts, csilvm.OperationJournal())
+	}
+	if *ioConcurrencyLimitF < 0 {
+		logger.Fatalf("io-concurrency-limit cannot be negative: %d", *ioConcurrencyLimitF)
+	}
+	if *ioConcurrencyLimitF > 0 {
+		if err := os.MkdirAll(*ioLockDirF, 0755); err != nil {
+			logger.Fatalf("cannot create io-lock-dir: %v", err)
+		}
+		opts = append(opts, csilvm.IOConcurrencyLimit(*ioLockDirF, *ioConcurrencyLimitF))
+	}
+	if len(wipeMethodsF) > 0 {
+		var methods []wipe.Method
+		for _, name := range wipeMethodsF {
+			m, err := wipe.LookupMethod(name)
+			if err != nil {
+				logger.Fatalf("invalid -wipe-method: %v", err)
+			}
+			methods = append(methods, m)
+		}
+		opts = append(opts, csilvm.WipeMethods(methods...))
+	}
+	if err := wipe.ValidateBlockSize(*wipeBlockSizeF); err != nil {
+		logger.Fatalf("invalid -wipe-block-size: %v", err)
+	}
+	opts = append(opts, csilvm.WipeBlockSize(*wipeBlockSizeF))
+	if *wipeRateLimitF > 0 {
+		opts = append(opts, csilvm.WipeRateLimit(*wipeRateLimitF*1000000))
+	}
+	if *skipWipeIfDeviceMissingF {
+		opts = append(opts, csilvm.SkipWipeIfDeviceMissing())
+	}
+	if *unmountRetriesF < 0 {
+		logger.Fatalf("unmount-retries must not be negative: %v", *unmountRetriesF)
+	}
+	opts = append(opts, csilvm.UnmountRetries(*unmountRetriesF))
+	if *lazyUnmountF {
+		opts = append(opts, csilvm.LazyUnmount())
+	}
+	if *trashRetentionF < 0 {
+		logger.Fatalf("trash-retention must not be negative: %v", *trashRetentionF)
+	}
+	if *trashRetentionF > 0 {
+		if *trashReapIntervalF <= 0 {
+			logger.Fatalf("trash-reap-interval must be positive: %v", *trashReapIntervalF)
+		}
+		opts = append(opts, csilvm.TrashRetention(*trashRetentionF))
+	}
+	if *scrubIntervalF < 0 {
+		logger.Fatalf("scrub-interval must not be negative: %v", *scrubIntervalF)
+	}
+	var scrubWindows []csilvm.ScrubWindow
+	for _, w := range scrubWindowsF {
+		window, err := csilvm.ParseScrubWindow(w)
+		if err != nil {
+			logger.Fatalf("invalid -scrub-window: %v", err)
+		}
+		scrubWindows = append(scrubWindows, window)
+	}
+	for _, o := range readonlyMountOptionsF {
+		parts := strings.SplitN(o, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			logger.Fatalf("invalid -readonly-mount-options %q, expected FSTYPE=OPTIONS", o)
+		}
+		var mountOptions []string
+		if parts[1] != "" {
+			mountOptions = strings.Split(parts[1], ",")
+		}
+		opts = append(opts, csilvm.ReadonlyMountOptions(parts[0], mountOptions))
+	}
+	for _, key := range metadataParamsF {
+		opts = append(opts, csilvm.MetadataParameter(key))
+	}
 	s := csilvm.NewServer(*vgnameF, strings.Split(*pvnamesF, ","), *defaultFsF, opts...)
+	if *configFileF != "" {
+		if err := s.ReloadConfig(*configFileF); err != nil {
+			logger.Fatalf("cannot load config-file: %v", err)
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				logger.Printf("Received SIGHUP, reloading %v", *configFileF)
+				if err := s.ReloadConfig(*configFileF); err != nil {
+					logger.Printf("Cannot reload config-file, keeping the previous config: %v", err)
+				}
+			}
+		}()
+	}
+	// A predecessor serves until the volume group is set up.
+	if err := pred.wait(logger); err != nil {
+		logger.Fatalf("Cannot wait for predecessor: %v", err)
+	}
 	if err := s.Setup(); err != nil {
 		logger.Fatalf("error initializing csilvm plugin: err=%v", err)
 	}
+	if err := pred.notifyReady(); err != nil {
+		logger.Fatalf("Cannot notify predecessor: %v", err)
+	}
 	defer s.ReportUptime()()
+	if *operationJournalF {
+		// Replay before serving so that the requests that change the
+		// volume group wait for it.
+		defer s.ReplayJournal(*operationJournalReplayTimeoutF)()
+	}
+	if *trashRetentionF > 0 && !s.RemovingVolumeGroup() {
+		defer s.ReapTrash(*trashReapIntervalF)()
+	}
+	if *scrubIntervalF > 0 && !s.RemovingVolumeGroup() {
+		defer s.ScrubRAID(*scrubIntervalF, scrubWindows)()
+	}
 	csi.RegisterIdentityServer(grpcServer, csilvm.IdentityServerValidator(s))
 	csi.RegisterControllerServer(grpcServer, csilvm.ControllerServerValidator(s, s.RemovingVolumeGroup(), s.SupportedFilesystems()))
-	csi.RegisterNodeServer(grpcServer, csilvm.NodeServerValidator(s, s.RemovingVolumeGroup(), s.SupportedFilesystems()))
-	if err := grpcServer.Serve(lis); err != nil {
-		logger.Fatalf("Stopped serving, err=%v", err)
+	csi.RegisterNodeServer(grpcServer, csilvm.NodeServerValidator(s, s.RemovingVolumeGroup(), s.SupportedFilesystems(), s.CreatesTargetPath()))
+	csilvm.RegisterModifyServer(grpcServer, csilvm.ModifyServerValidator(s, s.RemovingVolumeGroup()))
+	if *debugGRPCF {
+		logger.Printf("Registering the gRPC reflection and channelz services")
+		csilvm.RegisterDebugServices(grpcServer)
+	}
+	// The same services are served on every endpoint. If serving on
+	// any of them fails we exit.
+	errs := make(chan error, len(listeners)+1)
+	for _, lis := range listeners {
+		logger.Printf("Serving on %v://%v", lis.Addr().Network(), lis.Addr())
+		go func(lis net.Listener) {
+			errs <- grpcServer.Serve(lis)
+		}(lis)
+	}
+	if adminListener != nil {
+		logger.Printf("Serving the admin API on %v://%v", adminListener.Addr().Network(), adminListener.Addr())
+		mux := http.NewServeMux()
+		mux.Handle("/", s.InventoryHandler())
+		if *traceF {
+			mux.Handle("/debug/", csilvm.TraceHandler())
+		}
+		if volumeEvents != nil {
+			mux.Handle("/volume-events", volumeEvents.Handler())
+		}
+		go func() {
+			errs <- http.Serve(adminListener, mux)
+		}()
+	}
+	// On SIGUSR2 a successor is started that takes over the listeners
+	// once it is set up and the in-flight requests are finished.
+	var handingOff int32
+	handedOff := make(chan struct{})
+	handoffSignals := make(chan os.Signal, 1)
+	notifyHandoff(handoffSignals)
+	go func() {
+		allListeners := listeners
+		if adminListener != nil {
+			allListeners = append(allListeners[:len(allListeners):len(allListeners)], adminListener)
+		}
+		stop := func() {
+			atomic.StoreInt32(&handingOff, 1)
+			grpcServer.GracefulStop()
+		}
+		for sig := range handoffSignals {
+			logger.Printf("Received %v, handing off to a successor", sig)
+			if err := handOff(logger, allListeners, serializer, stop, grpcServer.Stop, *handoffTimeoutF); err != nil {
+				logger.Printf("Cannot hand off, continuing to serve: %v", err)
+				continue
+			}
+			close(handedOff)
+			return
+		}
+	}()
+	for {
+		select {
+		case err := <-errs:
+			if atomic.LoadInt32(&handingOff) == 1 {
+				// The listeners are closed by the handoff.
+				continue
+			}
+			if err != nil {
+				logger.Fatalf("Stopped serving, err=%v", err)
+			}
+			return
+		case <-handedOff:
+			logger.Printf("Handed off to the successor, exiting")
+			return
+		}
 	}
 }
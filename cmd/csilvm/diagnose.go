@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/mesosphere/csilvm/pkg/csilvm"
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+// diagnose implements the `csilvm diagnose` subcommand. It writes a JSON
+// support bundle to stdout and returns the process exit code.
+func diagnose(args []string) int {
+	fs := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	vgnameF := fs.String("volume-group", "", "The name of the volume group to inspect")
+	socketFileF := fs.String("unix-addr", "", "The path to the unix socket file of the running plugin to query")
+	socketFileEnvF := fs.String("unix-addr-env", "", "An optional environment variable from which to read the unix-addr")
+	lockFilePathF := fs.String("lockfile", defaultLockfilePathOrEnv(), "The path to the lock file used to prevent concurrent lvm invocation by multiple csilvm instances")
+	timeoutF := fs.Duration("timeout", 30*time.Second, "The timeout for querying the running plugin")
+	fs.Parse(args)
+
+	// Logs go to stderr so that stdout only contains the bundle.
+	logger := log.New(os.Stderr, "[diagnose]", log.LstdFlags|log.Lshortfile)
+	csilvm.SetLogger(logger)
+	lvm.SetLogger(logger)
+
+	if *socketFileF != "" && *socketFileEnvF != "" {
+		logger.Fatalf("cannot specify -unix-addr and -unix-addr-env")
+	}
+	sock := *socketFileF
+	if *socketFileEnvF != "" {
+		sock = os.Getenv(*socketFileEnvF)
+	}
+	sock = strings.TrimPrefix(sock, "unix://")
+	if *vgnameF == "" && sock == "" {
+		logger.Fatalf("at least one of -volume-group or -unix-addr must be specified")
+	}
+	if *vgnameF != "" && *lockFilePathF != "" {
+		lvm.SetLockFilePath(*lockFilePathF)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutF)
+	defer cancel()
+	var client *csilvm.Client
+	if sock != "" {
+		dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+		}
+		conn, err := grpc.DialContext(ctx, sock, grpc.WithContextDialer(dialer), grpc.WithInsecure())
+		if err != nil {
+			logger.Fatalf("cannot connect to %v: err=%v", sock, err)
+		}
+		defer conn.Close()
+		client = csilvm.NewClient(conn)
+	}
+
+	bundle := csilvm.Diagnose(ctx, *vgnameF, client)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(bundle); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot write support bundle: err=%v\n", err)
+		return 1
+	}
+	if len(bundle.Errors) != 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// endpoint is an address on which the plugin serves its gRPC services.
+type endpoint struct {
+	network string
+	address string
+}
+
+func (e endpoint) String() string {
+	return e.network + "://" + e.address
+}
+
+// parseEndpoint parses an -endpoint value. The following forms are
+// accepted:
+//
+//	unix:///run/csilvm.sock  a unix socket file
+//	unix://@csilvm           a socket in the abstract unix namespace
+//	tcp://127.0.0.1:5000     a TCP address
+//	tcp://:5000              a TCP address on the loopback interface
+//	/run/csilvm.sock         a unix socket file (same as -unix-addr)
+//
+// A TCP address without a host binds 127.0.0.1 rather than all interfaces
+// as the endpoints are neither authenticated nor encrypted.
+func parseEndpoint(s string) (endpoint, error) {
+	var e endpoint
+	switch {
+	case strings.HasPrefix(s, "unix://"):
+		e = endpoint{"unix", strings.TrimPrefix(s, "unix://")}
+	case strings.HasPrefix(s, "tcp://"):
+		e = endpoint{"tcp", strings.TrimPrefix(s, "tcp://")}
+		host, port, err := net.SplitHostPort(e.address)
+		if err != nil {
+			return endpoint{}, fmt.Errorf("invalid endpoint %q: %v", s, err)
+		}
+		if host == "" {
+			e.address = net.JoinHostPort("127.0.0.1", port)
+		}
+	case strings.Contains(s, "://"):
+		return endpoint{}, fmt.Errorf("invalid endpoint %q: unsupported scheme", s)
+	default:
+		e = endpoint{"unix", s}
+	}
+	if e.address == "" || e.address == "@" {
+		return endpoint{}, fmt.Errorf("invalid endpoint %q: missing address", s)
+	}
+	return e, nil
+}
+
+// isRemote returns whether the endpoint is a TCP address that can be
+// reached from other hosts, i.e., one whose host is not a loopback address.
+func (e endpoint) isRemote() bool {
+	if e.network != "tcp" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(e.address)
+	if err != nil {
+		return true
+	}
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !ip.IsLoopback()
+}
+
+// isAbstract returns whether the endpoint is in the abstract unix socket
+// namespace. Such sockets have no file and disappear with the process.
+func (e endpoint) isAbstract() bool {
+	return e.network == "unix" && strings.HasPrefix(e.address, "@")
+}
+
+// listen returns a listener for the endpoint.
+func (e endpoint) listen(logger *log.Logger) (net.Listener, error) {
+	if e.network == "unix" && !e.isAbstract() {
+		// Unlink the domain socket in case it is left lying around
+		// from a previous run. err return is not really interesting
+		// because it is normal for this to fail if the process is
+		// starting for the first time.
+		logger.Printf("Unlinking socket file in case it still exists: %q", e.address)
+		if err := syscall.Unlink(e.address); err != nil {
+			logger.Printf("Failed to unlink socket file: %v", err)
+		}
+	}
+	return net.Listen(e.network, e.address)
+}
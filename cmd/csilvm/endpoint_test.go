@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		value string
+		exp   endpoint
+	}{
+		{"unix:///run/csilvm.sock", endpoint{"unix", "/run/csilvm.sock"}},
+		{"unix://@csilvm", endpoint{"unix", "@csilvm"}},
+		{"tcp://127.0.0.1:5000", endpoint{"tcp", "127.0.0.1:5000"}},
+		{"tcp://:5000", endpoint{"tcp", "127.0.0.1:5000"}},
+		{"tcp://[::]:5000", endpoint{"tcp", "[::]:5000"}},
+		{"/run/csilvm.sock", endpoint{"unix", "/run/csilvm.sock"}},
+	}
+	for _, tt := range tests {
+		e, err := parseEndpoint(tt.value)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.value, err)
+		}
+		if e != tt.exp {
+			t.Fatalf("%q: expected %v but got %v", tt.value, tt.exp, e)
+		}
+	}
+}
+
+func TestParseEndpointInvalid(t *testing.T) {
+	for _, value := range []string{"", "unix://", "unix://@", "tcp://127.0.0.1", "http://localhost:80"} {
+		if _, err := parseEndpoint(value); err == nil {
+			t.Fatalf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestEndpointIsRemote(t *testing.T) {
+	tests := []struct {
+		value string
+		exp   bool
+	}{
+		{"unix:///run/csilvm.sock", false},
+		{"tcp://127.0.0.1:5000", false},
+		{"tcp://127.0.0.2:5000", false},
+		{"tcp://[::1]:5000", false},
+		{"tcp://localhost:5000", false},
+		{"tcp://:5000", false},
+		{"tcp://0.0.0.0:5000", true},
+		{"tcp://[::]:5000", true},
+		{"tcp://10.0.0.1:5000", true},
+		{"tcp://node-1.example.com:5000", true},
+	}
+	for _, tt := range tests {
+		e, err := parseEndpoint(tt.value)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.value, err)
+		}
+		if got := e.isRemote(); got != tt.exp {
+			t.Fatalf("%q: expected isRemote() %v but got %v", tt.value, tt.exp, got)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// flagsFileFlag is the name of the flag that names the flags file. It can
+// only be given on the command line or in the environment.
+const flagsFileFlag = "flags-file"
+
+// envPrefix is the prefix of the environment variables that set flags,
+// e.g., CSILVM_VOLUME_GROUP sets -volume-group.
+const envPrefix = "CSILVM_"
+
+// flagEnvVar returns the name of the environment variable that sets the
+// flag with the given name.
+func flagEnvVar(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// isRepeatable returns whether the flag can be given multiple times.
+func isRepeatable(f *flag.Flag) bool {
+	_, ok := f.Value.(*stringsFlag)
+	return ok
+}
+
+// applyFlagSources sets the flags of fs that were not given on the command
+// line, first from the environment and then from the flags file, if any.
+// The command line thus takes precedence over the environment, which takes
+// precedence over the file. The value of a repeatable flag in the
+// environment is split at whitespace. getenv is usually os.Getenv.
+func applyFlagSources(fs *flag.FlagSet, getenv func(string) string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		name := flagEnvVar(f.Name)
+		value := getenv(name)
+		if value == "" {
+			return
+		}
+		values := []string{value}
+		if isRepeatable(f) {
+			values = strings.Fields(value)
+		}
+		for _, v := range values {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("%v: invalid value %q for -%v: %v", name, v, f.Name, serr)
+				return
+			}
+		}
+		set[f.Name] = true
+	})
+	if err != nil {
+		return err
+	}
+	path := fs.Lookup(flagsFileFlag).Value.String()
+	if path == "" {
+		return nil
+	}
+	return applyFlagsFile(fs, path, set)
+}
+
+// applyFlagsFile sets the flags of fs from the JSON object in the file at
+// path, skipping those in set. The object maps flag names to strings,
+// numbers or booleans, or, for flags that can be given multiple times, to
+// lists of them, e.g.,
+//
+//	{
+//	  "volume-group": "data",
+//	  "devices": "/dev/sdb,/dev/sdc",
+//	  "default-volume-size": 1073741824,
+//	  "tag": ["team.storage", "tier.fast"]
+//	}
+//
+// Errors are prefixed with the path, line and column of the offending flag.
+func applyFlagsFile(fs *flag.FlagSet, path string, set map[string]bool) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	errorf := func(offset int64, format string, args ...interface{}) error {
+		line, col := position(data, offset)
+		return fmt.Errorf("%v:%d:%d: %v", path, line, col, fmt.Sprintf(format, args...))
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	syntaxError := func(err error) error {
+		if serr, ok := err.(*json.SyntaxError); ok {
+			// The offset is that of the byte after the invalid one.
+			return errorf(serr.Offset-1, "%v", serr)
+		}
+		return errorf(dec.InputOffset(), "%v", err)
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return syntaxError(err)
+	}
+	if tok != json.Delim('{') {
+		return errorf(dec.InputOffset(), "expected a JSON object of flags")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return syntaxError(err)
+		}
+		name := tok.(string)
+		offset := dec.InputOffset()
+		var raw interface{}
+		if err := dec.Decode(&raw); err != nil {
+			return syntaxError(err)
+		}
+		f := fs.Lookup(name)
+		if f == nil || name == flagsFileFlag {
+			return errorf(offset, "unknown flag %q", name)
+		}
+		values, ok := flagValues(raw)
+		if !ok {
+			return errorf(offset, "invalid value for -%v, expected a string, number or boolean", name)
+		}
+		if len(values) > 1 && !isRepeatable(f) {
+			return errorf(offset, "-%v cannot be given multiple times", name)
+		}
+		if set[name] {
+			continue
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return errorf(offset, "invalid value %q for -%v: %v", v, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flagValues converts a JSON value to the values of a flag. It returns
+// false if raw is not a scalar or a list of scalars.
+func flagValues(raw interface{}) ([]string, bool) {
+	switch v := raw.(type) {
+	case string:
+		return []string{v}, true
+	case json.Number:
+		return []string{v.String()}, true
+	case bool:
+		return []string{fmt.Sprint(v)}, true
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			if _, ok := e.([]interface{}); ok {
+				return nil, false
+			}
+			ev, ok := flagValues(e)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, ev...)
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// position returns the 1-based line and column of offset in data.
+func position(data []byte, offset int64) (line, col int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testFlags struct {
+	fs        *flag.FlagSet
+	vgname    *string
+	size      *uint64
+	remove    *bool
+	tags      stringsFlag
+	flagsFile *string
+}
+
+func newTestFlags() *testFlags {
+	f := &testFlags{fs: flag.NewFlagSet("csilvm", flag.ContinueOnError)}
+	f.flagsFile = f.fs.String(flagsFileFlag, "", "")
+	f.vgname = f.fs.String("volume-group", "", "")
+	f.size = f.fs.Uint64("default-volume-size", 10, "")
+	f.remove = f.fs.Bool("remove-volume-group", false, "")
+	f.fs.Var(&f.tags, "tag", "")
+	return f
+}
+
+func writeFlagsFile(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "csilvm-flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestApplyFlagSources(t *testing.T) {
+	path := writeFlagsFile(t, `{
+  "volume-group": "from-file",
+  "default-volume-size": 1024,
+  "remove-volume-group": true,
+  "tag": ["c"]
+}`)
+	defer os.Remove(path)
+	env := map[string]string{
+		"CSILVM_FLAGS_FILE": path,
+		"CSILVM_TAG":        "a b",
+	}
+	f := newTestFlags()
+	if err := f.fs.Parse([]string{"-volume-group=from-args"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyFlagSources(f.fs, func(name string) string { return env[name] }); err != nil {
+		t.Fatal(err)
+	}
+	if *f.vgname != "from-args" {
+		t.Fatalf("Expected the command line to take precedence but got %q", *f.vgname)
+	}
+	if exp := []string{"a", "b"}; !reflect.DeepEqual([]string(f.tags), exp) {
+		t.Fatalf("Expected the environment to take precedence with tags %v but got %v", exp, f.tags)
+	}
+	if *f.size != 1024 || !*f.remove {
+		t.Fatalf("Expected size 1024 and remove from the file but got %d and %v", *f.size, *f.remove)
+	}
+}
+
+func TestApplyFlagSourcesErrors(t *testing.T) {
+	tests := []struct {
+		contents string
+		exp      string
+	}{
+		{"{\n  \"volume-group\": \"vg\",\n  \"no-such-flag\": 1\n}", ":3:17: unknown flag \"no-such-flag\""},
+		{"{\n  \"default-volume-size\": \"big\"\n}", ":2:24: invalid value \"big\" for -default-volume-size"},
+		{"{\n  \"volume-group\": [\"a\", \"b\"]\n}", ":2:17: -volume-group cannot be given multiple times"},
+		{"{\n  \"tag\": {\"a\": 1}\n}", ":2:8: invalid value for -tag"},
+		{"{\n  \"volume-group\": \"vg\"\n  \"tag\": \"a\"\n}", ":3:3: invalid character"},
+		{"[]", ":1:2: expected a JSON object of flags"},
+	}
+	for _, tt := range tests {
+		path := writeFlagsFile(t, tt.contents)
+		defer os.Remove(path)
+		f := newTestFlags()
+		*f.flagsFile = path
+		err := applyFlagSources(f.fs, func(string) string { return "" })
+		if err == nil || !strings.Contains(err.Error(), path+tt.exp) {
+			t.Fatalf("Expected an error containing %q but got %v", path+tt.exp, err)
+		}
+	}
+	f := newTestFlags()
+	env := map[string]string{"CSILVM_REMOVE_VOLUME_GROUP": "maybe"}
+	err := applyFlagSources(f.fs, func(name string) string { return env[name] })
+	if err == nil || !strings.HasPrefix(err.Error(), "CSILVM_REMOVE_VOLUME_GROUP: ") {
+		t.Fatalf("Expected an error naming the environment variable but got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Environment variables with which listening sockets are passed to the
+// plugin. LISTEN_PID and LISTEN_FDS follow the systemd socket activation
+// protocol. A predecessor handing off its sockets sets LISTEN_FDS,
+// CSILVM_HANDOFF_FD and CSILVM_READY_FD instead of LISTEN_PID, as it cannot
+// know the pid of its successor in advance.
+const (
+	listenPIDEnv  = "LISTEN_PID"
+	listenFDsEnv  = "LISTEN_FDS"
+	listenFDNames = "LISTEN_FDNAMES"
+	handoffFDEnv  = "CSILVM_HANDOFF_FD"
+	readyFDEnv    = "CSILVM_READY_FD"
+)
+
+// listenFDsStart is the first file descriptor passed by LISTEN_FDS.
+const listenFDsStart = 3
+
+// inheritedListeners are the listening sockets passed to the plugin.
+type inheritedListeners struct {
+	listeners []net.Listener
+}
+
+// take removes and returns the inherited listener for the endpoint, or nil
+// if there is none.
+func (l *inheritedListeners) take(e endpoint) net.Listener {
+	for i, lis := range l.listeners {
+		if e.matches(lis.Addr()) {
+			l.listeners = append(l.listeners[:i], l.listeners[i+1:]...)
+			return lis
+		}
+	}
+	return nil
+}
+
+// closeUnused closes the inherited listeners that no endpoint took.
+func (l *inheritedListeners) closeUnused(logger *log.Logger) {
+	for _, lis := range l.listeners {
+		logger.Printf("Closing inherited listener on %v://%v that matches no endpoint", lis.Addr().Network(), lis.Addr())
+		lis.Close()
+	}
+	l.listeners = nil
+}
+
+// listen returns the inherited listener for the endpoint, if any, and
+// otherwise a new listener.
+func (l *inheritedListeners) listen(e endpoint, logger *log.Logger) (net.Listener, error) {
+	if lis := l.take(e); lis != nil {
+		logger.Printf("Using inherited listener on %v", e)
+		return lis, nil
+	}
+	return e.listen(logger)
+}
+
+// matches returns whether addr is the address of the endpoint.
+func (e endpoint) matches(addr net.Addr) bool {
+	if addr.Network() != e.network {
+		return false
+	}
+	if e.network != "tcp" {
+		return addr.String() == e.address
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	host, port, err := net.SplitHostPort(e.address)
+	if err != nil || port != strconv.Itoa(tcpAddr.Port) {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if host == "" || ip != nil && ip.IsUnspecified() {
+		return tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified()
+	}
+	return ip != nil && ip.Equal(tcpAddr.IP)
+}
+
+// errHandoffAborted is returned by predecessor.wait if the predecessor
+// continues to serve, e.g., because its in-flight requests did not finish.
+var errHandoffAborted = errors.New("the predecessor aborted the handoff")
+
+// predecessor is the plugin process that handed off its sockets to this
+// one. It serves until the plugin is set up, see notifyReady.
+type predecessor struct {
+	// handoff is readable once the predecessor stopped changing the
+	// volume group and ready tells the predecessor that the plugin is
+	// set up.
+	handoff, ready *os.File
+}
+
+// wait blocks until the predecessor, if any, has finished its in-flight
+// request and makes the following ones wait so that the plugin can set up
+// the volume group.
+func (p *predecessor) wait(logger *log.Logger) error {
+	if p == nil {
+		return nil
+	}
+	defer p.handoff.Close()
+	logger.Printf("Waiting for the predecessor to finish its in-flight request")
+	// The predecessor writes a byte once it is done and closes the pipe
+	// without writing one if it aborts the handoff.
+	var b [1]byte
+	if _, err := io.ReadFull(p.handoff, b[:]); err == io.EOF {
+		return errHandoffAborted
+	} else if err != nil {
+		return err
+	}
+	logger.Printf("The predecessor has stopped changing the volume group, taking over")
+	return nil
+}
+
+// notifyReady tells the predecessor, if any, that the plugin is set up so
+// that it stops serving and exits.
+func (p *predecessor) notifyReady() error {
+	if p == nil {
+		return nil
+	}
+	defer p.ready.Close()
+	_, err := p.ready.Write([]byte{1})
+	return err
+}
+
+// fileListener is implemented by the listeners that can be passed to
+// another process.
+type fileListener interface {
+	File() (*os.File, error)
+}
@@ -0,0 +1,34 @@
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/csilvm"
+)
+
+// notifyHandoff does nothing as handing off is not supported on this
+// platform.
+func notifyHandoff(c chan<- os.Signal) {}
+
+// inheritListeners returns no listeners as neither socket activation nor
+// handing off is supported on this platform.
+func inheritListeners() (*inheritedListeners, error) {
+	return &inheritedListeners{}, nil
+}
+
+// predecessorFromEnv returns nil as handing off is not supported on this
+// platform.
+func predecessorFromEnv() (*predecessor, error) {
+	return nil, nil
+}
+
+// handOff fails as it is not supported on this platform.
+func handOff(logger *log.Logger, listeners []net.Listener, serializer *csilvm.RequestSerializer, stop, forceStop func(), timeout time.Duration) error {
+	return errors.New("handing off is not supported on this platform")
+}
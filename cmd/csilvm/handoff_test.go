@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEndpointMatches(t *testing.T) {
+	tests := []struct {
+		e     endpoint
+		addr  net.Addr
+		match bool
+	}{
+		{endpoint{"unix", "/run/csilvm.sock"}, &net.UnixAddr{Name: "/run/csilvm.sock", Net: "unix"}, true},
+		{endpoint{"unix", "@csilvm"}, &net.UnixAddr{Name: "@csilvm", Net: "unix"}, true},
+		{endpoint{"unix", "/run/csilvm.sock"}, &net.UnixAddr{Name: "/run/other.sock", Net: "unix"}, false},
+		{endpoint{"tcp", "127.0.0.1:5000"}, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}, true},
+		{endpoint{"tcp", ":5000"}, &net.TCPAddr{IP: net.IPv6unspecified, Port: 5000}, true},
+		{endpoint{"tcp", "0.0.0.0:5000"}, &net.TCPAddr{Port: 5000}, true},
+		{endpoint{"tcp", "127.0.0.1:5000"}, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5001}, false},
+		{endpoint{"tcp", ":5000"}, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}, false},
+		{endpoint{"tcp", "127.0.0.1:5000"}, &net.UnixAddr{Name: "127.0.0.1:5000", Net: "unix"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.e.matches(tt.addr); got != tt.match {
+			t.Fatalf("%v matches %v: expected %v but got %v", tt.e, tt.addr, tt.match, got)
+		}
+	}
+}
+
+func TestInheritedListenersListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-handoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "csilvm.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherited := &inheritedListeners{listeners: []net.Listener{other, lis}}
+	logger := log.New(ioutil.Discard, "", 0)
+	got, err := inherited.listen(endpoint{"unix", path}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != lis {
+		t.Fatalf("Expected the inherited listener to be used")
+	}
+	inherited.closeUnused(logger)
+	if _, err := other.Accept(); err == nil {
+		t.Fatalf("Expected the unused listener to be closed")
+	}
+	lis.Close()
+}
+
+func TestPredecessorWait(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	var none *predecessor
+	if err := none.wait(logger); err != nil {
+		t.Fatal(err)
+	}
+	if err := none.notifyReady(); err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &predecessor{handoff: r}
+	done := make(chan error, 1)
+	go func() {
+		done <- p.wait(logger)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected to wait for the predecessor but got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.Write([]byte{1})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	// The predecessor aborts the handoff by closing the pipe.
+	r, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	p = &predecessor{handoff: r}
+	if err := p.wait(logger); err != errHandoffAborted {
+		t.Fatalf("Expected %v but got %v", errHandoffAborted, err)
+	}
+}
+
+func TestPredecessorNotifyReady(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	p := &predecessor{ready: w}
+	if err := p.notifyReady(); err != nil {
+		t.Fatal(err)
+	}
+	// The successor writes a byte and closes the pipe.
+	if buf, err := ioutil.ReadAll(r); err != nil || len(buf) != 1 {
+		t.Fatalf("Expected a byte but got %v, %v", buf, err)
+	}
+}
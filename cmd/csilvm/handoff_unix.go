@@ -0,0 +1,243 @@
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/csilvm"
+)
+
+// handoffSignal makes the plugin hand off its sockets to a successor.
+const handoffSignal = syscall.SIGUSR2
+
+// notifyHandoff relays the signals that make the plugin hand off its
+// sockets to c.
+func notifyHandoff(c chan<- os.Signal) {
+	signal.Notify(c, handoffSignal)
+}
+
+// inheritListeners returns the listening sockets passed by systemd socket
+// activation or by a predecessor. The environment variables are cleared so
+// that they are not passed on to child processes.
+func inheritListeners() (*inheritedListeners, error) {
+	defer func() {
+		os.Unsetenv(listenPIDEnv)
+		os.Unsetenv(listenFDsEnv)
+		os.Unsetenv(listenFDNames)
+	}()
+	inherited := &inheritedListeners{}
+	fds := os.Getenv(listenFDsEnv)
+	if fds == "" {
+		return inherited, nil
+	}
+	if os.Getenv(handoffFDEnv) == "" && os.Getenv(listenPIDEnv) != strconv.Itoa(os.Getpid()) {
+		// The sockets were meant for another process.
+		return inherited, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid %v=%q", listenFDsEnv, fds)
+	}
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "listener-"+strconv.Itoa(fd))
+		lis, err := net.FileListener(f)
+		// FileListener dups the descriptor.
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot use inherited file descriptor %d: %v", fd, err)
+		}
+		inherited.listeners = append(inherited.listeners, lis)
+	}
+	return inherited, nil
+}
+
+// predecessorFromEnv returns the predecessor that started the plugin, or
+// nil if there is none. The environment variables are cleared so that they
+// are not passed on to child processes.
+func predecessorFromEnv() (*predecessor, error) {
+	handoff, ready := os.Getenv(handoffFDEnv), os.Getenv(readyFDEnv)
+	os.Unsetenv(handoffFDEnv)
+	os.Unsetenv(readyFDEnv)
+	if handoff == "" {
+		return nil, nil
+	}
+	p := &predecessor{}
+	for _, v := range []struct {
+		env, value string
+		f          **os.File
+	}{
+		{handoffFDEnv, handoff, &p.handoff},
+		{readyFDEnv, ready, &p.ready},
+	} {
+		fd, err := strconv.Atoi(v.value)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid %v=%q", v.env, v.value)
+		}
+		syscall.CloseOnExec(fd)
+		*v.f = os.NewFile(uintptr(fd), strings.ToLower(v.env))
+	}
+	return p, nil
+}
+
+// successor is a plugin process started by startSuccessor.
+type successor struct {
+	// handoff lets the successor set up once written to and makes it
+	// exit if it is closed first. The successor closes ready once it is
+	// set up, after writing to it, or exits.
+	handoff, ready *os.File
+}
+
+// startSuccessor starts a new plugin process with the same arguments and
+// passes it the listeners. The successor waits for proceed before it sets
+// up the volume group and exits if the successor is closed first.
+func startSuccessor(listeners []net.Listener) (*successor, error) {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, lis := range listeners {
+		fl, ok := lis.(fileListener)
+		if !ok {
+			return nil, fmt.Errorf("cannot pass listener on %v to another process", lis.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	handoffR, handoffW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer handoffR.Close()
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		handoffW.Close()
+		return nil, err
+	}
+	defer readyW.Close()
+	succ := &successor{handoff: handoffW, ready: readyR}
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		succ.close()
+		return nil, err
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, listenPIDEnv+"=") && !strings.HasPrefix(kv, listenFDNames+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		fmt.Sprintf("%v=%d", listenFDsEnv, len(files)),
+		fmt.Sprintf("%v=%d", handoffFDEnv, listenFDsStart+len(files)),
+		fmt.Sprintf("%v=%d", readyFDEnv, listenFDsStart+len(files)+1))
+	c := exec.Command(path, os.Args[1:]...)
+	c.Env = env
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.ExtraFiles = append(files, handoffR, readyW)
+	if err := c.Start(); err != nil {
+		succ.close()
+		return nil, err
+	}
+	c.Process.Release()
+	return succ, nil
+}
+
+// proceed lets the successor set up the volume group.
+func (s *successor) proceed() error {
+	defer s.handoff.Close()
+	_, err := s.handoff.Write([]byte{1})
+	return err
+}
+
+// waitReady blocks until the successor is set up. It fails if the
+// successor exits first, e.g., because its Setup failed.
+func (s *successor) waitReady() error {
+	var b [1]byte
+	if _, err := io.ReadFull(s.ready, b[:]); err == io.EOF {
+		return fmt.Errorf("the successor exited before it was set up")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// close makes the successor exit unless it proceeded.
+func (s *successor) close() {
+	s.handoff.Close()
+	s.ready.Close()
+}
+
+// handOff starts a successor and hands off to it. The requests wait while
+// the successor sets up the volume group. If the successor fails to set up,
+// they continue and handOff fails. Otherwise they fail with
+// csilvm.ErrHandedOff so that the CO retries them against the successor,
+// and stop stops accepting connections and waits for the requests to
+// return, which are canceled with forceStop after timeout. The in-flight
+// request must finish within timeout too, otherwise the successor exits and
+// handOff fails.
+func handOff(logger *log.Logger, listeners []net.Listener, serializer *csilvm.RequestSerializer, stop, forceStop func(), timeout time.Duration) error {
+	succ, err := startSuccessor(listeners)
+	if err != nil {
+		return err
+	}
+	defer succ.close()
+	logger.Printf("Started successor, finishing the in-flight request")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	resume, err := serializer.Drain(ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("the in-flight request did not finish within %v: %v", timeout, err)
+	}
+	if err := succ.proceed(); err != nil {
+		resume(false)
+		return err
+	}
+	logger.Printf("Waiting for the successor to set up")
+	if err := succ.waitReady(); err != nil {
+		resume(false)
+		return err
+	}
+	resume(true)
+	logger.Printf("The successor is set up, finishing in-flight requests")
+	for _, lis := range listeners {
+		if ul, ok := lis.(*net.UnixListener); ok {
+			// The successor serves on the same socket file.
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		logger.Printf("In-flight requests did not finish within %v, canceling them", timeout)
+		forceStop()
+		<-stopped
+	}
+	for _, lis := range listeners {
+		lis.Close()
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	datadogstatsd "github.com/DataDog/datadog-go/statsd"
+	"github.com/cactus/go-statsd-client/statsd"
+	"github.com/mesosphere/csilvm/pkg/ddstatsd"
+	"github.com/uber-go/tally"
+	tallystatsd "github.com/uber-go/tally/statsd"
+)
+
+const (
+	defaultStatsdPrefix        = "csilvm"
+	defaultStatsdFlushInterval = time.Second
+)
+
+// statsdOptions configure the statsd emitter of the root metrics scope.
+type statsdOptions struct {
+	// addr is the host:port of the statsd server. If it is empty no
+	// metrics are emitted.
+	addr string
+	// format is one of classic or datadog.
+	format string
+	// prefix is prepended to the names of all metrics.
+	prefix string
+	// tags are added to all metrics.
+	tags map[string]string
+	// flushInterval is the interval at which metrics are reported.
+	flushInterval time.Duration
+	// maxUDPSize is the number of bytes buffered before a packet is sent.
+	maxUDPSize int
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// newScope returns the root metrics scope and a Closer that flushes and
+// stops it. If o.addr is empty the scope is tally.NoopScope.
+func newScope(o statsdOptions) (tally.Scope, io.Closer, error) {
+	if o.addr == "" {
+		return tally.NoopScope, nopCloser{}, nil
+	}
+	if o.flushInterval <= 0 {
+		return nil, nil, fmt.Errorf("the statsd flush interval must be positive: %v", o.flushInterval)
+	}
+	// The statsd clients are given no prefix, the scope's prefix is
+	// added to the metric names instead.
+	const statsdPrefix = ""
+	var reporter tally.StatsReporter
+	switch o.format {
+	case "datadog":
+		// The datadog statsd client does not support setting a
+		// custom flush interval. It defaults to 100ms:
+		// https://github.com/DataDog/datadog-go/blob/40bafcb5f6c1d49df36deaf4ab019e44961d5e36/statsd/statsd.go#L150
+		client, err := datadogstatsd.NewBuffered(o.addr, o.maxUDPSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		client.Namespace = statsdPrefix
+		reporter = ddstatsd.NewReporter(client, ddstatsd.Options{
+			SampleRate: 1.0,
+		})
+	case "classic":
+		client, err := statsd.NewBufferedClient(o.addr, statsdPrefix, o.flushInterval, o.maxUDPSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		reporter = tallystatsd.NewReporter(client, tallystatsd.Options{
+			SampleRate: 1.0,
+		})
+	default:
+		return nil, nil, fmt.Errorf("unknown statsd format: %q", o.format)
+	}
+	tags := make(map[string]string)
+	for k, v := range o.tags {
+		tags[k] = v
+	}
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		Prefix:   o.prefix,
+		Tags:     tags,
+		Reporter: reporter,
+	}, o.flushInterval)
+	return scope, closer, nil
+}
+
+// parseStatsdTags parses tags of the form KEY=VALUE.
+func parseStatsdTags(values []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid statsd tag %q, expected KEY=VALUE", v)
+		}
+		tags[parts[0]] = parts[1]
+	}
+	return tags, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/uber-go/tally"
+)
+
+func TestParseStatsdTags(t *testing.T) {
+	tags, err := parseStatsdTags([]string{"cluster=prod", "zone=a=b", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{"cluster": "prod", "zone": "a=b", "empty": ""}
+	if !reflect.DeepEqual(tags, exp) {
+		t.Fatalf("Expected %v but got %v", exp, tags)
+	}
+	for _, v := range []string{"cluster", "=prod"} {
+		if _, err := parseStatsdTags([]string{v}); err == nil {
+			t.Fatalf("Expected %q to be rejected", v)
+		}
+	}
+}
+
+func TestNewScope(t *testing.T) {
+	scope, closer, err := newScope(statsdOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scope != tally.NoopScope {
+		t.Fatalf("Expected the noop scope without a statsd address")
+	}
+	closer.Close()
+	opts := statsdOptions{
+		addr:          "127.0.0.1:8125",
+		format:        "classic",
+		prefix:        defaultStatsdPrefix,
+		flushInterval: defaultStatsdFlushInterval,
+		maxUDPSize:    1432,
+	}
+	for _, format := range []string{"classic", "datadog"} {
+		opts.format = format
+		scope, closer, err := newScope(opts)
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		scope.Counter("requests").Inc(1)
+		closer.Close()
+	}
+	opts.format = "influx"
+	if _, _, err := newScope(opts); err == nil {
+		t.Fatal("Expected an unknown format to be rejected")
+	}
+	opts.format = "classic"
+	opts.flushInterval = 0
+	if _, _, err := newScope(opts); err == nil {
+		t.Fatal("Expected a zero flush interval to be rejected")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+// moveVolume implements the `csilvm move-volume` subcommand. It describes
+// the move of a volume to another volume group on the node and only performs
+// it if -force is also given. It returns the process exit code.
+func moveVolume(args []string) int {
+	fs := flag.NewFlagSet("move-volume", flag.ExitOnError)
+	vgnameF := fs.String("volume-group", "", "The name of the volume group that has the volume")
+	targetF := fs.String("target-volume-group", "", "The name of the volume group to which the volume is moved")
+	volumeF := fs.String("volume", "", "The id of the volume to move, with or without the node id of -node-volume-ids")
+	lockFilePathF := fs.String("lockfile", defaultLockfilePathOrEnv(), "The path to the lock file used to prevent concurrent lvm invocation by multiple csilvm instances")
+	forceF := fs.Bool("force", false, "Confirms that the volume should be moved")
+	fs.Parse(args)
+
+	logger := log.New(os.Stderr, "[move-volume]", log.LstdFlags|log.Lshortfile)
+	lvm.SetLogger(logger)
+
+	if *vgnameF == "" || *targetF == "" || *volumeF == "" {
+		logger.Fatalf("-volume-group, -target-volume-group and -volume must be specified")
+	}
+	if *vgnameF == *targetF {
+		logger.Fatalf("-volume-group and -target-volume-group must differ")
+	}
+	if *lockFilePathF != "" {
+		lvm.SetLockFilePath(*lockFilePathF)
+	}
+	// The logical volume name follows the node id of a namespaced
+	// volume id.
+	lvname := *volumeF
+	if i := strings.LastIndex(lvname, ":"); i >= 0 {
+		lvname = lvname[i+1:]
+	}
+
+	src, err := lvm.LookupVolumeGroup(*vgnameF)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot lookup volume group %v: err=%v\n", *vgnameF, err)
+		return 1
+	}
+	dst, err := lvm.LookupVolumeGroup(*targetF)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot lookup volume group %v: err=%v\n", *targetF, err)
+		return 1
+	}
+	lv, err := src.LookupLogicalVolume(lvname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot lookup volume %v in volume group %v: err=%v\n", lvname, *vgnameF, err)
+		return 1
+	}
+	pvnames, err := lv.PhysicalVolumeNames()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot list the physical volumes of volume %v: err=%v\n", lvname, err)
+		return 1
+	}
+	fmt.Printf("Volume %v will be moved from volume group %v to volume group %v\n", lvname, *vgnameF, *targetF)
+	fmt.Printf("  size:             %d bytes\n", lv.SizeInBytes())
+	fmt.Printf("  physical volumes: %v\n", strings.Join(pvnames, ", "))
+	if !*forceF {
+		fmt.Fprintf(os.Stderr, "re-run with -force to move the volume\n")
+		return 1
+	}
+	if err := lvm.MoveLogicalVolume(src, dst, lvname); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot move volume %v: err=%v\n", lvname, err)
+		if _, lerr := lvm.LookupVolumeGroup(lvm.MoveVolumeGroupName(lvname)); lerr == nil {
+			fmt.Fprintf(os.Stderr, "the physical volumes were left in volume group %v, merge it with `vgmerge %v %v`\n", lvm.MoveVolumeGroupName(lvname), *targetF, lvm.MoveVolumeGroupName(lvname))
+		}
+		return 1
+	}
+	fmt.Printf("Moved volume %v to volume group %v\n", lvname, *targetF)
+	return 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+// restoreVG implements the `csilvm restore-vg` subcommand. Without -backup
+// it lists the metadata backups of the volume group. With -backup it
+// describes the restore that would be performed and only performs it if
+// -force is also given. It returns the process exit code.
+func restoreVG(args []string) int {
+	fs := flag.NewFlagSet("restore-vg", flag.ExitOnError)
+	vgnameF := fs.String("volume-group", "", "The name of the volume group to restore")
+	lockFilePathF := fs.String("lockfile", defaultLockfilePathOrEnv(), "The path to the lock file used to prevent concurrent lvm invocation by multiple csilvm instances")
+	backupF := fs.Uint64("backup", 0, "The sequence number of the backup to restore, as listed when this option is omitted")
+	forceF := fs.Bool("force", false, "Confirms that the volume group should be restored from the selected backup")
+	fs.Parse(args)
+
+	logger := log.New(os.Stderr, "[restore-vg]", log.LstdFlags|log.Lshortfile)
+	lvm.SetLogger(logger)
+
+	if *vgnameF == "" {
+		logger.Fatalf("-volume-group must be specified")
+	}
+	if *lockFilePathF != "" {
+		lvm.SetLockFilePath(*lockFilePathF)
+	}
+
+	backups, err := lvm.ListMetadataBackups(*vgnameF)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot list backups of volume group %v: err=%v\n", *vgnameF, err)
+		return 1
+	}
+	if *backupF == 0 {
+		if len(backups) == 0 {
+			fmt.Fprintf(os.Stderr, "no backups of volume group %v found\n", *vgnameF)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "BACKUP\tCREATED\tDESCRIPTION")
+		for _, b := range backups {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", b.Sequence, b.CreatedAt.Format(time.RFC3339), b.Description)
+		}
+		w.Flush()
+		return 0
+	}
+
+	var backup *lvm.MetadataBackup
+	for i := range backups {
+		if backups[i].Sequence == *backupF {
+			backup = &backups[i]
+			break
+		}
+	}
+	if backup == nil {
+		fmt.Fprintf(os.Stderr, "no backup %d of volume group %v found\n", *backupF, *vgnameF)
+		return 1
+	}
+	// vgcfgrestore would overwrite the metadata of an existing volume
+	// group, losing any changes made since the backup.
+	if _, err := lvm.LookupVolumeGroup(*vgnameF); err != lvm.ErrVolumeGroupNotFound {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot lookup volume group %v: err=%v\n", *vgnameF, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "volume group %v exists, refusing to restore it\n", *vgnameF)
+		}
+		return 1
+	}
+	fmt.Printf("Volume group %v will be restored from %v\n", *vgnameF, backup.Path)
+	fmt.Printf("  created:     %v\n", backup.CreatedAt.Format(time.RFC3339))
+	fmt.Printf("  description: %v\n", backup.Description)
+	if !*forceF {
+		fmt.Fprintf(os.Stderr, "re-run with -force to restore the volume group\n")
+		return 1
+	}
+	if err := lvm.RestoreVolumeGroup(*vgnameF, *backup); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot restore volume group %v: err=%v\n", *vgnameF, err)
+		return 1
+	}
+	fmt.Printf("Restored volume group %v\n", *vgnameF)
+	return 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/mesosphere/csilvm/pkg/csilvm"
+)
+
+// soak implements the hidden `csilvm soak` subcommand. It runs a soak test
+// against the running plugin, writes the JSON report to stdout and returns
+// the process exit code. It is intended for release testing on a node with
+// a dedicated volume group, not for production nodes.
+func soak(args []string) int {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	socketFileF := fs.String("unix-addr", "", "The path to the unix socket file of the running plugin to test")
+	socketFileEnvF := fs.String("unix-addr-env", "", "An optional environment variable from which to read the unix-addr")
+	volumesF := fs.Int("volumes", 200, "The number of volumes to create")
+	volumeBytesF := fs.Int64("volume-bytes", 4<<20, "The requested size of each volume in bytes")
+	concurrencyF := fs.Int("concurrency", 8, "The number of RPCs in flight at a time")
+	listsF := fs.Int("lists", 10, "The number of times all volumes are listed once they have been created")
+	targetDirF := fs.String("target-dir", "", "If set, every volume is published as a block volume in this directory and unpublished again")
+	namePrefixF := fs.String("name-prefix", fmt.Sprintf("soak-%d-", time.Now().Unix()), "The prefix of the names of the created volumes")
+	timeoutF := fs.Duration("timeout", 5*time.Minute, "The timeout of each RPC")
+	fs.Parse(args)
+
+	// Logs go to stderr so that stdout only contains the report.
+	logger := log.New(os.Stderr, "[soak]", log.LstdFlags|log.Lshortfile)
+
+	if *socketFileF != "" && *socketFileEnvF != "" {
+		logger.Fatalf("cannot specify -unix-addr and -unix-addr-env")
+	}
+	sock := *socketFileF
+	if *socketFileEnvF != "" {
+		sock = os.Getenv(*socketFileEnvF)
+	}
+	sock = strings.TrimPrefix(sock, "unix://")
+	if sock == "" {
+		logger.Fatalf("-unix-addr must be specified")
+	}
+
+	// An interrupted soak test stops creating volumes and deletes the
+	// ones it created.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logger.Printf("received %v, deleting the created volumes", sig)
+		cancel()
+	}()
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+	}
+	conn, err := grpc.DialContext(ctx, sock, grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		logger.Fatalf("cannot connect to %v: err=%v", sock, err)
+	}
+	defer conn.Close()
+
+	logger.Printf("creating %d volumes with prefix %v", *volumesF, *namePrefixF)
+	report := csilvm.Soak(ctx, csilvm.NewClient(conn), csilvm.SoakConfig{
+		NamePrefix:  *namePrefixF,
+		Volumes:     *volumesF,
+		VolumeBytes: *volumeBytesF,
+		Concurrency: *concurrencyF,
+		Lists:       *listsF,
+		TargetDir:   *targetDirF,
+		Timeout:     *timeoutF,
+	})
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot write soak report: err=%v\n", err)
+		return 1
+	}
+	if len(report.Errors) != 0 {
+		return 1
+	}
+	return 0
+}
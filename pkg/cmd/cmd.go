@@ -0,0 +1,100 @@
+// Package cmd runs external commands, such as mkfs or blkid, on behalf of
+// the plugin. Commands are canceled when their context is done or their
+// timeout expires, their stdout and stderr are captured separately and their
+// latency is reported as a metric and recorded in the trace of the context.
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/uber-go/tally"
+	"golang.org/x/net/trace"
+)
+
+const (
+	resultTypeSuccess = "success"
+	resultTypeError   = "error"
+)
+
+// latencyBuckets are the buckets of the command latency histogram, from
+// 10ms to about 5 minutes.
+var latencyBuckets = tally.MustMakeExponentialDurationBuckets(10*time.Millisecond, 2, 16)
+
+// Runner runs external commands.
+type Runner struct {
+	metrics tally.Scope
+}
+
+// NewRunner returns a Runner that reports metrics to the given scope. If
+// scope is nil, no metrics are reported.
+func NewRunner(scope tally.Scope) *Runner {
+	if scope == nil {
+		scope = tally.NoopScope
+	}
+	return &Runner{metrics: scope}
+}
+
+// Output holds the output of a command.
+type Output struct {
+	Stdout []byte
+	Stderr []byte
+}
+
+// Error is returned by Run if the command fails.
+type Error struct {
+	// Command is the command-line of the failed command.
+	Command string
+	// Err is the error returned when running the command. It is the
+	// context's error if the command was canceled or timed out.
+	Err error
+	// Stderr is the captured stderr of the command.
+	Stderr []byte
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s failed: err=%v stderr=%s", e.Command, e.Err, bytes.TrimSpace(e.Stderr))
+}
+
+// Run runs the named command with the given arguments and waits for it to
+// complete. The command is killed if ctx is done or, if timeout is
+// positive, once the timeout expires.
+func (r *Runner) Run(ctx context.Context, timeout time.Duration, name string, args ...string) (*Output, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	scope := r.metrics.Tagged(map[string]string{"command": name})
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	c := exec.CommandContext(ctx, name, args...)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	start := time.Now()
+	err := c.Run()
+	elapsed := time.Since(start)
+	if tr, ok := trace.FromContext(ctx); ok {
+		tr.LazyPrintf("%s %s: %v err=%v", name, strings.Join(args, " "), elapsed, err)
+	}
+	scope.SubScope("commands").Timer("latency").Record(elapsed)
+	scope.SubScope("commands").Histogram("duration", latencyBuckets).RecordDuration(elapsed)
+	output := &Output{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	if err != nil {
+		scope.Tagged(map[string]string{"result_type": resultTypeError}).Counter("commands").Inc(1)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// The command was killed, report why.
+			err = ctxErr
+		}
+		return output, &Error{
+			Command: strings.Join(append([]string{name}, args...), " "),
+			Err:     err,
+			Stderr:  output.Stderr,
+		}
+	}
+	scope.Tagged(map[string]string{"result_type": resultTypeSuccess}).Counter("commands").Inc(1)
+	return output, nil
+}
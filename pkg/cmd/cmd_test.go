@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/uber-go/tally"
+)
+
+func TestRun(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	r := NewRunner(scope)
+	output, err := r.Run(context.Background(), time.Minute, "sh", "-c", "echo out; echo err >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output.Stdout) != "out\n" {
+		t.Fatalf("unexpected stdout %q", output.Stdout)
+	}
+	if string(output.Stderr) != "err\n" {
+		t.Fatalf("unexpected stderr %q", output.Stderr)
+	}
+	counters := scope.Snapshot().Counters()
+	if c, ok := counters["commands+command=sh,result_type=success"]; !ok || c.Value() != 1 {
+		t.Fatalf("expected a successful command to be counted: %v", counters)
+	}
+	if _, ok := scope.Snapshot().Timers()["commands.latency+command=sh"]; !ok {
+		t.Fatalf("expected command latency to be recorded: %v", scope.Snapshot().Timers())
+	}
+	if _, ok := scope.Snapshot().Histograms()["commands.duration+command=sh"]; !ok {
+		t.Fatalf("expected command duration histogram to be recorded: %v", scope.Snapshot().Histograms())
+	}
+}
+
+func TestRunFailed(t *testing.T) {
+	r := NewRunner(nil)
+	_, err := r.Run(context.Background(), 0, "sh", "-c", "echo oops >&2; exit 1")
+	cerr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected *Error but got %v", err)
+	}
+	if string(cerr.Stderr) != "oops\n" {
+		t.Fatalf("unexpected stderr %q", cerr.Stderr)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	r := NewRunner(nil)
+	start := time.Now()
+	_, err := r.Run(context.Background(), 10*time.Millisecond, "sleep", "60")
+	cerr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected *Error but got %v", err)
+	}
+	if cerr.Err != context.DeadlineExceeded {
+		t.Fatalf("expected %v but got %v", context.DeadlineExceeded, cerr.Err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected the command to be killed but it ran for %v", elapsed)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	r := NewRunner(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.Run(ctx, 0, "sleep", "60")
+	cerr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected *Error but got %v", err)
+	}
+	if cerr.Err != context.Canceled {
+		t.Fatalf("expected %v but got %v", context.Canceled, cerr.Err)
+	}
+}
@@ -0,0 +1,29 @@
+package csilvm
+
+import (
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+// SkipAutoActivation configures the server to set the activation skip flag
+// on the logical volumes it creates. This prevents the host's LVM, e.g.,
+// the lvm2-activation services at boot, from activating them and racing
+// the plugin. Instead, the plugin activates the logical volumes in Setup
+// and before publishing or deleting them.
+func SkipAutoActivation() ServerOpt {
+	return func(s *Server) {
+		s.activationSkip = true
+	}
+}
+
+// activateVolume activates the logical volume if SkipAutoActivation is
+// configured. Logical volumes are otherwise activated by LVM.
+func (s *Server) activateVolume(lv *lvm.LogicalVolume) error {
+	if !s.activationSkip {
+		return nil
+	}
+	log.Printf("Activating volume %v", lv.Name())
+	if err := lv.Activate(); err != nil {
+		return s.lvmError(err, "Cannot activate volume", "lvname", lv.Name())
+	}
+	return nil
+}
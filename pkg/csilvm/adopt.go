@@ -0,0 +1,113 @@
+package csilvm
+
+import (
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+// AdoptionTag configures the server to adopt the logical volumes that carry
+// the given tag, e.g., volumes created by an operator or a previous driver.
+// An adopted volume is renamed with the VolumePrefix, if any, and its name,
+// without the prefix, and its layout are recorded in the same tags that
+// CreateVolume adds. The adoption tag is then removed. From then on the
+// volume is listed, published and deleted like any other volume. Volumes
+// are only adopted by Setup, which also validates the tag.
+func AdoptionTag(tag string) ServerOpt {
+	return func(s *Server) {
+		s.adoptionTag = tag
+	}
+}
+
+// adoptVolumes adopts the logical volumes that carry the adoption tag. A
+// volume that cannot be adopted is logged and keeps its tag so that it is
+// retried by the next Setup.
+func (s *Server) adoptVolumes(ctx context.Context, lvs []lvm.LogicalVolumeReport) {
+	names := make(map[string]bool)
+	for _, lv := range lvs {
+		if !s.ownsVolume(lv.Name) {
+			continue
+		}
+		if name, ok := volumeNameFromTags(lv.Tags); ok {
+			names[name] = true
+		}
+	}
+	for _, lv := range lvs {
+		if !containsString(lv.Tags, s.adoptionTag) || isTrashed(lv.Name) {
+			continue
+		}
+		if err := s.adoptVolume(ctx, lv, names); err != nil {
+			log.Printf("Cannot adopt logical volume %v: err=%v", lv.Name, err)
+			s.metrics.Counter("volume-adoption-errs").Inc(1)
+			continue
+		}
+		s.metrics.Counter("volumes-adopted").Inc(1)
+	}
+}
+
+// adoptVolume adopts the logical volume. The names of the volumes that are
+// managed by the server are given so that no two volumes have the same CO
+// name. A volume that is open, e.g., mounted, is not renamed as that
+// changes its device path. The volume is renamed before its tags are
+// changed so that a volume whose rename fails keeps its adoption tag.
+func (s *Server) adoptVolume(ctx context.Context, report lvm.LogicalVolumeReport, names map[string]bool) error {
+	vg := s.volumeGroup.WithContext(ctx)
+	lv, err := vg.LookupLogicalVolume(report.Name)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(report.Name, s.volumeIDPrefix())
+	if _, ok := volumeNameFromTags(report.Tags); !ok && names[name] {
+		return statusErrorf(
+			codes.AlreadyExists,
+			s.errorInfo(ReasonVolumeAlreadyExists, "lvname", report.Name),
+			"Another volume has the name %q", name)
+	}
+	var add []string
+	if _, ok := volumeNameFromTags(report.Tags); !ok {
+		add = append(add, s.volumeNameToTag(name))
+	}
+	if _, ok := layoutTagFromTags(report.Tags); !ok {
+		// Record the layout so that, e.g., a CreateVolume of the
+		// name with another layout fails. Volumes with an
+		// unsupported layout are not adopted.
+		layout, err := lv.Layout()
+		if err != nil {
+			return err
+		}
+		add = append(add, layoutToTag(layout))
+	}
+	if !s.ownsVolume(report.Name) {
+		open, err := lv.IsOpen()
+		if err != nil {
+			return err
+		}
+		if open {
+			return statusErrorf(
+				codes.FailedPrecondition,
+				s.errorInfo(ReasonVolumeInUse, "lvname", report.Name),
+				"Cannot rename the volume %v while it is open", report.Name)
+		}
+		id := s.volumeIDPrefix() + report.Name
+		if _, err := vg.LookupLogicalVolume(id); err == nil {
+			return statusErrorf(
+				codes.AlreadyExists,
+				s.errorInfo(ReasonVolumeAlreadyExists, "lvname", id),
+				"Cannot rename the volume to %v as another volume has that name", id)
+		} else if err != lvm.ErrLogicalVolumeNotFound {
+			return err
+		}
+		log.Printf("Adopting logical volume %v as volume %v", report.Name, id)
+		if err := lv.Rename(id); err != nil {
+			return err
+		}
+	}
+	if err := lv.ChangeTags(add, []string{s.adoptionTag}); err != nil {
+		return err
+	}
+	names[name] = true
+	log.Printf("Adopted volume %v with name %q", lv.Name(), name)
+	return nil
+}
@@ -0,0 +1,122 @@
+package csilvm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+// atomicPublishDirMode is the permission of the atomic publish dir and of
+// the staging directories beneath it.
+const atomicPublishDirMode = 0700
+
+// AtomicPublish configures NodePublishVolume to mount the filesystem of a
+// MOUNT_DEVICE volume at a private staging directory beneath dir, apply the
+// SELinux relabeling and volume mount group there, and only then move the
+// mount to the target path with MS_MOVE. The target path is thus either
+// empty or fully configured, even if the plugin crashes while publishing.
+// Setup makes dir a private mount, as mounts beneath a shared mount cannot
+// be moved, and unmounts anything left beneath it by a crash.
+func AtomicPublish(dir string) ServerOpt {
+	return func(s *Server) {
+		s.atomicPublishDir = filepath.Clean(dir)
+	}
+}
+
+// setupAtomicPublish creates the atomic publish dir, unmounts the staged
+// mounts left beneath it and makes it a private mount.
+func (s *Server) setupAtomicPublish() error {
+	dir := s.atomicPublishDir
+	log.Printf("Setting up atomic publish dir %v", dir)
+	if err := os.MkdirAll(dir, atomicPublishDirMode); err != nil {
+		return err
+	}
+	mounts, err := listMounts()
+	if err != nil {
+		return err
+	}
+	// Unmount in reverse order so that nested mounts are unmounted
+	// before their parents. Nothing beneath dir was ever visible at a
+	// target path so it is safe to discard.
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if path := mounts[i].path; strings.HasPrefix(path, dir+"/") {
+			log.Printf("Unmounting stale staged mount at %v", path)
+			if err := unmount(path, 0); err != nil {
+				return err
+			}
+		}
+	}
+	if mp := mountFor(mounts, dir); mp == nil || mp.path != dir {
+		// Only mount points can be made private so we bind mount
+		// dir onto itself.
+		log.Printf("Bind mounting %v onto itself", dir)
+		if err := mount(dir, dir, "", msBind, ""); err != nil {
+			return err
+		}
+	}
+	log.Printf("Making %v a private mount", dir)
+	return mount("", dir, "", msPrivate, "")
+}
+
+// stagingPath returns the directory at which the filesystem of the volume
+// is mounted before it is moved to the target path.
+func (s *Server) stagingPath(id string) string {
+	return filepath.Join(s.atomicPublishDir, id)
+}
+
+// mountAtomically mounts the filesystem on sourcePath at the staging path of
+// the volume, calls configure with the staging path and moves the mount to
+// targetPath. If any step fails the staged mount is removed so that nothing
+// is left at targetPath.
+func (s *Server) mountAtomically(id, sourcePath, targetPath, fstype string, flags uintptr, data string, configure func(mountPath string) error) error {
+	stagingPath := s.stagingPath(id)
+	if err := os.Mkdir(stagingPath, atomicPublishDirMode); err != nil && !os.IsExist(err) {
+		return statusErrorf(
+			codes.Internal,
+			s.errorInfo(ReasonMountFailed, "device", sourcePath, "targetPath", targetPath),
+			"Cannot create staging path %v: err=%v",
+			stagingPath, err)
+	}
+	// Once the mount is moved the staging path is an empty directory.
+	defer os.Remove(stagingPath)
+	mp, err := getMountAt(stagingPath)
+	if err != nil {
+		return statusErrorf(
+			codes.Internal,
+			s.errorInfo(ReasonMountInfoFailed, "device", sourcePath, "targetPath", stagingPath),
+			"Cannot get mount info at %v: err=%v",
+			stagingPath, err)
+	}
+	if mp != nil {
+		// Left behind by an earlier attempt that failed to clean up.
+		log.Printf("Unmounting stale staged mount at %v", stagingPath)
+		if err := unmount(stagingPath, 0); err != nil {
+			return statusErrorf(
+				codes.Internal,
+				s.errorInfo(ReasonMountFailed, "device", sourcePath, "targetPath", stagingPath),
+				"Cannot unmount stale staged mount: err=%v",
+				err)
+		}
+	}
+	log.Printf("Mounting %v at staging path %v fstype=%v, flags=%v mountOptions=%v", sourcePath, stagingPath, fstype, flags, data)
+	if err := mount(sourcePath, stagingPath, fstype, flags, data); err != nil {
+		return s.mountError(err, sourcePath, stagingPath, "Failed to perform mount: err=%v")
+	}
+	unstage := func() {
+		if err := unmount(stagingPath, 0); err != nil {
+			log.Printf("Cannot unmount staged mount at %v: err=%v", stagingPath, err)
+		}
+	}
+	if err := configure(stagingPath); err != nil {
+		unstage()
+		return err
+	}
+	log.Printf("Moving mount %v to %v", stagingPath, targetPath)
+	if err := mount(stagingPath, targetPath, "", msMove, ""); err != nil {
+		unstage()
+		return s.mountError(err, sourcePath, targetPath, "Failed to move mount: err=%v")
+	}
+	return nil
+}
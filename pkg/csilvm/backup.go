@@ -0,0 +1,73 @@
+package csilvm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// backupHookTimeout bounds the duration of the metadata backup hook.
+const backupHookTimeout = 5 * time.Minute
+
+// MetadataBackupDir configures the server to write a backup of the volume
+// group metadata to dir after every CreateVolume and DeleteVolume call that
+// changed the volume group. Backups are named
+// <vgname>-<timestamp>-<method>.vg and are never removed by the server.
+func MetadataBackupDir(dir string) ServerOpt {
+	return func(s *Server) {
+		s.metadataBackupDir = dir
+	}
+}
+
+// MetadataBackupHook configures the server to execute the program at path
+// with the path of each metadata backup as its only argument, e.g., to
+// upload it to remote storage. If no MetadataBackupDir is configured, the
+// backup is written to a temporary file that is removed once the hook
+// returns.
+func MetadataBackupHook(path string) ServerOpt {
+	return func(s *Server) {
+		s.metadataBackupHook = path
+	}
+}
+
+// backupMetadata backs up the volume group metadata after the given method
+// changed the volume group. The method has already succeeded so failures
+// are logged and counted rather than returned.
+func (s *Server) backupMetadata(ctx context.Context, method string) {
+	if s.metadataBackupDir == "" && s.metadataBackupHook == "" {
+		return
+	}
+	if err := s.doBackupMetadata(ctx, method); err != nil {
+		log.Printf("Failed to back up volume group metadata after %v: err=%v", method, err)
+		s.metrics.Counter("metadata-backup-errs").Inc(1)
+	}
+}
+
+func (s *Server) doBackupMetadata(ctx context.Context, method string) error {
+	dir := s.metadataBackupDir
+	if dir == "" {
+		tmpdir, err := ioutil.TempDir("", "csilvm-backup")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpdir)
+		dir = tmpdir
+	}
+	name := fmt.Sprintf("%s-%s-%s.vg", s.vgname, time.Now().UTC().Format("20060102T150405.000Z"), method)
+	path := filepath.Join(dir, name)
+	log.Printf("Backing up volume group metadata to %v", path)
+	if err := s.volumeGroup.WithContext(ctx).Backup(path); err != nil {
+		return err
+	}
+	if s.metadataBackupHook != "" {
+		log.Printf("Running metadata backup hook %v", s.metadataBackupHook)
+		if _, err := s.runner.Run(ctx, backupHookTimeout, s.metadataBackupHook, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// +build linux,!unit
+
+package csilvm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+// benchVolumeBytes is the size of the volumes created by the benchmarks,
+// which is a single extent of the default extent size.
+const benchVolumeBytes = 4 << 20
+
+// startBenchmark starts a server whose volume group has room for hundreds
+// of benchmark volumes.
+func startBenchmark(b *testing.B) (client *Client, cleanup func()) {
+	loop, err := lvm.CreateLoopDevice(2 << 30)
+	if err != nil {
+		b.Fatal(err)
+	}
+	client, clean := startTest(testvgname(), []string{loop.Path()})
+	return client, func() {
+		clean()
+		loop.Close()
+	}
+}
+
+func BenchmarkCreateDeleteVolume(b *testing.B) {
+	client, clean := startBenchmark(b)
+	defer clean()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := client.CreateVolume(context.Background(), soakCreateVolumeRequest(fmt.Sprintf("bench-%d", i), benchVolumeBytes))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := client.DeleteVolume(context.Background(), testDeleteVolumeRequest(resp.GetVolume().GetId())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListVolumes(b *testing.B) {
+	for _, volumes := range []int{10, 100, 300} {
+		b.Run(fmt.Sprintf("volumes=%d", volumes), func(b *testing.B) {
+			client, clean := startBenchmark(b)
+			defer clean()
+			for i := 0; i < volumes; i++ {
+				if _, err := client.CreateVolume(context.Background(), soakCreateVolumeRequest(fmt.Sprintf("bench-%d", i), benchVolumeBytes)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := client.ListVolumes(context.Background(), testListVolumesRequest())
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(resp.GetEntries()) != volumes {
+					b.Fatalf("expected %d volumes but got %d", volumes, len(resp.GetEntries()))
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSoak runs a soak test with hundreds of volumes and logs the
+// latency distribution of each RPC.
+func BenchmarkSoak(b *testing.B) {
+	client, clean := startBenchmark(b)
+	defer clean()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		report := Soak(context.Background(), client, SoakConfig{
+			NamePrefix:  fmt.Sprintf("soak-%d-", i),
+			Volumes:     300,
+			VolumeBytes: benchVolumeBytes,
+			Concurrency: 8,
+			Lists:       10,
+		})
+		if len(report.Errors) != 0 {
+			b.Fatalf("soak test failed: %v", report.Errors)
+		}
+		for rpc, summary := range report.Latencies {
+			b.Logf("%v: %+v", rpc, summary)
+		}
+	}
+}
+
+func TestSoak(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	report := Soak(context.Background(), client, SoakConfig{
+		NamePrefix:  "soak-",
+		Volumes:     5,
+		VolumeBytes: benchVolumeBytes,
+		Concurrency: 2,
+		Lists:       2,
+	})
+	if len(report.Errors) != 0 {
+		t.Fatalf("unexpected errors %v", report.Errors)
+	}
+	for rpc, count := range map[string]int{"CreateVolume": 5, "ListVolumes": 2, "DeleteVolume": 5} {
+		if got := report.Latencies[rpc].Count; got != count {
+			t.Fatalf("expected %d %v calls but got %d", count, rpc, got)
+		}
+	}
+	resp, err := client.ListVolumes(context.Background(), testListVolumesRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetEntries()) != 0 {
+		t.Fatalf("expected the volumes to be deleted but got %v", resp.GetEntries())
+	}
+}
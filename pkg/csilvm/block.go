@@ -0,0 +1,279 @@
+package csilvm
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/mesosphere/csilvm/pkg/cmd"
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+// Volume attributes that control how BLOCK_DEVICE volumes are published.
+const (
+	// attrBlockUID is the numeric user ID that will own the published
+	// device node.
+	attrBlockUID = "block-uid"
+	// attrBlockGID is the numeric group ID that will own the published
+	// device node.
+	attrBlockGID = "block-gid"
+	// attrBlockMode is the octal permission mode, e.g., "0660", of the
+	// published device node.
+	attrBlockMode = "block-mode"
+	// attrBlockVerifyDirectIO, if set to "true", checks that the device
+	// can be read using O_DIRECT before publishing it.
+	attrBlockVerifyDirectIO = "block-verify-direct-io"
+)
+
+type blockPublishOptions struct {
+	uid, gid       int
+	mode           os.FileMode
+	hasMode        bool
+	verifyDirectIO bool
+}
+
+// parseBlockPublishOptions parses the block-related volume attributes. A uid
+// or gid of -1 means that the owner or group is left unchanged.
+func parseBlockPublishOptions(attrs map[string]string) (opts blockPublishOptions, err error) {
+	opts.uid, opts.gid = -1, -1
+	if v, ok := attrs[attrBlockUID]; ok {
+		uid, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return opts, fmt.Errorf("The '%s' attribute must be a non-negative integer: err=%v", attrBlockUID, err)
+		}
+		opts.uid = int(uid)
+	}
+	if v, ok := attrs[attrBlockGID]; ok {
+		gid, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return opts, fmt.Errorf("The '%s' attribute must be a non-negative integer: err=%v", attrBlockGID, err)
+		}
+		opts.gid = int(gid)
+	}
+	if v, ok := attrs[attrBlockMode]; ok {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode&^uint64(os.ModePerm) != 0 {
+			return opts, fmt.Errorf("The '%s' attribute must be an octal permission mode such as 0660: %q", attrBlockMode, v)
+		}
+		opts.mode = os.FileMode(mode)
+		opts.hasMode = true
+	}
+	if v, ok := attrs[attrBlockVerifyDirectIO]; ok {
+		verify, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("The '%s' attribute must be a boolean: err=%v", attrBlockVerifyDirectIO, err)
+		}
+		opts.verifyDirectIO = verify
+	}
+	return opts, nil
+}
+
+// applyDevicePermissions changes the owner and mode of the device node at
+// path. As the target path is a bind mount of the device node, this changes
+// the device node itself.
+func applyDevicePermissions(path string, opts blockPublishOptions) error {
+	if opts.uid != -1 || opts.gid != -1 {
+		log.Printf("Changing ownership of %v to %d:%d", path, opts.uid, opts.gid)
+		if err := os.Chown(path, opts.uid, opts.gid); err != nil {
+			return err
+		}
+	}
+	if opts.hasMode {
+		log.Printf("Changing mode of %v to %v", path, opts.mode)
+		if err := os.Chmod(path, opts.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var ErrVolumePublishedRW = statusError(
+	codes.FailedPrecondition,
+	newErrorInfo(ReasonVolumePublishedRW),
+	"The volume is already published read-write and cannot also be published readonly as a block device.")
+
+// checkReadonlyBlockConflict checks that publishing the volume whose device
+// is at devicePath at targetPath is compatible with its other publications.
+// Publishing a block device readonly makes the device itself readonly, so
+// the volume cannot be published read-write at the same time.
+func (s *Server) checkReadonlyBlockConflict(ctx context.Context, id, devicePath, targetPath string, readonly, block bool) error {
+	published, err := s.publications(ctx, id, devicePath)
+	if err != nil {
+		return statusErrorf(
+			codes.Internal,
+			s.errorInfo(ReasonMountInfoFailed, "lvname", id, "device", devicePath),
+			"Cannot list the publications of the volume: err=%v",
+			err)
+	}
+	for path, info := range published {
+		if path == targetPath {
+			continue
+		}
+		if readonly && block && !info.Readonly {
+			return ErrVolumePublishedRW
+		}
+		if !readonly && info.Readonly && info.Block {
+			return ErrVolumePublishedRO
+		}
+	}
+	return nil
+}
+
+// publications returns the target paths of the volume whose device is at
+// devicePath and how it is published at each of them. The registry is lost
+// when the plugin restarts without a state directory, so the publications
+// found in the mount table are included as well.
+func (s *Server) publications(ctx context.Context, id, devicePath string) (map[string]targetInfo, error) {
+	published := s.targets.entries(id)
+	mounts, err := listMounts()
+	if err != nil {
+		return nil, err
+	}
+	found := devicePublications(lookupBlockDevice(devicePath), mounts)
+	var deviceReadonly *bool
+	for path, info := range found {
+		if _, ok := published[path]; ok {
+			continue
+		}
+		if info.Block {
+			// A readonly BLOCK_DEVICE publication is a read-write
+			// bind mount of a readonly device.
+			if deviceReadonly == nil {
+				readonly, err := isDeviceReadonly(ctx, s.runner, devicePath)
+				if err != nil {
+					return nil, err
+				}
+				deviceReadonly = &readonly
+			}
+			info.Readonly = info.Readonly || *deviceReadonly
+		}
+		published[path] = info
+	}
+	return published, nil
+}
+
+// devicePublications returns the publications of the device found in
+// mounts, keyed by target path. A publication is readonly if its mount is.
+func devicePublications(d blockDevice, mounts []mountpoint) map[string]targetInfo {
+	published := make(map[string]targetInfo)
+	for i := range mounts {
+		if info, ok := d.publicationAt(&mounts[i]); ok {
+			published[mounts[i].path] = info
+		}
+	}
+	return published
+}
+
+// publicationAt returns how the device is published at mp, if it is. A
+// bind mount of the device node is a BLOCK_DEVICE publication and a mount
+// of its filesystem a MOUNT one.
+func (d blockDevice) publicationAt(mp *mountpoint) (targetInfo, bool) {
+	switch {
+	case d.isBoundAt(mp):
+		return targetInfo{Readonly: mp.isReadonly(), Block: true}, true
+	case d.isMountedAt(mp):
+		return targetInfo{Readonly: mp.isReadonly()}, true
+	}
+	return targetInfo{}, false
+}
+
+// setDeviceReadonly sets or clears the readonly flag of the block device at
+// path. Unlike the readonly flag of a mount, the kernel enforces it for all
+// writes to the device.
+func setDeviceReadonly(ctx context.Context, runner *cmd.Runner, path string, readonly bool) error {
+	flag := "--setrw"
+	if readonly {
+		flag = "--setro"
+	}
+	_, err := runner.Run(ctx, probeTimeout, "blockdev", flag, path)
+	return err
+}
+
+// isDeviceReadonly returns whether the readonly flag of the block device at
+// path is set.
+func isDeviceReadonly(ctx context.Context, runner *cmd.Runner, path string) (bool, error) {
+	output, err := runner.Run(ctx, probeTimeout, "blockdev", "--getro", path)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(output.Stdout)) == "1", nil
+}
+
+// releaseReadonlyDevice clears the readonly flag of the volume's device
+// after it was unpublished from a target described by unpublished, once
+// the volume is no longer published readonly as a block device elsewhere.
+// The flag of a logical volume whose permission is read-only is kept.
+func (s *Server) releaseReadonlyDevice(ctx context.Context, lv *lvm.LogicalVolume, unpublished targetInfo) error {
+	if !unpublished.Readonly || !unpublished.Block {
+		return nil
+	}
+	path, err := lv.Path()
+	if err != nil {
+		return s.lvmError(err, "Error in Path()", "lvname", lv.Name())
+	}
+	published, err := s.publications(ctx, lv.Name(), path)
+	if err != nil {
+		return statusErrorf(
+			codes.Internal,
+			s.errorInfo(ReasonMountInfoFailed, "lvname", lv.Name(), "device", path),
+			"Cannot list the publications of the volume: err=%v",
+			err)
+	}
+	for _, info := range published {
+		if info.Readonly && info.Block {
+			return nil
+		}
+	}
+	readonly, err := lv.IsReadonly()
+	if err != nil {
+		return s.lvmError(err, "Error in IsReadonly()", "lvname", lv.Name())
+	}
+	if readonly {
+		log.Printf("The volume %v is readonly, keeping its device readonly", lv.Name())
+		return nil
+	}
+	log.Printf("Setting the device %v read-write", path)
+	if err := setDeviceReadonly(ctx, s.runner, path, false); err != nil {
+		return statusErrorf(
+			codes.Internal,
+			s.errorInfo(ReasonDeviceReadonlyFailed, "lvname", lv.Name(), "device", path),
+			"Cannot set the device read-write: err=%v",
+			err)
+	}
+	return nil
+}
+
+// directIOAlignment is the buffer alignment and read size used when
+// verifying O_DIRECT reads. It satisfies the logical block size of both
+// 512e and 4Kn devices.
+const directIOAlignment = 4096
+
+// verifyDirectIO checks that the first block of the device at path can be
+// read with O_DIRECT.
+func verifyDirectIO(path string) error {
+	file, err := os.OpenFile(path, os.O_RDONLY|oDirect, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	buf := alignedBuffer(directIOAlignment, directIOAlignment)
+	if _, err := file.Read(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// alignedBuffer returns a buffer of the given size whose first byte is
+// aligned to align bytes, as required for O_DIRECT.
+func alignedBuffer(size, align int) []byte {
+	buf := make([]byte, size+align)
+	offset := int(uintptr(unsafe.Pointer(&buf[0])) & uintptr(align-1))
+	if offset != 0 {
+		offset = align - offset
+	}
+	return buf[offset : offset+size]
+}
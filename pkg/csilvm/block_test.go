@@ -0,0 +1,80 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestParseBlockPublishOptions(t *testing.T) {
+	opts, err := parseBlockPublishOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.uid != -1 || opts.gid != -1 || opts.hasMode || opts.verifyDirectIO {
+		t.Fatalf("Expected default options but got %+v", opts)
+	}
+	opts, err = parseBlockPublishOptions(map[string]string{
+		attrBlockUID:            "1000",
+		attrBlockGID:            "2000",
+		attrBlockMode:           "0660",
+		attrBlockVerifyDirectIO: "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.uid != 1000 || opts.gid != 2000 {
+		t.Fatalf("Expected owner 1000:2000 but got %d:%d", opts.uid, opts.gid)
+	}
+	if !opts.hasMode || opts.mode != 0660 {
+		t.Fatalf("Expected mode 0660 but got %v", opts.mode)
+	}
+	if !opts.verifyDirectIO {
+		t.Fatal("Expected O_DIRECT verification to be enabled")
+	}
+}
+
+func TestParseBlockPublishOptionsInvalid(t *testing.T) {
+	for _, attrs := range []map[string]string{
+		{attrBlockUID: "-1"},
+		{attrBlockUID: "root"},
+		{attrBlockGID: "1.5"},
+		{attrBlockMode: "rw"},
+		{attrBlockMode: "0999"},
+		{attrBlockMode: "1777"},
+		{attrBlockVerifyDirectIO: "maybe"},
+	} {
+		if _, err := parseBlockPublishOptions(attrs); err == nil {
+			t.Fatalf("Expected %v to fail validation", attrs)
+		}
+	}
+}
+
+func TestAlignedBuffer(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		buf := alignedBuffer(directIOAlignment, directIOAlignment)
+		if len(buf) != directIOAlignment {
+			t.Fatalf("Expected buffer of length %d but got %d", directIOAlignment, len(buf))
+		}
+		if addr := uintptr(unsafe.Pointer(&buf[0])); addr%directIOAlignment != 0 {
+			t.Fatalf("Expected buffer aligned to %d bytes but got address %x", directIOAlignment, addr)
+		}
+	}
+}
+
+func TestDevicePublications(t *testing.T) {
+	d := blockDevice{path: "/dev/vg/lv1", resolved: "/dev/dm-3"}
+	mounts := []mountpoint{
+		{root: "/dm-3", path: "/target/block-ro", mountopts: []string{"ro", "relatime"}, mountsource: "devtmpfs"},
+		{root: "/", path: "/target/mount-rw", mountopts: []string{"rw", "relatime"}, mountsource: "/dev/vg/lv1"},
+		{root: "/dm-4", path: "/target/other", mountopts: []string{"rw"}, mountsource: "devtmpfs"},
+		{root: "/", path: "/dev", mountopts: []string{"rw"}, mountsource: "devtmpfs"},
+	}
+	exp := map[string]targetInfo{
+		"/target/block-ro": {Readonly: true, Block: true},
+		"/target/mount-rw": {},
+	}
+	if got := devicePublications(d, mounts); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected %+v but got %+v", exp, got)
+	}
+}
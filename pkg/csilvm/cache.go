@@ -0,0 +1,162 @@
+package csilvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// paramCache is the CreateVolume parameter that, if "true", caches
+	// the new volume with dm-cache on the cache devices.
+	paramCache = "cache"
+	// paramCachePolicy is the dm-cache policy, see lvm.CachePolicies.
+	paramCachePolicy = "cachepolicy"
+	// paramCacheSize is the size in bytes of the cache pool.
+	paramCacheSize = "cachesize"
+	// tagCachePrefix prefixes the logical volume tag that records the
+	// cache parameters, e.g., "CA.smq.1073741824" or "CA.default.0".
+	tagCachePrefix = "CA."
+	// cachePoolSuffix is appended to the volume id to name its cache
+	// pool. LVM renames the pool once it is attached.
+	cachePoolSuffix = "-cache"
+	// defaultCacheRatio is the size of a volume divided by the size of
+	// its cache pool if no cachesize is requested.
+	defaultCacheRatio = 10
+)
+
+var ErrInsufficientCacheCapacity = statusError(codes.OutOfRange, newErrorInfo(ReasonInsufficientCapacity, "cache", "true"), "Not enough free space on the cache devices")
+
+// CacheDeviceTag configures the physical volumes with the given LVM tag as
+// cache devices. The cache pools of volumes created with the cache
+// parameter are allocated on them, while the volumes themselves are
+// allocated on the other physical volumes.
+func CacheDeviceTag(tag string) ServerOpt {
+	return func(s *Server) {
+		s.cacheDeviceTag = tag
+	}
+}
+
+// cacheSpec describes the cache requested by CreateVolume.
+type cacheSpec struct {
+	// policy is empty for the LVM default policy.
+	policy string
+	// size is zero for the default size.
+	size uint64
+}
+
+// takeCacheFromParameters consumes the cache parameters. It returns nil if
+// no cache is requested.
+func takeCacheFromParameters(params map[string]string) (*cacheSpec, error) {
+	value, ok := params[paramCache]
+	delete(params, paramCache)
+	policy, hasPolicy := params[paramCachePolicy]
+	delete(params, paramCachePolicy)
+	size, hasSize := params[paramCacheSize]
+	delete(params, paramCacheSize)
+	cache := false
+	if ok {
+		var err error
+		if cache, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("The '%s' parameter must be a boolean: err=%v", paramCache, err)
+		}
+	}
+	if !cache {
+		if hasPolicy || hasSize {
+			return nil, fmt.Errorf("The '%s' and '%s' parameters require '%s' to be true", paramCachePolicy, paramCacheSize, paramCache)
+		}
+		return nil, nil
+	}
+	spec := &cacheSpec{}
+	if hasPolicy {
+		if err := lvm.ValidateCachePolicy(policy); err != nil {
+			return nil, fmt.Errorf("The '%s' parameter is invalid: err=%v", paramCachePolicy, err)
+		}
+		spec.policy = policy
+	}
+	if hasSize {
+		n, err := strconv.ParseUint(size, 10, 64)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("The '%s' parameter must be a positive integer: err=%v", paramCacheSize, err)
+		}
+		spec.size = n
+	}
+	return spec, nil
+}
+
+// cacheToTag returns the tag that records the cache parameters.
+func cacheToTag(spec *cacheSpec) string {
+	policy := spec.policy
+	if policy == "" {
+		policy = "default"
+	}
+	return tagCachePrefix + policy + "." + strconv.FormatUint(spec.size, 10)
+}
+
+// cacheTagFromTags returns the cache tag among the given tags, if any.
+func cacheTagFromTags(tags []string) (string, bool) {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, tagCachePrefix) {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// partitionCacheDevices partitions the physical volumes into the cache
+// devices, i.e., those with the given cache device tag, and the other
+// devices.
+func partitionCacheDevices(pvs []lvm.PhysicalVolumeReport, cacheDeviceTag string) (cache, other []lvm.PhysicalVolumeReport) {
+	for _, pv := range pvs {
+		if containsString(pv.Tags, cacheDeviceTag) {
+			cache = append(cache, pv)
+		} else {
+			other = append(other, pv)
+		}
+	}
+	return cache, other
+}
+
+// cachePoolSize returns the size of the cache pool of a volume of the
+// given size, rounded up to a multiple of the extent size.
+func cachePoolSize(spec *cacheSpec, volumeSize, extentSize uint64) uint64 {
+	size := spec.size
+	if size == 0 {
+		size = volumeSize / defaultCacheRatio
+	}
+	if size < extentSize {
+		size = extentSize
+	}
+	return (size + extentSize - 1) / extentSize * extentSize
+}
+
+// attachCache creates the cache pool of the logical volume on the cache
+// devices and attaches it. If that fails, the logical volume is removed.
+func (s *Server) attachCache(ctx context.Context, lv *lvm.LogicalVolume, plan *volumePlan) error {
+	vg := s.volumeGroup.WithContext(ctx)
+	log.Printf("Creating cache pool of %v bytes for volume id=%v on %v", plan.cacheSize, lv.Name(), plan.cacheDevices)
+	pool, err := vg.CreateCachePool(lv.Name()+cachePoolSuffix, plan.cacheSize, plan.cacheDevices)
+	if err == nil {
+		log.Printf("Attaching cache pool %v to volume id=%v with policy %q", pool.Name(), lv.Name(), plan.cache.policy)
+		if err = lv.AttachCachePool(pool, plan.cache.policy); err != nil {
+			if rerr := pool.Remove(); rerr != nil {
+				log.Printf("Cannot remove cache pool %v: err=%v", pool.Name(), rerr)
+			}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	log.Printf("Cannot cache volume id=%v, removing it: err=%v", lv.Name(), err)
+	if rerr := lv.Remove(); rerr != nil {
+		log.Printf("Cannot remove volume id=%v: err=%v", lv.Name(), rerr)
+	}
+	if err == lvm.ErrNoSpace {
+		return ErrInsufficientCacheCapacity
+	}
+	return s.lvmError(err, "Cannot attach cache pool", "lvname", lv.Name())
+}
@@ -0,0 +1,76 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTakeCacheFromParameters(t *testing.T) {
+	for _, tt := range []struct {
+		params map[string]string
+		spec   *cacheSpec
+		err    bool
+	}{
+		{nil, nil, false},
+		{map[string]string{"cache": "false"}, nil, false},
+		{map[string]string{"cache": "true"}, &cacheSpec{}, false},
+		{map[string]string{"cache": "true", "cachepolicy": "smq", "cachesize": "4194304"}, &cacheSpec{policy: "smq", size: 4194304}, false},
+		{map[string]string{"cache": "yes please"}, nil, true},
+		{map[string]string{"cachepolicy": "smq"}, nil, true},
+		{map[string]string{"cache": "false", "cachesize": "1"}, nil, true},
+		{map[string]string{"cache": "true", "cachepolicy": "lru"}, nil, true},
+		{map[string]string{"cache": "true", "cachesize": "0"}, nil, true},
+		{map[string]string{"cache": "true", "cachesize": "-1"}, nil, true},
+	} {
+		params := dupParams(tt.params)
+		spec, err := takeCacheFromParameters(params)
+		if (err != nil) != tt.err || !reflect.DeepEqual(spec, tt.spec) {
+			t.Fatalf("%v: expected %+v and error %v, got %+v and %v", tt.params, tt.spec, tt.err, spec, err)
+		}
+		if len(params) != 0 {
+			t.Fatalf("%v: expected the cache parameters to be consumed, got %v", tt.params, params)
+		}
+	}
+}
+
+func TestCacheTag(t *testing.T) {
+	for _, tt := range []struct {
+		spec *cacheSpec
+		tag  string
+	}{
+		{&cacheSpec{}, "CA.default.0"},
+		{&cacheSpec{policy: "smq", size: 1 << 30}, "CA.smq.1073741824"},
+	} {
+		tag := cacheToTag(tt.spec)
+		if tag != tt.tag {
+			t.Fatalf("expected %v, got %v", tt.tag, tag)
+		}
+		if got, ok := cacheTagFromTags([]string{"VN.name", tag, "LY.linear"}); !ok || got != tag {
+			t.Fatalf("expected %v, got %v", tag, got)
+		}
+	}
+	if got, ok := cacheTagFromTags([]string{"VN.name", "LY.linear"}); ok {
+		t.Fatalf("expected no cache tag, got %v", got)
+	}
+}
+
+func TestCachePoolSize(t *testing.T) {
+	const extent = 4 << 20
+	for _, tt := range []struct {
+		spec       *cacheSpec
+		volumeSize uint64
+		exp        uint64
+	}{
+		// The default is a tenth of the volume, rounded up.
+		{&cacheSpec{}, 100 * extent, 10 * extent},
+		{&cacheSpec{}, 101 * extent, 11 * extent},
+		// At least one extent.
+		{&cacheSpec{}, extent, extent},
+		{&cacheSpec{size: 1}, 100 * extent, extent},
+		{&cacheSpec{size: 3 * extent}, 100 * extent, 3 * extent},
+	} {
+		if got := cachePoolSize(tt.spec, tt.volumeSize, extent); got != tt.exp {
+			t.Fatalf("%+v of %v: expected %v, got %v", tt.spec, tt.volumeSize, tt.exp, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package csilvm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+)
+
+// The CSI spec v1.5 splits SINGLE_NODE_WRITER into SINGLE_NODE_SINGLE_WRITER
+// and SINGLE_NODE_MULTI_WRITER. The vendored spec predates them so they are
+// declared here with their wire values. Protobuf preserves unknown enum
+// values so COs that send them are understood. Both are treated like
+// SINGLE_NODE_WRITER. The capabilities with which newer plugins report them
+// are not part of the v0 services and so are not reported.
+const (
+	accessModeSingleNodeSingleWriter csi.VolumeCapability_AccessMode_Mode = 6
+	accessModeSingleNodeMultiWriter  csi.VolumeCapability_AccessMode_Mode = 7
+)
+
+// isKnownAccessMode returns whether mode is an access mode of the CSI spec,
+// including the split single node writer modes.
+func isKnownAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case accessModeSingleNodeSingleWriter, accessModeSingleNodeMultiWriter:
+		return true
+	}
+	_, ok := csi.VolumeCapability_AccessMode_Mode_name[int32(mode)]
+	return ok
+}
+
+// checkVolumeCapability returns why the plugin cannot satisfy the given
+// volume capability, or an empty string if it can. The capability has
+// already been validated to have an access type and a known access mode.
+func checkVolumeCapability(volumeCapability *csi.VolumeCapability, supportedFilesystems map[string]string) string {
+	if mnt := volumeCapability.GetMount(); mnt != nil {
+		if _, ok := supportedFilesystems[mnt.GetFsType()]; !ok {
+			return fmt.Sprintf("filesystem type %q is not supported", mnt.GetFsType())
+		}
+	}
+	mode := volumeCapability.GetAccessMode().GetMode()
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		accessModeSingleNodeSingleWriter,
+		accessModeSingleNodeMultiWriter:
+	default:
+		return fmt.Sprintf("access mode %v is not supported", mode)
+	}
+	return ""
+}
+
+// checkVolumeCapabilities checks each of the volume capabilities and
+// returns whether all of them are supported along with a message that
+// lists, per capability, whether it was confirmed or denied and why.
+func checkVolumeCapabilities(volumeCapabilities []*csi.VolumeCapability, supportedFilesystems map[string]string) (supported bool, message string) {
+	supported = true
+	var results []string
+	for i, volumeCapability := range volumeCapabilities {
+		if reason := checkVolumeCapability(volumeCapability, supportedFilesystems); reason != "" {
+			supported = false
+			results = append(results, fmt.Sprintf("volume_capabilities[%d]: denied: %s", i, reason))
+		} else {
+			results = append(results, fmt.Sprintf("volume_capabilities[%d]: confirmed", i))
+		}
+	}
+	return supported, strings.Join(results, "; ")
+}
+
+// checkVolumeAttributes checks the volume attributes of a
+// ValidateVolumeCapabilities request against those recorded by the tags of
+// the volume, i.e., the tags, metadata and name hash attributes, which
+// CreateVolume reported and which do not change. The vendored CSI spec
+// predates the confirmed field of the response so, like
+// checkVolumeCapabilities, it returns whether they match along with a
+// message that lists, per attribute, whether it was confirmed or denied and
+// why. Other attributes, e.g., those that describe the condition of the
+// volume when it was listed, are listed as not validated.
+func checkVolumeAttributes(requested, recorded map[string]string) (supported bool, message string) {
+	supported = true
+	var keys []string
+	for key := range requested {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var results []string
+	for _, key := range keys {
+		if key != attrTags && !strings.HasPrefix(key, attrMetadataPrefix) {
+			results = append(results, fmt.Sprintf("volume_attributes[%s]: not validated", key))
+			continue
+		}
+		value, ok := recorded[key]
+		switch {
+		case !ok:
+			supported = false
+			results = append(results, fmt.Sprintf("volume_attributes[%s]: denied: the volume has no such attribute", key))
+		case value != requested[key]:
+			supported = false
+			results = append(results, fmt.Sprintf("volume_attributes[%s]: denied: the volume has %q", key, value))
+		default:
+			results = append(results, fmt.Sprintf("volume_attributes[%s]: confirmed", key))
+		}
+	}
+	return supported, strings.Join(results, "; ")
+}
@@ -0,0 +1,128 @@
+package csilvm
+
+import (
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+)
+
+func testCapability(fstype string, mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+	capability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+	if fstype == "block" {
+		capability.AccessType = &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		}
+	} else {
+		capability.AccessType = &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{FsType: fstype},
+		}
+	}
+	return capability
+}
+
+func TestCheckVolumeCapabilities(t *testing.T) {
+	supportedFilesystems := map[string]string{"": "xfs", "xfs": "xfs"}
+	cases := []struct {
+		capabilities []*csi.VolumeCapability
+		supported    bool
+		message      string
+	}{
+		{
+			[]*csi.VolumeCapability{
+				testCapability("block", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+				testCapability("", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+				testCapability("xfs", csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY),
+			},
+			true,
+			"volume_capabilities[0]: confirmed; volume_capabilities[1]: confirmed; volume_capabilities[2]: confirmed",
+		},
+		{
+			[]*csi.VolumeCapability{
+				testCapability("ext4", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			false,
+			`volume_capabilities[0]: denied: filesystem type "ext4" is not supported`,
+		},
+		{
+			[]*csi.VolumeCapability{
+				testCapability("xfs", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+				testCapability("xfs", csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+			},
+			false,
+			"volume_capabilities[0]: confirmed; volume_capabilities[1]: denied: access mode MULTI_NODE_READER_ONLY is not supported",
+		},
+		{
+			[]*csi.VolumeCapability{
+				testCapability("block", csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY),
+			},
+			true,
+			"volume_capabilities[0]: confirmed",
+		},
+		{
+			[]*csi.VolumeCapability{
+				testCapability("block", accessModeSingleNodeSingleWriter),
+				testCapability("xfs", accessModeSingleNodeMultiWriter),
+			},
+			true,
+			"volume_capabilities[0]: confirmed; volume_capabilities[1]: confirmed",
+		},
+	}
+	for i, tt := range cases {
+		supported, message := checkVolumeCapabilities(tt.capabilities, supportedFilesystems)
+		if supported != tt.supported || message != tt.message {
+			t.Fatalf("test case %d: expected (%v, %q) but got (%v, %q)", i, tt.supported, tt.message, supported, message)
+		}
+	}
+}
+
+func TestCheckVolumeAttributes(t *testing.T) {
+	recorded := map[string]string{
+		attrTags:                   "WyJWTi50ZXN0Il0",
+		attrMetadataPrefix + "pvc": "data-0",
+	}
+	cases := []struct {
+		requested map[string]string
+		supported bool
+		message   string
+	}{
+		{
+			map[string]string{attrTags: "WyJWTi50ZXN0Il0", attrMetadataPrefix + "pvc": "data-0"},
+			true,
+			"volume_attributes[metadata.pvc]: confirmed; volume_attributes[tags]: confirmed",
+		},
+		{
+			map[string]string{attrExtentSize: "4194304", attrConditionAbnormal: "true"},
+			true,
+			"volume_attributes[condition-abnormal]: not validated; volume_attributes[extent-size]: not validated",
+		},
+		{
+			map[string]string{attrMetadataPrefix + "pvc": "data-1"},
+			false,
+			"volume_attributes[metadata.pvc]: denied: the volume has \"data-0\"",
+		},
+	}
+	for i, tt := range cases {
+		supported, message := checkVolumeAttributes(tt.requested, recorded)
+		if supported != tt.supported || message != tt.message {
+			t.Fatalf("test case %d: expected (%v, %q) but got (%v, %q)", i, tt.supported, tt.message, supported, message)
+		}
+	}
+}
+
+func TestIsKnownAccessMode(t *testing.T) {
+	for _, mode := range []csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		accessModeSingleNodeSingleWriter,
+		accessModeSingleNodeMultiWriter,
+	} {
+		if !isKnownAccessMode(mode) {
+			t.Fatalf("expected %v to be known", mode)
+		}
+	}
+	if isKnownAccessMode(1000) {
+		t.Fatal("expected 1000 to be unknown")
+	}
+}
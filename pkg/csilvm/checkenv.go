@@ -0,0 +1,192 @@
+package csilvm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"github.com/mesosphere/csilvm/pkg/version"
+	"golang.org/x/net/context"
+)
+
+// EnvCheck is the outcome of one of the checks run by CheckEnv.
+type EnvCheck struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Detail describes what was found, e.g., the kernel version or the
+	// missing tools.
+	Detail string `json:"detail,omitempty"`
+	// Remediation describes how to fix a failed check.
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// EnvReport is the result of CheckEnv. It is produced by `csilvm check-env`.
+type EnvReport struct {
+	CheckedAt time.Time       `json:"checkedAt"`
+	Version   version.Version `json:"version"`
+	Checks    []EnvCheck      `json:"checks"`
+	// OK is set if all checks passed.
+	OK bool `json:"ok"`
+}
+
+func (r *EnvReport) add(check EnvCheck) {
+	r.Checks = append(r.Checks, check)
+	if !check.OK {
+		r.OK = false
+	}
+}
+
+// CheckEnv runs the checks of Setup and Probe against the node without
+// changing anything, e.g., before the plugin is started by a node bootstrap
+// script: the kernel version, the kernel modules, the required tools, the
+// container mounts, the version of LVM, whether the devices can be opened
+// and whether the volume group exists. Unlike Setup it never loads modules,
+// creates the volume group or initializes devices. Failures are recorded in
+// the report rather than aborting the checks.
+func (s *Server) CheckEnv(ctx context.Context) *EnvReport {
+	r := &EnvReport{
+		CheckedAt: time.Now().UTC(),
+		Version:   version.Get(),
+		OK:        true,
+	}
+	r.add(s.checkEnvKernelVersion())
+	r.add(s.checkEnvModules(ctx))
+	r.add(s.checkEnvTools())
+	r.add(s.checkEnvContainer())
+	r.add(checkEnvLVMVersion(ctx))
+	for _, pvname := range s.pvnames {
+		if pvname != "" {
+			r.add(checkEnvDevice(pvname))
+		}
+	}
+	if s.vgname != "" {
+		r.add(s.checkEnvVolumeGroup())
+	}
+	return r
+}
+
+func (s *Server) checkEnvKernelVersion() EnvCheck {
+	check := EnvCheck{Name: "kernel-version"}
+	release, ok, err := s.checkKernelVersion()
+	if err != nil {
+		check.Detail = fmt.Sprintf("Cannot determine kernel version: err=%v", err)
+		return check
+	}
+	if release == "" {
+		// No minimum version is configured.
+		release, _ = kernelVersion()
+	}
+	check.OK = ok
+	check.Detail = release
+	if !ok {
+		check.Detail = fmt.Sprintf("The kernel version %v is older than the required version %v", release, s.minKernelVersion)
+		check.Remediation = fmt.Sprintf("Upgrade the kernel to version %v or later.", s.minKernelVersion)
+	}
+	return check
+}
+
+func (s *Server) checkEnvModules(ctx context.Context) EnvCheck {
+	check := EnvCheck{Name: "kernel-modules"}
+	missing, err := s.missingModules(ctx, false)
+	if err != nil {
+		check.Detail = fmt.Sprintf("Cannot resolve kernel modules: err=%v", err)
+		return check
+	}
+	if len(missing) > 0 {
+		check.Detail = fmt.Sprintf("One or more kernel modules are missing: %v", missing)
+		check.Remediation = modulesRemediation(missing)
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+func (s *Server) checkEnvTools() EnvCheck {
+	check := EnvCheck{Name: "tools"}
+	if missing := missingTools(s.requiredTools()); len(missing) > 0 {
+		check.Detail = fmt.Sprintf("One or more required tools are missing from $PATH: %v", missing)
+		check.Remediation = "Install the packages that provide the tools or add their directories to $PATH."
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+func (s *Server) checkEnvContainer() EnvCheck {
+	check := EnvCheck{Name: "container"}
+	problems, warnings, err := s.checkContainer()
+	if err != nil {
+		check.Detail = fmt.Sprintf("Cannot check container mounts: err=%v", err)
+		return check
+	}
+	if len(problems) > 0 {
+		check.Detail = strings.Join(problems, "; ")
+		check.Remediation = fmt.Sprintf("Start the container with the mounts %v.", requiredContainerMounts(s.publishDir))
+		return check
+	}
+	check.OK = true
+	check.Detail = strings.Join(warnings, "; ")
+	return check
+}
+
+func checkEnvLVMVersion(ctx context.Context) EnvCheck {
+	check := EnvCheck{Name: "lvm-version"}
+	v, err := lvm.Version(ctx)
+	if err != nil {
+		check.Detail = fmt.Sprintf("Cannot determine the LVM version: err=%v", err)
+		check.Remediation = "Install the LVM tools, e.g., the lvm2 package."
+		return check
+	}
+	check.OK = true
+	check.Detail = v
+	return check
+}
+
+// checkEnvDevice checks that the device exists, is a block device and can
+// be opened for reading.
+func checkEnvDevice(path string) EnvCheck {
+	check := EnvCheck{Name: "device " + path}
+	fi, err := os.Stat(path)
+	if err != nil {
+		check.Detail = fmt.Sprintf("Cannot stat device: err=%v", err)
+		check.Remediation = "Check the device path given by -devices and, if the plugin runs in a container, that the host's /dev is mounted."
+		return check
+	}
+	if fi.Mode()&os.ModeDevice == 0 || fi.Mode()&os.ModeCharDevice != 0 {
+		check.Detail = fmt.Sprintf("Not a block device: %v", fi.Mode())
+		return check
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		check.Detail = fmt.Sprintf("Cannot open device: err=%v", err)
+		check.Remediation = "Run the plugin as root or, if it runs in a container, as a privileged container."
+		return check
+	}
+	f.Close()
+	check.OK = true
+	return check
+}
+
+// checkEnvVolumeGroup checks whether the volume group exists. A volume
+// group that does not exist is fine if devices are given as Setup creates
+// it on them.
+func (s *Server) checkEnvVolumeGroup() EnvCheck {
+	check := EnvCheck{Name: "volume-group " + s.vgname}
+	_, err := lvm.LookupVolumeGroup(s.vgname)
+	switch {
+	case err == nil:
+		check.OK = true
+		check.Detail = "exists"
+	case err == lvm.ErrVolumeGroupNotFound && len(s.pvnames) > 0 && s.pvnames[0] != "":
+		check.OK = true
+		check.Detail = "does not exist, Setup creates it on the devices"
+	case err == lvm.ErrVolumeGroupNotFound:
+		check.Detail = "does not exist and no devices are given"
+		check.Remediation = "Create the volume group or give the devices on which it is created."
+	default:
+		check.Detail = fmt.Sprintf("Cannot look up volume group: err=%v", err)
+	}
+	return check
+}
@@ -0,0 +1,37 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCheckEnvDevice(t *testing.T) {
+	f, err := ioutil.TempFile("", "csilvm-checkenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if check := checkEnvDevice(f.Name()); check.OK {
+		t.Fatalf("Expected a regular file to fail the check: %+v", check)
+	}
+	if check := checkEnvDevice(f.Name() + "-missing"); check.OK || check.Remediation == "" {
+		t.Fatalf("Expected a missing device to fail the check with a remediation: %+v", check)
+	}
+}
+
+func TestCheckEnvTools(t *testing.T) {
+	s := NewServer("vg", nil, "xfs", ProbeTools([]string{"csilvm-no-such-tool"}))
+	check := s.checkEnvTools()
+	if check.OK {
+		t.Fatalf("Expected a missing tool to fail the check: %+v", check)
+	}
+	r := &EnvReport{OK: true}
+	r.add(EnvCheck{Name: "a", OK: true})
+	r.add(check)
+	r.add(EnvCheck{Name: "b", OK: true})
+	if r.OK || len(r.Checks) != 3 {
+		t.Fatalf("Expected a failed report with 3 checks: %+v", r)
+	}
+}
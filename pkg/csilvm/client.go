@@ -9,6 +9,7 @@ type Client struct {
 	csi.IdentityClient
 	csi.ControllerClient
 	csi.NodeClient
+	ModifyClient
 }
 
 func NewClient(conn *grpc.ClientConn) *Client {
@@ -16,5 +17,6 @@ func NewClient(conn *grpc.ClientConn) *Client {
 		csi.NewIdentityClient(conn),
 		csi.NewControllerClient(conn),
 		csi.NewNodeClient(conn),
+		NewModifyClient(conn),
 	}
 }
@@ -0,0 +1,129 @@
+package csilvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+// Config holds the server options that ReloadConfig changes at runtime,
+// without restarting the plugin or interrupting in-flight requests. It is
+// read from a JSON file, e.g.,
+//
+//	{
+//	  "supported_filesystems": ["ext4"],
+//	  "volume_tags": ["team.storage"],
+//	  "default_volume_size": 1073741824
+//	}
+type Config struct {
+	// SupportedFilesystems are supported in addition to those
+	// configured with SupportedFilesystem.
+	SupportedFilesystems []string `json:"supported_filesystems"`
+	// VolumeTags are added to new volumes in addition to the volume
+	// group tags configured with Tag. Unlike those, they are not
+	// applied to the volume group.
+	VolumeTags []string `json:"volume_tags"`
+	// DefaultVolumeSize, if non-zero, overrides the size configured
+	// with DefaultVolumeSize.
+	DefaultVolumeSize uint64 `json:"default_volume_size"`
+}
+
+// readConfig decodes the config file at path.
+func readConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("cannot parse %v: %v", path, err)
+	}
+	return config, nil
+}
+
+// ReloadConfig reads the config file at path and applies it to future
+// requests, replacing the config of any previous call. Requests that are in
+// flight complete with the config they started with. If the file cannot be
+// read or is invalid, an error is returned and the previous config is kept.
+func (s *Server) ReloadConfig(path string) error {
+	config, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := s.validateConfig(config); err != nil {
+		return fmt.Errorf("invalid config in %v: %v", path, err)
+	}
+	s.configMu.Lock()
+	s.config = config
+	s.configMu.Unlock()
+	log.Printf("Loaded config from %v: supported filesystems %v, volume tags %v, default volume size %v",
+		path, config.SupportedFilesystems, config.VolumeTags, config.DefaultVolumeSize)
+	return nil
+}
+
+// validateConfig checks that the tags of config are valid and that
+// mkfs.<fstype> can be found in $PATH for each of its filesystems.
+func (s *Server) validateConfig(config *Config) error {
+	for _, tag := range config.VolumeTags {
+		if err := lvm.ValidateTag(tag); err != nil {
+			return fmt.Errorf("invalid volume tag %q: %v", tag, err)
+		}
+	}
+	var tools []string
+	for _, fstype := range config.SupportedFilesystems {
+		if fstype == "" {
+			return fmt.Errorf("empty filesystem type")
+		}
+		tools = append(tools, "mkfs."+fstype)
+	}
+	if missing := missingTools(tools); len(missing) > 0 {
+		return fmt.Errorf("cannot find %v in $PATH", missing)
+	}
+	return nil
+}
+
+// currentConfig returns the config last loaded by ReloadConfig. The
+// returned config must not be modified.
+func (s *Server) currentConfig() *Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	if s.config == nil {
+		return &Config{}
+	}
+	return s.config
+}
+
+// defaultSize returns the size of new volumes if none is requested.
+func (s *Server) defaultSize() uint64 {
+	if size := s.currentConfig().DefaultVolumeSize; size != 0 {
+		return size
+	}
+	return s.defaultVolumeSize
+}
+
+// volumeTags returns the tags of new volumes: the volume group tags
+// followed by the sorted volume tags of the current config.
+func (s *Server) volumeTags() []string {
+	extra := append([]string(nil), s.currentConfig().VolumeTags...)
+	sort.Strings(extra)
+	tags := make([]string, 0, len(s.tags)+len(extra)+1)
+	tags = append(tags, s.tags...)
+	for _, tag := range extra {
+		if !containsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+)
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-config-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "mkfs.ext4"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+	path := filepath.Join(dir, "config.json")
+	s := NewServer("vg", nil, "xfs", Tag("vgtag"), DefaultVolumeSize(100))
+
+	if err := ioutil.WriteFile(path, []byte(`{"supported_filesystems": ["ext4"], "volume_tags": ["b", "a", "vgtag"], "default_volume_size": 200}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{"": "xfs", "xfs": "xfs", "ext4": "ext4"}
+	if got := s.SupportedFilesystems(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected supported filesystems %v, got %v", exp, got)
+	}
+	if got := s.volumeTags(); !reflect.DeepEqual(got, []string{"vgtag", "a", "b"}) {
+		t.Fatalf("unexpected volume tags %v", got)
+	}
+	if got := s.requestedSize(&csi.CreateVolumeRequest{}); got != 200 {
+		t.Fatalf("expected default size 200, got %d", got)
+	}
+
+	// An invalid config is rejected and the previous config is kept.
+	for _, config := range []string{
+		`{"supported_filesystems": ["btrfs"]}`,
+		`{"volume_tags": ["in valid"]}`,
+		`{"supported_filesystems": [""]}`,
+		`{`,
+	} {
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ReloadConfig(path); err == nil {
+			t.Fatalf("expected %s to be rejected", config)
+		}
+	}
+	if got := s.requestedSize(&csi.CreateVolumeRequest{}); got != 200 {
+		t.Fatalf("expected default size 200, got %d", got)
+	}
+
+	// Values removed from the config revert to the configured options.
+	if err := ioutil.WriteFile(path, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	exp = map[string]string{"": "xfs", "xfs": "xfs"}
+	if got := s.SupportedFilesystems(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected supported filesystems %v, got %v", exp, got)
+	}
+	if got := s.volumeTags(); !reflect.DeepEqual(got, []string{"vgtag"}) {
+		t.Fatalf("unexpected volume tags %v", got)
+	}
+	if got := s.requestedSize(&csi.CreateVolumeRequest{}); got != 100 {
+		t.Fatalf("expected default size 100, got %d", got)
+	}
+}
+
+func TestSupportedFilesystemsOf(t *testing.T) {
+	s := NewServer("vg", nil, "xfs")
+	s.config = &Config{SupportedFilesystems: []string{"ext4"}}
+	exp := map[string]string{"": "xfs", "xfs": "xfs", "ext4": "ext4", "btrfs": "btrfs"}
+	if got := supportedFilesystemsOf(s, map[string]string{"btrfs": "btrfs"}); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	static := map[string]string{"xfs": "xfs"}
+	if got := supportedFilesystemsOf(nil, static); !reflect.DeepEqual(got, static) {
+		t.Fatalf("expected %v, got %v", static, got)
+	}
+}
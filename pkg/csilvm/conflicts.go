@@ -0,0 +1,189 @@
+package csilvm
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+// The host files that checkDeviceConflicts consults. They are variables so
+// that tests can replace them.
+var (
+	// sysClassBlock lists the block devices and their holders.
+	sysClassBlock = "/sys/class/block"
+	// fstabPath lists the filesystems that systemd mounts at boot.
+	fstabPath = "/etc/fstab"
+	// lvmDevicesFile lists the devices the host's LVM may use if it
+	// exists, see lvmdevices(8).
+	lvmDevicesFile = "/etc/lvm/devices/system.devices"
+)
+
+// AllowDeviceConflicts configures Setup to only log the conflicts found by
+// checkDeviceConflicts instead of failing, e.g., for known false positives.
+func AllowDeviceConflicts() ServerOpt {
+	return func(s *Server) {
+		s.ignoreDeviceConflicts = true
+	}
+}
+
+// checkDeviceConflicts returns an error that lists the reasons to believe
+// that the physical volumes or the volume group are also managed by
+// something other than the plugin, e.g., another CSI driver or a systemd
+// mount, which would corrupt their shared state.
+func (s *Server) checkDeviceConflicts() error {
+	var conflicts []string
+	for _, pvname := range s.pvnames {
+		conflicts = append(conflicts, deviceHolderConflicts(pvname)...)
+	}
+	fstab, err := fstabConflicts(s.vgname, s.pvnames)
+	if err != nil {
+		return err
+	}
+	conflicts = append(conflicts, fstab...)
+	// The host's LVM ignoring the devices is intended if the plugin uses
+	// its own devices file.
+	if lvm.DevicesFile() == "" {
+		devicesFile, err := devicesFileConflicts(s.pvnames)
+		if err != nil {
+			return err
+		}
+		conflicts = append(conflicts, devicesFile...)
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	for _, conflict := range conflicts {
+		log.Printf("Device conflict: %v", conflict)
+	}
+	s.metrics.Gauge("device-conflicts").Update(float64(len(conflicts)))
+	if s.ignoreDeviceConflicts {
+		log.Printf("Ignoring %d device conflicts", len(conflicts))
+		return nil
+	}
+	return fmt.Errorf("The devices or volume group %v appear to be managed by something else: %v", s.vgname, strings.Join(conflicts, "; "))
+}
+
+// deviceHolderConflicts returns the holders of the device other than
+// logical volumes, e.g., dm-crypt, multipath or md devices set up by
+// another driver. A device that does not exist has no holders.
+func deviceHolderConflicts(devicePath string) []string {
+	resolved, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return nil
+	}
+	holdersDir := filepath.Join(sysClassBlock, filepath.Base(resolved), "holders")
+	holders, err := ioutil.ReadDir(holdersDir)
+	if err != nil {
+		return nil
+	}
+	var conflicts []string
+	for _, holder := range holders {
+		uuid, err := ioutil.ReadFile(filepath.Join(sysClassBlock, holder.Name(), "dm", "uuid"))
+		if err == nil && strings.HasPrefix(string(uuid), "LVM-") {
+			continue
+		}
+		what := holder.Name()
+		if err == nil {
+			what = fmt.Sprintf("%v with dm uuid %v", holder.Name(), strings.TrimSpace(string(uuid)))
+		}
+		conflicts = append(conflicts, fmt.Sprintf("device %v is held by %v", devicePath, what))
+	}
+	return conflicts
+}
+
+// fstabConflicts returns the fstab entries that mount the devices or the
+// logical volumes of the volume group. Entries that identify the device by
+// UUID or LABEL are not resolved.
+func fstabConflicts(vgname string, devicePaths []string) ([]string, error) {
+	f, err := os.Open(fstabPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	devices := resolvedPaths(devicePaths)
+	lvPrefixes := []string{
+		"/dev/" + vgname + "/",
+		"/dev/mapper/" + strings.Replace(vgname, "-", "--", -1) + "-",
+	}
+	var conflicts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		spec, mountPath := fields[0], fields[1]
+		matches := devices[spec]
+		if resolved, err := filepath.EvalSymlinks(spec); err == nil && devices[resolved] {
+			matches = true
+		}
+		for _, prefix := range lvPrefixes {
+			if strings.HasPrefix(spec, prefix) {
+				matches = true
+			}
+		}
+		if matches {
+			conflicts = append(conflicts, fmt.Sprintf("%v mounts %v at %v", fstabPath, spec, mountPath))
+		}
+	}
+	return conflicts, scanner.Err()
+}
+
+// devicesFileConflicts returns the devices that the LVM devices file, if
+// there is one, does not list. The host's LVM ignores them and considers
+// them unused, so that another driver may claim them.
+func devicesFileConflicts(devicePaths []string) ([]string, error) {
+	f, err := os.Open(lvmDevicesFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		for _, field := range strings.Fields(scanner.Text()) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) == 2 && (kv[0] == "DEVNAME" || kv[0] == "IDNAME") {
+				listed[kv[1]] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var conflicts []string
+	for _, devicePath := range devicePaths {
+		resolved, err := filepath.EvalSymlinks(devicePath)
+		if err != nil {
+			resolved = devicePath
+		}
+		if !listed[devicePath] && !listed[resolved] {
+			conflicts = append(conflicts, fmt.Sprintf("device %v is not listed in %v", devicePath, lvmDevicesFile))
+		}
+	}
+	return conflicts, nil
+}
+
+// resolvedPaths returns a set of the given paths and the paths they
+// resolve to.
+func resolvedPaths(paths []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, path := range paths {
+		set[path] = true
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			set[resolved] = true
+		}
+	}
+	return set
+}
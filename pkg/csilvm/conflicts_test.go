@@ -0,0 +1,106 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// withConflictFiles points the files consulted by checkDeviceConflicts into
+// a temporary directory and returns it along with a cleanup function.
+func withConflictFiles(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "csilvm-conflicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSys, oldFstab, oldDevices := sysClassBlock, fstabPath, lvmDevicesFile
+	sysClassBlock = filepath.Join(dir, "sys")
+	fstabPath = filepath.Join(dir, "fstab")
+	lvmDevicesFile = filepath.Join(dir, "system.devices")
+	return dir, func() {
+		sysClassBlock, fstabPath, lvmDevicesFile = oldSys, oldFstab, oldDevices
+		os.RemoveAll(dir)
+	}
+}
+
+func writeConflictFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeviceHolderConflicts(t *testing.T) {
+	dir, cleanup := withConflictFiles(t)
+	defer cleanup()
+	device := filepath.Join(dir, "dev", "sdb")
+	writeConflictFile(t, device, "")
+	writeConflictFile(t, filepath.Join(sysClassBlock, "sdb", "holders", "dm-0"), "")
+	writeConflictFile(t, filepath.Join(sysClassBlock, "sdb", "holders", "dm-1"), "")
+	writeConflictFile(t, filepath.Join(sysClassBlock, "sdb", "holders", "md0"), "")
+	writeConflictFile(t, filepath.Join(sysClassBlock, "dm-0", "dm", "uuid"), "LVM-abcdef\n")
+	writeConflictFile(t, filepath.Join(sysClassBlock, "dm-1", "dm", "uuid"), "CRYPT-LUKS2-0123-data\n")
+	exp := []string{
+		"device " + device + " is held by dm-1 with dm uuid CRYPT-LUKS2-0123-data",
+		"device " + device + " is held by md0",
+	}
+	if got := deviceHolderConflicts(device); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v but got %v", exp, got)
+	}
+	if got := deviceHolderConflicts(filepath.Join(dir, "dev", "sdc")); got != nil {
+		t.Fatalf("expected no conflicts for a missing device but got %v", got)
+	}
+}
+
+func TestFstabConflicts(t *testing.T) {
+	_, cleanup := withConflictFiles(t)
+	defer cleanup()
+	if got, err := fstabConflicts("data-vg", []string{"/dev/sdb"}); err != nil || got != nil {
+		t.Fatalf("expected no conflicts without an fstab but got %v, err=%v", got, err)
+	}
+	writeConflictFile(t, fstabPath, `# /etc/fstab
+/dev/sda1 / ext4 defaults 0 1
+UUID=0123 /boot ext4 defaults 0 2
+/dev/sdb /mnt/sdb xfs defaults 0 0
+/dev/data-vg/lv1 /mnt/lv1 xfs defaults 0 0
+/dev/mapper/data--vg-lv2 /mnt/lv2 xfs defaults 0 0
+/dev/mapper/data-other /mnt/other xfs defaults 0 0
+`)
+	exp := []string{
+		fstabPath + " mounts /dev/sdb at /mnt/sdb",
+		fstabPath + " mounts /dev/data-vg/lv1 at /mnt/lv1",
+		fstabPath + " mounts /dev/mapper/data--vg-lv2 at /mnt/lv2",
+	}
+	got, err := fstabConflicts("data-vg", []string{"/dev/sdb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v but got %v", exp, got)
+	}
+}
+
+func TestDevicesFileConflicts(t *testing.T) {
+	_, cleanup := withConflictFiles(t)
+	defer cleanup()
+	if got, err := devicesFileConflicts([]string{"/dev/sdb"}); err != nil || got != nil {
+		t.Fatalf("expected no conflicts without a devices file but got %v, err=%v", got, err)
+	}
+	writeConflictFile(t, lvmDevicesFile, `# LVM uses devices listed in this file.
+VERSION=1.1.2
+IDTYPE=sys_wwid IDNAME=naa.0123 DEVNAME=/dev/sdb PVID=abc
+IDTYPE=devname IDNAME=/dev/sdc DEVNAME=/dev/sdc PVID=def
+`)
+	exp := []string{"device /dev/sdd is not listed in " + lvmDevicesFile}
+	got, err := devicesFileConflicts([]string{"/dev/sdb", "/dev/sdc", "/dev/sdd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v but got %v", exp, got)
+	}
+}
@@ -0,0 +1,133 @@
+package csilvm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// containerMarkers are files that container runtimes create in the root
+// of a container's filesystem.
+var containerMarkers = []string{"/.dockerenv", "/run/.containerenv"}
+
+// containerCgroupMarkers are substrings of the cgroup paths of processes
+// that run in a container.
+var containerCgroupMarkers = []string{"docker", "kubepods", "containerd", "libpod", "lxc"}
+
+// devMapperControl is the device node through which LVM talks to the
+// device-mapper.
+const devMapperControl = "/dev/mapper/control"
+
+// PublishDir configures the directory under which the CO publishes
+// volumes, e.g., /var/lib/kubelet. If the plugin runs in a container,
+// Setup and Probe check that dir is on a mount with shared propagation so
+// that the volumes the plugin mounts are visible to the host.
+func PublishDir(dir string) ServerOpt {
+	return func(s *Server) {
+		s.publishDir = filepath.Clean(dir)
+	}
+}
+
+// isContainerized returns whether the plugin runs in a container.
+func isContainerized() bool {
+	if os.Getenv("container") != "" {
+		// Set by systemd-nspawn, podman and lxc.
+		return true
+	}
+	for _, marker := range containerMarkers {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	buf, err := ioutil.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	return isContainerCgroup(buf)
+}
+
+// isContainerCgroup returns whether the contents of /proc/<pid>/cgroup
+// indicate that the process runs in a container.
+func isContainerCgroup(buf []byte) bool {
+	for _, line := range strings.Split(string(buf), "\n") {
+		// Each line has the form hierarchy-ID:controller-list:cgroup-path.
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, marker := range containerCgroupMarkers {
+			if strings.Contains(fields[2], marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mountFor returns the mount that contains path, i.e., the last listed
+// mount whose mount point is the longest prefix of path.
+func mountFor(mounts []mountpoint, path string) *mountpoint {
+	var found *mountpoint
+	for i := range mounts {
+		mp := &mounts[i]
+		if mp.path != "/" && path != mp.path && !strings.HasPrefix(path, mp.path+"/") {
+			continue
+		}
+		if found == nil || len(mp.path) >= len(found.path) {
+			found = mp
+		}
+	}
+	return found
+}
+
+// requiredContainerMounts returns the host paths that must be bind mounted
+// into the plugin's container, in the form host-path:container-path[:propagation].
+func requiredContainerMounts(publishDir string) []string {
+	required := []string{"/dev:/dev"}
+	if publishDir != "" {
+		required = append(required, publishDir+":"+publishDir+":rshared")
+	}
+	return required
+}
+
+// containerProblems returns a description of each way in which the mounts
+// of the plugin's container prevent it from working (problems) or may
+// cause surprising behaviour (warnings).
+func containerProblems(mounts []mountpoint, publishDir string, hasDevMapperControl bool) (problems, warnings []string) {
+	if !hasDevMapperControl {
+		problems = append(problems, fmt.Sprintf(
+			"%v does not exist, bind mount the host's /dev into the container",
+			devMapperControl))
+	}
+	if mp := mountFor(mounts, "/dev"); mp == nil || mp.fstype != "devtmpfs" {
+		warnings = append(warnings,
+			"/dev is not the host's devtmpfs, device nodes of new volumes may not appear in the container")
+	}
+	if publishDir == "" {
+		warnings = append(warnings,
+			"no publish dir is configured, cannot check mount propagation")
+	} else if mp := mountFor(mounts, publishDir); mp == nil || !mp.isShared() {
+		problems = append(problems, fmt.Sprintf(
+			"%v is not on a mount with shared propagation, bind mount it from the host with rshared propagation",
+			publishDir))
+	}
+	return problems, warnings
+}
+
+// checkContainer returns the problems and warnings with the mounts of the
+// plugin's container. It returns no problems if the plugin does not run in
+// a container.
+func (s *Server) checkContainer() (problems, warnings []string, err error) {
+	if !isContainerized() {
+		return nil, nil, nil
+	}
+	mounts, err := listMounts()
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = os.Stat(devMapperControl)
+	problems, warnings = containerProblems(mounts, s.publishDir, err == nil)
+	return problems, warnings, nil
+}
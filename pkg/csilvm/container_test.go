@@ -0,0 +1,83 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsContainerCgroup(t *testing.T) {
+	cases := []struct {
+		cgroup string
+		exp    bool
+	}{
+		{"12:pids:/init.scope\n1:name=systemd:/init.scope\n0::/init.scope\n", false},
+		{"0::/\n", false},
+		{"12:pids:/docker/0123abcd\n1:name=systemd:/docker/0123abcd\n", true},
+		{"0::/kubepods.slice/kubepods-burstable.slice/cri-containerd-0123abcd.scope\n", true},
+	}
+	for i, tt := range cases {
+		if got := isContainerCgroup([]byte(tt.cgroup)); got != tt.exp {
+			t.Fatalf("test case %d: expected %v but got %v", i, tt.exp, got)
+		}
+	}
+}
+
+func TestMountFor(t *testing.T) {
+	mounts := []mountpoint{
+		{path: "/"},
+		{path: "/var/lib", fstype: "xfs"},
+		{path: "/var/lib/kubelet", fstype: "ext4"},
+		{path: "/var/lib/kubelet", fstype: "tmpfs"},
+	}
+	cases := []struct {
+		path string
+		exp  string
+	}{
+		{"/", "/"},
+		{"/var", "/"},
+		{"/var/lib", "/var/lib"},
+		{"/var/lib/kube", "/var/lib"},
+		{"/var/lib/kubelet/pods", "/var/lib/kubelet"},
+	}
+	for i, tt := range cases {
+		if got := mountFor(mounts, tt.path); got == nil || got.path != tt.exp {
+			t.Fatalf("test case %d: expected %v but got %v", i, tt.exp, got)
+		}
+	}
+	// The last of several mounts at the same path is visible.
+	if got := mountFor(mounts, "/var/lib/kubelet"); got.fstype != "tmpfs" {
+		t.Fatalf("expected the tmpfs mount but got %v", got)
+	}
+}
+
+func TestContainerProblems(t *testing.T) {
+	mounts := []mountpoint{
+		{path: "/", fstype: "overlay"},
+		{path: "/dev", fstype: "devtmpfs"},
+		{path: "/var/lib/kubelet", fstype: "xfs", optional: []string{"shared:42"}},
+		{path: "/var/lib/mesos", fstype: "xfs", optional: []string{"master:3"}},
+	}
+	problems, warnings := containerProblems(mounts, "/var/lib/kubelet", true)
+	if len(problems) != 0 || len(warnings) != 0 {
+		t.Fatalf("expected no problems or warnings but got %v and %v", problems, warnings)
+	}
+	problems, _ = containerProblems(mounts, "/var/lib/mesos", false)
+	exp := []string{
+		"/dev/mapper/control does not exist, bind mount the host's /dev into the container",
+		"/var/lib/mesos is not on a mount with shared propagation, bind mount it from the host with rshared propagation",
+	}
+	if !reflect.DeepEqual(problems, exp) {
+		t.Fatalf("expected %v but got %v", exp, problems)
+	}
+	problems, warnings = containerProblems(mounts[:1], "", true)
+	if len(problems) != 0 || len(warnings) != 2 {
+		t.Fatalf("expected two warnings but got %v and %v", problems, warnings)
+	}
+}
+
+func TestRequiredContainerMounts(t *testing.T) {
+	exp := []string{"/dev:/dev", "/var/lib/kubelet:/var/lib/kubelet:rshared"}
+	if got := requiredContainerMounts("/var/lib/kubelet"); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v but got %v", exp, got)
+	}
+}
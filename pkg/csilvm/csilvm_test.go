@@ -1,4 +1,4 @@
-// +build !unit
+// +build linux,!unit
 
 package csilvm
 
@@ -7,28 +7,36 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	stdlog "log"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"github.com/google/uuid"
 	"github.com/mesosphere/csilvm/pkg/cleanup"
+	"github.com/mesosphere/csilvm/pkg/cmd"
 	"github.com/mesosphere/csilvm/pkg/lvm"
+	"github.com/mesosphere/csilvm/pkg/udev"
 	"github.com/uber-go/tally"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -151,7 +159,6 @@ func TestGetPluginCapabilitiesRemoveVolumeGroup(t *testing.T) {
 
 func testCreateVolumeRequest() *csi.CreateVolumeRequest {
 	const requiredBytes = 80 << 20
-	const limitBytes = 1000 << 20
 	volumeCapabilities := []*csi.VolumeCapability{
 		{
 			AccessType: &csi.VolumeCapability_Block{
@@ -175,7 +182,7 @@ func testCreateVolumeRequest() *csi.CreateVolumeRequest {
 	}
 	req := &csi.CreateVolumeRequest{
 		Name:               "test-volume",
-		CapacityRange:      &csi.CapacityRange{RequiredBytes: requiredBytes, LimitBytes: limitBytes},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: requiredBytes},
 		VolumeCapabilities: volumeCapabilities,
 	}
 	return req
@@ -224,7 +231,7 @@ func checkAttributesIncludeVolumeTag(t *testing.T, info *csi.Volume, name string
 }
 
 func TestCreateVolume_WithTag(t *testing.T) {
-	expected := []string{"some-tag", tagVolumeNamePlainPrefix + "test-volume"}
+	expected := []string{"some-tag", tagVolumeNamePlainPrefix + "test-volume", tagLayoutPrefix + "linear"}
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
@@ -313,6 +320,157 @@ func TestCreateVolumeCapacityNotMultipleOfExtentSize(t *testing.T) {
 	}
 }
 
+func TestCreateVolumeCapacityLimitBytesOnly(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	const defaultVolumeSize = uint64(20 << 20)
+	client, clean := startTest(vgname, []string{pvname}, DefaultVolumeSize(defaultVolumeSize))
+	defer clean()
+	const extentSize = 4 << 20 // 4MiB
+	// Without required_bytes the size is a single extent.
+	req := testCreateVolumeRequest()
+	req.CapacityRange.RequiredBytes = 0
+	req.CapacityRange.LimitBytes = 10 << 20
+	resp, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetVolume().GetCapacityBytes(); got != extentSize {
+		t.Fatalf("Expected volume size of %v but got %v", extentSize, got)
+	}
+	if got := resp.GetVolume().GetAttributes()[attrRoundedUpBytes]; got != "0" {
+		t.Fatalf("Expected rounded-up-bytes of 0 but got %v", got)
+	}
+	// The default size does not matter.
+	req = testCreateVolumeRequest()
+	req.Name += "-default"
+	req.CapacityRange.RequiredBytes = 0
+	req.CapacityRange.LimitBytes = int64(2*defaultVolumeSize + 1)
+	resp, err = client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetVolume().GetCapacityBytes(); got != extentSize {
+		t.Fatalf("Expected volume size of %v but got %v", extentSize, got)
+	}
+	// A limit_bytes below the extent size cannot be satisfied.
+	req = testCreateVolumeRequest()
+	req.Name += "-tiny"
+	req.CapacityRange.RequiredBytes = 0
+	req.CapacityRange.LimitBytes = extentSize - 1
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrNotMultipleOfExtentSize(extentSize)) {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateVolumeCapacityRequiredEqualsLimit(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	req := testCreateVolumeRequest()
+	req.CapacityRange.RequiredBytes = 24 << 20
+	req.CapacityRange.LimitBytes = 24 << 20
+	resp, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetVolume().GetCapacityBytes(); got != 24<<20 {
+		t.Fatalf("Expected volume size of 24MiB but got %v", got)
+	}
+	// A limit_bytes larger than the free space does not matter as long
+	// as the required_bytes fit.
+	req = testCreateVolumeRequest()
+	req.Name += "-large-limit"
+	req.CapacityRange.RequiredBytes = 4 << 20
+	req.CapacityRange.LimitBytes = 1 << 40
+	resp, err = client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetVolume().GetCapacityBytes(); got != 4<<20 {
+		t.Fatalf("Expected volume size of 4MiB but got %v", got)
+	}
+}
+
+func TestCreateVolumeCapacityRoundedUpToExtentSize(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	req := testCreateVolumeRequest()
+	req.CapacityRange.RequiredBytes = 25 << 20
+	const extentSize = 4 << 20 // 4MiB
+	resp, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := resp.GetVolume()
+	if got := info.GetCapacityBytes(); got != 28<<20 {
+		t.Fatalf("Expected capacity_bytes %d but got %d", 28<<20, got)
+	}
+	attr := info.GetAttributes()
+	if got := attr[attrExtentSize]; got != strconv.Itoa(extentSize) {
+		t.Fatalf("Expected %v=%d but got %q", attrExtentSize, extentSize, got)
+	}
+	if got := attr[attrRoundedUpBytes]; got != strconv.Itoa(3<<20) {
+		t.Fatalf("Expected %v=%d but got %q", attrRoundedUpBytes, 3<<20, got)
+	}
+}
+
+func TestCreateVolumeDeleteVolume_MetadataBackup(t *testing.T) {
+	backupDir, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(backupDir)
+	// The hook records the path of each backup it is called with.
+	hookLog := filepath.Join(backupDir, "hook.log")
+	hook := filepath.Join(backupDir, "hook.sh")
+	script := "#!/bin/sh\necho \"$1\" >> " + hookLog + "\n"
+	if err := ioutil.WriteFile(hook, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname}, MetadataBackupDir(backupDir), MetadataBackupHook(hook))
+	defer clean()
+	req := testCreateVolumeRequest()
+	resp, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An idempotent CreateVolume does not change the volume group.
+	if _, err := client.CreateVolume(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	deleteReq := testDeleteVolumeRequest(resp.GetVolume().GetId())
+	if _, err := client.DeleteVolume(context.Background(), deleteReq); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := filepath.Glob(filepath.Join(backupDir, vgname+"-*.vg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(backups)
+	if len(backups) != 2 ||
+		!strings.HasSuffix(backups[0], "-CreateVolume.vg") ||
+		!strings.HasSuffix(backups[1], "-DeleteVolume.vg") {
+		t.Fatalf("Expected a backup after CreateVolume and DeleteVolume but got %v", backups)
+	}
+	logged, err := ioutil.ReadFile(hookLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := strings.Join(backups, "\n") + "\n"; string(logged) != exp {
+		t.Fatalf("Expected hook to be called with %q but got %q", exp, logged)
+	}
+}
+
 func TestCreateVolume_Idempotent(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
@@ -367,6 +525,36 @@ func TestCreateVolume_AlreadyExists_CapacityRange(t *testing.T) {
 	}
 }
 
+func TestCreateVolume_AlreadyExists_Layout(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	req := testCreateVolumeRequest()
+	// Use only half the usual size so there is enough space for a
+	// second volume to be created.
+	req.CapacityRange.RequiredBytes /= 2
+	_, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Check that explicitly requesting the equivalent linear layout
+	// succeeds.
+	req.Parameters = map[string]string{"type": "linear"}
+	_, err = client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Check that trying to create a volume with the same name but a
+	// different layout fails.
+	req.Parameters = map[string]string{"type": "raid1"}
+	_, err = client.CreateVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrVolumeAlreadyExists) {
+		t.Fatal(err)
+	}
+}
+
 func TestCreateVolume_AlreadyExists_VolumeCapabilities(t *testing.T) {
 	// Prepare a test server with a known volume group name.
 	var clean cleanup.Steps
@@ -404,11 +592,19 @@ func TestCreateVolume_AlreadyExists_VolumeCapabilities(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := formatDevice(lvpath, "xfs"); err != nil {
+	// Start listening for udev events before creating the filesystem.
+	monitor, err := udev.NewMonitor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	if err := formatDevice(context.Background(), cmd.NewRunner(nil), lvpath, "xfs", ""); err != nil {
 		t.Fatal(err)
 	}
 	// Wait for filesystem creation to be reflected in udev.
-	_, err = exec.Command("udevadm", "settle").CombinedOutput()
+	_, err = monitor.WaitFor(10*time.Second, func(event udev.Event) bool {
+		return event.Action() == "change" && event.HasDevice(lvpath)
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -461,11 +657,19 @@ func TestCreateVolume_Idempotent_UnspecifiedExistingFsType(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := formatDevice(lvpath, "xfs"); err != nil {
+	// Start listening for udev events before creating the filesystem.
+	monitor, err := udev.NewMonitor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	if err := formatDevice(context.Background(), cmd.NewRunner(nil), lvpath, "xfs", ""); err != nil {
 		t.Fatal(err)
 	}
 	// Wait for filesystem creation to be reflected in udev.
-	_, err = exec.Command("udevadm", "settle").CombinedOutput()
+	_, err = monitor.WaitFor(10*time.Second, func(event udev.Event) bool {
+		return event.Action() == "change" && event.HasDevice(lvpath)
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -505,26 +709,139 @@ func TestCreateVolumeCapacityRangeNotSatisfied(t *testing.T) {
 	}
 }
 
-/* TODO(jdef) re-enable this test once we add length validation
+func TestCreateVolumeUnsafeVolumeName(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	// CO-specified names are only recorded in a tag so names that are
+	// not valid logical volume names are accepted, as are names of the
+	// maximum length allowed by CSI.
+	for _, name := range []string{"invalid name : /", "snapshot_rimage_0", strings.Repeat("a", 128)} {
+		req := testCreateVolumeRequest()
+		req.Name = name
+		// Use only a fraction of the usual size so there is enough
+		// space for all volumes.
+		req.CapacityRange = &csi.CapacityRange{RequiredBytes: 20 << 20, LimitBytes: 20 << 20}
+		resp, err := client.CreateVolume(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Expected %q to be accepted: %v", name, err)
+		}
+		// A retry finds the volume.
+		again, err := client.CreateVolume(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.GetVolume().GetId() != resp.GetVolume().GetId() {
+			t.Fatalf("Expected volume id %v but got %v", resp.GetVolume().GetId(), again.GetVolume().GetId())
+		}
+	}
+}
+
+func TestCreateVolume_ExtendOntoStandbyDevice(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	standbyname, standbyclean := testpv()
+	defer check(standbyclean)
+	client, clean := startTest(vgname, []string{pvname}, StandbyDevices([]string{standbyname}))
+	defer clean()
+	req := testCreateVolumeRequest()
+	// The request cannot be satisfied by a single physical volume.
+	req.CapacityRange.RequiredBytes = pvsize + (pvsize / 2)
+	resp, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetVolume().GetCapacityBytes() < req.CapacityRange.RequiredBytes {
+		t.Fatalf("Expected volume of at least %d bytes but got %d", req.CapacityRange.RequiredBytes, resp.GetVolume().GetCapacityBytes())
+	}
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pvnames, err := vg.ListPhysicalVolumeNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(pvnames)
+	exp := []string{pvname, standbyname}
+	sort.Strings(exp)
+	if !reflect.DeepEqual(pvnames, exp) {
+		t.Fatalf("Expected physical volumes %v but got %v", exp, pvnames)
+	}
+}
 
-func TestCreateVolumeInvalidVolumeName(t *testing.T) {
+func TestCreateVolume_NoStandbyDevices_InsufficientCapacity(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
 	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
 	req := testCreateVolumeRequest()
-	// Use only half the usual size so there is enough space for a
-	// second volume to be created.
-	req.Name = "invalid name : /"
+	req.CapacityRange.RequiredBytes = pvsize + (pvsize / 2)
 	_, err := client.CreateVolume(context.Background(), req)
-	expdesc := "The volume name is invalid: err=lvm: Name contains invalid character, valid set includes: [A-Za-z0-9_+.-]"
-	experr := status.Error(codes.InvalidArgument, expdesc)
-	if !grpcErrorEqual(err, experr) {
-		t.Fatal("expected 'invalid argument' error instead of ", err)
+	if !grpcErrorEqual(err, ErrInsufficientCapacity) {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateVolume_DryRun(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	req := testCreateVolumeRequest()
+	req.CapacityRange.RequiredBytes /= 2
+	req.Parameters = map[string]string{"dry-run": "true"}
+	resp, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volInfo := resp.GetVolume()
+	if !strings.Contains(volInfo.GetId(), "csilv") {
+		t.Fatalf("Expected the id that would be allocated but got %v", volInfo.GetId())
+	}
+	if got := volInfo.GetCapacityBytes(); got != req.CapacityRange.RequiredBytes {
+		t.Fatalf("Unexpected capacity_bytes %v != %v", got, req.CapacityRange.RequiredBytes)
+	}
+	if got := volInfo.GetAttributes()["dry-run"]; got != "true" {
+		t.Fatalf("Expected the dry-run attribute but got %v", volInfo.GetAttributes())
+	}
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lvnames, err := vg.ListLogicalVolumeNames(); err != nil || len(lvnames) != 0 {
+		t.Fatalf("Expected no logical volumes but got %v (err=%v)", lvnames, err)
+	}
+	// A dry run reports an existing volume as CreateVolume would.
+	delete(req.Parameters, "dry-run")
+	created, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Parameters["dry-run"] = "true"
+	resp, err = client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetVolume().GetId(); got != created.GetVolume().GetId() {
+		t.Fatalf("Unexpected id %v != %v", got, created.GetVolume().GetId())
+	}
+	// A dry run fails as CreateVolume would.
+	req.Name += "-too-big"
+	req.CapacityRange.RequiredBytes = pvsize
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrInsufficientCapacity) {
+		t.Fatal(err)
+	}
+	req.Parameters["dry-run"] = "maybe"
+	if _, err := client.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument but got %v", err)
 	}
 }
-*/
 
 func TestCreateVolume_VolumeLayout_Linear(t *testing.T) {
 	vgname := testvgname()
@@ -570,442 +887,572 @@ func TestCreateVolume_VolumeLayout_RAID1(t *testing.T) {
 	checkAttributesIncludeVolumeTag(t, info, req.GetName())
 }
 
-func TestCreateVolume_VolumeLayout_RAID1_Mirror2(t *testing.T) {
+func TestCreateVolume_Cache(t *testing.T) {
 	vgname := testvgname()
 	pvname1, pvclean1 := testpv()
 	defer check(pvclean1)
 	pvname2, pvclean2 := testpv()
 	defer check(pvclean2)
-	pvname3, pvclean3 := testpv()
-	defer check(pvclean3)
-	pvname4, pvclean4 := testpv()
-	defer check(pvclean4)
-	client, clean := startTest(vgname, []string{pvname1, pvname2, pvname3, pvname4})
+	client, clean := startTest(vgname, []string{pvname1, pvname2}, CacheDeviceTag("fast"))
 	defer clean()
+	fast, err := lvm.LookupPhysicalVolume(pvname2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fast.AddTag("fast"); err != nil {
+		t.Fatal(err)
+	}
 	req := testCreateVolumeRequest()
 	req.Parameters = map[string]string{
-		"type":    "raid1",
-		"mirrors": "2",
+		"cache":       "true",
+		"cachepolicy": "smq",
 	}
 	resp, err := client.CreateVolume(context.Background(), req)
 	if err != nil {
 		t.Fatal(err)
 	}
 	info := resp.GetVolume()
-	if info.GetCapacityBytes() != req.GetCapacityRange().GetRequiredBytes() {
-		t.Fatalf("Expected required_bytes (%v) to match volume size (%v).", req.GetCapacityRange().GetRequiredBytes(), info.GetCapacityBytes())
-	}
 	checkAttributesIncludeVolumeTag(t, info, req.GetName())
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv, err := vg.LookupLogicalVolume(info.GetId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := lv.IsCached(); err != nil || !cached {
+		t.Fatalf("Expected the volume to be cached (err=%v)", err)
+	}
+	// Retrying the request succeeds but requesting a different cache
+	// does not.
+	if _, err := client.CreateVolume(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	req.Parameters = nil
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrVolumeAlreadyExists) {
+		t.Fatal(err)
+	}
+	// The cache pool cannot be larger than the cache devices.
+	req = testCreateVolumeRequest()
+	req.Name += "-big-cache"
+	req.CapacityRange.RequiredBytes = 4 << 20
+	req.CapacityRange.LimitBytes = 0
+	req.Parameters = map[string]string{
+		"cache":     "true",
+		"cachesize": strconv.Itoa(2 * pvsize),
+	}
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrInsufficientCacheCapacity) {
+		t.Fatal(err)
+	}
+	if _, err := client.DeleteVolume(context.Background(), testDeleteVolumeRequest(info.GetId())); err != nil {
+		t.Fatal(err)
+	}
 }
 
-func TestCreateVolume_VolumeLayout_TooFewDisks(t *testing.T) {
+func TestCreateVolume_Cache_NotConfigured(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
 	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
 	req := testCreateVolumeRequest()
-	req.Parameters = map[string]string{
-		"type": "raid1",
-	}
-	_, err := client.CreateVolume(context.Background(), req)
-	if !grpcErrorEqual(err, ErrInsufficientCapacity) {
-		// We expect ErrInsufficientCapacity as CreateVolume checks
-		// whether there is sufficient capacity to create the volume.
-		t.Fatal(err)
-	}
-}
-
-func testDeleteVolumeRequest(volumeId string) *csi.DeleteVolumeRequest {
-	req := &csi.DeleteVolumeRequest{
-		VolumeId: volumeId,
+	req.Parameters = map[string]string{"cache": "true"}
+	if _, err := client.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument but got %v", err)
 	}
-	return req
 }
 
-func TestDeleteVolume(t *testing.T) {
+func TestCreateVolume_VolumePrefix(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname})
+	client, clean := startTest(vgname, []string{pvname}, VolumePrefix("tenantA"))
 	defer clean()
-	createReq := testCreateVolumeRequest()
-	createResp, err := client.CreateVolume(context.Background(), createReq)
+	// A volume created by another instance without the prefix.
+	vg, err := lvm.LookupVolumeGroup(vgname)
 	if err != nil {
 		t.Fatal(err)
 	}
-	volumeId := createResp.GetVolume().GetId()
-	req := testDeleteVolumeRequest(volumeId)
-	_, err = client.DeleteVolume(context.Background(), req)
+	other, err := vg.CreateLogicalVolume("csilvother", 8<<20, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-}
-
-func TestDeleteVolume_Idempotent(t *testing.T) {
-	vgname := testvgname()
-	pvname, pvclean := testpv()
-	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname})
-	defer clean()
-	createReq := testCreateVolumeRequest()
-	createResp, err := client.CreateVolume(context.Background(), createReq)
+	resp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
 	if err != nil {
 		t.Fatal(err)
 	}
-	volumeId := createResp.GetVolume().GetId()
-	req := testDeleteVolumeRequest(volumeId)
-	_, err = client.DeleteVolume(context.Background(), req)
-	if err != nil {
-		t.Fatal(err)
+	if id := resp.GetVolume().GetId(); !strings.HasPrefix(id, "tenantA_csilv") {
+		t.Fatalf("Expected the volume id %q to start with the prefix", id)
 	}
-	_, err = client.DeleteVolume(context.Background(), req)
+	listResp, err := client.ListVolumes(context.Background(), testListVolumesRequest())
 	if err != nil {
 		t.Fatal(err)
 	}
-}
-
-func TestDeleteVolumeUnknownVolume(t *testing.T) {
-	vgname := testvgname()
-	pvname, pvclean := testpv()
-	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname})
-	defer clean()
-	req := testDeleteVolumeRequest("missing-volume")
-	_, err := client.DeleteVolume(context.Background(), req)
-	if err != nil {
+	if entries := listResp.GetEntries(); len(entries) != 1 || entries[0].GetVolume().GetId() != resp.GetVolume().GetId() {
+		t.Fatalf("Expected only the prefixed volume to be listed but got %v", entries)
+	}
+	// Deleting the other volume succeeds without touching it.
+	if _, err := client.DeleteVolume(context.Background(), testDeleteVolumeRequest(other.Name())); err != nil {
 		t.Fatal(err)
 	}
+	if _, err := vg.LookupLogicalVolume(other.Name()); err != nil {
+		t.Fatalf("Expected the other volume to remain: %v", err)
+	}
 }
 
-func TestDeleteVolumeAfterDeviceDisappears(t *testing.T) {
+func TestCreateVolume_NodeVolumeIDs(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname})
+	client, clean := startTest(vgname, []string{pvname}, NodeID("node1"), NodeVolumeIDs())
 	defer clean()
-	// Create the volume that we'll be publishing.
-	createReq := testCreateVolumeRequest()
-	createResp, err := client.CreateVolume(context.Background(), createReq)
+	resp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
 	if err != nil {
 		t.Fatal(err)
 	}
-	volumeId := createResp.GetVolume().GetId()
-	// Remove the device node.
-	vg, err := lvm.LookupVolumeGroup(vgname)
-	if err != nil {
-		panic(err)
+	id := resp.GetVolume().GetId()
+	if !strings.HasPrefix(id, "node1:csilv") {
+		t.Fatalf("Expected the volume id %q to start with the node id", id)
 	}
-	lv, err := vg.LookupLogicalVolume(volumeId)
+	name := strings.TrimPrefix(id, "node1:")
+	vg, err := lvm.LookupVolumeGroup(vgname)
 	if err != nil {
 		t.Fatal(err)
 	}
-	path, err := lv.Path()
+	if _, err := vg.LookupLogicalVolume(name); err != nil {
+		t.Fatalf("Expected a logical volume named %v: %v", name, err)
+	}
+	listResp, err := client.ListVolumes(context.Background(), testListVolumesRequest())
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := os.Remove(path); err != nil {
+	if entries := listResp.GetEntries(); len(entries) != 1 || entries[0].GetVolume().GetId() != id {
+		t.Fatalf("Expected the volume %v to be listed but got %v", id, entries)
+	}
+	// The volume id of another node is not found.
+	validateReq := testValidateVolumeCapabilitiesRequest("node2:"+name, "xfs", nil)
+	if _, err := client.ValidateVolumeCapabilities(context.Background(), validateReq); status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound for the volume id of another node but got %v", err)
+	}
+	if _, err := client.DeleteVolume(context.Background(), testDeleteVolumeRequest(id)); err != nil {
 		t.Fatal(err)
 	}
-	// Delete the volume, even though the device node has already been
-	// removed, and expect it to succeed.
-	expErr := status.Errorf(codes.Internal,
-		"The device path does not exist, cannot zero volume contents. To bypass the zeroing of the volume contents, ensure the file exists, or create it by hand, and reissue the DeleteVolume operation. path=%s",
-		path)
-	deleteReq := testDeleteVolumeRequest(volumeId)
-	_, err = client.DeleteVolume(context.Background(), deleteReq)
-	if !grpcErrorEqual(err, expErr) {
-		t.Fatalf("expected %v got %v", expErr, err)
+	if _, err := vg.LookupLogicalVolume(name); err != lvm.ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected the volume to be deleted but got %v", err)
 	}
 }
 
-func TestDeleteVolumeErasesData(t *testing.T) {
+func TestCreateVolume_PVTags(t *testing.T) {
 	vgname := testvgname()
-	pvname, pvclean := testpv()
-	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname})
+	pvname1, pvclean1 := testpv()
+	defer check(pvclean1)
+	pvname2, pvclean2 := testpv()
+	defer check(pvclean2)
+	client, clean := startTest(vgname, []string{pvname1, pvname2})
 	defer clean()
-	// Create the volume that we'll be publishing.
-	createReq := testCreateVolumeRequest()
-	createResp, err := client.CreateVolume(context.Background(), createReq)
-	if err != nil {
-		t.Fatal(err)
-	}
-	volumeId := createResp.GetVolume().GetId()
-	capacityBytes := createResp.GetVolume().GetCapacityBytes()
-	// Prepare a temporary mount directory.
-	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	ssd, err := lvm.LookupPhysicalVolume(pvname2)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(tmpdirPath)
-	targetPath := filepath.Join(tmpdirPath, volumeId)
-	if err := os.Mkdir(targetPath, 0755); err != nil {
+	if err := ssd.AddTag("ssd"); err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(targetPath)
-	// Publish the volume to /the/tmp/dir/volume-id
-	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
-	_, err = client.NodePublishVolume(context.Background(), publishReq)
+	vg, err := lvm.LookupVolumeGroup(vgname)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Unpublish the volume when the test ends unless the test
-	// called unpublish already.
-	alreadyUnpublished := false
-	defer func() {
-		if alreadyUnpublished {
-			return
-		}
-		req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
-		_, err = client.NodeUnpublishVolume(context.Background(), req)
+	ssdBytesFree := func() int64 {
+		pvs, err := vg.ReportPhysicalVolumes()
 		if err != nil {
 			t.Fatal(err)
 		}
-	}()
-	// Ensure that the device was mounted.
-	if !targetPathIsMountPoint(publishReq.TargetPath) {
-		t.Fatalf("Expected volume to be mounted at %v.", publishReq.TargetPath)
+		for _, pv := range pvs {
+			if pv.Name == pvname2 {
+				return int64(pv.BytesFree)
+			}
+		}
+		t.Fatalf("Physical volume %v not found", pvname2)
+		return 0
 	}
-	// Create a file on the mounted volume.
-	file, err := os.Create(filepath.Join(targetPath, "test"))
+	// GetCapacity reports the capacity of the tagged physical volume.
+	capReq := testGetCapacityRequest("xfs")
+	capReq.Parameters = map[string]string{"pv-tags": "ssd"}
+	capResp, err := client.GetCapacity(context.Background(), capReq)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Fill the file with 1's.
-	ones := repeater{1}
-	wrote, err := io.CopyN(file, ones, int64(capacityBytes))
-	if err.(*os.PathError).Err != syscall.ENOSPC {
-		t.Fatalf("Expected ENOSPC but got %v", err)
+	if capResp.GetAvailableCapacity() != ssdBytesFree() {
+		t.Fatalf("Expected %v bytes free, got %v", ssdBytesFree(), capResp.GetAvailableCapacity())
 	}
-	file.Close()
-	// Check that we wrote at least half the volume's capacity full of ones.
-	// We can't check for equality due to filesystem metadata, etc.
-	if int64(wrote) < capacityBytes/2 {
-		t.Fatalf("Failed to write even half of the volume: %v of %v", wrote, capacityBytes)
+	// A volume larger than the tagged physical volume cannot be created.
+	req := testCreateVolumeRequest()
+	req.Name += "-too-big"
+	req.CapacityRange.RequiredBytes = capResp.GetAvailableCapacity() + 4<<20
+	req.CapacityRange.LimitBytes = 0
+	req.Parameters = map[string]string{"pv-tags": "ssd"}
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrInsufficientCapacity) {
+		t.Fatal(err)
 	}
-	// Unpublish the volume.
-	req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
-	_, err = client.NodeUnpublishVolume(context.Background(), req)
+	req = testCreateVolumeRequest()
+	req.Parameters = map[string]string{"pv-tags": "ssd"}
+	resp, err := client.CreateVolume(context.Background(), req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	alreadyUnpublished = true
-	deleteReq := testDeleteVolumeRequest(volumeId)
-	_, err = client.DeleteVolume(context.Background(), deleteReq)
-	if err != nil {
+	// The volume is allocated on the tagged physical volume.
+	if got := capResp.GetAvailableCapacity() - ssdBytesFree(); got != resp.GetVolume().GetCapacityBytes() {
+		t.Fatalf("Expected %v bytes allocated on %v, got %v", resp.GetVolume().GetCapacityBytes(), pvname2, got)
+	}
+	// Retrying the request succeeds but requesting different pv-tags
+	// does not.
+	if _, err := client.CreateVolume(context.Background(), req); err != nil {
 		t.Fatal(err)
 	}
-	// Create a new volume and check that it contains only zeros.
-	createReq.Name += "-2"
-	createResp, err = client.CreateVolume(context.Background(), createReq)
-	if err != nil {
+	req.Parameters = nil
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrVolumeAlreadyExists) {
 		t.Fatal(err)
 	}
-	volumeId = createResp.GetVolume().GetId()
-	capacityBytes = createResp.GetVolume().GetCapacityBytes()
-	targetPath = filepath.Join(tmpdirPath, volumeId)
-	if file, err := os.Create(targetPath); err != nil {
+	// No physical volume has the nvme tag.
+	req = testCreateVolumeRequest()
+	req.Name += "-nvme"
+	req.Parameters = map[string]string{"pv-tags": "nvme"}
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrTooFewDisks(1, 0)) {
 		t.Fatal(err)
-	} else {
-		// Immediately close the file, we're just creating it
-		// as a mount target.
-		if err := file.Close(); err != nil {
-			t.Fatal(err)
-		}
 	}
-	defer os.RemoveAll(targetPath)
-	publishReq = testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
-	_, err = client.NodePublishVolume(context.Background(), publishReq)
+	if _, err := client.DeleteVolume(context.Background(), testDeleteVolumeRequest(resp.GetVolume().GetId())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateVolume_PVs(t *testing.T) {
+	vgname := testvgname()
+	pvname1, pvclean1 := testpv()
+	defer check(pvclean1)
+	pvname2, pvclean2 := testpv()
+	defer check(pvclean2)
+	client, clean := startTest(vgname, []string{pvname1, pvname2})
+	defer clean()
+	vg, err := lvm.LookupVolumeGroup(vgname)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
-		_, err = client.NodeUnpublishVolume(context.Background(), req)
+	bytesFree := func(name string) uint64 {
+		pvs, err := vg.ReportPhysicalVolumes()
 		if err != nil {
 			t.Fatal(err)
 		}
-	}()
-	// Check that the device is filled with zeros.
-	n, ok := containsOnly(targetPath, 0)
-	if !ok {
-		t.Fatal("Expected device to consists of zeros only.")
+		for _, pv := range pvs {
+			if pv.Name == name {
+				return pv.BytesFree
+			}
+		}
+		t.Fatalf("Physical volume %v not found", name)
+		return 0
 	}
-	if int64(n) != capacityBytes {
-		t.Fatalf("Bad read, expected device to have size %d but read only %d bytes", capacityBytes, n)
+	before := bytesFree(pvname1)
+	req := testCreateVolumeRequest()
+	req.Parameters = map[string]string{"pvs": pvname2}
+	resp, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The volume is allocated on the named physical volume only.
+	if got := bytesFree(pvname1); got != before {
+		t.Fatalf("Expected %v to remain untouched with %v bytes free, got %v", pvname1, before, got)
+	}
+	// Retrying the request succeeds but requesting different pvs does
+	// not.
+	if _, err := client.CreateVolume(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	req.Parameters = map[string]string{"pvs": pvname1}
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrVolumeAlreadyExists) {
+		t.Fatal(err)
+	}
+	// A raid1 volume requires two of the named physical volumes.
+	req = testCreateVolumeRequest()
+	req.Name += "-raid1"
+	req.Parameters = map[string]string{"pvs": pvname1, "type": "raid1"}
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrTooFewDisks(2, 1)) {
+		t.Fatal(err)
+	}
+	// A device that is not a physical volume of the volume group is
+	// rejected.
+	req = testCreateVolumeRequest()
+	req.Name += "-unknown"
+	req.Parameters = map[string]string{"pvs": "/dev/no-such-device"}
+	if _, err := client.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument but got %v", err)
+	}
+	if _, err := client.DeleteVolume(context.Background(), testDeleteVolumeRequest(resp.GetVolume().GetId())); err != nil {
+		t.Fatal(err)
 	}
 }
 
-func containsOnly(devicePath string, i byte) (uint64, bool) {
-	file, err := os.Open(devicePath)
+func TestCreateVolume_VolumeLayout_RAID1_Mirror2(t *testing.T) {
+	vgname := testvgname()
+	pvname1, pvclean1 := testpv()
+	defer check(pvclean1)
+	pvname2, pvclean2 := testpv()
+	defer check(pvclean2)
+	pvname3, pvclean3 := testpv()
+	defer check(pvclean3)
+	pvname4, pvclean4 := testpv()
+	defer check(pvclean4)
+	client, clean := startTest(vgname, []string{pvname1, pvname2, pvname3, pvname4})
+	defer clean()
+	req := testCreateVolumeRequest()
+	req.Parameters = map[string]string{
+		"type":    "raid1",
+		"mirrors": "2",
+	}
+	resp, err := client.CreateVolume(context.Background(), req)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	defer file.Close()
-	br := bufio.NewReader(file)
-	idx := uint64(0)
-	for {
-		b, err := br.ReadByte()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			panic(err)
-		}
-		if b != 0 {
-			return idx, false
-		}
-		idx++
+	info := resp.GetVolume()
+	if info.GetCapacityBytes() != req.GetCapacityRange().GetRequiredBytes() {
+		t.Fatalf("Expected required_bytes (%v) to match volume size (%v).", req.GetCapacityRange().GetRequiredBytes(), info.GetCapacityBytes())
 	}
-	return idx, true
+	checkAttributesIncludeVolumeTag(t, info, req.GetName())
 }
 
-func TestControllerPublishVolumeNotSupported(t *testing.T) {
+func TestCreateVolume_VolumeLayout_Stripes(t *testing.T) {
 	vgname := testvgname()
-	pvname, pvclean := testpv()
-	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname})
+	pvname1, pvclean1 := testpv()
+	defer check(pvclean1)
+	pvname2, pvclean2 := testpv()
+	defer check(pvclean2)
+	client, clean := startTest(vgname, []string{pvname1, pvname2})
 	defer clean()
-	req := &csi.ControllerPublishVolumeRequest{}
-	_, err := client.ControllerPublishVolume(context.Background(), req)
-	if !grpcErrorEqual(err, ErrCallNotImplemented) {
+	req := testCreateVolumeRequest()
+	req.CapacityRange = &csi.CapacityRange{RequiredBytes: 25 << 20}
+	req.Parameters = map[string]string{
+		"type":    "linear",
+		"stripes": "2",
+	}
+	resp, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The size is rounded up to a full stripe of two 4MiB extents.
+	if size := resp.GetVolume().GetCapacityBytes(); size != 32<<20 {
+		t.Fatalf("Expected the volume size to be rounded up to 32MiB but got %v", size)
+	}
+	// A retry with a different number of stripes fails.
+	req.Parameters["stripes"] = "1"
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrVolumeAlreadyExists) {
+		t.Fatal(err)
+	}
+	req.Name = "striped-3"
+	req.Parameters["stripes"] = "3"
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrTooFewDisks(3, 2)) {
 		t.Fatal(err)
 	}
 }
 
-func TestControllerUnpublishVolumeNotSupported(t *testing.T) {
+func TestCreateVolume_VolumeLayout_TooFewDisks(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
 	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
-	req := &csi.ControllerUnpublishVolumeRequest{}
-	_, err := client.ControllerUnpublishVolume(context.Background(), req)
-	if !grpcErrorEqual(err, ErrCallNotImplemented) {
+	req := testCreateVolumeRequest()
+	req.Parameters = map[string]string{
+		"type": "raid1",
+	}
+	_, err := client.CreateVolume(context.Background(), req)
+	// CreateVolume checks the number of devices before checking
+	// whether there is sufficient capacity.
+	if !grpcErrorEqual(err, ErrTooFewDisks(2, 1)) {
 		t.Fatal(err)
 	}
+	info, ok := ErrorReason(err)
+	if !ok {
+		t.Fatalf("Expected error %v to carry an ErrorInfo", err)
+	}
+	if info.Metadata["required"] != "2" || info.Metadata["available"] != "1" {
+		t.Fatalf("Expected the device counts in the ErrorInfo metadata but got %v", info.Metadata)
+	}
 }
 
-func testValidateVolumeCapabilitiesRequest(volumeId string, filesystem string, mountOpts []string) *csi.ValidateVolumeCapabilitiesRequest {
-	volumeCapabilities := []*csi.VolumeCapability{
-		{
-			AccessType: &csi.VolumeCapability_Block{
-				Block: &csi.VolumeCapability_BlockVolume{},
-			},
-			AccessMode: &csi.VolumeCapability_AccessMode{
-				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
-			},
-		},
-		{
-			AccessType: &csi.VolumeCapability_Mount{
-				Mount: &csi.VolumeCapability_MountVolume{
-					FsType:     filesystem,
-					MountFlags: mountOpts,
-				},
-			},
-			AccessMode: &csi.VolumeCapability_AccessMode{
-				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
-			},
-		},
+func TestCreateVolume_VolumeLayout_RAID1_Mirror2_TooFewDisks(t *testing.T) {
+	vgname := testvgname()
+	var pvnames []string
+	for i := 0; i < 3; i++ {
+		pvname, pvclean := testpv()
+		defer check(pvclean)
+		pvnames = append(pvnames, pvname)
 	}
-	req := &csi.ValidateVolumeCapabilitiesRequest{
-		VolumeId:           volumeId,
-		VolumeCapabilities: volumeCapabilities,
+	client, clean := startTest(vgname, pvnames)
+	defer clean()
+	req := testCreateVolumeRequest()
+	req.Parameters = map[string]string{
+		"type":    "raid1",
+		"mirrors": "2",
+	}
+	_, err := client.CreateVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrTooFewDisks(4, 3)) {
+		t.Fatal(err)
 	}
-	return req
 }
 
-func TestValidateVolumeCapabilities_BlockVolume(t *testing.T) {
+func TestModifyVolume(t *testing.T) {
 	vgname := testvgname()
-	pvname, pvclean := testpv()
-	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname})
+	pvname1, pvclean1 := testpv()
+	defer check(pvclean1)
+	pvname2, pvclean2 := testpv()
+	defer check(pvclean2)
+	client, clean := startTest(vgname, []string{pvname1, pvname2})
 	defer clean()
-	// Create the volume that we'll be publishing.
-	createReq := testCreateVolumeRequest()
-	createResp, err := client.CreateVolume(context.Background(), createReq)
+	resp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
 	if err != nil {
 		t.Fatal(err)
 	}
-	volumeId := createResp.GetVolume().GetId()
-	req := testValidateVolumeCapabilitiesRequest(volumeId, "xfs", nil)
-	resp, err := client.ValidateVolumeCapabilities(context.Background(), req)
+	id := resp.GetVolume().GetId()
+	req := &ModifyVolumeRequest{
+		VolumeId: id,
+		MutableParameters: map[string]string{
+			"add-tags": "tenant.a,backup",
+			"readonly": "true",
+			"type":     "raid1",
+		},
+	}
+	// The request is idempotent.
+	for i := 0; i < 2; i++ {
+		if _, err := client.ModifyVolume(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vg, err := lvm.LookupVolumeGroup(vgname)
 	if err != nil {
 		t.Fatal(err)
 	}
+	lv, err := vg.LookupLogicalVolume(id)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !resp.GetSupported() {
-		t.Fatal("Expected requested volume capabilities to be supported.")
+	tags, err := lv.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{"tenant.a", "backup", "LY.raid1.1"} {
+		if !containsString(tags, exp) {
+			t.Fatalf("Expected tag %v in %v", exp, tags)
+		}
+	}
+	if containsString(tags, "LY.linear") {
+		t.Fatalf("Expected the linear layout tag to be removed from %v", tags)
+	}
+	if readonly, err := lv.IsReadonly(); err != nil || !readonly {
+		t.Fatalf("Expected the volume to be readonly: readonly=%v err=%v", readonly, err)
+	}
+	req.MutableParameters = map[string]string{
+		"remove-tags": "backup",
+		"readonly":    "false",
+	}
+	if _, err := client.ModifyVolume(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err = lv.Tags(); err != nil {
+		t.Fatal(err)
+	}
+	if containsString(tags, "backup") {
+		t.Fatalf("Expected the tag to be removed from %v", tags)
+	}
+	if readonly, err := lv.IsReadonly(); err != nil || readonly {
+		t.Fatalf("Expected the volume to be writable: readonly=%v err=%v", readonly, err)
 	}
 }
 
-func TestValidateVolumeCapabilities_MountVolume(t *testing.T) {
+func TestModifyVolume_InvalidParameters(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	req := &ModifyVolumeRequest{VolumeId: "csilvnonexistent"}
+	if _, err := client.ModifyVolume(context.Background(), req); !grpcErrorEqual(err, ErrMissingMutableParameters) {
+		t.Fatal(err)
+	}
+	req.MutableParameters = map[string]string{"size": "1Gi"}
+	if _, err := client.ModifyVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument but got %v", err)
+	}
+	req.MutableParameters = map[string]string{"readonly": "true"}
+	if _, err := client.ModifyVolume(context.Background(), req); status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound but got %v", err)
+	}
+}
+
+func testDeleteVolumeRequest(volumeId string) *csi.DeleteVolumeRequest {
+	req := &csi.DeleteVolumeRequest{
+		VolumeId: volumeId,
+	}
+	return req
+}
+
+func TestDeleteVolume(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
 	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
-	// Create the volume that we'll be publishing.
 	createReq := testCreateVolumeRequest()
 	createResp, err := client.CreateVolume(context.Background(), createReq)
 	if err != nil {
 		t.Fatal(err)
 	}
 	volumeId := createResp.GetVolume().GetId()
-	// Publish the volume with fstype 'xfs' then unmount it.
-	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	req := testDeleteVolumeRequest(volumeId)
+	_, err = client.DeleteVolume(context.Background(), req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(tmpdirPath)
-	targetPath := filepath.Join(tmpdirPath, volumeId)
-	if err := os.Mkdir(targetPath, 0755); err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(targetPath)
-	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
-	_, err = client.NodePublishVolume(context.Background(), publishReq)
+}
+
+func TestDeleteVolume_Idempotent(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Unpublish the volume now that it has been formatted.
-	unpublishReq := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
-	_, err = client.NodeUnpublishVolume(context.Background(), unpublishReq)
+	volumeId := createResp.GetVolume().GetId()
+	req := testDeleteVolumeRequest(volumeId)
+	_, err = client.DeleteVolume(context.Background(), req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	validateReq := testValidateVolumeCapabilitiesRequest(volumeId, "xfs", nil)
-	validateResp, err := client.ValidateVolumeCapabilities(context.Background(), validateReq)
+	_, err = client.DeleteVolume(context.Background(), req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !validateResp.GetSupported() {
-		t.Fatal("Expected requested volume capabilities to be supported.")
-	}
 }
 
-func TestValidateVolumeCapabilities_MissingVolume(t *testing.T) {
+func TestDeleteVolumeUnknownVolume(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
 	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
-	// Create the volume that we'll be publishing.
-	validateReq := testValidateVolumeCapabilitiesRequest("foo", "xfs", nil)
-	_, err := client.ValidateVolumeCapabilities(context.Background(), validateReq)
-	if !grpcErrorEqual(err, ErrVolumeNotFound) {
+	req := testDeleteVolumeRequest("missing-volume")
+	_, err := client.DeleteVolume(context.Background(), req)
+	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func TestValidateVolumeCapabilities_MountVolume_MismatchedFsTypes(t *testing.T) {
+func TestDeleteVolumeAfterDeviceDisappears(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname}, SupportedFilesystem("ext4"))
+	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
 	// Create the volume that we'll be publishing.
 	createReq := testCreateVolumeRequest()
@@ -1014,145 +1461,312 @@ func TestValidateVolumeCapabilities_MountVolume_MismatchedFsTypes(t *testing.T)
 		t.Fatal(err)
 	}
 	volumeId := createResp.GetVolume().GetId()
-	// Publish the volume with fstype 'xfs' then unmount it.
-	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	// Remove the device node.
+	vg, err := lvm.LookupVolumeGroup(vgname)
 	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tmpdirPath)
-	targetPath := filepath.Join(tmpdirPath, volumeId)
-	if err := os.Mkdir(targetPath, 0755); err != nil {
-		t.Fatal(err)
+		panic(err)
 	}
-	defer os.RemoveAll(targetPath)
-	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
-	_, err = client.NodePublishVolume(context.Background(), publishReq)
+	lv, err := vg.LookupLogicalVolume(volumeId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Unpublish the volume now that it has been formatted.
-	unpublishReq := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
-	_, err = client.NodeUnpublishVolume(context.Background(), unpublishReq)
+	path, err := lv.Path()
 	if err != nil {
 		t.Fatal(err)
 	}
-	validateReq := testValidateVolumeCapabilitiesRequest(volumeId, "ext4", nil)
-	_, err = client.ValidateVolumeCapabilities(context.Background(), validateReq)
-	if !grpcErrorEqual(err, ErrMismatchedFilesystemType) {
+	if err := os.Remove(path); err != nil {
 		t.Fatal(err)
 	}
-}
-
-func testListVolumesRequest() *csi.ListVolumesRequest {
-	req := &csi.ListVolumesRequest{
-		MaxEntries:    0,
-		StartingToken: "",
+	// Delete the volume, even though the device node has already been
+	// removed, and expect it to succeed.
+	expErr := status.Errorf(codes.Internal,
+		"The device path does not exist, cannot zero volume contents. To bypass the zeroing of the volume contents, ensure the file exists, or create it by hand, and reissue the DeleteVolume operation. path=%s",
+		path)
+	deleteReq := testDeleteVolumeRequest(volumeId)
+	_, err = client.DeleteVolume(context.Background(), deleteReq)
+	if !grpcErrorEqual(err, expErr) {
+		t.Fatalf("expected %v got %v", expErr, err)
 	}
-	return req
 }
 
-func TestListVolumes_NoVolumes(t *testing.T) {
+func TestDeleteVolumeAfterDeviceDisappears_SkipWipe(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname})
+	client, clean := startTest(vgname, []string{pvname}, SkipWipeIfDeviceMissing())
 	defer clean()
-	req := testListVolumesRequest()
-	resp, err := client.ListVolumes(context.Background(), req)
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(resp.GetEntries()) != 0 {
-		t.Fatal("Expected no entries.")
+	volumeId := createResp.GetVolume().GetId()
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		panic(err)
+	}
+	lv, err := vg.LookupLogicalVolume(volumeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := lv.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	// The volume is removed without zeroing its contents.
+	deleteReq := testDeleteVolumeRequest(volumeId)
+	if _, err := client.DeleteVolume(context.Background(), deleteReq); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vg.LookupLogicalVolume(volumeId); err != lvm.ErrLogicalVolumeNotFound {
+		t.Fatalf("expected the volume to be removed, got err=%v", err)
 	}
 }
 
-func TestListVolumes_TwoVolumes(t *testing.T) {
+func TestDeleteVolume_TrashRetention(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
-	tag := "vg_asset_123"
-	client, clean := startTest(vgname, []string{pvname}, Tag(tag))
+	client, server, clean := prepareSetupTest(vgname, []string{pvname}, TrashRetention(time.Hour))
 	defer clean()
-	var infos []*csi.Volume
-	// Add the first volume.
-	req := testCreateVolumeRequest()
-	req.Name = "test-volume-1"
-	req.CapacityRange.RequiredBytes /= 3
-	resp, err := client.CreateVolume(context.Background(), req)
+	if err := server.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
 	if err != nil {
 		t.Fatal(err)
 	}
-	infos = append(infos, resp.GetVolume())
-	// Add the second volume.
-	req = testCreateVolumeRequest()
-	req.Name = "test-volume-2"
-	req.CapacityRange.RequiredBytes /= 2
-	resp, err = client.CreateVolume(context.Background(), req)
+	volumeId := createResp.GetVolume().GetId()
+	if _, err := client.DeleteVolume(context.Background(), testDeleteVolumeRequest(volumeId)); err != nil {
+		t.Fatal(err)
+	}
+	vg, err := lvm.LookupVolumeGroup(vgname)
 	if err != nil {
 		t.Fatal(err)
 	}
-	infos = append(infos, resp.GetVolume())
-	// Check that ListVolumes returns the two volumes.
-	listReq := testListVolumesRequest()
-	listResp, err := client.ListVolumes(context.Background(), listReq)
+	// The volume is renamed rather than removed.
+	if _, err := vg.LookupLogicalVolume(volumeId); err != lvm.ErrLogicalVolumeNotFound {
+		t.Fatalf("expected the volume to be moved to the trash, got err=%v", err)
+	}
+	trashed, err := vg.LookupLogicalVolume(trashNamePrefix + volumeId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	entries := listResp.GetEntries()
-	if len(entries) != len(infos) {
-		t.Fatalf("ListVolumes returned %v entries, expected %d.", len(entries), len(infos))
+	tags, err := trashed.Tags()
+	if err != nil {
+		t.Fatal(err)
 	}
-	nameTags := []string{"VN.test-volume-1", "VN.test-volume-2"}
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].GetVolume().GetCapacityBytes() < entries[j].GetVolume().GetCapacityBytes()
-	})
-	for i, entry := range entries {
-		had := false
-		for _, info := range infos {
-			if reflect.DeepEqual(info, entry.GetVolume()) {
-				had = true
-				break
-			}
-		}
-		if !had {
-			t.Fatalf("Cannot find volume info %+v in %+v.", entry.GetVolume(), infos)
-		}
-
-		// This validates that create and list both properly return the tags attribute.
-		attr := entry.GetVolume().GetAttributes()
-		tags := tagsFromAttributes(t, attr)
-		expected := []string{tag, nameTags[i]}
-		sort.Strings(expected)
-		sort.Strings(tags)
-		if !reflect.DeepEqual(tags, expected) {
-			t.Fatalf("unexpected tags: %v", tags)
-		}
+	expiry, ok := trashExpiryFromTags(tags)
+	if !ok {
+		t.Fatalf("expected an expiry tag in %v", tags)
+	}
+	// The trashed volume is not listed and a volume with the same name
+	// can be created.
+	listResp, err := client.ListVolumes(context.Background(), testListVolumesRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := listResp.GetEntries(); len(entries) != 0 {
+		t.Fatalf("expected no volumes to be listed but got %v", entries)
+	}
+	createResp, err = client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if createResp.GetVolume().GetId() == volumeId {
+		t.Fatalf("expected a new volume to be created")
+	}
+	// The trashed volume is kept until it expires.
+	if err := server.reapTrash(context.Background(), expiry.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vg.LookupLogicalVolume(trashed.Name()); err != nil {
+		t.Fatalf("expected the trashed volume to be kept, got err=%v", err)
+	}
+	if err := server.reapTrash(context.Background(), expiry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vg.LookupLogicalVolume(trashed.Name()); err != lvm.ErrLogicalVolumeNotFound {
+		t.Fatalf("expected the trashed volume to be purged, got err=%v", err)
+	}
+	if _, err := vg.LookupLogicalVolume(createResp.GetVolume().GetId()); err != nil {
+		t.Fatalf("expected the new volume to remain, got err=%v", err)
 	}
 }
 
-func tagsFromAttributes(t *testing.T, attr map[string]string) []string {
-	etags, ok := attr[attrTags]
-	if !ok {
-		t.Fatalf("volume attributes missing tags")
+func TestDeleteVolumeErasesData(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	// Create the volume that we'll be publishing.
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
 	}
-	buf, err := base64.RawURLEncoding.DecodeString(etags)
+	volumeId := createResp.GetVolume().GetId()
+	capacityBytes := createResp.GetVolume().GetCapacityBytes()
+	// Prepare a temporary mount directory.
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
 	if err != nil {
-		t.Fatal("failed to decode tags:", err)
+		t.Fatal(err)
 	}
-	var itags []interface{}
-	err = json.Unmarshal(buf, &itags)
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, volumeId)
+	if err := os.Mkdir(targetPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(targetPath)
+	// Publish the volume to /the/tmp/dir/volume-id
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+	_, err = client.NodePublishVolume(context.Background(), publishReq)
 	if err != nil {
-		t.Fatal("failed to unmarshal tags:", err)
+		t.Fatal(err)
 	}
-	tags := make([]string, len(itags))
-	for i := range itags {
-		tags[i] = itags[i].(string)
+	// Unpublish the volume when the test ends unless the test
+	// called unpublish already.
+	alreadyUnpublished := false
+	defer func() {
+		if alreadyUnpublished {
+			return
+		}
+		req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
+		_, err = client.NodeUnpublishVolume(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	// Ensure that the device was mounted.
+	if !targetPathIsMountPoint(publishReq.TargetPath) {
+		t.Fatalf("Expected volume to be mounted at %v.", publishReq.TargetPath)
+	}
+	// Create a file on the mounted volume.
+	file, err := os.Create(filepath.Join(targetPath, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fill the file with 1's.
+	ones := repeater{1}
+	wrote, err := io.CopyN(file, ones, int64(capacityBytes))
+	if err.(*os.PathError).Err != syscall.ENOSPC {
+		t.Fatalf("Expected ENOSPC but got %v", err)
+	}
+	file.Close()
+	// Check that we wrote at least half the volume's capacity full of ones.
+	// We can't check for equality due to filesystem metadata, etc.
+	if int64(wrote) < capacityBytes/2 {
+		t.Fatalf("Failed to write even half of the volume: %v of %v", wrote, capacityBytes)
+	}
+	// Unpublish the volume.
+	req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
+	_, err = client.NodeUnpublishVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alreadyUnpublished = true
+	deleteReq := testDeleteVolumeRequest(volumeId)
+	_, err = client.DeleteVolume(context.Background(), deleteReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Create a new volume and check that it contains only zeros.
+	createReq.Name += "-2"
+	createResp, err = client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId = createResp.GetVolume().GetId()
+	capacityBytes = createResp.GetVolume().GetCapacityBytes()
+	targetPath = filepath.Join(tmpdirPath, volumeId)
+	if file, err := os.Create(targetPath); err != nil {
+		t.Fatal(err)
+	} else {
+		// Immediately close the file, we're just creating it
+		// as a mount target.
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.RemoveAll(targetPath)
+	publishReq = testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
+	_, err = client.NodePublishVolume(context.Background(), publishReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
+		_, err = client.NodeUnpublishVolume(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	// Check that the device is filled with zeros.
+	n, ok := containsOnly(targetPath, 0)
+	if !ok {
+		t.Fatal("Expected device to consists of zeros only.")
+	}
+	if int64(n) != capacityBytes {
+		t.Fatalf("Bad read, expected device to have size %d but read only %d bytes", capacityBytes, n)
 	}
-	return tags
 }
 
-func testGetCapacityRequest(fstype string) *csi.GetCapacityRequest {
+func containsOnly(devicePath string, i byte) (uint64, bool) {
+	file, err := os.Open(devicePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+	idx := uint64(0)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			panic(err)
+		}
+		if b != 0 {
+			return idx, false
+		}
+		idx++
+	}
+	return idx, true
+}
+
+func TestControllerPublishVolumeNotSupported(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	req := &csi.ControllerPublishVolumeRequest{}
+	_, err := client.ControllerPublishVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrCallNotImplemented) {
+		t.Fatal(err)
+	}
+}
+
+func TestControllerUnpublishVolumeNotSupported(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	req := &csi.ControllerUnpublishVolumeRequest{}
+	_, err := client.ControllerUnpublishVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrCallNotImplemented) {
+		t.Fatal(err)
+	}
+}
+
+func testValidateVolumeCapabilitiesRequest(volumeId string, filesystem string, mountOpts []string) *csi.ValidateVolumeCapabilitiesRequest {
 	volumeCapabilities := []*csi.VolumeCapability{
 		{
 			AccessType: &csi.VolumeCapability_Block{
@@ -1165,7 +1779,8 @@ func testGetCapacityRequest(fstype string) *csi.GetCapacityRequest {
 		{
 			AccessType: &csi.VolumeCapability_Mount{
 				Mount: &csi.VolumeCapability_MountVolume{
-					FsType: fstype,
+					FsType:     filesystem,
+					MountFlags: mountOpts,
 				},
 			},
 			AccessMode: &csi.VolumeCapability_AccessMode{
@@ -1173,268 +1788,1430 @@ func testGetCapacityRequest(fstype string) *csi.GetCapacityRequest {
 			},
 		},
 	}
-	req := &csi.GetCapacityRequest{
+	req := &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           volumeId,
 		VolumeCapabilities: volumeCapabilities,
 	}
 	return req
 }
 
-type testGetCapacity struct {
-	numberOfPVs  uint64
-	params       map[string]string
-	createVolume bool
-	err          error
-}
-
-func (tc testGetCapacity) test(t *testing.T) {
+func TestValidateVolumeCapabilities_BlockVolume(t *testing.T) {
 	vgname := testvgname()
-	var pvnames []string
-	for ii := uint64(0); ii < tc.numberOfPVs; ii++ {
-		pvname, pvclean := testpv()
-		defer check(pvclean)
-		pvnames = append(pvnames, pvname)
-	}
-	client, clean := startTest(vgname, pvnames)
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
-	volumesize := int64(0)
-	if tc.createVolume {
-		volumesize = 40 << 20
-		createReq := testCreateVolumeRequest()
-		createReq.Name = "test-volume-1"
-		createReq.CapacityRange.RequiredBytes = volumesize
-		_, err := client.CreateVolume(context.Background(), createReq)
-		if err != nil {
-			t.Fatal(err)
-		}
-	}
-	req := testGetCapacityRequest("xfs")
-	req.Parameters = map[string]string{
-		"type":    "raid1",
-		"mirrors": "2",
-	}
-	resp, err := client.GetCapacity(context.Background(), req)
-	if tc.err != nil {
-		if !grpcErrorEqual(err, tc.err) {
-			t.Fatal(err)
-		}
-		return
+	// Create the volume that we'll be publishing.
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
 	}
+	volumeId := createResp.GetVolume().GetId()
+	req := testValidateVolumeCapabilitiesRequest(volumeId, "xfs", nil)
+	resp, err := client.ValidateVolumeCapabilities(context.Background(), req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	layout, err := takeVolumeLayoutFromParameters(dupParams(req.GetParameters()))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if layout.MinNumberOfDevices() > tc.numberOfPVs {
-		if resp.GetAvailableCapacity() != 0 {
-			t.Fatalf("Expected 0 bytes free.")
-		}
-		return
-	}
-	const extentsize = uint64(4 << 20)
-	// One extent per PV is reserved for metadata.
-	metadataExtents := tc.numberOfPVs
-	// Calculate the linear available capacity.
-	totalExtents := tc.numberOfPVs * pvsize / extentsize
-	// Subtract the per-PV metadata extents from the total expected number of extents.
-	totalAvailableExtents := totalExtents - metadataExtents
-	// Reduce the capacity by the required_bytes of the created volume (if any).
-	linearAvailableExtents := totalAvailableExtents - uint64(volumesize)/extentsize
-	// Reduce the capacity by one more extent per data copy as each needs
-	// to store metadata in a single extent. Then divide the remaining extents
-	// by the number of data copies.
-	expextents := (linearAvailableExtents - 3) / 3
-	// The remaining bytes we get by multiplying by the extentsize again.
-	expbytes := expextents * extentsize
-	if got := resp.GetAvailableCapacity(); uint64(got) != expbytes {
-		t.Fatalf("Expected %d bytes free but got %v.", expbytes, got)
+	if !resp.GetSupported() {
+		t.Fatal("Expected requested volume capabilities to be supported.")
 	}
 }
 
-func TestGetCapacity_NoVolumes(t *testing.T) {
-	testGetCapacity{
-		numberOfPVs:  1,
-		params:       nil,
-		createVolume: false,
-	}.test(t)
+func TestValidateVolumeCapabilities_MountVolume(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	// Create the volume that we'll be publishing.
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	// Publish the volume with fstype 'xfs' then unmount it.
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, volumeId)
+	if err := os.Mkdir(targetPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(targetPath)
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+	_, err = client.NodePublishVolume(context.Background(), publishReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unpublish the volume now that it has been formatted.
+	unpublishReq := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
+	_, err = client.NodeUnpublishVolume(context.Background(), unpublishReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateReq := testValidateVolumeCapabilitiesRequest(volumeId, "xfs", nil)
+	validateResp, err := client.ValidateVolumeCapabilities(context.Background(), validateReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validateResp.GetSupported() {
+		t.Fatal("Expected requested volume capabilities to be supported.")
+	}
 }
 
-func TestGetCapacity_NoVolumes_OneDisk_VolumeLayout_Linear(t *testing.T) {
-	testGetCapacity{
-		numberOfPVs:  1,
-		params:       map[string]string{"type": "linear"},
-		createVolume: false,
-	}.test(t)
+func TestValidateVolumeCapabilities_MissingVolume(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	// Create the volume that we'll be publishing.
+	validateReq := testValidateVolumeCapabilitiesRequest("foo", "xfs", nil)
+	_, err := client.ValidateVolumeCapabilities(context.Background(), validateReq)
+	if !grpcErrorEqual(err, ErrVolumeNotFound) {
+		t.Fatal(err)
+	}
 }
 
-func TestGetCapacity_NoVolumes_OneDisk_VolumeLayout_RAID1(t *testing.T) {
-	testGetCapacity{
-		numberOfPVs:  1,
-		params:       map[string]string{"type": "raid1"},
-		createVolume: false,
-	}.test(t)
+func TestValidateVolumeCapabilities_MountVolume_MismatchedFsTypes(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname}, SupportedFilesystem("ext4"))
+	defer clean()
+	// Create the volume that we'll be publishing.
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	// Publish the volume with fstype 'xfs' then unmount it.
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, volumeId)
+	if err := os.Mkdir(targetPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(targetPath)
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+	_, err = client.NodePublishVolume(context.Background(), publishReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unpublish the volume now that it has been formatted.
+	unpublishReq := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
+	_, err = client.NodeUnpublishVolume(context.Background(), unpublishReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateReq := testValidateVolumeCapabilitiesRequest(volumeId, "ext4", nil)
+	_, err = client.ValidateVolumeCapabilities(context.Background(), validateReq)
+	if !grpcErrorEqual(err, ErrMismatchedFilesystemType) {
+		t.Fatal(err)
+	}
 }
 
-func TestGetCapacity_NoVolumes_TwoDisks_VolumeLayout_RAID1(t *testing.T) {
-	testGetCapacity{
-		numberOfPVs:  2,
-		params:       map[string]string{"type": "raid1"},
-		createVolume: false,
-	}.test(t)
+func TestValidateVolumeCapabilities_UnsupportedFilesystem(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	validateReq := testValidateVolumeCapabilitiesRequest(volumeId, "ext4", nil)
+	validateResp, err := client.ValidateVolumeCapabilities(context.Background(), validateReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validateResp.GetSupported() {
+		t.Fatal("Expected requested volume capabilities to be unsupported.")
+	}
+	exp := `volume_capabilities[0]: confirmed; volume_capabilities[1]: denied: filesystem type "ext4" is not supported`
+	if validateResp.GetMessage() != exp {
+		t.Fatalf("Expected message %q but got %q", exp, validateResp.GetMessage())
+	}
 }
 
-func TestGetCapacity_NoVolumes_FourDisks_VolumeLayout_RAID1_Mirrors2(t *testing.T) {
-	testGetCapacity{
-		numberOfPVs:  4,
-		params:       map[string]string{"type": "raid1", "mirrors": "2"},
-		createVolume: false,
-	}.test(t)
+func TestValidateVolumeCapabilities_MultiNodeAccessMode(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	validateReq := testValidateVolumeCapabilitiesRequest(volumeId, "xfs", nil)
+	validateReq.VolumeCapabilities[0].AccessMode.Mode = csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+	validateResp, err := client.ValidateVolumeCapabilities(context.Background(), validateReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validateResp.GetSupported() {
+		t.Fatal("Expected requested volume capabilities to be unsupported.")
+	}
+	exp := "volume_capabilities[0]: denied: access mode MULTI_NODE_MULTI_WRITER is not supported; volume_capabilities[1]: confirmed"
+	if validateResp.GetMessage() != exp {
+		t.Fatalf("Expected message %q but got %q", exp, validateResp.GetMessage())
+	}
 }
 
-func TestGetCapacity_OneVolume(t *testing.T) {
-	testGetCapacity{
-		numberOfPVs:  1,
-		params:       nil,
-		createVolume: true,
-	}.test(t)
+func testListVolumesRequest() *csi.ListVolumesRequest {
+	req := &csi.ListVolumesRequest{
+		MaxEntries:    0,
+		StartingToken: "",
+	}
+	return req
 }
 
-func TestGetCapacity_OneVolume_VolumeLayout_Linear(t *testing.T) {
-	testGetCapacity{
-		numberOfPVs:  1,
-		params:       map[string]string{"type": "linear"},
-		createVolume: true,
-	}.test(t)
+func TestListVolumes_NoVolumes(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	req := testListVolumesRequest()
+	resp, err := client.ListVolumes(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetEntries()) != 0 {
+		t.Fatal("Expected no entries.")
+	}
 }
 
-func TestGetCapacity_OneVolume_TwoDisks_VolumeLayout_RAID1(t *testing.T) {
-	testGetCapacity{
-		numberOfPVs:  2,
-		params:       map[string]string{"type": "linear"},
-		createVolume: true,
-	}.test(t)
+func TestListVolumes_MissingDevice(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	// Remove the device node.
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv, err := vg.LookupLogicalVolume(volumeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := lv.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.ListVolumes(context.Background(), testListVolumesRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetEntries()) != 1 {
+		t.Fatalf("Expected 1 entry but got %v", resp.GetEntries())
+	}
+	attr := resp.GetEntries()[0].GetVolume().GetAttributes()
+	if got := attr[attrConditionAbnormal]; got != "true" {
+		t.Fatalf("Expected abnormal volume but got %v=%q", attrConditionAbnormal, got)
+	}
+	if msg := attr[attrConditionMessage]; !strings.Contains(msg, "device "+path+" is missing") {
+		t.Fatalf("Unexpected condition message %q", msg)
+	}
 }
 
-func TestGetCapacity_OneVolume_FourDisks_VolumeLayout_Mirror2(t *testing.T) {
-	testGetCapacity{
+func TestListVolumes_TwoVolumes(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	tag := "vg_asset_123"
+	client, clean := startTest(vgname, []string{pvname}, Tag(tag))
+	defer clean()
+	var infos []*csi.Volume
+	// Add the first volume.
+	req := testCreateVolumeRequest()
+	req.Name = "test-volume-1"
+	req.CapacityRange.RequiredBytes /= 3
+	resp, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos = append(infos, resp.GetVolume())
+	// Add the second volume.
+	req = testCreateVolumeRequest()
+	req.Name = "test-volume-2"
+	req.CapacityRange.RequiredBytes /= 2
+	resp, err = client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos = append(infos, resp.GetVolume())
+	// Check that ListVolumes returns the two volumes.
+	listReq := testListVolumesRequest()
+	listResp, err := client.ListVolumes(context.Background(), listReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := listResp.GetEntries()
+	if len(entries) != len(infos) {
+		t.Fatalf("ListVolumes returned %v entries, expected %d.", len(entries), len(infos))
+	}
+	for _, info := range infos {
+		// CreateVolume additionally reports the size attributes.
+		delete(info.Attributes, attrExtentSize)
+		delete(info.Attributes, attrRoundedUpBytes)
+	}
+	nameTags := []string{"VN.test-volume-1", "VN.test-volume-2"}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].GetVolume().GetCapacityBytes() < entries[j].GetVolume().GetCapacityBytes()
+	})
+	for i, entry := range entries {
+		// ListVolumes additionally reports the volume condition.
+		attr := entry.GetVolume().GetAttributes()
+		if got := attr[attrConditionAbnormal]; got != "false" {
+			t.Fatalf("Expected healthy volume but got %v=%q, %v=%q", attrConditionAbnormal, got, attrConditionMessage, attr[attrConditionMessage])
+		}
+		delete(attr, attrConditionAbnormal)
+		had := false
+		for _, info := range infos {
+			if reflect.DeepEqual(info, entry.GetVolume()) {
+				had = true
+				break
+			}
+		}
+		if !had {
+			t.Fatalf("Cannot find volume info %+v in %+v.", entry.GetVolume(), infos)
+		}
+
+		// This validates that create and list both properly return the tags attribute.
+		tags := tagsFromAttributes(t, attr)
+		expected := []string{tag, nameTags[i], tagLayoutPrefix + "linear"}
+		sort.Strings(expected)
+		sort.Strings(tags)
+		if !reflect.DeepEqual(tags, expected) {
+			t.Fatalf("unexpected tags: %v", tags)
+		}
+	}
+}
+
+func TestListVolumes_Filter(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	ids := make(map[string]string)
+	for _, name := range []string{"web-1", "web-2", "db-1"} {
+		req := testCreateVolumeRequest()
+		req.Name = name
+		req.CapacityRange.RequiredBytes /= 4
+		resp, err := client.CreateVolume(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = resp.GetVolume().GetId()
+	}
+	listed := func(kv ...string) []string {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(kv...))
+		resp, err := client.ListVolumes(ctx, testListVolumesRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, entry := range resp.GetEntries() {
+			got = append(got, entry.GetVolume().GetId())
+		}
+		sort.Strings(got)
+		return got
+	}
+	exp := []string{ids["web-1"], ids["web-2"]}
+	sort.Strings(exp)
+	if got := listed(listNamePrefixKey, "web-"); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected volumes %v but got %v", exp, got)
+	}
+	exp = []string{ids["db-1"]}
+	if got := listed(listTagKey, "VN.db-1", listTagKey, tagLayoutPrefix+"linear"); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected volumes %v but got %v", exp, got)
+	}
+	if got := listed(listTagKey, "VN.db-1", listNamePrefixKey, "web-"); len(got) != 0 {
+		t.Fatalf("Expected no volumes but got %v", got)
+	}
+	if got := listed(); len(got) != 3 {
+		t.Fatalf("Expected all volumes but got %v", got)
+	}
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(listTagKey, "not a tag"))
+	if _, err := client.ListVolumes(ctx, testListVolumesRequest()); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for an invalid tag but got %v", err)
+	}
+}
+
+func TestSetup_Adoption(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	const adoptionTag = "csilvm.adopt"
+	opts := []ServerOpt{VolumePrefix("tenantA"), AdoptionTag(adoptionTag)}
+	client, clean := startTest(vgname, []string{pvname}, opts...)
+	defer clean()
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Logical volumes created outside of the plugin, one of which is
+	// open.
+	if _, err := vg.CreateLogicalVolume("data1", 80<<20, []string{adoptionTag}); err != nil {
+		t.Fatal(err)
+	}
+	data2, err := vg.CreateLogicalVolume("data2", 80<<20, []string{adoptionTag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := data2.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// Volumes are not adopted by ListVolumes.
+	resp, err := client.ListVolumes(context.Background(), testListVolumesRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetEntries()) != 0 {
+		t.Fatalf("Expected no volumes but got %v", resp.GetEntries())
+	}
+	// Volumes are adopted when the plugin starts.
+	s := NewServer(vgname, []string{pvname}, "xfs", opts...)
+	if err := s.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.ListVolumes(context.Background(), testListVolumesRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetEntries()) != 1 || resp.GetEntries()[0].GetVolume().GetId() != "tenantA_data1" {
+		t.Fatalf("Expected the adopted volume but got %v", resp.GetEntries())
+	}
+	lv, err := vg.LookupLogicalVolume("tenantA_data1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := lv.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tags)
+	if !reflect.DeepEqual(tags, []string{"LY.linear", "VN.data1"}) {
+		t.Fatalf("Expected the name and layout tags to replace the adoption tag but got %v", tags)
+	}
+	// The open volume is not renamed and keeps its adoption tag.
+	tags, err = data2.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{adoptionTag}) {
+		t.Fatalf("Expected the open volume to keep the adoption tag but got %v", tags)
+	}
+	if _, err := client.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "tenantA_data1"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListVolumes_VolumeUsageStats(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname}, VolumeUsageStats())
+	defer clean()
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	// The volume is not mounted so no usage is reported.
+	listResp, err := client.ListVolumes(context.Background(), testListVolumesRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr := listResp.GetEntries()[0].GetVolume().GetAttributes()
+	if _, ok := attr[attrUsageBytesUsed]; ok {
+		t.Fatalf("Expected no usage for an unmounted volume but got %v", attr)
+	}
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, volumeId)
+	if err := os.Mkdir(targetPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		req := testNodeUnpublishVolumeRequest(volumeId, targetPath)
+		if _, err := client.NodeUnpublishVolume(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if err := ioutil.WriteFile(filepath.Join(targetPath, "test"), make([]byte, 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	listResp, err = client.ListVolumes(context.Background(), testListVolumesRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr = listResp.GetEntries()[0].GetVolume().GetAttributes()
+	used, err := strconv.ParseUint(attr[attrUsageBytesUsed], 10, 64)
+	if err != nil {
+		t.Fatalf("Expected %v attribute: %v", attrUsageBytesUsed, err)
+	}
+	free, err := strconv.ParseUint(attr[attrUsageBytesFree], 10, 64)
+	if err != nil {
+		t.Fatalf("Expected %v attribute: %v", attrUsageBytesFree, err)
+	}
+	if used < 1<<20 {
+		t.Fatalf("Expected at least %v bytes used but got %v", 1<<20, used)
+	}
+	if capacity := uint64(createResp.GetVolume().GetCapacityBytes()); used+free > capacity {
+		t.Fatalf("Expected used (%v) and free (%v) bytes within the capacity %v", used, free, capacity)
+	}
+}
+
+func tagsFromAttributes(t *testing.T, attr map[string]string) []string {
+	etags, ok := attr[attrTags]
+	if !ok {
+		t.Fatalf("volume attributes missing tags")
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(etags)
+	if err != nil {
+		t.Fatal("failed to decode tags:", err)
+	}
+	var itags []interface{}
+	err = json.Unmarshal(buf, &itags)
+	if err != nil {
+		t.Fatal("failed to unmarshal tags:", err)
+	}
+	tags := make([]string, len(itags))
+	for i := range itags {
+		tags[i] = itags[i].(string)
+	}
+	return tags
+}
+
+func testGetCapacityRequest(fstype string) *csi.GetCapacityRequest {
+	volumeCapabilities := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Block{
+				Block: &csi.VolumeCapability_BlockVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+		{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{
+					FsType: fstype,
+				},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+	req := &csi.GetCapacityRequest{
+		VolumeCapabilities: volumeCapabilities,
+	}
+	return req
+}
+
+type testGetCapacity struct {
+	numberOfPVs  uint64
+	params       map[string]string
+	createVolume bool
+	err          error
+}
+
+func (tc testGetCapacity) test(t *testing.T) {
+	vgname := testvgname()
+	var pvnames []string
+	for ii := uint64(0); ii < tc.numberOfPVs; ii++ {
+		pvname, pvclean := testpv()
+		defer check(pvclean)
+		pvnames = append(pvnames, pvname)
+	}
+	client, clean := startTest(vgname, pvnames)
+	defer clean()
+	volumesize := int64(0)
+	if tc.createVolume {
+		volumesize = 40 << 20
+		createReq := testCreateVolumeRequest()
+		createReq.Name = "test-volume-1"
+		createReq.CapacityRange.RequiredBytes = volumesize
+		_, err := client.CreateVolume(context.Background(), createReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := testGetCapacityRequest("xfs")
+	req.Parameters = map[string]string{
+		"type":    "raid1",
+		"mirrors": "2",
+	}
+	resp, err := client.GetCapacity(context.Background(), req)
+	if tc.err != nil {
+		if !grpcErrorEqual(err, tc.err) {
+			t.Fatal(err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout, err := takeVolumeLayoutFromParameters(dupParams(req.GetParameters()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.MinNumberOfDevices() > tc.numberOfPVs {
+		if resp.GetAvailableCapacity() != 0 {
+			t.Fatalf("Expected 0 bytes free.")
+		}
+		return
+	}
+	const extentsize = uint64(4 << 20)
+	// One extent per PV is reserved for metadata.
+	metadataExtents := tc.numberOfPVs
+	// Calculate the linear available capacity.
+	totalExtents := tc.numberOfPVs * pvsize / extentsize
+	// Subtract the per-PV metadata extents from the total expected number of extents.
+	totalAvailableExtents := totalExtents - metadataExtents
+	// Reduce the capacity by the required_bytes of the created volume (if any).
+	linearAvailableExtents := totalAvailableExtents - uint64(volumesize)/extentsize
+	// Reduce the capacity by one more extent per data copy as each needs
+	// to store metadata in a single extent. Then divide the remaining extents
+	// by the number of data copies.
+	expextents := (linearAvailableExtents - 3) / 3
+	// The remaining bytes we get by multiplying by the extentsize again.
+	expbytes := expextents * extentsize
+	if got := resp.GetAvailableCapacity(); uint64(got) != expbytes {
+		t.Fatalf("Expected %d bytes free but got %v.", expbytes, got)
+	}
+}
+
+func TestGetCapacity_NoVolumes(t *testing.T) {
+	testGetCapacity{
+		numberOfPVs:  1,
+		params:       nil,
+		createVolume: false,
+	}.test(t)
+}
+
+func TestGetCapacity_NoVolumes_OneDisk_VolumeLayout_Linear(t *testing.T) {
+	testGetCapacity{
+		numberOfPVs:  1,
+		params:       map[string]string{"type": "linear"},
+		createVolume: false,
+	}.test(t)
+}
+
+func TestGetCapacity_NoVolumes_OneDisk_VolumeLayout_RAID1(t *testing.T) {
+	testGetCapacity{
+		numberOfPVs:  1,
+		params:       map[string]string{"type": "raid1"},
+		createVolume: false,
+	}.test(t)
+}
+
+func TestGetCapacity_VolumeLayout_RAID1_TooFewDisks(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	req := testGetCapacityRequest("xfs")
+	req.Parameters = map[string]string{"type": "raid1"}
+	resp, err := client.GetCapacity(context.Background(), req)
+	// Unlike CreateVolume, GetCapacity does not fail if the volume
+	// group has too few devices for the layout but reports that no
+	// capacity is available.
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetAvailableCapacity(); got != 0 {
+		t.Fatalf("Expected 0 bytes free but got %v.", got)
+	}
+}
+
+func TestGetCapacity_NoVolumes_TwoDisks_VolumeLayout_RAID1(t *testing.T) {
+	testGetCapacity{
+		numberOfPVs:  2,
+		params:       map[string]string{"type": "raid1"},
+		createVolume: false,
+	}.test(t)
+}
+
+func TestGetCapacity_NoVolumes_FourDisks_VolumeLayout_RAID1_Mirrors2(t *testing.T) {
+	testGetCapacity{
+		numberOfPVs:  4,
+		params:       map[string]string{"type": "raid1", "mirrors": "2"},
+		createVolume: false,
+	}.test(t)
+}
+
+func TestGetCapacity_OneVolume(t *testing.T) {
+	testGetCapacity{
+		numberOfPVs:  1,
+		params:       nil,
+		createVolume: true,
+	}.test(t)
+}
+
+func TestGetCapacity_OneVolume_VolumeLayout_Linear(t *testing.T) {
+	testGetCapacity{
+		numberOfPVs:  1,
+		params:       map[string]string{"type": "linear"},
+		createVolume: true,
+	}.test(t)
+}
+
+func TestGetCapacity_OneVolume_TwoDisks_VolumeLayout_RAID1(t *testing.T) {
+	testGetCapacity{
+		numberOfPVs:  2,
+		params:       map[string]string{"type": "linear"},
+		createVolume: true,
+	}.test(t)
+}
+
+func TestGetCapacity_OneVolume_FourDisks_VolumeLayout_Mirror2(t *testing.T) {
+	testGetCapacity{
 		numberOfPVs:  2,
 		params:       map[string]string{"type": "raid1", "mirrors": "2"},
 		createVolume: true,
 	}.test(t)
 }
 
-func TestGetCapacity_RemoveVolumeGroup(t *testing.T) {
+func TestGetCapacity_RemoveVolumeGroup(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname}, RemoveVolumeGroup())
+	defer clean()
+	req := testGetCapacityRequest("xfs")
+	resp, err := client.GetCapacity(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetAvailableCapacity(); got != 0 {
+		t.Fatalf("Expected 0 bytes free but got %v.", got)
+	}
+}
+
+func TestGetCapacity_FragmentationAttributes(t *testing.T) {
+	vgname := testvgname()
+	pvname1, pvclean1 := testpv()
+	defer check(pvclean1)
+	pvname2, pvclean2 := testpv()
+	defer check(pvclean2)
+	client, clean := startTest(vgname, []string{pvname1, pvname2})
+	defer clean()
+	// Fill most of the first device so that the free space is unevenly
+	// spread over the devices.
+	createReq := testCreateVolumeRequest()
+	createReq.CapacityRange.RequiredBytes = 60 << 20
+	createReq.CapacityRange.LimitBytes = 60 << 20
+	if _, err := client.CreateVolume(context.Background(), createReq); err != nil {
+		t.Fatal(err)
+	}
+	req := testGetCapacityRequest("xfs")
+	req.Parameters = map[string]string{"type": "raid1"}
+	var header metadata.MD
+	resp, err := client.GetCapacity(context.Background(), req, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr := func(key string) uint64 {
+		t.Helper()
+		values := header.Get(key)
+		if len(values) != 1 {
+			t.Fatalf("Expected one %v header but got %v", key, values)
+		}
+		v, err := strconv.ParseUint(values[0], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	allocatable := attr(attrLargestAllocatableBytes)
+	if allocatable == 0 || allocatable >= uint64(resp.GetAvailableCapacity()) {
+		t.Fatalf("Expected the largest allocatable size to be less than %v but got %v", resp.GetAvailableCapacity(), allocatable)
+	}
+	if region := attr(attrLargestFreeRegionBytes); region <= allocatable {
+		t.Fatalf("Expected the largest free region to exceed %v but got %v", allocatable, region)
+	}
+}
+
+func TestControllerGetCapabilities(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	req := &csi.ControllerGetCapabilitiesRequest{}
+	resp, err := client.ControllerGetCapabilities(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+	}
+	got := []csi.ControllerServiceCapability_RPC_Type{}
+	for _, capability := range resp.GetCapabilities() {
+		got = append(got, capability.GetRpc().GetType())
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("Expected capabilities %+v but got %+v", expected, got)
+	}
+}
+
+func TestControllerGetCapabilitiesRemoveVolumeGroup(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname}, RemoveVolumeGroup())
+	defer clean()
+	req := &csi.ControllerGetCapabilitiesRequest{}
+	resp, err := client.ControllerGetCapabilities(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+	}
+	got := []csi.ControllerServiceCapability_RPC_Type{}
+	for _, capability := range resp.GetCapabilities() {
+		got = append(got, capability.GetRpc().GetType())
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("Expected capabilities %+v but got %+v", expected, got)
+	}
+}
+
+// NodeService RPCs
+
+func testNodePublishVolumeRequest(volumeId string, targetPath string, filesystem string, mountOpts []string) *csi.NodePublishVolumeRequest {
+	var volumeCapability *csi.VolumeCapability
+	if filesystem == "block" {
+		volumeCapability = &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{
+				Block: &csi.VolumeCapability_BlockVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		}
+	} else {
+		volumeCapability = &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{
+					FsType:     filesystem,
+					MountFlags: mountOpts,
+				},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		}
+	}
+	const readonly = false
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         volumeId,
+		TargetPath:       targetPath,
+		VolumeCapability: volumeCapability,
+		Readonly:         readonly,
+	}
+	return req
+}
+
+func testNodeUnpublishVolumeRequest(volumeId string, targetPath string) *csi.NodeUnpublishVolumeRequest {
+	req := &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   volumeId,
+		TargetPath: targetPath,
+	}
+	return req
+}
+
+func TestNodePublishVolumeNodeUnpublishVolume_BlockVolume(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	// Create the volume that we'll be publishing.
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	// Prepare a temporary mount directory.
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, volumeId)
+	// As we're publishing as a BlockVolume we need to bind mount
+	// the device over a file, not a directory.
+	if file, err := os.Create(targetPath); err != nil {
+		t.Fatal(err)
+	} else {
+		// Immediately close the file, we're just creating it
+		// as a mount target.
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Remove(targetPath)
+	// Publish the volume to /the/tmp/dir/volume-id
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
+	_, err = client.NodePublishVolume(context.Background(), publishReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unpublish the volume when the test ends.
+	defer func() {
+		req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
+		_, err = client.NodeUnpublishVolume(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	mp, err := getMountAt(publishReq.TargetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mp == nil {
+		t.Fatalf("Expected volume to be mounted at %v.", publishReq.TargetPath)
+	}
+}
+
+func TestNodePublishVolume_StateDir(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	stateDir, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	client, clean := startTest(vgname, []string{pvname}, StateDir(stateDir))
+	defer clean()
+	var volumeIds []string
+	for i := 0; i < 2; i++ {
+		createReq := testCreateVolumeRequest()
+		createReq.Name = fmt.Sprintf("%s-%d", createReq.Name, i)
+		createResp, err := client.CreateVolume(context.Background(), createReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		volumeIds = append(volumeIds, createResp.GetVolume().GetId())
+	}
+	targetPath := filepath.Join(stateDir, "target")
+	if err := ioutil.WriteFile(targetPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	publishReq := testNodePublishVolumeRequest(volumeIds[0], targetPath, "block", nil)
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	statePath := filepath.Join(stateDir, vgname+"-targets.json")
+	registry := newTargetRegistry()
+	if err := registry.load(statePath); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := registry.owner(targetPath); !ok || id != volumeIds[0] {
+		t.Fatalf("Expected %v to be recorded at %v but got %q", volumeIds[0], targetPath, id)
+	}
+	// Unpublishing the wrong volume fails.
+	req := testNodeUnpublishVolumeRequest(volumeIds[1], targetPath)
+	if _, err := client.NodeUnpublishVolume(context.Background(), req); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Expected FailedPrecondition but got %v", err)
+	}
+	req = testNodeUnpublishVolumeRequest(volumeIds[0], targetPath)
+	if _, err := client.NodeUnpublishVolume(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.load(statePath); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := registry.owner(targetPath); ok {
+		t.Fatalf("Expected no volume to be recorded at %v but got %q", targetPath, id)
+	}
+}
+
+func TestReplayJournal_ProvisionerSecret(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	stateDir, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createResp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	// Record a DeleteVolume that was interrupted by a crash.
+	path := filepath.Join(stateDir, vgname+"-journal")
+	j, err := openJournal(path, 1, []journalEntry{{Seq: 1, Op: journalDelete, Volume: volumeId}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.f.Close()
+	// The interrupted request was authorized, replaying it needs no secret.
+	s := NewServer(vgname, []string{pvname}, "xfs", StateDir(stateDir), OperationJournal(), ProvisionerSecret("s3cret"))
+	if err := s.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.ReplayJournal(time.Minute)()
+	// Wait for the replay.
+	if err := s.serializer.sem.Acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	s.serializer.sem.Release(1)
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vg.LookupLogicalVolume(volumeId); err != lvm.ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected the volume to be deleted, got %v", err)
+	}
+	if pending, _, err := readJournal(path); err != nil || len(pending) != 0 {
+		t.Fatalf("Expected no pending operations, got %+v, %v", pending, err)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, server, clean := prepareSetupTest(vgname, []string{pvname}, Tag("inventory"))
+	defer clean()
+	if err := server.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, "target")
+	if err := ioutil.WriteFile(targetPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	defer client.NodeUnpublishVolume(context.Background(), testNodeUnpublishVolumeRequest(volumeId, targetPath))
+	rec := httptest.NewRecorder()
+	server.InventoryHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/inventory", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 but got %d: %s", rec.Code, rec.Body)
+	}
+	var inv Inventory
+	if err := json.Unmarshal(rec.Body.Bytes(), &inv); err != nil {
+		t.Fatal(err)
+	}
+	if inv.VolumeGroup.Name != vgname || !reflect.DeepEqual(inv.VolumeGroup.Tags, []string{"inventory"}) {
+		t.Fatalf("Unexpected volume group %+v", inv.VolumeGroup)
+	}
+	if inv.VolumeGroup.BytesFree >= inv.VolumeGroup.BytesTotal {
+		t.Fatalf("Expected the volume to use space in %+v", inv.VolumeGroup)
+	}
+	if len(inv.PhysicalVolumes) != 1 || inv.PhysicalVolumes[0].Name != pvname || inv.PhysicalVolumes[0].Health != "ok" || inv.PhysicalVolumes[0].SizeInBytes == 0 {
+		t.Fatalf("Unexpected physical volumes %+v", inv.PhysicalVolumes)
+	}
+	if len(inv.LogicalVolumes) != 1 {
+		t.Fatalf("Expected 1 logical volume but got %+v", inv.LogicalVolumes)
+	}
+	lv := inv.LogicalVolumes[0]
+	if lv.ID != volumeId || lv.Name != createReq.GetName() || lv.Layout != "linear" || lv.SizeInBytes != uint64(createResp.GetVolume().GetCapacityBytes()) || lv.Abnormal {
+		t.Fatalf("Unexpected logical volume %+v", lv)
+	}
+	if !reflect.DeepEqual(lv.Targets, []InventoryTarget{{Path: targetPath}}) {
+		t.Fatalf("Unexpected targets %+v", lv.Targets)
+	}
+}
+
+func TestMounts(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, server, clean := prepareSetupTest(vgname, []string{pvname})
+	defer clean()
+	if err := server.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	createResp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, "target")
+	if err := ioutil.WriteFile(targetPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	defer client.NodeUnpublishVolume(context.Background(), testNodeUnpublishVolumeRequest(volumeId, targetPath))
+	rec := httptest.NewRecorder()
+	server.InventoryHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/mounts", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 but got %d: %s", rec.Code, rec.Body)
+	}
+	var mounts []InventoryMount
+	if err := json.Unmarshal(rec.Body.Bytes(), &mounts); err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 1 || mounts[0].LogicalVolume != volumeId || mounts[0].Path != targetPath || !mounts[0].Published {
+		t.Fatalf("Unexpected mounts %+v", mounts)
+	}
+	// A mount the plugin did not make is reported as not published.
+	server.targets.remove(volumeId, targetPath)
+	got, err := server.Mounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Published {
+		t.Fatalf("Unexpected mounts %+v", got)
+	}
+}
+
+func TestNodePublishVolume_CreateTargetPath(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname}, RemoveVolumeGroup())
+	client, clean := startTest(vgname, []string{pvname}, CreateTargetPath())
 	defer clean()
-	req := testGetCapacityRequest("xfs")
-	resp, err := client.GetCapacity(context.Background(), req)
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if got := resp.GetAvailableCapacity(); got != 0 {
-		t.Fatalf("Expected 0 bytes free but got %v.", got)
+	defer os.RemoveAll(tmpdirPath)
+	for _, filesystem := range []string{"xfs", "block"} {
+		createReq := testCreateVolumeRequest()
+		createReq.Name += "-" + filesystem
+		createResp, err := client.CreateVolume(context.Background(), createReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		volumeId := createResp.GetVolume().GetId()
+		// The target path does not exist yet.
+		targetPath := filepath.Join(tmpdirPath, volumeId)
+		publishReq := testNodePublishVolumeRequest(volumeId, targetPath, filesystem, nil)
+		_, err = client.NodePublishVolume(context.Background(), publishReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !targetPathIsMountPoint(targetPath) {
+			t.Fatalf("Expected volume to be mounted at %v.", targetPath)
+		}
+		unpublishReq := testNodeUnpublishVolumeRequest(volumeId, targetPath)
+		if _, err := client.NodeUnpublishVolume(context.Background(), unpublishReq); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(targetPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isDir := filesystem != "block"; info.IsDir() != isDir {
+			t.Fatalf("Expected target path %v to be a directory: %v", targetPath, isDir)
+		}
 	}
 }
 
-func TestControllerGetCapabilities(t *testing.T) {
+func TestNodePublishVolume_QueueTuning(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
 	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
-	req := &csi.ControllerGetCapabilitiesRequest{}
-	resp, err := client.ControllerGetCapabilities(context.Background(), req)
+	createReq := testCreateVolumeRequest()
+	createReq.Parameters = map[string]string{"readahead": "1024", "scheduler": "none"}
+	createResp, err := client.CreateVolume(context.Background(), createReq)
 	if err != nil {
 		t.Fatal(err)
 	}
-	expected := []csi.ControllerServiceCapability_RPC_Type{
-		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
-		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+	volumeId := createResp.GetVolume().GetId()
+	// A retry with different tuning fails.
+	createReq.Parameters["readahead"] = "2048"
+	if _, err := client.CreateVolume(context.Background(), createReq); !grpcErrorEqual(err, ErrVolumeAlreadyExists) {
+		t.Fatal(err)
 	}
-	got := []csi.ControllerServiceCapability_RPC_Type{}
-	for _, capability := range resp.GetCapabilities() {
-		got = append(got, capability.GetRpc().GetType())
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(expected, got) {
-		t.Fatalf("Expected capabilities %+v but got %+v", expected, got)
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, "target")
+	if err := ioutil.WriteFile(targetPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	defer client.NodeUnpublishVolume(context.Background(), testNodeUnpublishVolumeRequest(volumeId, targetPath))
+	dev, err := filepath.EvalSymlinks(filepath.Join("/dev", vgname, volumeId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join("/sys/block", filepath.Base(dev), "queue", "read_ahead_kb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(buf)); got != "1024" {
+		t.Fatalf("Expected readahead 1024 but got %v", got)
 	}
 }
 
-func TestControllerGetCapabilitiesRemoveVolumeGroup(t *testing.T) {
+func TestNodePublishVolume_PartitionedVolume(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname}, RemoveVolumeGroup())
+	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
-	req := &csi.ControllerGetCapabilitiesRequest{}
-	resp, err := client.ControllerGetCapabilities(context.Background(), req)
+	createResp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
 	if err != nil {
 		t.Fatal(err)
 	}
-	expected := []csi.ControllerServiceCapability_RPC_Type{
-		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
-		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+	volumeId := createResp.GetVolume().GetId()
+	// Write a DOS partition table with a single partition, as a
+	// workload using the block volume might.
+	mbr := make([]byte, 512)
+	mbr[446+4] = 0x83
+	binary.LittleEndian.PutUint32(mbr[446+8:], 2048)
+	binary.LittleEndian.PutUint32(mbr[446+12:], 16384)
+	mbr[510], mbr[511] = 0x55, 0xaa
+	devicePath := filepath.Join("/dev", vgname, volumeId)
+	f, err := os.OpenFile(devicePath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
 	}
-	got := []csi.ControllerServiceCapability_RPC_Type{}
-	for _, capability := range resp.GetCapabilities() {
-		got = append(got, capability.GetRpc().GetType())
+	if _, err := f.Write(mbr); err != nil {
+		f.Close()
+		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(expected, got) {
-		t.Fatalf("Expected capabilities %+v but got %+v", expected, got)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	mountPath := filepath.Join(tmpdirPath, "mount")
+	if err := os.Mkdir(mountPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The volume cannot be published as a filesystem.
+	publishReq := testNodePublishVolumeRequest(volumeId, mountPath, "xfs", nil)
+	_, err = client.NodePublishVolume(context.Background(), publishReq)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Expected FailedPrecondition but got %v", err)
+	}
+	if info, ok := ErrorReason(err); !ok || info.Reason != ReasonDevicePartitioned {
+		t.Fatalf("Expected reason %v but got %v", ReasonDevicePartitioned, err)
+	}
+	// It can still be published as a block volume.
+	blockPath := filepath.Join(tmpdirPath, "block")
+	if err := ioutil.WriteFile(blockPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	publishReq = testNodePublishVolumeRequest(volumeId, blockPath, "block", nil)
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	defer client.NodeUnpublishVolume(context.Background(), testNodeUnpublishVolumeRequest(volumeId, blockPath))
+	// Retrying CreateVolume with a block capability succeeds.
+	createReq := testCreateVolumeRequest()
+	createReq.VolumeCapabilities = createReq.VolumeCapabilities[:1]
+	if _, err := client.CreateVolume(context.Background(), createReq); err != nil {
+		t.Fatal(err)
 	}
 }
 
-// NodeService RPCs
-
-func testNodePublishVolumeRequest(volumeId string, targetPath string, filesystem string, mountOpts []string) *csi.NodePublishVolumeRequest {
-	var volumeCapability *csi.VolumeCapability
-	if filesystem == "block" {
-		volumeCapability = &csi.VolumeCapability{
-			AccessType: &csi.VolumeCapability_Block{
-				Block: &csi.VolumeCapability_BlockVolume{},
-			},
-			AccessMode: &csi.VolumeCapability_AccessMode{
-				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
-			},
-		}
-	} else {
-		volumeCapability = &csi.VolumeCapability{
-			AccessType: &csi.VolumeCapability_Mount{
-				Mount: &csi.VolumeCapability_MountVolume{
-					FsType:     filesystem,
-					MountFlags: mountOpts,
-				},
-			},
-			AccessMode: &csi.VolumeCapability_AccessMode{
-				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
-			},
-		}
+func TestNodePublishVolume_FilesystemLabelAndUUID(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createReq := testCreateVolumeRequest()
+	createReq.Name = "pvc-6f1f4f9e-3f3c-4c3a"
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
 	}
-	const readonly = false
-	req := &csi.NodePublishVolumeRequest{
-		VolumeId:         volumeId,
-		TargetPath:       targetPath,
-		VolumeCapability: volumeCapability,
-		Readonly:         readonly,
+	volumeId := createResp.GetVolume().GetId()
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, volumeId)
+	if err := os.Mkdir(targetPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	defer client.NodeUnpublishVolume(context.Background(), testNodeUnpublishVolumeRequest(volumeId, targetPath))
+	devicePath := filepath.Join("/dev", vgname, volumeId)
+	output, err := exec.Command("blkid", "-c", "/dev/null", "-s", "LABEL", "-o", "value", devicePath).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The label is truncated to the 12 characters xfs allows.
+	if label := strings.TrimSpace(string(output)); label != "pvc-6f1f4f9e" {
+		t.Fatalf("Expected filesystem label pvc-6f1f4f9e but got %q", label)
+	}
+	output, err = exec.Command("blkid", "-c", "/dev/null", "-s", "UUID", "-o", "value", devicePath).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv, err := vg.LookupLogicalVolume(volumeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := lv.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "FS." + strings.TrimSpace(string(output))
+	if !containsString(tags, exp) {
+		t.Fatalf("Expected tag %v in %v", exp, tags)
 	}
-	return req
 }
 
-func testNodeUnpublishVolumeRequest(volumeId string, targetPath string) *csi.NodeUnpublishVolumeRequest {
-	req := &csi.NodeUnpublishVolumeRequest{
-		VolumeId:   volumeId,
-		TargetPath: targetPath,
+func TestNodePublishVolume_AtomicPublish(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	atomicDir, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(atomicDir)
+	// A staged mount left behind by a crash is unmounted by Setup.
+	stalePath := filepath.Join(atomicDir, "stale")
+	if err := os.Mkdir(stalePath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := mount("tmpfs", stalePath, "tmpfs", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	client, clean := startTest(vgname, []string{pvname}, AtomicPublish(atomicDir))
+	defer clean()
+	defer unmount(atomicDir, 0)
+	if targetPathIsMountPoint(stalePath) {
+		t.Fatalf("Expected the stale staged mount at %v to be unmounted", stalePath)
+	}
+	if mp, err := getMountAt(atomicDir); err != nil || mp == nil || mp.isShared() {
+		t.Fatalf("Expected %v to be a private mount: %+v, err=%v", atomicDir, mp, err)
+	}
+	createResp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	publishReq := testNodePublishVolumeRequest(volumeId, tmpdirPath, "xfs", nil)
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	if !targetPathIsMountPoint(tmpdirPath) {
+		t.Fatalf("Expected volume to be mounted at %v.", tmpdirPath)
+	}
+	// The mount was moved and the staging directory removed.
+	if _, err := os.Stat(filepath.Join(atomicDir, volumeId)); !os.IsNotExist(err) {
+		t.Fatalf("Expected the staging path to be removed: err=%v", err)
+	}
+	// Publishing again is idempotent.
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	unpublishReq := testNodeUnpublishVolumeRequest(volumeId, tmpdirPath)
+	if _, err := client.NodeUnpublishVolume(context.Background(), unpublishReq); err != nil {
+		t.Fatal(err)
+	}
+	if targetPathIsMountPoint(tmpdirPath) {
+		t.Fatalf("Expected volume to be unmounted at %v.", tmpdirPath)
 	}
-	return req
 }
 
-func TestNodePublishVolumeNodeUnpublishVolume_BlockVolume(t *testing.T) {
+func TestNodePublishVolumeNodeUnpublishVolume_MountVolume(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
@@ -1454,42 +3231,89 @@ func TestNodePublishVolumeNodeUnpublishVolume_BlockVolume(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdirPath)
 	targetPath := filepath.Join(tmpdirPath, volumeId)
-	// As we're publishing as a BlockVolume we need to bind mount
-	// the device over a file, not a directory.
-	if file, err := os.Create(targetPath); err != nil {
+	if err := os.Mkdir(targetPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(targetPath)
+	// Publish the volume to /the/tmp/dir/volume-id
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+	_, err = client.NodePublishVolume(context.Background(), publishReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unpublish the volume when the test ends unless the test
+	// called unpublish already.
+	alreadyUnpublished := false
+	defer func() {
+		if alreadyUnpublished {
+			return
+		}
+		req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
+		_, err = client.NodeUnpublishVolume(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	// Ensure that the device was mounted.
+	if !targetPathIsMountPoint(publishReq.TargetPath) {
+		t.Fatalf("Expected volume to be mounted at %v.", publishReq.TargetPath)
+	}
+	// Create a file on the mounted volume.
+	file, err := os.Create(filepath.Join(targetPath, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	// Check that the file exists where it is expected.
+	matches, err := filepath.Glob(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("Expected to see only file %v but got %v.", file.Name(), matches)
+	}
+	if matches[0] != file.Name() {
+		t.Fatalf("Expected to see file %v but got %v.", file.Name(), matches[0])
+	}
+	// Unpublish to check that the file is now missing.
+	req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
+	_, err = client.NodeUnpublishVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alreadyUnpublished = true
+	// Check that the targetPath is now no longer a mountpoint.
+	if targetPathIsMountPoint(publishReq.TargetPath) {
+		t.Fatalf("Expected target path %v not to be a mountpoint.", publishReq.TargetPath)
+	}
+	// Check that the file is now missing.
+	matches, err = filepath.Glob(file.Name())
+	if err != nil {
 		t.Fatal(err)
-	} else {
-		// Immediately close the file, we're just creating it
-		// as a mount target.
-		if err := file.Close(); err != nil {
-			t.Fatal(err)
-		}
 	}
-	defer os.Remove(targetPath)
-	// Publish the volume to /the/tmp/dir/volume-id
-	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
+	if len(matches) != 0 {
+		t.Fatalf("Expected to see no files but got %v.", matches)
+	}
+	// Publish again to make sure the file comes back
 	_, err = client.NodePublishVolume(context.Background(), publishReq)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Unpublish the volume when the test ends.
-	defer func() {
-		req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
-		_, err = client.NodeUnpublishVolume(context.Background(), req)
-		if err != nil {
-			t.Fatal(err)
-		}
-	}()
-	mp, err := getMountAt(publishReq.TargetPath)
+	alreadyUnpublished = false
+	// Check that the file exists where it is expected.
+	matches, err = filepath.Glob(file.Name())
 	if err != nil {
 		t.Fatal(err)
 	}
-	if mp == nil {
-		t.Fatalf("Expected volume to be mounted at %v.", publishReq.TargetPath)
+	if len(matches) != 1 {
+		t.Fatalf("Expected to see only file %v but got %v.", file.Name(), matches)
+	}
+	if matches[0] != file.Name() {
+		t.Fatalf("Expected to see file %v but got %v.", file.Name(), matches[0])
 	}
 }
 
-func TestNodePublishVolumeNodeUnpublishVolume_MountVolume(t *testing.T) {
+func TestNodePublishVolumeNodeUnpublishVolume_MountVolume_UnspecifiedFS(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
@@ -1514,7 +3338,7 @@ func TestNodePublishVolumeNodeUnpublishVolume_MountVolume(t *testing.T) {
 	}
 	defer os.RemoveAll(targetPath)
 	// Publish the volume to /the/tmp/dir/volume-id
-	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "", nil)
 	_, err = client.NodePublishVolume(context.Background(), publishReq)
 	if err != nil {
 		t.Fatal(err)
@@ -1591,7 +3415,7 @@ func TestNodePublishVolumeNodeUnpublishVolume_MountVolume(t *testing.T) {
 	}
 }
 
-func TestNodePublishVolumeNodeUnpublishVolume_MountVolume_UnspecifiedFS(t *testing.T) {
+func TestNodePublishVolumeNodeUnpublishVolume_MountVolume_ReadOnly(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
@@ -1615,8 +3439,8 @@ func TestNodePublishVolumeNodeUnpublishVolume_MountVolume_UnspecifiedFS(t *testi
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(targetPath)
-	// Publish the volume to /the/tmp/dir/volume-id
-	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "", nil)
+	// Publish the volume.
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
 	_, err = client.NodePublishVolume(context.Background(), publishReq)
 	if err != nil {
 		t.Fatal(err)
@@ -1691,22 +3515,63 @@ func TestNodePublishVolumeNodeUnpublishVolume_MountVolume_UnspecifiedFS(t *testi
 	if matches[0] != file.Name() {
 		t.Fatalf("Expected to see file %v but got %v.", file.Name(), matches[0])
 	}
+	// Unpublish to volume so that we can republish it as readonly.
+	req = testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
+	_, err = client.NodeUnpublishVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alreadyUnpublished = true
+	// Publish the volume again, as SINGLE_NODE_READER_ONLY (ie., readonly).
+	targetPathRO := filepath.Join(tmpdirPath, volumeId+"-ro")
+	if err := os.Mkdir(targetPathRO, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(targetPathRO)
+	publishReqRO := testNodePublishVolumeRequest(volumeId, targetPathRO, "xfs", nil)
+	publishReqRO.VolumeCapability.AccessMode.Mode = csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
+	_, err = client.NodePublishVolume(context.Background(), publishReqRO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		req := testNodeUnpublishVolumeRequest(volumeId, publishReqRO.TargetPath)
+		_, err = client.NodeUnpublishVolume(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	// Check that the file exists at the new, readonly targetPath.
+	roFilepath := filepath.Join(publishReqRO.TargetPath, filepath.Base(file.Name()))
+	matches, err = filepath.Glob(roFilepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("Expected to see only file %v but got %v.", file.Name(), matches)
+	}
+	if matches[0] != roFilepath {
+		t.Fatalf("Expected to see file %v but got %v.", roFilepath, matches[0])
+	}
+	// Check that we cannot create a new file at this location.
+	_, err = os.Create(roFilepath + ".2")
+	if err.(*os.PathError).Err != syscall.EROFS {
+		t.Fatal("Expected file creation to fail due to read-only filesystem.")
+	}
 }
 
-func TestNodePublishVolumeNodeUnpublishVolume_MountVolume_ReadOnly(t *testing.T) {
+func TestNodePublishVolume_MountVolume_ReadOnly_DirtyLog(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
 	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
-	// Create the volume that we'll be publishing.
 	createReq := testCreateVolumeRequest()
 	createResp, err := client.CreateVolume(context.Background(), createReq)
 	if err != nil {
 		t.Fatal(err)
 	}
 	volumeId := createResp.GetVolume().GetId()
-	// Prepare a temporary mount directory.
 	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
 	if err != nil {
 		t.Fatal(err)
@@ -1716,133 +3581,327 @@ func TestNodePublishVolumeNodeUnpublishVolume_MountVolume_ReadOnly(t *testing.T)
 	if err := os.Mkdir(targetPath, 0755); err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(targetPath)
-	// Publish the volume.
 	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(targetPath, "test"), []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crash of the writer by shutting down the filesystem
+	// without flushing its log.
+	if out, err := exec.Command("xfs_io", "-x", "-c", "shutdown", targetPath).CombinedOutput(); err != nil {
+		t.Fatalf("xfs_io shutdown failed: %v: %s", err, out)
+	}
+	unpublishReq := testNodeUnpublishVolumeRequest(volumeId, targetPath)
+	if _, err := client.NodeUnpublishVolume(context.Background(), unpublishReq); err != nil {
+		t.Fatal(err)
+	}
+	// The log cannot be replayed on a readonly device.
+	lvname := vgname + "/" + volumeId
+	if out, err := exec.Command("lvchange", "--permission", "r", lvname).CombinedOutput(); err != nil {
+		t.Fatalf("lvchange failed: %v: %s", err, out)
+	}
+	defer func() {
+		if out, err := exec.Command("lvchange", "--permission", "rw", lvname).CombinedOutput(); err != nil {
+			t.Fatalf("lvchange failed: %v: %s", err, out)
+		}
+	}()
+	publishReq.VolumeCapability.AccessMode.Mode = csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.NodeUnpublishVolume(context.Background(), unpublishReq); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNodePublishVolume_BlockVolume_Idempotent(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	// Create the volume that we'll be publishing.
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	// Prepare a temporary mount directory.
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, volumeId)
+	// As we're publishing as a BlockVolume we need to bind mount
+	// the device over a file, not a directory.
+	if file, err := os.Create(targetPath); err != nil {
+		t.Fatal(err)
+	} else {
+		// Immediately close the file, we're just creating it
+		// as a mount target.
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Remove(targetPath)
+	// Publish the volume to /the/tmp/dir/volume-id
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
 	_, err = client.NodePublishVolume(context.Background(), publishReq)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Unpublish the volume when the test ends unless the test
-	// called unpublish already.
-	alreadyUnpublished := false
+	// Unpublish the volume when the test ends.
 	defer func() {
-		if alreadyUnpublished {
-			return
-		}
 		req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
 		_, err = client.NodeUnpublishVolume(context.Background(), req)
 		if err != nil {
 			t.Fatal(err)
 		}
 	}()
-	// Ensure that the device was mounted.
-	if !targetPathIsMountPoint(publishReq.TargetPath) {
-		t.Fatalf("Expected volume to be mounted at %v.", publishReq.TargetPath)
+	// Check that calling NodePublishVolume with the same
+	// parameters succeeds and doesn't mount anything new at
+	// targetPath.
+	mountsBefore, err := getMountsAt(publishReq.TargetPath)
+	if err != nil {
+		t.Fatal(err)
 	}
-	// Create a file on the mounted volume.
+	publishReq = testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
+	_, err = client.NodePublishVolume(context.Background(), publishReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountsAfter, err := getMountsAt(publishReq.TargetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mountsBefore, mountsAfter) {
+		t.Fatal("Expected idempotent publish to not mount anything new at targetPath.")
+	}
+}
+
+func TestNodePublishVolume_BlockVolume_ReadOnly(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createResp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	var targetPaths []string
+	for _, name := range []string{"ro", "rw"} {
+		targetPath := filepath.Join(tmpdirPath, name)
+		if err := ioutil.WriteFile(targetPath, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		targetPaths = append(targetPaths, targetPath)
+	}
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv, err := vg.LookupLogicalVolume(volumeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devicePath, err := lv.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceReadonly := func() bool {
+		out, err := exec.Command("blockdev", "--getro", devicePath).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out)) == "1"
+	}
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPaths[0], "block", nil)
+	publishReq.VolumeCapability.AccessMode.Mode = csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	if !deviceReadonly() {
+		t.Fatal("Expected the device to be readonly")
+	}
+	f, err := os.OpenFile(targetPaths[0], os.O_WRONLY, 0)
+	if err == nil {
+		_, err = f.Write(make([]byte, 512))
+		f.Close()
+	}
+	if err == nil {
+		t.Fatal("Expected the readonly device not to be writable")
+	}
+	// The volume cannot also be published read-write.
+	publishReq = testNodePublishVolumeRequest(volumeId, targetPaths[1], "block", nil)
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); !grpcErrorEqual(err, ErrVolumePublishedRO) {
+		t.Fatalf("Expected %v but got %v", ErrVolumePublishedRO, err)
+	}
+	if _, err := client.NodeUnpublishVolume(context.Background(), testNodeUnpublishVolumeRequest(volumeId, targetPaths[0])); err != nil {
+		t.Fatal(err)
+	}
+	if deviceReadonly() {
+		t.Fatal("Expected the device to be read-write after the last readonly unpublish")
+	}
+}
+
+func TestNodePublishVolume_SingleNodeMultiWriter(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createReq := testCreateVolumeRequest()
+	for _, volumeCapability := range createReq.VolumeCapabilities {
+		volumeCapability.AccessMode.Mode = accessModeSingleNodeMultiWriter
+	}
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, volumeId)
+	if err := os.Mkdir(targetPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+	publishReq.VolumeCapability.AccessMode.Mode = accessModeSingleNodeSingleWriter
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		req := testNodeUnpublishVolumeRequest(volumeId, targetPath)
+		if _, err := client.NodeUnpublishVolume(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	// The volume is writable.
 	file, err := os.Create(filepath.Join(targetPath, "test"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	file.Close()
-	// Check that the file exists where it is expected.
-	matches, err := filepath.Glob(file.Name())
+	file.Close()
+}
+
+func TestNodePublishVolume_MountVolume_MultipleTargets(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createResp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(matches) != 1 {
-		t.Fatalf("Expected to see only file %v but got %v.", file.Name(), matches)
-	}
-	if matches[0] != file.Name() {
-		t.Fatalf("Expected to see file %v but got %v.", file.Name(), matches[0])
+	defer os.RemoveAll(tmpdirPath)
+	// Publish the volume read-write and then readonly at a second
+	// target path.
+	rwPath := filepath.Join(tmpdirPath, "rw")
+	roPath := filepath.Join(tmpdirPath, "ro")
+	for _, targetPath := range []string{rwPath, roPath} {
+		if err := os.Mkdir(targetPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+		publishReq.Readonly = targetPath == roPath
+		if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
+			t.Fatal(err)
+		}
+		defer func(targetPath string) {
+			req := testNodeUnpublishVolumeRequest(volumeId, targetPath)
+			if _, err := client.NodeUnpublishVolume(context.Background(), req); err != nil {
+				t.Fatal(err)
+			}
+		}(targetPath)
 	}
-	// Unpublish to check that the file is now missing.
-	req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
-	_, err = client.NodeUnpublishVolume(context.Background(), req)
-	if err != nil {
+	if err := ioutil.WriteFile(filepath.Join(rwPath, "test"), []byte("hello"), 0644); err != nil {
 		t.Fatal(err)
 	}
-	alreadyUnpublished = true
-	// Check that the targetPath is now no longer a mountpoint.
-	if targetPathIsMountPoint(publishReq.TargetPath) {
-		t.Fatalf("Expected target path %v not to be a mountpoint.", publishReq.TargetPath)
-	}
-	// Check that the file is now missing.
-	matches, err = filepath.Glob(file.Name())
-	if err != nil {
+	// The file is visible at the readonly target path.
+	if _, err := os.Stat(filepath.Join(roPath, "test")); err != nil {
 		t.Fatal(err)
 	}
-	if len(matches) != 0 {
-		t.Fatalf("Expected to see no files but got %v.", matches)
+	if _, err := os.Create(filepath.Join(roPath, "other")); err == nil {
+		t.Fatalf("Expected the volume to be readonly at %v", roPath)
 	}
-	// Publish again to make sure the file comes back
-	_, err = client.NodePublishVolume(context.Background(), publishReq)
-	if err != nil {
+	// Publishing readonly again is idempotent.
+	publishReq := testNodePublishVolumeRequest(volumeId, roPath, "xfs", nil)
+	publishReq.Readonly = true
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
 		t.Fatal(err)
 	}
-	alreadyUnpublished = false
-	// Check that the file exists where it is expected.
-	matches, err = filepath.Glob(file.Name())
+}
+
+func TestNodePublishVolume_MountVolume_VolumeMountGroup(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createResp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(matches) != 1 {
-		t.Fatalf("Expected to see only file %v but got %v.", file.Name(), matches)
-	}
-	if matches[0] != file.Name() {
-		t.Fatalf("Expected to see file %v but got %v.", file.Name(), matches[0])
-	}
-	// Unpublish to volume so that we can republish it as readonly.
-	req = testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
-	_, err = client.NodeUnpublishVolume(context.Background(), req)
+	volumeId := createResp.GetVolume().GetId()
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
 	if err != nil {
 		t.Fatal(err)
 	}
-	alreadyUnpublished = true
-	// Publish the volume again, as SINGLE_NODE_READER_ONLY (ie., readonly).
-	targetPathRO := filepath.Join(tmpdirPath, volumeId+"-ro")
-	if err := os.Mkdir(targetPathRO, 0755); err != nil {
+	defer os.RemoveAll(tmpdirPath)
+	targetPath := filepath.Join(tmpdirPath, volumeId)
+	if err := os.Mkdir(targetPath, 0755); err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(targetPathRO)
-	publishReqRO := testNodePublishVolumeRequest(volumeId, targetPathRO, "xfs", nil)
-	publishReqRO.VolumeCapability.AccessMode.Mode = csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
-	_, err = client.NodePublishVolume(context.Background(), publishReqRO)
-	if err != nil {
+	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "xfs", nil)
+	publishReq.VolumeCapability.AccessType = &csi.VolumeCapability_Mount{
+		Mount: testMountVolume(t, "1234"),
+	}
+	if _, err := client.NodePublishVolume(context.Background(), publishReq); err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		req := testNodeUnpublishVolumeRequest(volumeId, publishReqRO.TargetPath)
-		_, err = client.NodeUnpublishVolume(context.Background(), req)
-		if err != nil {
+		req := testNodeUnpublishVolumeRequest(volumeId, targetPath)
+		if _, err := client.NodeUnpublishVolume(context.Background(), req); err != nil {
 			t.Fatal(err)
 		}
 	}()
-	// Check that the file exists at the new, readonly targetPath.
-	roFilepath := filepath.Join(publishReqRO.TargetPath, filepath.Base(file.Name()))
-	matches, err = filepath.Glob(roFilepath)
+	info, err := os.Stat(targetPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(matches) != 1 {
-		t.Fatalf("Expected to see only file %v but got %v.", file.Name(), matches)
-	}
-	if matches[0] != roFilepath {
-		t.Fatalf("Expected to see file %v but got %v.", roFilepath, matches[0])
+	if gid := info.Sys().(*syscall.Stat_t).Gid; gid != 1234 {
+		t.Fatalf("Expected the filesystem root to be owned by group 1234 but got %v", gid)
 	}
-	// Check that we cannot create a new file at this location.
-	_, err = os.Create(roFilepath + ".2")
-	if err.(*os.PathError).Err != syscall.EROFS {
-		t.Fatal("Expected file creation to fail due to read-only filesystem.")
+	if info.Mode()&os.ModeSetgid == 0 || info.Mode()&0070 != 0070 {
+		t.Fatalf("Expected the filesystem root to be setgid and group writable but got %v", info.Mode())
 	}
 }
 
-func TestNodePublishVolume_BlockVolume_Idempotent(t *testing.T) {
+func TestNodePublishVolume_SkipAutoActivation(t *testing.T) {
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
-	client, clean := startTest(vgname, []string{pvname})
+	client, clean := startTest(vgname, []string{pvname}, SkipAutoActivation())
 	defer clean()
 	// Create the volume that we'll be publishing.
 	createReq := testCreateVolumeRequest()
@@ -1851,58 +3910,41 @@ func TestNodePublishVolume_BlockVolume_Idempotent(t *testing.T) {
 		t.Fatal(err)
 	}
 	volumeId := createResp.GetVolume().GetId()
-	// Prepare a temporary mount directory.
+	// Deactivate the volume and check that activating the volume group,
+	// as the host's LVM does at boot, skips it.
+	lvname := vgname + "/" + volumeId
+	if out, err := exec.Command("lvchange", "--activate=n", lvname).CombinedOutput(); err != nil {
+		t.Fatalf("lvchange failed: err=%v output=%s", err, out)
+	}
+	if out, err := exec.Command("vgchange", "--activate=y", vgname).CombinedOutput(); err != nil {
+		t.Fatalf("vgchange failed: err=%v output=%s", err, out)
+	}
+	lvpath := filepath.Join("/dev", vgname, volumeId)
+	if _, err := os.Stat(lvpath); !os.IsNotExist(err) {
+		t.Fatalf("expected %v to be inactive, err=%v", lvpath, err)
+	}
+	// Prepare a temporary mount target.
 	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(tmpdirPath)
 	targetPath := filepath.Join(tmpdirPath, volumeId)
-	// As we're publishing as a BlockVolume we need to bind mount
-	// the device over a file, not a directory.
-	if file, err := os.Create(targetPath); err != nil {
+	if err := ioutil.WriteFile(targetPath, nil, 0644); err != nil {
 		t.Fatal(err)
-	} else {
-		// Immediately close the file, we're just creating it
-		// as a mount target.
-		if err := file.Close(); err != nil {
-			t.Fatal(err)
-		}
 	}
 	defer os.Remove(targetPath)
-	// Publish the volume to /the/tmp/dir/volume-id
+	// Publishing the volume activates it.
 	publishReq := testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
 	_, err = client.NodePublishVolume(context.Background(), publishReq)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Unpublish the volume when the test ends.
-	defer func() {
-		req := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
-		_, err = client.NodeUnpublishVolume(context.Background(), req)
-		if err != nil {
-			t.Fatal(err)
-		}
-	}()
-	// Check that calling NodePublishVolume with the same
-	// parameters succeeds and doesn't mount anything new at
-	// targetPath.
-	mountsBefore, err := getMountsAt(publishReq.TargetPath)
-	if err != nil {
-		t.Fatal(err)
-	}
-	publishReq = testNodePublishVolumeRequest(volumeId, targetPath, "block", nil)
-	_, err = client.NodePublishVolume(context.Background(), publishReq)
-	if err != nil {
-		t.Fatal(err)
-	}
-	mountsAfter, err := getMountsAt(publishReq.TargetPath)
+	unpublishReq := testNodeUnpublishVolumeRequest(volumeId, publishReq.TargetPath)
+	_, err = client.NodeUnpublishVolume(context.Background(), unpublishReq)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(mountsBefore, mountsAfter) {
-		t.Fatal("Expected idempotent publish to not mount anything new at targetPath.")
-	}
 }
 
 func TestNodePublishVolume_BlockVolume_TargetPathOccupied(t *testing.T) {
@@ -2330,6 +4372,40 @@ func TestProbe_MissingRequiredModule(t *testing.T) {
 	t.Log(err)
 }
 
+func TestProbe_MissingRequiredTool(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	// Hide all tools from Probe.
+	emptyDir, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(emptyDir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", emptyDir)
+	req := testProbeRequest()
+	_, err = client.Probe(context.Background(), req)
+	if info, ok := ErrorReason(err); !ok || info.Reason != ReasonToolsMissing {
+		t.Fatalf("expected reason %v instead of %v", ReasonToolsMissing, info)
+	}
+}
+
+func TestSetup_MissingRequiredTool(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	_, server, cleanup := prepareSetupTest(vgname, []string{pvname}, ProbeTools([]string{
+		"no_such_tool",
+	}))
+	defer cleanup()
+	if err := server.Setup(); err == nil {
+		t.Fatal("expected setup failure due to missing tool")
+	}
+}
+
 func TestProbe_MissingPhysicalVolumes(t *testing.T) {
 	scope := tally.NewTestScope("", nil)
 	loop1, err := lvm.CreateLoopDevice(pvsize)
@@ -2495,6 +4571,43 @@ func TestSetup_NewVolumeGroup_NewPhysicalVolumes_WithMalformedTag(t *testing.T)
 	}
 }
 
+func TestSetup_NewVolumeGroup_NewPhysicalVolumes_WithExtentSize(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	const extentSize = 1 << 20
+	_, server, clean := prepareSetupTest(vgname, []string{pvname}, ExtentSize(extentSize))
+	defer clean()
+	if err := server.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := vg.ExtentSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != extentSize {
+		t.Fatalf("Expected extent size %d but got %d", extentSize, size)
+	}
+}
+
+func TestSetup_NewVolumeGroup_NewPhysicalVolumes_WithInvalidExtentSize(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	const extentSize = 3 << 20
+	_, server, clean := prepareSetupTest(vgname, []string{pvname}, ExtentSize(extentSize))
+	defer clean()
+	experr := fmt.Sprintf("Invalid extent size %v: err=%v", extentSize, lvm.ErrInvalidExtentSize)
+	err := server.Setup()
+	if err == nil || err.Error() != experr {
+		t.Fatal(err)
+	}
+}
+
 func TestSetup_NewVolumeGroup_NonExistantPhysicalVolume(t *testing.T) {
 	vgname := testvgname()
 	pvnames := []string{"/dev/does/not/exist"}
@@ -2515,7 +4628,7 @@ func TestSetup_NewVolumeGroup_BusyPhysicalVolume(t *testing.T) {
 	defer check(pv2clean)
 	pvnames := []string{pv1name, pv2name}
 	// Format and mount loop1 so it appears busy.
-	if err := formatDevice(pv1name, "xfs"); err != nil {
+	if err := formatDevice(context.Background(), cmd.NewRunner(nil), pv1name, "xfs", ""); err != nil {
 		t.Fatal(err)
 	}
 	targetPath, err := ioutil.TempDir("", "csilvm_tests")
@@ -2564,6 +4677,55 @@ func TestSetup_NewVolumeGroup_FormattedPhysicalVolume(t *testing.T) {
 	}
 }
 
+func TestSetup_NewVolumeGroup_ForceDeviceInit(t *testing.T) {
+	vgname := testvgname()
+	pv1name, pv1clean := testpv()
+	defer check(pv1clean)
+	pv2name, pv2clean := testpv()
+	defer check(pv2clean)
+	// The ext4 superblock is not within the first 512 bytes so it
+	// survives zeroing the partition table.
+	if out, err := exec.Command("mkfs", "-t", "ext4", "-F", pv2name).CombinedOutput(); err != nil {
+		t.Fatalf("mkfs failed: err=%v output=%s", err, out)
+	}
+	pvnames := []string{pv1name, pv2name}
+	_, server, clean := prepareSetupTest(vgname, pvnames, ForceDeviceInit())
+	defer clean()
+	if err := server.Setup(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetup_NewVolumeGroup_ForceDeviceInit_BusyPhysicalVolume(t *testing.T) {
+	vgname := testvgname()
+	pv1name, pv1clean := testpv()
+	defer check(pv1clean)
+	// Format and mount the device so it appears busy.
+	if err := formatDevice(context.Background(), cmd.NewRunner(nil), pv1name, "xfs", ""); err != nil {
+		t.Fatal(err)
+	}
+	targetPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(targetPath)
+	if merr := syscall.Mount(pv1name, targetPath, "xfs", 0, ""); merr != nil {
+		t.Fatal(merr)
+	}
+	defer func() {
+		if merr := syscall.Unmount(targetPath, 0); merr != nil {
+			t.Fatal(merr)
+		}
+	}()
+	_, server, clean := prepareSetupTest(vgname, []string{pv1name}, ForceDeviceInit())
+	defer clean()
+	experr := fmt.Sprintf("Refusing to wipe signatures on %s: err=%s is mounted at %s", pv1name, pv1name, targetPath)
+	err = server.Setup()
+	if err == nil || err.Error() != experr {
+		t.Fatal(err)
+	}
+}
+
 func readPartitionTable(devicePath string) []byte {
 	file, err := os.Open(devicePath)
 	if err != nil {
@@ -2983,6 +5145,87 @@ func TestNodeGetCapabilitiesRemoveVolumeGroup(t *testing.T) {
 	}
 }
 
+func TestCreateVolume_MetadataParameters(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname}, MetadataParameter("owner"))
+	defer clean()
+	req := testCreateVolumeRequest()
+	req.Parameters = map[string]string{"owner": "team-a"}
+	resp, err := client.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetVolume().GetAttributes()["metadata.owner"]; got != "team-a" {
+		t.Fatalf("Expected owner 'team-a' but got %q", got)
+	}
+	listResp, err := client.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := listResp.GetEntries()
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 volume but got %v", entries)
+	}
+	if got := entries[0].GetVolume().GetAttributes()["metadata.owner"]; got != "team-a" {
+		t.Fatalf("Expected owner 'team-a' but got %q", got)
+	}
+	// Parameters that are not configured are still rejected.
+	req = testCreateVolumeRequest()
+	req.Name = "test-volume-2"
+	req.Parameters = map[string]string{"workload": "kafka"}
+	if _, err := client.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument but got %v", err)
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createReq := testCreateVolumeRequest()
+	createResp, err := client.CreateVolume(context.Background(), createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeID := createResp.GetVolume().GetId()
+	bundle := Diagnose(context.Background(), vgname, client)
+	if len(bundle.Errors) != 0 {
+		t.Fatalf("Unexpected errors: %v", bundle.Errors)
+	}
+	if bundle.VolumeGroup == nil || bundle.VolumeGroup.Name != vgname {
+		t.Fatalf("Expected volume group %v but got %+v", vgname, bundle.VolumeGroup)
+	}
+	if !reflect.DeepEqual(bundle.VolumeGroup.PhysicalVolumes, []string{pvname}) {
+		t.Fatalf("Expected physical volumes %v but got %v", []string{pvname}, bundle.VolumeGroup.PhysicalVolumes)
+	}
+	if len(bundle.VolumeGroup.LogicalVolumes) != 1 || bundle.VolumeGroup.LogicalVolumes[0].Name != volumeID {
+		t.Fatalf("Expected logical volume %v but got %+v", volumeID, bundle.VolumeGroup.LogicalVolumes)
+	}
+	if bundle.Server == nil || !bundle.Server.Ready {
+		t.Fatalf("Expected the server to be ready but got %+v", bundle.Server)
+	}
+	if !reflect.DeepEqual(bundle.Server.Volumes, []string{volumeID}) {
+		t.Fatalf("Expected volumes %v but got %v", []string{volumeID}, bundle.Server.Volumes)
+	}
+}
+
+func TestDiagnose_VolumeGroupNotFound(t *testing.T) {
+	bundle := Diagnose(context.Background(), testvgname(), nil)
+	if bundle.VolumeGroup != nil {
+		t.Fatalf("Expected no volume group but got %+v", bundle.VolumeGroup)
+	}
+	if len(bundle.Errors) != 1 {
+		t.Fatalf("Expected one error but got %v", bundle.Errors)
+	}
+	if bundle.ErrorCounts["UNKNOWN"] != 1 {
+		t.Fatalf("Expected the error to be counted but got %v", bundle.ErrorCounts)
+	}
+}
+
 func prepareSetupTest(vgname string, pvnames []string, serverOpts ...ServerOpt) (client *Client, server *Server, cleanupFn func()) {
 	var clean cleanup.Steps
 	defer func() {
@@ -3065,7 +5308,8 @@ func prepareSetupTest(vgname string, pvnames []string, serverOpts ...ServerOpt)
 	grpcServer := grpc.NewServer(opts...)
 	csi.RegisterIdentityServer(grpcServer, IdentityServerValidator(s))
 	csi.RegisterControllerServer(grpcServer, ControllerServerValidator(s, s.RemovingVolumeGroup(), s.SupportedFilesystems()))
-	csi.RegisterNodeServer(grpcServer, NodeServerValidator(s, s.RemovingVolumeGroup(), s.SupportedFilesystems()))
+	csi.RegisterNodeServer(grpcServer, NodeServerValidator(s, s.RemovingVolumeGroup(), s.SupportedFilesystems(), s.CreatesTargetPath()))
+	RegisterModifyServer(grpcServer, ModifyServerValidator(s, s.RemovingVolumeGroup()))
 	go func() {
 		err := grpcServer.Serve(lis)
 		if err != nil {
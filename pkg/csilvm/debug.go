@@ -0,0 +1,50 @@
+package csilvm
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/reflection"
+)
+
+// debugServicePrefixes are the method name prefixes of the services
+// registered by RegisterDebugServices.
+var debugServicePrefixes = []string{
+	"/grpc.reflection.",
+	"/grpc.channelz.",
+}
+
+// RegisterDebugServices registers the gRPC server reflection and channelz
+// services so that tools such as grpcurl can list and call the CSI services
+// and inspect the connections of the server, e.g., when diagnosing interop
+// problems with a CO.
+func RegisterDebugServices(s *grpc.Server) {
+	reflection.Register(s)
+	channelz.RegisterChannelzServiceToServer(s)
+}
+
+// isDebugMethod returns whether the method belongs to a service registered
+// by RegisterDebugServices.
+func isDebugMethod(fullMethod string) bool {
+	for _, prefix := range debugServicePrefixes {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipDebugServices returns an interceptor that runs the given interceptor
+// for all requests except those for the services registered by
+// RegisterDebugServices. The latter are handled immediately so that they are
+// neither limited nor serialized behind a hung CSI request.
+func SkipDebugServices(interceptor grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if info != nil && isDebugMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		return interceptor(ctx, req, info, handler)
+	}
+}
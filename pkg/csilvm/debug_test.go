@@ -0,0 +1,50 @@
+package csilvm
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestRegisterDebugServices(t *testing.T) {
+	s := grpc.NewServer()
+	RegisterDebugServices(s)
+	services := s.GetServiceInfo()
+	for _, name := range []string{"grpc.reflection.v1alpha.ServerReflection", "grpc.channelz.v1.Channelz"} {
+		if _, ok := services[name]; !ok {
+			t.Fatalf("expected the %v service to be registered, got %v", name, services)
+		}
+	}
+}
+
+func TestSkipDebugServices(t *testing.T) {
+	intercepted := 0
+	interceptor := SkipDebugServices(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		intercepted++
+		return handler(ctx, req)
+	})
+	handled := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled++
+		return nil, nil
+	}
+	for _, tt := range []struct {
+		method      string
+		intercepted int
+	}{
+		{"/grpc.channelz.v1.Channelz/GetTopChannels", 0},
+		{"/csi.v0.Controller/CreateVolume", 1},
+		{"/csi.v0.Identity/Probe", 2},
+	} {
+		if _, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler); err != nil {
+			t.Fatal(err)
+		}
+		if intercepted != tt.intercepted {
+			t.Fatalf("%v: expected %d intercepted requests, got %d", tt.method, tt.intercepted, intercepted)
+		}
+	}
+	if handled != 3 {
+		t.Fatalf("expected 3 handled requests, got %d", handled)
+	}
+}
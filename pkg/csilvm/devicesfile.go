@@ -0,0 +1,28 @@
+package csilvm
+
+import (
+	"os"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+)
+
+// registerDevices adds the devices and standby devices to the LVM devices
+// file set by lvm.SetDevicesFile, if any, as LVM ignores the devices that
+// it does not list. Devices that do not exist are skipped as lvmdevices
+// cannot identify them; Setup reports them as missing physical volumes.
+func (s *Server) registerDevices(ctx context.Context) error {
+	if lvm.DevicesFile() == "" {
+		return nil
+	}
+	var devices []string
+	for _, dev := range append(append([]string(nil), s.pvnames...), s.standbyDevices...) {
+		if _, err := os.Stat(dev); err != nil {
+			log.Printf("Not adding device %v to the LVM devices file %v: err=%v", dev, lvm.DevicesFile(), err)
+			continue
+		}
+		devices = append(devices, dev)
+	}
+	log.Printf("Adding devices %v to the LVM devices file %v", devices, lvm.DevicesFile())
+	return lvm.AddToDevicesFile(ctx, devices...)
+}
@@ -0,0 +1,59 @@
+package csilvm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ForceDeviceInit configures the server to erase all filesystem, RAID and
+// partition table signatures from a device using `wipefs --all` before it
+// is turned into a physical volume. By default only the first 512 bytes of
+// the device are zeroed, which is not enough for pvcreate to accept a
+// device that previously held, e.g., an xfs filesystem. Devices that are
+// mounted, in use, or that belong to another volume group are never wiped.
+func ForceDeviceInit() ServerOpt {
+	return func(s *Server) {
+		s.forceDeviceInit = true
+	}
+}
+
+// checkDeviceUnused returns an error if the device is mounted or otherwise
+// in use, e.g., by the device-mapper.
+func checkDeviceUnused(devicePath string) error {
+	realPath, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return err
+	}
+	mounts, err := listMounts()
+	if err != nil {
+		return err
+	}
+	if mp := mountOfDevice(mounts, devicePath, realPath); mp != nil {
+		return fmt.Errorf("%v is mounted at %v", devicePath, mp.path)
+	}
+	// Opening a block device exclusively fails with EBUSY if it or one
+	// of its partitions is mounted or held by another device.
+	file, err := os.OpenFile(realPath, os.O_RDONLY|syscall.O_EXCL, 0)
+	if err != nil {
+		if perr, ok := err.(*os.PathError); ok && perr.Err == syscall.EBUSY {
+			return fmt.Errorf("%v is in use", devicePath)
+		}
+		return err
+	}
+	return file.Close()
+}
+
+// mountOfDevice returns the first mount whose source is one of the given
+// device paths, or nil if the device is not mounted.
+func mountOfDevice(mounts []mountpoint, devicePaths ...string) *mountpoint {
+	for i := range mounts {
+		for _, path := range devicePaths {
+			if mounts[i].mountsource == path {
+				return &mounts[i]
+			}
+		}
+	}
+	return nil
+}
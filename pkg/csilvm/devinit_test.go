@@ -0,0 +1,19 @@
+package csilvm
+
+import (
+	"testing"
+)
+
+func TestMountOfDevice(t *testing.T) {
+	mounts := []mountpoint{
+		{path: "/", mountsource: "/dev/sda1"},
+		{path: "/data", mountsource: "/dev/mapper/data"},
+		{path: "/proc", mountsource: "proc"},
+	}
+	if mp := mountOfDevice(mounts, "/dev/disk/by-id/data", "/dev/mapper/data"); mp == nil || mp.path != "/data" {
+		t.Fatalf("expected the mount at /data but got %v", mp)
+	}
+	if mp := mountOfDevice(mounts, "/dev/sdb"); mp != nil {
+		t.Fatalf("expected no mount but got %v", mp)
+	}
+}
@@ -0,0 +1,227 @@
+package csilvm
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"github.com/mesosphere/csilvm/pkg/version"
+	"golang.org/x/net/context"
+)
+
+// SupportBundle describes the state of the plugin and of the volume group
+// it manages. It is produced by `csilvm diagnose` for inclusion in support
+// requests.
+type SupportBundle struct {
+	CreatedAt   time.Time        `json:"createdAt"`
+	Version     version.Version  `json:"version"`
+	VolumeGroup *VolumeGroupInfo `json:"volumeGroup,omitempty"`
+	Mounts      []MountInfo      `json:"mounts"`
+	Server      *ServerInfo      `json:"server,omitempty"`
+	// Errors lists the errors encountered while collecting the bundle.
+	Errors []string `json:"errors,omitempty"`
+	// ErrorCounts counts the collected errors by ErrorInfo reason.
+	// Errors without an ErrorInfo are counted under "UNKNOWN".
+	ErrorCounts map[string]int `json:"errorCounts,omitempty"`
+}
+
+// VolumeGroupInfo describes a volume group as reported by LVM.
+type VolumeGroupInfo struct {
+	Name            string              `json:"name"`
+	Tags            []string            `json:"tags"`
+	ExtentSize      uint64              `json:"extentSize"`
+	BytesTotal      uint64              `json:"bytesTotal"`
+	BytesFree       uint64              `json:"bytesFree"`
+	PhysicalVolumes []string            `json:"physicalVolumes"`
+	LogicalVolumes  []LogicalVolumeInfo `json:"logicalVolumes"`
+}
+
+// LogicalVolumeInfo describes a logical volume as reported by LVM.
+type LogicalVolumeInfo struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	SizeInBytes uint64   `json:"sizeInBytes"`
+	Tags        []string `json:"tags"`
+}
+
+// MountInfo describes a filesystem or bind mount of a logical volume.
+type MountInfo struct {
+	LogicalVolume string   `json:"logicalVolume"`
+	Path          string   `json:"path"`
+	Source        string   `json:"source"`
+	Fstype        string   `json:"fstype"`
+	Options       []string `json:"options"`
+}
+
+// ServerInfo describes the running plugin as reported over its CSI socket.
+type ServerInfo struct {
+	PluginName        string   `json:"pluginName"`
+	PluginVersion     string   `json:"pluginVersion"`
+	Ready             bool     `json:"ready"`
+	NodeID            string   `json:"nodeId"`
+	AvailableCapacity int64    `json:"availableCapacity"`
+	Volumes           []string `json:"volumes"`
+}
+
+// Diagnose collects a SupportBundle. If vgname is non-empty, the volume
+// group and the mounts of its logical volumes are inspected directly. If
+// client is non-nil, the running plugin is queried over its CSI socket.
+// Failures are recorded in the bundle rather than aborting the collection.
+func Diagnose(ctx context.Context, vgname string, client *Client) *SupportBundle {
+	b := &SupportBundle{
+		CreatedAt: time.Now().UTC(),
+		Version:   version.Get(),
+		Mounts:    []MountInfo{},
+	}
+	if vgname != "" {
+		b.VolumeGroup = b.diagnoseVolumeGroup(vgname)
+		if b.VolumeGroup != nil {
+			b.Mounts = b.diagnoseMounts(b.VolumeGroup.LogicalVolumes)
+		}
+	}
+	if client != nil {
+		b.Server = b.diagnoseServer(ctx, client)
+	}
+	return b
+}
+
+func (b *SupportBundle) addError(what string, err error) {
+	b.Errors = append(b.Errors, fmt.Sprintf("%s: %v", what, err))
+	reason := "UNKNOWN"
+	if info, ok := ErrorReason(err); ok {
+		reason = info.Reason
+	}
+	if b.ErrorCounts == nil {
+		b.ErrorCounts = make(map[string]int)
+	}
+	b.ErrorCounts[reason]++
+}
+
+func (b *SupportBundle) diagnoseVolumeGroup(vgname string) *VolumeGroupInfo {
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		b.addError("cannot lookup volume group "+vgname, err)
+		return nil
+	}
+	info := &VolumeGroupInfo{Name: vgname}
+	if info.Tags, err = vg.Tags(); err != nil {
+		b.addError("cannot list volume group tags", err)
+	}
+	if info.ExtentSize, err = vg.ExtentSize(); err != nil {
+		b.addError("cannot determine extent size", err)
+	}
+	if info.BytesTotal, err = vg.BytesTotal(); err != nil {
+		b.addError("cannot determine total bytes", err)
+	}
+	if info.BytesFree, err = vg.BytesFree(lvm.VolumeLayout{Type: lvm.VolumeTypeLinear}); err != nil {
+		b.addError("cannot determine free bytes", err)
+	}
+	if info.PhysicalVolumes, err = vg.ListPhysicalVolumeNames(); err != nil {
+		b.addError("cannot list physical volumes", err)
+	}
+	lvs, err := vg.ReportLogicalVolumes()
+	if err != nil {
+		b.addError("cannot list logical volumes", err)
+	}
+	for _, lv := range lvs {
+		info.LogicalVolumes = append(info.LogicalVolumes, LogicalVolumeInfo{
+			Name:        lv.Name,
+			SizeInBytes: lv.SizeInBytes,
+			Path:        lv.Path,
+			Tags:        lv.Tags,
+		})
+	}
+	return info
+}
+
+// diagnoseMounts returns the mounts of the given logical volumes. This
+// includes both filesystem mounts and the bind mounts of BLOCK_DEVICE
+// volumes.
+func (b *SupportBundle) diagnoseMounts(lvs []LogicalVolumeInfo) []MountInfo {
+	mounts, err := listMounts()
+	if err != nil {
+		b.addError("cannot list mounts", err)
+		return []MountInfo{}
+	}
+	sources := make(map[string]string)
+	for _, lv := range lvs {
+		addMountSources(sources, lv.Name, lv.Path)
+	}
+	return logicalVolumeMounts(mounts, sources)
+}
+
+// addMountSources maps each of the names by which the logical volume with
+// the given device path may appear in the mount table to its name.
+func addMountSources(sources map[string]string, lvname, path string) {
+	if path == "" {
+		return
+	}
+	sources[path] = lvname
+	if dev, err := filepath.EvalSymlinks(path); err == nil {
+		sources[dev] = lvname
+	}
+}
+
+// logicalVolumeMounts returns the mounts whose source, as given by sources,
+// is a logical volume.
+func logicalVolumeMounts(mounts []mountpoint, sources map[string]string) []MountInfo {
+	result := []MountInfo{}
+	for _, mp := range mounts {
+		lvname, ok := sources[mp.mountsource]
+		if !ok {
+			// Bind mounts of block devices show the device
+			// node as the mount root.
+			lvname, ok = sources["/dev"+mp.root]
+		}
+		if !ok {
+			continue
+		}
+		result = append(result, MountInfo{
+			LogicalVolume: lvname,
+			Path:          mp.path,
+			Source:        mp.mountsource,
+			Fstype:        mp.fstype,
+			Options:       mp.mountopts,
+		})
+	}
+	return result
+}
+
+func (b *SupportBundle) diagnoseServer(ctx context.Context, client *Client) *ServerInfo {
+	info := &ServerInfo{}
+	pluginInfo, err := client.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{})
+	if err != nil {
+		b.addError("GetPluginInfo failed", err)
+	} else {
+		info.PluginName = pluginInfo.GetName()
+		info.PluginVersion = pluginInfo.GetVendorVersion()
+	}
+	if _, err := client.Probe(ctx, &csi.ProbeRequest{}); err != nil {
+		b.addError("Probe failed", err)
+	} else {
+		info.Ready = true
+	}
+	nodeInfo, err := client.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	if err != nil {
+		b.addError("NodeGetInfo failed", err)
+	} else {
+		info.NodeID = nodeInfo.GetNodeId()
+	}
+	capacity, err := client.GetCapacity(ctx, &csi.GetCapacityRequest{})
+	if err != nil {
+		b.addError("GetCapacity failed", err)
+	} else {
+		info.AvailableCapacity = capacity.GetAvailableCapacity()
+	}
+	volumes, err := client.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		b.addError("ListVolumes failed", err)
+	} else {
+		for _, entry := range volumes.GetEntries() {
+			info.Volumes = append(info.Volumes, entry.GetVolume().GetId())
+		}
+	}
+	return info
+}
@@ -0,0 +1,78 @@
+package csilvm
+
+import (
+	"fmt"
+	"strconv"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// paramDryRun is the CreateVolume parameter that, if "true", makes
+	// CreateVolume check that the volume can be created and report it
+	// without creating it.
+	paramDryRun = "dry-run"
+	// attrDryRun marks the volume reported by a dry run.
+	attrDryRun = "dry-run"
+)
+
+// takeDryRunFromParameters consumes the dry-run parameter.
+func takeDryRunFromParameters(params map[string]string) (bool, error) {
+	value, ok := params[paramDryRun]
+	if !ok {
+		return false, nil
+	}
+	delete(params, paramDryRun)
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("The '%s' parameter must be a boolean: err=%v", paramDryRun, err)
+	}
+	return dryRun, nil
+}
+
+// isDryRun returns whether the CreateVolume request is a dry run. It
+// assumes the parameters have been validated by takeDryRunFromParameters.
+func isDryRun(request *csi.CreateVolumeRequest) bool {
+	dryRun, _ := takeDryRunFromParameters(dupParams(request.GetParameters()))
+	return dryRun
+}
+
+// dryRunCreateVolume checks that the volume specified by the CreateVolume
+// request can be created and returns it as CreateVolume would, without
+// creating it. The returned volume has the id allocated for it. As nothing
+// is created the id is not reserved and a later CreateVolume allocates
+// another one.
+func (s *Server) dryRunCreateVolume(ctx context.Context, volumeID string, tags []string, request *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	layout, err := takeVolumeLayoutFromParameters(dupParams(request.GetParameters()))
+	if err != nil {
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid volume layout: err=%v", err)
+	}
+	tags = append(tags, layoutToTag(layout))
+	plan, err := s.planLogicalVolume(ctx, tags, layout, request)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Dry run: volume %q can be created with id=%v, size=%v, tags=%v", request.GetName(), volumeID, plan.size, plan.tags)
+	attr, err := attributesFromTags(plan.tags)
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, s.errorInfo(ReasonInternal), "failed to get volume attributes: err=%v", err)
+	}
+	attr[attrExtentSize] = strconv.FormatUint(plan.extentSize, 10)
+	var roundedUp uint64
+	if requested := s.requestedSize(request); plan.size > requested {
+		roundedUp = plan.size - requested
+	}
+	attr[attrRoundedUpBytes] = strconv.FormatUint(roundedUp, 10)
+	attr[attrDryRun] = "true"
+	response := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes:      int64(plan.size),
+			Id:                 s.volumeIDFromName(volumeID),
+			Attributes:         attr,
+			AccessibleTopology: s.volumeTopology(),
+		},
+	}
+	return response, nil
+}
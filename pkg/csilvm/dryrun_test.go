@@ -0,0 +1,33 @@
+package csilvm
+
+import (
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+)
+
+func TestTakeDryRunFromParameters(t *testing.T) {
+	for _, tt := range []struct {
+		params map[string]string
+		dryRun bool
+		err    bool
+	}{
+		{nil, false, false},
+		{map[string]string{"type": "linear"}, false, false},
+		{map[string]string{"dry-run": "true"}, true, false},
+		{map[string]string{"dry-run": "false"}, false, false},
+		{map[string]string{"dry-run": "maybe"}, false, true},
+	} {
+		params := dupParams(tt.params)
+		dryRun, err := takeDryRunFromParameters(params)
+		if (err != nil) != tt.err || dryRun != tt.dryRun {
+			t.Fatalf("%v: expected dry run %v and error %v, got %v and %v", tt.params, tt.dryRun, tt.err, dryRun, err)
+		}
+		if _, ok := params["dry-run"]; ok {
+			t.Fatalf("%v: expected the dry-run parameter to be consumed", tt.params)
+		}
+		if got := isDryRun(&csi.CreateVolumeRequest{Parameters: tt.params}); got != tt.dryRun {
+			t.Fatalf("%v: expected isDryRun %v, got %v", tt.params, tt.dryRun, got)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package csilvm
+
+import (
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is the value of ErrorInfo.Domain for all errors returned by
+// this plugin.
+const errorDomain = "io.mesosphere.csi.lvm"
+
+// Machine-readable reasons attached to gRPC errors returned by this plugin.
+// They allow the CO (or any other automation) to distinguish between
+// failures without matching on the error message.
+const (
+	ReasonKernelModulesMissing    = "KERNEL_MODULES_MISSING"
+	ReasonKernelTooOld            = "KERNEL_TOO_OLD"
+	ReasonToolsMissing            = "TOOLS_MISSING"
+	ReasonContainerMisconfigured  = "CONTAINER_MISCONFIGURED"
+	ReasonVolumeGroupNotFound     = "VOLUME_GROUP_NOT_FOUND"
+	ReasonVolumeNotFound          = "VOLUME_NOT_FOUND"
+	ReasonVolumeAlreadyExists     = "VOLUME_ALREADY_EXISTS"
+	ReasonVolumeInUse             = "VOLUME_IN_USE"
+	ReasonInsufficientCapacity    = "INSUFFICIENT_CAPACITY"
+	ReasonTooFewDisks             = "TOO_FEW_DISKS"
+	ReasonVolumeLimitReached      = "VOLUME_LIMIT_REACHED"
+	ReasonTopologyUnsatisfiable   = "TOPOLOGY_UNSATISFIABLE"
+	ReasonNotMultipleOfExtentSize = "NOT_MULTIPLE_OF_EXTENT_SIZE"
+	ReasonInvalidCapacityRange    = "INVALID_CAPACITY_RANGE"
+	ReasonInvalidParameters       = "INVALID_PARAMETERS"
+	ReasonInvalidVolumeName       = "INVALID_VOLUME_NAME"
+	ReasonMissingField            = "MISSING_FIELD"
+	ReasonInvalidField            = "INVALID_FIELD"
+	ReasonInvalidAccessMode       = "INVALID_ACCESS_MODE"
+	ReasonUnsupportedAccessMode   = "ACCESS_MODE_UNSUPPORTED"
+	ReasonInvalidTargetPath       = "INVALID_TARGET_PATH"
+	ReasonFilesystemUnsupported   = "FS_UNSUPPORTED"
+	ReasonSourceUnsupported       = "CONTENT_SOURCE_UNSUPPORTED"
+	ReasonRemovingVolumeGroup     = "REMOVING_VOLUME_GROUP"
+	ReasonDeviceMissing           = "DEVICE_MISSING"
+	ReasonWipeFailed              = "WIPE_FAILED"
+	ReasonLVMFailure              = "LVM_FAILURE"
+	ReasonLVMLocked               = "LVM_LOCKED"
+	ReasonFilesystemMismatch      = "FS_MISMATCH"
+	ReasonFilesystemUnknown       = "FS_UNKNOWN"
+	ReasonDevicePartitioned       = "DEVICE_PARTITIONED"
+	ReasonFormatFailed            = "FORMAT_FAILED"
+	ReasonTargetPathNotEmpty      = "TARGET_PATH_NOT_EMPTY"
+	ReasonTargetPathReadonly      = "TARGET_PATH_RO"
+	ReasonTargetPathReadWrite     = "TARGET_PATH_RW"
+	ReasonTargetPathCreateFailed  = "TARGET_PATH_CREATE_FAILED"
+	ReasonVolumePublishedReadonly = "VOLUME_PUBLISHED_RO"
+	ReasonVolumePublishedRW       = "VOLUME_PUBLISHED_RW"
+	ReasonMountInfoFailed         = "MOUNT_INFO_FAILED"
+	ReasonMountFailed             = "MOUNT_FAILED"
+	ReasonUnmountFailed           = "UNMOUNT_FAILED"
+	ReasonDirectIOFailed          = "DIRECT_IO_FAILED"
+	ReasonDevicePermissionsFailed = "DEVICE_PERMISSIONS_FAILED"
+	ReasonDeviceReadonlyFailed    = "DEVICE_RO_FAILED"
+	ReasonRelabelFailed           = "RELABEL_FAILED"
+	ReasonVolumeMountGroupFailed  = "VOLUME_MOUNT_GROUP_FAILED"
+	ReasonIdmapFailed             = "IDMAP_FAILED"
+	ReasonQueueTuningFailed       = "QUEUE_TUNING_FAILED"
+	ReasonNotImplemented          = "NOT_IMPLEMENTED"
+	ReasonTooManyRequests         = "TOO_MANY_REQUESTS"
+	ReasonHandedOff               = "HANDED_OFF"
+	ReasonPermissionDenied        = "PERMISSION_DENIED"
+	ReasonDeadlineExceeded        = "DEADLINE_EXCEEDED"
+	ReasonCanceled                = "CANCELED"
+	ReasonInternal                = "INTERNAL"
+)
+
+// ErrorInfo is wire-compatible with the google.rpc.ErrorInfo message. The
+// vendored genproto predates that message so we carry our own definition.
+// Clients decode it with their own copy of google.rpc.ErrorInfo.
+type ErrorInfo struct {
+	// Reason is one of the Reason* constants.
+	Reason string `protobuf:"bytes,1,opt,name=reason,proto3" json:"reason,omitempty"`
+	// Domain is always "io.mesosphere.csi.lvm".
+	Domain string `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	// Metadata holds additional structured details such as the
+	// volume group, logical volume or device concerned.
+	Metadata             map[string]string `protobuf:"bytes,3,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *ErrorInfo) Reset()         { *m = ErrorInfo{} }
+func (m *ErrorInfo) String() string { return proto.CompactTextString(m) }
+func (*ErrorInfo) ProtoMessage()    {}
+
+// XXX_MessageName is consulted by proto.MessageName. We implement it so that
+// package-level error values that are initialized before init() runs are
+// marshalled with the correct type URL.
+func (*ErrorInfo) XXX_MessageName() string { return "google.rpc.ErrorInfo" }
+
+func init() {
+	proto.RegisterType((*ErrorInfo)(nil), "google.rpc.ErrorInfo")
+}
+
+// newErrorInfo returns an ErrorInfo with the given reason. The kv arguments
+// are interpreted as alternating metadata keys and values.
+func newErrorInfo(reason string, kv ...string) *ErrorInfo {
+	info := &ErrorInfo{Reason: reason, Domain: errorDomain}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] == "" {
+			continue
+		}
+		if info.Metadata == nil {
+			info.Metadata = make(map[string]string)
+		}
+		info.Metadata[kv[i]] = kv[i+1]
+	}
+	return info
+}
+
+// errorInfo returns an ErrorInfo with the given reason whose metadata
+// includes the name of the volume group managed by this server.
+func (s *Server) errorInfo(reason string, kv ...string) *ErrorInfo {
+	return newErrorInfo(reason, append([]string{"vgname", s.vgname}, kv...)...)
+}
+
+// statusError is like status.Error but attaches the given ErrorInfo to the
+// returned error's details.
+func statusError(c codes.Code, info *ErrorInfo, msg string) error {
+	return withErrorInfo(status.New(c, msg), info)
+}
+
+// statusErrorf is like status.Errorf but attaches the given ErrorInfo to the
+// returned error's details.
+func statusErrorf(c codes.Code, info *ErrorInfo, format string, a ...interface{}) error {
+	return withErrorInfo(status.Newf(c, format, a...), info)
+}
+
+func withErrorInfo(st *status.Status, info *ErrorInfo) error {
+	if info == nil {
+		return st.Err()
+	}
+	dst, err := st.WithDetails(info)
+	if err != nil {
+		// This should never happen. We prefer returning the error
+		// without details over losing the original error.
+		log.Printf("Cannot attach error details %v: err=%v", info, err)
+		return st.Err()
+	}
+	return dst.Err()
+}
+
+// lvmErrorStatus is the code and reason with which an error returned by the
+// lvm package is reported.
+type lvmErrorStatus struct {
+	code   codes.Code
+	reason string
+}
+
+// lvmErrors maps the errors of the lvm package that are caused by the
+// request or the state of the node to their code and reason. All other
+// errors, e.g., a failed LVM command, are reported as INTERNAL with
+// reason LVM_FAILURE.
+var lvmErrors = map[error]lvmErrorStatus{
+	lvm.ErrTooFewDisks:            {codes.OutOfRange, ReasonTooFewDisks},
+	lvm.ErrInvalidLVName:          {codes.InvalidArgument, ReasonInvalidVolumeName},
+	lvm.ErrTagInvalidLength:       {codes.InvalidArgument, ReasonInvalidParameters},
+	lvm.ErrTagHasInvalidChars:     {codes.InvalidArgument, ReasonInvalidParameters},
+	lvm.ErrVolumeGroupNotFound:    {codes.FailedPrecondition, ReasonVolumeGroupNotFound},
+	lvm.ErrPhysicalVolumeNotFound: {codes.FailedPrecondition, ReasonDeviceMissing},
+	context.DeadlineExceeded:      {codes.DeadlineExceeded, ReasonDeadlineExceeded},
+	context.Canceled:              {codes.Canceled, ReasonCanceled},
+}
+
+// lvmError translates an error returned by the lvm package into a gRPC
+// status error. The message is msg followed by the error and kv are added
+// to the error's metadata as by errorInfo. lvm.ErrNoSpace and
+// lvm.ErrLogicalVolumeNotFound are reported as ErrInsufficientCapacity and
+// ErrVolumeNotFound. An LVM command that failed as a lock is held by another
+// process is reported as UNAVAILABLE. The metadata of a failed LVM command
+// includes the command and its exit code.
+func (s *Server) lvmError(err error, msg string, kv ...string) error {
+	switch err {
+	case lvm.ErrNoSpace:
+		return ErrInsufficientCapacity
+	case lvm.ErrLogicalVolumeNotFound:
+		return ErrVolumeNotFound
+	}
+	st, ok := lvmErrors[err]
+	if !ok {
+		st = lvmErrorStatus{codes.Internal, ReasonLVMFailure}
+		if lvm.IsLockContention(err) {
+			// Retrying once the other process releases the lock
+			// succeeds.
+			st = lvmErrorStatus{codes.Unavailable, ReasonLVMLocked}
+		}
+		if cmdErr, ok := err.(*lvm.CommandError); ok {
+			kv = append(kv, "command", cmdErr.Command, "exitCode", strconv.Itoa(cmdErr.ExitCode))
+		}
+	}
+	return statusErrorf(st.code, s.errorInfo(st.reason, kv...), "%s: err=%v", msg, err)
+}
+
+// ErrorReason returns the ErrorInfo attached to the given error, if any.
+func ErrorReason(err error) (*ErrorInfo, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil, false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*ErrorInfo); ok {
+			return info, true
+		}
+	}
+	return nil, false
+}
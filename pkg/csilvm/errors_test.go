@@ -0,0 +1,180 @@
+package csilvm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusErrorfAttachesErrorInfo(t *testing.T) {
+	s := &Server{vgname: "test-vg"}
+	err := statusErrorf(
+		codes.Internal,
+		s.errorInfo(ReasonDeviceMissing, "lvname", "csilv123", "device", "/dev/test-vg/csilv123", "fstype", ""),
+		"device missing: %v", "/dev/test-vg/csilv123")
+	st, ok := status.FromError(err)
+	if !ok {
+		t.Fatalf("expected a status error but got %v", err)
+	}
+	if st.Code() != codes.Internal {
+		t.Fatalf("expected code %v but got %v", codes.Internal, st.Code())
+	}
+	if st.Message() != "device missing: /dev/test-vg/csilv123" {
+		t.Fatalf("unexpected message %q", st.Message())
+	}
+	info, ok := ErrorReason(err)
+	if !ok {
+		t.Fatal("expected error to carry an ErrorInfo")
+	}
+	if info.Reason != ReasonDeviceMissing {
+		t.Fatalf("expected reason %q but got %q", ReasonDeviceMissing, info.Reason)
+	}
+	if info.Domain != errorDomain {
+		t.Fatalf("expected domain %q but got %q", errorDomain, info.Domain)
+	}
+	// Empty metadata values are omitted.
+	exp := map[string]string{
+		"vgname": "test-vg",
+		"lvname": "csilv123",
+		"device": "/dev/test-vg/csilv123",
+	}
+	if !reflect.DeepEqual(info.Metadata, exp) {
+		t.Fatalf("expected metadata %v but got %v", exp, info.Metadata)
+	}
+}
+
+func TestErrorInfoTypeURL(t *testing.T) {
+	// Package-level errors are initialized before init() registers
+	// the message type. Check that they still carry the correct type.
+	st, _ := status.FromError(ErrVolumeNotFound)
+	details := st.Proto().GetDetails()
+	if len(details) != 1 {
+		t.Fatalf("expected 1 detail but got %d", len(details))
+	}
+	const expURL = "type.googleapis.com/google.rpc.ErrorInfo"
+	if details[0].GetTypeUrl() != expURL {
+		t.Fatalf("expected type URL %q but got %q", expURL, details[0].GetTypeUrl())
+	}
+	info := new(ErrorInfo)
+	if err := ptypes.UnmarshalAny(details[0], info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Reason != ReasonVolumeNotFound {
+		t.Fatalf("expected reason %q but got %q", ReasonVolumeNotFound, info.Reason)
+	}
+}
+
+func TestErrorReasonWithoutDetails(t *testing.T) {
+	if _, ok := ErrorReason(status.Error(codes.InvalidArgument, "no details")); ok {
+		t.Fatal("expected no ErrorInfo")
+	}
+	if _, ok := ErrorReason(nil); ok {
+		t.Fatal("expected no ErrorInfo")
+	}
+}
+
+func TestValidatorErrorsHaveErrorInfo(t *testing.T) {
+	for _, err := range []error{
+		ErrRemovingMode,
+		ErrMissingVolumeId,
+		ErrMissingName,
+		ErrUnsupportedFilesystem,
+		unsupportedFilesystemError("zfs", map[string]string{"xfs": "xfs"}),
+		ErrCapacityRangeUnspecified,
+		ErrCapacityRangeInvalidSize,
+		ErrMissingVolumeCapabilities,
+		ErrMissingAccessType,
+		ErrMissingAccessMode,
+		ErrMissingAccessModeMode,
+		ErrInvalidAccessMode,
+		ErrUnsupportedAccessMode,
+		ErrMissingTargetPath,
+		ErrMissingVolumeCapability,
+		ErrSpecifiedPublishInfo,
+		ErrTargetPathNotAbsolute,
+		ErrTargetPathTraversal,
+		ErrTargetPathNotFound,
+		ErrTargetPathNotDirectory,
+		ErrTargetPathNotFile,
+		ErrMissingMutableParameters,
+		ErrHandedOff,
+		ErrContentSourceNotSupported,
+	} {
+		info, ok := ErrorReason(err)
+		if !ok {
+			t.Errorf("expected ErrorInfo on %v", err)
+			continue
+		}
+		if info.Domain != errorDomain || info.Reason == "" {
+			t.Errorf("expected a reason in domain %q on %v but got %+v", errorDomain, err, info)
+		}
+	}
+}
+
+func TestErrNotMultipleOfExtentSize(t *testing.T) {
+	// Extent sizes below 1MiB must not be reported as "0MiB".
+	st, _ := status.FromError(ErrNotMultipleOfExtentSize(512 << 10))
+	if exp := "Volume capacity must be a multiple of 524288 bytes"; st.Message() != exp {
+		t.Fatalf("expected message %q but got %q", exp, st.Message())
+	}
+}
+
+func TestLVMError(t *testing.T) {
+	s := &Server{vgname: "test-vg"}
+	testCases := []struct {
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{lvm.ErrTooFewDisks, codes.OutOfRange, ReasonTooFewDisks},
+		{lvm.ErrInvalidLVName, codes.InvalidArgument, ReasonInvalidVolumeName},
+		{lvm.ErrTagHasInvalidChars, codes.InvalidArgument, ReasonInvalidParameters},
+		{lvm.ErrVolumeGroupNotFound, codes.FailedPrecondition, ReasonVolumeGroupNotFound},
+		{lvm.ErrPhysicalVolumeNotFound, codes.FailedPrecondition, ReasonDeviceMissing},
+		{context.DeadlineExceeded, codes.DeadlineExceeded, ReasonDeadlineExceeded},
+		{context.Canceled, codes.Canceled, ReasonCanceled},
+		{errors.New("unexpected"), codes.Internal, ReasonLVMFailure},
+		{&lvm.CommandError{Command: "lvcreate", ExitCode: 5, Stderr: "VG test-vg open failed: held by another process"}, codes.Unavailable, ReasonLVMLocked},
+		{&lvm.CommandError{Command: "vgs", ExitCode: 5, Stderr: "/run/lock/lvm/V_test-vg: flock failed: Resource temporarily unavailable"}, codes.Unavailable, ReasonLVMLocked},
+		{&lvm.CommandError{Command: "pvs", ExitCode: 5, Stderr: "/dev/sdb: open failed: Resource temporarily unavailable"}, codes.Internal, ReasonLVMFailure},
+	}
+	for _, tc := range testCases {
+		err := s.lvmError(tc.err, "Error in Test", "lvname", "csilv123")
+		if got := status.Code(err); got != tc.code {
+			t.Fatalf("%v: expected code %v but got %v", tc.err, tc.code, got)
+		}
+		info, ok := ErrorReason(err)
+		if !ok || info.Reason != tc.reason {
+			t.Fatalf("%v: expected reason %q but got %v", tc.err, tc.reason, info)
+		}
+		if info.Metadata["lvname"] != "csilv123" {
+			t.Fatalf("%v: expected lvname metadata but got %v", tc.err, info.Metadata)
+		}
+		if exp := "Error in Test: err=" + tc.err.Error(); status.Convert(err).Message() != exp {
+			t.Fatalf("expected message %q but got %q", exp, status.Convert(err).Message())
+		}
+	}
+	// Errors with a dedicated package-level error are reported as such.
+	if err := s.lvmError(lvm.ErrNoSpace, "Error in Test"); err != ErrInsufficientCapacity {
+		t.Fatalf("expected %v but got %v", ErrInsufficientCapacity, err)
+	}
+	if err := s.lvmError(lvm.ErrLogicalVolumeNotFound, "Error in Test"); err != ErrVolumeNotFound {
+		t.Fatalf("expected %v but got %v", ErrVolumeNotFound, err)
+	}
+	// Failed commands carry the command and its exit code.
+	err := s.lvmError(&lvm.CommandError{Command: "lvremove", ExitCode: 5, Stderr: "Logical volume in use"}, "Failed to remove volume")
+	info, _ := ErrorReason(err)
+	exp := map[string]string{"vgname": "test-vg", "command": "lvremove", "exitCode": "5"}
+	if !reflect.DeepEqual(info.Metadata, exp) {
+		t.Fatalf("expected metadata %v but got %v", exp, info.Metadata)
+	}
+	if msg := status.Convert(err).Message(); msg != "Failed to remove volume: err=Logical volume in use" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
@@ -0,0 +1,190 @@
+// +build linux,!unit,lvmfaults
+
+package csilvm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The tests in this file inject failures into LVM commands with
+// lvm.InjectFault to exercise error paths that real devices rarely take.
+
+func TestFault_CreateVolume_NoSpace(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	// The volume group runs out of space after the plugin has checked
+	// that it has enough, e.g., because of a concurrent lvcreate.
+	fault := lvm.NoSpaceFault("lvcreate")
+	fault.Times = 1
+	defer lvm.InjectFault(fault)()
+	req := testCreateVolumeRequest()
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrInsufficientCapacity) {
+		t.Fatalf("Expected %v but got %v", ErrInsufficientCapacity, err)
+	}
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, err := vg.ListLogicalVolumeNames(); err != nil || len(names) != 0 {
+		t.Fatalf("Expected no volumes but got %v, err=%v", names, err)
+	}
+	// The retry succeeds.
+	if _, err := client.CreateVolume(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFault_CreateVolume_NoSpace_ExtendOntoStandbyDevice(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	standbyname, standbyclean := testpv()
+	defer check(standbyclean)
+	client, clean := startTest(vgname, []string{pvname}, StandbyDevices([]string{standbyname}))
+	defer clean()
+	fault := lvm.NoSpaceFault("lvcreate")
+	fault.Times = 1
+	defer lvm.InjectFault(fault)()
+	// The volume is created once the volume group has been extended.
+	if _, err := client.CreateVolume(context.Background(), testCreateVolumeRequest()); err != nil {
+		t.Fatal(err)
+	}
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pvnames, err := vg.ListPhysicalVolumeNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pvnames) != 2 {
+		t.Fatalf("Expected the volume group to be extended onto %v but got %v", standbyname, pvnames)
+	}
+}
+
+func TestFault_CreateVolume_Cache_NoSpace(t *testing.T) {
+	vgname := testvgname()
+	pvname1, pvclean1 := testpv()
+	defer check(pvclean1)
+	pvname2, pvclean2 := testpv()
+	defer check(pvclean2)
+	client, clean := startTest(vgname, []string{pvname1, pvname2}, CacheDeviceTag("fast"))
+	defer clean()
+	fast, err := lvm.LookupPhysicalVolume(pvname2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fast.AddTag("fast"); err != nil {
+		t.Fatal(err)
+	}
+	// The volume is created but its cache pool is not.
+	defer lvm.InjectFault(lvm.Fault{
+		Command: "lvcreate",
+		Arg:     "--type=cache-pool",
+		Err:     lvm.NoSpaceFault("lvcreate").Err,
+	})()
+	req := testCreateVolumeRequest()
+	req.Parameters = map[string]string{"cache": "true"}
+	if _, err := client.CreateVolume(context.Background(), req); !grpcErrorEqual(err, ErrInsufficientCacheCapacity) {
+		t.Fatalf("Expected %v but got %v", ErrInsufficientCacheCapacity, err)
+	}
+	// The uncached volume is removed.
+	vg, err := lvm.LookupVolumeGroup(vgname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, err := vg.ListLogicalVolumeNames(); err != nil || len(names) != 0 {
+		t.Fatalf("Expected no volumes but got %v, err=%v", names, err)
+	}
+}
+
+func TestFault_DeleteVolume_VolumeDisappears(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createResp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	// The volume is removed, e.g., by an operator, after the plugin
+	// looked it up and before it removes it.
+	remove := lvm.InjectFault(lvm.NotFoundFault("lvremove", volumeId))
+	if _, err := client.DeleteVolume(context.Background(), testDeleteVolumeRequest(volumeId)); err != nil {
+		remove()
+		t.Fatal(err)
+	}
+	remove()
+	if _, err := client.DeleteVolume(context.Background(), testDeleteVolumeRequest(volumeId)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFault_DeleteVolume_RemoveFails(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	client, clean := startTest(vgname, []string{pvname})
+	defer clean()
+	createResp, err := client.CreateVolume(context.Background(), testCreateVolumeRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeId := createResp.GetVolume().GetId()
+	defer lvm.InjectFault(lvm.Fault{
+		Command: "lvremove",
+		Arg:     volumeId,
+		Err: &lvm.CommandError{
+			Command:  "lvremove",
+			ExitCode: 5,
+			Stderr:   "Logical volume contains a filesystem in use.",
+		},
+	})()
+	_, err = client.DeleteVolume(context.Background(), testDeleteVolumeRequest(volumeId))
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("Expected Internal but got %v", err)
+	}
+	info, ok := ErrorReason(err)
+	if !ok || info.Reason != ReasonLVMFailure || info.Metadata["command"] != "lvremove" || info.Metadata["exitCode"] != "5" {
+		t.Fatalf("Unexpected error info %+v", info)
+	}
+}
+
+func TestFault_VolumeGroupOpenTimeout(t *testing.T) {
+	vgname := testvgname()
+	pvname, pvclean := testpv()
+	defer check(pvclean)
+	_, server, clean := prepareSetupTest(vgname, []string{pvname})
+	defer clean()
+	if err := server.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	// Opening the volume group hangs, e.g., on a device that stopped
+	// responding.
+	remove := lvm.InjectFault(lvm.Fault{Command: "lvs", Delay: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := server.ListVolumes(ctx, testListVolumesRequest())
+	remove()
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Expected DeadlineExceeded but got %v", err)
+	}
+	if info, ok := ErrorReason(err); !ok || info.Reason != ReasonDeadlineExceeded {
+		t.Fatalf("Unexpected error info %+v", info)
+	}
+	// The plugin has not been left holding the LVM lock.
+	if _, err := server.ListVolumes(context.Background(), testListVolumesRequest()); err != nil {
+		t.Fatal(err)
+	}
+}
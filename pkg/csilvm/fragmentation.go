@@ -0,0 +1,83 @@
+package csilvm
+
+import (
+	"strconv"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// The GetCapacity attributes that describe how fragmented the free space
+// is. As GetCapacityResponse has no field for them they are sent as gRPC
+// response headers.
+const (
+	// attrLargestAllocatableBytes is the size in bytes of the largest
+	// volume with the requested layout that can be allocated, which can
+	// be less than the available capacity if the free space is unevenly
+	// spread over the devices.
+	attrLargestAllocatableBytes = "largest-allocatable-bytes"
+	// attrLargestFreeRegionBytes is the size in bytes of the largest
+	// contiguous unallocated region of any device.
+	attrLargestFreeRegionBytes = "largest-free-region-bytes"
+)
+
+// sendCapacityAttributes sends the fragmentation attributes of GetCapacity
+// for the layout on the physical volumes with all of the given tags and
+// reports the fragmentation of every physical volume, see
+// reportFreeSegmentMetrics. The attributes are optional so if they cannot be
+// determined the failure is logged and they are omitted rather than failing
+// GetCapacity.
+func (s *Server) sendCapacityAttributes(ctx context.Context, layout lvm.VolumeLayout, pvTags []string) {
+	vg := s.volumeGroup.WithContext(ctx)
+	extentSize, err := vg.ExtentSize()
+	if err != nil {
+		log.Printf("Cannot send the capacity attributes: cannot read extent size: err=%v", err)
+		return
+	}
+	reports, err := vg.ReportPhysicalVolumes()
+	if err != nil {
+		log.Printf("Cannot send the capacity attributes: cannot read physical volumes: err=%v", err)
+		return
+	}
+	pvs := withPVTags(presentPhysicalVolumes(reports), pvTags)
+	segments, err := vg.ReportFreeSegments()
+	if err != nil {
+		log.Printf("Cannot send the capacity attributes: cannot read free segments: err=%v", err)
+		return
+	}
+	s.reportFreeSegmentMetrics(segments)
+	names := make(map[string]bool)
+	for _, pv := range pvs {
+		names[pv.Name] = true
+	}
+	var largestRegion uint64
+	for _, seg := range segments {
+		if names[seg.Name] && seg.LargestBytes > largestRegion {
+			largestRegion = seg.LargestBytes
+		}
+	}
+	allocatable := layout.BytesAllocatableOn(pvs, extentSize)
+	log.Printf("Largest allocatable: %v, largest free region: %v", allocatable, largestRegion)
+	md := metadata.Pairs(
+		attrLargestAllocatableBytes, strconv.FormatUint(allocatable, 10),
+		attrLargestFreeRegionBytes, strconv.FormatUint(largestRegion, 10),
+	)
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		// The server was called directly rather than over gRPC.
+		log.Printf("Cannot send the capacity attributes: err=%v", err)
+	}
+}
+
+// reportFreeSegmentMetrics sets the gauges that describe how fragmented the
+// free space of every physical volume is from its free segments. They are
+// read by GetCapacity, which holds the request serializer, rather than by
+// another pvs command.
+func (s *Server) reportFreeSegmentMetrics(segments []lvm.FreeSegmentsReport) {
+	for _, seg := range segments {
+		scope := s.metrics.Tagged(map[string]string{"pv": seg.Name})
+		scope.Gauge("free-segments").Update(float64(seg.Count))
+		scope.Gauge("largest-free-region-bytes").Update(float64(seg.LargestBytes))
+	}
+}
@@ -0,0 +1,107 @@
+package csilvm
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// procFilesystems lists the filesystems registered with the kernel, either
+// built in or provided by a loaded module.
+var procFilesystems = "/proc/filesystems"
+
+// DetectFilesystems configures the server to support, in addition to the
+// default filesystem and those given by SupportedFilesystem, each of the
+// allowed filesystems that is available on the node. Setup detects which
+// of them are available: mkfs.<fstype> must be in $PATH and the kernel must
+// support the filesystem. This option may be specified multiple times to
+// extend the allow-list.
+func DetectFilesystems(allowed []string) ServerOpt {
+	for _, fstype := range allowed {
+		if fstype == "" {
+			panic("csilvm: DetectFilesystems: filesystem type not provided")
+		}
+	}
+	return func(s *Server) {
+		s.detectFilesystems = append(s.detectFilesystems, allowed...)
+	}
+}
+
+// detectSupportedFilesystems adds the available filesystems given by
+// DetectFilesystems to the supported filesystems and returns them, sorted.
+func (s *Server) detectSupportedFilesystems(ctx context.Context) ([]string, error) {
+	if len(s.detectFilesystems) == 0 {
+		return nil, nil
+	}
+	registered, err := kernelFilesystems()
+	if err != nil {
+		return nil, err
+	}
+	var detected []string
+	for _, fstype := range s.detectFilesystems {
+		if _, ok := s.supportedFilesystems[fstype]; ok {
+			continue
+		}
+		if _, err := exec.LookPath("mkfs." + fstype); err != nil {
+			log.Printf("Not supporting filesystem %v: mkfs.%v is not in $PATH", fstype, fstype)
+			continue
+		}
+		if _, ok := registered[fstype]; !ok && !s.canLoadFilesystem(ctx, fstype) {
+			log.Printf("Not supporting filesystem %v: it is not listed in %v and no kernel module provides it", fstype, procFilesystems)
+			continue
+		}
+		s.supportedFilesystems[fstype] = fstype
+		detected = append(detected, fstype)
+	}
+	sort.Strings(detected)
+	return detected, nil
+}
+
+// canLoadFilesystem returns whether modprobe finds a kernel module for the
+// filesystem. The kernel loads it when the filesystem is first mounted.
+func (s *Server) canLoadFilesystem(ctx context.Context, fstype string) bool {
+	if _, err := exec.LookPath("modprobe"); err != nil {
+		return false
+	}
+	_, err := s.runner.Run(ctx, modprobeTimeout, "modprobe", "--dry-run", "--quiet", fstype)
+	return err == nil
+}
+
+// kernelFilesystems returns the block device filesystems listed in
+// procFilesystems.
+func kernelFilesystems() (map[string]struct{}, error) {
+	buf, err := ioutil.ReadFile(procFilesystems)
+	if err != nil {
+		return nil, err
+	}
+	return parseFilesystems(bytes.NewReader(buf))
+}
+
+func parseFilesystems(r io.Reader) (map[string]struct{}, error) {
+	/*
+		$ head -5 /proc/filesystems
+		nodev	sysfs
+		nodev	tmpfs
+		nodev	proc
+			ext4
+			xfs
+	*/
+	fstypes := make(map[string]struct{})
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// Filesystems that do not need a block device, e.g.,
+		// tmpfs, are marked nodev. Volumes cannot be formatted
+		// with them.
+		fields := strings.Fields(s.Text())
+		if len(fields) == 1 {
+			fstypes[fields[0]] = struct{}{}
+		}
+	}
+	return fstypes, s.Err()
+}
@@ -0,0 +1,67 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+const testProcFilesystems = "nodev\tsysfs\nnodev\ttmpfs\n\text4\n\txfs\n"
+
+func TestParseFilesystems(t *testing.T) {
+	fstypes, err := parseFilesystems(strings.NewReader(testProcFilesystems))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]struct{}{"ext4": {}, "xfs": {}}
+	if !reflect.DeepEqual(fstypes, expected) {
+		t.Fatalf("expected %v instead of %v", expected, fstypes)
+	}
+}
+
+func TestDetectSupportedFilesystems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-fsdetect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(p string) { procFilesystems = p }(procFilesystems)
+	procFilesystems = filepath.Join(dir, "filesystems")
+	if err := ioutil.WriteFile(procFilesystems, []byte(testProcFilesystems), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tool := range []string{"mkfs.xfs", "mkfs.ext4", "mkfs.btrfs"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, tool), nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// There is no modprobe in $PATH so btrfs, which the kernel does
+	// not list, is not detected.
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+	s := NewServer("vg", nil, "xfs", DetectFilesystems([]string{"xfs", "ext4", "btrfs", "vfat"}))
+	detected, err := s.detectSupportedFilesystems(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"ext4"}; !reflect.DeepEqual(detected, expected) {
+		t.Fatalf("expected %v instead of %v", expected, detected)
+	}
+	expected := map[string]string{"": "xfs", "xfs": "xfs", "ext4": "ext4"}
+	if fstypes := s.SupportedFilesystems(); !reflect.DeepEqual(fstypes, expected) {
+		t.Fatalf("expected %v instead of %v", expected, fstypes)
+	}
+}
+
+func TestUnsupportedFilesystemError(t *testing.T) {
+	err := unsupportedFilesystemError("btrfs", map[string]string{"": "xfs", "xfs": "xfs", "ext4": "ext4"})
+	expected := `rpc error: code = FailedPrecondition desc = The requested filesystem type "btrfs" is not supported, the supported filesystem types are [ext4 xfs].`
+	if err.Error() != expected {
+		t.Fatalf("expected %q instead of %q", expected, err)
+	}
+}
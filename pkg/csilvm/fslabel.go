@@ -0,0 +1,93 @@
+package csilvm
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/cmd"
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+)
+
+// tagFilesystemUUIDPrefix prefixes the logical volume tag that records the
+// UUID of the filesystem on the volume, e.g.,
+// "FS.0f5a3c4e-6b1d-4f6e-9a43-3c2d1b0e9f87".
+const tagFilesystemUUIDPrefix = "FS."
+
+// filesystemLabelMaxLen is the maximum length of a label for the
+// filesystems whose mkfs accepts one with -L. Other filesystems are
+// formatted without a label.
+var filesystemLabelMaxLen = map[string]int{
+	"xfs":   12,
+	"ext2":  16,
+	"ext3":  16,
+	"ext4":  16,
+	"btrfs": 255,
+}
+
+// filesystemLabel returns the label of a filesystem of type fstype on the
+// volume with the given name. Characters other than ASCII letters, digits,
+// '-', '_' and '.' are dropped and the result is truncated to the maximum
+// label length of the filesystem. An empty label means none is set.
+func filesystemLabel(fstype, volname string) string {
+	max, ok := filesystemLabelMaxLen[fstype]
+	if !ok {
+		return ""
+	}
+	var label []byte
+	for i := 0; i < len(volname) && len(label) < max; i++ {
+		c := volname[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			continue
+		}
+		label = append(label, c)
+	}
+	return string(label)
+}
+
+// filesystemUUIDFromTags returns the filesystem UUID recorded among the
+// given tags.
+func filesystemUUIDFromTags(tags []string) (string, bool) {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, tagFilesystemUUIDPrefix) {
+			return strings.TrimPrefix(tag, tagFilesystemUUIDPrefix), true
+		}
+	}
+	return "", false
+}
+
+// filesystemUUID returns the UUID of the filesystem on the device.
+func filesystemUUID(ctx context.Context, runner *cmd.Runner, devicePath string) (string, error) {
+	output, err := runner.Run(ctx, probeTimeout, "blkid", "-c", "/dev/null", "-s", "UUID", "-o", "value", devicePath)
+	if err != nil {
+		return "", err
+	}
+	uuid := strings.TrimSpace(string(output.Stdout))
+	if uuid == "" {
+		return "", errors.New("Filesystem has no UUID.")
+	}
+	return uuid, nil
+}
+
+// recordFilesystemUUID tags the volume with the UUID of the filesystem on
+// it unless the tags already record it. The tag only aids operators so
+// failures are logged and retried the next time the volume is published.
+func (s *Server) recordFilesystemUUID(ctx context.Context, lv *lvm.LogicalVolume, tags []string, sourcePath string) {
+	if _, ok := filesystemUUIDFromTags(tags); ok {
+		return
+	}
+	uuid, err := filesystemUUID(ctx, s.runner, sourcePath)
+	if err != nil {
+		log.Printf("Cannot determine filesystem UUID of %v: err=%v", sourcePath, err)
+		return
+	}
+	log.Printf("Recording filesystem UUID %v of volume %v", uuid, lv.Name())
+	if err := lv.ChangeTags([]string{tagFilesystemUUIDPrefix + uuid}, nil); err != nil {
+		log.Printf("Cannot record filesystem UUID of volume %v: err=%v", lv.Name(), err)
+		return
+	}
+	s.backupMetadata(ctx, "NodePublishVolume")
+}
@@ -0,0 +1,36 @@
+package csilvm
+
+import (
+	"testing"
+)
+
+func TestFilesystemLabel(t *testing.T) {
+	for _, tt := range []struct {
+		fstype  string
+		volname string
+		label   string
+	}{
+		{"xfs", "test-volume", "test-volume"},
+		{"xfs", "pvc-6f1f4f9e-3f3c-4c3a-9c1e-0d1e2f3a4b5c", "pvc-6f1f4f9e"},
+		{"ext4", "pvc-6f1f4f9e-3f3c-4c3a-9c1e-0d1e2f3a4b5c", "pvc-6f1f4f9e-3f3"},
+		{"ext4", "my volume/1", "myvolume1"},
+		{"xfs", "ünïcode_vol.1", "ncode_vol.1"},
+		{"xfs", "???", ""},
+		{"xfs", "", ""},
+		{"vfat", "test-volume", ""},
+	} {
+		if label := filesystemLabel(tt.fstype, tt.volname); label != tt.label {
+			t.Fatalf("%v %q: expected label %q, got %q", tt.fstype, tt.volname, tt.label, label)
+		}
+	}
+}
+
+func TestFilesystemUUIDFromTags(t *testing.T) {
+	if _, ok := filesystemUUIDFromTags([]string{"VN.test-volume", "LY.linear"}); ok {
+		t.Fatal("Expected no filesystem UUID")
+	}
+	uuid, ok := filesystemUUIDFromTags([]string{"VN.test-volume", "FS.0f5a3c4e-6b1d-4f6e-9a43-3c2d1b0e9f87"})
+	if !ok || uuid != "0f5a3c4e-6b1d-4f6e-9a43-3c2d1b0e9f87" {
+		t.Fatalf("Unexpected filesystem UUID %q", uuid)
+	}
+}
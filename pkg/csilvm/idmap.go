@@ -0,0 +1,134 @@
+package csilvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The volume attributes that request an idmapped mount. Their values are
+// lists of <on-disk id>:<id seen through the mount>:<count> mappings,
+// separated by commas, e.g., "0:100000:65536". Files owned by an on-disk id
+// appear to be owned by the id it is mapped to, and files created through
+// the mount are stored with the on-disk id, so that a rootless container
+// whose root is host uid 100000 can use a volume without chowning it.
+// If only one of the attributes is given the other ids are not changed.
+const (
+	attrIdmapUIDs = "idmap-uids"
+	attrIdmapGIDs = "idmap-gids"
+)
+
+// idMapping maps count ids starting at diskID to ids starting at mountID.
+type idMapping struct {
+	diskID  uint32
+	mountID uint32
+	count   uint32
+}
+
+// identityMapping maps all ids to themselves. The id 4294967295 is invalid
+// and cannot be mapped.
+var identityMapping = idMapping{diskID: 0, mountID: 0, count: 1<<32 - 1}
+
+// idmap holds the uid and gid mappings of an idmapped mount.
+type idmap struct {
+	uids []idMapping
+	gids []idMapping
+}
+
+func (m *idmap) String() string {
+	return fmt.Sprintf("uids=%v gids=%v", m.uids, m.gids)
+}
+
+// parseIdmap returns the idmap requested by the volume attributes or nil
+// if none is requested.
+func parseIdmap(attrs map[string]string) (*idmap, error) {
+	m := &idmap{}
+	var err error
+	if v, ok := attrs[attrIdmapUIDs]; ok {
+		if m.uids, err = parseIDMappings(v); err != nil {
+			return nil, fmt.Errorf("The '%s' attribute is invalid: err=%v", attrIdmapUIDs, err)
+		}
+	}
+	if v, ok := attrs[attrIdmapGIDs]; ok {
+		if m.gids, err = parseIDMappings(v); err != nil {
+			return nil, fmt.Errorf("The '%s' attribute is invalid: err=%v", attrIdmapGIDs, err)
+		}
+	}
+	if m.uids == nil && m.gids == nil {
+		return nil, nil
+	}
+	if m.uids == nil {
+		m.uids = []idMapping{identityMapping}
+	}
+	if m.gids == nil {
+		m.gids = []idMapping{identityMapping}
+	}
+	return m, nil
+}
+
+// parseIDMappings parses a comma-separated list of mappings. The on-disk
+// ranges, like the ranges they are mapped to, must not overlap.
+func parseIDMappings(value string) ([]idMapping, error) {
+	var mappings []idMapping
+	for _, s := range strings.Split(value, ",") {
+		parts := strings.Split(s, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("expected DISKID:MOUNTID:COUNT but got %q", s)
+		}
+		var ids [3]uint32
+		for i, p := range parts {
+			n, err := strconv.ParseUint(p, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid id mapping %q: %v", s, err)
+			}
+			ids[i] = uint32(n)
+		}
+		m := idMapping{diskID: ids[0], mountID: ids[1], count: ids[2]}
+		if m.count == 0 {
+			return nil, fmt.Errorf("the count of id mapping %q must be positive", s)
+		}
+		if uint64(m.diskID)+uint64(m.count) > 1<<32 || uint64(m.mountID)+uint64(m.count) > 1<<32 {
+			return nil, fmt.Errorf("id mapping %q exceeds the range of ids", s)
+		}
+		for _, o := range mappings {
+			if overlaps(m.diskID, o.diskID, m.count, o.count) || overlaps(m.mountID, o.mountID, m.count, o.count) {
+				return nil, fmt.Errorf("id mapping %q overlaps another mapping", s)
+			}
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+// overlaps returns whether the ranges [a, a+alen) and [b, b+blen) overlap.
+func overlaps(a, b, alen, blen uint32) bool {
+	return uint64(a) < uint64(b)+uint64(blen) && uint64(b) < uint64(a)+uint64(alen)
+}
+
+// isIdmapped returns whether the mount is idmapped.
+func (m *mountpoint) isIdmapped() bool {
+	for _, opt := range m.mountopts {
+		if opt == "idmapped" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyIdmap replaces the mount at mountPath with an idmapped mount of the
+// same filesystem. It does nothing if the mount is already idmapped, e.g.,
+// because NodePublishVolume is retried.
+func applyIdmap(mountPath string, m *idmap) error {
+	mp, err := getMountAt(mountPath)
+	if err != nil {
+		return err
+	}
+	if mp == nil {
+		return fmt.Errorf("nothing is mounted at %v", mountPath)
+	}
+	if mp.isIdmapped() {
+		log.Printf("The mount at %v is already idmapped", mountPath)
+		return nil
+	}
+	return idmapMount(mountPath, m)
+}
@@ -0,0 +1,37 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIdmap(t *testing.T) {
+	m, err := parseIdmap(map[string]string{})
+	if err != nil || m != nil {
+		t.Fatalf("expected no idmap, got %v, err=%v", m, err)
+	}
+	m, err = parseIdmap(map[string]string{attrIdmapUIDs: "0:100000:65536,65536:0:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &idmap{
+		uids: []idMapping{{0, 100000, 65536}, {65536, 0, 1}},
+		gids: []idMapping{identityMapping},
+	}
+	if !reflect.DeepEqual(m, exp) {
+		t.Fatalf("expected %v, got %v", exp, m)
+	}
+	for _, v := range []string{
+		"",
+		"0:100000",
+		"0:100000:0",
+		"a:100000:1",
+		"0:4294967295:2",
+		"0:100000:10,5:200000:10",
+		"0:100000:10,20:100005:10",
+	} {
+		if _, err := parseIdmap(map[string]string{attrIdmapGIDs: v}); err == nil {
+			t.Fatalf("expected %q to be rejected", v)
+		}
+	}
+}
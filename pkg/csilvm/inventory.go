@@ -0,0 +1,272 @@
+package csilvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+)
+
+// Inventory describes everything the server controls: the volume group,
+// its physical volumes and its logical volumes along with the target paths
+// at which they are published. It is served as JSON by InventoryHandler,
+// e.g., for capacity dashboards.
+type Inventory struct {
+	CreatedAt       time.Time                 `json:"createdAt"`
+	NodeID          string                    `json:"nodeId"`
+	VolumeGroup     InventoryVolumeGroup      `json:"volumeGroup"`
+	PhysicalVolumes []InventoryPhysicalVolume `json:"physicalVolumes"`
+	LogicalVolumes  []InventoryLogicalVolume  `json:"logicalVolumes"`
+}
+
+// InventoryVolumeGroup describes the totals of the volume group.
+type InventoryVolumeGroup struct {
+	Name       string   `json:"name"`
+	Tags       []string `json:"tags"`
+	ExtentSize uint64   `json:"extentSize"`
+	BytesTotal uint64   `json:"bytesTotal"`
+	BytesFree  uint64   `json:"bytesFree"`
+}
+
+// InventoryPhysicalVolume describes a physical volume of the volume group.
+type InventoryPhysicalVolume struct {
+	Name        string `json:"name"`
+	SizeInBytes uint64 `json:"sizeInBytes"`
+	BytesFree   uint64 `json:"bytesFree"`
+	// Health is "ok" or "missing" if LVM cannot find the device.
+	Health string   `json:"health"`
+	Tags   []string `json:"tags"`
+}
+
+// InventoryLogicalVolume describes a logical volume of the volume group.
+type InventoryLogicalVolume struct {
+	// ID is the CSI volume id, i.e., the logical volume name prefixed
+	// with the node id if NodeVolumeIDs is set.
+	ID string `json:"id"`
+	// Name is the name given to CreateVolume, if it was recorded.
+	Name        string `json:"name,omitempty"`
+	SizeInBytes uint64 `json:"sizeInBytes"`
+	// Layout is "linear" or "raid1.<mirrors>". It is empty if the
+	// volume was created before layouts were recorded.
+	Layout   string            `json:"layout,omitempty"`
+	Tags     []string          `json:"tags"`
+	Abnormal bool              `json:"abnormal"`
+	Message  string            `json:"message,omitempty"`
+	Targets  []InventoryTarget `json:"targets"`
+}
+
+// InventoryTarget describes a target path at which a volume is published.
+type InventoryTarget struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly"`
+}
+
+// Inventory collects the inventory of the volume group. It only reads
+// state but runs LVM commands, so it is serialized with the requests, see
+// Serializer.
+func (s *Server) Inventory(ctx context.Context) (*Inventory, error) {
+	var inv *Inventory
+	err := s.serialize(ctx, func() (err error) {
+		inv, err = s.collectInventory(ctx)
+		return err
+	})
+	return inv, err
+}
+
+// collectInventory collects the inventory of the volume group.
+func (s *Server) collectInventory(ctx context.Context) (*Inventory, error) {
+	if s.removingVolumeGroup {
+		return nil, fmt.Errorf("the volume group %v is being removed", s.vgname)
+	}
+	vg := s.volumeGroup.WithContext(ctx)
+	inv := &Inventory{
+		CreatedAt:       time.Now().UTC(),
+		NodeID:          s.nodeID,
+		VolumeGroup:     InventoryVolumeGroup{Name: s.vgname},
+		PhysicalVolumes: []InventoryPhysicalVolume{},
+	}
+	var err error
+	if inv.VolumeGroup.Tags, err = vg.Tags(); err != nil {
+		return nil, fmt.Errorf("cannot list volume group tags: %v", err)
+	}
+	if inv.VolumeGroup.ExtentSize, err = vg.ExtentSize(); err != nil {
+		return nil, fmt.Errorf("cannot determine extent size: %v", err)
+	}
+	if inv.VolumeGroup.BytesTotal, err = vg.BytesTotal(); err != nil {
+		return nil, fmt.Errorf("cannot determine total bytes: %v", err)
+	}
+	if inv.VolumeGroup.BytesFree, err = vg.BytesFree(lvm.VolumeLayout{Type: lvm.VolumeTypeLinear}); err != nil {
+		return nil, fmt.Errorf("cannot determine free bytes: %v", err)
+	}
+	pvs, err := vg.ReportPhysicalVolumes()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list physical volumes: %v", err)
+	}
+	for _, pv := range pvs {
+		health := "ok"
+		if pv.Missing {
+			health = "missing"
+		}
+		inv.PhysicalVolumes = append(inv.PhysicalVolumes, InventoryPhysicalVolume{
+			Name:        pv.Name,
+			SizeInBytes: pv.SizeInBytes,
+			BytesFree:   pv.BytesFree,
+			Health:      health,
+			Tags:        pv.Tags,
+		})
+	}
+	if inv.LogicalVolumes, err = s.inventoryLogicalVolumes(ctx, volumeFilter{}); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// inventoryLogicalVolumes collects the logical volumes of the inventory
+// that match the filter, sorted by id.
+func (s *Server) inventoryLogicalVolumes(ctx context.Context, filter volumeFilter) ([]InventoryLogicalVolume, error) {
+	if s.removingVolumeGroup {
+		return nil, fmt.Errorf("the volume group %v is being removed", s.vgname)
+	}
+	lvs, err := s.volumeGroup.WithContext(ctx).ReportLogicalVolumes()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list logical volumes: %v", err)
+	}
+	lvs = filter.apply(s.ownedVolumes(lvs))
+	sort.Slice(lvs, func(i, j int) bool { return lvs[i].Name < lvs[j].Name })
+	result := []InventoryLogicalVolume{}
+	for _, lv := range lvs {
+		info := InventoryLogicalVolume{
+			ID:          s.volumeIDFromName(lv.Name),
+			SizeInBytes: lv.SizeInBytes,
+			Tags:        lv.Tags,
+			Targets:     s.inventoryTargets(lv.Name),
+		}
+		info.Name, _ = volumeNameFromTags(lv.Tags)
+		if tag, ok := layoutTagFromTags(lv.Tags); ok {
+			info.Layout = strings.TrimPrefix(tag, tagLayoutPrefix)
+		}
+		info.Abnormal, info.Message = s.volumeCondition(lv)
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+// inventoryTargets returns the sorted target paths of the volume.
+func (s *Server) inventoryTargets(id string) []InventoryTarget {
+	targets := []InventoryTarget{}
+	for path, info := range s.targets.entries(id) {
+		targets = append(targets, InventoryTarget{Path: path, Readonly: info.Readonly})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Path < targets[j].Path })
+	return targets
+}
+
+// InventoryMount describes a mount of a logical volume of the volume group.
+type InventoryMount struct {
+	MountInfo
+	// Published is whether the plugin published the volume at the
+	// mount path. Mounts that are not published have leaked, e.g., from
+	// an interrupted NodeUnpublishVolume, or were made outside of the
+	// plugin.
+	Published bool `json:"published"`
+}
+
+// Mounts returns the current mounts whose source is a logical volume of the
+// volume group, sorted by path. This includes both filesystem mounts and
+// the bind mounts of BLOCK_DEVICE volumes. Like Inventory, it is serialized
+// with the requests.
+func (s *Server) Mounts(ctx context.Context) ([]InventoryMount, error) {
+	var result []InventoryMount
+	err := s.serialize(ctx, func() (err error) {
+		result, err = s.collectMounts(ctx)
+		return err
+	})
+	return result, err
+}
+
+// collectMounts collects the mounts of the logical volumes of the volume
+// group.
+func (s *Server) collectMounts(ctx context.Context) ([]InventoryMount, error) {
+	if s.removingVolumeGroup {
+		return nil, fmt.Errorf("the volume group %v is being removed", s.vgname)
+	}
+	lvs, err := s.volumeGroup.WithContext(ctx).ReportLogicalVolumes()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list logical volumes: %v", err)
+	}
+	sources := make(map[string]string)
+	for _, lv := range s.ownedVolumes(lvs) {
+		addMountSources(sources, lv.Name, lv.Path)
+	}
+	mounts, err := listMounts()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list mounts: %v", err)
+	}
+	result := []InventoryMount{}
+	for _, mi := range logicalVolumeMounts(mounts, sources) {
+		_, published := s.targets.entries(mi.LogicalVolume)[mi.Path]
+		result = append(result, InventoryMount{mi, published})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// InventoryHandler returns a read-only HTTP handler that serves the
+// Inventory as JSON at /inventory and the Mounts as JSON at /mounts. It
+// serves the logical volumes of the inventory at /volumes, which the "tag"
+// and "name-prefix" query parameters filter like the ListVolumes metadata.
+// Like Inventory, they are serialized with the requests.
+func (s *Server) InventoryHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/inventory", jsonHandler("inventory", func(ctx context.Context) (interface{}, error) {
+		return s.Inventory(ctx)
+	}))
+	mux.HandleFunc("/volumes", func(w http.ResponseWriter, r *http.Request) {
+		filter, err := volumeFilterFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jsonHandler("volumes", func(ctx context.Context) (interface{}, error) {
+			var lvs []InventoryLogicalVolume
+			err := s.serialize(ctx, func() (err error) {
+				lvs, err = s.inventoryLogicalVolumes(ctx, filter)
+				return err
+			})
+			return lvs, err
+		})(w, r)
+	})
+	mux.HandleFunc("/mounts", jsonHandler("mounts", func(ctx context.Context) (interface{}, error) {
+		return s.Mounts(ctx)
+	}))
+	return mux
+}
+
+// jsonHandler returns a read-only HTTP handler func that serves the result
+// of collect as JSON. The what argument names the result in log messages.
+func jsonHandler(what string, collect func(context.Context) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result, err := collect(r.Context())
+		if err != nil {
+			log.Printf("Cannot collect %v: err=%v", what, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			log.Printf("Cannot write %v: err=%v", what, err)
+		}
+	}
+}
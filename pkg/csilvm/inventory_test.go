@@ -0,0 +1,80 @@
+package csilvm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestVolumeNameFromTags(t *testing.T) {
+	s := NewServer("vg", nil, "xfs")
+	for _, name := range []string{"plain-name", "not tag safe/äö"} {
+		got, ok := volumeNameFromTags([]string{"other", s.volumeNameToTag(name), "LY.linear"})
+		if !ok || got != name {
+			t.Fatalf("expected %q, got %q (ok=%v)", name, got, ok)
+		}
+	}
+	if got, ok := volumeNameFromTags([]string{"other"}); ok {
+		t.Fatalf("expected no name, got %q", got)
+	}
+}
+
+func TestInventoryTargets(t *testing.T) {
+	s := NewServer("vg", nil, "xfs")
+	s.targets.add("lv1", "/b", targetInfo{Readonly: true})
+	s.targets.add("lv1", "/a", targetInfo{})
+	s.targets.add("lv2", "/c", targetInfo{})
+	exp := []InventoryTarget{{Path: "/a"}, {Path: "/b", Readonly: true}}
+	if got := s.inventoryTargets("lv1"); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if got := s.inventoryTargets("lv3"); got == nil || len(got) != 0 {
+		t.Fatalf("expected an empty list, got %#v", got)
+	}
+}
+
+func TestInventoryHandler(t *testing.T) {
+	s := NewServer("vg", nil, "xfs", RemoveVolumeGroup())
+	for _, tt := range []struct {
+		method, path string
+		code         int
+	}{
+		{"POST", "/inventory", http.StatusMethodNotAllowed},
+		{"GET", "/other", http.StatusNotFound},
+		// The inventory is unavailable while removing the volume group.
+		{"GET", "/inventory", http.StatusInternalServerError},
+		{"HEAD", "/mounts", http.StatusInternalServerError},
+		{"DELETE", "/mounts", http.StatusMethodNotAllowed},
+	} {
+		rec := httptest.NewRecorder()
+		s.InventoryHandler().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Fatalf("%s %s: expected %d, got %d", tt.method, tt.path, tt.code, rec.Code)
+		}
+	}
+}
+
+func TestLogicalVolumeMounts(t *testing.T) {
+	sources := map[string]string{
+		"/dev/vg/lv1":     "lv1",
+		"/dev/dm-1":       "lv1",
+		"/dev/mapper/lv2": "lv2",
+	}
+	mounts := []mountpoint{
+		{root: "/", path: "/a", fstype: "xfs", mountsource: "/dev/dm-1", mountopts: []string{"rw"}},
+		{root: "/", path: "/b", fstype: "ext4", mountsource: "/dev/sda1", mountopts: []string{"rw"}},
+		// The bind mount of a BLOCK_DEVICE volume.
+		{root: "/mapper/lv2", path: "/c", fstype: "devtmpfs", mountsource: "udev", mountopts: []string{"ro"}},
+	}
+	exp := []MountInfo{
+		{LogicalVolume: "lv1", Path: "/a", Source: "/dev/dm-1", Fstype: "xfs", Options: []string{"rw"}},
+		{LogicalVolume: "lv2", Path: "/c", Source: "udev", Fstype: "devtmpfs", Options: []string{"ro"}},
+	}
+	if got := logicalVolumeMounts(mounts, sources); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %+v, got %+v", exp, got)
+	}
+	if got := logicalVolumeMounts(nil, sources); got == nil || len(got) != 0 {
+		t.Fatalf("expected an empty list, got %#v", got)
+	}
+}
@@ -0,0 +1,136 @@
+package csilvm
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/semaphore"
+)
+
+// ioSlotRetryDelay is how often a waiting operation retries acquiring an
+// I/O slot.
+const ioSlotRetryDelay = 250 * time.Millisecond
+
+// IOConcurrencyLimit limits the number of I/O intensive operations, i.e.,
+// formatting a volume in NodePublishVolume and wiping a volume in
+// DeleteVolume, that run concurrently on the node. The limit is enforced
+// with limit lock files in dir and therefore applies across all csilvm
+// instances that are configured with the same dir and limit, e.g., one per
+// volume group. Operations wait until a slot is free or their RPC times
+// out. A limit of zero disables the limiter.
+func IOConcurrencyLimit(dir string, limit int) ServerOpt {
+	return func(s *Server) {
+		if limit <= 0 {
+			s.ioLimiter = nil
+			return
+		}
+		l := &ioLimiter{
+			sem:  semaphore.NewWeighted(int64(limit)),
+			held: make([]bool, limit),
+		}
+		for i := 0; i < limit; i++ {
+			l.slots = append(l.slots, flock.New(filepath.Join(dir, fmt.Sprintf("csilvm-io-%d.lock", i))))
+		}
+		s.ioLimiter = l
+	}
+}
+
+// ioLimiter is a counting semaphore whose slots are lock files. The locks
+// only exclude other processes as they are all held through the same open
+// files, so the operations of this process are limited by sem as well and
+// each slot is used by at most one of them at a time.
+type ioLimiter struct {
+	sem   *semaphore.Weighted
+	slots []*flock.Flock
+	mu    sync.Mutex
+	// held records which slots are held by operations of this process.
+	held []bool
+}
+
+// tryLock locks a free slot and returns its index, or -1 if all slots are
+// held by this or other processes.
+func (l *ioLimiter) tryLock() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, slot := range l.slots {
+		if l.held[i] {
+			continue
+		}
+		ok, err := slot.TryLock()
+		if err != nil {
+			return -1, fmt.Errorf("cannot lock %v: %v", slot.Path(), err)
+		}
+		if ok {
+			l.held[i] = true
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// unlock releases the slot locked by tryLock.
+func (l *ioLimiter) unlock(i int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.slots[i].Unlock(); err != nil {
+		log.Printf("Failed to release I/O slot %v: err=%v", l.slots[i].Path(), err)
+	}
+	l.held[i] = false
+}
+
+// acquireIOSlot waits for a free I/O slot for the named operation, e.g.,
+// "format" or "wipe", and returns a func that releases it. The time spent
+// waiting is recorded by the io.wait timer.
+func (s *Server) acquireIOSlot(ctx context.Context, operation string) (release func(), err error) {
+	if s.ioLimiter == nil {
+		return func() {}, nil
+	}
+	scope := s.metrics.Tagged(map[string]string{"operation": operation})
+	start := time.Now()
+	defer func() {
+		waited := time.Since(start)
+		scope.SubScope("io").Timer("wait").Record(waited)
+		traceEvent(ctx, "waited %v for an I/O slot to %v", waited, operation)
+	}()
+	// The operations of this process wait for each other here, without
+	// polling, before competing for the lock files with other processes.
+	l := s.ioLimiter
+	logged := false
+	if !l.sem.TryAcquire(1) {
+		log.Printf("All %d I/O slots are busy, waiting to %v", len(l.slots), operation)
+		logged = true
+		if err := l.sem.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		i, err := l.tryLock()
+		if err != nil {
+			l.sem.Release(1)
+			return nil, err
+		}
+		if i >= 0 {
+			if logged {
+				log.Printf("Acquired I/O slot %v for %v after %v", l.slots[i].Path(), operation, time.Since(start))
+			}
+			return func() {
+				l.unlock(i)
+				l.sem.Release(1)
+			}, nil
+		}
+		if !logged {
+			log.Printf("All %d I/O slots are busy, waiting to %v", len(l.slots), operation)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			l.sem.Release(1)
+			return nil, ctx.Err()
+		case <-time.After(ioSlotRetryDelay):
+		}
+	}
+}
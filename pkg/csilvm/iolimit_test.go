@@ -0,0 +1,110 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestAcquireIOSlot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Two servers model two csilvm instances on the same node.
+	s1 := NewServer("vg1", nil, "xfs", IOConcurrencyLimit(dir, 1))
+	s2 := NewServer("vg2", nil, "xfs", IOConcurrencyLimit(dir, 1))
+	release, err := s1.acquireIOSlot(context.Background(), "format")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*ioSlotRetryDelay)
+	defer cancel()
+	if _, err := s2.acquireIOSlot(ctx, "wipe"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v while the only slot is held but got %v", context.DeadlineExceeded, err)
+	}
+	// Once the slot is released the waiting operation proceeds.
+	time.AfterFunc(ioSlotRetryDelay, release)
+	release2, err := s2.acquireIOSlot(context.Background(), "wipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release2()
+}
+
+func TestAcquireIOSlotSameProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const limit = 2
+	s := NewServer("vg", nil, "xfs", IOConcurrencyLimit(dir, limit))
+	// limit+1 concurrent operations of the same process never hold
+	// more than limit slots at a time.
+	var mu sync.Mutex
+	var active, maxActive int
+	var wg sync.WaitGroup
+	errs := make(chan error, limit+1)
+	for i := 0; i < limit+1; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := s.acquireIOSlot(context.Background(), "wipe")
+			if err != nil {
+				errs <- err
+				return
+			}
+			mu.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+			time.Sleep(100 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			release()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if maxActive != limit {
+		t.Fatalf("Expected at most %d concurrent operations but got %d", limit, maxActive)
+	}
+	// While all slots are held another operation waits.
+	var releases []func()
+	for i := 0; i < limit; i++ {
+		release, err := s.acquireIOSlot(context.Background(), "format")
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*ioSlotRetryDelay)
+	defer cancel()
+	if _, err := s.acquireIOSlot(ctx, "wipe"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v while all slots are held but got %v", context.DeadlineExceeded, err)
+	}
+	for _, release := range releases {
+		release()
+	}
+}
+
+func TestAcquireIOSlotUnlimited(t *testing.T) {
+	s := NewServer("vg", nil, "xfs")
+	release, err := s.acquireIOSlot(context.Background(), "format")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -0,0 +1,323 @@
+package csilvm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+// The operations recorded in the journal.
+const (
+	journalCreate  = "create"
+	journalDelete  = "delete"
+	journalPublish = "publish"
+)
+
+// journalCompactSize is the size above which the journal is truncated once
+// no operation is in progress.
+const journalCompactSize = 1 << 20
+
+// OperationJournal configures the server to record the intent and the
+// completion of each CreateVolume, DeleteVolume and NodePublishVolume in a
+// journal in the StateDir. Operations that were interrupted, e.g., because
+// the plugin crashed, are finished or rolled back by ReplayJournal.
+func OperationJournal() ServerOpt {
+	return func(s *Server) {
+		s.journalEnabled = true
+	}
+}
+
+// journalEntry is a line of the journal. An operation is recorded by an
+// entry when it starts and an entry with the same Seq and Done set when it
+// completes, successfully or not.
+type journalEntry struct {
+	Seq    uint64 `json:"seq"`
+	Op     string `json:"op,omitempty"`
+	Volume string `json:"volume,omitempty"`
+	Target string `json:"target,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+// journal is an append-only file of journalEntry lines. Every entry is
+// synced to disk before the operation proceeds.
+type journal struct {
+	mu      sync.Mutex
+	f       *os.File
+	seq     uint64
+	pending int
+}
+
+// readJournal returns the operations in the journal at path that started
+// but never completed, in the order they started. A missing journal has
+// none. A partially written last line, as left by a crash, is ignored.
+func readJournal(path string) (pending []journalEntry, seq uint64, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	started := make(map[uint64]journalEntry)
+	var order []uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("Ignoring invalid journal entry %q: err=%v", scanner.Text(), err)
+			continue
+		}
+		if entry.Seq > seq {
+			seq = entry.Seq
+		}
+		if entry.Done {
+			delete(started, entry.Seq)
+			continue
+		}
+		started[entry.Seq] = entry
+		order = append(order, entry.Seq)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	for _, n := range order {
+		if entry, ok := started[n]; ok {
+			pending = append(pending, entry)
+		}
+	}
+	return pending, seq, nil
+}
+
+// openJournal truncates the journal at path and opens it for appending,
+// recording the given entries as still pending until they are finished.
+func openJournal(path string, seq uint64, pending []journalEntry) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{f: f, seq: seq, pending: len(pending)}
+	for _, entry := range pending {
+		if err := j.append(entry); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// append writes the entry and syncs it to disk. The caller must hold j.mu
+// unless the journal is not shared yet.
+func (j *journal) append(entry journalEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// begin records that the operation on the volume starts and returns the
+// function that records its completion. It is a no-op if j is nil.
+func (j *journal) begin(op, volume, target string) (end func(), err error) {
+	if j == nil {
+		return func() {}, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	seq := j.seq
+	if err := j.append(journalEntry{Seq: seq, Op: op, Volume: volume, Target: target}); err != nil {
+		return nil, err
+	}
+	j.pending++
+	return func() {
+		j.finish(journalEntry{Seq: seq, Op: op, Volume: volume})
+	}, nil
+}
+
+// finish records the completion of the pending operation.
+func (j *journal) finish(entry journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.pending--
+	if err := j.append(journalEntry{Seq: entry.Seq, Done: true}); err != nil {
+		log.Printf("Cannot record completion of %v of volume %v in journal: err=%v", entry.Op, entry.Volume, err)
+		return
+	}
+	j.compact()
+}
+
+// compact truncates the journal if it is large and no operation is in
+// progress. The caller must hold j.mu.
+func (j *journal) compact() {
+	if j.pending > 0 {
+		return
+	}
+	fi, err := j.f.Stat()
+	if err != nil || fi.Size() < journalCompactSize {
+		return
+	}
+	log.Printf("Truncating journal %v of %v bytes", j.f.Name(), fi.Size())
+	if err := j.f.Truncate(0); err != nil {
+		log.Printf("Cannot truncate journal: err=%v", err)
+	}
+}
+
+// journalBegin records the start of an operation in the journal, if one is
+// configured, and returns the function that records its completion.
+func (s *Server) journalBegin(op, volume, target string) (end func(), err error) {
+	end, err = s.journal.begin(op, volume, target)
+	if err != nil {
+		return nil, statusErrorf(
+			codes.Internal,
+			s.errorInfo(ReasonInternal, "lvname", volume),
+			"Cannot record %v in journal: err=%v",
+			op, err)
+	}
+	return end, nil
+}
+
+// setupJournal opens the journal for the operations to come. The operations
+// that it records as interrupted are kept in the journal until
+// ReplayJournal replays them.
+func (s *Server) setupJournal() error {
+	path := filepath.Join(s.stateDir, s.vgname+"-journal")
+	log.Printf("Opening journal %v", path)
+	pending, seq, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+	j, err := openJournal(path, seq, pending)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		log.Printf("Journal %v records %d interrupted operations", path, len(pending))
+	}
+	s.journal = j
+	s.journalPending = pending
+	return nil
+}
+
+// ReplayJournal finishes or rolls back the operations that the journal
+// records as interrupted, see OperationJournal, in the background. Each
+// operation is replayed like a request: it is validated and canceled if it
+// takes longer than timeout. The requests, see Serializer, wait for the
+// replay so that, e.g., a retried CreateVolume never returns a volume that
+// is about to be rolled back; ReplayJournal must therefore be called before
+// requests are served. Operations that cannot be
+// replayed are kept in the journal and retried after the next restart. The
+// returned function stops the replay, interrupting an ongoing operation,
+// and waits for it to return.
+func (s *Server) ReplayJournal(timeout time.Duration) context.CancelFunc {
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	pending := s.journalPending
+	s.journalPending = nil
+	// Acquire cannot fail without a deadline.
+	_ = s.serializer.sem.Acquire(context.Background(), 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer s.serializer.sem.Release(1)
+		for _, entry := range pending {
+			if err := s.replayWithTimeout(ctx, entry, timeout); err != nil {
+				log.Printf("Cannot replay interrupted %v of volume %v: err=%v", entry.Op, entry.Volume, err)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// replayWithTimeout replays the interrupted operation and records its
+// completion.
+func (s *Server) replayWithTimeout(ctx context.Context, entry journalEntry, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := s.replay(ctx, entry); err != nil {
+		return err
+	}
+	s.journal.finish(entry)
+	return nil
+}
+
+// replay finishes or rolls back the interrupted operation.
+func (s *Server) replay(ctx context.Context, entry journalEntry) error {
+	switch entry.Op {
+	case journalCreate:
+		// The CO never learned of the volume, it retries the
+		// request or gives up. In either case the volume, which
+		// may not be fully set up, is removed.
+		log.Printf("Rolling back interrupted create of volume %v", entry.Volume)
+		for _, name := range []string{entry.Volume, entry.Volume + cachePoolSuffix} {
+			lv, err := s.volumeGroup.WithContext(ctx).LookupLogicalVolume(name)
+			if err == lvm.ErrLogicalVolumeNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := lv.Remove(); err != nil && err != lvm.ErrLogicalVolumeNotFound {
+				return err
+			}
+		}
+		return nil
+	case journalDelete:
+		log.Printf("Finishing interrupted delete of volume %v", entry.Volume)
+		request := &csi.DeleteVolumeRequest{VolumeId: entry.Volume}
+		if err := validateDeleteVolumeRequest(request, s.removingVolumeGroup); err != nil {
+			return err
+		}
+		// The request was authorized before it was interrupted.
+		_, err := s.deleteVolume(ctx, request)
+		return err
+	case journalPublish:
+		// The CO never learned whether the volume was published,
+		// it retries the request or unpublishes it.
+		log.Printf("Rolling back interrupted publish of volume %v at %v", entry.Volume, entry.Target)
+		request := &csi.NodeUnpublishVolumeRequest{VolumeId: entry.Volume, TargetPath: entry.Target}
+		if err := validateNodeUnpublishVolumeRequest(request, s.removingVolumeGroup); err != nil {
+			return err
+		}
+		_, err := s.NodeUnpublishVolume(ctx, request)
+		if err == ErrVolumeNotFound {
+			return nil
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown operation %q", entry.Op)
+	}
+}
+
+// syncDir syncs the directory so that a file created in it survives a
+// crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
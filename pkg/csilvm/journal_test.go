@@ -0,0 +1,120 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vg-journal")
+	// A missing journal has no pending operations.
+	if pending, seq, err := readJournal(path); err != nil || pending != nil || seq != 0 {
+		t.Fatalf("Expected an empty journal, got %v, %v, %v", pending, seq, err)
+	}
+	data := `{"seq":1,"op":"create","volume":"csilv1"}
+{"seq":2,"op":"publish","volume":"csilv1","target":"/mnt/a"}
+{"seq":1,"done":true}
+{"seq":3,"op":"delete","volume":"csilv2"}
+{"seq":4,"op":"crea`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pending, seq, err := readJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []journalEntry{
+		{Seq: 2, Op: journalPublish, Volume: "csilv1", Target: "/mnt/a"},
+		{Seq: 3, Op: journalDelete, Volume: "csilv2"},
+	}
+	if !reflect.DeepEqual(pending, expected) || seq != 3 {
+		t.Fatalf("Expected %+v and seq 3, got %+v and %v", expected, pending, seq)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vg-journal")
+	failed := []journalEntry{{Seq: 3, Op: journalDelete, Volume: "csilv2"}}
+	j, err := openJournal(path, 3, failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.f.Close()
+	end1, err := j.begin(journalCreate, "csilv3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	end2, err := j.begin(journalPublish, "csilv3", "/mnt/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	end1()
+	pending, seq, err := readJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []journalEntry{
+		{Seq: 3, Op: journalDelete, Volume: "csilv2"},
+		{Seq: 5, Op: journalPublish, Volume: "csilv3", Target: "/mnt/b"},
+	}
+	if !reflect.DeepEqual(pending, expected) || seq != 5 {
+		t.Fatalf("Expected %+v and seq 5, got %+v and %v", expected, pending, seq)
+	}
+	end2()
+	if j.pending != 1 {
+		t.Fatalf("Expected the replayed operation to be pending, got %v", j.pending)
+	}
+	j.finish(failed[0])
+	if pending, _, err := readJournal(path); err != nil || len(pending) != 0 {
+		t.Fatalf("Expected no pending operations, got %+v, %v", pending, err)
+	}
+	// A nil journal records nothing.
+	var none *journal
+	end, err := none.begin(journalCreate, "csilv4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	end()
+}
+
+func TestJournalCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vg-journal")
+	j, err := openJournal(path, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.f.Close()
+	if err := j.f.Truncate(journalCompactSize); err != nil {
+		t.Fatal(err)
+	}
+	end, err := j.begin(journalCreate, "csilv1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	end()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 0 {
+		t.Fatalf("Expected the journal to be truncated, got %v bytes", fi.Size())
+	}
+}
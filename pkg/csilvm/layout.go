@@ -0,0 +1,71 @@
+package csilvm
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+// tagLayoutPrefix prefixes the logical volume tag that records the volume
+// layout requested by CreateVolume, e.g., "LY.linear" or "LY.raid1.2".
+const tagLayoutPrefix = "LY."
+
+// tagLayoutStripes precedes the number of stripes in the layout tag of a
+// striped volume, e.g., "LY.linear.s2" or "LY.raid1.1.s2".
+const tagLayoutStripes = ".s"
+
+// layoutToTag returns the tag that records the given layout. Equivalent
+// layouts, e.g., the default and "linear" layouts, have the same tag.
+func layoutToTag(layout lvm.VolumeLayout) string {
+	var tag string
+	switch layout.Type {
+	case lvm.VolumeTypeRAID1:
+		mirrors := layout.Mirrors
+		if mirrors == 0 {
+			// lvcreate defaults to a single mirror.
+			mirrors = 1
+		}
+		tag = tagLayoutPrefix + "raid1." + strconv.FormatUint(mirrors, 10)
+	default:
+		tag = tagLayoutPrefix + "linear"
+	}
+	if layout.Stripes > 1 {
+		tag += tagLayoutStripes + strconv.FormatUint(layout.Stripes, 10)
+	}
+	return tag
+}
+
+// isStripedLayoutTag returns whether the layout tag records a striped
+// layout.
+func isStripedLayoutTag(tag string) bool {
+	return strings.Contains(tag, tagLayoutStripes)
+}
+
+// stripeSize returns the size of a full stripe of a volume with the given
+// layout. Striped volumes are allocated in full stripes.
+func stripeSize(layout lvm.VolumeLayout, extentSize uint64) uint64 {
+	if layout.Stripes > 1 {
+		return extentSize * layout.Stripes
+	}
+	return extentSize
+}
+
+// roundUp rounds size up to the nearest multiple of unit.
+func roundUp(size, unit uint64) uint64 {
+	if size%unit == 0 {
+		return size
+	}
+	return (size/unit + 1) * unit
+}
+
+// layoutTagFromTags returns the layout tag among the given tags. It
+// returns false if the volume was created before layouts were recorded.
+func layoutTagFromTags(tags []string) (string, bool) {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, tagLayoutPrefix) {
+			return tag, true
+		}
+	}
+	return "", false
+}
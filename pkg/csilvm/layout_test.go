@@ -0,0 +1,83 @@
+package csilvm
+
+import (
+	"testing"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+func TestLayoutToTag(t *testing.T) {
+	cases := []struct {
+		layout lvm.VolumeLayout
+		exp    string
+	}{
+		{lvm.VolumeLayout{}, "LY.linear"},
+		{lvm.VolumeLayout{Type: lvm.VolumeTypeLinear}, "LY.linear"},
+		{lvm.VolumeLayout{Type: lvm.VolumeTypeRAID1}, "LY.raid1.1"},
+		{lvm.VolumeLayout{Type: lvm.VolumeTypeRAID1, Mirrors: 1}, "LY.raid1.1"},
+		{lvm.VolumeLayout{Type: lvm.VolumeTypeRAID1, Mirrors: 2}, "LY.raid1.2"},
+		{lvm.VolumeLayout{Stripes: 2}, "LY.linear.s2"},
+		{lvm.VolumeLayout{Type: lvm.VolumeTypeRAID1, Stripes: 3}, "LY.raid1.1.s3"},
+	}
+	for i, tt := range cases {
+		tag := layoutToTag(tt.layout)
+		if tag != tt.exp {
+			t.Fatalf("test case %d: expected %q but got %q", i, tt.exp, tag)
+		}
+		if err := lvm.ValidateTag(tag); err != nil {
+			t.Fatalf("test case %d: invalid tag %q: %v", i, tag, err)
+		}
+	}
+}
+
+func TestLayoutTagFromTags(t *testing.T) {
+	if _, ok := layoutTagFromTags([]string{"VN.test-volume", "some-tag"}); ok {
+		t.Fatal("expected no layout tag")
+	}
+	tag, ok := layoutTagFromTags([]string{"VN.test-volume", "LY.raid1.2"})
+	if !ok || tag != "LY.raid1.2" {
+		t.Fatalf("expected layout tag LY.raid1.2 but got %q", tag)
+	}
+}
+
+func TestTakeVolumeLayoutFromParametersStripes(t *testing.T) {
+	cases := []struct {
+		params map[string]string
+		exp    lvm.VolumeLayout
+	}{
+		{map[string]string{"stripes": "2"}, lvm.VolumeLayout{Stripes: 2}},
+		// A single stripe is the same as no striping.
+		{map[string]string{"type": "linear", "stripes": "1"}, lvm.VolumeLayout{Type: lvm.VolumeTypeLinear}},
+		{map[string]string{"type": "raid1", "mirrors": "1", "stripes": "2"}, lvm.VolumeLayout{Type: lvm.VolumeTypeRAID1, Mirrors: 1, Stripes: 2}},
+	}
+	for i, tt := range cases {
+		layout, err := takeVolumeLayoutFromParameters(tt.params)
+		if err != nil {
+			t.Fatalf("test case %d: %v", i, err)
+		}
+		if layout != tt.exp {
+			t.Fatalf("test case %d: expected %+v but got %+v", i, tt.exp, layout)
+		}
+		if len(tt.params) != 0 {
+			t.Fatalf("test case %d: expected all parameters to be consumed but got %v", i, tt.params)
+		}
+	}
+	for _, stripes := range []string{"0", "-1", "two"} {
+		if _, err := takeVolumeLayoutFromParameters(map[string]string{"stripes": stripes}); err == nil {
+			t.Fatalf("expected an error for stripes=%v", stripes)
+		}
+	}
+}
+
+func TestStripeSize(t *testing.T) {
+	const extent = 4 << 20
+	if got := stripeSize(lvm.VolumeLayout{}, extent); got != extent {
+		t.Fatalf("expected %v but got %v", extent, got)
+	}
+	if got := stripeSize(lvm.VolumeLayout{Type: lvm.VolumeTypeRAID1, Stripes: 3}, extent); got != 3*extent {
+		t.Fatalf("expected %v but got %v", 3*extent, got)
+	}
+	if got := roundUp(25<<20, 3*extent); got != 36<<20 {
+		t.Fatalf("expected %v but got %v", 36<<20, got)
+	}
+}
@@ -0,0 +1,116 @@
+package csilvm
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+// The gRPC request metadata keys with which ListVolumes callers can filter
+// the listed volumes, so that large fleets can query only the volumes of a
+// given workload without transferring the full list. As ListVolumesRequest
+// has no field for them they are sent as metadata.
+const (
+	// listTagKey only lists the volumes with the given logical volume
+	// tag. If it is given several times only the volumes with all of the
+	// tags are listed.
+	listTagKey = "csilvm-list-tag"
+	// listNamePrefixKey only lists the volumes whose name given to
+	// CreateVolume starts with the given prefix.
+	listNamePrefixKey = "csilvm-list-name-prefix"
+)
+
+// The query parameters of the /volumes admin API that correspond to the
+// ListVolumes metadata keys.
+const (
+	queryTag        = "tag"
+	queryNamePrefix = "name-prefix"
+)
+
+// volumeFilter selects logical volumes by tag and volume name prefix. The
+// zero value selects all volumes.
+type volumeFilter struct {
+	tags       []string
+	namePrefix string
+}
+
+func (f volumeFilter) String() string {
+	return fmt.Sprintf("tags=%v name-prefix=%q", f.tags, f.namePrefix)
+}
+
+// volumeFilterFromContext returns the filter given by the gRPC request
+// metadata of ctx.
+func volumeFilterFromContext(ctx context.Context) (volumeFilter, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return volumeFilter{}, nil
+	}
+	return newVolumeFilter(md.Get(listTagKey), md.Get(listNamePrefixKey), listTagKey, listNamePrefixKey)
+}
+
+// volumeFilterFromQuery returns the filter given by the query parameters of
+// an admin API request.
+func volumeFilterFromQuery(query url.Values) (volumeFilter, error) {
+	return newVolumeFilter(query[queryTag], query[queryNamePrefix], queryTag, queryNamePrefix)
+}
+
+// newVolumeFilter validates the given tags and name prefixes. The key
+// arguments name them in error messages.
+func newVolumeFilter(tags, prefixes []string, tagKey, prefixKey string) (volumeFilter, error) {
+	var f volumeFilter
+	for _, tag := range tags {
+		if err := lvm.ValidateTag(tag); err != nil {
+			return volumeFilter{}, fmt.Errorf("The '%s' filter is invalid: err=%v", tagKey, err)
+		}
+		if !containsString(f.tags, tag) {
+			f.tags = append(f.tags, tag)
+		}
+	}
+	if len(prefixes) > 1 {
+		return volumeFilter{}, fmt.Errorf("The '%s' filter may only be given once", prefixKey)
+	}
+	if len(prefixes) == 1 {
+		f.namePrefix = prefixes[0]
+	}
+	return f, nil
+}
+
+// isEmpty returns whether the filter selects all volumes.
+func (f volumeFilter) isEmpty() bool {
+	return len(f.tags) == 0 && f.namePrefix == ""
+}
+
+// matches returns whether the filter selects the logical volume. Volumes
+// whose name was not recorded never match a name prefix.
+func (f volumeFilter) matches(lv lvm.LogicalVolumeReport) bool {
+	for _, tag := range f.tags {
+		if !containsString(lv.Tags, tag) {
+			return false
+		}
+	}
+	if f.namePrefix != "" {
+		name, ok := volumeNameFromTags(lv.Tags)
+		if !ok || !strings.HasPrefix(name, f.namePrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the logical volumes selected by the filter.
+func (f volumeFilter) apply(lvs []lvm.LogicalVolumeReport) []lvm.LogicalVolumeReport {
+	if f.isEmpty() {
+		return lvs
+	}
+	var result []lvm.LogicalVolumeReport
+	for _, lv := range lvs {
+		if f.matches(lv) {
+			result = append(result, lv)
+		}
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package csilvm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestVolumeFilter(t *testing.T) {
+	lvs := []lvm.LogicalVolumeReport{
+		{Name: "lv1", Tags: []string{"VN.web-1", "team.a"}},
+		{Name: "lv2", Tags: []string{"VN.web-2", "team.b"}},
+		{Name: "lv3", Tags: []string{tagVolumeNameEncodedPrefix + "ZGItMQ", "team.a"}},
+		// A volume whose name was not recorded.
+		{Name: "lv4", Tags: []string{"team.a"}},
+	}
+	for _, tt := range []struct {
+		filter volumeFilter
+		exp    []string
+	}{
+		{volumeFilter{}, []string{"lv1", "lv2", "lv3", "lv4"}},
+		{volumeFilter{namePrefix: "web-"}, []string{"lv1", "lv2"}},
+		{volumeFilter{namePrefix: "db-"}, []string{"lv3"}},
+		{volumeFilter{tags: []string{"team.a"}}, []string{"lv1", "lv3", "lv4"}},
+		{volumeFilter{tags: []string{"team.a", "VN.web-1"}}, []string{"lv1"}},
+		{volumeFilter{tags: []string{"team.a"}, namePrefix: "web-"}, []string{"lv1"}},
+		{volumeFilter{tags: []string{"team.c"}}, nil},
+	} {
+		var got []string
+		for _, lv := range tt.filter.apply(lvs) {
+			got = append(got, lv.Name)
+		}
+		if !reflect.DeepEqual(got, tt.exp) {
+			t.Fatalf("%v: expected %v, got %v", tt.filter, tt.exp, got)
+		}
+	}
+}
+
+func TestVolumeFilterFromContext(t *testing.T) {
+	f, err := volumeFilterFromContext(context.Background())
+	if err != nil || !f.isEmpty() {
+		t.Fatalf("expected an empty filter, got %v (err=%v)", f, err)
+	}
+	md := metadata.Pairs(listTagKey, "a", listTagKey, "b", listTagKey, "a", listNamePrefixKey, "web-")
+	f, err = volumeFilterFromContext(metadata.NewIncomingContext(context.Background(), md))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (volumeFilter{tags: []string{"a", "b"}, namePrefix: "web-"}); !reflect.DeepEqual(f, exp) {
+		t.Fatalf("expected %v, got %v", exp, f)
+	}
+	for _, md := range []metadata.MD{
+		metadata.Pairs(listTagKey, "not a tag"),
+		metadata.Pairs(listNamePrefixKey, "a", listNamePrefixKey, "b"),
+	} {
+		if f, err := volumeFilterFromContext(metadata.NewIncomingContext(context.Background(), md)); err == nil {
+			t.Fatalf("%v: expected an error, got %v", md, f)
+		}
+	}
+}
+
+func TestVolumeFilterFromQuery(t *testing.T) {
+	query, err := url.ParseQuery("tag=team.a&name-prefix=web-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := volumeFilterFromQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (volumeFilter{tags: []string{"team.a"}, namePrefix: "web-"}); !reflect.DeepEqual(f, exp) {
+		t.Fatalf("expected %v, got %v", exp, f)
+	}
+	s := NewServer("vg", nil, "xfs")
+	rec := httptest.NewRecorder()
+	s.InventoryHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/volumes?tag=not+a+tag", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
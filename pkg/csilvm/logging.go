@@ -21,7 +21,7 @@ func SetLogger(l logger) {
 
 func LoggingInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		log.Printf("Serving %v: req=%v", info.FullMethod, req)
+		log.Printf("Serving %v: req=%v", info.FullMethod, redactSecrets(req))
 		v, err := handler(ctx, req)
 		if err != nil {
 			log.Printf("%v failed: err=%v", info.FullMethod, err)
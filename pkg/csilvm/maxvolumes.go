@@ -0,0 +1,52 @@
+package csilvm
+
+import (
+	"strconv"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+// MaxVolumes configures the server to report n as the maximum number of
+// volumes on the node in NodeGetInfo and to fail CreateVolume with
+// ResourceExhausted once the volume group has n volumes, e.g., to keep a
+// storm of volume claims from filling the device-mapper table of the node.
+// Volumes in the trash count towards the limit until they are reaped as
+// their devices still exist. A value of zero means no limit.
+func MaxVolumes(n int64) ServerOpt {
+	return func(s *Server) {
+		s.maxVolumes = n
+	}
+}
+
+// countVolumes returns the number of the logical volumes with the given
+// names that are managed by this server, including those in the trash.
+func (s *Server) countVolumes(names []string) int64 {
+	var n int64
+	for _, name := range names {
+		if s.ownsVolume(name) || s.ownsTrashedVolume(name) {
+			n++
+		}
+	}
+	return n
+}
+
+// checkVolumeLimit returns an error if the MaxVolumes option is set and the
+// volume group already has that many volumes.
+func (s *Server) checkVolumeLimit(ctx context.Context) error {
+	if s.maxVolumes == 0 {
+		return nil
+	}
+	names, err := s.volumeGroup.WithContext(ctx).ListLogicalVolumeNames()
+	if err != nil {
+		return s.lvmError(err, "Cannot count volumes")
+	}
+	if n := s.countVolumes(names); n >= s.maxVolumes {
+		log.Printf("Cannot create another volume as there are %d volumes and the limit is %d", n, s.maxVolumes)
+		return statusErrorf(
+			codes.ResourceExhausted,
+			s.errorInfo(ReasonVolumeLimitReached, "maxVolumes", strconv.FormatInt(s.maxVolumes, 10)),
+			"The node already has %d volumes, the maximum is %d", n, s.maxVolumes)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package csilvm
+
+import (
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+)
+
+func TestCountVolumes(t *testing.T) {
+	names := []string{
+		"csilvabc",
+		"team1_csilvdef",
+		trashNamePrefix + "csilvghi",
+		trashNamePrefix + "team1_csilvjkl",
+	}
+	s := &Server{}
+	if got := s.countVolumes(names); got != 4 {
+		t.Fatalf("expected all 4 volumes to be counted but got %d", got)
+	}
+	s = &Server{volumePrefix: "team1"}
+	if got := s.countVolumes(names); got != 2 {
+		t.Fatalf("expected the 2 volumes with the prefix to be counted but got %d", got)
+	}
+}
+
+func TestNodeGetInfoMaxVolumes(t *testing.T) {
+	s := NewServer("vg0", nil, "xfs", NodeID("node1"), MaxVolumes(100))
+	resp, err := s.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetMaxVolumesPerNode() != 100 {
+		t.Fatalf("expected max_volumes_per_node 100 but got %d", resp.GetMaxVolumesPerNode())
+	}
+}
@@ -0,0 +1,86 @@
+package csilvm
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+const (
+	// tagMetadataPrefix prefixes the logical volume tags that record
+	// metadata parameters. The tag is of the form
+	// MD+<base64(key)>.<base64(value)> as neither keys nor values are
+	// necessarily tag-safe.
+	tagMetadataPrefix = "MD+"
+	// attrMetadataPrefix prefixes the volume attributes that report
+	// metadata parameters, e.g., "metadata.owner".
+	attrMetadataPrefix = "metadata."
+)
+
+// MetadataParameter configures the server to accept the CreateVolume
+// parameter with the given key, e.g., "owner", and to record its value as a
+// tag on the logical volume. The value is reported by CreateVolume and
+// ListVolumes as the "metadata.<key>" volume attribute. This option may be
+// specified multiple times.
+func MetadataParameter(key string) ServerOpt {
+	return func(s *Server) {
+		if s.metadataParams == nil {
+			s.metadataParams = make(map[string]struct{})
+		}
+		s.metadataParams[key] = struct{}{}
+	}
+}
+
+// takeMetadataFromParameters removes the configured metadata parameters
+// from params and returns the corresponding logical volume tags.
+func (s *Server) takeMetadataFromParameters(params map[string]string) ([]string, error) {
+	var tags []string
+	for key := range s.metadataParams {
+		value, ok := params[key]
+		if !ok {
+			continue
+		}
+		delete(params, key)
+		tag := metadataToTag(key, value)
+		if err := lvm.ValidateTag(tag); err != nil {
+			return nil, fmt.Errorf("The '%s' parameter cannot be recorded: err=%v", key, err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func metadataToTag(key, value string) string {
+	return tagMetadataPrefix +
+		base64.RawURLEncoding.EncodeToString([]byte(key)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+// metadataFromTags returns the metadata recorded in the given tags. Tags
+// that are not metadata tags or that cannot be decoded are ignored.
+func metadataFromTags(tags []string) map[string]string {
+	var md map[string]string
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, tagMetadataPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(tag, tagMetadataPrefix), ".", 2)
+		if len(parts) != 2 {
+			log.Printf("Ignoring malformed metadata tag %v", tag)
+			continue
+		}
+		key, kerr := base64.RawURLEncoding.DecodeString(parts[0])
+		value, verr := base64.RawURLEncoding.DecodeString(parts[1])
+		if kerr != nil || verr != nil {
+			log.Printf("Ignoring malformed metadata tag %v", tag)
+			continue
+		}
+		if md == nil {
+			md = make(map[string]string)
+		}
+		md[string(key)] = string(value)
+	}
+	return md
+}
@@ -0,0 +1,38 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetadataTags(t *testing.T) {
+	s := NewServer("test-vg", nil, "xfs", MetadataParameter("owner"), MetadataParameter("workload"))
+	params := map[string]string{
+		"owner":    "team a/b",
+		"workload": "kafka",
+		"type":     "linear",
+	}
+	tags, err := s.takeMetadataFromParameters(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 {
+		t.Fatalf("Expected 2 tags but got %v", tags)
+	}
+	// The metadata parameters are consumed.
+	if exp := map[string]string{"type": "linear"}; !reflect.DeepEqual(params, exp) {
+		t.Fatalf("Expected remaining parameters %v but got %v", exp, params)
+	}
+	got := metadataFromTags(append(tags, "some-tag", tagVolumeNamePlainPrefix+"test-volume"))
+	exp := map[string]string{"owner": "team a/b", "workload": "kafka"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected metadata %v but got %v", exp, got)
+	}
+}
+
+func TestMetadataFromTagsMalformed(t *testing.T) {
+	got := metadataFromTags([]string{tagMetadataPrefix + "bm9wZQ", tagMetadataPrefix + "!!.!!"})
+	if got != nil {
+		t.Fatalf("Expected no metadata but got %v", got)
+	}
+}
@@ -15,14 +15,21 @@ const (
 	resultTypeError   = "error"
 )
 
-func MetricsInterceptor(scope tally.Scope) grpc.UnaryServerInterceptor {
+// MetricsInterceptor reports the number and latency of requests to scope,
+// tagged with their method and, if VolumeMetricSlices is given, with the
+// volume they are for.
+func MetricsInterceptor(scope tally.Scope, opts ...InterceptorOpt) grpc.UnaryServerInterceptor {
+	o := newInterceptorOpts(opts)
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		scope = scope.Tagged(map[string]string{
+		scope := scope.Tagged(map[string]string{
 			"method": info.FullMethod,
 		})
-		timer := scope.SubScope("requests").Timer("latency")
-		defer timer.Start().Stop()
+		start := time.Now()
 		v, err := handler(ctx, req)
+		// The volume id of a CreateVolume request is only known once
+		// it succeeded.
+		scope = o.volumeScope(scope, req, v)
+		scope.SubScope("requests").Timer("latency").Record(time.Since(start))
 		if err != nil {
 			scope.Tagged(map[string]string{"result_type": resultTypeError}).Counter("requests").Inc(1)
 			return nil, err
@@ -72,7 +79,13 @@ func (s *Server) reportStorageMetrics() {
 		log.Printf("failed to report metrics: cannot load lv names: err=%v", err)
 		return
 	}
-	s.metrics.Gauge("volumes").Update(float64(len(volNames)))
+	volumes := 0
+	for _, name := range volNames {
+		if s.ownsVolume(name) {
+			volumes++
+		}
+	}
+	s.metrics.Gauge("volumes").Update(float64(volumes))
 	// Report the total bytes free for the volume group.
 	bytesTotal, err := s.volumeGroup.BytesTotal()
 	if err != nil {
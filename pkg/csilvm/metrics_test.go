@@ -1,4 +1,4 @@
-// +build !unit
+// +build linux,!unit
 
 //nolint:errcheck
 package csilvm
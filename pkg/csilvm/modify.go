@@ -0,0 +1,325 @@
+package csilvm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// The vendored CSI spec predates the ControllerModifyVolume RPC so the
+// plugin serves it as the ModifyVolume method of its own csilvm.v0.Modify
+// service. The messages are wire-compatible with the CSI v1
+// ControllerModifyVolume messages, without the secrets field which the
+// plugin does not use.
+
+// ModifyVolumeRequest changes the mutable parameters of an existing volume.
+type ModifyVolumeRequest struct {
+	// VolumeId is the id of the volume to modify.
+	VolumeId string `protobuf:"bytes,1,opt,name=volume_id,json=volumeId,proto3" json:"volume_id,omitempty"`
+	// MutableParameters are the parameters to change, e.g.,
+	// "type": "raid1".
+	MutableParameters    map[string]string `protobuf:"bytes,3,rep,name=mutable_parameters,json=mutableParameters,proto3" json:"mutable_parameters,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *ModifyVolumeRequest) Reset()         { *m = ModifyVolumeRequest{} }
+func (m *ModifyVolumeRequest) String() string { return proto.CompactTextString(m) }
+func (*ModifyVolumeRequest) ProtoMessage()    {}
+
+func (m *ModifyVolumeRequest) GetVolumeId() string {
+	if m != nil {
+		return m.VolumeId
+	}
+	return ""
+}
+
+func (m *ModifyVolumeRequest) GetMutableParameters() map[string]string {
+	if m != nil {
+		return m.MutableParameters
+	}
+	return nil
+}
+
+// ModifyVolumeResponse is returned once the volume has been modified.
+type ModifyVolumeResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ModifyVolumeResponse) Reset()         { *m = ModifyVolumeResponse{} }
+func (m *ModifyVolumeResponse) String() string { return proto.CompactTextString(m) }
+func (*ModifyVolumeResponse) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*ModifyVolumeRequest)(nil), "csilvm.v0.ModifyVolumeRequest")
+	proto.RegisterType((*ModifyVolumeResponse)(nil), "csilvm.v0.ModifyVolumeResponse")
+}
+
+// ModifyServer is the server API for the csilvm.v0.Modify service.
+type ModifyServer interface {
+	ModifyVolume(context.Context, *ModifyVolumeRequest) (*ModifyVolumeResponse, error)
+}
+
+const modifyVolumeMethod = "/csilvm.v0.Modify/ModifyVolume"
+
+// RegisterModifyServer registers the csilvm.v0.Modify service.
+func RegisterModifyServer(s *grpc.Server, srv ModifyServer) {
+	s.RegisterService(&modifyServiceDesc, srv)
+}
+
+func modifyVolumeHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ModifyVolumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ModifyServer).ModifyVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: modifyVolumeMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ModifyServer).ModifyVolume(ctx, req.(*ModifyVolumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var modifyServiceDesc = grpc.ServiceDesc{
+	ServiceName: "csilvm.v0.Modify",
+	HandlerType: (*ModifyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ModifyVolume",
+			Handler:    modifyVolumeHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// ModifyClient is the client API for the csilvm.v0.Modify service.
+type ModifyClient interface {
+	ModifyVolume(ctx context.Context, in *ModifyVolumeRequest, opts ...grpc.CallOption) (*ModifyVolumeResponse, error)
+}
+
+type modifyClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewModifyClient(cc *grpc.ClientConn) ModifyClient {
+	return &modifyClient{cc}
+}
+
+func (c *modifyClient) ModifyVolume(ctx context.Context, in *ModifyVolumeRequest, opts ...grpc.CallOption) (*ModifyVolumeResponse, error) {
+	out := new(ModifyVolumeResponse)
+	if err := c.cc.Invoke(ctx, modifyVolumeMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Mutable parameters accepted by ModifyVolume in addition to the 'type'
+// and 'mirrors' parameters of CreateVolume.
+const (
+	// paramAddTags is a comma-separated list of LVM tags to add to the
+	// logical volume.
+	paramAddTags = "add-tags"
+	// paramRemoveTags is a comma-separated list of LVM tags to remove
+	// from the logical volume.
+	paramRemoveTags = "remove-tags"
+	// paramReadonly is "true" or "false" and sets the permission of
+	// the logical volume.
+	paramReadonly = "readonly"
+)
+
+// reservedTagPrefixes prefix the tags with which the plugin records how a
+// volume was created. They cannot be changed by ModifyVolume.
+var reservedTagPrefixes = []string{
+	tagVolumeNamePlainPrefix,
+	tagVolumeNameEncodedPrefix,
+	tagLayoutPrefix,
+	tagCachePrefix,
+	tagPVTagsPrefix,
+	tagMetadataPrefix,
+	tagReadaheadPrefix,
+	tagSchedulerPrefix,
+	tagFilesystemUUIDPrefix,
+}
+
+// volumeChange describes the changes requested by ModifyVolume.
+type volumeChange struct {
+	addTags    []string
+	removeTags []string
+	// readonly is nil if the permission is unchanged.
+	readonly *bool
+	// layout is nil if the layout is unchanged.
+	layout *lvm.VolumeLayout
+}
+
+// takeVolumeChangeFromParameters consumes the mutable parameters and returns
+// the requested changes.
+func takeVolumeChangeFromParameters(params map[string]string) (change volumeChange, err error) {
+	if change.addTags, err = takeTagsFromParameters(params, paramAddTags); err != nil {
+		return change, err
+	}
+	if change.removeTags, err = takeTagsFromParameters(params, paramRemoveTags); err != nil {
+		return change, err
+	}
+	if value, ok := params[paramReadonly]; ok {
+		delete(params, paramReadonly)
+		readonly, err := strconv.ParseBool(value)
+		if err != nil {
+			return change, fmt.Errorf("The '%s' parameter must be 'true' or 'false': err=%v", paramReadonly, err)
+		}
+		change.readonly = &readonly
+	}
+	if _, ok := params["type"]; ok {
+		layout, err := takeVolumeLayoutFromParameters(params)
+		if err != nil {
+			return change, err
+		}
+		if layout.Type != lvm.VolumeTypeRAID1 {
+			return change, fmt.Errorf("Volumes can only be converted to the 'raid1' type")
+		}
+		if layout.Stripes > 0 {
+			return change, fmt.Errorf("Volumes cannot be converted to a striped layout")
+		}
+		change.layout = &layout
+	}
+	if _, ok := params["mirrors"]; ok {
+		return change, fmt.Errorf("The 'mirrors' parameter requires the 'type' parameter")
+	}
+	return change, nil
+}
+
+// takeTagsFromParameters consumes the parameter with the given key whose
+// value is a comma-separated list of tags.
+func takeTagsFromParameters(params map[string]string, key string) ([]string, error) {
+	value, ok := params[key]
+	if !ok {
+		return nil, nil
+	}
+	delete(params, key)
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if err := lvm.ValidateTag(tag); err != nil {
+			return nil, fmt.Errorf("The '%s' parameter must be a comma-separated list of tags: err=%v", key, err)
+		}
+		for _, prefix := range reservedTagPrefixes {
+			if strings.HasPrefix(tag, prefix) {
+				return nil, fmt.Errorf("The '%s' parameter cannot contain the tag %v as tags with the prefix %v are reserved", key, tag, prefix)
+			}
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// ModifyVolume changes the tags, the permission or the layout of an existing
+// volume. Converting a volume to raid1 adds mirror images that are
+// synchronized in the background. Changes that are already in effect are
+// ignored so that the RPC is idempotent.
+func (s *Server) ModifyVolume(
+	ctx context.Context,
+	request *ModifyVolumeRequest) (*ModifyVolumeResponse, error) {
+	id := s.nameFromVolumeID(request.GetVolumeId())
+	params := dupParams(request.GetMutableParameters())
+	change, err := takeVolumeChangeFromParameters(params)
+	if err != nil {
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters, "lvname", id), "Invalid mutable parameters: %v", err)
+	}
+	if len(params) > 0 {
+		var keys []string
+		for key := range params {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters, "lvname", id), "Unsupported mutable parameters: %v", keys)
+	}
+	log.Printf("Looking up volume with id=%v", id)
+	lv, err := s.lookupVolume(ctx, id)
+	if err != nil {
+		return nil, s.lvmError(err, "Cannot look up volume", "lvname", id)
+	}
+	if change.layout != nil {
+		if err := s.convertVolumeLayout(lv, *change.layout); err != nil {
+			return nil, err
+		}
+	}
+	if len(change.addTags) > 0 || len(change.removeTags) > 0 {
+		log.Printf("Changing tags of volume id=%v: add=%v remove=%v", id, change.addTags, change.removeTags)
+		if err := lv.ChangeTags(change.addTags, change.removeTags); err != nil {
+			return nil, s.lvmError(err, "Cannot change tags", "lvname", id)
+		}
+	}
+	if change.readonly != nil {
+		readonly, err := lv.IsReadonly()
+		if err != nil {
+			return nil, s.lvmError(err, "Cannot determine permission", "lvname", id)
+		}
+		if readonly != *change.readonly {
+			log.Printf("Changing volume id=%v to readonly=%v", id, *change.readonly)
+			if err := lv.SetReadonly(*change.readonly); err != nil {
+				return nil, s.lvmError(err, "Cannot change permission", "lvname", id)
+			}
+		}
+	}
+	s.backupMetadata(ctx, "ModifyVolume")
+	return &ModifyVolumeResponse{}, nil
+}
+
+// convertVolumeLayout converts the logical volume to the given raid1 layout
+// and records the new layout tag.
+func (s *Server) convertVolumeLayout(lv *lvm.LogicalVolume, layout lvm.VolumeLayout) error {
+	tags, err := lv.Tags()
+	if err != nil {
+		return s.lvmError(err, "Error in Tags()", "lvname", lv.Name())
+	}
+	newTag := layoutToTag(layout)
+	oldTag, ok := layoutTagFromTags(tags)
+	if ok && oldTag == newTag {
+		log.Printf("Volume id=%v already has layout %v", lv.Name(), newTag)
+		return nil
+	}
+	// The cache pool and the pv-tags and pvs restrictions would have to be
+	// honoured when allocating the new images.
+	if _, ok := cacheTagFromTags(tags); ok {
+		return statusError(codes.FailedPrecondition, s.errorInfo(ReasonInvalidParameters, "lvname", lv.Name()), "Cached volumes cannot be converted")
+	}
+	if isStripedLayoutTag(oldTag) {
+		return statusError(codes.FailedPrecondition, s.errorInfo(ReasonInvalidParameters, "lvname", lv.Name()), "Striped volumes cannot be converted")
+	}
+	if _, ok := pvTagsTagFromTags(tags); ok {
+		return statusError(codes.FailedPrecondition, s.errorInfo(ReasonInvalidParameters, "lvname", lv.Name()), "Volumes restricted by pv-tags cannot be converted")
+	}
+	if _, ok := pvsTagFromTags(tags); ok {
+		return statusError(codes.FailedPrecondition, s.errorInfo(ReasonInvalidParameters, "lvname", lv.Name()), "Volumes restricted by pvs cannot be converted")
+	}
+	mirrors := layout.Mirrors
+	if mirrors == 0 {
+		mirrors = 1
+	}
+	log.Printf("Converting volume id=%v from %v to %v", lv.Name(), oldTag, newTag)
+	if err := lv.ConvertToRAID1(mirrors); err != nil {
+		return s.lvmError(err, "Cannot convert volume", "lvname", lv.Name())
+	}
+	var removeTags []string
+	if ok {
+		removeTags = append(removeTags, oldTag)
+	}
+	if err := lv.ChangeTags([]string{newTag}, removeTags); err != nil {
+		return s.lvmError(err, "Cannot record volume layout", "lvname", lv.Name())
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+func TestTakeVolumeChangeFromParameters(t *testing.T) {
+	params := map[string]string{
+		"add-tags":    "a, b",
+		"remove-tags": "c",
+		"readonly":    "true",
+		"type":        "raid1",
+		"mirrors":     "2",
+		"unknown":     "x",
+	}
+	change, err := takeVolumeChangeFromParameters(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(change.addTags, []string{"a", "b"}) {
+		t.Fatalf("Unexpected tags to add %v", change.addTags)
+	}
+	if !reflect.DeepEqual(change.removeTags, []string{"c"}) {
+		t.Fatalf("Unexpected tags to remove %v", change.removeTags)
+	}
+	if change.readonly == nil || !*change.readonly {
+		t.Fatalf("Expected readonly to be set")
+	}
+	exp := lvm.VolumeLayout{Type: lvm.VolumeTypeRAID1, Mirrors: 2}
+	if change.layout == nil || *change.layout != exp {
+		t.Fatalf("Expected layout %v but got %v", exp, change.layout)
+	}
+	// Only the unknown parameter is left.
+	if !reflect.DeepEqual(params, map[string]string{"unknown": "x"}) {
+		t.Fatalf("Unexpected remaining parameters %v", params)
+	}
+}
+
+func TestTakeVolumeChangeFromParametersErrors(t *testing.T) {
+	cases := []map[string]string{
+		{"add-tags": "LY.raid1.1"},
+		{"remove-tags": "VN.name"},
+		{"add-tags": "a,,b"},
+		{"add-tags": "in valid"},
+		{"readonly": "maybe"},
+		{"type": "linear"},
+		{"type": "striped"},
+		{"mirrors": "2"},
+		{"type": "raid1", "mirrors": "0"},
+	}
+	for i, params := range cases {
+		if _, err := takeVolumeChangeFromParameters(params); err == nil {
+			t.Fatalf("test case %d: expected an error for %v", i, params)
+		}
+	}
+}
@@ -3,12 +3,162 @@ package csilvm
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
 )
 
+// modprobeTimeout bounds loading a kernel module.
+const modprobeTimeout = 30 * time.Second
+
+// procModules lists the loaded modules.
+var procModules = "/proc/modules"
+
+// sysModuleDir lists the modules known to the kernel, including those built
+// into it which /proc/modules omits.
+var sysModuleDir = "/sys/module"
+
+// LoadModules configures the server to load missing modules given by
+// ProbeModules with modprobe before reporting them missing.
+func LoadModules() ServerOpt {
+	return func(s *Server) {
+		s.loadModules = true
+	}
+}
+
+// MinKernelVersion configures the server to check that the running kernel
+// is at least the given version, e.g., "4.10", which is required by the
+// features the plugin is used with.
+func MinKernelVersion(version string) ServerOpt {
+	return func(s *Server) {
+		s.minKernelVersion = version
+	}
+}
+
+// ValidateKernelVersion returns an error if version cannot be used with
+// MinKernelVersion.
+func ValidateKernelVersion(version string) error {
+	_, err := parseKernelVersion(version)
+	return err
+}
+
+// missingModules returns the sorted modules given by ProbeModules that are
+// not loaded. If load is set, i.e., LoadModules is configured, the missing
+// modules are loaded with modprobe first.
+func (s *Server) missingModules(ctx context.Context, load bool) ([]string, error) {
+	if len(s.probeModules) == 0 {
+		return nil, nil
+	}
+	listed, err := listModules()
+	if err != nil {
+		return nil, err
+	}
+	mods := make(map[string]struct{})
+	for _, m := range listed {
+		mods[m] = struct{}{}
+	}
+	var missing []string
+	for m := range s.probeModules {
+		if _, found := mods[m]; found {
+			continue
+		}
+		if isBuiltinModule(m) {
+			continue
+		}
+		if load {
+			log.Printf("Loading kernel module %v", m)
+			if _, err := s.runner.Run(ctx, modprobeTimeout, "modprobe", m); err == nil {
+				continue
+			} else {
+				log.Printf("Cannot load kernel module %v: err=%v", m, err)
+			}
+		}
+		missing = append(missing, m)
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// isBuiltinModule returns whether the kernel knows the module even though
+// /proc/modules does not list it, i.e., it is built into the kernel.
+func isBuiltinModule(name string) bool {
+	_, err := os.Stat(filepath.Join(sysModuleDir, strings.Replace(name, "-", "_", -1)))
+	return err == nil
+}
+
+// modulesRemediation describes how to make the missing modules available.
+func modulesRemediation(missing []string) string {
+	return fmt.Sprintf("Load the modules on the host with 'modprobe %s' and list them in /etc/modules-load.d so that they are loaded at boot, or start the plugin with -load-modules.", strings.Join(missing, " "))
+}
+
+// parseKernelVersion returns the numeric components of a kernel release,
+// e.g., [4 15 0] for "4.15.0-1021-aws".
+func parseKernelVersion(release string) ([]int, error) {
+	numeric := release
+	if i := strings.IndexFunc(release, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); i >= 0 {
+		numeric = release[:i]
+	}
+	var version []int
+	for _, field := range strings.Split(numeric, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kernel version %q", release)
+		}
+		version = append(version, n)
+	}
+	return version, nil
+}
+
+// kernelVersionAtLeast returns whether the kernel release is the minimum
+// version or newer. Missing components count as zero.
+func kernelVersionAtLeast(release, minimum string) (bool, error) {
+	have, err := parseKernelVersion(release)
+	if err != nil {
+		return false, err
+	}
+	want, err := parseKernelVersion(minimum)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < len(have) || i < len(want); i++ {
+		var h, w int
+		if i < len(have) {
+			h = have[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		if h != w {
+			return h > w, nil
+		}
+	}
+	return true, nil
+}
+
+// checkKernelVersion returns the running kernel version and whether it
+// satisfies MinKernelVersion.
+func (s *Server) checkKernelVersion() (release string, ok bool, err error) {
+	if s.minKernelVersion == "" {
+		return "", true, nil
+	}
+	release, err = kernelVersion()
+	if err != nil {
+		return "", false, err
+	}
+	ok, err = kernelVersionAtLeast(release, s.minKernelVersion)
+	return release, ok, err
+}
+
 func listModules() ([]string, error) {
-	buf, err := ioutil.ReadFile("/proc/modules")
+	buf, err := ioutil.ReadFile(procModules)
 	if err != nil {
 		return nil, err
 	}
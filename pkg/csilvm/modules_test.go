@@ -1,9 +1,14 @@
 package csilvm
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+
+	"golang.org/x/net/context"
 )
 
 func TestParseModules(t *testing.T) {
@@ -56,3 +61,76 @@ floppy 73728`
 		t.Fatalf("expected %v instead of %v", expected, mods)
 	}
 }
+
+func TestKernelVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		release, minimum string
+		ok               bool
+	}{
+		{"4.15.0-1021-aws", "4.10", true},
+		{"4.10", "4.10", true},
+		{"4.9.120", "4.10", false},
+		{"3.10.0-957.el7.x86_64", "4.10", false},
+		{"5.0.0+", "4.10.1", true},
+		{"4.10.0", "4.10.1", false},
+	}
+	for _, tt := range cases {
+		ok, err := kernelVersionAtLeast(tt.release, tt.minimum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok {
+			t.Fatalf("expected %v >= %v to be %v", tt.release, tt.minimum, tt.ok)
+		}
+	}
+	if _, err := kernelVersionAtLeast("4.15", "four"); err == nil {
+		t.Fatal("expected an error for an invalid version")
+	}
+}
+
+func TestMissingModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-modules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(p, d string) { procModules, sysModuleDir = p, d }(procModules, sysModuleDir)
+	procModules = filepath.Join(dir, "modules")
+	if err := ioutil.WriteFile(procModules, []byte("csilvm_loaded 16384 0 - Live 0x0000000000000000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sysModuleDir = filepath.Join(dir, "sys")
+	if err := os.MkdirAll(filepath.Join(sysModuleDir, "csilvm_builtin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	probe := []string{"csilvm_loaded", "csilvm-builtin", "csilvm_missing"}
+	s := NewServer("vg", nil, "xfs", ProbeModules(probe))
+	missing, err := s.missingModules(context.Background(), s.loadModules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"csilvm_missing"}; !reflect.DeepEqual(missing, expected) {
+		t.Fatalf("expected %v instead of %v", expected, missing)
+	}
+	// With LoadModules, modprobe is run for the missing module.
+	if err := ioutil.WriteFile(filepath.Join(dir, "modprobe"), []byte("#!/bin/sh\necho \"$@\" >> "+filepath.Join(dir, "loaded")+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+	s = NewServer("vg", nil, "xfs", ProbeModules(probe), LoadModules())
+	missing, err = s.missingModules(context.Background(), s.loadModules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Fatalf("expected no missing modules instead of %v", missing)
+	}
+	loaded, err := ioutil.ReadFile(filepath.Join(dir, "loaded"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded) != "csilvm_missing\n" {
+		t.Fatalf("expected modprobe to load csilvm_missing instead of %q", loaded)
+	}
+}
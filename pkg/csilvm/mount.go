@@ -3,6 +3,8 @@ package csilvm
 import (
 	"errors"
 	"io/ioutil"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -31,11 +33,17 @@ This file contains lines of the form:
 */
 
 type mountpoint struct {
+	id       int
+	parentID int
+	// dev is the major:minor number of the filesystem, e.g., "253:4".
+	dev         string
 	root        string
 	path        string
 	fstype      string
 	mountopts   []string
+	optional    []string
 	mountsource string
+	superopts   []string
 }
 
 func (m *mountpoint) isReadonly() bool {
@@ -47,6 +55,28 @@ func (m *mountpoint) isReadonly() bool {
 	return false
 }
 
+// isSuperReadonly returns whether the filesystem itself, rather than only
+// this mount of it, is readonly.
+func (m *mountpoint) isSuperReadonly() bool {
+	for _, opt := range m.superopts {
+		if opt == "ro" {
+			return true
+		}
+	}
+	return false
+}
+
+// isShared returns whether mount and unmount events propagate between this
+// mount and its peers, e.g., because it was bind mounted with rshared.
+func (m *mountpoint) isShared() bool {
+	for _, field := range m.optional {
+		if strings.HasPrefix(field, "shared:") {
+			return true
+		}
+	}
+	return false
+}
+
 func listMounts() (mounts []mountpoint, err error) {
 	buf, err := ioutil.ReadFile("/proc/self/mountinfo")
 	if err != nil {
@@ -71,32 +101,67 @@ func parseMountinfo(buf []byte) (mounts []mountpoint, err error) {
 				break
 			}
 		}
-		if !foundSep {
+		if !foundSep || len(fields) < sepoffset+3 {
+			return nil, errors.New("Failed to parse /proc/mountinfo")
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, errors.New("Failed to parse /proc/mountinfo")
+		}
+		parentID, err := strconv.Atoi(fields[1])
+		if err != nil {
 			return nil, errors.New("Failed to parse /proc/mountinfo")
 		}
 		mount := mountpoint{
+			id:          id,
+			parentID:    parentID,
+			dev:         fields[2],
 			root:        fields[3],
 			path:        fields[4],
 			fstype:      fields[sepoffset+1],
 			mountopts:   strings.Split(fields[5], ","),
 			mountsource: fields[sepoffset+2],
 		}
+		if len(fields) > sepoffset+3 {
+			mount.superopts = strings.Split(fields[sepoffset+3], ",")
+		}
+		if sepoffset > 6 {
+			mount.optional = fields[6:sepoffset]
+		}
 		mounts = append(mounts, mount)
 	}
 	return mounts, nil
 }
 
-// getMountAt returns the first `mountpoint` that is mounted at the
-// given path.
+// getMountAt returns the `mountpoint` that is visible at the given path,
+// i.e., the topmost of the mounts at that path.
 func getMountAt(path string) (*mountpoint, error) {
 	mounts, err := getMountsAt(path)
 	if err != nil {
 		return nil, err
 	}
+	return topMount(mounts), nil
+}
+
+// topMount returns the mount that is not covered by any other of the given
+// mounts at the same path, or nil if there are none. A mount on top of
+// another mount at the same path has that mount as its parent. The
+// mountinfo order is not relied upon as mounts that propagate from a peer
+// may be listed before the mount they cover.
+func topMount(mounts []mountpoint) *mountpoint {
+	covered := make(map[int]bool)
 	for _, mp := range mounts {
-		return &mp, nil
+		if mp.parentID != mp.id {
+			covered[mp.parentID] = true
+		}
 	}
-	return nil, nil
+	var top *mountpoint
+	for i := range mounts {
+		if !covered[mounts[i].id] {
+			top = &mounts[i]
+		}
+	}
+	return top
 }
 
 // getMountsAt returns all `mountpoint` that are mounted at the given
@@ -114,3 +179,66 @@ func getMountsAt(path string) ([]mountpoint, error) {
 	}
 	return mps, nil
 }
+
+// blockDevice identifies a block device, e.g., a logical volume, by its
+// resolved path and its major:minor number as well as the path through
+// which it is used. The kernel reports the mount source as it was given to
+// mount(2) so the same logical volume may show up as /dev/<vg>/<lv>,
+// /dev/mapper/<vg>-<lv> or /dev/dm-N.
+type blockDevice struct {
+	path string
+	// resolved is path with symlinks followed, e.g., /dev/dm-4, or empty
+	// if the symlinks cannot be followed.
+	resolved string
+	// dev is the major:minor number of the device, or empty if it cannot
+	// be determined.
+	dev string
+}
+
+// lookupBlockDevice returns the blockDevice at path. The device's number
+// and resolved path are left empty if they cannot be determined, in which
+// case mounts are matched by path alone.
+func lookupBlockDevice(path string) blockDevice {
+	d := blockDevice{path: path}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		d.resolved = resolved
+	}
+	if dev, err := deviceNumber(path); err == nil {
+		d.dev = dev
+	} else {
+		log.Printf("Cannot determine the device number of %v, matching mounts by path: err=%v", path, err)
+	}
+	return d
+}
+
+// isMountedAt returns whether mp is a mount of a filesystem on the device.
+// The device number of a filesystem is not that of its device for some
+// filesystem types, e.g., btrfs, so the mount source is compared as well.
+func (d blockDevice) isMountedAt(mp *mountpoint) bool {
+	if mp.mountsource == d.path {
+		return true
+	}
+	if d.dev != "" && mp.dev == d.dev {
+		return true
+	}
+	if d.resolved == "" || !strings.HasPrefix(mp.mountsource, "/dev/") {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(mp.mountsource)
+	return err == nil && resolved == d.resolved
+}
+
+// isBoundAt returns whether mp is a bind mount of the device node itself,
+// as made when publishing a BLOCK_DEVICE volume. The root of such a mount
+// is the path of the device node within /dev, e.g., /dm-4.
+func (d blockDevice) isBoundAt(mp *mountpoint) bool {
+	node := "/dev" + mp.root
+	if node == d.path || node == d.resolved {
+		return true
+	}
+	if d.dev == "" || mp.root == "/" {
+		return false
+	}
+	dev, err := deviceNumber(node)
+	return err == nil && dev == d.dev
+}
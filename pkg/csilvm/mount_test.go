@@ -13,11 +13,16 @@ func TestParseMountinfoOptionalFields(t *testing.T) {
 	}
 	exp := []mountpoint{
 		{
+			id:          36,
+			parentID:    35,
+			dev:         "98:0",
 			root:        "/mnt1",
 			path:        "/mnt2",
 			fstype:      "ext3",
 			mountopts:   []string{"rw", "noatime"},
+			optional:    []string{"master:1"},
 			mountsource: "/dev/root",
+			superopts:   []string{"rw", "errors=continue"},
 		},
 	}
 	if !reflect.DeepEqual(mounts, exp) {
@@ -33,14 +38,97 @@ func TestParseMountinfoNoOptionalFields(t *testing.T) {
 	}
 	exp := []mountpoint{
 		{
+			id:          228,
+			parentID:    381,
+			dev:         "253:4",
 			root:        "/",
 			path:        "/mnt/volume-1",
 			fstype:      "xfs",
 			mountopts:   []string{"rw", "relatime"},
 			mountsource: "/mnt/volume-1",
+			superopts:   []string{"rw", "seclabel", "attr2", "inode64", "noquota"},
 		},
 	}
 	if !reflect.DeepEqual(mounts, exp) {
 		t.Fatalf("Expected %#v but got %#v", exp, mounts)
 	}
 }
+
+func TestParseMountinfoInvalidMountID(t *testing.T) {
+	buf := []byte("x 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue")
+	if _, err := parseMountinfo(buf); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestTopMount(t *testing.T) {
+	if mp := topMount(nil); mp != nil {
+		t.Fatalf("Expected no mount but got %+v", mp)
+	}
+	// The mount of id 40 is covered by 41, which in turn is covered by
+	// 42. A mount that propagated from a peer may be listed before the
+	// mount it covers.
+	mounts := []mountpoint{
+		{id: 42, parentID: 41, path: "/target", mountsource: "/dev/vg/lv3"},
+		{id: 40, parentID: 1, path: "/target", mountsource: "/dev/vg/lv1"},
+		{id: 41, parentID: 40, path: "/target", mountsource: "/dev/vg/lv2"},
+	}
+	mp := topMount(mounts)
+	if mp == nil || mp.id != 42 {
+		t.Fatalf("Expected mount 42 but got %+v", mp)
+	}
+}
+
+func TestBlockDeviceIsMountedAt(t *testing.T) {
+	d := blockDevice{path: "/dev/vg/lv1", resolved: "/dev/dm-3", dev: "253:3"}
+	testCases := []struct {
+		mp  mountpoint
+		exp bool
+	}{
+		{mountpoint{dev: "253:3", root: "/", mountsource: "/dev/vg/lv1"}, true},
+		// Mounted through the devicemapper name of the volume.
+		{mountpoint{dev: "253:3", root: "/", mountsource: "/dev/mapper/vg-lv1"}, true},
+		// btrfs reports an anonymous device number.
+		{mountpoint{dev: "0:52", root: "/", mountsource: "/dev/vg/lv1"}, true},
+		{mountpoint{dev: "253:4", root: "/", mountsource: "/dev/mapper/vg-lv2"}, false},
+		{mountpoint{dev: "0:5", root: "/dm-3", mountsource: "devtmpfs"}, false},
+	}
+	for i, tc := range testCases {
+		if got := d.isMountedAt(&tc.mp); got != tc.exp {
+			t.Errorf("%d: Expected %v for %+v but got %v", i, tc.exp, tc.mp, got)
+		}
+	}
+}
+
+func TestBlockDeviceIsBoundAt(t *testing.T) {
+	d := blockDevice{path: "/dev/vg/lv1", resolved: "/dev/dm-3"}
+	if !d.isBoundAt(&mountpoint{root: "/dm-3", mountsource: "devtmpfs"}) {
+		t.Fatal("Expected the bind mount of /dev/dm-3 to match")
+	}
+	if d.isBoundAt(&mountpoint{root: "/dm-4", mountsource: "devtmpfs"}) {
+		t.Fatal("Expected the bind mount of /dev/dm-4 not to match")
+	}
+	if d.isBoundAt(&mountpoint{root: "/", mountsource: "/dev/vg/lv1"}) {
+		t.Fatal("Expected a filesystem mount not to match")
+	}
+}
+
+func TestVolumeMountPaths(t *testing.T) {
+	mounts := []mountpoint{
+		{root: "/", path: "/other", mountsource: "/dev/sda1"},
+		{root: "/", path: "/target-1", mountsource: "/dev/dm-3"},
+		{root: "/", path: "/target-2", mountsource: "/dev/vg/lv1"},
+		// The bind mount of a BLOCK_DEVICE volume.
+		{root: "/dm-4", path: "/target-3", mountsource: "devtmpfs"},
+	}
+	sources := map[string]string{
+		"/dev/vg/lv1": "lv1",
+		"/dev/dm-3":   "lv1",
+		"/dev/vg/lv2": "lv2",
+		"/dev/dm-4":   "lv2",
+	}
+	exp := map[string]string{"lv1": "/target-1"}
+	if got := volumeMountPaths(mounts, sources); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected %v but got %v", exp, got)
+	}
+}
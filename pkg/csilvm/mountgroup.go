@@ -0,0 +1,66 @@
+package csilvm
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/golang/protobuf/proto"
+)
+
+// nodeCapabilityVolumeMountGroup is the VOLUME_MOUNT_GROUP node capability
+// of the CSI spec v1.5. The vendored spec predates it so it is declared
+// here with its wire value.
+const nodeCapabilityVolumeMountGroup csi.NodeServiceCapability_RPC_Type = 6
+
+// mountVolumeGroup is wire-compatible with the volume_mount_group field of
+// the MountVolume message of the CSI spec v1.5. The vendored spec predates
+// that field so it arrives as an unrecognized field that we decode with
+// our own definition.
+type mountVolumeGroup struct {
+	VolumeMountGroup     string   `protobuf:"bytes,3,opt,name=volume_mount_group,json=volumeMountGroup,proto3" json:"volume_mount_group,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *mountVolumeGroup) Reset()         { *m = mountVolumeGroup{} }
+func (m *mountVolumeGroup) String() string { return proto.CompactTextString(m) }
+func (*mountVolumeGroup) ProtoMessage()    {}
+
+// volumeMountGroup returns the group id that the CO requested to own the
+// published filesystem, if any.
+func volumeMountGroup(mnt *csi.VolumeCapability_MountVolume) (gid int, ok bool, err error) {
+	if len(mnt.XXX_unrecognized) == 0 {
+		return 0, false, nil
+	}
+	var m mountVolumeGroup
+	if err := proto.Unmarshal(mnt.XXX_unrecognized, &m); err != nil {
+		return 0, false, fmt.Errorf("cannot decode volume_mount_group: %v", err)
+	}
+	if m.VolumeMountGroup == "" {
+		return 0, false, nil
+	}
+	gid, err = strconv.Atoi(m.VolumeMountGroup)
+	if err != nil || gid < 0 {
+		return 0, false, fmt.Errorf("The volume_mount_group %q is not a numeric group id", m.VolumeMountGroup)
+	}
+	return gid, true, nil
+}
+
+// applyVolumeMountGroup gives the group gid ownership of the root of the
+// filesystem mounted at targetPath, the same way the kubelet applies a
+// pod's fsGroup: the group can read, write and traverse the root directory
+// and, with the setgid bit, new files inherit the group.
+func applyVolumeMountGroup(targetPath string, gid int) error {
+	if err := os.Lchown(targetPath, -1, gid); err != nil {
+		return err
+	}
+	info, err := os.Stat(targetPath)
+	if err != nil {
+		return err
+	}
+	mode := info.Mode()&(os.ModePerm|os.ModeSticky) | 0070 | os.ModeSetgid
+	return os.Chmod(targetPath, mode)
+}
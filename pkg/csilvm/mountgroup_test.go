@@ -0,0 +1,67 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/golang/protobuf/proto"
+)
+
+// testMountVolume returns a MountVolume whose volume_mount_group field, as
+// sent by a CO that implements CSI spec v1.5, is set to group.
+func testMountVolume(t *testing.T, group string) *csi.VolumeCapability_MountVolume {
+	raw, err := proto.Marshal(&mountVolumeGroup{VolumeMountGroup: group})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := proto.Marshal(&csi.VolumeCapability_MountVolume{FsType: "xfs", XXX_unrecognized: raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt := new(csi.VolumeCapability_MountVolume)
+	if err := proto.Unmarshal(buf, mnt); err != nil {
+		t.Fatal(err)
+	}
+	return mnt
+}
+
+func TestVolumeMountGroup(t *testing.T) {
+	gid, ok, err := volumeMountGroup(testMountVolume(t, "1234"))
+	if err != nil || !ok || gid != 1234 {
+		t.Fatalf("expected group 1234 instead of (%v, %v, %v)", gid, ok, err)
+	}
+	if _, ok, err := volumeMountGroup(&csi.VolumeCapability_MountVolume{FsType: "xfs"}); ok || err != nil {
+		t.Fatalf("expected no group instead of (%v, %v)", ok, err)
+	}
+	if _, _, err := volumeMountGroup(testMountVolume(t, "users")); err == nil {
+		t.Fatal("expected an error for a non-numeric group")
+	}
+}
+
+func TestApplyVolumeMountGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-mountgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	gid := os.Getgid()
+	if err := applyVolumeMountGroup(dir, gid); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode, expected := info.Mode()&(os.ModePerm|os.ModeSetgid), 0770|os.ModeSetgid; mode != expected {
+		t.Fatalf("expected mode %v instead of %v", expected, mode)
+	}
+	if _, got, ok := fileOwner(info); !ok || got != gid {
+		t.Fatalf("expected group %v instead of %v", strconv.Itoa(gid), got)
+	}
+}
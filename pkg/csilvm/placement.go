@@ -0,0 +1,81 @@
+package csilvm
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+const (
+	// paramPVs is the CreateVolume parameter that pins allocation to the
+	// given comma-separated physical volumes, e.g., "/dev/sdb,/dev/sdc",
+	// to keep workloads on separate disks.
+	paramPVs = "pvs"
+	// tagPVsPrefix prefixes the logical volume tag that records the
+	// sorted pvs, base64 encoded as tags cannot contain commas or
+	// slashes.
+	tagPVsPrefix = "PN."
+)
+
+// takePVsFromParameters consumes the pvs parameter and returns the sorted
+// device paths.
+func takePVsFromParameters(params map[string]string) ([]string, error) {
+	value, ok := params[paramPVs]
+	if !ok {
+		return nil, nil
+	}
+	delete(params, paramPVs)
+	var pvnames []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !filepath.IsAbs(name) || filepath.Clean(name) != name {
+			return nil, fmt.Errorf("The '%s' parameter must be a comma-separated list of device paths: got %q", paramPVs, name)
+		}
+		if !containsString(pvnames, name) {
+			pvnames = append(pvnames, name)
+		}
+	}
+	sort.Strings(pvnames)
+	return pvnames, nil
+}
+
+// pvsToTag returns the tag that records the given sorted pvs.
+func pvsToTag(pvnames []string) string {
+	return tagPVsPrefix + base64.RawURLEncoding.EncodeToString([]byte(strings.Join(pvnames, ",")))
+}
+
+// pvsTagFromTags returns the pvs tag among the given tags, if any.
+func pvsTagFromTags(tags []string) (string, bool) {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, tagPVsPrefix) {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// checkPVNames checks that the given names are those of physical volumes
+// whose devices LVM can find.
+func checkPVNames(pvs []lvm.PhysicalVolumeReport, pvnames []string) error {
+	for _, name := range pvnames {
+		if len(withPVNames(pvs, []string{name})) == 0 {
+			return fmt.Errorf("The device %v given by the '%s' parameter is not a physical volume of the volume group", name, paramPVs)
+		}
+	}
+	return nil
+}
+
+// withPVNames returns the physical volumes with the given names.
+func withPVNames(pvs []lvm.PhysicalVolumeReport, pvnames []string) []lvm.PhysicalVolumeReport {
+	var result []lvm.PhysicalVolumeReport
+	for _, pv := range pvs {
+		if containsString(pvnames, pv.Name) {
+			result = append(result, pv)
+		}
+	}
+	return result
+}
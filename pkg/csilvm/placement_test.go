@@ -0,0 +1,67 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+func TestTakePVsFromParameters(t *testing.T) {
+	for _, tt := range []struct {
+		params  map[string]string
+		pvnames []string
+		err     bool
+	}{
+		{nil, nil, false},
+		{map[string]string{"pvs": "/dev/sdb"}, []string{"/dev/sdb"}, false},
+		{map[string]string{"pvs": "/dev/sdc, /dev/sdb,/dev/sdc"}, []string{"/dev/sdb", "/dev/sdc"}, false},
+		{map[string]string{"pvs": ""}, nil, true},
+		{map[string]string{"pvs": "/dev/sdb,,/dev/sdc"}, nil, true},
+		{map[string]string{"pvs": "sdb"}, nil, true},
+		{map[string]string{"pvs": "/dev/../dev/sdb"}, nil, true},
+	} {
+		params := dupParams(tt.params)
+		pvnames, err := takePVsFromParameters(params)
+		if (err != nil) != tt.err || !reflect.DeepEqual(pvnames, tt.pvnames) {
+			t.Fatalf("%v: expected %v and error %v, got %v and %v", tt.params, tt.pvnames, tt.err, pvnames, err)
+		}
+		if len(params) != 0 {
+			t.Fatalf("%v: expected the pvs parameter to be consumed, got %v", tt.params, params)
+		}
+	}
+}
+
+func TestPVsTag(t *testing.T) {
+	tag := pvsToTag([]string{"/dev/sdb", "/dev/sdc"})
+	if err := lvm.ValidateTag(tag); err != nil {
+		t.Fatalf("expected a valid tag, got %v: %v", tag, err)
+	}
+	if tag == pvsToTag([]string{"/dev/sdb"}) {
+		t.Fatalf("expected different pvs to have different tags")
+	}
+	if got, ok := pvsTagFromTags([]string{"VN.name", pvTagsToTag([]string{"ssd"}), tag}); !ok || got != tag {
+		t.Fatalf("expected %v, got %v", tag, got)
+	}
+	if got, ok := pvsTagFromTags([]string{"VN.name", "LY.linear"}); ok {
+		t.Fatalf("expected no pvs tag, got %v", got)
+	}
+}
+
+func TestWithPVNames(t *testing.T) {
+	pvs := []lvm.PhysicalVolumeReport{
+		{Name: "/dev/a"},
+		{Name: "/dev/b"},
+		{Name: "/dev/c"},
+	}
+	if err := checkPVNames(pvs, []string{"/dev/a", "/dev/c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPVNames(pvs, []string{"/dev/a", "/dev/d"}); err == nil {
+		t.Fatal("expected an error for a device that is not a physical volume")
+	}
+	got := withPVNames(pvs[1:], []string{"/dev/a", "/dev/c"})
+	if exp := pvs[2:]; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
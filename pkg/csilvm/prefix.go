@@ -0,0 +1,161 @@
+package csilvm
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+)
+
+// volumePrefixSeparator separates the volume prefix from the rest of the
+// volume id. Prefixes cannot contain it so that the volume ids of one
+// prefix never start with another prefix.
+const volumePrefixSeparator = "_"
+
+// maxVolumePrefixLength leaves room for the generated part of the volume
+// id within LVM's limit of 127 characters.
+const maxVolumePrefixLength = 64
+
+var volumePrefixRegexp = regexp.MustCompile("^[A-Za-z0-9+.][A-Za-z0-9+.-]*$")
+
+var ErrInvalidVolumePrefix = errors.New("The volume prefix must be at most 64 characters from [A-Za-z0-9+.-], must not contain '_' and must not start with '-'")
+
+// ValidateVolumePrefix validates a prefix given to VolumePrefix.
+func ValidateVolumePrefix(prefix string) error {
+	if len(prefix) > maxVolumePrefixLength || !volumePrefixRegexp.MatchString(prefix) {
+		return ErrInvalidVolumePrefix
+	}
+	return nil
+}
+
+// VolumePrefix configures the server to prefix the ids of the volumes it
+// creates with the given prefix and an underscore, e.g., "tenantA_csilv...",
+// and to ignore all logical volumes without that prefix. They are not
+// listed and RPCs report them as not found. This allows several plugin
+// instances, e.g., of different tenants, to safely share a volume group.
+// The prefix is validated by Setup.
+func VolumePrefix(prefix string) ServerOpt {
+	return func(s *Server) {
+		s.volumePrefix = prefix
+	}
+}
+
+// volumeIDPrefix returns the prefix of the ids of the volumes managed by
+// the server.
+func (s *Server) volumeIDPrefix() string {
+	if s.volumePrefix == "" {
+		return ""
+	}
+	return s.volumePrefix + volumePrefixSeparator
+}
+
+// maxLVPrefixLength bounds the logical volume prefix so that it leaves
+// room for the volume prefix and the generated part of the volume id.
+const maxLVPrefixLength = 32
+
+var ErrInvalidLVPrefix = errors.New("The logical volume prefix must be at most 32 characters from [A-Za-z0-9+.-], must not contain '_' and must not start with '-' or a prefix reserved by LVM")
+
+// ValidateLVPrefix validates a prefix given to LVPrefix. Unlike a volume
+// prefix it may end in '-', e.g., "csi-". It cannot contain '_' so that
+// the logical volumes of an instance without a volume prefix never appear
+// to belong to an instance with one.
+func ValidateLVPrefix(prefix string) error {
+	if len(prefix) > maxLVPrefixLength || !volumePrefixRegexp.MatchString(prefix) {
+		return ErrInvalidLVPrefix
+	}
+	for _, reserved := range reservedVolumeNamePrefixes {
+		if strings.HasPrefix(prefix, reserved) {
+			return ErrInvalidLVPrefix
+		}
+	}
+	return nil
+}
+
+// LVPrefix configures the server to prefix the names of the logical volumes
+// it creates with the given prefix, e.g., "csi-csilv...", so that host
+// administrators can tell which logical volumes are managed by the plugin
+// when running `lvs`. As volume ids are logical volume names, the ids of
+// new volumes carry the prefix too. Unlike VolumePrefix it does not change
+// which volumes the server manages, so volumes created without it, or with
+// another prefix, keep working. The CO-specified name is still recorded in
+// a tag. The prefix is validated by Setup.
+func LVPrefix(prefix string) ServerOpt {
+	return func(s *Server) {
+		s.lvPrefix = prefix
+	}
+}
+
+// newVolumeIDPrefix returns the prefix of the ids of the volumes created by
+// the server.
+func (s *Server) newVolumeIDPrefix() string {
+	return s.volumeIDPrefix() + s.lvPrefix
+}
+
+// nodeVolumeIDSeparator separates the node id from the logical volume name
+// in namespaced volume ids. Logical volume names cannot contain it.
+const nodeVolumeIDSeparator = ":"
+
+// NodeVolumeIDs configures the server to report volume ids that are
+// prefixed with the node id and a colon, e.g., "node1:csilv...", so that
+// the volumes of volume groups with the same name on many nodes do not have
+// the same ids in the CO's database. The node id is stripped from the volume
+// ids of requests. Volume ids without it, e.g., those reported before the
+// option was set, are still accepted. Setup fails if no node id is set.
+func NodeVolumeIDs() ServerOpt {
+	return func(s *Server) {
+		s.nodeVolumeIDs = true
+	}
+}
+
+// volumeIDFromName returns the volume id reported for the logical volume
+// with the given name.
+func (s *Server) volumeIDFromName(name string) string {
+	if !s.nodeVolumeIDs {
+		return name
+	}
+	return s.nodeID + nodeVolumeIDSeparator + name
+}
+
+// nameFromVolumeID returns the name of the logical volume with the given
+// volume id. The volume ids of other nodes are returned unchanged and are
+// not owned by this server as they contain the separator.
+func (s *Server) nameFromVolumeID(id string) string {
+	if !s.nodeVolumeIDs {
+		return id
+	}
+	return strings.TrimPrefix(id, s.nodeID+nodeVolumeIDSeparator)
+}
+
+// ownsVolume returns whether the logical volume with the given name is
+// managed by this server. Volumes in the trash are not, see TrashRetention.
+// Without a volume prefix, volumes whose name contains the separator belong
+// to the instances with a prefix and are not managed either.
+func (s *Server) ownsVolume(name string) bool {
+	if s.volumePrefix == "" && strings.Contains(name, volumePrefixSeparator) {
+		return false
+	}
+	return strings.HasPrefix(name, s.volumeIDPrefix()) && !isTrashed(name) && !strings.Contains(name, nodeVolumeIDSeparator)
+}
+
+// lookupVolume looks up the logical volume with the given id. It returns
+// lvm.ErrLogicalVolumeNotFound if the volume is not managed by this server.
+func (s *Server) lookupVolume(ctx context.Context, id string) (*lvm.LogicalVolume, error) {
+	if !s.ownsVolume(id) {
+		log.Printf("Ignoring volume id=%v without the prefix %q", id, s.volumeIDPrefix())
+		return nil, lvm.ErrLogicalVolumeNotFound
+	}
+	return s.volumeGroup.WithContext(ctx).LookupLogicalVolume(id)
+}
+
+// ownedVolumes returns the logical volumes that are managed by this server.
+func (s *Server) ownedVolumes(lvs []lvm.LogicalVolumeReport) []lvm.LogicalVolumeReport {
+	var owned []lvm.LogicalVolumeReport
+	for _, lv := range lvs {
+		if s.ownsVolume(lv.Name) {
+			owned = append(owned, lv)
+		}
+	}
+	return owned
+}
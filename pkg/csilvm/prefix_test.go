@@ -0,0 +1,102 @@
+package csilvm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+)
+
+func TestValidateVolumePrefix(t *testing.T) {
+	for _, prefix := range []string{"tenantA", "a", "team.1", "a-b+c"} {
+		if err := ValidateVolumePrefix(prefix); err != nil {
+			t.Fatalf("Expected %q to be valid: %v", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"", "tenant_A", "-tenant", "tenant A", strings.Repeat("a", maxVolumePrefixLength+1)} {
+		if err := ValidateVolumePrefix(prefix); err != ErrInvalidVolumePrefix {
+			t.Fatalf("Expected %q to be invalid but got %v", prefix, err)
+		}
+	}
+}
+
+func TestValidateLVPrefix(t *testing.T) {
+	for _, prefix := range []string{"csi-", "csi", "k8s.io-", "a"} {
+		if err := ValidateLVPrefix(prefix); err != nil {
+			t.Fatalf("Expected %q to be valid: %v", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"", "csi_", "-csi", "csi lvm", "snapshot-", "pvmove-", strings.Repeat("a", maxLVPrefixLength+1)} {
+		if err := ValidateLVPrefix(prefix); err != ErrInvalidLVPrefix {
+			t.Fatalf("Expected %q to be invalid but got %v", prefix, err)
+		}
+	}
+}
+
+func TestLVPrefix(t *testing.T) {
+	s := &Server{volumePrefix: "tenantA", lvPrefix: "csi-"}
+	if got, exp := s.newVolumeIDPrefix(), "tenantA_csi-"; got != exp {
+		t.Fatalf("Expected %q but got %q", exp, got)
+	}
+	// Volumes created without the logical volume prefix are still
+	// managed.
+	lvs := []lvm.LogicalVolumeReport{{Name: "tenantA_csilv1"}, {Name: "tenantA_csi-csilv2"}}
+	if got := s.ownedVolumes(lvs); !reflect.DeepEqual(got, lvs) {
+		t.Fatalf("Expected %v but got %v", lvs, got)
+	}
+}
+
+func TestOwnedVolumes(t *testing.T) {
+	lvs := []lvm.LogicalVolumeReport{
+		{Name: "csilv1"},
+		{Name: "tenantA_csilv2"},
+		{Name: "tenantAB_csilv3"},
+		{Name: "tenantB_csilv4"},
+	}
+	// Without a prefix, the volumes of the instances with a prefix are
+	// not owned.
+	s := &Server{}
+	exp := []lvm.LogicalVolumeReport{{Name: "csilv1"}}
+	if got := s.ownedVolumes(lvs); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected %v without a prefix but got %v", exp, got)
+	}
+	if _, err := s.lookupVolume(context.Background(), "tenantA_csilv2"); err != lvm.ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected %v but got %v", lvm.ErrLogicalVolumeNotFound, err)
+	}
+	s = &Server{volumePrefix: "tenantA"}
+	exp = []lvm.LogicalVolumeReport{{Name: "tenantA_csilv2"}}
+	if got := s.ownedVolumes(lvs); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected %v but got %v", exp, got)
+	}
+	// Volumes of other prefixes are not looked up.
+	if _, err := s.lookupVolume(context.Background(), "tenantB_csilv4"); err != lvm.ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected %v but got %v", lvm.ErrLogicalVolumeNotFound, err)
+	}
+}
+
+func TestNodeVolumeIDs(t *testing.T) {
+	s := &Server{nodeID: "node1"}
+	if got := s.volumeIDFromName("csilv1"); got != "csilv1" {
+		t.Fatalf("Expected an unchanged id but got %v", got)
+	}
+	if got := s.nameFromVolumeID("node1:csilv1"); got != "node1:csilv1" {
+		t.Fatalf("Expected an unchanged name but got %v", got)
+	}
+	s = &Server{nodeID: "node1", nodeVolumeIDs: true}
+	id := s.volumeIDFromName("csilv1")
+	if id != "node1:csilv1" {
+		t.Fatalf("Expected node1:csilv1 but got %v", id)
+	}
+	for _, id := range []string{id, "csilv1"} {
+		if got := s.nameFromVolumeID(id); got != "csilv1" {
+			t.Fatalf("Expected csilv1 for %v but got %v", id, got)
+		}
+	}
+	// The volumes of other nodes are not looked up.
+	name := s.nameFromVolumeID("node2:csilv1")
+	if _, err := s.lookupVolume(context.Background(), name); err != lvm.ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected %v but got %v", lvm.ErrLogicalVolumeNotFound, err)
+	}
+}
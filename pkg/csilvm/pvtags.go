@@ -0,0 +1,104 @@
+package csilvm
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+)
+
+const (
+	// paramPVTags is the CreateVolume and GetCapacity parameter that
+	// restricts allocation to the physical volumes that carry all of
+	// the given comma-separated LVM tags, e.g., "ssd".
+	paramPVTags = "pv-tags"
+	// tagPVTagsPrefix prefixes the logical volume tag that records the
+	// sorted pv-tags, base64 encoded as tags cannot contain commas.
+	tagPVTagsPrefix = "PT."
+)
+
+// takePVTagsFromParameters consumes the pv-tags parameter and returns the
+// sorted tags.
+func takePVTagsFromParameters(params map[string]string) ([]string, error) {
+	value, ok := params[paramPVTags]
+	if !ok {
+		return nil, nil
+	}
+	delete(params, paramPVTags)
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if err := lvm.ValidateTag(tag); err != nil {
+			return nil, fmt.Errorf("The '%s' parameter must be a comma-separated list of tags: err=%v", paramPVTags, err)
+		}
+		if !containsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// pvTagsToTag returns the tag that records the given sorted pv-tags.
+func pvTagsToTag(pvTags []string) string {
+	return tagPVTagsPrefix + base64.RawURLEncoding.EncodeToString([]byte(strings.Join(pvTags, ",")))
+}
+
+// pvTagsTagFromTags returns the pv-tags tag among the given tags, if any.
+func pvTagsTagFromTags(tags []string) (string, bool) {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, tagPVTagsPrefix) {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// withPVTags returns the physical volumes that carry all of the given tags.
+func withPVTags(pvs []lvm.PhysicalVolumeReport, pvTags []string) []lvm.PhysicalVolumeReport {
+	var result []lvm.PhysicalVolumeReport
+	for _, pv := range pvs {
+		ok := true
+		for _, tag := range pvTags {
+			if !containsString(pv.Tags, tag) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			result = append(result, pv)
+		}
+	}
+	return result
+}
+
+// presentPhysicalVolumes returns the physical volumes whose devices LVM
+// can find.
+func presentPhysicalVolumes(pvs []lvm.PhysicalVolumeReport) []lvm.PhysicalVolumeReport {
+	var result []lvm.PhysicalVolumeReport
+	for _, pv := range pvs {
+		if !pv.Missing {
+			result = append(result, pv)
+		}
+	}
+	return result
+}
+
+// bytesFreeWithPVTags returns the unallocated space in bytes for logical
+// volumes with the given layout on the physical volumes with all of the
+// given tags.
+func (s *Server) bytesFreeWithPVTags(ctx context.Context, layout lvm.VolumeLayout, pvTags []string) (uint64, error) {
+	vg := s.volumeGroup.WithContext(ctx)
+	extentSize, err := vg.ExtentSize()
+	if err != nil {
+		return 0, err
+	}
+	pvs, err := vg.ReportPhysicalVolumes()
+	if err != nil {
+		return 0, err
+	}
+	return layout.BytesFreeOn(withPVTags(presentPhysicalVolumes(pvs), pvTags), extentSize), nil
+}
@@ -0,0 +1,79 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+func TestTakePVTagsFromParameters(t *testing.T) {
+	for _, tt := range []struct {
+		params map[string]string
+		tags   []string
+		err    bool
+	}{
+		{nil, nil, false},
+		{map[string]string{"pv-tags": "ssd"}, []string{"ssd"}, false},
+		{map[string]string{"pv-tags": "ssd, rack1,ssd"}, []string{"rack1", "ssd"}, false},
+		{map[string]string{"pv-tags": ""}, nil, true},
+		{map[string]string{"pv-tags": "ssd,,rack1"}, nil, true},
+		{map[string]string{"pv-tags": "s$d"}, nil, true},
+	} {
+		params := dupParams(tt.params)
+		tags, err := takePVTagsFromParameters(params)
+		if (err != nil) != tt.err || !reflect.DeepEqual(tags, tt.tags) {
+			t.Fatalf("%v: expected %v and error %v, got %v and %v", tt.params, tt.tags, tt.err, tags, err)
+		}
+		if len(params) != 0 {
+			t.Fatalf("%v: expected the pv-tags parameter to be consumed, got %v", tt.params, params)
+		}
+	}
+}
+
+func TestPVTagsTag(t *testing.T) {
+	tag := pvTagsToTag([]string{"rack1", "ssd"})
+	if err := lvm.ValidateTag(tag); err != nil {
+		t.Fatalf("expected a valid tag, got %v: %v", tag, err)
+	}
+	if tag == pvTagsToTag([]string{"rack1ssd"}) {
+		t.Fatalf("expected different pv-tags to have different tags")
+	}
+	if got, ok := pvTagsTagFromTags([]string{"VN.name", tag, "LY.linear"}); !ok || got != tag {
+		t.Fatalf("expected %v, got %v", tag, got)
+	}
+	if got, ok := pvTagsTagFromTags([]string{"VN.name", "LY.linear"}); ok {
+		t.Fatalf("expected no pv-tags tag, got %v", got)
+	}
+}
+
+func TestWithPVTags(t *testing.T) {
+	pvs := []lvm.PhysicalVolumeReport{
+		{Name: "/dev/a", Tags: []string{"ssd", "rack1"}},
+		{Name: "/dev/b", Tags: []string{"ssd"}},
+		{Name: "/dev/c", Tags: []string{"hdd"}, Missing: true},
+	}
+	names := func(pvs []lvm.PhysicalVolumeReport) []string {
+		var names []string
+		for _, pv := range pvs {
+			names = append(names, pv.Name)
+		}
+		return names
+	}
+	for _, tt := range []struct {
+		tags  []string
+		names []string
+	}{
+		{[]string{"ssd"}, []string{"/dev/a", "/dev/b"}},
+		{[]string{"rack1", "ssd"}, []string{"/dev/a"}},
+		{[]string{"hdd"}, []string{"/dev/c"}},
+		{[]string{"nvme"}, nil},
+	} {
+		if got := names(withPVTags(pvs, tt.tags)); !reflect.DeepEqual(got, tt.names) {
+			t.Fatalf("%v: expected %v, got %v", tt.tags, tt.names, got)
+		}
+	}
+	if got := names(presentPhysicalVolumes(pvs)); !reflect.DeepEqual(got, []string{"/dev/a", "/dev/b"}) {
+		t.Fatalf("expected the missing physical volume to be skipped, got %v", got)
+	}
+}
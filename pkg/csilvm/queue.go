@@ -0,0 +1,154 @@
+package csilvm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// paramReadahead is the CreateVolume parameter that sets the
+	// readahead of the volume's device in KiB.
+	paramReadahead = "readahead"
+	// paramScheduler is the CreateVolume parameter that sets the I/O
+	// scheduler of the volume's device, see supportedSchedulers.
+	paramScheduler = "scheduler"
+	// tagReadaheadPrefix prefixes the logical volume tag that records
+	// the readahead, e.g., "RA.1024".
+	tagReadaheadPrefix = "RA."
+	// tagSchedulerPrefix prefixes the logical volume tag that records
+	// the I/O scheduler, e.g., "IS.none".
+	tagSchedulerPrefix = "IS."
+)
+
+// sysBlockDir is where the kernel exposes the queue settings of block
+// devices.
+const sysBlockDir = "/sys/block"
+
+// supportedSchedulers are the I/O schedulers that the scheduler parameter
+// accepts. Logical volumes are bio-based device-mapper devices that only
+// offer "none", so accepting others, e.g., "mq-deadline", would only make
+// NodePublishVolume fail.
+var supportedSchedulers = []string{"none"}
+
+// queueTuning is the block-layer tuning of the device of a volume. The
+// settings are not persistent so they are recorded as volume tags and
+// applied each time the volume is published. Empty fields are left as is.
+type queueTuning struct {
+	// readahead is the readahead in KiB.
+	readahead string
+	scheduler string
+}
+
+// takeQueueTuningFromParameters consumes the readahead and scheduler
+// parameters and returns the requested tuning.
+func takeQueueTuningFromParameters(params map[string]string) (q queueTuning, err error) {
+	if value, ok := params[paramReadahead]; ok {
+		delete(params, paramReadahead)
+		kb, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return q, fmt.Errorf("The '%s' parameter must be a number of KiB: err=%v", paramReadahead, err)
+		}
+		q.readahead = strconv.FormatUint(kb, 10)
+	}
+	if value, ok := params[paramScheduler]; ok {
+		delete(params, paramScheduler)
+		if !containsString(supportedSchedulers, value) {
+			return q, fmt.Errorf("The '%s' parameter must be one of %v", paramScheduler, supportedSchedulers)
+		}
+		q.scheduler = value
+	}
+	return q, nil
+}
+
+// tags returns the tags that record the tuning.
+func (q queueTuning) tags() []string {
+	var tags []string
+	if q.readahead != "" {
+		tags = append(tags, tagReadaheadPrefix+q.readahead)
+	}
+	if q.scheduler != "" {
+		tags = append(tags, tagSchedulerPrefix+q.scheduler)
+	}
+	return tags
+}
+
+// queueTuningFromTags returns the tuning recorded by the given tags.
+func queueTuningFromTags(tags []string) queueTuning {
+	var q queueTuning
+	for _, tag := range tags {
+		switch {
+		case strings.HasPrefix(tag, tagReadaheadPrefix):
+			q.readahead = strings.TrimPrefix(tag, tagReadaheadPrefix)
+		case strings.HasPrefix(tag, tagSchedulerPrefix):
+			q.scheduler = strings.TrimPrefix(tag, tagSchedulerPrefix)
+		}
+	}
+	return q
+}
+
+// tuneQueue applies the tuning recorded in the tags of the logical volume
+// to its device at sourcePath.
+func (s *Server) tuneQueue(lv *lvm.LogicalVolume, sourcePath string) error {
+	tags, err := lv.CachedTags()
+	if err != nil {
+		return s.lvmError(err, "Error in CachedTags()", "lvname", lv.Name())
+	}
+	q := queueTuningFromTags(tags)
+	if q == (queueTuning{}) {
+		return nil
+	}
+	// The volume path is a symlink to the device-mapper device, e.g.,
+	// /dev/dm-3, whose queue is at /sys/block/dm-3/queue.
+	dev, err := filepath.EvalSymlinks(sourcePath)
+	if err != nil {
+		return statusErrorf(
+			codes.Internal,
+			s.errorInfo(ReasonDeviceMissing, "device", sourcePath),
+			"Failed to follow symlinks at %v: err=%v",
+			sourcePath, err)
+	}
+	log.Printf("Tuning queue of %v: readahead=%q scheduler=%q", dev, q.readahead, q.scheduler)
+	if err := applyQueueTuning(sysBlockDir, filepath.Base(dev), q); err != nil {
+		return statusErrorf(
+			codes.FailedPrecondition,
+			s.errorInfo(ReasonQueueTuningFailed, "lvname", lv.Name(), "device", dev),
+			"Cannot tune block device queue: err=%v",
+			err)
+	}
+	return nil
+}
+
+// applyQueueTuning writes the tuning to the queue settings of the named
+// block device beneath dir.
+func applyQueueTuning(dir, device string, q queueTuning) error {
+	queue := filepath.Join(dir, device, "queue")
+	if q.readahead != "" {
+		if err := ioutil.WriteFile(filepath.Join(queue, "read_ahead_kb"), []byte(q.readahead), 0644); err != nil {
+			return err
+		}
+	}
+	if q.scheduler != "" {
+		path := filepath.Join(queue, "scheduler")
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// The file lists the available schedulers with the active
+		// one in brackets, e.g., "[mq-deadline] none". Bio-based
+		// device-mapper devices only offer "none".
+		available := strings.Fields(strings.NewReplacer("[", "", "]", "").Replace(string(buf)))
+		if !containsString(available, q.scheduler) {
+			return fmt.Errorf("the device does not offer the %v scheduler, only %v", q.scheduler, available)
+		}
+		if err := ioutil.WriteFile(path, []byte(q.scheduler), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+func TestTakeQueueTuningFromParameters(t *testing.T) {
+	for _, tt := range []struct {
+		params map[string]string
+		q      queueTuning
+		err    bool
+	}{
+		{nil, queueTuning{}, false},
+		{map[string]string{"readahead": "1024"}, queueTuning{readahead: "1024"}, false},
+		{map[string]string{"readahead": "0128", "scheduler": "none"}, queueTuning{readahead: "128", scheduler: "none"}, false},
+		{map[string]string{"scheduler": "mq-deadline"}, queueTuning{}, true},
+		{map[string]string{"readahead": "-1"}, queueTuning{}, true},
+		{map[string]string{"readahead": "1MiB"}, queueTuning{}, true},
+		{map[string]string{"scheduler": "cfq"}, queueTuning{}, true},
+	} {
+		params := dupParams(tt.params)
+		q, err := takeQueueTuningFromParameters(params)
+		if (err != nil) != tt.err || (!tt.err && q != tt.q) {
+			t.Fatalf("%v: expected %+v and error %v, got %+v and %v", tt.params, tt.q, tt.err, q, err)
+		}
+		if !tt.err && len(params) != 0 {
+			t.Fatalf("%v: expected the parameters to be consumed, got %v", tt.params, params)
+		}
+	}
+}
+
+func TestQueueTuningTags(t *testing.T) {
+	q := queueTuning{readahead: "1024", scheduler: "none"}
+	tags := q.tags()
+	if !reflect.DeepEqual(tags, []string{"RA.1024", "IS.none"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+	for _, tag := range tags {
+		if err := lvm.ValidateTag(tag); err != nil {
+			t.Fatalf("invalid tag %q: %v", tag, err)
+		}
+	}
+	if got := queueTuningFromTags(append([]string{"VN.name", "LY.linear"}, tags...)); got != q {
+		t.Fatalf("expected %+v, got %+v", q, got)
+	}
+	if got := queueTuningFromTags([]string{"VN.name"}); got != (queueTuning{}) {
+		t.Fatalf("expected no tuning, got %+v", got)
+	}
+	if tags := (queueTuning{}).tags(); len(tags) != 0 {
+		t.Fatalf("expected no tags, got %v", tags)
+	}
+}
+
+func TestApplyQueueTuning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queue := filepath.Join(dir, "dm-3", "queue")
+	if err := os.MkdirAll(queue, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(queue, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		buf, err := ioutil.ReadFile(filepath.Join(queue, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf)
+	}
+	write("read_ahead_kb", "128\n")
+	write("scheduler", "[none] mq-deadline\n")
+	if err := applyQueueTuning(dir, "dm-3", queueTuning{readahead: "1024", scheduler: "mq-deadline"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := read("read_ahead_kb"); got != "1024" {
+		t.Fatalf("expected readahead 1024, got %q", got)
+	}
+	if got := read("scheduler"); got != "mq-deadline" {
+		t.Fatalf("expected scheduler mq-deadline, got %q", got)
+	}
+	// Bio-based device-mapper devices only offer "none".
+	write("scheduler", "none\n")
+	if err := applyQueueTuning(dir, "dm-3", queueTuning{scheduler: "mq-deadline"}); err == nil {
+		t.Fatal("expected an error for an unavailable scheduler")
+	}
+	if err := applyQueueTuning(dir, "dm-3", queueTuning{scheduler: "none"}); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is written without tuning.
+	if err := applyQueueTuning(dir, "dm-4", queueTuning{}); err != nil {
+		t.Fatal(err)
+	}
+}
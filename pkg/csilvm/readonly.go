@@ -0,0 +1,47 @@
+package csilvm
+
+import (
+	"strings"
+)
+
+// defaultReadonlyMountOptions are the mount options added when a
+// MOUNT_DEVICE volume with the given filesystem is published readonly. They
+// skip replaying the journal, which is impossible on a readonly device, so
+// that a volume whose writer crashed can still be published readonly.
+var defaultReadonlyMountOptions = map[string][]string{
+	"xfs":  {"norecovery"},
+	"ext4": {"noload"},
+}
+
+// ReadonlyMountOptions sets the mount options that are added when a
+// MOUNT_DEVICE volume with the given filesystem is published readonly,
+// e.g., "nouuid" and "norecovery" for xfs. Options that the CO already
+// specified are not added again. Passing no options disables the defaults
+// for that filesystem.
+func ReadonlyMountOptions(fstype string, options []string) ServerOpt {
+	return func(s *Server) {
+		s.readonlyMountOptions[fstype] = options
+	}
+}
+
+// withReadonlyMountOptions returns mountOptions with each of the readonly
+// options appended unless an option of the same name is already present.
+func withReadonlyMountOptions(mountOptions, readonlyOptions []string) []string {
+	opts := append([]string(nil), mountOptions...)
+	present := make(map[string]bool)
+	for _, opt := range mountOptions {
+		present[mountOptionName(opt)] = true
+	}
+	for _, opt := range readonlyOptions {
+		if !present[mountOptionName(opt)] {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// mountOptionName returns the name of a mount option, e.g., "logbufs" for
+// "logbufs=8".
+func mountOptionName(opt string) string {
+	return strings.SplitN(opt, "=", 2)[0]
+}
@@ -0,0 +1,44 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithReadonlyMountOptions(t *testing.T) {
+	cases := []struct {
+		opts     []string
+		readonly []string
+		exp      []string
+	}{
+		{nil, nil, nil},
+		{[]string{"noatime"}, nil, []string{"noatime"}},
+		{nil, []string{"norecovery"}, []string{"norecovery"}},
+		{[]string{"noatime"}, []string{"nouuid", "norecovery"}, []string{"noatime", "nouuid", "norecovery"}},
+		{[]string{"norecovery"}, []string{"norecovery"}, []string{"norecovery"}},
+		{[]string{"logbufs=8"}, []string{"logbufs=2"}, []string{"logbufs=8"}},
+	}
+	for i, tt := range cases {
+		got := withReadonlyMountOptions(tt.opts, tt.readonly)
+		if len(got) == 0 && len(tt.exp) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.exp) {
+			t.Fatalf("test case %d: expected %v but got %v", i, tt.exp, got)
+		}
+	}
+}
+
+func TestReadonlyMountOptions(t *testing.T) {
+	s := NewServer("vg", nil, "xfs", ReadonlyMountOptions("xfs", []string{"nouuid", "norecovery"}), ReadonlyMountOptions("ext4", nil))
+	if exp := []string{"nouuid", "norecovery"}; !reflect.DeepEqual(s.readonlyMountOptions["xfs"], exp) {
+		t.Fatalf("Expected %v but got %v", exp, s.readonlyMountOptions["xfs"])
+	}
+	if opts := s.readonlyMountOptions["ext4"]; len(opts) != 0 {
+		t.Fatalf("Expected no ext4 options but got %v", opts)
+	}
+	// The defaults are not modified.
+	if exp := []string{"noload"}; !reflect.DeepEqual(defaultReadonlyMountOptions["ext4"], exp) {
+		t.Fatalf("Expected default ext4 options %v but got %v", exp, defaultReadonlyMountOptions["ext4"])
+	}
+}
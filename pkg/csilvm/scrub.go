@@ -0,0 +1,242 @@
+package csilvm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+)
+
+const (
+	// tagScrubbedPrefix prefixes the logical volume tag that records when
+	// a check of a raid volume was last started, in seconds since the
+	// epoch.
+	tagScrubbedPrefix = "SC."
+	// scrubPollInterval is how often ScrubRAID reports the mismatch
+	// counts and considers starting a check.
+	scrubPollInterval = time.Minute
+)
+
+// ScrubWindow is a daily time range in local time in which ScrubRAID starts
+// checks. Start and End are offsets from midnight. A window whose end is
+// before its start wraps around midnight.
+type ScrubWindow struct {
+	Start, End time.Duration
+}
+
+// ParseScrubWindow parses a window of the form HH:MM-HH:MM, e.g.,
+// "01:00-05:00" or "22:00-02:00".
+func ParseScrubWindow(s string) (ScrubWindow, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return ScrubWindow{}, fmt.Errorf("invalid scrub window %q, expected HH:MM-HH:MM", s)
+	}
+	var offsets [2]time.Duration
+	for i, part := range parts {
+		t, err := time.Parse("15:04", part)
+		if err != nil {
+			return ScrubWindow{}, fmt.Errorf("invalid scrub window %q, expected HH:MM-HH:MM", s)
+		}
+		offsets[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if offsets[0] == offsets[1] {
+		return ScrubWindow{}, fmt.Errorf("invalid scrub window %q, the start and end are the same", s)
+	}
+	return ScrubWindow{Start: offsets[0], End: offsets[1]}, nil
+}
+
+// contains returns whether the local time of t is in the window.
+func (w ScrubWindow) contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start < w.End {
+		return w.Start <= offset && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+func (w ScrubWindow) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
+
+// inScrubWindow returns whether t is in any of the windows. Without windows
+// checks may be started at any time.
+func inScrubWindow(windows []ScrubWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubbedFromTags returns the time recorded by the scrubbed tag among the
+// given tags.
+func scrubbedFromTags(tags []string) (time.Time, bool) {
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, tagScrubbedPrefix) {
+			continue
+		}
+		secs, err := strconv.ParseInt(strings.TrimPrefix(tag, tagScrubbedPrefix), 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(secs, 0), true
+	}
+	return time.Time{}, false
+}
+
+// nextScrub returns the name of the raid volume that should be checked
+// next, i.e., the one that was checked the longest ago if that was at
+// least interval before now. No volume is returned while any volume is
+// syncing so that checks do not compete for I/O with each other or with
+// the synchronization of new or repaired images. The reports are sorted by
+// name in place.
+func nextScrub(reports []lvm.RAIDReport, now time.Time, interval time.Duration) (string, bool) {
+	var name string
+	var oldest time.Time
+	for _, report := range reports {
+		if report.Syncing() {
+			return "", false
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+	for _, report := range reports {
+		scrubbed, _ := scrubbedFromTags(report.Tags)
+		if now.Sub(scrubbed) < interval {
+			continue
+		}
+		if name == "" || scrubbed.Before(oldest) {
+			name, oldest = report.Name, scrubbed
+		}
+	}
+	return name, name != ""
+}
+
+// ScrubRAID checks each raid volume for silent corruption, i.e., for
+// regions in which its images differ, once every interval. Checks are only
+// started within the given windows, if any, and one volume is checked at a
+// time. A check that is still running at the end of a window is not
+// stopped. The mismatch counts found by the last check of each volume are
+// reported every minute. Starting a check is serialized with the requests,
+// see Serializer. The returned function stops
+// the scrubber and waits for it to return; running checks continue.
+func (s *Server) ScrubRAID(interval time.Duration, windows []ScrubWindow) context.CancelFunc {
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(scrubPollInterval)
+		defer ticker.Stop()
+		// mismatches records the last logged mismatch count of each
+		// volume so that they are logged once per check.
+		mismatches := make(map[string]uint64)
+		for {
+			if err := s.scrubRAID(ctx, time.Now(), interval, windows, mismatches); err != nil {
+				log.Printf("Cannot scrub raid volumes: err=%v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// scrubRAID reports the mismatch counts of the raid volumes and, if now is
+// in one of the windows, starts a check of the volume returned by
+// nextScrub.
+func (s *Server) scrubRAID(ctx context.Context, now time.Time, interval time.Duration, windows []ScrubWindow, mismatches map[string]uint64) error {
+	vg := s.volumeGroup.WithContext(ctx)
+	all, err := vg.ReportRAIDLogicalVolumes()
+	if err != nil {
+		return err
+	}
+	var reports []lvm.RAIDReport
+	var total, volumes uint64
+	owned := make(map[string]bool)
+	for _, report := range all {
+		if !s.ownsVolume(report.Name) {
+			continue
+		}
+		owned[report.Name] = true
+		reports = append(reports, report)
+		if report.Syncing() {
+			continue
+		}
+		if report.MismatchCount > 0 {
+			volumes++
+			total += report.MismatchCount
+			if mismatches[report.Name] != report.MismatchCount {
+				log.Printf("WARNING: The last check of volume %v found %d mismatches between its images", report.Name, report.MismatchCount)
+			}
+		}
+		mismatches[report.Name] = report.MismatchCount
+	}
+	for name := range mismatches {
+		if !owned[name] {
+			delete(mismatches, name)
+		}
+	}
+	s.metrics.Gauge("raid-mismatches").Update(float64(total))
+	s.metrics.Gauge("raid-volumes-with-mismatches").Update(float64(volumes))
+	if !inScrubWindow(windows, now) {
+		return nil
+	}
+	name, ok := nextScrub(reports, now, interval)
+	if !ok {
+		return nil
+	}
+	// The volume is looked up again, tagged and checked while the
+	// requests wait, so that it is not
+	// removed, e.g., by DeleteVolume, in the meantime.
+	return s.serialize(ctx, func() error {
+		lv, err := vg.LookupLogicalVolume(name)
+		if err == lvm.ErrLogicalVolumeNotFound {
+			// The volume was removed concurrently.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// The start of the check is recorded first so that a volume
+		// whose check fails to start is not retried every minute.
+		tags, err := lv.CachedTags()
+		if err != nil {
+			return err
+		}
+		tag := tagScrubbedPrefix + strconv.FormatInt(now.Unix(), 10)
+		var stale []string
+		for _, t := range tags {
+			if strings.HasPrefix(t, tagScrubbedPrefix) && t != tag {
+				stale = append(stale, t)
+			}
+		}
+		if err := lv.ChangeTags([]string{tag}, stale); err != nil {
+			return err
+		}
+		log.Printf("Checking raid volume %v for mismatches between its images", name)
+		if err := lv.CheckRAID(); err != nil {
+			s.metrics.Counter("raid-scrub-errs").Inc(1)
+			return err
+		}
+		s.metrics.Counter("raid-scrubs").Inc(1)
+		return nil
+	})
+}
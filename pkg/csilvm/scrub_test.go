@@ -0,0 +1,87 @@
+package csilvm
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+func TestParseScrubWindow(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2018, 1, 1, hour, min, 0, 0, time.Local)
+	}
+	for _, tt := range []struct {
+		window string
+		in     []time.Time
+		out    []time.Time
+	}{
+		{"01:00-05:00", []time.Time{at(1, 0), at(4, 59)}, []time.Time{at(0, 59), at(5, 0), at(23, 0)}},
+		{"22:30-02:00", []time.Time{at(22, 30), at(23, 59), at(0, 0), at(1, 59)}, []time.Time{at(2, 0), at(22, 29), at(12, 0)}},
+	} {
+		w, err := ParseScrubWindow(tt.window)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.window, err)
+		}
+		if w.String() != tt.window {
+			t.Fatalf("expected %v, got %v", tt.window, w)
+		}
+		for _, in := range tt.in {
+			if !w.contains(in) {
+				t.Fatalf("expected %v to contain %v", tt.window, in.Format("15:04"))
+			}
+		}
+		for _, out := range tt.out {
+			if w.contains(out) {
+				t.Fatalf("expected %v not to contain %v", tt.window, out.Format("15:04"))
+			}
+		}
+	}
+	for _, window := range []string{"", "01:00", "01:00-", "1-5", "01:00-25:00", "03:00-03:00"} {
+		if _, err := ParseScrubWindow(window); err == nil {
+			t.Fatalf("expected %q to be invalid", window)
+		}
+	}
+	if !inScrubWindow(nil, time.Now()) {
+		t.Fatal("expected any time to be in a window without windows")
+	}
+}
+
+func TestNextScrub(t *testing.T) {
+	now := time.Unix(100000, 0)
+	interval := 1000 * time.Second
+	scrubbed := func(secs int64) []string {
+		return []string{"VN.name", tagScrubbedPrefix + strconv.FormatInt(secs, 10)}
+	}
+	for _, tt := range []struct {
+		name    string
+		reports []lvm.RAIDReport
+		next    string
+	}{
+		{"none", nil, ""},
+		{"never scrubbed first", []lvm.RAIDReport{
+			{Name: "b", Tags: scrubbed(1), SyncAction: "idle"},
+			{Name: "a", SyncAction: "idle"},
+		}, "a"},
+		{"oldest first", []lvm.RAIDReport{
+			{Name: "a", Tags: scrubbed(50000), SyncAction: "idle"},
+			{Name: "b", Tags: scrubbed(1), SyncAction: "idle"},
+		}, "b"},
+		{"recently scrubbed", []lvm.RAIDReport{
+			{Name: "a", Tags: scrubbed(99500), SyncAction: "idle"},
+		}, ""},
+		{"syncing", []lvm.RAIDReport{
+			{Name: "a", SyncAction: "idle"},
+			{Name: "b", Tags: scrubbed(99500), SyncAction: "check"},
+		}, ""},
+		{"frozen", []lvm.RAIDReport{
+			{Name: "a", SyncAction: "frozen"},
+		}, "a"},
+	} {
+		next, ok := nextScrub(tt.reports, now, interval)
+		if next != tt.next || ok != (tt.next != "") {
+			t.Fatalf("%v: expected %q, got %q (ok=%v)", tt.name, tt.next, next, ok)
+		}
+	}
+}
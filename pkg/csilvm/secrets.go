@@ -0,0 +1,79 @@
+package csilvm
+
+import (
+	"crypto/subtle"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+)
+
+// secretKeyProvisioner is the key of the secret entry that CreateVolume and
+// DeleteVolume requests must present if the ProvisionerSecret option is set.
+const secretKeyProvisioner = "provisioner-secret"
+
+// redactedSecret replaces the values of secrets in logged requests.
+const redactedSecret = "<redacted>"
+
+// ProvisionerSecret configures the server to require CreateVolume and
+// DeleteVolume requests to present the given secret as the
+// "provisioner-secret" entry of their controller create or delete secrets.
+// Requests that do not are rejected with PermissionDenied. This is useful if
+// the socket is exposed beyond the kubelet, e.g., to other containers.
+func ProvisionerSecret(secret string) ServerOpt {
+	return func(s *Server) {
+		// The secret is referenced by a pointer so that it is not
+		// logged along with the server.
+		s.provisionerSecret = &secret
+	}
+}
+
+// checkProvisionerSecret returns an error if the ProvisionerSecret option is
+// set and the secrets of a request do not include it.
+func (s *Server) checkProvisionerSecret(method string, secrets map[string]string) error {
+	if s.provisionerSecret == nil {
+		return nil
+	}
+	secret, ok := secrets[secretKeyProvisioner]
+	if ok && subtle.ConstantTimeCompare([]byte(secret), []byte(*s.provisionerSecret)) == 1 {
+		return nil
+	}
+	log.Printf("Rejecting %v request without a matching %q secret", method, secretKeyProvisioner)
+	return statusErrorf(
+		codes.PermissionDenied,
+		s.errorInfo(ReasonPermissionDenied, "secret", secretKeyProvisioner),
+		"The request must present a matching %q secret", secretKeyProvisioner)
+}
+
+// redactSecrets returns a copy of the request whose secret values are
+// replaced so that it can be logged or traced. Requests without secrets are
+// returned as is.
+func redactSecrets(req interface{}) interface{} {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return req
+	}
+	var secrets *map[string]string
+	redacted := proto.Clone(msg)
+	switch r := redacted.(type) {
+	case *csi.CreateVolumeRequest:
+		secrets = &r.ControllerCreateSecrets
+	case *csi.DeleteVolumeRequest:
+		secrets = &r.ControllerDeleteSecrets
+	case *csi.ControllerPublishVolumeRequest:
+		secrets = &r.ControllerPublishSecrets
+	case *csi.ControllerUnpublishVolumeRequest:
+		secrets = &r.ControllerUnpublishSecrets
+	case *csi.NodeStageVolumeRequest:
+		secrets = &r.NodeStageSecrets
+	case *csi.NodePublishVolumeRequest:
+		secrets = &r.NodePublishSecrets
+	}
+	if secrets == nil || len(*secrets) == 0 {
+		return req
+	}
+	for key := range *secrets {
+		(*secrets)[key] = redactedSecret
+	}
+	return redacted
+}
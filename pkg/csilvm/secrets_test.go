@@ -0,0 +1,66 @@
+package csilvm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckProvisionerSecret(t *testing.T) {
+	s := &Server{}
+	if err := s.checkProvisionerSecret("CreateVolume", nil); err != nil {
+		t.Fatalf("expected no secret to be required but got %v", err)
+	}
+	s = NewServer("vg0", nil, "xfs", ProvisionerSecret("s3cret"))
+	if strings.Contains(fmt.Sprintf("%v", s), "s3cret") {
+		t.Fatalf("expected the server not to print the secret")
+	}
+	if err := s.checkProvisionerSecret("CreateVolume", map[string]string{secretKeyProvisioner: "s3cret"}); err != nil {
+		t.Fatalf("expected the matching secret to be accepted but got %v", err)
+	}
+	for _, secrets := range []map[string]string{
+		nil,
+		{secretKeyProvisioner: "wrong"},
+		{secretKeyProvisioner: ""},
+		{"other": "s3cret"},
+	} {
+		err := s.checkProvisionerSecret("DeleteVolume", secrets)
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("expected PermissionDenied for %v but got %v", secrets, err)
+		}
+		if info, ok := ErrorReason(err); !ok || info.Reason != ReasonPermissionDenied {
+			t.Fatalf("expected reason %v but got %v", ReasonPermissionDenied, info)
+		}
+		if strings.Contains(err.Error(), "s3cret") {
+			t.Fatalf("expected the error not to contain the secret: %v", err)
+		}
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	req := &csi.CreateVolumeRequest{
+		Name:                    "test-volume",
+		ControllerCreateSecrets: map[string]string{secretKeyProvisioner: "s3cret"},
+	}
+	redacted, ok := redactSecrets(req).(*csi.CreateVolumeRequest)
+	if !ok {
+		t.Fatalf("expected a CreateVolumeRequest")
+	}
+	if got := redacted.ControllerCreateSecrets[secretKeyProvisioner]; got != redactedSecret {
+		t.Fatalf("expected the secret to be redacted but got %q", got)
+	}
+	if redacted.Name != "test-volume" {
+		t.Fatalf("expected the name to be kept but got %q", redacted.Name)
+	}
+	if got := req.ControllerCreateSecrets[secretKeyProvisioner]; got != "s3cret" {
+		t.Fatalf("expected the request not to be modified but got %q", got)
+	}
+	other := &csi.ListVolumesRequest{}
+	if got := redactSecrets(other); got != other {
+		t.Fatalf("expected a request without secrets to be returned as is")
+	}
+}
@@ -0,0 +1,76 @@
+package csilvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/csilvm/pkg/cmd"
+	"golang.org/x/net/context"
+)
+
+// attrSELinuxRelabel is the volume attribute that, if set to "true",
+// causes the published filesystem to be relabeled with the configured
+// SELinux context instead of being mounted with the context= mount option.
+// Relabeling persists the labels on disk which is required by workloads
+// that set labels on individual files.
+const attrSELinuxRelabel = "selinux-relabel"
+
+// selinuxContextMountOptions lists the mount options that set the SELinux
+// context of a filesystem. They are mutually exclusive with the context=
+// option so we never add one if the CO specified any of these.
+var selinuxContextMountOptions = []string{"context=", "fscontext=", "defcontext=", "rootcontext="}
+
+// SELinuxContext sets the SELinux context, e.g.,
+// "system_u:object_r:container_file_t:s0", with which MOUNT_DEVICE
+// volumes are published. Unless the volume is relabeled (see
+// attrSELinuxRelabel) the context is passed as the context= mount option.
+func SELinuxContext(context string) ServerOpt {
+	return func(s *Server) {
+		s.selinuxContext = context
+	}
+}
+
+// parseSELinuxRelabel returns whether the volume attributes request that
+// the published filesystem be relabeled.
+func parseSELinuxRelabel(attrs map[string]string) (bool, error) {
+	v, ok := attrs[attrSELinuxRelabel]
+	if !ok {
+		return false, nil
+	}
+	relabel, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("The '%s' attribute must be a boolean: err=%v", attrSELinuxRelabel, err)
+	}
+	return relabel, nil
+}
+
+// withSELinuxContext returns the mount options with the context= option
+// appended. The options are returned unchanged if context is empty or if
+// they already specify an SELinux context.
+func withSELinuxContext(mountOptions []string, context string) []string {
+	if context == "" {
+		return mountOptions
+	}
+	for _, opt := range mountOptions {
+		for _, prefix := range selinuxContextMountOptions {
+			if strings.HasPrefix(opt, prefix) {
+				return mountOptions
+			}
+		}
+	}
+	// The context is quoted as it may contain commas when MCS
+	// categories are used, e.g., "s0:c1,c2".
+	opts := make([]string, 0, len(mountOptions)+1)
+	opts = append(opts, mountOptions...)
+	return append(opts, fmt.Sprintf("context=%q", context))
+}
+
+// relabel recursively sets the SELinux context of the files at path.
+func relabel(ctx context.Context, runner *cmd.Runner, path, selinuxContext string) error {
+	log.Printf("Relabeling %v with SELinux context %v", path, selinuxContext)
+	if _, err := runner.Run(ctx, 0, "chcon", "-R", selinuxContext, path); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithSELinuxContext(t *testing.T) {
+	const context = "system_u:object_r:container_file_t:s0:c1,c2"
+	cases := []struct {
+		opts    []string
+		context string
+		exp     []string
+	}{
+		{nil, "", nil},
+		{[]string{"noatime"}, "", []string{"noatime"}},
+		{nil, context, []string{`context="system_u:object_r:container_file_t:s0:c1,c2"`}},
+		{[]string{"noatime"}, context, []string{"noatime", `context="system_u:object_r:container_file_t:s0:c1,c2"`}},
+		{[]string{"context=foo"}, context, []string{"context=foo"}},
+		{[]string{"noatime", "defcontext=foo"}, context, []string{"noatime", "defcontext=foo"}},
+	}
+	for i, tt := range cases {
+		got := withSELinuxContext(tt.opts, tt.context)
+		if !reflect.DeepEqual(got, tt.exp) {
+			t.Fatalf("test case %d: expected %v but got %v", i, tt.exp, got)
+		}
+	}
+}
+
+func TestParseSELinuxRelabel(t *testing.T) {
+	relabel, err := parseSELinuxRelabel(nil)
+	if err != nil || relabel {
+		t.Fatalf("Expected no relabel but got %v, err=%v", relabel, err)
+	}
+	relabel, err = parseSELinuxRelabel(map[string]string{attrSELinuxRelabel: "true"})
+	if err != nil || !relabel {
+		t.Fatalf("Expected relabel but got %v, err=%v", relabel, err)
+	}
+	if _, err := parseSELinuxRelabel(map[string]string{attrSELinuxRelabel: "yes please"}); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
@@ -6,24 +6,27 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"math/rand"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/mesosphere/csilvm/pkg/cmd"
 	"github.com/mesosphere/csilvm/pkg/lvm"
 	"github.com/mesosphere/csilvm/pkg/version"
+	"github.com/mesosphere/csilvm/pkg/wipe"
 	"github.com/uber-go/tally"
 	"golang.org/x/net/context"
 	"golang.org/x/sync/semaphore"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 const (
@@ -31,16 +34,63 @@ const (
 )
 
 type Server struct {
-	vgname               string
-	pvnames              []string
-	volumeGroup          *lvm.VolumeGroup
-	defaultVolumeSize    uint64
-	supportedFilesystems map[string]string
-	removingVolumeGroup  bool
-	tags                 []string
-	probeModules         map[string]struct{}
-	nodeID               string
-	metrics              tally.Scope
+	// wipeBytesRemaining is the sum of the bytes left to wipe by all
+	// running wipes. It is first so that it is 64-bit aligned for atomic.
+	wipeBytesRemaining    int64
+	vgname                string
+	pvnames               []string
+	volumeGroup           *lvm.VolumeGroup
+	defaultVolumeSize     uint64
+	supportedFilesystems  map[string]string
+	detectFilesystems     []string
+	removingVolumeGroup   bool
+	tags                  []string
+	probeModules          map[string]struct{}
+	loadModules           bool
+	minKernelVersion      string
+	probeTools            map[string]struct{}
+	publishDir            string
+	activationSkip        bool
+	forceDeviceInit       bool
+	deviceWaitTimeout     time.Duration
+	nodeID                string
+	nodeVolumeIDs         bool
+	topology              bool
+	metrics               tally.Scope
+	extentSize            uint64
+	standbyDevices        []string
+	selinuxContext        string
+	runner                *cmd.Runner
+	metadataParams        map[string]struct{}
+	createTargetPath      bool
+	metadataBackupDir     string
+	metadataBackupHook    string
+	wipeMethods           []wipe.Method
+	wipeBlockSize         uint64
+	wipeRateLimit         uint64
+	ignoreDeviceConflicts bool
+	provisionerSecret     *string
+	maxVolumes            int64
+	unmountRetries        int
+	lazyUnmount           bool
+	skipWipeIfMissing     bool
+	trashRetention        time.Duration
+	ioLimiter             *ioLimiter
+	readonlyMountOptions  map[string][]string
+	targets               *targetRegistry
+	stateDir              string
+	cacheDeviceTag        string
+	volumeUsageStats      bool
+	volumePrefix          string
+	lvPrefix              string
+	adoptionTag           string
+	atomicPublishDir      string
+	journalEnabled        bool
+	journal               *journal
+	journalPending        []journalEntry
+	serializer            *RequestSerializer
+	configMu              sync.RWMutex
+	config                *Config
 }
 
 // NewServer returns a new Server that will manage the given LVM volume
@@ -63,7 +113,15 @@ func NewServer(vgname string, pvnames []string, defaultFs string, opts ...Server
 			"":        defaultFs,
 			defaultFs: defaultFs,
 		},
-		metrics: tally.NoopScope,
+		metrics:           tally.NoopScope,
+		wipeMethods:       defaultWipeMethods,
+		deviceWaitTimeout: defaultDeviceWaitTimeout,
+		targets:           newTargetRegistry(),
+		serializer:        NewRequestSerializer(),
+	}
+	s.readonlyMountOptions = make(map[string][]string)
+	for fstype, opts := range defaultReadonlyMountOptions {
+		s.readonlyMountOptions[fstype] = opts
 	}
 	for _, opt := range opts {
 		if opt == nil {
@@ -76,6 +134,7 @@ func NewServer(vgname string, pvnames []string, defaultFs string, opts ...Server
 	s.metrics = s.metrics.Tagged(map[string]string{
 		"volume-group": s.vgname,
 	})
+	s.runner = cmd.NewRunner(s.metrics)
 
 	log.Printf("NewServer: %v", s)
 	return s
@@ -86,6 +145,9 @@ func (s *Server) SupportedFilesystems() map[string]string {
 	for k, v := range s.supportedFilesystems {
 		m[k] = v
 	}
+	for _, fstype := range s.currentConfig().SupportedFilesystems {
+		m[fstype] = fstype
+	}
 	return m
 }
 
@@ -137,6 +199,43 @@ func Tag(tag string) ServerOpt {
 	}
 }
 
+// ExtentSize sets the physical extent size in bytes used when the volume
+// group is created by Setup. It has no effect on an existing volume group. If
+// unspecified, the LVM default is used.
+func ExtentSize(size uint64) ServerOpt {
+	return func(s *Server) {
+		s.extentSize = size
+	}
+}
+
+// StandbyDevices configures devices that are not initially part of the
+// volume group. If CreateVolume fails due to insufficient capacity, the
+// volume group is extended onto the next standby device and the volume
+// creation is retried once. Standby devices that are already part of the
+// volume group are treated as regular devices.
+func StandbyDevices(devices []string) ServerOpt {
+	return func(s *Server) {
+		for _, dev := range devices {
+			if dev != "" {
+				s.standbyDevices = append(s.standbyDevices, dev)
+			}
+		}
+	}
+}
+
+// defaultDeviceWaitTimeout is how long the server waits for udev to create
+// the device node of a volume unless overwritten by DeviceWaitTimeout.
+const defaultDeviceWaitTimeout = 10 * time.Second
+
+// DeviceWaitTimeout sets how long the server waits for udev to create the
+// device node of a volume after CreateVolume creates it and before
+// NodePublishVolume publishes it.
+func DeviceWaitTimeout(timeout time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.deviceWaitTimeout = timeout
+	}
+}
+
 // Metrics sets the Server's tally.Scope, used for reporting metrics.
 func Metrics(scope tally.Scope) ServerOpt {
 	return func(s *Server) {
@@ -144,6 +243,16 @@ func Metrics(scope tally.Scope) ServerOpt {
 	}
 }
 
+// Serializer serializes the background tasks of the server that run LVM
+// commands, e.g., ReplayJournal, with the requests that
+// SerializingInterceptor serializes with ser. Without it they are only
+// serialized with each other.
+func Serializer(ser *RequestSerializer) ServerOpt {
+	return func(s *Server) {
+		s.serializer = ser
+	}
+}
+
 // ProbeModules configures the server to query the loaded kernel modules to ensure
 // that prerequisite modules are loaded before any operations are executed.
 // This option may be specified multiple times to append additional module requirements.
@@ -178,6 +287,109 @@ func (s *Server) Setup() error {
 				err)
 		}
 	}
+	if !s.removingVolumeGroup {
+		log.Printf("Checking kernel version")
+		release, ok, err := s.checkKernelVersion()
+		if err != nil {
+			return fmt.Errorf("Cannot determine kernel version: err=%v", err)
+		}
+		if !ok {
+			return fmt.Errorf(
+				"The kernel version %v is older than the required version %v",
+				release, s.minKernelVersion)
+		}
+		log.Printf("Checking for required kernel modules")
+		missing, err := s.missingModules(context.Background(), s.loadModules)
+		if err != nil {
+			return fmt.Errorf("Cannot resolve kernel modules: err=%v", err)
+		}
+		if len(missing) > 0 {
+			// Probe reports the missing modules.
+			log.Printf("Kernel modules %v are missing: %v", missing, modulesRemediation(missing))
+		}
+		if len(s.detectFilesystems) > 0 {
+			log.Printf("Detecting available filesystems among %v", s.detectFilesystems)
+			detected, err := s.detectSupportedFilesystems(context.Background())
+			if err != nil {
+				return fmt.Errorf("Cannot detect available filesystems: err=%v", err)
+			}
+			log.Printf("Detected filesystems %v", detected)
+		}
+		log.Printf("Checking for required tools")
+		if missing := missingTools(s.requiredTools()); len(missing) > 0 {
+			return fmt.Errorf(
+				"One or more required tools are missing from $PATH: %v",
+				missing)
+		}
+		log.Printf("Checking container mounts")
+		problems, warnings, err := s.checkContainer()
+		if err != nil {
+			return fmt.Errorf("Cannot check container mounts: err=%v", err)
+		}
+		for _, warning := range warnings {
+			log.Printf("Warning: %v", warning)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf(
+				"The plugin's container is misconfigured, it requires the mounts %v: %v",
+				requiredContainerMounts(s.publishDir),
+				strings.Join(problems, "; "))
+		}
+	}
+	if s.volumePrefix != "" {
+		log.Printf("Validating volume prefix: %v", s.volumePrefix)
+		if err := ValidateVolumePrefix(s.volumePrefix); err != nil {
+			return fmt.Errorf(
+				"Invalid volume prefix '%v': err=%v",
+				s.volumePrefix,
+				err)
+		}
+		if s.removingVolumeGroup {
+			// The volume group is shared with other
+			// prefixes whose volumes we must not touch.
+			return errors.New("Cannot remove the volume group when a volume prefix is set")
+		}
+	}
+	if s.adoptionTag != "" {
+		log.Printf("Validating adoption tag: %v", s.adoptionTag)
+		if err := lvm.ValidateTag(s.adoptionTag); err != nil {
+			return fmt.Errorf(
+				"Invalid adoption tag '%v': err=%v",
+				s.adoptionTag,
+				err)
+		}
+	}
+	if s.lvPrefix != "" {
+		log.Printf("Validating logical volume prefix: %v", s.lvPrefix)
+		if err := ValidateLVPrefix(s.lvPrefix); err != nil {
+			return fmt.Errorf(
+				"Invalid logical volume prefix '%v': err=%v",
+				s.lvPrefix,
+				err)
+		}
+	}
+	if s.nodeVolumeIDs && s.nodeID == "" {
+		return errors.New("Cannot prefix volume ids with the node id as no node id is set")
+	}
+	if s.topology && s.nodeID == "" {
+		return errors.New("Cannot report the accessible topology of volumes as no node id is set")
+	}
+	if s.extentSize != 0 {
+		log.Printf("Validating extent size: %v", s.extentSize)
+		if err := lvm.ValidateExtentSize(s.extentSize); err != nil {
+			return fmt.Errorf(
+				"Invalid extent size %v: err=%v",
+				s.extentSize,
+				err)
+		}
+	}
+	log.Printf("Checking for other managers of the devices and volume group %v", s.vgname)
+	if err := s.checkDeviceConflicts(); err != nil {
+		return err
+	}
+	if err := s.registerDevices(context.Background()); err != nil {
+		return fmt.Errorf("Cannot add the devices to the LVM devices file: err=%v", err)
+	}
 	log.Printf("Looking up volume group %v", s.vgname)
 	volumeGroup, err := lvm.LookupVolumeGroup(s.vgname)
 	if err == lvm.ErrVolumeGroupNotFound {
@@ -194,48 +406,18 @@ func (s *Server) Setup() error {
 		log.Printf("Getting LVM2 physical volumes %v", s.pvnames)
 		var pvs []*lvm.PhysicalVolume
 		for _, pvname := range s.pvnames {
-			log.Printf("Looking up LVM2 physical volume %v", pvname)
-			var pv *lvm.PhysicalVolume
-			pv, err = lvm.LookupPhysicalVolume(pvname)
-			if err == nil {
-				log.Printf("Found LVM2 physical volume %v", pvname)
-				pvs = append(pvs, pv)
-				continue
+			pv, err := s.lookupOrCreatePhysicalVolume(pvname)
+			if err != nil {
+				return err
 			}
-			if err == lvm.ErrPhysicalVolumeNotFound {
-				log.Printf("Cannot find LVM2 physical volume %v", pvname)
-				// The physical volume cannot be found. Try to create it.
-				// First, wipe the partition table on the device in accordance
-				// with the `pvcreate` man page.
-				if err := statDevice(pvname); err != nil {
-					return fmt.Errorf(
-						"Could not stat device %v: err=%v",
-						pvname, err)
-				}
-				log.Printf("Stat device %v", pvname)
-				log.Printf("Zeroing partition table on %v", pvname)
-				if err := zeroPartitionTable(pvname); err != nil {
-					return fmt.Errorf(
-						"Cannot zero partition table on %v: err=%v",
-						pvname, err)
-				}
-				log.Printf("Creating LVM2 physical volume %v", pvname)
-				pv, err = lvm.CreatePhysicalVolume(pvname)
-				if err != nil {
-					return fmt.Errorf(
-						"Cannot create LVM2 physical volume %v: err=%v",
-						pvname, err)
-				}
-				log.Printf("Created LVM2 physical volume %v", pvname)
-				pvs = append(pvs, pv)
-				continue
-			}
-			return fmt.Errorf(
-				"Cannot lookup physical volume %v: err=%v",
-				pvname, err)
+			pvs = append(pvs, pv)
+		}
+		log.Printf("Creating volume group %v with physical volumes %v, tags %v and extent size %v", s.vgname, s.pvnames, s.tags, s.extentSize)
+		var vgopts []lvm.VolumeGroupOpt
+		if s.extentSize != 0 {
+			vgopts = append(vgopts, lvm.ExtentSize(s.extentSize))
 		}
-		log.Printf("Creating volume group %v with physical volumes %v and tags %v", s.vgname, s.pvnames, s.tags)
-		volumeGroup, err = lvm.CreateVolumeGroup(s.vgname, pvs, s.tags)
+		volumeGroup, err = lvm.CreateVolumeGroup(s.vgname, pvs, s.tags, vgopts...)
 		if err != nil {
 			return fmt.Errorf(
 				"Cannot create volume group %v: err=%v",
@@ -274,6 +456,7 @@ func (s *Server) Setup() error {
 			"Cannot list physical volumes: err=%v",
 			err)
 	}
+	s.adoptStandbyDevices(existing)
 	missing, unexpected := calculatePVDiff(existing, s.pvnames)
 	if len(missing) != 0 || len(unexpected) != 0 {
 		log.Printf("Volume group contains unexpected PVs %v and is missing PVs %v",
@@ -296,6 +479,19 @@ func (s *Server) Setup() error {
 			"Volume group tags did not match expected: err=%v",
 			err)
 	}
+	if s.extentSize != 0 && !s.removingVolumeGroup {
+		// The extent size of an existing volume group cannot be
+		// changed so we only log a mismatch.
+		extentSize, err := volumeGroup.ExtentSize()
+		if err != nil {
+			return fmt.Errorf(
+				"Cannot lookup extent size: err=%v",
+				err)
+		}
+		if extentSize != s.extentSize {
+			log.Printf("Volume group extent size %v does not match configured extent size %v", extentSize, s.extentSize)
+		}
+	}
 	// The volume group is configured as expected.
 	log.Printf("Volume group matches configuration")
 	if s.removingVolumeGroup {
@@ -311,7 +507,49 @@ func (s *Server) Setup() error {
 		log.Printf("Removed volume group %v", s.vgname)
 		return nil
 	}
+	if s.activationSkip {
+		// Logical volumes whose activation skip flag is set are not
+		// activated by the host's LVM so we activate them here.
+		log.Printf("Activating logical volumes in volume group %v", s.vgname)
+		if err := volumeGroup.Activate(); err != nil {
+			return fmt.Errorf(
+				"Cannot activate logical volumes: err=%v",
+				err)
+		}
+	}
+	if err := s.loadTargets(); err != nil {
+		return fmt.Errorf(
+			"Cannot load published targets: err=%v",
+			err)
+	}
+	if s.atomicPublishDir != "" {
+		if err := s.setupAtomicPublish(); err != nil {
+			return fmt.Errorf(
+				"Cannot set up atomic publish dir %v: err=%v",
+				s.atomicPublishDir, err)
+		}
+	}
 	s.volumeGroup = volumeGroup
+	if s.adoptionTag != "" {
+		log.Printf("Adopting logical volumes tagged %v", s.adoptionTag)
+		lvs, err := volumeGroup.ReportLogicalVolumes()
+		if err != nil {
+			return fmt.Errorf(
+				"Cannot list logical volumes to adopt: err=%v",
+				err)
+		}
+		s.adoptVolumes(context.Background(), lvs)
+	}
+	if s.journalEnabled {
+		if s.stateDir == "" {
+			return errors.New("The operation journal requires a state dir")
+		}
+		if err := s.setupJournal(); err != nil {
+			return fmt.Errorf(
+				"Cannot open operation journal: err=%v",
+				err)
+		}
+	}
 	s.reportStorageMetrics()
 	return nil
 }
@@ -349,61 +587,86 @@ func (s *Server) GetPluginCapabilities(
 	ctx context.Context,
 	request *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
 	response := &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-					},
-				},
-			},
-		},
+		Capabilities: s.serviceCapabilities().pluginCapabilities(),
 	}
 	return response, nil
 }
 
-// Probe is currently a no-op.
+// Probe checks that the required kernel modules are loaded, that the
+// required tools are in $PATH, that the plugin's container, if any, has the
+// required mounts and that the volume group and its physical volumes are
+// healthy.
 func (s *Server) Probe(
 	ctx context.Context,
 	request *csi.ProbeRequest) (*csi.ProbeResponse, error) {
-	if len(s.probeModules) > 0 {
-		mods := make(map[string]struct{})
-		listed, err := listModules()
-		if err != nil {
-			return nil, status.Errorf(
-				codes.FailedPrecondition,
-				"Cannot resolve kernel modules: err=%v",
-				err)
-		}
-		for _, m := range listed {
-			mods[m] = struct{}{}
-		}
-		var missing []string
-		for m := range s.probeModules {
-			if _, found := mods[m]; found {
-				continue
-			}
-			missing = append(missing, m)
-		}
-		if len(missing) > 0 {
-			return nil, status.Errorf(
-				codes.FailedPrecondition,
-				"One or more kernel modules are missing: %v",
-				missing)
-		}
+	missingModules, err := s.missingModules(ctx, s.loadModules)
+	if err != nil {
+		return nil, statusErrorf(
+			codes.FailedPrecondition,
+			s.errorInfo(ReasonKernelModulesMissing),
+			"Cannot resolve kernel modules: err=%v",
+			err)
+	}
+	if len(missingModules) > 0 {
+		return nil, statusErrorf(
+			codes.FailedPrecondition,
+			s.errorInfo(ReasonKernelModulesMissing,
+				"modules", strings.Join(missingModules, ","),
+				"remediation", modulesRemediation(missingModules)),
+			"One or more kernel modules are missing: %v",
+			missingModules)
+	}
+	release, ok, err := s.checkKernelVersion()
+	if err != nil {
+		return nil, statusErrorf(
+			codes.Internal,
+			s.errorInfo(ReasonKernelTooOld),
+			"Cannot determine kernel version: err=%v",
+			err)
+	}
+	if !ok {
+		return nil, statusErrorf(
+			codes.FailedPrecondition,
+			s.errorInfo(ReasonKernelTooOld,
+				"kernel", release,
+				"required", s.minKernelVersion,
+				"remediation", fmt.Sprintf("Upgrade the kernel to version %v or later.", s.minKernelVersion)),
+			"The kernel version %v is older than the required version %v",
+			release, s.minKernelVersion)
 	}
 	if s.removingVolumeGroup {
 		// We're busy removing the volume-group so no need to perform health checks.
 		response := &csi.ProbeResponse{}
 		return response, nil
 	}
+	if missing := missingTools(s.requiredTools()); len(missing) > 0 {
+		return nil, statusErrorf(
+			codes.FailedPrecondition,
+			s.errorInfo(ReasonToolsMissing, "tools", strings.Join(missing, ",")),
+			"One or more required tools are missing from $PATH: %v",
+			missing)
+	}
+	problems, _, err := s.checkContainer()
+	if err != nil {
+		return nil, statusErrorf(
+			codes.Internal,
+			s.errorInfo(ReasonMountInfoFailed),
+			"Cannot check container mounts: err=%v",
+			err)
+	}
+	if len(problems) > 0 {
+		return nil, statusErrorf(
+			codes.FailedPrecondition,
+			s.errorInfo(ReasonContainerMisconfigured,
+				"problems", strings.Join(problems, "; "),
+				"required_mounts", strings.Join(requiredContainerMounts(s.publishDir), ",")),
+			"The plugin's container is misconfigured: %v",
+			strings.Join(problems, "; "))
+	}
 	log.Printf("Looking up volume group %v", s.vgname)
 	volumeGroup, err := lvm.LookupVolumeGroup(s.vgname)
 	if err != nil {
-		return nil, status.Errorf(
-			codes.FailedPrecondition,
-			"Cannot find volume group %v",
-			s.vgname)
+		return nil, s.lvmError(err, fmt.Sprintf("Cannot find volume group %v", s.vgname))
 	}
 	log.Printf("Looking up physical volumes")
 	var pverrs []error
@@ -426,9 +689,7 @@ func (s *Server) Probe(
 	log.Printf("Comparing expected PVs with actual PVs")
 	existing, err := volumeGroup.ListPhysicalVolumeNames()
 	if err != nil {
-		return nil, fmt.Errorf(
-			"Cannot list physical volumes: err=%v",
-			err)
+		return nil, s.lvmError(err, "Cannot list physical volumes")
 	}
 	missing, unexpected := calculatePVDiff(existing, s.pvnames)
 	if len(missing) != 0 || len(unexpected) != 0 {
@@ -445,114 +706,393 @@ func (s *Server) Probe(
 // ControllerService RPCs
 
 func ErrNotMultipleOfExtentSize(extentSize uint64) error {
-	return status.Error(codes.OutOfRange, fmt.Sprintf("Volume capacity must be a multiple of %dMiB", extentSize>>20))
+	return statusError(codes.OutOfRange, newErrorInfo(ReasonNotMultipleOfExtentSize, "extentSize", strconv.FormatUint(extentSize, 10)), fmt.Sprintf("Volume capacity must be a multiple of %d bytes", extentSize))
 }
 
-var ErrVolumeAlreadyExists = status.Error(codes.AlreadyExists, "The volume already exists")
-var ErrInsufficientCapacity = status.Error(codes.OutOfRange, "Not enough free space")
-var ErrTooFewDisks = status.Error(codes.OutOfRange, "The volume group does not have enough underlying physical devices to support the requested RAID configuration")
+var ErrVolumeAlreadyExists = statusError(codes.AlreadyExists, newErrorInfo(ReasonVolumeAlreadyExists), "The volume already exists")
+var ErrInsufficientCapacity = statusError(codes.OutOfRange, newErrorInfo(ReasonInsufficientCapacity), "Not enough free space")
+
+func ErrTooFewDisks(required, available int) error {
+	return statusError(
+		codes.OutOfRange,
+		newErrorInfo(ReasonTooFewDisks, "required", strconv.Itoa(required), "available", strconv.Itoa(available)),
+		fmt.Sprintf("The volume group has %d underlying physical devices but the requested RAID configuration requires %d", available, required))
+}
+
+// isTooFewDisks returns whether err was returned by ErrTooFewDisks.
+func isTooFewDisks(err error) bool {
+	info, ok := ErrorReason(err)
+	return ok && info.Reason == ReasonTooFewDisks
+}
 
 const attrTags = "tags"
 
+// The size attributes are reported by CreateVolume only.
+const (
+	// attrExtentSize is the extent size of the volume group in bytes.
+	// The volume size is always a multiple of it.
+	attrExtentSize = "extent-size"
+	// attrRoundedUpBytes is the number of bytes by which the volume is
+	// larger than requested, e.g., because the requested size was
+	// rounded up to a multiple of the extent size.
+	attrRoundedUpBytes = "rounded-up-bytes"
+)
+
+// requestedSize returns the volume size requested by the CreateVolume
+// request, i.e., its required_bytes or the default volume size.
+func (s *Server) requestedSize(request *csi.CreateVolumeRequest) uint64 {
+	if required := request.GetCapacityRange().GetRequiredBytes(); required != 0 {
+		return uint64(required)
+	}
+	return s.defaultSize()
+}
+
+// sizeInCapacityRange returns the size of the volume given its requested
+// size rounded up to a multiple of extentSize. If the capacity range has a
+// limit_bytes but no required_bytes, the size is a single extentSize
+// instead. It returns false if that size exceeds limit_bytes.
+func sizeInCapacityRange(size, extentSize uint64, capacityRange *csi.CapacityRange) (uint64, bool) {
+	limit := uint64(capacityRange.GetLimitBytes())
+	if limit == 0 {
+		return size, true
+	}
+	if capacityRange.GetRequiredBytes() == 0 {
+		size = extentSize
+	}
+	if size > limit {
+		return 0, false
+	}
+	return size, true
+}
+
+// createVolumeAttributes returns the attributes of the volume reported by
+// CreateVolume. They are the volume attributes along with the size
+// attributes.
+func (s *Server) createVolumeAttributes(ctx context.Context, lv *lvm.LogicalVolume, request *csi.CreateVolumeRequest) (map[string]string, error) {
+	attr, err := s.volumeAttributes(lv)
+	if err != nil {
+		return nil, err
+	}
+	extentSize, err := s.volumeGroup.WithContext(ctx).ExtentSize()
+	if err != nil {
+		return nil, err
+	}
+	var roundedUp uint64
+	if requested := s.requestedSize(request); lv.SizeInBytes() > requested {
+		roundedUp = lv.SizeInBytes() - requested
+	}
+	if attr == nil {
+		attr = make(map[string]string)
+	}
+	attr[attrExtentSize] = strconv.FormatUint(extentSize, 10)
+	attr[attrRoundedUpBytes] = strconv.FormatUint(roundedUp, 10)
+	return attr, nil
+}
+
 func (s *Server) volumeAttributes(lv *lvm.LogicalVolume) (map[string]string, error) {
-	t, err := lv.Tags()
+	t, err := lv.CachedTags()
 	if err != nil {
 		return nil, err
 	}
 	if len(t) == 0 {
 		return nil, nil
 	}
+	return attributesFromTags(t)
+}
+
+// attributesFromTags returns the volume attributes that record the given
+// logical volume tags.
+func attributesFromTags(t []string) (map[string]string, error) {
 	buf, err := json.Marshal(t)
 	if err != nil {
 		return nil, err
 	}
-	return map[string]string{
+	attr := map[string]string{
 		attrTags: base64.RawURLEncoding.EncodeToString(buf),
-	}, nil
+	}
+	for key, value := range metadataFromTags(t) {
+		attr[attrMetadataPrefix+key] = value
+	}
+	return attr, nil
+}
+
+// The condition attributes are reported by ListVolumes only. The vendored
+// CSI spec predates the VolumeCondition message so we report the same
+// information as volume attributes.
+const (
+	// attrConditionAbnormal is "true" if the volume is unhealthy.
+	attrConditionAbnormal = "condition-abnormal"
+	// attrConditionMessage describes why the volume is unhealthy.
+	attrConditionMessage = "condition-message"
+)
+
+// volumeCondition determines whether the logical volume is healthy according
+// to LVM and whether its device node exists.
+func (s *Server) volumeCondition(lv lvm.LogicalVolumeReport) (abnormal bool, message string) {
+	var problems []string
+	if lv.HealthStatus != "" {
+		problems = append(problems, "health status is "+lv.HealthStatus)
+	}
+	if lv.Path == "" {
+		problems = append(problems, "cannot determine device path")
+	} else if _, err := os.Stat(lv.Path); err != nil {
+		problems = append(problems, fmt.Sprintf("device %v is missing: %v", lv.Path, err))
+	}
+	if len(problems) == 0 {
+		return false, ""
+	}
+	return true, strings.Join(problems, "; ")
 }
 
 func (s *Server) CreateVolume(
 	ctx context.Context,
 	request *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if err := s.checkProvisionerSecret("CreateVolume", request.GetControllerCreateSecrets()); err != nil {
+		return nil, err
+	}
+	dryRun, err := takeDryRunFromParameters(dupParams(request.GetParameters()))
+	if err != nil {
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	if err := s.checkAccessibilityRequirements(request.GetAccessibilityRequirements()); err != nil {
+		return nil, err
+	}
 
 	// Record the original volume name as a tag.
 	encodedName := s.volumeNameToTag(request.GetName())
-	tags := make([]string, len(s.tags), len(s.tags)+1)
-	copy(tags, s.tags)
-	tags = append(tags, encodedName)
+	tags := append(s.volumeTags(), encodedName)
 
 	// Check whether a logical volume with the given name already
 	// exists in this volume group.
 	log.Printf("Determining whether volume %q with encoded name %v already exists", request.GetName(), encodedName)
-	if lv, err := s.volumeGroup.FindLogicalVolume(lvm.LVMatchTag(encodedName)); err == nil {
+	if lv, err := s.volumeGroup.WithContext(ctx).FindLogicalVolume(lvm.LVMatchAll(lvm.LVMatchTag(encodedName), lvm.LVMatchName(s.ownsVolume))); err == nil {
 		log.Printf("Volume %s already exists.", encodedName)
 		// The volume already exists. Determine whether or not the
 		// existing volume satisfies the request. If so, return a
 		// successful response. If not, return ErrVolumeAlreadyExists.
-		if err := s.validateExistingVolume(lv, request); err != nil {
+		if err := s.validateExistingVolume(ctx, lv, request); err != nil {
 			return nil, err
 		}
-		attr, err := s.volumeAttributes(lv)
+		attr, err := s.createVolumeAttributes(ctx, lv, request)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to get volume attributes: err=%v", err)
+			return nil, s.lvmError(err, "failed to get volume attributes", "lvname", lv.Name())
+		}
+		if dryRun {
+			attr[attrDryRun] = "true"
 		}
 		response := &csi.CreateVolumeResponse{
 			Volume: &csi.Volume{
-				CapacityBytes: int64(lv.SizeInBytes()),
-				Id:            lv.Name(),
-				Attributes:    attr,
+				CapacityBytes:      int64(lv.SizeInBytes()),
+				Id:                 s.volumeIDFromName(lv.Name()),
+				Attributes:         attr,
+				AccessibleTopology: s.volumeTopology(),
 			},
 		}
 		return response, nil
+	} else if err != lvm.ErrLogicalVolumeNotFound {
+		return nil, s.lvmError(err, "Cannot look up volume")
+	}
+	if err := s.checkVolumeLimit(ctx); err != nil {
+		return nil, err
+	}
+	volumeID, err := s.allocateVolumeID(ctx, request.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return s.dryRunCreateVolume(ctx, volumeID, tags, request)
+	}
+	layout, err := takeVolumeLayoutFromParameters(dupParams(request.GetParameters()))
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, s.errorInfo(ReasonInvalidParameters), "Invalid volume layout: err=%v", err)
+	}
+	// Record the layout as a tag so that retries with a different
+	// layout can be detected.
+	tags = append(tags, layoutToTag(layout))
+	end, err := s.journalBegin(journalCreate, volumeID, "")
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	lv, err := s.createLogicalVolume(ctx, volumeID, tags, layout, request)
+	if (err == ErrInsufficientCapacity || isTooFewDisks(err)) && len(s.standbyDevices) > 0 && request.GetParameters()[paramPVTags] == "" && request.GetParameters()[paramPVs] == "" {
+		// The volume group is full or has too few devices for the
+		// requested layout. Extend it onto the next standby device
+		// and retry once. Standby devices have no tags and are not
+		// named by pvs so this does not help volumes restricted by
+		// pv-tags or pvs.
+		log.Printf("Insufficient capacity to create volume id=%v, extending volume group onto standby device", volumeID)
+		if eerr := s.extendOntoStandbyDevice(ctx); eerr != nil {
+			log.Printf("Failed to extend volume group onto standby device: err=%v", eerr)
+			return nil, err
+		}
+		lv, err = s.createLogicalVolume(ctx, volumeID, tags, layout, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Wait for the device node so that the volume can be published
+	// immediately. NodePublishVolume waits again so a timeout here is
+	// not an error.
+	if path, err := lv.Path(); err != nil {
+		log.Printf("Cannot determine path of volume id=%v: err=%v", volumeID, err)
+	} else if err := lvm.WaitForDevice(path, s.deviceWaitTimeout); err != nil {
+		log.Printf("Device %v of volume id=%v did not appear: err=%v", path, volumeID, err)
 	}
-	// Generate a random volume name and ensure that it doesn't already exist.
-	var volumeID string
+	s.backupMetadata(ctx, "CreateVolume")
+	attr, err := s.createVolumeAttributes(ctx, lv, request)
+	if err != nil {
+		return nil, s.lvmError(err, "failed to get volume attributes", "lvname", volumeID)
+	}
+	defer s.reportStorageMetrics()
+	response := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes:      int64(lv.SizeInBytes()),
+			Id:                 s.volumeIDFromName(volumeID),
+			Attributes:         attr,
+			AccessibleTopology: s.volumeTopology(),
+		},
+	}
+	return response, nil
+}
+
+// allocateVolumeID generates a random logical volume name for the named
+// volume and ensures that it doesn't already exist.
+func (s *Server) allocateVolumeID(ctx context.Context, name string) (string, error) {
 	const lvPrefix = "csilv"
-	for i := 0; i < 10 && volumeID == ""; i++ {
+	for i := 0; i < 10; i++ {
 		// prefix a random number to avoid stomping on reserved names.
-		tryID := lvPrefix + strconv.FormatUint(rand.Uint64(), 36)
-		log.Printf("Attempting to allocate id=%v for requested volume %q", tryID, request.GetName())
-		if _, err := s.volumeGroup.LookupLogicalVolume(tryID); err == nil {
+		tryID := s.newVolumeIDPrefix() + lvPrefix + strconv.FormatUint(rand.Uint64(), 36)
+		log.Printf("Attempting to allocate id=%v for requested volume %q", tryID, name)
+		if _, err := s.volumeGroup.WithContext(ctx).LookupLogicalVolume(tryID); err == nil {
 			log.Printf("Volume id %s already exists, trying again..", tryID)
 			continue
 		}
-		volumeID = tryID
+		log.Printf("Volume with id=%v does not already exist", tryID)
+		return tryID, nil
 	}
-	if volumeID == "" {
-		return nil, status.Error(codes.Internal, "Failed to allocate volume ID")
+	return "", statusError(codes.Internal, s.errorInfo(ReasonInternal), "Failed to allocate volume ID")
+}
+
+// volumePlan describes the logical volume that CreateVolume creates.
+type volumePlan struct {
+	size       uint64
+	extentSize uint64
+	tags       []string
+	lvopts     []lvm.CreateLogicalVolumeOpt
+	// required is the number of devices the layout requires and
+	// devices the number of physical volumes it may be allocated on.
+	required, devices int
+	// cache is nil unless the volume is cached, in which case its
+	// cache pool of cacheSize bytes is allocated on cacheDevices.
+	cache        *cacheSpec
+	cacheSize    uint64
+	cacheDevices []string
+}
+
+// planLogicalVolume checks that the logical volume specified by the
+// CreateVolume request can be created and determines its size, tags and
+// options.
+func (s *Server) planLogicalVolume(ctx context.Context, tags []string, layout lvm.VolumeLayout, request *csi.CreateVolumeRequest) (*volumePlan, error) {
+	params := dupParams(request.GetParameters())
+	delete(params, paramDryRun)
+	cache, err := takeCacheFromParameters(params)
+	if err != nil {
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
 	}
-	log.Printf("Volume with id=%v does not already exist", volumeID)
-	layout, err := takeVolumeLayoutFromParameters(dupParams(request.GetParameters()))
+	if cache != nil && s.cacheDeviceTag == "" {
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: The '%s' parameter requires cache devices to be configured", paramCache)
+	}
+	pvTags, err := takePVTagsFromParameters(params)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Invalid volume layout: err=%v", err)
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
 	}
-	// Determine the capacity, default to maximum size.
-	size := s.defaultVolumeSize
-	if capacityRange := request.GetCapacityRange(); capacityRange != nil {
-		// Set the volume size to the minimum requested size.
-		size = uint64(capacityRange.GetRequiredBytes())
-		// Get the extentSize for this volume group. The LV size must be a multiple of the extent size.
-		extentSize, err := s.volumeGroup.ExtentSize()
-		if err != nil {
-			return nil, status.Errorf(
-				codes.Internal,
-				"Error in ExtentSize: err=%v",
-				err)
-		}
-		// If size is not already a multiple of extentSize, round it up to the
-		// nearest extentSize.
-		if size%extentSize != 0 {
-			sizeBefore := size
-			size = ((size + extentSize) / extentSize) * extentSize
-			log.Printf("Rounding size up from required_bytes (about %dMiB) to nearest extent size (%dMiB) to get (%dMiB)", sizeBefore>>20, extentSize>>20, size>>20)
+	pinnedPVs, err := takePVsFromParameters(params)
+	if err != nil {
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	// Check upfront that the layout can be satisfied rather than relying
+	// on the lvcreate error which does not tell how many devices are
+	// required.
+	var (
+		pvnames        []string
+		candidates     []lvm.PhysicalVolumeReport
+		cacheDevices   []string
+		cacheBytesFree uint64
+	)
+	// A cached volume or one with pv-tags or pvs is restricted to a
+	// subset of the physical volumes.
+	restricted := cache != nil || len(pvTags) > 0 || len(pinnedPVs) > 0
+	if restricted {
+		var pvs []lvm.PhysicalVolumeReport
+		pvs, err = s.volumeGroup.WithContext(ctx).ReportPhysicalVolumes()
+		candidates = presentPhysicalVolumes(pvs)
+	} else {
+		pvnames, err = s.volumeGroup.WithContext(ctx).ListPhysicalVolumeNames()
+	}
+	if err != nil {
+		return nil, s.lvmError(err, "Cannot list physical volumes")
+	}
+	if err := checkPVNames(candidates, pinnedPVs); err != nil {
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	if cache != nil {
+		// A cached volume is allocated on the devices other than
+		// the cache devices.
+		var cachePVs []lvm.PhysicalVolumeReport
+		cachePVs, candidates = partitionCacheDevices(candidates, s.cacheDeviceTag)
+		for _, pv := range cachePVs {
+			cacheDevices = append(cacheDevices, pv.Name)
+			cacheBytesFree += pv.BytesFree
 		}
+	}
+	if len(pvTags) > 0 {
+		candidates = withPVTags(candidates, pvTags)
+		log.Printf("Physical volumes with tags %v: %v", pvTags, candidates)
+	}
+	if len(pinnedPVs) > 0 {
+		candidates = withPVNames(candidates, pinnedPVs)
+		log.Printf("Physical volumes among %v: %v", pinnedPVs, candidates)
+	}
+	for _, pv := range candidates {
+		pvnames = append(pvnames, pv.Name)
+	}
+	required := int(layout.MinNumberOfDevices())
+	if len(pvnames) < required {
+		log.Printf("Volume layout %+v requires %d devices but the volume group has %d", layout, required, len(pvnames))
+		return nil, ErrTooFewDisks(required, len(pvnames))
+	}
+	// Determine the capacity. The LV size must be a multiple of the
+	// extent size so we round it up as lvcreate would.
+	requested := s.requestedSize(request)
+	size, extentSize, err := s.volumeGroup.WithContext(ctx).RoundUpSize(requested)
+	if err != nil {
+		return nil, s.lvmError(err, "Error in RoundUpSize")
+	}
+	// Striped volumes are allocated in full stripes, i.e., in
+	// multiples of the extent size times the number of stripes.
+	unit := stripeSize(layout, extentSize)
+	size = roundUp(size, unit)
+	if size != requested {
+		log.Printf("Rounding size up from %d bytes (about %dMiB) to nearest extent size (%dMiB) to get (%dMiB)", requested, requested>>20, unit>>20, size>>20)
+	}
+	if limited, ok := sizeInCapacityRange(size, unit, request.GetCapacityRange()); !ok {
+		// [required_bytes,limit_bytes] does not include a multiple
+		// of unit, in which case we cannot satisfy this request.
+		return nil, ErrNotMultipleOfExtentSize(unit)
+	} else if limited != size {
+		log.Printf("Sizing volume to %d bytes as only limit_bytes is given, rather than %d bytes", limited, size)
+		size = limited
+	}
+	// Without a capacity_range lvcreate fails if there is not enough
+	// space but a dry run does not get that far.
+	if request.GetCapacityRange() != nil || isDryRun(request) {
 		// Get bytesFree, it is a multiple of extentSize.
-		bytesFree, err := s.volumeGroup.BytesFree(layout)
-		if err != nil {
-			return nil, status.Errorf(
-				codes.Internal,
-				"Error in BytesFree: err=%v",
-				err)
+		var bytesFree uint64
+		if restricted {
+			bytesFree = layout.BytesFreeOn(candidates, extentSize)
+		} else if bytesFree, err = s.volumeGroup.WithContext(ctx).BytesFree(layout); err != nil {
+			return nil, s.lvmError(err, "Error in BytesFree")
 		}
 		log.Printf("BytesFree: %v (%dMiB)", bytesFree, bytesFree>>20)
 		// Check whether there is enough free space available.
@@ -560,57 +1100,89 @@ func (s *Server) CreateVolume(
 		if bytesFree < size {
 			return nil, ErrInsufficientCapacity
 		}
-		if limit := capacityRange.GetLimitBytes(); limit != 0 && size > uint64(limit) {
-			// We've already checked that there is sufficient capacity. The only
-			// way we can arrive here is if [required_bytes,limit_bytes] does
-			// not include a multiple of extentSize, in which case we cannot
-			// satisfy this request.
-			return nil, ErrNotMultipleOfExtentSize(extentSize)
+	}
+	var cacheSize uint64
+	if cache != nil {
+		cacheSize = cachePoolSize(cache, size, extentSize)
+		log.Printf("Cache devices %v have %v bytes free for a cache pool of %v bytes", cacheDevices, cacheBytesFree, cacheSize)
+		if cacheBytesFree < cacheSize {
+			return nil, ErrInsufficientCacheCapacity
 		}
+		tags = append(append([]string(nil), tags...), cacheToTag(cache))
+	}
+	if len(pvTags) > 0 {
+		// Record the pv-tags as a tag so that retries with different
+		// pv-tags can be detected.
+		tags = append(append([]string(nil), tags...), pvTagsToTag(pvTags))
+	}
+	if len(pinnedPVs) > 0 {
+		// Record the pvs as a tag so that retries with different
+		// pvs can be detected.
+		tags = append(append([]string(nil), tags...), pvsToTag(pinnedPVs))
 	}
-	lvopts, err := volumeOptsFromParameters(request.GetParameters())
+	queue, err := takeQueueTuningFromParameters(params)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "Invalid parameters: %v", err)
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	// The tuning is applied when the volume is published.
+	tags = append(append([]string(nil), tags...), queue.tags()...)
+	mdtags, err := s.takeMetadataFromParameters(params)
+	if err != nil {
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	if len(mdtags) > 0 {
+		tags = append(append([]string(nil), tags...), mdtags...)
 	}
+	lvopts, err := volumeOptsFromParameters(params)
+	if err != nil {
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	if s.activationSkip {
+		lvopts = append(lvopts, lvm.ActivationSkipOpt())
+	}
+	if restricted {
+		lvopts = append(lvopts, lvm.PhysicalVolumesOpt(pvnames...))
+	}
+	return &volumePlan{
+		size:         size,
+		extentSize:   extentSize,
+		tags:         tags,
+		lvopts:       lvopts,
+		required:     required,
+		devices:      len(pvnames),
+		cache:        cache,
+		cacheSize:    cacheSize,
+		cacheDevices: cacheDevices,
+	}, nil
+}
 
-	log.Printf("Creating logical volume id=%v, size=%v, tags=%v, params=%v", volumeID, size, tags, request.GetParameters())
-	lv, err := s.volumeGroup.CreateLogicalVolume(volumeID, size, tags, lvopts...)
+// createLogicalVolume creates the logical volume with the given id as
+// specified by the CreateVolume request.
+func (s *Server) createLogicalVolume(ctx context.Context, volumeID string, tags []string, layout lvm.VolumeLayout, request *csi.CreateVolumeRequest) (*lvm.LogicalVolume, error) {
+	plan, err := s.planLogicalVolume(ctx, tags, layout, request)
 	if err != nil {
-		if err == lvm.ErrInvalidLVName {
-			return nil, status.Errorf(
-				codes.InvalidArgument,
-				"The volume name is invalid: err=%v",
-				err)
-		}
-		if err == lvm.ErrNoSpace {
-			// Somehow, despite checking for sufficient space
-			// above, we still have insuffient free space.
-			return nil, ErrInsufficientCapacity
-		}
-		if err == lvm.ErrTooFewDisks {
-			return nil, ErrTooFewDisks
-		}
-		return nil, status.Errorf(
-			codes.Internal,
-			"Error in CreateLogicalVolume: err=%v",
-			err)
+		return nil, err
 	}
-	attr, err := s.volumeAttributes(lv)
+	log.Printf("Creating logical volume id=%v, size=%v, tags=%v, params=%v", volumeID, plan.size, plan.tags, request.GetParameters())
+	lv, err := s.volumeGroup.WithContext(ctx).CreateLogicalVolume(volumeID, plan.size, plan.tags, plan.lvopts...)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to get volume attributes: err=%v", err)
+		if err == lvm.ErrTooFewDisks {
+			// Report how many devices the layout requires.
+			return nil, ErrTooFewDisks(plan.required, plan.devices)
+		}
+		// Somehow, despite checking for sufficient space above, we
+		// may still have insufficient free space.
+		return nil, s.lvmError(err, "Error in CreateLogicalVolume", "lvname", volumeID)
 	}
-	defer s.reportStorageMetrics()
-	response := &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			CapacityBytes: int64(lv.SizeInBytes()),
-			Id:            volumeID,
-			Attributes:    attr,
-		},
+	if plan.cache != nil {
+		if err := s.attachCache(ctx, lv, plan); err != nil {
+			return nil, err
+		}
 	}
-	return response, nil
+	return lv, nil
 }
 
-func (s *Server) validateExistingVolume(lv *lvm.LogicalVolume, request *csi.CreateVolumeRequest) error {
+func (s *Server) validateExistingVolume(ctx context.Context, lv *lvm.LogicalVolume, request *csi.CreateVolumeRequest) error {
 	// Determine whether the existing volume satisfies the capacity_range
 	// of the current request.
 	if capacityRange := request.GetCapacityRange(); capacityRange != nil {
@@ -634,21 +1206,91 @@ func (s *Server) validateExistingVolume(lv *lvm.LogicalVolume, request *csi.Crea
 		// specified, thanks to the specification and the request
 		// validation logic.
 	}
+	// Determine whether the existing volume has the requested layout.
+	layout, err := takeVolumeLayoutFromParameters(dupParams(request.GetParameters()))
+	if err != nil {
+		return statusErrorf(codes.Internal, s.errorInfo(ReasonInvalidParameters), "Invalid volume layout: err=%v", err)
+	}
+	tags, err := lv.CachedTags()
+	if err != nil {
+		return s.lvmError(err, "Error in CachedTags()", "lvname", lv.Name())
+	}
+	if existingTag, ok := layoutTagFromTags(tags); !ok {
+		// The volume was created before layouts were recorded so
+		// we cannot tell whether it matches.
+		log.Printf("Existing volume has no layout tag, assuming it matches the requested layout")
+	} else if requestedTag := layoutToTag(layout); existingTag != requestedTag {
+		log.Printf("Existing volume does not satisfy request: layout != volume layout (%v != %v)", requestedTag, existingTag)
+		return ErrVolumeAlreadyExists
+	}
+	// Determine whether the existing volume has the requested cache.
+	cache, err := takeCacheFromParameters(dupParams(request.GetParameters()))
+	if err != nil {
+		return statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	var requestedCacheTag string
+	if cache != nil {
+		requestedCacheTag = cacheToTag(cache)
+	}
+	if existingCacheTag, _ := cacheTagFromTags(tags); existingCacheTag != requestedCacheTag {
+		log.Printf("Existing volume does not satisfy request: cache != volume cache (%q != %q)", requestedCacheTag, existingCacheTag)
+		return ErrVolumeAlreadyExists
+	}
+	// Determine whether the existing volume has the requested pv-tags.
+	pvTags, err := takePVTagsFromParameters(dupParams(request.GetParameters()))
+	if err != nil {
+		return statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	var requestedPVTagsTag string
+	if len(pvTags) > 0 {
+		requestedPVTagsTag = pvTagsToTag(pvTags)
+	}
+	if existingPVTagsTag, _ := pvTagsTagFromTags(tags); existingPVTagsTag != requestedPVTagsTag {
+		log.Printf("Existing volume does not satisfy request: pv-tags != volume pv-tags (%q != %q)", requestedPVTagsTag, existingPVTagsTag)
+		return ErrVolumeAlreadyExists
+	}
+	// Determine whether the existing volume has the requested pvs.
+	pinnedPVs, err := takePVsFromParameters(dupParams(request.GetParameters()))
+	if err != nil {
+		return statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	var requestedPVsTag string
+	if len(pinnedPVs) > 0 {
+		requestedPVsTag = pvsToTag(pinnedPVs)
+	}
+	if existingPVsTag, _ := pvsTagFromTags(tags); existingPVsTag != requestedPVsTag {
+		log.Printf("Existing volume does not satisfy request: pvs != volume pvs (%q != %q)", requestedPVsTag, existingPVsTag)
+		return ErrVolumeAlreadyExists
+	}
+	// Determine whether the existing volume has the requested queue
+	// tuning.
+	queue, err := takeQueueTuningFromParameters(dupParams(request.GetParameters()))
+	if err != nil {
+		return statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	if existingQueue := queueTuningFromTags(tags); existingQueue != queue {
+		log.Printf("Existing volume does not satisfy request: queue tuning != volume queue tuning (%+v != %+v)", queue, existingQueue)
+		return ErrVolumeAlreadyExists
+	}
 	// The existing volume matches the requested capacity_range.  We
 	// determine whether the existing volume satisfies all requested
 	// volume_capabilities.
 	sourcePath, err := lv.Path()
 	if err != nil {
-		return status.Errorf(
-			codes.Internal,
-			"Error in Path(): err=%v",
-			err)
+		return s.lvmError(err, "Error in Path()", "lvname", lv.Name())
 	}
 	log.Printf("Volume path is %v", sourcePath)
-	existingFsType, err := determineFilesystemType(sourcePath)
+	existingFsType, err := determineFilesystemType(ctx, s.runner, sourcePath)
+	ptErr, partitioned := err.(*partitionTableError)
+	if partitioned {
+		// The volume can still satisfy BLOCK_DEVICE capabilities.
+		log.Printf("Existing volume is partitioned: %v", ptErr)
+		err = nil
+	}
 	if err != nil {
-		return status.Errorf(
+		return statusErrorf(
 			codes.Internal,
+			s.errorInfo(ReasonFilesystemUnknown, "lvname", lv.Name(), "device", sourcePath),
 			"Cannot determine filesystem type: err=%v",
 			err)
 	}
@@ -658,6 +1300,10 @@ func (s *Server) validateExistingVolume(lv *lvm.LogicalVolume, request *csi.Crea
 			// This is a MOUNT_VOLUME capability. We know that the
 			// requested filesystem type is supported on this host
 			// thanks to the request validation logic.
+			if partitioned {
+				log.Printf("Existing volume does not satisfy request: a partitioned volume cannot be formatted with fs_type %v", mnt.GetFsType())
+				return ErrVolumeAlreadyExists
+			}
 			if existingFsType != "" {
 				// The volume has already been formatted with
 				// some filesystem. If the requested
@@ -692,76 +1338,200 @@ func (s *Server) validateExistingVolume(lv *lvm.LogicalVolume, request *csi.Crea
 	return nil
 }
 
-var ErrVolumeNotFound = status.Error(codes.NotFound, "The volume does not exist.")
+var ErrVolumeNotFound = statusError(codes.NotFound, newErrorInfo(ReasonVolumeNotFound), "The volume does not exist.")
 
 func (s *Server) DeleteVolume(
 	ctx context.Context,
 	request *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	id := request.GetVolumeId()
+	if err := s.checkProvisionerSecret("DeleteVolume", request.GetControllerDeleteSecrets()); err != nil {
+		return nil, err
+	}
+	return s.deleteVolume(ctx, request)
+}
+
+// deleteVolume deletes the volume without checking the provisioner secret,
+// e.g., to finish a DeleteVolume request that was authorized before a crash.
+func (s *Server) deleteVolume(
+	ctx context.Context,
+	request *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	id := s.nameFromVolumeID(request.GetVolumeId())
 	log.Printf("Looking up volume with id=%v", id)
-	lv, err := s.volumeGroup.LookupLogicalVolume(id)
-	if err != nil {
+	lv, err := s.lookupVolume(ctx, id)
+	if err == lvm.ErrLogicalVolumeNotFound {
 		// It is idempotent to succeed if a volume is not found.
 		response := &csi.DeleteVolumeResponse{}
 		return response, nil
 	}
-	log.Printf("Determining volume path")
-	path, err := lv.Path()
 	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Error in Path(): err=%v",
-			err)
-	}
-	if _, err := os.Stat(path); err != nil && os.IsNotExist(err) {
-		return nil, status.Errorf(
-			codes.Internal,
-			"The device path does not exist, cannot zero volume contents. To bypass the zeroing of the volume contents, ensure the file exists, or create it by hand, and reissue the DeleteVolume operation. path=%s",
-			path)
+		return nil, s.lvmError(err, "Cannot look up volume", "lvname", id)
 	}
-	log.Printf("Deleting data on device %v", path)
-	if err := deleteDataOnDevice(path); err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Cannot delete data from device: err=%v",
-			err)
+	end, err := s.journalBegin(journalDelete, id, "")
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("Removing volume")
-	if err := lv.Remove(); err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Failed to remove volume: err=%v",
-			err)
+	defer end()
+	if s.trashRetention > 0 {
+		if err := s.trashVolume(ctx, lv); err != nil {
+			return nil, err
+		}
+	} else if err := s.removeVolume(ctx, lv); err != nil {
+		return nil, err
 	}
+	s.backupMetadata(ctx, "DeleteVolume")
 	defer s.reportStorageMetrics()
 	response := &csi.DeleteVolumeResponse{}
 	return response, nil
 }
 
-func deleteDataOnDevice(devicePath string) error {
-	// This method is the go equivalent of
-	// `dd if=/dev/zero of=PhysicalVolume`.
-	file, err := os.OpenFile(devicePath, os.O_WRONLY, 0644)
-	if err != nil {
+// removeVolume zeroes the contents of the logical volume and removes it.
+func (s *Server) removeVolume(ctx context.Context, lv *lvm.LogicalVolume) error {
+	id := lv.Name()
+	if err := s.activateVolume(lv); err != nil {
 		return err
 	}
-	defer file.Close()
-	devzero, err := os.Open("/dev/zero")
+	log.Printf("Determining volume path")
+	path, err := lv.Path()
+	if err != nil {
+		return s.lvmError(err, "Error in Path()", "lvname", id)
+	}
+	if _, err := os.Stat(path); err != nil && os.IsNotExist(err) {
+		if !s.skipWipeIfMissing {
+			return statusErrorf(
+				codes.Internal,
+				s.errorInfo(ReasonDeviceMissing, "lvname", id, "device", path),
+				"The device path does not exist, cannot zero volume contents. To bypass the zeroing of the volume contents, ensure the file exists, or create it by hand, and reissue the DeleteVolume operation. path=%s",
+				path)
+		}
+		log.Printf("WARNING: The device path %v of volume %v does not exist, removing the volume without zeroing its contents", path, id)
+		s.metrics.Tagged(map[string]string{"reason": "device_missing"}).Counter("wipe-skipped").Inc(1)
+		traceEvent(ctx, "skipped zeroing volume %v as device %v does not exist", id, path)
+	} else {
+		log.Printf("Deleting data on device %v", path)
+		if err := s.deleteDataOnDevice(ctx, path); err != nil {
+			return statusErrorf(
+				codes.Internal,
+				s.errorInfo(ReasonWipeFailed, "lvname", id, "device", path),
+				"Cannot delete data from device: err=%v",
+				err)
+		}
+	}
+	log.Printf("Removing volume")
+	if err := lv.Remove(); err == lvm.ErrLogicalVolumeNotFound {
+		// The volume was removed concurrently, e.g., by an
+		// operator. It is idempotent to succeed.
+		log.Printf("Volume %v disappeared before it could be removed", id)
+	} else if err != nil {
+		return s.lvmError(err, "Failed to remove volume", "lvname", id)
+	}
+	return nil
+}
+
+// defaultWipeMethods are used to delete data unless overridden by the
+// WipeMethods ServerOpt.
+var defaultWipeMethods = []wipe.Method{wipe.Zeroout, wipe.Copy}
+
+// WipeMethods sets the methods with which DeleteVolume deletes the data on
+// a volume. The methods are tried in order until one is supported by the
+// device.
+func WipeMethods(methods ...wipe.Method) ServerOpt {
+	return func(s *Server) {
+		s.wipeMethods = methods
+	}
+}
+
+// WipeBlockSize sets the number of bytes DeleteVolume wipes at a time. It
+// must be a multiple of 512. Larger blocks are faster on devices that
+// offload zeroing, e.g., NVMe drives, but cancellation and progress are
+// only checked between blocks. Zero selects wipe.DefaultBlockSize.
+func WipeBlockSize(size uint64) ServerOpt {
+	return func(s *Server) {
+		s.wipeBlockSize = size
+	}
+}
+
+// WipeRateLimit caps the rate at which DeleteVolume wipes a volume at the
+// given number of bytes per second, averaged over blocks of WipeBlockSize,
+// to bound the impact of large deletes on the I/O of other volumes. Each
+// wipe is limited separately. Zero means no limit.
+func WipeRateLimit(bytesPerSecond uint64) ServerOpt {
+	return func(s *Server) {
+		s.wipeRateLimit = bytesPerSecond
+	}
+}
+
+// SkipWipeIfDeviceMissing configures DeleteVolume to remove a volume
+// without zeroing its contents if LVM knows the volume but its device node
+// does not exist, instead of failing until an operator creates the node.
+// A warning is logged and the wipe-skipped counter is incremented. The data
+// remains on the physical volumes until it is overwritten by new volumes.
+func SkipWipeIfDeviceMissing() ServerOpt {
+	return func(s *Server) {
+		s.skipWipeIfMissing = true
+	}
+}
+
+// wipeDurationBuckets are the buckets of the wipe duration histogram, from
+// 1s to about 9 hours.
+var wipeDurationBuckets = tally.MustMakeExponentialDurationBuckets(time.Second, 2, 16)
+
+// wipeLogInterval is the minimum interval between logging wipe progress.
+const wipeLogInterval = 10 * time.Second
+
+// wipeRemaining is the contribution of one wipe to the sum of the bytes left
+// to wipe by all running wipes.
+type wipeRemaining struct {
+	s *Server
+	n uint64
+}
+
+// update sets the bytes left to wipe by this wipe to n and reports the sum
+// by the wipe-bytes-remaining gauge.
+func (w *wipeRemaining) update(n uint64) {
+	sum := atomic.AddInt64(&w.s.wipeBytesRemaining, int64(n)-int64(w.n))
+	w.n = n
+	w.s.metrics.Gauge("wipe-bytes-remaining").Update(float64(sum))
+}
+
+// deleteDataOnDevice overwrites the device with zeros. Progress is logged
+// and reported by the wipe-bytes-remaining gauge, which is the sum of the
+// bytes left to wipe by all running wipes.
+func (s *Server) deleteDataOnDevice(ctx context.Context, devicePath string) error {
+	release, err := s.acquireIOSlot(ctx, "wipe")
 	if err != nil {
 		return err
 	}
-	defer devzero.Close()
-	if _, err := io.Copy(file, devzero); err != nil {
-		// We expect to stop when we get ENOSPC.
-		if perr, ok := err.(*os.PathError); ok && perr.Err == syscall.ENOSPC {
-			return nil
+	defer release()
+	remaining := &wipeRemaining{s: s}
+	defer remaining.update(0)
+	start := time.Now()
+	lastLog := start
+	var wiped uint64
+	progress := func(n, total uint64) {
+		wiped = n
+		remaining.update(total - wiped)
+		if now := time.Now(); now.Sub(lastLog) >= wipeLogInterval {
+			lastLog = now
+			log.Printf("Deleted %dMiB of %dMiB (%d%%) on device %v", wiped>>20, total>>20, wiped*100/total, devicePath)
 		}
+	}
+	blockSize := s.wipeBlockSize
+	if blockSize == 0 {
+		blockSize = wipe.DefaultBlockSize
+	}
+	method, err := wipe.Device(ctx, devicePath, s.wipeMethods, blockSize, s.wipeRateLimit, progress)
+	if err != nil {
 		return err
 	}
-	panic("csilvm: expected ENOSPC when erasing data")
+	elapsed := time.Since(start)
+	scope := s.metrics.Tagged(map[string]string{"method": method}).SubScope("wipe")
+	scope.Histogram("duration", wipeDurationBuckets).RecordDuration(elapsed)
+	scope.Counter("bytes").Inc(int64(wiped))
+	traceEvent(ctx, "deleted %dMiB of data on device %v using %v in %v", wiped>>20, devicePath, method, elapsed)
+	log.Printf("Deleted %dMiB of data on device %v using %v with a block size of %d bytes in %v", wiped>>20, devicePath, method, blockSize, elapsed)
+	return nil
 }
 
-var ErrCallNotImplemented = status.Error(codes.Unimplemented, "That RPC is not implemented.")
+var ErrCallNotImplemented = statusError(codes.Unimplemented, newErrorInfo(ReasonNotImplemented), "That RPC is not implemented.")
 
 func (s *Server) ControllerPublishVolume(
 	ctx context.Context,
@@ -777,38 +1547,41 @@ func (s *Server) ControllerUnpublishVolume(
 	return nil, ErrCallNotImplemented
 }
 
-var ErrMismatchedFilesystemType = status.Error(
+var ErrMismatchedFilesystemType = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonFilesystemMismatch),
 	"The requested fs_type does not match the existing filesystem on the volume.")
 
 func (s *Server) ValidateVolumeCapabilities(
 	ctx context.Context,
 	request *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	id := request.GetVolumeId()
+	id := s.nameFromVolumeID(request.GetVolumeId())
 	log.Printf("Looking up volume with id=%v", id)
-	lv, err := s.volumeGroup.LookupLogicalVolume(id)
+	lv, err := s.lookupVolume(ctx, id)
 	if err != nil {
-		return nil, ErrVolumeNotFound
+		return nil, s.lvmError(err, "Cannot look up volume", "lvname", id)
 	}
 	log.Printf("Determining volume path")
 	sourcePath, err := lv.Path()
 	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Error in Path(): err=%v",
-			err)
+		return nil, s.lvmError(err, "Error in Path()", "lvname", id)
 	}
 	log.Printf("Determining filesystem type at %v", sourcePath)
-	existingFstype, err := determineFilesystemType(sourcePath)
-	if err != nil {
-		return nil, status.Errorf(
+	existingFstype, err := determineFilesystemType(ctx, s.runner, sourcePath)
+	ptErr, partitioned := err.(*partitionTableError)
+	if err != nil && !partitioned {
+		return nil, statusErrorf(
 			codes.Internal,
+			s.errorInfo(ReasonFilesystemUnknown, "lvname", id, "device", sourcePath),
 			"Cannot determine filesystem type: err=%v",
 			err)
 	}
 	log.Printf("Existing filesystem type is '%v'", existingFstype)
 	for _, capability := range request.GetVolumeCapabilities() {
 		if mnt := capability.GetMount(); mnt != nil {
+			if partitioned {
+				return nil, s.partitionedVolumeError(id, sourcePath, ptErr)
+			}
 			if existingFstype != "" {
 				// The volume has already been formatted.
 				if mnt.GetFsType() != "" && existingFstype != mnt.GetFsType() {
@@ -818,9 +1591,28 @@ func (s *Server) ValidateVolumeCapabilities(
 			}
 		}
 	}
+	supported, message := checkVolumeCapabilities(request.GetVolumeCapabilities(), s.SupportedFilesystems())
+	log.Printf("Volume capabilities supported=%v: %v", supported, message)
+	messages := []string{message}
+	if len(request.GetVolumeAttributes()) > 0 {
+		recorded, err := s.volumeAttributes(lv)
+		if err != nil {
+			return nil, s.lvmError(err, "failed to get volume attributes", "lvname", id)
+		}
+		ok, message := checkVolumeAttributes(request.GetVolumeAttributes(), recorded)
+		log.Printf("Volume attributes supported=%v: %v", ok, message)
+		supported = supported && ok
+		messages = append(messages, message)
+	}
+	if len(request.GetAccessibleTopology()) > 0 {
+		ok, message := s.checkAccessibleTopology(request.GetAccessibleTopology())
+		log.Printf("Accessible topology supported=%v: %v", ok, message)
+		supported = supported && ok
+		messages = append(messages, message)
+	}
 	response := &csi.ValidateVolumeCapabilitiesResponse{
-		Supported: true,
-		Message:   "",
+		Supported: supported,
+		Message:   strings.Join(messages, "; "),
 	}
 	return response, nil
 }
@@ -849,7 +1641,25 @@ func (s *Server) volumeNameToTag(volname string) string {
 		return tagVolumeNameEncodedPrefix +
 			base64.RawURLEncoding.EncodeToString([]byte(volname))
 	}
-	return tagVolumeNamePlainPrefix + volname
+	return tagVolumeNamePlainPrefix + volname
+}
+
+// volumeNameFromTags returns the volume name recorded by volumeNameToTag
+// among the given tags.
+func volumeNameFromTags(tags []string) (string, bool) {
+	for _, tag := range tags {
+		switch {
+		case strings.HasPrefix(tag, tagVolumeNamePlainPrefix):
+			return strings.TrimPrefix(tag, tagVolumeNamePlainPrefix), true
+		case strings.HasPrefix(tag, tagVolumeNameEncodedPrefix):
+			name, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(tag, tagVolumeNameEncodedPrefix))
+			if err != nil {
+				continue
+			}
+			return string(name), true
+		}
+	}
+	return "", false
 }
 
 func (s *Server) ListVolumes(
@@ -860,30 +1670,52 @@ func (s *Server) ListVolumes(
 		response := &csi.ListVolumesResponse{}
 		return response, nil
 	}
-	volnames, err := s.volumeGroup.ListLogicalVolumeNames()
+	filter, err := volumeFilterFromContext(ctx)
 	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Cannot list volume names: err=%v",
-			err)
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	// Report all logical volumes at once rather than looking up each
+	// of them, which takes the volume group lock several times per
+	// volume.
+	lvs, err := s.volumeGroup.WithContext(ctx).ReportLogicalVolumes()
+	if err != nil {
+		return nil, s.lvmError(err, "Cannot list volumes")
+	}
+	lvs = filter.apply(s.ownedVolumes(lvs))
+	if !filter.isEmpty() {
+		log.Printf("Listing %d volumes matching %v", len(lvs), filter)
+	}
+	var usage *volumeUsage
+	if s.volumeUsageStats {
+		usage = newVolumeUsage(lvs)
 	}
 	var entries []*csi.ListVolumesResponse_Entry
-	for _, volname := range volnames {
-		log.Printf("Looking up volume '%v'", volname)
-		lv, err := s.volumeGroup.LookupLogicalVolume(volname)
-		if err != nil {
-			return nil, ErrVolumeNotFound
+	for _, lv := range lvs {
+		var attr map[string]string
+		if len(lv.Tags) > 0 {
+			attr, err = attributesFromTags(lv.Tags)
+			if err != nil {
+				return nil, statusErrorf(codes.Internal, s.errorInfo(ReasonLVMFailure, "lvname", lv.Name), "failed to get volume attributes: err=%v", err)
+			}
 		}
-		attr, err := s.volumeAttributes(lv)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to get volume attributes: err=%v", err)
+		abnormal, message := s.volumeCondition(lv)
+		if attr == nil {
+			attr = make(map[string]string)
+		}
+		attr[attrConditionAbnormal] = strconv.FormatBool(abnormal)
+		if message != "" {
+			attr[attrConditionMessage] = message
 		}
 		info := &csi.Volume{
-			CapacityBytes: int64(lv.SizeInBytes()),
-			Id:            lv.Name(),
-			Attributes:    attr,
+			CapacityBytes:      int64(lv.SizeInBytes),
+			Id:                 s.volumeIDFromName(lv.Name),
+			Attributes:         attr,
+			AccessibleTopology: s.volumeTopology(),
 		}
-		log.Printf("Found volume %v (%v bytes)", volname, lv.SizeInBytes())
+		if usage != nil {
+			usage.addAttributes(lv.Name, attr)
+		}
+		log.Printf("Found volume %v (%v bytes)", lv.Name, lv.SizeInBytes)
 		entry := &csi.ListVolumesResponse_Entry{Volume: info}
 		entries = append(entries, entry)
 	}
@@ -904,13 +1736,14 @@ func (s *Server) GetCapacity(
 		response := &csi.GetCapacityResponse{AvailableCapacity: 0}
 		return response, nil
 	}
+	supportedFilesystems := s.SupportedFilesystems()
 	for _, volumeCapability := range request.GetVolumeCapabilities() {
 		// Check for unsupported filesystem type in order to return 0
 		// capacity if it isn't supported.
 		if mnt := volumeCapability.GetMount(); mnt != nil {
 			// This is a MOUNT_VOLUME request.
 			fstype := mnt.GetFsType()
-			if _, ok := s.supportedFilesystems[fstype]; !ok {
+			if _, ok := supportedFilesystems[fstype]; !ok {
 				// Zero capacity for unsupported filesystem type.
 				response := &csi.GetCapacityResponse{AvailableCapacity: 0}
 				return response, nil
@@ -919,16 +1752,25 @@ func (s *Server) GetCapacity(
 	}
 	layout, err := takeVolumeLayoutFromParameters(dupParams(request.GetParameters()))
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Invalid volume layout: err=%v", err)
+		return nil, statusErrorf(codes.Internal, s.errorInfo(ReasonInvalidParameters), "Invalid volume layout: err=%v", err)
 	}
-	bytesFree, err := s.volumeGroup.BytesFree(layout)
+	pvTags, err := takePVTagsFromParameters(dupParams(request.GetParameters()))
 	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Error in BytesFree: err=%v",
-			err)
+		return nil, statusErrorf(codes.InvalidArgument, s.errorInfo(ReasonInvalidParameters), "Invalid parameters: %v", err)
+	}
+	var bytesFree uint64
+	if len(pvTags) > 0 {
+		// Report the capacity of the physical volumes with the
+		// requested tags only.
+		bytesFree, err = s.bytesFreeWithPVTags(ctx, layout, pvTags)
+	} else {
+		bytesFree, err = s.volumeGroup.WithContext(ctx).BytesFree(layout)
+	}
+	if err != nil {
+		return nil, s.lvmError(err, "Error in BytesFree")
 	}
 	log.Printf("BytesFree: %v", bytesFree)
+	s.sendCapacityAttributes(ctx, layout, pvTags)
 	defer s.reportStorageMetrics()
 	response := &csi.GetCapacityResponse{AvailableCapacity: int64(bytesFree)}
 	return response, nil
@@ -937,38 +1779,7 @@ func (s *Server) GetCapacity(
 func (s *Server) ControllerGetCapabilities(
 	ctx context.Context,
 	request *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	capabilities := []*csi.ControllerServiceCapability{
-		// CREATE_DELETE_VOLUME
-		{
-			Type: &csi.ControllerServiceCapability_Rpc{
-				Rpc: &csi.ControllerServiceCapability_RPC{
-					Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-				},
-			},
-		},
-		// PUBLISH_UNPUBLISH_VOLUME
-		//
-		//     Not supported by Controller service. This is
-		//     performed by the Node service for the Logical
-		//     Volume Service.
-		//
-		// LIST_VOLUMES
-		{
-			Type: &csi.ControllerServiceCapability_Rpc{
-				Rpc: &csi.ControllerServiceCapability_RPC{
-					Type: csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
-				},
-			},
-		},
-		// GET_CAPACITY
-		{
-			Type: &csi.ControllerServiceCapability_Rpc{
-				Rpc: &csi.ControllerServiceCapability_RPC{
-					Type: csi.ControllerServiceCapability_RPC_GET_CAPACITY,
-				},
-			},
-		},
-	}
+	capabilities := s.serviceCapabilities().controllerCapabilities()
 	response := &csi.ControllerGetCapabilitiesResponse{Capabilities: capabilities}
 	return response, nil
 }
@@ -1010,50 +1821,223 @@ func (s *Server) NodeUnstageVolume(
 	return nil, ErrCallNotImplemented
 }
 
-var ErrTargetPathNotEmpty = status.Error(
+var ErrTargetPathNotEmpty = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonTargetPathNotEmpty),
 	"Unexpected device already mounted at targetPath.")
 
-var ErrTargetPathRO = status.Error(
+var ErrTargetPathRO = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonTargetPathReadonly),
 	"The targetPath is already mounted readonly.")
 
-var ErrTargetPathRW = status.Error(
+var ErrTargetPathRW = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonTargetPathReadWrite),
 	"The targetPath is already mounted read-write.")
 
 func (s *Server) NodePublishVolume(
 	ctx context.Context,
 	request *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	id := request.GetVolumeId()
+	id := s.nameFromVolumeID(request.GetVolumeId())
 	log.Printf("Looking up volume with id=%v", id)
-	lv, err := s.volumeGroup.LookupLogicalVolume(id)
+	lv, err := s.lookupVolume(ctx, id)
 	if err != nil {
-		return nil, ErrVolumeNotFound
+		return nil, s.lvmError(err, "Cannot look up volume", "lvname", id)
+	}
+	if _, published := s.targets.entries(id)[request.GetTargetPath()]; !published {
+		// Only a new publication is rolled back if it is
+		// interrupted. The CO relies on existing ones.
+		end, err := s.journalBegin(journalPublish, id, request.GetTargetPath())
+		if err != nil {
+			return nil, err
+		}
+		defer end()
+	}
+	if err := s.activateVolume(lv); err != nil {
+		return nil, err
 	}
 	log.Printf("Determining volume path")
 	sourcePath, err := lv.Path()
 	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Error in Path(): err=%v",
-			err)
+		return nil, s.lvmError(err, "Error in Path()", "lvname", id)
 	}
 	log.Printf("Volume path is %v", sourcePath)
+	if err := lvm.WaitForDevice(sourcePath, s.deviceWaitTimeout); err != nil {
+		return nil, statusErrorf(
+			codes.FailedPrecondition,
+			s.errorInfo(ReasonDeviceMissing, "lvname", id, "device", sourcePath),
+			"The device does not exist: err=%v",
+			err)
+	}
+	if err := s.tuneQueue(lv, sourcePath); err != nil {
+		return nil, err
+	}
 	targetPath := request.GetTargetPath()
 	log.Printf("Target path is %v", targetPath)
+	isBlock := request.GetVolumeCapability().GetBlock() != nil
+	if s.createTargetPath {
+		if err := createTargetPath(targetPath, isBlock); err != nil {
+			return nil, statusErrorf(
+				codes.Internal,
+				s.errorInfo(ReasonTargetPathCreateFailed, "lvname", id, "targetPath", targetPath),
+				"Cannot create target path: err=%v",
+				err)
+		}
+	}
 	readonly := request.GetVolumeCapability().GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
 	readonly = readonly || request.GetReadonly()
 	log.Printf("Mounting readonly: %v", readonly)
+	if err := s.checkReadonlyBlockConflict(ctx, id, sourcePath, targetPath, readonly, isBlock); err != nil {
+		return nil, err
+	}
 	switch accessType := request.GetVolumeCapability().GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
+		opts, err := parseBlockPublishOptions(request.GetVolumeAttributes())
+		if err != nil {
+			return nil, statusErrorf(
+				codes.InvalidArgument,
+				s.errorInfo(ReasonInvalidParameters, "lvname", id),
+				"Invalid volume attributes: err=%v",
+				err)
+		}
+		if opts.verifyDirectIO {
+			log.Printf("Verifying that %v can be read with O_DIRECT", sourcePath)
+			if err := verifyDirectIO(sourcePath); err != nil {
+				return nil, statusErrorf(
+					codes.FailedPrecondition,
+					s.errorInfo(ReasonDirectIOFailed, "lvname", id, "device", sourcePath),
+					"Cannot read device with O_DIRECT: err=%v",
+					err)
+			}
+		}
+		if readonly {
+			// The readonly flag of a bind mount does not
+			// prevent writes through a device node.
+			log.Printf("Setting the device %v readonly", sourcePath)
+			if err := setDeviceReadonly(ctx, s.runner, sourcePath, true); err != nil {
+				return nil, statusErrorf(
+					codes.Internal,
+					s.errorInfo(ReasonDeviceReadonlyFailed, "lvname", id, "device", sourcePath),
+					"Cannot set the device readonly: err=%v",
+					err)
+			}
+		}
 		if err := s.nodePublishVolume_Block(sourcePath, targetPath, readonly); err != nil {
+			if readonly {
+				if err := s.releaseReadonlyDevice(ctx, lv, targetInfo{Readonly: true, Block: true}); err != nil {
+					log.Printf("Cannot clear the readonly flag of %v: err=%v", sourcePath, err)
+				}
+			}
 			return nil, err
 		}
+		if err := applyDevicePermissions(targetPath, opts); err != nil {
+			// Undo the bind mount so that a retry sets the
+			// permissions again instead of finding the volume
+			// already published.
+			if err := s.unmountTarget(ctx, targetPath); err != nil {
+				log.Printf("Cannot unmount %v: err=%v", targetPath, err)
+			}
+			if readonly {
+				if err := s.releaseReadonlyDevice(ctx, lv, targetInfo{Readonly: true, Block: true}); err != nil {
+					log.Printf("Cannot clear the readonly flag of %v: err=%v", sourcePath, err)
+				}
+			}
+			return nil, statusErrorf(
+				codes.Internal,
+				s.errorInfo(ReasonDevicePermissionsFailed, "lvname", id, "device", sourcePath, "targetPath", targetPath),
+				"Cannot set device permissions: err=%v",
+				err)
+		}
+		s.targets.add(id, targetPath, targetInfo{Readonly: readonly, Block: true})
 	case *csi.VolumeCapability_Mount:
 		fstype := request.GetVolumeCapability().GetMount().GetFsType()
 		mountOptions := request.GetVolumeCapability().GetMount().GetMountFlags()
-		if err := s.nodePublishVolume_Mount(sourcePath, targetPath, readonly, fstype, mountOptions); err != nil {
+		relabelVolume, err := parseSELinuxRelabel(request.GetVolumeAttributes())
+		if err != nil {
+			return nil, statusErrorf(
+				codes.InvalidArgument,
+				s.errorInfo(ReasonInvalidParameters, "lvname", id),
+				"Invalid volume attributes: err=%v",
+				err)
+		}
+		idmap, err := parseIdmap(request.GetVolumeAttributes())
+		if err != nil {
+			return nil, statusErrorf(
+				codes.InvalidArgument,
+				s.errorInfo(ReasonInvalidParameters, "lvname", id),
+				"Invalid volume attributes: err=%v",
+				err)
+		}
+		gid, hasMountGroup, err := volumeMountGroup(request.GetVolumeCapability().GetMount())
+		if err != nil {
+			return nil, statusErrorf(
+				codes.InvalidArgument,
+				s.errorInfo(ReasonInvalidParameters, "lvname", id),
+				"Invalid volume capability: err=%v",
+				err)
+		}
+		if relabelVolume {
+			if s.selinuxContext == "" {
+				return nil, statusErrorf(
+					codes.InvalidArgument,
+					s.errorInfo(ReasonInvalidParameters, "lvname", id),
+					"The '%s' attribute requires an SELinux context to be configured",
+					attrSELinuxRelabel)
+			}
+			if readonly {
+				return nil, statusErrorf(
+					codes.InvalidArgument,
+					s.errorInfo(ReasonInvalidParameters, "lvname", id),
+					"The '%s' attribute cannot be used with readonly volumes",
+					attrSELinuxRelabel)
+			}
+		} else {
+			mountOptions = withSELinuxContext(mountOptions, s.selinuxContext)
+		}
+		// configure is called with the path at which the filesystem
+		// is mounted, which is a staging path with AtomicPublish.
+		configure := func(mountPath string) error {
+			if relabelVolume {
+				if err := relabel(ctx, s.runner, mountPath, s.selinuxContext); err != nil {
+					return statusErrorf(
+						codes.Internal,
+						s.errorInfo(ReasonRelabelFailed, "lvname", id, "targetPath", targetPath),
+						"Cannot relabel volume: err=%v",
+						err)
+				}
+			}
+			if hasMountGroup {
+				if readonly {
+					// The filesystem cannot be changed, like the
+					// kubelet we leave its ownership as is.
+					log.Printf("Not applying volume mount group %v to readonly volume at %v", gid, mountPath)
+				} else {
+					log.Printf("Applying volume mount group %v to %v", gid, mountPath)
+					if err := applyVolumeMountGroup(mountPath, gid); err != nil {
+						return statusErrorf(
+							codes.Internal,
+							s.errorInfo(ReasonVolumeMountGroupFailed, "lvname", id, "targetPath", targetPath),
+							"Cannot apply volume mount group: err=%v",
+							err)
+					}
+				}
+			}
+			if idmap != nil {
+				// The mount is idmapped last as it replaces the
+				// mount at mountPath.
+				log.Printf("Idmapping the mount at %v with %v", mountPath, idmap)
+				if err := applyIdmap(mountPath, idmap); err != nil {
+					return statusErrorf(
+						codes.FailedPrecondition,
+						s.errorInfo(ReasonIdmapFailed, "lvname", id, "targetPath", targetPath),
+						"Cannot create idmapped mount: err=%v",
+						err)
+				}
+			}
+			return nil
+		}
+		if err := s.nodePublishVolume_Mount(ctx, lv, sourcePath, targetPath, readonly, fstype, mountOptions, configure); err != nil {
 			return nil, err
 		}
 	default:
@@ -1069,8 +2053,9 @@ func (s *Server) nodePublishVolume_Block(sourcePath, targetPath string, readonly
 	// Check whether something is already mounted at targetPath.
 	mp, err := getMountAt(targetPath)
 	if err != nil {
-		return status.Errorf(
+		return statusErrorf(
 			codes.Internal,
+			s.errorInfo(ReasonMountInfoFailed, "device", sourcePath, "targetPath", targetPath),
 			"Cannot get mount info at %v: err=%v",
 			targetPath, err)
 	}
@@ -1083,20 +2068,22 @@ func (s *Server) nodePublishVolume_Block(sourcePath, targetPath string, readonly
 		// However, the mountpoint root shows the actual device, not
 		// the symlink. As such, to determine whether or not the
 		// device mounted at targetPath is the expected one, we need
-		// to resolve the symlink and compare the targets.
+		// to resolve the symlink and compare the targets or, if the
+		// device was bind mounted through another device node, e.g.,
+		// /dev/mapper/<vg>-<lv>, their device numbers.
 		log.Printf("Following symlinks at %v", sourcePath)
 		sourceDevicePath, err := filepath.EvalSymlinks(sourcePath)
 		if err != nil {
-			return status.Errorf(
+			return statusErrorf(
 				codes.Internal,
+				s.errorInfo(ReasonDeviceMissing, "device", sourcePath),
 				"Failed to follow symlinks at %v: err=%v",
 				sourcePath, err)
 		}
 		log.Printf("Determined that %v -> %v", sourcePath, sourceDevicePath)
 		// For bindmounts, we use the mountpoint root
 		// in the current filesystem.
-		mpdev := "/dev" + mp.root
-		if mpdev != sourceDevicePath {
+		if !lookupBlockDevice(sourcePath).isBoundAt(mp) {
 			return ErrTargetPathNotEmpty
 		}
 		log.Printf("The volume %v is already bind mounted to %v", sourcePath, targetPath)
@@ -1108,29 +2095,34 @@ func (s *Server) nodePublishVolume_Block(sourcePath, targetPath string, readonly
 	// Perform a bind mount of the raw block device. The
 	// `filesystemtype` and `data` parameters to the
 	// mount(2) system call are ignored in this case.
-	flags := uintptr(syscall.MS_BIND)
+	flags := uintptr(msBind)
 	log.Printf("Performing bind mount of %s -> %s", sourcePath, targetPath)
-	if err := syscall.Mount(sourcePath, targetPath, "", flags, ""); err != nil {
+	if err := mount(sourcePath, targetPath, "", flags, ""); err != nil {
 		_, ok := err.(syscall.Errno)
 		if !ok {
-			return status.Errorf(
+			return statusErrorf(
 				codes.Internal,
+				s.errorInfo(ReasonMountFailed, "device", sourcePath, "targetPath", targetPath),
 				"Failed to perform bind mount: err=%v",
 				err)
 		}
-		return status.Errorf(
+		return statusErrorf(
 			codes.FailedPrecondition,
+			s.errorInfo(ReasonMountFailed, "device", sourcePath, "targetPath", targetPath),
 			"Failed to perform bind mount: err=%v",
 			err)
 	}
 	return nil
 }
 
-func (s *Server) nodePublishVolume_Mount(sourcePath, targetPath string, readonly bool, fstype string, mountOptions []string) error {
+// nodePublishVolume_Mount mounts the filesystem of the volume at targetPath
+// and calls configure with the path at which it is mounted before returning.
+func (s *Server) nodePublishVolume_Mount(ctx context.Context, lv *lvm.LogicalVolume, sourcePath, targetPath string, readonly bool, fstype string, mountOptions []string, configure func(mountPath string) error) error {
+	id := lv.Name()
 	log.Printf("Attempting to publish volume %v as MOUNT_DEVICE to %v", sourcePath, targetPath)
 	var flags uintptr
 	if readonly {
-		flags |= syscall.MS_RDONLY
+		flags |= msRdonly
 	}
 	// Request validation ensures that the fstype is in our list of
 	// supported filesystems.
@@ -1144,15 +2136,19 @@ func (s *Server) nodePublishVolume_Mount(sourcePath, targetPath string, readonly
 	log.Printf("Determining mount info at %v", targetPath)
 	mp, err := getMountAt(targetPath)
 	if err != nil {
-		return status.Errorf(
+		return statusErrorf(
 			codes.Internal,
+			s.errorInfo(ReasonMountInfoFailed, "device", sourcePath, "targetPath", targetPath),
 			"Cannot get mount info at %v: err=%v",
 			targetPath, err)
 	}
 	log.Printf("Mount info at %v: %+v", targetPath, mp)
 	if mp != nil {
-		// For regular mounts, we use the mount source.
-		if mp.mountsource != sourcePath {
+		// For regular mounts, we use the mount source or the
+		// device number of the filesystem as the volume may have
+		// been mounted through another path, e.g.,
+		// /dev/mapper/<vg>-<lv>.
+		if !lookupBlockDevice(sourcePath).isMountedAt(mp) {
 			return ErrTargetPathNotEmpty
 		}
 		// Something is mounted at targetPath. We check that
@@ -1175,13 +2171,39 @@ func (s *Server) nodePublishVolume_Mount(sourcePath, targetPath string, readonly
 		// the filesystem at targetPath matches that
 		// which is requested, to support idempotency
 		// we return success.
-		return nil
+		return configure(targetPath)
+	}
+	// If the volume is already published at another target path, the
+	// filesystem cannot be mounted again with different flags. Instead
+	// we bind mount it.
+	published, err := s.findPublishedMount(id, sourcePath, targetPath)
+	if err != nil {
+		return statusErrorf(
+			codes.Internal,
+			s.errorInfo(ReasonMountInfoFailed, "device", sourcePath, "targetPath", targetPath),
+			"Cannot get mount info: err=%v",
+			err)
+	}
+	if published != nil {
+		if err := s.bindMountTarget(published, sourcePath, targetPath, fstype, readonly); err != nil {
+			return err
+		}
+		s.targets.add(id, targetPath, targetInfo{Readonly: readonly})
+		return configure(targetPath)
+	}
+	tags, err := lv.CachedTags()
+	if err != nil {
+		return s.lvmError(err, "Error in CachedTags()", "lvname", id)
 	}
 	log.Printf("Determining filesystem type at %v", sourcePath)
-	existingFstype, err := determineFilesystemType(sourcePath)
+	existingFstype, err := determineFilesystemType(ctx, s.runner, sourcePath)
+	if ptErr, ok := err.(*partitionTableError); ok {
+		return s.partitionedVolumeError(id, sourcePath, ptErr)
+	}
 	if err != nil {
-		return status.Errorf(
+		return statusErrorf(
 			codes.Internal,
+			s.errorInfo(ReasonFilesystemUnknown, "device", sourcePath),
 			"Cannot determine filesystem type: err=%v",
 			err)
 	}
@@ -1191,9 +2213,21 @@ func (s *Server) nodePublishVolume_Mount(sourcePath, targetPath string, readonly
 		// device, format it with the requested
 		// filesystem.
 		log.Printf("The device %v has no existing filesystem, formatting with %v", sourcePath, fstype)
-		if err := formatDevice(sourcePath, fstype); err != nil {
-			return status.Errorf(
+		release, err := s.acquireIOSlot(ctx, "format")
+		if err != nil {
+			return statusErrorf(
 				codes.Internal,
+				s.errorInfo(ReasonFormatFailed, "device", sourcePath, "fstype", fstype),
+				"Cannot acquire I/O slot to format device: err=%v",
+				err)
+		}
+		volname, _ := volumeNameFromTags(tags)
+		err = formatDevice(ctx, s.runner, sourcePath, fstype, filesystemLabel(fstype, volname))
+		release()
+		if err != nil {
+			return statusErrorf(
+				codes.Internal,
+				s.errorInfo(ReasonFormatFailed, "device", sourcePath, "fstype", fstype),
 				"formatDevice failed: err=%v",
 				err)
 		}
@@ -1202,70 +2236,138 @@ func (s *Server) nodePublishVolume_Mount(sourcePath, targetPath string, readonly
 	if fstype != existingFstype {
 		return ErrMismatchedFilesystemType
 	}
+	s.recordFilesystemUUID(ctx, lv, tags, sourcePath)
+	if readonly {
+		mountOptions = withReadonlyMountOptions(mountOptions, s.readonlyMountOptions[fstype])
+	}
 	mountOptionsStr := strings.Join(mountOptions, ",")
+	if s.atomicPublishDir != "" {
+		if err := s.mountAtomically(id, sourcePath, targetPath, fstype, flags, mountOptionsStr, configure); err != nil {
+			return err
+		}
+		s.targets.add(id, targetPath, targetInfo{Readonly: readonly})
+		return nil
+	}
 	// Try to mount the volume by assuming it is correctly formatted.
 	log.Printf("Mounting %v at %v fstype=%v, flags=%v mountOptions=%v", sourcePath, targetPath, fstype, flags, mountOptionsStr)
-	if err := syscall.Mount(sourcePath, targetPath, fstype, flags, mountOptionsStr); err != nil {
+	if err := mount(sourcePath, targetPath, fstype, flags, mountOptionsStr); err != nil {
 		_, ok := err.(syscall.Errno)
 		if !ok {
-			return status.Errorf(
+			return statusErrorf(
 				codes.Internal,
+				s.errorInfo(ReasonMountFailed, "device", sourcePath, "targetPath", targetPath, "fstype", fstype),
 				"Failed to perform mount: err=%v",
 				err)
 		}
-		return status.Errorf(
+		return statusErrorf(
 			codes.FailedPrecondition,
+			s.errorInfo(ReasonMountFailed, "device", sourcePath, "targetPath", targetPath, "fstype", fstype),
 			"Failed to perform mount: err=%v",
 			err)
 	}
-	return nil
+	s.targets.add(id, targetPath, targetInfo{Readonly: readonly})
+	return configure(targetPath)
 }
 
-func determineFilesystemType(devicePath string) (string, error) {
+// Timeouts for the external commands run while publishing a volume.
+const (
+	probeTimeout  = 30 * time.Second
+	formatTimeout = 10 * time.Minute
+)
+
+func determineFilesystemType(ctx context.Context, runner *cmd.Runner, devicePath string) (string, error) {
 	// We use `file -bsL` to determine whether any filesystem type is detected.
 	// If a filesystem is detected (ie., the output is not "data", we use
 	// `blkid` to determine what the filesystem is. We use `blkid` as `file`
 	// has inconvenient output.
 	// We do *not* use `lsblk` as that requires udev to be up-to-date which
 	// is often not the case when a device is erased using `dd`.
-	output, err := exec.Command("file", "-bsL", devicePath).CombinedOutput()
+	output, err := runner.Run(ctx, probeTimeout, "file", "-bsL", devicePath)
 	if err != nil {
 		return "", err
 	}
-	if strings.TrimSpace(string(output)) == "data" {
+	if strings.TrimSpace(string(output.Stdout)) == "data" {
 		// No filesystem detected.
 		return "", nil
 	}
 	// Some filesystem was detected, we use blkid to figure out what it is.
-	output, err = exec.Command("blkid", "-c", "/dev/null", "-o", "export", devicePath).CombinedOutput()
+	output, err = runner.Run(ctx, probeTimeout, "blkid", "-c", "/dev/null", "-o", "export", devicePath)
 	if err != nil {
 		return "", err
 	}
+	return parseBlkidType(string(output.Stdout))
+}
+
+// partitionTableError is returned by determineFilesystemType if the device
+// holds a partition table instead of a filesystem, e.g., because a workload
+// partitioned a block volume.
+type partitionTableError struct {
+	pttype string
+}
+
+func (e *partitionTableError) Error() string {
+	return fmt.Sprintf("The device has a %v partition table instead of a filesystem.", e.pttype)
+}
+
+// parseBlkidType returns the filesystem type given by the output of
+// `blkid -o export`, or a *partitionTableError if it reports a partition
+// table instead.
+func parseBlkidType(output string) (string, error) {
 	parseErr := errors.New("Cannot parse output of blkid.")
-	lines := strings.Split(string(output), "\n")
+	var pttype string
+	lines := strings.Split(output, "\n")
 	for _, line := range lines {
-		fields := strings.Split(strings.TrimSpace(line), "=")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "=", 2)
 		if len(fields) != 2 {
 			return "", parseErr
 		}
-		if fields[0] == "TYPE" {
+		switch fields[0] {
+		case "TYPE":
 			return fields[1], nil
+		case "PTTYPE":
+			pttype = fields[1]
 		}
 	}
+	if pttype != "" {
+		return "", &partitionTableError{pttype}
+	}
 	return "", parseErr
 }
 
-func formatDevice(devicePath, fstype string) error {
+// partitionedVolumeError returns the error with which requests that need a
+// filesystem on a partitioned volume are rejected.
+func (s *Server) partitionedVolumeError(lvname, sourcePath string, err *partitionTableError) error {
+	return statusErrorf(
+		codes.FailedPrecondition,
+		s.errorInfo(ReasonDevicePartitioned, "lvname", lvname, "device", sourcePath, "pttype", err.pttype),
+		"The volume has a %v partition table and can only be published as a block volume",
+		err.pttype)
+}
+
+// formatDevice creates a filesystem of type fstype on the device. If label
+// is not empty the filesystem is labelled with it.
+func formatDevice(ctx context.Context, runner *cmd.Runner, devicePath, fstype, label string) error {
 	// scrub the first 256k of the device to head off any mkfs probe misfires.
-	output, err := exec.Command(
-		"dd", "if=/dev/zero", "of="+devicePath, "bs=512", "count=512", "conv=notrunc",
-	).CombinedOutput()
+	// The zeros bypass the page cache, in blocks that satisfy the
+	// alignment of both 512e and 4Kn devices.
+	_, err := runner.Run(ctx, probeTimeout,
+		"dd", "if=/dev/zero", "of="+devicePath, "bs=4096", "count=64", "oflag=direct", "conv=notrunc",
+	)
 	if err != nil {
-		return errors.New("csilvm: formatDevice: dd failed: err=" + err.Error() + ": " + string(output))
+		return errors.New("csilvm: formatDevice: " + err.Error())
+	}
+	args := []string{"-t", fstype}
+	if label != "" {
+		args = append(args, "-L", label)
 	}
-	output, err = exec.Command("mkfs", "-t", fstype, devicePath).CombinedOutput()
+	args = append(args, devicePath)
+	_, err = runner.Run(ctx, formatTimeout, "mkfs", args...)
 	if err != nil {
-		return errors.New("csilvm: formatDevice: mkfs failed: err=" + err.Error() + ": " + string(output))
+		return errors.New("csilvm: formatDevice: " + err.Error())
 	}
 	return nil
 }
@@ -1273,44 +2375,79 @@ func formatDevice(devicePath, fstype string) error {
 func (s *Server) NodeUnpublishVolume(
 	ctx context.Context,
 	request *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	id := request.GetVolumeId()
+	id := s.nameFromVolumeID(request.GetVolumeId())
 	log.Printf("Looking up volume with id=%v", id)
-	_, err := s.volumeGroup.LookupLogicalVolume(id)
+	lv, err := s.lookupVolume(ctx, id)
 	if err != nil {
-		return nil, ErrVolumeNotFound
+		return nil, s.lvmError(err, "Cannot look up volume", "lvname", id)
 	}
 	targetPath := request.GetTargetPath()
+	published, registered := s.targets.entries(id)[targetPath]
 	log.Printf("Determining mount info at %v", targetPath)
 	mp, err := getMountAt(targetPath)
 	if err != nil {
-		return nil, status.Errorf(
+		return nil, statusErrorf(
 			codes.Internal,
+			s.errorInfo(ReasonMountInfoFailed, "lvname", id, "targetPath", targetPath),
 			"Cannot get mount info at %v: err=%v",
 			targetPath, err)
 	}
 	log.Printf("Mount info at %v: %+v", targetPath, mp)
+	if !registered && mp != nil {
+		// The registry is lost when the plugin restarts without a
+		// state directory.
+		sourcePath, err := lv.Path()
+		if err != nil {
+			return nil, s.lvmError(err, "Error in Path()", "lvname", id)
+		}
+		found, err := s.publications(ctx, id, sourcePath)
+		if err != nil {
+			return nil, statusErrorf(
+				codes.Internal,
+				s.errorInfo(ReasonMountInfoFailed, "lvname", id, "device", sourcePath),
+				"Cannot list the publications of the volume: err=%v",
+				err)
+		}
+		published = found[mp.path]
+	}
 	if mp == nil {
 		log.Printf("Nothing mounted at %v", targetPath)
+		s.targets.remove(id, targetPath)
+		if err := s.releaseReadonlyDevice(ctx, lv, published); err != nil {
+			return nil, err
+		}
 		// There is nothing mounted at targetPath, to support
 		// idempotency we return success.
 		response := &csi.NodeUnpublishVolumeResponse{}
 		return response, nil
 	}
-	const umountFlags = 0
+	if owner, ok := s.targets.owner(targetPath); ok && owner != id {
+		return nil, statusErrorf(
+			codes.FailedPrecondition,
+			s.errorInfo(ReasonTargetPathNotEmpty, "lvname", id, "targetPath", targetPath, "owner", owner),
+			"The targetPath is published for volume %v",
+			owner)
+	}
 	log.Printf("Unmounting %v", targetPath)
-	if err := syscall.Unmount(targetPath, umountFlags); err != nil {
+	if err := s.unmountTarget(ctx, targetPath); err != nil {
 		_, ok := err.(syscall.Errno)
 		if !ok {
-			return nil, status.Errorf(
+			return nil, statusErrorf(
 				codes.Internal,
+				s.errorInfo(ReasonUnmountFailed, "lvname", id, "targetPath", targetPath),
 				"Failed to perform unmount: err=%v",
 				err)
 		}
-		return nil, status.Errorf(
+		return nil, statusErrorf(
 			codes.FailedPrecondition,
+			s.errorInfo(ReasonUnmountFailed, "lvname", id, "targetPath", targetPath),
 			"Failed to perform unmount: err=%v",
 			err)
 	}
+	s.targets.remove(id, targetPath)
+	if err := s.releaseReadonlyDevice(ctx, lv, published); err != nil {
+		return nil, err
+	}
 	response := &csi.NodeUnpublishVolumeResponse{}
 	return response, nil
 }
@@ -1330,15 +2467,109 @@ func (s *Server) NodeGetId(
 func (s *Server) NodeGetInfo(
 	ctx context.Context,
 	request *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	topology := &csi.Topology{
-		Segments: map[string]string{topologyKey: s.nodeID},
-	}
 	return &csi.NodeGetInfoResponse{
 		NodeId:             s.nodeID,
-		AccessibleTopology: topology,
+		MaxVolumesPerNode:  s.maxVolumes,
+		AccessibleTopology: s.nodeTopology(),
 	}, nil
 }
 
+// lookupOrCreatePhysicalVolume looks up the physical volume with the given
+// name, creating it if it does not exist.
+func (s *Server) lookupOrCreatePhysicalVolume(pvname string) (*lvm.PhysicalVolume, error) {
+	log.Printf("Looking up LVM2 physical volume %v", pvname)
+	pv, err := lvm.LookupPhysicalVolume(pvname)
+	if err == nil {
+		log.Printf("Found LVM2 physical volume %v", pvname)
+		if s.forceDeviceInit {
+			// Refuse to use a physical volume that belongs to
+			// another volume group.
+			vgname, err := pv.VolumeGroupName()
+			if err != nil {
+				return nil, fmt.Errorf(
+					"Cannot lookup volume group of physical volume %v: err=%v",
+					pvname, err)
+			}
+			if vgname != "" && vgname != s.vgname {
+				return nil, fmt.Errorf(
+					"Physical volume %v belongs to volume group %v, refusing to initialize it",
+					pvname, vgname)
+			}
+		}
+		return pv, nil
+	}
+	if err != lvm.ErrPhysicalVolumeNotFound {
+		return nil, fmt.Errorf(
+			"Cannot lookup physical volume %v: err=%v",
+			pvname, err)
+	}
+	log.Printf("Cannot find LVM2 physical volume %v", pvname)
+	// The physical volume cannot be found. Try to create it.
+	// First, wipe the partition table on the device in accordance
+	// with the `pvcreate` man page.
+	if err := statDevice(pvname); err != nil {
+		return nil, fmt.Errorf(
+			"Could not stat device %v: err=%v",
+			pvname, err)
+	}
+	log.Printf("Stat device %v", pvname)
+	if s.forceDeviceInit {
+		log.Printf("Checking that %v is not in use", pvname)
+		if err := checkDeviceUnused(pvname); err != nil {
+			return nil, fmt.Errorf(
+				"Refusing to wipe signatures on %v: err=%v",
+				pvname, err)
+		}
+		log.Printf("Wiping all signatures on %v", pvname)
+		if _, err := s.runner.Run(context.Background(), probeTimeout, "wipefs", "--all", pvname); err != nil {
+			return nil, fmt.Errorf(
+				"Cannot wipe signatures on %v: err=%v",
+				pvname, err)
+		}
+	} else {
+		log.Printf("Zeroing partition table on %v", pvname)
+		if err := zeroPartitionTable(pvname); err != nil {
+			return nil, fmt.Errorf(
+				"Cannot zero partition table on %v: err=%v",
+				pvname, err)
+		}
+	}
+	log.Printf("Creating LVM2 physical volume %v", pvname)
+	pv, err = lvm.CreatePhysicalVolume(pvname)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Cannot create LVM2 physical volume %v: err=%v",
+			pvname, err)
+	}
+	log.Printf("Created LVM2 physical volume %v", pvname)
+	return pv, nil
+}
+
+// extendOntoStandbyDevice extends the volume group onto the next standby
+// device. The standby device is consumed even if the extension fails so
+// that a broken device is not retried forever.
+func (s *Server) extendOntoStandbyDevice(ctx context.Context) error {
+	if len(s.standbyDevices) == 0 {
+		return errors.New("csilvm: no standby devices left")
+	}
+	pvname := s.standbyDevices[0]
+	s.standbyDevices = s.standbyDevices[1:]
+	pv, err := s.lookupOrCreatePhysicalVolume(pvname)
+	if err != nil {
+		return err
+	}
+	log.Printf("Extending volume group %v onto standby device %v", s.vgname, pvname)
+	if err := s.volumeGroup.WithContext(ctx).Extend(pv); err != nil {
+		return fmt.Errorf(
+			"Cannot extend volume group %v onto %v: err=%v",
+			s.vgname, pvname, err)
+	}
+	log.Printf("Extended volume group %v onto standby device %v, %d standby devices remaining", s.vgname, pvname, len(s.standbyDevices))
+	s.pvnames = append(s.pvnames, pvname)
+	s.metrics.Counter("standby-extensions").Inc(1)
+	return nil
+}
+
 func zeroPartitionTable(devicePath string) error {
 	// This method is the go equivalent of
 	// `dd if=/dev/zero of=PhysicalVolume bs=512 count=1`.
@@ -1358,6 +2589,29 @@ func statDevice(devicePath string) error {
 	return err
 }
 
+// adoptStandbyDevices moves any standby devices that are already part of
+// the volume group, e.g., due to an extension prior to a restart, to the
+// list of expected physical volumes.
+func (s *Server) adoptStandbyDevices(existing []string) {
+	var standby []string
+	for _, dev := range s.standbyDevices {
+		adopted := false
+		for _, pvname := range existing {
+			if pvname == dev {
+				adopted = true
+				break
+			}
+		}
+		if adopted {
+			log.Printf("Standby device %v is already part of the volume group", dev)
+			s.pvnames = append(s.pvnames, dev)
+			continue
+		}
+		standby = append(standby, dev)
+	}
+	s.standbyDevices = standby
+}
+
 func calculatePVDiff(existing, pvnames []string) (missing, unexpected []string) {
 	for _, epvname := range existing {
 		had := false
@@ -1408,7 +2662,9 @@ func (s *Server) checkVolumeGroupTags(tags []string) error {
 func (s *Server) NodeGetCapabilities(
 	ctx context.Context,
 	request *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	response := &csi.NodeGetCapabilitiesResponse{}
+	response := &csi.NodeGetCapabilitiesResponse{
+		Capabilities: s.serviceCapabilities().nodeCapabilities(),
+	}
 	return response, nil
 }
 
@@ -1437,6 +2693,18 @@ func takeVolumeLayoutFromParameters(params map[string]string) (layout lvm.Volume
 			return layout, errors.New("The 'type' parameter must be one of 'linear' or 'raid1'.")
 		}
 	}
+	sstripes, ok := params["stripes"]
+	if ok {
+		delete(params, "stripes")
+		stripes, err := strconv.ParseUint(sstripes, 10, 64)
+		if err != nil || stripes < 1 {
+			return layout, fmt.Errorf("The 'stripes' parameter must be a positive integer: err=%v", err)
+		}
+		// A single stripe is the same as no striping.
+		if stripes > 1 {
+			layout.Stripes = stripes
+		}
+	}
 	return layout, nil
 }
 
@@ -1478,37 +2746,308 @@ func volumeOptsFromParameters(in map[string]string) (opts []lvm.CreateLogicalVol
 // volumes in parallel where calls to `lvs` appear to hang.
 //
 // See https://jira.mesosphere.com/browse/DCOS_OSS-4642
-func SerializingInterceptor() grpc.UnaryServerInterceptor {
-	// Instead of a mutex, use a weighted semaphore because it's sensitive to context cancellation and/or deadline
-	// expiration, which is important for maintaining a healthy request queue, and also helps prevent execution of
-	// operations that the calling CO is no longer interested in.
-	sem := semaphore.NewWeighted(1)
+func SerializingInterceptor(opts ...InterceptorOpt) grpc.UnaryServerInterceptor {
+	o := newInterceptorOpts(opts)
+	ser := o.serializer
+	if ser == nil {
+		ser = NewRequestSerializer()
+	}
+	var queued int64
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		err := sem.Acquire(ctx, 1)
+		o.metrics.SubScope("requests").Gauge("queued").Update(float64(atomic.AddInt64(&queued, 1)))
+		start := time.Now()
+		err := ser.acquire(ctx)
+		o.metrics.SubScope("requests").Gauge("queued").Update(float64(atomic.AddInt64(&queued, -1)))
+		o.methodScope(info).SubScope("requests").Histogram("queue_wait", queueWaitBuckets).RecordDuration(time.Since(start))
 		if err != nil {
 			return nil, err
 		}
 		// Acquire can still succeed if the context is canceled, double-check it.
 		select {
 		case <-ctx.Done():
-			sem.Release(1)
+			ser.sem.Release(1)
 			return nil, ctx.Err()
 		default:
 		}
-		defer sem.Release(1)
+		defer ser.sem.Release(1)
 		return handler(ctx, req)
 	}
 }
 
+// RequestSerializer serializes all RPCs. It is shared by
+// SerializingInterceptor and the Server, see Serializer, so that the
+// background tasks of the Server that run LVM commands are serialized with
+// the requests too.
+type RequestSerializer struct {
+	// Instead of a mutex, use a weighted semaphore because it's sensitive to context cancellation and/or deadline
+	// expiration, which is important for maintaining a healthy request queue, and also helps prevent execution of
+	// operations that the calling CO is no longer interested in.
+	sem *semaphore.Weighted
+	// handedOff is closed once the requests are handed off, see Drain.
+	handedOff chan struct{}
+}
+
+// NewRequestSerializer returns a RequestSerializer.
+func NewRequestSerializer() *RequestSerializer {
+	return &RequestSerializer{
+		sem:       semaphore.NewWeighted(1),
+		handedOff: make(chan struct{}),
+	}
+}
+
+// ErrHandedOff is returned for the requests once they are handed off to
+// another plugin process, see Drain.
+var ErrHandedOff = statusError(codes.Unavailable, newErrorInfo(ReasonHandedOff), "The plugin handed off its requests to another process. Please retry.")
+
+// Drain waits for the in-flight request, or background task of the Server,
+// to finish and makes the following ones wait, e.g., while another plugin
+// process sets up to take over. The returned function lets them continue if
+// handedOff is false and otherwise fails them, and any later ones, with
+// ErrHandedOff so that the CO retries them against the other process.
+func (s *RequestSerializer) Drain(ctx context.Context) (resume func(handedOff bool), err error) {
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return func(handedOff bool) {
+		if handedOff {
+			close(s.handedOff)
+			return
+		}
+		s.sem.Release(1)
+	}, nil
+}
+
+// acquire acquires the semaphore unless ctx is done or the requests are
+// handed off.
+func (s *RequestSerializer) acquire(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.handedOff:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		select {
+		case <-s.handedOff:
+			return ErrHandedOff
+		default:
+		}
+		return err
+	}
+	return nil
+}
+
+// serialize calls f once the in-flight request, or other background task,
+// is done, see Serializer.
+func (s *Server) serialize(ctx context.Context, f func() error) error {
+	if err := s.serializer.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.serializer.sem.Release(1)
+	return f()
+}
+
 // RequestLimitInterceptor limits the number of pending requests in flight at any given time. If an incoming request
-// would exceed the specified requestLimit then an Unavailable gRPC error is returned.
-func RequestLimitInterceptor(requestLimit int) grpc.UnaryServerInterceptor {
-	sem := semaphore.NewWeighted(int64(requestLimit))
+// would exceed the specified requestLimit then an Unavailable gRPC error is returned. Requests for methods given to
+// MethodRequestLimits are limited by their own limit instead.
+func RequestLimitInterceptor(requestLimit int, opts ...InterceptorOpt) grpc.UnaryServerInterceptor {
+	o := newInterceptorOpts(opts)
+	global := &requestLimiter{limit: requestLimit, sem: semaphore.NewWeighted(int64(requestLimit))}
+	methods := make(map[string]*requestLimiter)
+	for method, limit := range o.methodRequestLimits {
+		methods[method] = &requestLimiter{limit: limit, sem: semaphore.NewWeighted(int64(limit))}
+	}
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if !sem.TryAcquire(1) {
-			return nil, status.Error(codes.Unavailable, "Too many pending requests. Please retry later.")
+		limiter, ok := methods[methodName(info)]
+		scope := o.metrics
+		if ok {
+			// The pending requests of a method with its own
+			// limit are reported separately.
+			scope = o.methodScope(info)
+		} else {
+			limiter = global
+		}
+		if !limiter.sem.TryAcquire(1) {
+			log.Printf("Rejecting request %v, %d requests are pending", methodName(info), limiter.limit)
+			o.methodScope(info).SubScope("requests").Counter("rejected").Inc(1)
+			return nil, statusError(codes.Unavailable, newErrorInfo(ReasonTooManyRequests), "Too many pending requests. Please retry later.")
+		}
+		n := atomic.AddInt64(&limiter.pending, 1)
+		scope.SubScope("requests").Gauge("pending").Update(float64(n))
+		if limiter == global && o.softRequestLimit > 0 && n == int64(o.softRequestLimit)+1 {
+			log.Printf("WARNING: %d requests are pending, more than the soft limit of %d (request-limit is %d)", n, o.softRequestLimit, requestLimit)
 		}
-		defer sem.Release(1)
+		defer func() {
+			scope.SubScope("requests").Gauge("pending").Update(float64(atomic.AddInt64(&limiter.pending, -1)))
+			limiter.sem.Release(1)
+		}()
 		return handler(ctx, req)
 	}
 }
+
+// requestLimiter admits up to limit pending requests.
+type requestLimiter struct {
+	// pending is first so that it is 64-bit aligned for atomic.
+	pending int64
+	limit   int
+	sem     *semaphore.Weighted
+}
+
+// queueWaitBuckets are the buckets of the histogram of the time requests
+// wait for their turn, from 10ms to about 5 minutes.
+var queueWaitBuckets = tally.MustMakeExponentialDurationBuckets(10*time.Millisecond, 2, 16)
+
+// InterceptorOpt configures RequestLimitInterceptor and
+// SerializingInterceptor.
+type InterceptorOpt func(*interceptorOpts)
+
+type interceptorOpts struct {
+	metrics             tally.Scope
+	softRequestLimit    int
+	methodRequestLimits map[string]int
+	volumeSlices        int
+	serializer          *RequestSerializer
+}
+
+func newInterceptorOpts(opts []InterceptorOpt) *interceptorOpts {
+	o := &interceptorOpts{metrics: tally.NoopScope}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// methodScope returns the metrics scope tagged with the RPC name.
+func (o *interceptorOpts) methodScope(info *grpc.UnaryServerInfo) tally.Scope {
+	if info == nil {
+		return o.metrics
+	}
+	return o.metrics.Tagged(map[string]string{"method": info.FullMethod})
+}
+
+// InterceptorMetrics reports the number of pending, queued and rejected
+// requests and the time requests wait for their turn to scope.
+func InterceptorMetrics(scope tally.Scope) InterceptorOpt {
+	return func(o *interceptorOpts) {
+		o.metrics = scope
+	}
+}
+
+// InterceptorSerializer makes SerializingInterceptor serialize requests
+// with ser, e.g., the one given to the Server with the Serializer option.
+func InterceptorSerializer(ser *RequestSerializer) InterceptorOpt {
+	return func(o *interceptorOpts) {
+		o.serializer = ser
+	}
+}
+
+// SoftRequestLimit causes RequestLimitInterceptor to log a warning when more
+// than n requests are pending so that operators notice that the request
+// limit is almost reached before requests are rejected.
+func SoftRequestLimit(n int) InterceptorOpt {
+	return func(o *interceptorOpts) {
+		o.softRequestLimit = n
+	}
+}
+
+// MethodRequestLimits gives the methods their own limits on the number of
+// pending requests, e.g., so that a wave of DeleteVolume requests does not
+// cause GetCapacity requests to be rejected. The keys are gRPC method names,
+// e.g., "CreateVolume", and the limits must be positive. Requests for these
+// methods do not count towards the limit given to RequestLimitInterceptor.
+func MethodRequestLimits(limits map[string]int) InterceptorOpt {
+	return func(o *interceptorOpts) {
+		o.methodRequestLimits = limits
+	}
+}
+
+// IsMethod returns whether name is the name of a CSI RPC, e.g.,
+// "CreateVolume".
+func IsMethod(name string) bool {
+	for _, service := range []reflect.Type{
+		reflect.TypeOf((*csi.IdentityServer)(nil)).Elem(),
+		reflect.TypeOf((*csi.ControllerServer)(nil)).Elem(),
+		reflect.TypeOf((*csi.NodeServer)(nil)).Elem(),
+	} {
+		if _, ok := service.MethodByName(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// methodName returns the short RPC name, e.g., "CreateVolume".
+func methodName(info *grpc.UnaryServerInfo) string {
+	if info == nil {
+		return ""
+	}
+	return info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+}
+
+// DefaultTimeouts returns the default per-RPC timeouts used with
+// TimeoutInterceptor. The keys are gRPC method names, e.g., "CreateVolume".
+// DeleteVolume and NodePublishVolume have long timeouts: the former zeroes
+// the entire volume and the latter creates the filesystem of a new volume,
+// both of which take time in proportion to the size of the volume. The
+// timeouts only keep a hung command from blocking the requests forever.
+func DefaultTimeouts() map[string]time.Duration {
+	return map[string]time.Duration{
+		"CreateVolume":        2 * time.Minute,
+		"DeleteVolume":        4 * time.Hour,
+		"NodePublishVolume":   time.Hour,
+		"NodeUnpublishVolume": 30 * time.Second,
+	}
+}
+
+// TimeoutInterceptor enforces a deadline on each request. The timeouts are
+// keyed by gRPC method name, e.g., "CreateVolume". Methods without a
+// positive timeout are only bounded by the deadline set by the caller, if
+// any. When the deadline expires or the request is canceled, the LVM and
+// other commands run with the request's context are interrupted and
+// DeadlineExceeded or Canceled is returned once the handler has returned.
+// Commands that are not run on behalf of the request, e.g., those run by
+// Setup or the trash reaper, are left alone. DeadlineExceeded or Canceled is
+// also returned if the handler fails after the request stopped, e.g.,
+// because one of its commands was interrupted.
+//
+// This interceptor should follow the SerializingInterceptor so that
+// requests waiting for their turn do not consume their timeout and so that
+// the handler of an expired request returns before the next one starts.
+func TimeoutInterceptor(timeouts map[string]time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method := methodName(info)
+		if timeout := timeouts[method]; timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		resp, err := handler(ctx, req)
+		if ctx.Err() != nil && err != nil {
+			// The handler failed because the request stopped,
+			// e.g., its LVM commands were interrupted.
+			log.Printf("Request %v stopped: err=%v", method, err)
+			return nil, contextError(ctx, method)
+		}
+		return resp, err
+	}
+}
+
+// contextError returns the error for a request of the given method whose
+// context is done.
+func contextError(ctx context.Context, method string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return statusErrorf(
+			codes.DeadlineExceeded,
+			newErrorInfo(ReasonDeadlineExceeded, "method", method),
+			"The %v request did not complete in time.",
+			method)
+	}
+	return statusErrorf(
+		codes.Canceled,
+		newErrorInfo(ReasonCanceled, "method", method),
+		"The %v request was canceled.",
+		method)
+}
@@ -9,6 +9,9 @@ import (
 	"testing"
 	"time"
 
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/uber-go/tally"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -66,6 +69,169 @@ func TestRequestLimitInterceptor(t *testing.T) {
 	}
 }
 
+func TestRequestLimitInterceptorMetrics(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	limiter := RequestLimitInterceptor(1, InterceptorMetrics(scope), SoftRequestLimit(0))
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Controller/CreateVolume"}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := limiter(context.Background(), nil, info, handler)
+		done <- err
+	}()
+	<-started
+	snap := scope.Snapshot()
+	if got := snapshotGauge(snap, "requests.pending"); got != 1 {
+		t.Fatalf("expected 1 pending request instead of %v", got)
+	}
+	if _, err := limiter(context.Background(), nil, info, handler); status.Code(err) != codes.Unavailable {
+		t.Fatal("expected the request to be rejected", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	snap = scope.Snapshot()
+	if got := snapshotGauge(snap, "requests.pending"); got != 0 {
+		t.Fatalf("expected 0 pending requests instead of %v", got)
+	}
+	for _, c := range snap.Counters() {
+		if c.Name() == "requests.rejected" {
+			if c.Value() != 1 || c.Tags()["method"] != info.FullMethod {
+				t.Fatalf("expected 1 rejected %v request instead of %v %v", info.FullMethod, c.Value(), c.Tags())
+			}
+			return
+		}
+	}
+	t.Fatal("expected the requests.rejected counter")
+}
+
+func TestRequestLimitInterceptorMethodLimits(t *testing.T) {
+	limiter := RequestLimitInterceptor(1, MethodRequestLimits(map[string]int{"CreateVolume": 2}))
+	createVolume := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Controller/CreateVolume"}
+	getCapacity := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Controller/GetCapacity"}
+	var started sync.WaitGroup
+	release := make(chan struct{})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		started.Done()
+		<-release
+		return nil, nil
+	}
+	var done sync.WaitGroup
+	var errs int32
+	call := func(info *grpc.UnaryServerInfo) {
+		started.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			if _, err := limiter(context.Background(), nil, info, handler); err != nil {
+				atomic.AddInt32(&errs, 1)
+			}
+		}()
+	}
+	// Two CreateVolume requests and one GetCapacity request are
+	// admitted at the same time.
+	call(createVolume)
+	call(createVolume)
+	call(getCapacity)
+	started.Wait()
+	if _, err := limiter(context.Background(), nil, createVolume, handler); status.Code(err) != codes.Unavailable {
+		t.Fatal("expected the third CreateVolume request to be rejected", err)
+	}
+	if _, err := limiter(context.Background(), nil, getCapacity, handler); status.Code(err) != codes.Unavailable {
+		t.Fatal("expected the second GetCapacity request to be rejected", err)
+	}
+	close(release)
+	done.Wait()
+	if errs != 0 {
+		t.Fatalf("expected the admitted requests to succeed, %d failed", errs)
+	}
+}
+
+func TestSerializingInterceptorMetrics(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	serializer := SerializingInterceptor(InterceptorMetrics(scope))
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Controller/DeleteVolume"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	if _, err := serializer(context.Background(), nil, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	snap := scope.Snapshot()
+	if got := snapshotGauge(snap, "requests.queued"); got != 0 {
+		t.Fatalf("expected 0 queued requests instead of %v", got)
+	}
+	for _, h := range snap.Histograms() {
+		if h.Name() == "requests.queue_wait" && h.Tags()["method"] == info.FullMethod {
+			return
+		}
+	}
+	t.Fatal("expected the requests.queue_wait histogram")
+}
+
+func TestSerializingInterceptorReadsQueueBehindWrites(t *testing.T) {
+	serializer := SerializingInterceptor()
+	deleteVolume := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Controller/DeleteVolume"}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := serializer(context.Background(), nil, deleteVolume, func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+		done <- err
+	}()
+	<-started
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	// Running lvs while lvremove runs can hang, see DCOS_OSS-4642.
+	for _, method := range []string{"/csi.v0.Identity/Probe", "/csi.v0.Controller/ListVolumes", "/csi.v0.Controller/GetCapacity"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := serializer(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected %v to queue behind DeleteVolume instead of %v", method, err)
+		}
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsMethod(t *testing.T) {
+	for _, name := range []string{"Probe", "CreateVolume", "NodePublishVolume"} {
+		if !IsMethod(name) {
+			t.Fatalf("expected %v to be a CSI RPC", name)
+		}
+	}
+	for _, name := range []string{"", "createVolume", "CreateVolumes", "Controller"} {
+		if IsMethod(name) {
+			t.Fatalf("expected %q not to be a CSI RPC", name)
+		}
+	}
+}
+
+// snapshotGauge returns the value of the named gauge in snap.
+func snapshotGauge(snap tally.Snapshot, name string) float64 {
+	for _, g := range snap.Gauges() {
+		if g.Name() == name {
+			return g.Value()
+		}
+	}
+	return -1
+}
+
 func TestSerializingInterceptor(t *testing.T) {
 	const workers = 100
 	var g sync.WaitGroup
@@ -245,3 +411,170 @@ func TestRequestQueuingWithInterceptors(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestTimeoutInterceptor(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	ti := TimeoutInterceptor(map[string]time.Duration{"CreateVolume": 10 * time.Millisecond})
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Controller/CreateVolume"}
+	_, err := ti(context.Background(), nil, info, handler)
+	if c := status.Code(err); c != codes.DeadlineExceeded {
+		t.Fatalf("expected code %v instead of %v", codes.DeadlineExceeded, c)
+	}
+	reason, ok := ErrorReason(err)
+	if !ok || reason.Reason != ReasonDeadlineExceeded {
+		t.Fatalf("expected reason %v instead of %v", ReasonDeadlineExceeded, reason)
+	}
+}
+
+func TestTimeoutInterceptorCanceled(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ti := TimeoutInterceptor(DefaultTimeouts())
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Controller/DeleteVolume"}
+	_, err := ti(ctx, nil, info, handler)
+	if c := status.Code(err); c != codes.Canceled {
+		t.Fatalf("expected code %v instead of %v", codes.Canceled, c)
+	}
+}
+
+func TestTimeoutInterceptorHandlerFailed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		// The handler notices that the request stopped before
+		// the interceptor does.
+		cancel()
+		return nil, status.Error(codes.Internal, "lvm command interrupted")
+	}
+	ti := TimeoutInterceptor(DefaultTimeouts())
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Controller/DeleteVolume"}
+	_, err := ti(ctx, nil, info, handler)
+	if c := status.Code(err); c != codes.Canceled {
+		t.Fatalf("expected code %v instead of %v", codes.Canceled, c)
+	}
+}
+
+func TestTimeoutInterceptorNoTimeout(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return nil, errors.New("unexpected deadline")
+		}
+		return "ok", nil
+	}
+	ti := TimeoutInterceptor(DefaultTimeouts())
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Identity/Probe"}
+	resp, err := ti(context.Background(), nil, info, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != "ok" {
+		t.Fatalf("unexpected response %v", resp)
+	}
+}
+
+func TestWipeRemaining(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	s := &Server{metrics: scope}
+	gauge := func() float64 {
+		for _, g := range scope.Snapshot().Gauges() {
+			if g.Name() == "wipe-bytes-remaining" {
+				return g.Value()
+			}
+		}
+		t.Fatal("missing wipe-bytes-remaining gauge")
+		return 0
+	}
+	// Two concurrent wipes are reported as their sum.
+	w1, w2 := &wipeRemaining{s: s}, &wipeRemaining{s: s}
+	w1.update(100)
+	w2.update(50)
+	if v := gauge(); v != 150 {
+		t.Fatalf("expected 150 bytes remaining but got %v", v)
+	}
+	w1.update(40)
+	if v := gauge(); v != 90 {
+		t.Fatalf("expected 90 bytes remaining but got %v", v)
+	}
+	// The first wipe completes while the second is still running.
+	w1.update(0)
+	if v := gauge(); v != 50 {
+		t.Fatalf("expected 50 bytes remaining but got %v", v)
+	}
+	w2.update(0)
+	if v := gauge(); v != 0 {
+		t.Fatalf("expected no bytes remaining but got %v", v)
+	}
+}
+
+func TestDefaultTimeouts(t *testing.T) {
+	// Wiping and formatting take time in proportion to the size of the
+	// volume so these RPCs have long but finite timeouts.
+	timeouts := DefaultTimeouts()
+	for _, method := range []string{"DeleteVolume", "NodePublishVolume"} {
+		if timeout := timeouts[method]; timeout < time.Hour {
+			t.Errorf("expected a default timeout of at least an hour for %v but got %v", method, timeout)
+		}
+	}
+}
+
+func TestSizeInCapacityRange(t *testing.T) {
+	const extent = 4 << 20
+	for _, tt := range []struct {
+		size          uint64
+		capacityRange *csi.CapacityRange
+		exp           uint64
+		ok            bool
+	}{
+		// No capacity_range or no limit_bytes.
+		{10 * extent, nil, 10 * extent, true},
+		{2 * extent, &csi.CapacityRange{RequiredBytes: extent + 1}, 2 * extent, true},
+		// The rounded up size is within limit_bytes.
+		{2 * extent, &csi.CapacityRange{RequiredBytes: extent + 1, LimitBytes: 2 * extent}, 2 * extent, true},
+		{extent, &csi.CapacityRange{RequiredBytes: extent, LimitBytes: extent}, extent, true},
+		// Rounding up exceeds limit_bytes.
+		{2 * extent, &csi.CapacityRange{RequiredBytes: extent + 1, LimitBytes: 2*extent - 1}, 0, false},
+		// Without required_bytes the size is a single extent.
+		{10 * extent, &csi.CapacityRange{LimitBytes: 3*extent + 1}, extent, true},
+		{10 * extent, &csi.CapacityRange{LimitBytes: 20 * extent}, extent, true},
+		{10 * extent, &csi.CapacityRange{LimitBytes: extent - 1}, 0, false},
+		{10 * extent, &csi.CapacityRange{LimitBytes: extent}, extent, true},
+		// A generous limit_bytes does not grow the volume.
+		{extent, &csi.CapacityRange{RequiredBytes: extent, LimitBytes: 3 * extent}, extent, true},
+		{2 * extent, &csi.CapacityRange{RequiredBytes: extent + 1, LimitBytes: 3*extent - 1}, 2 * extent, true},
+	} {
+		size, ok := sizeInCapacityRange(tt.size, extent, tt.capacityRange)
+		if size != tt.exp || ok != tt.ok {
+			t.Fatalf("%v in %v: expected %v, %v but got %v, %v", tt.size, tt.capacityRange, tt.exp, tt.ok, size, ok)
+		}
+	}
+}
+
+func TestParseBlkidType(t *testing.T) {
+	for _, tt := range []struct {
+		output string
+		fstype string
+		pttype string
+		err    bool
+	}{
+		{"DEVNAME=/dev/dm-3\nUUID=0f5a3c4e-6b1d-4f6e-9a43-3c2d1b0e9f87\nTYPE=xfs\n", "xfs", "", false},
+		{"DEVNAME=/dev/dm-3\nPTUUID=78563412\nPTTYPE=dos\n", "", "dos", true},
+		{"DEVNAME=/dev/dm-3\nPTUUID=5c3f-aa\nPTTYPE=gpt\n", "", "gpt", true},
+		{"DEVNAME=/dev/dm-3\n", "", "", true},
+		{"garbage\n", "", "", true},
+	} {
+		fstype, err := parseBlkidType(tt.output)
+		if (err != nil) != tt.err || fstype != tt.fstype {
+			t.Fatalf("%q: expected %q and error %v, got %q and %v", tt.output, tt.fstype, tt.err, fstype, err)
+		}
+		ptErr, ok := err.(*partitionTableError)
+		if ok != (tt.pttype != "") || (ok && ptErr.pttype != tt.pttype) {
+			t.Fatalf("%q: expected partition table %q, got %v", tt.output, tt.pttype, err)
+		}
+	}
+}
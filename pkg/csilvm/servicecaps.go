@@ -0,0 +1,98 @@
+package csilvm
+
+import (
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+)
+
+// features are the optional features of the server that determine the
+// capabilities it reports. Every capability that depends on the server's
+// configuration is derived from them in one place so that
+// GetPluginCapabilities, ControllerGetCapabilities and NodeGetCapabilities
+// remain consistent.
+type features struct {
+	// topology is set by the Topology option.
+	topology bool
+}
+
+// features returns the optional features enabled by the server's options.
+func (s *Server) features() features {
+	return features{
+		topology: s.topology,
+	}
+}
+
+// serviceCapabilities are the capabilities reported by
+// GetPluginCapabilities, ControllerGetCapabilities and NodeGetCapabilities.
+type serviceCapabilities struct {
+	plugin     []csi.PluginCapability_Service_Type
+	controller []csi.ControllerServiceCapability_RPC_Type
+	node       []csi.NodeServiceCapability_RPC_Type
+}
+
+// serviceCapabilities returns the capabilities of the server.
+func (s *Server) serviceCapabilities() serviceCapabilities {
+	return s.features().serviceCapabilities()
+}
+
+// serviceCapabilities returns the capabilities of a server with the
+// features. Optional features, e.g., snapshots (CREATE_DELETE_SNAPSHOT and
+// LIST_SNAPSHOTS) or staging (STAGE_UNSTAGE_VOLUME), are not implemented
+// and are therefore not reported. The vendored CSI spec predates volume
+// expansion.
+func (f features) serviceCapabilities() serviceCapabilities {
+	var caps serviceCapabilities
+	caps.controller = append(caps.controller,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		// PUBLISH_UNPUBLISH_VOLUME is not supported by the
+		// Controller service. This is performed by the Node
+		// service for the Logical Volume Service.
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+	)
+	caps.node = append(caps.node,
+		nodeCapabilityVolumeMountGroup,
+	)
+	if len(caps.controller) > 0 {
+		caps.plugin = append(caps.plugin, csi.PluginCapability_Service_CONTROLLER_SERVICE)
+	}
+	if f.topology {
+		caps.plugin = append(caps.plugin, csi.PluginCapability_Service_ACCESSIBILITY_CONSTRAINTS)
+	}
+	return caps
+}
+
+func (c serviceCapabilities) pluginCapabilities() []*csi.PluginCapability {
+	var capabilities []*csi.PluginCapability
+	for _, typ := range c.plugin {
+		capabilities = append(capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{Type: typ},
+			},
+		})
+	}
+	return capabilities
+}
+
+func (c serviceCapabilities) controllerCapabilities() []*csi.ControllerServiceCapability {
+	var capabilities []*csi.ControllerServiceCapability
+	for _, typ := range c.controller {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{Type: typ},
+			},
+		})
+	}
+	return capabilities
+}
+
+func (c serviceCapabilities) nodeCapabilities() []*csi.NodeServiceCapability {
+	var capabilities []*csi.NodeServiceCapability
+	for _, typ := range c.node {
+		capabilities = append(capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{Type: typ},
+			},
+		})
+	}
+	return capabilities
+}
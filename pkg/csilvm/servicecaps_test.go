@@ -0,0 +1,84 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+)
+
+func TestServiceCapabilities(t *testing.T) {
+	s := NewServer("vg", nil, "xfs")
+	caps := s.serviceCapabilities()
+	plugin := caps.pluginCapabilities()
+	if len(plugin) != 1 || plugin[0].GetService().GetType() != csi.PluginCapability_Service_CONTROLLER_SERVICE {
+		t.Fatalf("expected only CONTROLLER_SERVICE instead of %v", plugin)
+	}
+	controller := caps.controllerCapabilities()
+	if len(controller) != len(caps.controller) {
+		t.Fatalf("expected %d controller capabilities instead of %v", len(caps.controller), controller)
+	}
+	for i, c := range controller {
+		if c.GetRpc().GetType() != caps.controller[i] {
+			t.Fatalf("expected %v instead of %v", caps.controller[i], c.GetRpc().GetType())
+		}
+		switch c.GetRpc().GetType() {
+		case csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+			csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME:
+			t.Fatalf("unexpected unimplemented capability %v", c.GetRpc().GetType())
+		}
+	}
+	for _, c := range controller {
+		if _, ok := csi.ControllerServiceCapability_RPC_Type_name[int32(c.GetRpc().GetType())]; !ok {
+			t.Fatalf("unexpected controller capability %v unknown to the vendored spec", c.GetRpc().GetType())
+		}
+	}
+	node := caps.nodeCapabilities()
+	if len(node) != 1 || node[0].GetRpc().GetType() != nodeCapabilityVolumeMountGroup {
+		t.Fatalf("expected only the VOLUME_MOUNT_GROUP node capability instead of %v", node)
+	}
+}
+
+// allFeatures returns every combination of the optional features.
+func allFeatures() []features {
+	var all []features
+	for _, topology := range []bool{false, true} {
+		all = append(all, features{topology: topology})
+	}
+	return all
+}
+
+func TestFeatureCapabilities(t *testing.T) {
+	base := NewServer("vg", nil, "xfs").serviceCapabilities()
+	for _, f := range allFeatures() {
+		caps := f.serviceCapabilities()
+		var plugin []csi.PluginCapability_Service_Type
+		for _, c := range caps.pluginCapabilities() {
+			plugin = append(plugin, c.GetService().GetType())
+		}
+		exp := []csi.PluginCapability_Service_Type{csi.PluginCapability_Service_CONTROLLER_SERVICE}
+		if f.topology {
+			exp = append(exp, csi.PluginCapability_Service_ACCESSIBILITY_CONSTRAINTS)
+		}
+		if !reflect.DeepEqual(plugin, exp) {
+			t.Fatalf("%+v: expected plugin capabilities %v instead of %v", f, exp, plugin)
+		}
+		// No feature changes the controller or node capabilities yet.
+		if !reflect.DeepEqual(caps.controller, base.controller) {
+			t.Fatalf("%+v: expected controller capabilities %v instead of %v", f, base.controller, caps.controller)
+		}
+		if !reflect.DeepEqual(caps.node, base.node) {
+			t.Fatalf("%+v: expected node capabilities %v instead of %v", f, base.node, caps.node)
+		}
+	}
+}
+
+func TestServerFeatures(t *testing.T) {
+	if f := NewServer("vg", nil, "xfs").features(); f != (features{}) {
+		t.Fatalf("expected no features by default instead of %+v", f)
+	}
+	if f := NewServer("vg", nil, "xfs", Topology()).features(); !f.topology {
+		t.Fatalf("expected the topology feature instead of %+v", f)
+	}
+}
@@ -0,0 +1,268 @@
+package csilvm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+)
+
+// SoakConfig configures Soak.
+type SoakConfig struct {
+	// NamePrefix prefixes the names of the created volumes so that
+	// concurrent soak tests do not collide.
+	NamePrefix string `json:"namePrefix"`
+	// Volumes is the number of volumes that are created, e.g., several
+	// hundred.
+	Volumes int `json:"volumes"`
+	// VolumeBytes is the requested size of each volume.
+	VolumeBytes int64 `json:"volumeBytes"`
+	// Concurrency is the number of RPCs that are in flight at a time.
+	Concurrency int `json:"concurrency"`
+	// Lists is the number of times all volumes are listed once they have
+	// been created.
+	Lists int `json:"lists"`
+	// TargetDir, if set, is the directory in which every volume is
+	// published as a block volume and unpublished again. It must be on
+	// the node of the plugin.
+	TargetDir string `json:"targetDir,omitempty"`
+	// Timeout bounds each RPC.
+	Timeout time.Duration `json:"timeout"`
+}
+
+// SoakReport is the result of a soak test. It is produced by `csilvm soak`.
+type SoakReport struct {
+	StartedAt      time.Time  `json:"startedAt"`
+	ElapsedSeconds float64    `json:"elapsedSeconds"`
+	Config         SoakConfig `json:"config"`
+	// Latencies describes the latency distribution of each RPC.
+	Latencies map[string]LatencySummary `json:"latencies"`
+	// Errors lists the failed RPCs.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// LatencySummary describes the latency distribution of the calls of an RPC.
+// The latencies are in milliseconds.
+type LatencySummary struct {
+	Count  int     `json:"count"`
+	Errors int     `json:"errors"`
+	MinMs  float64 `json:"minMs"`
+	P50Ms  float64 `json:"p50Ms"`
+	P90Ms  float64 `json:"p90Ms"`
+	P99Ms  float64 `json:"p99Ms"`
+	MaxMs  float64 `json:"maxMs"`
+	MeanMs float64 `json:"meanMs"`
+}
+
+// summarizeLatencies returns the summary of the given latencies, which are
+// sorted in place. Percentiles are computed with the nearest-rank method.
+func summarizeLatencies(latencies []time.Duration, errors int) LatencySummary {
+	summary := LatencySummary{Count: len(latencies), Errors: errors}
+	if len(latencies) == 0 {
+		return summary
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	percentile := func(p int) float64 {
+		rank := (p*len(latencies) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+		return ms(latencies[rank-1])
+	}
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	summary.MinMs = ms(latencies[0])
+	summary.P50Ms = percentile(50)
+	summary.P90Ms = percentile(90)
+	summary.P99Ms = percentile(99)
+	summary.MaxMs = ms(latencies[len(latencies)-1])
+	summary.MeanMs = ms(total / time.Duration(len(latencies)))
+	return summary
+}
+
+// soakRecorder collects the latencies and errors of the RPCs of a soak
+// test.
+type soakRecorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	messages  []string
+}
+
+func (r *soakRecorder) record(rpc string, d time.Duration, what string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[rpc] = append(r.latencies[rpc], d)
+	if err != nil {
+		r.errors[rpc]++
+		r.messages = append(r.messages, fmt.Sprintf("%s %s: %v", rpc, what, err))
+	}
+}
+
+// fail records an error that occurred before the RPC was called.
+func (r *soakRecorder) fail(rpc, what string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, fmt.Sprintf("%s %s: %v", rpc, what, err))
+}
+
+// call calls fn with a context bounded by the RPC timeout and records its
+// latency.
+func (r *soakRecorder) call(ctx context.Context, timeout time.Duration, rpc, what string, fn func(context.Context) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err := fn(ctx)
+	r.record(rpc, time.Since(start), what, err)
+	return err
+}
+
+// Soak creates, lists, optionally publishes and unpublishes, and deletes
+// many volumes using the running plugin to measure the latency of each RPC
+// under load, e.g., to catch regressions in the lvm package before a
+// release. The volumes that were created are deleted even if other RPCs
+// fail. Failures are recorded in the report rather than aborting the test.
+func Soak(ctx context.Context, client *Client, config SoakConfig) *SoakReport {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	report := &SoakReport{StartedAt: time.Now().UTC(), Config: config}
+	r := &soakRecorder{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+	ids := make([]string, config.Volumes)
+	soakParallel(ctx, ids, config.Concurrency, func(i int, _ string) {
+		name := fmt.Sprintf("%s%d", config.NamePrefix, i)
+		r.call(ctx, config.Timeout, "CreateVolume", name, func(ctx context.Context) error {
+			resp, err := client.CreateVolume(ctx, soakCreateVolumeRequest(name, config.VolumeBytes))
+			if err == nil {
+				ids[i] = resp.GetVolume().GetId()
+			}
+			return err
+		})
+	})
+	var created []string
+	for _, id := range ids {
+		if id != "" {
+			created = append(created, id)
+		}
+	}
+	for i := 0; i < config.Lists && ctx.Err() == nil; i++ {
+		r.call(ctx, config.Timeout, "ListVolumes", fmt.Sprintf("#%d", i), func(ctx context.Context) error {
+			resp, err := client.ListVolumes(ctx, &csi.ListVolumesRequest{})
+			if err == nil && len(resp.GetEntries()) < len(created) {
+				err = fmt.Errorf("listed %d volumes but %d were created", len(resp.GetEntries()), len(created))
+			}
+			return err
+		})
+	}
+	if config.TargetDir != "" {
+		soakParallel(ctx, created, config.Concurrency, func(_ int, id string) {
+			soakPublish(ctx, client, r, config, id)
+		})
+	}
+	// The volumes are deleted even if the soak test was interrupted.
+	soakParallel(context.Background(), created, config.Concurrency, func(_ int, id string) {
+		r.call(context.Background(), config.Timeout, "DeleteVolume", id, func(ctx context.Context) error {
+			_, err := client.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: id})
+			return err
+		})
+	})
+
+	report.ElapsedSeconds = time.Since(report.StartedAt).Seconds()
+	report.Latencies = make(map[string]LatencySummary)
+	for rpc, latencies := range r.latencies {
+		report.Latencies[rpc] = summarizeLatencies(latencies, r.errors[rpc])
+	}
+	report.Errors = r.messages
+	if ctx.Err() != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("soak test interrupted: %v", ctx.Err()))
+	}
+	return report
+}
+
+// soakParallel calls fn for each of the volume ids with at most concurrency
+// calls in flight. No more calls are started once ctx is done.
+func soakParallel(ctx context.Context, ids []string, concurrency int, fn func(i int, id string)) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i, id)
+		}(i, id)
+	}
+	wg.Wait()
+}
+
+// soakPublish publishes the volume as a block volume at a target path in
+// the target directory and unpublishes it again.
+func soakPublish(ctx context.Context, client *Client, r *soakRecorder, config SoakConfig, id string) {
+	// The target path of a block volume is a file onto which the device
+	// is bind mounted.
+	targetPath := filepath.Join(config.TargetDir, filepath.Base(id))
+	f, err := os.Create(targetPath)
+	if err != nil {
+		r.fail("NodePublishVolume", id, err)
+		return
+	}
+	f.Close()
+	defer os.Remove(targetPath)
+	err = r.call(ctx, config.Timeout, "NodePublishVolume", id, func(ctx context.Context) error {
+		_, err := client.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:         id,
+			TargetPath:       targetPath,
+			VolumeCapability: soakVolumeCapability(),
+		})
+		return err
+	})
+	if err != nil {
+		return
+	}
+	r.call(context.Background(), config.Timeout, "NodeUnpublishVolume", id, func(ctx context.Context) error {
+		_, err := client.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   id,
+			TargetPath: targetPath,
+		})
+		return err
+	})
+}
+
+func soakVolumeCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+	}
+}
+
+func soakCreateVolumeRequest(name string, bytes int64) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name:               name,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: bytes},
+		VolumeCapabilities: []*csi.VolumeCapability{soakVolumeCapability()},
+	}
+}
@@ -0,0 +1,65 @@
+package csilvm
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestSummarizeLatencies(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	exp := LatencySummary{
+		Count:  100,
+		Errors: 2,
+		MinMs:  1,
+		P50Ms:  50,
+		P90Ms:  90,
+		P99Ms:  99,
+		MaxMs:  100,
+		MeanMs: 50.5,
+	}
+	if got := summarizeLatencies(latencies, 2); got != exp {
+		t.Fatalf("expected %+v but got %+v", exp, got)
+	}
+	exp = LatencySummary{Count: 1, MinMs: 3, P50Ms: 3, P90Ms: 3, P99Ms: 3, MaxMs: 3, MeanMs: 3}
+	if got := summarizeLatencies([]time.Duration{3 * time.Millisecond}, 0); got != exp {
+		t.Fatalf("expected %+v but got %+v", exp, got)
+	}
+	if got := summarizeLatencies(nil, 1); got != (LatencySummary{Errors: 1}) {
+		t.Fatalf("expected only the errors but got %+v", got)
+	}
+}
+
+func TestSoakParallel(t *testing.T) {
+	ids := make([]string, 20)
+	var inFlight, maxInFlight, calls int32
+	soakParallel(context.Background(), ids, 3, func(i int, id string) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		atomic.AddInt32(&calls, 1)
+	})
+	if calls != 20 {
+		t.Fatalf("expected 20 calls but got %d", calls)
+	}
+	if maxInFlight > 3 {
+		t.Fatalf("expected at most 3 calls in flight but got %d", maxInFlight)
+	}
+	// No calls are started once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	soakParallel(ctx, ids, 3, func(i int, id string) {
+		t.Fatalf("unexpected call after the context is done")
+	})
+}
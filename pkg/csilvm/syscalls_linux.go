@@ -0,0 +1,218 @@
+package csilvm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// Flags passed to mount and open that only exist on linux.
+const (
+	msBind    = syscall.MS_BIND
+	msMove    = syscall.MS_MOVE
+	msPrivate = syscall.MS_PRIVATE
+	msRdonly  = syscall.MS_RDONLY
+	msRemount = syscall.MS_REMOUNT
+	mntDetach = syscall.MNT_DETACH
+	oDirect   = syscall.O_DIRECT
+)
+
+func mount(source, target, fstype string, flags uintptr, data string) error {
+	return syscall.Mount(source, target, fstype, flags, data)
+}
+
+func unmount(target string, flags int) error {
+	return syscall.Unmount(target, flags)
+}
+
+// kernelVersion returns the release of the running kernel, e.g.,
+// "4.15.0-1021-aws".
+func kernelVersion() (string, error) {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return "", err
+	}
+	var b []byte
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b), nil
+}
+
+// fileOwner returns the owning user and group of the file.
+func fileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+// deviceNumber returns the major:minor number of the block device at path,
+// following symlinks, in the format used by /proc/self/mountinfo.
+func deviceNumber(path string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", err
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return "", fmt.Errorf("%v is not a block device", path)
+	}
+	// The encoding of dev_t as in glibc's gnu_dev_major and
+	// gnu_dev_minor.
+	rdev := uint64(st.Rdev)
+	major := (rdev>>8)&0xfff | (rdev>>32)&^0xfff
+	minor := rdev&0xff | (rdev>>12)&^0xff
+	return fmt.Sprintf("%d:%d", major, minor), nil
+}
+
+// filesystemUsage returns the bytes used on the filesystem mounted at path
+// and the bytes available to unprivileged users.
+func filesystemUsage(path string) (used, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(st.Bsize)
+	return (st.Blocks - st.Bfree) * bsize, st.Bavail * bsize, nil
+}
+
+// Syscall numbers and flags of the mount API, which the vendored
+// golang.org/x/sys predates. The syscall numbers are the same on all
+// architectures.
+const (
+	sysOpenTree         = 428
+	sysMoveMount        = 429
+	sysMountSetattr     = 442
+	openTreeClone       = 0x1
+	moveMountFEmptyPath = 0x4
+	atFdcwd             = -0x64
+	atEmptyPath         = 0x1000
+	mountAttrIdmap      = 0x100000
+)
+
+// mountAttr is struct mount_attr from <linux/mount.h>.
+type mountAttr struct {
+	attrSet     uint64
+	attrClr     uint64
+	propagation uint64
+	usernsFd    uint64
+}
+
+// idmapMount replaces the mount at mountPath with an idmapped clone of it.
+// It requires linux 5.12 or later and a filesystem that supports idmapped
+// mounts, e.g., xfs or ext4. If the clone cannot be moved to mountPath the
+// original mount is restored.
+func idmapMount(mountPath string, m *idmap) error {
+	userns, err := newUserns(m)
+	if err != nil {
+		return err
+	}
+	defer userns.Close()
+	// A clone that is not moved to a mount point is unmounted when its
+	// file descriptor is closed.
+	clone, err := openTree(mountPath)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(int(clone))
+	empty, err := syscall.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+	attr := mountAttr{attrSet: mountAttrIdmap, usernsFd: uint64(userns.Fd())}
+	if _, _, errno := syscall.Syscall6(sysMountSetattr, clone, uintptr(unsafe.Pointer(empty)), atEmptyPath, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0); errno != 0 {
+		return os.NewSyscallError("mount_setattr", errno)
+	}
+	// The original mount is kept as a plain clone so that it can be
+	// restored if the idmapped clone cannot replace it.
+	original, err := openTree(mountPath)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(int(original))
+	// The clones keep the filesystem mounted while the original mount
+	// is replaced.
+	if err := syscall.Unmount(mountPath, 0); err != nil {
+		return err
+	}
+	if err := moveMount(clone, mountPath); err != nil {
+		if rerr := moveMount(original, mountPath); rerr != nil {
+			return fmt.Errorf("%v, cannot restore the mount at %v: %v", err, mountPath, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// openTree returns a file descriptor of a detached clone of the mount at
+// path.
+func openTree(path string) (uintptr, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	fdcwd := atFdcwd
+	fd, _, errno := syscall.Syscall(sysOpenTree, uintptr(fdcwd), uintptr(unsafe.Pointer(p)), openTreeClone|syscall.O_CLOEXEC)
+	if errno != 0 {
+		return 0, os.NewSyscallError("open_tree", errno)
+	}
+	return fd, nil
+}
+
+// moveMount attaches the detached mount with the file descriptor fd at
+// path.
+func moveMount(fd uintptr, path string) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	empty, err := syscall.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+	fdcwd := atFdcwd
+	if _, _, errno := syscall.Syscall6(sysMoveMount, fd, uintptr(unsafe.Pointer(empty)), uintptr(fdcwd), uintptr(unsafe.Pointer(p)), moveMountFEmptyPath, 0); errno != 0 {
+		return os.NewSyscallError("move_mount", errno)
+	}
+	return nil
+}
+
+// newUserns returns a user namespace with the mappings of m. The namespace
+// is created by a short-lived child process and kept alive by the returned
+// file.
+func newUserns(m *idmap) (*os.File, error) {
+	c := exec.Command("cat")
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: sysProcIDMaps(m.uids),
+		GidMappings: sysProcIDMaps(m.gids),
+	}
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	userns, err := os.Open(fmt.Sprintf("/proc/%d/ns/user", c.Process.Pid))
+	stdin.Close()
+	if werr := c.Wait(); err == nil && werr != nil {
+		userns.Close()
+		err = werr
+	}
+	return userns, err
+}
+
+func sysProcIDMaps(mappings []idMapping) []syscall.SysProcIDMap {
+	maps := make([]syscall.SysProcIDMap, len(mappings))
+	for i, m := range mappings {
+		maps[i] = syscall.SysProcIDMap{ContainerID: int(m.diskID), HostID: int(m.mountID), Size: int(m.count)}
+	}
+	return maps
+}
@@ -0,0 +1,53 @@
+// +build !linux
+
+package csilvm
+
+import (
+	"errors"
+	"os"
+)
+
+// errNotSupported is returned by the mount and kernel helpers on platforms
+// other than linux. The plugin only serves volumes on linux; the stubs
+// exist so that the packages can be built and vetted elsewhere.
+var errNotSupported = errors.New("csilvm: only supported on linux")
+
+// Flags passed to mount and open. The values match linux so that the
+// callers build unchanged; they are never passed to the kernel.
+const (
+	msBind    = 0x1000
+	msMove    = 0x2000
+	msPrivate = 0x40000
+	msRdonly  = 0x1
+	msRemount = 0x20
+	mntDetach = 0x2
+	oDirect   = 0
+)
+
+func mount(source, target, fstype string, flags uintptr, data string) error {
+	return errNotSupported
+}
+
+func unmount(target string, flags int) error {
+	return errNotSupported
+}
+
+func kernelVersion() (string, error) {
+	return "", errNotSupported
+}
+
+func fileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+func deviceNumber(path string) (string, error) {
+	return "", errNotSupported
+}
+
+func filesystemUsage(path string) (used, free uint64, err error) {
+	return 0, 0, errNotSupported
+}
+
+func idmapMount(mountPath string, m *idmap) error {
+	return errNotSupported
+}
@@ -0,0 +1,68 @@
+package csilvm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// targetDirMode and targetFileMode are the permissions with which
+	// missing target paths are created.
+	targetDirMode  = 0750
+	targetFileMode = 0640
+)
+
+// CreateTargetPath configures the server to create the target path in
+// NodePublishVolume if it does not exist, as permitted by the CSI spec. A
+// directory is created for MOUNT_DEVICE volumes and an empty file onto which
+// the device is bind mounted for BLOCK_DEVICE volumes. The parent directory
+// must exist and the target path is owned by the parent directory's owner.
+func CreateTargetPath() ServerOpt {
+	return func(s *Server) {
+		s.createTargetPath = true
+	}
+}
+
+// CreatesTargetPath returns whether the server creates missing target paths.
+func (s *Server) CreatesTargetPath() bool {
+	return s.createTargetPath
+}
+
+// createTargetPath creates a directory, or a file if block is true, at
+// targetPath unless something already exists there.
+func createTargetPath(targetPath string, block bool) error {
+	if _, err := os.Lstat(targetPath); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	parent, err := os.Stat(filepath.Dir(targetPath))
+	if err != nil {
+		return err
+	}
+	uid, gid, ok := fileOwner(parent)
+	if !ok {
+		return fmt.Errorf("cannot determine owner of %v", filepath.Dir(targetPath))
+	}
+	if block {
+		log.Printf("Creating target file %v", targetPath)
+		f, err := os.OpenFile(targetPath, os.O_RDONLY|os.O_CREATE|os.O_EXCL, targetFileMode)
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("Creating target directory %v", targetPath)
+		if err := os.Mkdir(targetPath, targetDirMode); err != nil {
+			return err
+		}
+	}
+	if err := os.Lchown(targetPath, uid, gid); err != nil {
+		os.Remove(targetPath)
+		return err
+	}
+	return nil
+}
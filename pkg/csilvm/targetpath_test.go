@@ -0,0 +1,57 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateTargetPath(t *testing.T) {
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+
+	dirPath := filepath.Join(tmpdirPath, "mount")
+	if err := createTargetPath(dirPath, false); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(dirPath); err != nil {
+		t.Fatal(err)
+	} else if !info.IsDir() || info.Mode().Perm() != targetDirMode {
+		t.Fatalf("Expected directory with mode %o but got %v", targetDirMode, info.Mode())
+	}
+
+	filePath := filepath.Join(tmpdirPath, "block")
+	if err := createTargetPath(filePath, true); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filePath); err != nil {
+		t.Fatal(err)
+	} else if !info.Mode().IsRegular() || info.Mode().Perm() != targetFileMode {
+		t.Fatalf("Expected file with mode %o but got %v", targetFileMode, info.Mode())
+	}
+
+	// Existing paths are left alone.
+	if err := createTargetPath(filePath, false); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filePath); err != nil {
+		t.Fatal(err)
+	} else if !info.Mode().IsRegular() {
+		t.Fatalf("Expected %v to remain a file", filePath)
+	}
+}
+
+func TestCreateTargetPathMissingParent(t *testing.T) {
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	if err := createTargetPath(filepath.Join(tmpdirPath, "missing", "mount"), false); err == nil {
+		t.Fatal("Expected an error as the parent directory does not exist")
+	}
+}
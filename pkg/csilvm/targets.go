@@ -0,0 +1,289 @@
+package csilvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+
+	"google.golang.org/grpc/codes"
+)
+
+// targetRegistry records the target paths at which each volume is
+// published. If a state file is configured, the registry is persisted to it
+// so that it survives restarts. Otherwise it is kept in memory and targets
+// are rediscovered from the mount table after a restart.
+type targetRegistry struct {
+	mu sync.Mutex
+	// path is the state file, if any.
+	path string
+	// targets maps a volume id to its target paths.
+	targets map[string]map[string]targetInfo
+}
+
+// targetInfo describes how a volume is published at a target path.
+type targetInfo struct {
+	Readonly bool `json:"readonly"`
+	// Block is whether the volume is published as a block device.
+	Block bool `json:"block,omitempty"`
+}
+
+// targetState is the content of the state file.
+type targetState struct {
+	Volumes map[string]map[string]targetInfo `json:"volumes"`
+}
+
+func newTargetRegistry() *targetRegistry {
+	return &targetRegistry{targets: make(map[string]map[string]targetInfo)}
+}
+
+// StateDir configures the server to persist the target paths at which
+// volumes are published to a state file in dir. The file is named after the
+// volume group so several instances may share dir.
+func StateDir(dir string) ServerOpt {
+	return func(s *Server) {
+		s.stateDir = dir
+	}
+}
+
+// load replaces the registry with the contents of the state file at path
+// and persists later changes to it. A missing file is an empty registry.
+func (r *targetRegistry) load(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.path = path
+	r.targets = make(map[string]map[string]targetInfo)
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state targetState
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return fmt.Errorf("cannot parse %v: %v", path, err)
+	}
+	for id, targets := range state.Volumes {
+		if len(targets) > 0 {
+			r.targets[id] = targets
+		}
+	}
+	return nil
+}
+
+// save writes the registry to the state file, if any. It replaces the file
+// atomically so that a crash never leaves a partially written file behind.
+// The caller must hold r.mu.
+func (r *targetRegistry) save() {
+	if r.path == "" {
+		return
+	}
+	buf, err := json.MarshalIndent(targetState{Volumes: r.targets}, "", "  ")
+	if err != nil {
+		log.Printf("Cannot encode target state: err=%v", err)
+		return
+	}
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		log.Printf("Cannot write target state to %v: err=%v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		log.Printf("Cannot replace target state %v: err=%v", r.path, err)
+	}
+}
+
+func (r *targetRegistry) add(id, targetPath string, info targetInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.targets[id] == nil {
+		r.targets[id] = make(map[string]targetInfo)
+	}
+	// A target path is published for a single volume. Any other volume
+	// recorded for it is stale.
+	for other, targets := range r.targets {
+		if _, ok := targets[targetPath]; ok && other != id {
+			delete(targets, targetPath)
+			if len(targets) == 0 {
+				delete(r.targets, other)
+			}
+		}
+	}
+	r.targets[id][targetPath] = info
+	r.save()
+}
+
+func (r *targetRegistry) remove(id, targetPath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.targets[id][targetPath]; !ok {
+		return
+	}
+	delete(r.targets[id], targetPath)
+	if len(r.targets[id]) == 0 {
+		delete(r.targets, id)
+	}
+	r.save()
+}
+
+// owner returns the volume that is published at targetPath, if any.
+func (r *targetRegistry) owner(targetPath string) (id string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, targets := range r.targets {
+		if _, ok := targets[targetPath]; ok {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// volumes returns the sorted ids of the volumes that have targets.
+func (r *targetRegistry) volumes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []string
+	for id := range r.targets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// list returns the sorted target paths of the volume.
+func (r *targetRegistry) list(id string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var paths []string
+	for path := range r.targets[id] {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// entries returns the target paths of the volume and how it is published
+// at each of them.
+func (r *targetRegistry) entries(id string) map[string]targetInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := make(map[string]targetInfo, len(r.targets[id]))
+	for path, info := range r.targets[id] {
+		m[path] = info
+	}
+	return m
+}
+
+// loadTargets loads the state file, if one is configured, and forgets the
+// targets at which nothing is mounted anymore, e.g., because the node
+// rebooted or they were unmounted while the plugin was not running.
+func (s *Server) loadTargets() error {
+	if s.stateDir == "" {
+		return nil
+	}
+	path := filepath.Join(s.stateDir, s.vgname+"-targets.json")
+	log.Printf("Loading published targets from %v", path)
+	if err := s.targets.load(path); err != nil {
+		return err
+	}
+	for _, id := range s.targets.volumes() {
+		for _, targetPath := range s.targets.list(id) {
+			mp, err := getMountAt(targetPath)
+			if err != nil {
+				return err
+			}
+			if mp == nil {
+				log.Printf("Nothing is mounted at %v anymore, forgetting that volume %v was published there", targetPath, id)
+				s.targets.remove(id, targetPath)
+				continue
+			}
+			log.Printf("Volume %v is published at %v", id, targetPath)
+		}
+	}
+	return nil
+}
+
+// findPublishedMount returns a mount of sourcePath at another target path
+// than targetPath, or nil if the volume is not mounted. Targets in the
+// registry are preferred over other mounts of the device.
+func (s *Server) findPublishedMount(id, sourcePath, targetPath string) (*mountpoint, error) {
+	device := lookupBlockDevice(sourcePath)
+	for _, path := range s.targets.list(id) {
+		if path == targetPath {
+			continue
+		}
+		mp, err := getMountAt(path)
+		if err != nil {
+			return nil, err
+		}
+		if mp != nil && device.isMountedAt(mp) {
+			return mp, nil
+		}
+		// The target was unmounted behind our back.
+		s.targets.remove(id, path)
+	}
+	mounts, err := listMounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, mp := range mounts {
+		if mp.root == "/" && mp.path != targetPath && device.isMountedAt(&mp) {
+			return &mp, nil
+		}
+	}
+	return nil, nil
+}
+
+var ErrVolumePublishedRO = statusError(
+	codes.FailedPrecondition,
+	newErrorInfo(ReasonVolumePublishedReadonly),
+	"The volume is already published readonly and cannot also be published read-write.")
+
+// bindMountTarget publishes a volume that is already mounted at
+// published.path at targetPath as well by bind mounting it. The readonly
+// flag of the bind mount is set separately by remounting it, so that a
+// volume can be published read-write at one target path and readonly at
+// another. Mount options are ignored as the filesystem is already mounted.
+func (s *Server) bindMountTarget(published *mountpoint, sourcePath, targetPath, fstype string, readonly bool) error {
+	if published.fstype != fstype {
+		return ErrMismatchedFilesystemType
+	}
+	if !readonly && published.isSuperReadonly() {
+		return ErrVolumePublishedRO
+	}
+	log.Printf("The volume %v is already mounted at %v, bind mounting it to %v", sourcePath, published.path, targetPath)
+	if err := mount(published.path, targetPath, "", msBind, ""); err != nil {
+		return s.mountError(err, sourcePath, targetPath, "Failed to perform bind mount: err=%v")
+	}
+	flags := uintptr(msRemount | msBind)
+	if readonly {
+		flags |= msRdonly
+	}
+	log.Printf("Remounting %v with flags=%v", targetPath, flags)
+	if err := mount("", targetPath, "", flags, ""); err != nil {
+		if err := unmount(targetPath, 0); err != nil {
+			log.Printf("Failed to unmount %v: err=%v", targetPath, err)
+		}
+		return s.mountError(err, sourcePath, targetPath, "Failed to remount bind mount: err=%v")
+	}
+	return nil
+}
+
+// mountError returns the status error for a failed mount(2) of sourcePath
+// at targetPath. Failures reported by the kernel are FailedPrecondition.
+func (s *Server) mountError(err error, sourcePath, targetPath, format string) error {
+	code := codes.FailedPrecondition
+	if _, ok := err.(syscall.Errno); !ok {
+		code = codes.Internal
+	}
+	return statusErrorf(
+		code,
+		s.errorInfo(ReasonMountFailed, "device", sourcePath, "targetPath", targetPath),
+		format,
+		err)
+}
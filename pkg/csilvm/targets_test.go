@@ -0,0 +1,92 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTargetRegistry(t *testing.T) {
+	r := newTargetRegistry()
+	r.add("vol", "/b", targetInfo{Readonly: true})
+	r.add("vol", "/a", targetInfo{})
+	r.add("other", "/c", targetInfo{})
+	if paths, expected := r.list("vol"), []string{"/a", "/b"}; !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %v instead of %v", expected, paths)
+	}
+	r.remove("vol", "/a")
+	r.remove("vol", "/b")
+	if paths := r.list("vol"); len(paths) != 0 {
+		t.Fatalf("expected no targets instead of %v", paths)
+	}
+	if _, ok := r.targets["vol"]; ok {
+		t.Fatal("expected the volume to be removed from the registry")
+	}
+	if paths, expected := r.list("other"), []string{"/c"}; !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %v instead of %v", expected, paths)
+	}
+}
+
+func TestTargetRegistryOwner(t *testing.T) {
+	r := newTargetRegistry()
+	r.add("vol", "/a", targetInfo{})
+	if id, ok := r.owner("/a"); !ok || id != "vol" {
+		t.Fatalf("expected vol to own /a instead of %q", id)
+	}
+	// Publishing another volume at the same target path replaces the
+	// stale record.
+	r.add("other", "/a", targetInfo{})
+	if id, ok := r.owner("/a"); !ok || id != "other" {
+		t.Fatalf("expected other to own /a instead of %q", id)
+	}
+	if ids, expected := r.volumes(), []string{"other"}; !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected %v instead of %v", expected, ids)
+	}
+	if _, ok := r.owner("/b"); ok {
+		t.Fatal("expected /b to have no owner")
+	}
+}
+
+func TestTargetRegistryPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vg-targets.json")
+	r := newTargetRegistry()
+	if err := r.load(path); err != nil {
+		t.Fatal(err)
+	}
+	r.add("vol", "/a", targetInfo{Readonly: true})
+	r.add("vol", "/b", targetInfo{})
+	r.remove("vol", "/b")
+	loaded := newTargetRegistry()
+	if err := loaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]targetInfo{"vol": {"/a": {Readonly: true}}}
+	if !reflect.DeepEqual(loaded.targets, expected) {
+		t.Fatalf("expected %v instead of %v", expected, loaded.targets)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected the temporary file to be renamed: err=%v", err)
+	}
+}
+
+func TestTargetRegistryLoadCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vg-targets.json")
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := newTargetRegistry().load(path); err == nil {
+		t.Fatal("expected an error for a corrupt state file")
+	}
+}
@@ -0,0 +1,69 @@
+package csilvm
+
+import (
+	"os/exec"
+	"sort"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+// defaultProbeTools are the userspace tools that the plugin runs in
+// addition to mkfs.<fstype> for each supported filesystem.
+var defaultProbeTools = []string{"blkid", "blockdev", "dd", "file", "mkfs"}
+
+// ProbeTools configures the server to check that the given executables can
+// be found in $PATH, in addition to the tools the plugin always requires.
+// This option may be specified multiple times to append additional tool
+// requirements.
+func ProbeTools(required []string) ServerOpt {
+	return func(s *Server) {
+		if s.probeTools == nil {
+			s.probeTools = make(map[string]struct{}, len(required))
+		}
+		for _, r := range required {
+			s.probeTools[r] = struct{}{}
+		}
+	}
+}
+
+// requiredTools returns the sorted names of all executables the server
+// needs: the defaults, mkfs.<fstype> for each supported filesystem, wipefs
+// if ForceDeviceInit is configured, modprobe if LoadModules is configured
+// and those configured using ProbeTools.
+func (s *Server) requiredTools() []string {
+	m := make(map[string]struct{})
+	for _, tool := range defaultProbeTools {
+		m[tool] = struct{}{}
+	}
+	for _, fstype := range s.SupportedFilesystems() {
+		m["mkfs."+fstype] = struct{}{}
+	}
+	if s.forceDeviceInit {
+		m["wipefs"] = struct{}{}
+	}
+	if s.loadModules {
+		m["modprobe"] = struct{}{}
+	}
+	if lvm.DevicesFile() != "" {
+		m["lvmdevices"] = struct{}{}
+	}
+	for tool := range s.probeTools {
+		m[tool] = struct{}{}
+	}
+	tools := make([]string, 0, len(m))
+	for tool := range m {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	return tools
+}
+
+// missingTools returns those tools that cannot be found in $PATH.
+func missingTools(tools []string) (missing []string) {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			missing = append(missing, tool)
+		}
+	}
+	return missing
+}
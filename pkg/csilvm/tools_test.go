@@ -0,0 +1,35 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRequiredTools(t *testing.T) {
+	s := NewServer("vg", nil, "xfs", SupportedFilesystem("ext4"), ProbeTools([]string{"xfs_io", "dd"}))
+	expected := []string{"blkid", "blockdev", "dd", "file", "mkfs", "mkfs.ext4", "mkfs.xfs", "xfs_io"}
+	if tools := s.requiredTools(); !reflect.DeepEqual(tools, expected) {
+		t.Fatalf("expected %v instead of %v", expected, tools)
+	}
+}
+
+func TestMissingTools(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "present"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+	missing := missingTools([]string{"present", "absent"})
+	expected := []string{"absent"}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Fatalf("expected %v instead of %v", expected, missing)
+	}
+}
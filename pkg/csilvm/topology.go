@@ -0,0 +1,83 @@
+package csilvm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"google.golang.org/grpc/codes"
+)
+
+// Topology configures the server to advertise the ACCESSIBILITY_CONSTRAINTS
+// plugin capability and to report that its volumes are only accessible from
+// the node's topology segment, so that a topology-aware CO only publishes a
+// volume on the node that has it. CreateVolume fails if the requisite
+// topologies of the request do not include the node's. Setup fails if no
+// node id is set.
+func Topology() ServerOpt {
+	return func(s *Server) {
+		s.topology = true
+	}
+}
+
+// nodeTopology returns the topology segment of the node reported by
+// NodeGetInfo.
+func (s *Server) nodeTopology() *csi.Topology {
+	return &csi.Topology{
+		Segments: map[string]string{topologyKey: s.nodeID},
+	}
+}
+
+// volumeTopology returns the accessible topology of the volumes of the
+// server or nil if the Topology option is not set.
+func (s *Server) volumeTopology() []*csi.Topology {
+	if !s.topology {
+		return nil
+	}
+	return []*csi.Topology{s.nodeTopology()}
+}
+
+// checkAccessibleTopology checks the accessible topology of a
+// ValidateVolumeCapabilities request. Like checkVolumeCapabilities it returns
+// whether each topology is the node's, which is the only one the volumes are
+// accessible from, along with a message that lists, per topology, whether it
+// was confirmed or denied and why.
+func (s *Server) checkAccessibleTopology(topologies []*csi.Topology) (supported bool, message string) {
+	supported = true
+	var results []string
+	for i, topology := range topologies {
+		switch {
+		case !s.topology:
+			supported = false
+			results = append(results, fmt.Sprintf("accessible_topology[%d]: denied: the plugin does not report topology", i))
+		case !reflect.DeepEqual(topology.GetSegments(), s.nodeTopology().Segments):
+			supported = false
+			results = append(results, fmt.Sprintf("accessible_topology[%d]: denied: the volume is only accessible from %v", i, s.nodeTopology().Segments))
+		default:
+			results = append(results, fmt.Sprintf("accessible_topology[%d]: confirmed", i))
+		}
+	}
+	return supported, strings.Join(results, "; ")
+}
+
+// checkAccessibilityRequirements returns an error if the Topology option is
+// set and the requisite topologies of a CreateVolume request do not include
+// the node's. The preferred topologies are a subset of the requisite ones
+// and are ignored as there is only one to choose from.
+func (s *Server) checkAccessibilityRequirements(requirements *csi.TopologyRequirement) error {
+	if !s.topology || len(requirements.GetRequisite()) == 0 {
+		return nil
+	}
+	node := s.nodeTopology()
+	for _, topology := range requirements.GetRequisite() {
+		if reflect.DeepEqual(topology.GetSegments(), node.Segments) {
+			return nil
+		}
+	}
+	return statusErrorf(
+		codes.ResourceExhausted,
+		s.errorInfo(ReasonTopologyUnsatisfiable, "nodeID", s.nodeID),
+		"The volume cannot be accessible from the requisite topologies %v as it is only accessible from %v",
+		requirements.GetRequisite(), node.Segments)
+}
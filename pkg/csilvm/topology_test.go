@@ -0,0 +1,74 @@
+package csilvm
+
+import (
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVolumeTopology(t *testing.T) {
+	if topology := NewServer("vg", nil, "xfs", NodeID("node1")).volumeTopology(); topology != nil {
+		t.Fatalf("expected no topology without the option instead of %v", topology)
+	}
+	topology := NewServer("vg", nil, "xfs", NodeID("node1"), Topology()).volumeTopology()
+	if len(topology) != 1 || topology[0].GetSegments()[topologyKey] != "node1" {
+		t.Fatalf("expected the node's topology instead of %v", topology)
+	}
+}
+
+func TestCheckAccessibilityRequirements(t *testing.T) {
+	node := &csi.Topology{Segments: map[string]string{topologyKey: "node1"}}
+	other := &csi.Topology{Segments: map[string]string{topologyKey: "node2"}}
+	zoned := &csi.Topology{Segments: map[string]string{topologyKey: "node1", "zone": "a"}}
+	cases := []struct {
+		requirements *csi.TopologyRequirement
+		ok           bool
+	}{
+		{nil, true},
+		{&csi.TopologyRequirement{}, true},
+		{&csi.TopologyRequirement{Preferred: []*csi.Topology{other}}, true},
+		{&csi.TopologyRequirement{Requisite: []*csi.Topology{other, node}}, true},
+		{&csi.TopologyRequirement{Requisite: []*csi.Topology{other}}, false},
+		{&csi.TopologyRequirement{Requisite: []*csi.Topology{zoned}}, false},
+	}
+	s := NewServer("vg", nil, "xfs", NodeID("node1"), Topology())
+	for i, tt := range cases {
+		err := s.checkAccessibilityRequirements(tt.requirements)
+		if tt.ok && err != nil {
+			t.Fatalf("test case %d: unexpected error %v", i, err)
+		}
+		if !tt.ok {
+			if status.Code(err) != codes.ResourceExhausted {
+				t.Fatalf("test case %d: expected RESOURCE_EXHAUSTED instead of %v", i, err)
+			}
+			if info, ok := ErrorReason(err); !ok || info.Reason != ReasonTopologyUnsatisfiable {
+				t.Fatalf("test case %d: expected reason %v instead of %v", i, ReasonTopologyUnsatisfiable, info)
+			}
+		}
+	}
+	// The requirements are ignored without the option.
+	s = NewServer("vg", nil, "xfs", NodeID("node1"))
+	if err := s.checkAccessibilityRequirements(&csi.TopologyRequirement{Requisite: []*csi.Topology{other}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestCheckAccessibleTopology(t *testing.T) {
+	node := &csi.Topology{Segments: map[string]string{topologyKey: "node1"}}
+	other := &csi.Topology{Segments: map[string]string{topologyKey: "node2"}}
+	s := NewServer("vg", nil, "xfs", NodeID("node1"), Topology())
+	supported, message := s.checkAccessibleTopology([]*csi.Topology{node})
+	if exp := "accessible_topology[0]: confirmed"; !supported || message != exp {
+		t.Fatalf("expected (true, %q) but got (%v, %q)", exp, supported, message)
+	}
+	supported, message = s.checkAccessibleTopology([]*csi.Topology{node, other})
+	if exp := "accessible_topology[0]: confirmed; accessible_topology[1]: denied: the volume is only accessible from map[" + topologyKey + ":node1]"; supported || message != exp {
+		t.Fatalf("expected (false, %q) but got (%v, %q)", exp, supported, message)
+	}
+	s = NewServer("vg", nil, "xfs", NodeID("node1"))
+	if supported, message = s.checkAccessibleTopology([]*csi.Topology{node}); supported {
+		t.Fatalf("expected topology to be denied without the option but got %q", message)
+	}
+}
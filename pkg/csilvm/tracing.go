@@ -0,0 +1,66 @@
+package csilvm
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// traceFamily is the family of the traces created by TracingInterceptor.
+const traceFamily = "csilvm"
+
+// traceparentKey is the gRPC metadata key of the W3C trace context with
+// which a CO links its own trace to the RPC.
+const traceparentKey = "traceparent"
+
+// TracingInterceptor returns an interceptor that traces each RPC using the
+// golang.org/x/net/trace package. The trace is added to the request context
+// so that the LVM and other commands run on behalf of the RPC are recorded
+// in it along with their latency. If the CO sends a traceparent header it is
+// recorded as well, so that the trace can be matched to the CO's trace. The
+// traces are served by TraceHandler.
+func TracingInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		tr := trace.New(traceFamily, info.FullMethod)
+		defer tr.Finish()
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, v := range md.Get(traceparentKey) {
+				tr.LazyPrintf("%s: %s", traceparentKey, v)
+			}
+		}
+		tr.LazyPrintf("request: %v", redactSecrets(req))
+		resp, err := handler(trace.NewContext(ctx, tr), req)
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError()
+			return nil, err
+		}
+		tr.LazyPrintf("response: %v", resp)
+		return resp, nil
+	}
+}
+
+// traceEvent records an event in the trace of the RPC, if any.
+func traceEvent(ctx context.Context, format string, args ...interface{}) {
+	if tr, ok := trace.FromContext(ctx); ok {
+		tr.LazyPrintf(format, args...)
+	}
+}
+
+// TraceHandler returns an HTTP handler that serves the active and recent
+// traces at /debug/requests and long-lived events at /debug/events. It is
+// meant to be served on the admin endpoint, so requests are not restricted
+// to localhost.
+func TraceHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/requests", func(w http.ResponseWriter, r *http.Request) {
+		trace.Render(w, r, true)
+	})
+	mux.HandleFunc("/debug/events", func(w http.ResponseWriter, r *http.Request) {
+		trace.RenderEvents(w, r, true)
+	})
+	return mux
+}
@@ -0,0 +1,53 @@
+package csilvm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTracingInterceptor(t *testing.T) {
+	interceptor := TracingInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Controller/CreateVolume"}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		traceparentKey, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	traced := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, traced = trace.FromContext(ctx)
+		traceEvent(ctx, "handled %v", req)
+		return "ok", nil
+	}
+	if resp, err := interceptor(ctx, "req", info, handler); err != nil || resp != "ok" {
+		t.Fatalf("expected response ok, got %v, err=%v", resp, err)
+	}
+	if !traced {
+		t.Fatal("expected the handler context to carry a trace")
+	}
+	experr := errors.New("failed")
+	handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, experr
+	}
+	if _, err := interceptor(context.Background(), "req", info, handler); err != experr {
+		t.Fatalf("expected %v, got %v", experr, err)
+	}
+	// traceEvent ignores contexts without a trace.
+	traceEvent(context.Background(), "untraced")
+}
+
+func TestTraceHandler(t *testing.T) {
+	for _, path := range []string{"/debug/requests", "/debug/events"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "@"
+		w := httptest.NewRecorder()
+		TraceHandler().ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: expected status %d, got %d", path, http.StatusOK, w.Code)
+		}
+	}
+}
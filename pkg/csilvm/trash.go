@@ -0,0 +1,197 @@
+package csilvm
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// trashNamePrefix prefixes the name of a logical volume moved to the
+	// trash by DeleteVolume. Volume ids never start with an underscore
+	// as volume prefixes cannot contain one, so trashed volumes are never
+	// mistaken for volumes of another plugin instance.
+	trashNamePrefix = "_trash_"
+	// tagTrashExpiryPrefix prefixes the logical volume tag that records
+	// when a trashed volume may be purged, in seconds since the epoch.
+	tagTrashExpiryPrefix = "TX."
+)
+
+// TrashRetention configures DeleteVolume to move volumes to the trash,
+// i.e., to rename them with the "_trash_" prefix, instead of zeroing and
+// removing them. A trashed volume can be restored by an operator until it
+// is purged by ReapTrash once the retention period has passed. This
+// protects against the accidental deletion of volumes at the cost of their
+// space remaining allocated for the retention period.
+func TrashRetention(retention time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.trashRetention = retention
+	}
+}
+
+// isTrashed returns whether the logical volume with the given name is in
+// the trash.
+func isTrashed(name string) bool {
+	return strings.HasPrefix(name, trashNamePrefix)
+}
+
+// ownsTrashedVolume returns whether the trashed logical volume with the
+// given name was managed by this server, see ownsVolume.
+func (s *Server) ownsTrashedVolume(name string) bool {
+	return isTrashed(name) && s.ownsVolume(strings.TrimPrefix(name, trashNamePrefix))
+}
+
+// trashExpiryFromTags returns the time recorded by the trash expiry tag
+// among the given tags.
+func trashExpiryFromTags(tags []string) (time.Time, bool) {
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, tagTrashExpiryPrefix) {
+			continue
+		}
+		secs, err := strconv.ParseInt(strings.TrimPrefix(tag, tagTrashExpiryPrefix), 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(secs, 0), true
+	}
+	return time.Time{}, false
+}
+
+// trashName returns the name of the logical volume with the given name once
+// it is moved to the trash. It fails if the trash prefix makes the name too
+// long, e.g., because of a long volume prefix.
+func (s *Server) trashName(id string) (string, error) {
+	name := trashNamePrefix + id
+	if err := validateLogicalVolumeName(s.vgname, name); err != nil {
+		return "", statusErrorf(
+			codes.FailedPrecondition,
+			s.errorInfo(ReasonInvalidVolumeName, "lvname", id),
+			"Cannot move volume %v to the trash as %v: %v", id, name, err)
+	}
+	return name, nil
+}
+
+// trashVolume moves the logical volume to the trash. The expiry is recorded
+// before the volume is renamed so that a trashed volume always has one.
+func (s *Server) trashVolume(ctx context.Context, lv *lvm.LogicalVolume) error {
+	id := lv.Name()
+	name, err := s.trashName(id)
+	if err != nil {
+		return err
+	}
+	tags, err := lv.CachedTags()
+	if err != nil {
+		return s.lvmError(err, "Error in CachedTags()", "lvname", id)
+	}
+	// An expiry left by a previous attempt that failed to rename the
+	// volume, or by an operator who restored the volume without removing
+	// it, is replaced.
+	expiry := time.Now().Add(s.trashRetention)
+	tag := tagTrashExpiryPrefix + strconv.FormatInt(expiry.Unix(), 10)
+	var stale []string
+	for _, t := range tags {
+		if strings.HasPrefix(t, tagTrashExpiryPrefix) && t != tag {
+			stale = append(stale, t)
+		}
+	}
+	if err := lv.ChangeTags([]string{tag}, stale); err != nil {
+		return s.lvmError(err, "Cannot record trash expiry", "lvname", id)
+	}
+	log.Printf("Moving volume %v to the trash as %v until %v", id, name, expiry.Format(time.RFC3339))
+	if err := lv.Rename(name); err != nil {
+		return s.lvmError(err, "Cannot move volume to the trash", "lvname", id)
+	}
+	s.metrics.Counter("volumes-trashed").Inc(1)
+	traceEvent(ctx, "moved volume %v to the trash until %v", id, expiry)
+	return nil
+}
+
+// ReapTrash purges the trashed volumes whose retention has expired every
+// interval, starting immediately. Each purge is serialized with the
+// requests, see Serializer. A purge that is
+// interrupted is retried by the next one as the volume stays in the trash.
+// The returned function stops the reaper, interrupting an ongoing purge,
+// and waits for it to return.
+func (s *Server) ReapTrash(interval time.Duration) context.CancelFunc {
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.reapTrash(ctx, time.Now()); err != nil {
+				log.Printf("Cannot purge trashed volumes: err=%v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// reapTrash zeroes and removes the trashed volumes whose retention expired
+// before now. It reports the number and size of the volumes that remain in
+// the trash.
+func (s *Server) reapTrash(ctx context.Context, now time.Time) error {
+	vg := s.volumeGroup.WithContext(ctx)
+	lvs, err := vg.ReportLogicalVolumes()
+	if err != nil {
+		return err
+	}
+	var volumes, bytes uint64
+	for _, report := range lvs {
+		if !s.ownsTrashedVolume(report.Name) {
+			continue
+		}
+		expiry, ok := trashExpiryFromTags(report.Tags)
+		if !ok || now.Before(expiry) {
+			if !ok {
+				// The volume was not trashed by DeleteVolume,
+				// e.g., it was renamed by an operator.
+				log.Printf("Trashed volume %v has no expiry, keeping it", report.Name)
+			}
+			volumes++
+			bytes += report.SizeInBytes
+			continue
+		}
+		log.Printf("Purging trashed volume %v that expired at %v", report.Name, expiry.Format(time.RFC3339))
+		err := s.serialize(ctx, func() error {
+			lv, err := vg.LookupLogicalVolume(report.Name)
+			if err != nil {
+				return err
+			}
+			return s.removeVolume(ctx, lv)
+		})
+		if err == lvm.ErrLogicalVolumeNotFound {
+			// The volume was restored by an operator meanwhile.
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Cannot purge trashed volume %v: err=%v", report.Name, err)
+			s.metrics.Counter("trash-purge-errs").Inc(1)
+			volumes++
+			bytes += report.SizeInBytes
+			continue
+		}
+		s.metrics.Counter("trash-purged").Inc(1)
+	}
+	s.metrics.Gauge("trash-volumes").Update(float64(volumes))
+	s.metrics.Gauge("trash-bytes").Update(float64(bytes))
+	return nil
+}
@@ -0,0 +1,72 @@
+package csilvm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTrashedVolumeOwnership(t *testing.T) {
+	lvs := []lvm.LogicalVolumeReport{
+		{Name: "csilv1"},
+		{Name: "_trash_csilv2"},
+		{Name: "_trash_tenantA_csilv3"},
+	}
+	s := &Server{}
+	if got, exp := s.ownedVolumes(lvs), lvs[:1]; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected trashed volumes to be ignored but got %v", got)
+	}
+	for _, tt := range []struct {
+		prefix string
+		name   string
+		exp    bool
+	}{
+		{"", "_trash_csilv2", true},
+		{"", "_trash_tenantA_csilv3", false},
+		{"", "csilv1", false},
+		{"tenantA", "_trash_tenantA_csilv3", true},
+		{"tenantA", "_trash_csilv2", false},
+		{"tenantA", "tenantA_csilv4", false},
+	} {
+		s := &Server{volumePrefix: tt.prefix}
+		if got := s.ownsTrashedVolume(tt.name); got != tt.exp {
+			t.Fatalf("%q with prefix %q: expected %v but got %v", tt.name, tt.prefix, tt.exp, got)
+		}
+	}
+}
+
+func TestTrashExpiryFromTags(t *testing.T) {
+	expiry := time.Unix(1700000000, 0)
+	if got, ok := trashExpiryFromTags([]string{"VN.name", "TX.1700000000"}); !ok || !got.Equal(expiry) {
+		t.Fatalf("Expected %v but got %v, %v", expiry, got, ok)
+	}
+	for _, tags := range [][]string{nil, {"VN.name"}, {"TX.soon"}} {
+		if got, ok := trashExpiryFromTags(tags); ok {
+			t.Fatalf("%v: expected no expiry but got %v", tags, got)
+		}
+	}
+	if err := lvm.ValidateTag(tagTrashExpiryPrefix + "1700000000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lvm.ValidateLogicalVolumeName(trashNamePrefix + "tenantA_csilv1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrashVolumeNameTooLong(t *testing.T) {
+	s := &Server{vgname: "vg0"}
+	if name, err := s.trashName("csilv1"); err != nil || name != "_trash_csilv1" {
+		t.Fatalf("Expected _trash_csilv1, got %q, %v", name, err)
+	}
+	id := strings.Repeat("p", maxVolumePrefixLength) + volumePrefixSeparator + "csilv3w5e11264sgsf"
+	s = &Server{vgname: strings.Repeat("v", 40)}
+	_, err := s.trashName(id)
+	if info, ok := ErrorReason(err); status.Code(err) != codes.FailedPrecondition || !ok || info.Reason != ReasonInvalidVolumeName {
+		t.Fatalf("Expected %v with reason %v, got %v", codes.FailedPrecondition, ReasonInvalidVolumeName, err)
+	}
+}
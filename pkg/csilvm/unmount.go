@@ -0,0 +1,78 @@
+package csilvm
+
+import (
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// unmountRetryInitialDelay is the delay before the first retry of an
+	// unmount that failed as the mount is busy. It doubles with every
+	// retry up to unmountRetryMaxDelay.
+	unmountRetryInitialDelay = 100 * time.Millisecond
+	unmountRetryMaxDelay     = 2 * time.Second
+)
+
+// unmountSyscall is unmount. It is replaced in tests.
+var unmountSyscall = unmount
+
+// UnmountRetries configures the server to retry an unmount in
+// NodeUnpublishVolume that fails with EBUSY, e.g., as a process has its
+// working directory in the mount, up to n times with exponential backoff
+// within the request deadline.
+func UnmountRetries(n int) ServerOpt {
+	return func(s *Server) {
+		s.unmountRetries = n
+	}
+}
+
+// LazyUnmount configures the server to lazily detach a mount in
+// NodeUnpublishVolume that is still busy after the retries configured by
+// UnmountRetries, so that the CO can finish cleaning up, e.g., a terminating
+// pod, instead of failing forever. The filesystem stays mounted, out of
+// sight, until the processes using it exit.
+func LazyUnmount() ServerOpt {
+	return func(s *Server) {
+		s.lazyUnmount = true
+	}
+}
+
+// unmountTarget unmounts the target path. An unmount that fails as the mount
+// is busy is retried as configured by UnmountRetries as long as the next
+// retry starts before the deadline of ctx. If the mount is still busy it is
+// lazily detached if LazyUnmount is set.
+func (s *Server) unmountTarget(ctx context.Context, targetPath string) error {
+	var err error
+	retry := 0
+	for ; ; retry++ {
+		if err = unmountSyscall(targetPath, 0); err != syscall.EBUSY {
+			return err
+		}
+		if retry >= s.unmountRetries {
+			break
+		}
+		delay := unmountRetryInitialDelay << uint(retry)
+		if delay > unmountRetryMaxDelay {
+			delay = unmountRetryMaxDelay
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			break
+		}
+		log.Printf("Unmounting %v failed as it is busy, retrying in %v", targetPath, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+	if !s.lazyUnmount {
+		return err
+	}
+	log.Printf("WARNING: %v is still busy after %d retries, lazily detaching it; the filesystem remains mounted until the processes using it exit", targetPath, retry)
+	s.metrics.Counter("lazy-unmounts").Inc(1)
+	return unmountSyscall(targetPath, mntDetach)
+}
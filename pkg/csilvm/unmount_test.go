@@ -0,0 +1,74 @@
+package csilvm
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// withBusyUnmount makes unmounts fail with EBUSY the given number of times
+// and records their flags.
+func withBusyUnmount(busy int) (*[]int, func()) {
+	var flags []int
+	old := unmountSyscall
+	unmountSyscall = func(target string, f int) error {
+		flags = append(flags, f)
+		if f == 0 && busy > 0 {
+			busy--
+			return syscall.EBUSY
+		}
+		return nil
+	}
+	return &flags, func() { unmountSyscall = old }
+}
+
+func TestUnmountTarget(t *testing.T) {
+	cases := []struct {
+		busy    int
+		opts    []ServerOpt
+		err     error
+		flags   []int
+		comment string
+	}{
+		{0, nil, nil, []int{0}, "not busy"},
+		{1, nil, syscall.EBUSY, []int{0}, "busy without retries"},
+		{2, []ServerOpt{UnmountRetries(2)}, nil, []int{0, 0, 0}, "retried until no longer busy"},
+		{3, []ServerOpt{UnmountRetries(2)}, syscall.EBUSY, []int{0, 0, 0}, "busy after retries"},
+		{3, []ServerOpt{UnmountRetries(2), LazyUnmount()}, nil, []int{0, 0, 0, mntDetach}, "lazily detached after retries"},
+	}
+	for _, tt := range cases {
+		flags, cleanup := withBusyUnmount(tt.busy)
+		s := NewServer("vg", nil, "xfs", tt.opts...)
+		err := s.unmountTarget(context.Background(), "/target")
+		cleanup()
+		if err != tt.err {
+			t.Fatalf("%s: expected err=%v but got %v", tt.comment, tt.err, err)
+		}
+		if !reflect.DeepEqual(*flags, tt.flags) {
+			t.Fatalf("%s: expected unmounts with flags %v but got %v", tt.comment, tt.flags, *flags)
+		}
+	}
+}
+
+func TestUnmountTargetDeadline(t *testing.T) {
+	flags, cleanup := withBusyUnmount(10)
+	defer cleanup()
+	s := NewServer("vg", nil, "xfs", UnmountRetries(10), LazyUnmount())
+	// The deadline leaves no time for a retry so the mount is detached
+	// right away.
+	ctx, cancel := context.WithTimeout(context.Background(), unmountRetryInitialDelay/2)
+	defer cancel()
+	start := time.Now()
+	if err := s.unmountTarget(ctx, "/target"); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []int{0, mntDetach}; !reflect.DeepEqual(*flags, exp) {
+		t.Fatalf("expected unmounts with flags %v but got %v", exp, *flags)
+	}
+	if elapsed := time.Since(start); elapsed > unmountRetryInitialDelay {
+		t.Fatalf("expected no retry but took %v", elapsed)
+	}
+}
@@ -0,0 +1,83 @@
+package csilvm
+
+import (
+	"strconv"
+
+	"github.com/mesosphere/csilvm/pkg/lvm"
+)
+
+// The usage attributes are reported by ListVolumes for volumes whose
+// filesystem is mounted if the server was created with VolumeUsageStats.
+const (
+	// attrUsageBytesUsed is the number of bytes used on the filesystem.
+	attrUsageBytesUsed = "usage-bytes-used"
+	// attrUsageBytesFree is the number of bytes available to
+	// unprivileged users on the filesystem.
+	attrUsageBytesFree = "usage-bytes-free"
+)
+
+// VolumeUsageStats configures ListVolumes to report the used and free bytes
+// of the filesystem of each mounted volume as the usage-bytes-used and
+// usage-bytes-free volume attributes. This reads the mount table and stats
+// a filesystem per mounted volume on every ListVolumes call.
+func VolumeUsageStats() ServerOpt {
+	return func(s *Server) {
+		s.volumeUsageStats = true
+	}
+}
+
+// volumeUsage maps logical volume names to the path at which their
+// filesystem is mounted.
+type volumeUsage struct {
+	mountPaths map[string]string
+}
+
+// newVolumeUsage reads the mount table and returns the mount paths of the
+// given logical volumes. If the mount table cannot be read no usage is
+// reported.
+func newVolumeUsage(lvs []lvm.LogicalVolumeReport) *volumeUsage {
+	mounts, err := listMounts()
+	if err != nil {
+		log.Printf("Cannot list mounts, not reporting volume usage: err=%v", err)
+		return &volumeUsage{}
+	}
+	sources := make(map[string]string)
+	for _, lv := range lvs {
+		addMountSources(sources, lv.Name, lv.Path)
+	}
+	return &volumeUsage{volumeMountPaths(mounts, sources)}
+}
+
+// volumeMountPaths returns the path of the first mount of each logical
+// volume whose device, as given by sources, is the mount source. The bind
+// mounts of BLOCK_DEVICE volumes are not filesystem mounts of the device
+// and are ignored.
+func volumeMountPaths(mounts []mountpoint, sources map[string]string) map[string]string {
+	paths := make(map[string]string)
+	for _, mp := range mounts {
+		lvname, ok := sources[mp.mountsource]
+		if !ok {
+			continue
+		}
+		if _, ok := paths[lvname]; !ok {
+			paths[lvname] = mp.path
+		}
+	}
+	return paths
+}
+
+// addAttributes adds the usage attributes of the logical volume to attr if
+// its filesystem is mounted.
+func (u *volumeUsage) addAttributes(lvname string, attr map[string]string) {
+	path, ok := u.mountPaths[lvname]
+	if !ok {
+		return
+	}
+	used, free, err := filesystemUsage(path)
+	if err != nil {
+		log.Printf("Cannot determine usage of volume %v mounted at %v: err=%v", lvname, path, err)
+		return
+	}
+	attr[attrUsageBytesUsed] = strconv.FormatUint(used, 10)
+	attr[attrUsageBytesFree] = strconv.FormatUint(free, 10)
+}
@@ -2,14 +2,20 @@ package csilvm
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
-var ErrRemovingMode = status.Error(
+var ErrRemovingMode = statusError(
 	codes.FailedPrecondition,
+	newErrorInfo(ReasonRemovingVolumeGroup),
 	"This service is running in 'remove volume group' mode.")
 
 func validateRemoving(removingVolumeGroup bool) error {
@@ -68,6 +74,28 @@ func validateProbeRequest(request *csi.ProbeRequest) error {
 	return nil
 }
 
+// filesystemLister is implemented by servers whose supported filesystems
+// can change at runtime, e.g., *Server.
+type filesystemLister interface {
+	SupportedFilesystems() map[string]string
+}
+
+// supportedFilesystemsOf returns the given supported filesystems and, if
+// inner is a filesystemLister, those it currently supports.
+func supportedFilesystemsOf(inner interface{}, supportedFilesystems map[string]string) map[string]string {
+	lister, ok := inner.(filesystemLister)
+	if !ok {
+		return supportedFilesystems
+	}
+	m := lister.SupportedFilesystems()
+	for k, v := range supportedFilesystems {
+		if _, ok := m[k]; !ok {
+			m[k] = v
+		}
+	}
+	return m
+}
+
 // ControllerService RPCs
 
 type controllerServerValidator struct {
@@ -80,10 +108,16 @@ func ControllerServerValidator(inner csi.ControllerServer, removingVolumeGroup b
 	return &controllerServerValidator{inner, removingVolumeGroup, supportedFilesystems}
 }
 
+// filesystems returns the filesystems supported by the inner server, which
+// may change at runtime, see ReloadConfig.
+func (v *controllerServerValidator) filesystems() map[string]string {
+	return supportedFilesystemsOf(v.inner, v.supportedFilesystems)
+}
+
 func (v *controllerServerValidator) CreateVolume(
 	ctx context.Context,
 	request *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	if err := validateCreateVolumeRequest(request, v.removingVolumeGroup, v.supportedFilesystems); err != nil {
+	if err := validateCreateVolumeRequest(request, v.removingVolumeGroup, v.filesystems()); err != nil {
 		return nil, err
 	}
 	return v.inner.CreateVolume(ctx, request)
@@ -105,9 +139,22 @@ func validateCreateVolumeRequest(request *csi.CreateVolumeRequest, removingVolum
 	if err := validateVolumeCapabilities(request.GetVolumeCapabilities(), supportedFilesystems); err != nil {
 		return err
 	}
+	if request.GetVolumeContentSource() != nil {
+		// The CREATE_DELETE_SNAPSHOT capability is not reported so a
+		// CO must not request a content source. Rather than create an
+		// empty volume the request is rejected. Restoring a snapshot,
+		// including into a larger volume, is blocked on snapshot
+		// support.
+		return ErrContentSourceNotSupported
+	}
 	return nil
 }
 
+var ErrContentSourceNotSupported = statusError(
+	codes.InvalidArgument,
+	newErrorInfo(ReasonSourceUnsupported),
+	"Creating a volume from a volume_content_source is not supported.")
+
 func (v *controllerServerValidator) DeleteVolume(
 	ctx context.Context,
 	request *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
@@ -117,7 +164,7 @@ func (v *controllerServerValidator) DeleteVolume(
 	return v.inner.DeleteVolume(ctx, request)
 }
 
-var ErrMissingVolumeId = status.Error(codes.InvalidArgument, "The volume_id field must be specified.")
+var ErrMissingVolumeId = statusError(codes.InvalidArgument, newErrorInfo(ReasonMissingField, "field", "volume_id"), "The volume_id field must be specified.")
 
 func validateDeleteVolumeRequest(request *csi.DeleteVolumeRequest, removingVolumeGroup bool) error {
 	if err := validateRemoving(removingVolumeGroup); err != nil {
@@ -130,16 +177,87 @@ func validateDeleteVolumeRequest(request *csi.DeleteVolumeRequest, removingVolum
 	return nil
 }
 
-var ErrMissingName = status.Error(codes.InvalidArgument, "The name field must be specified.")
-var ErrUnsupportedFilesystem = status.Error(codes.FailedPrecondition, "The requested filesystem type is unknown.")
+var ErrMissingName = statusError(codes.InvalidArgument, newErrorInfo(ReasonMissingField, "field", "name"), "The name field must be specified.")
+
+// maxDeviceMapperNameLen is the maximum length of a device-mapper device
+// name, excluding the terminating NUL byte.
+const maxDeviceMapperNameLen = 127
 
-var ErrCapacityRangeUnspecified = status.Error(
+// reservedVolumeNamePrefixes and reservedVolumeNameSubstrings are reserved
+// by LVM for internal volumes.
+var (
+	reservedVolumeNamePrefixes   = []string{"snapshot", "pvmove"}
+	reservedVolumeNameSubstrings = []string{
+		"_cdata", "_cmeta", "_corig", "_iorig", "_mimage", "_mlog", "_pmspare",
+		"_rimage", "_rmeta", "_tdata", "_tmeta", "_vdata", "_vorigin", "_wcorig",
+	}
+)
+
+// validateLogicalVolumeName checks that name is a valid logical volume name
+// in the volume group vgname. The error describes the offending character,
+// length or reserved word. It applies to the logical volume names chosen by
+// the plugin, not to the names given to CreateVolume, which are only
+// recorded in a tag.
+func validateLogicalVolumeName(vgname, name string) error {
+	for i, r := range name {
+		if _, ok := tagSafeChars[r]; !ok {
+			return fmt.Errorf("The name contains the invalid character %q at offset %d, valid set includes: [A-Za-z0-9_+.-]", r, i)
+		}
+	}
+	if strings.HasPrefix(name, "-") {
+		return errors.New("The name must not start with '-'")
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("The name %q is reserved", name)
+	}
+	for _, prefix := range reservedVolumeNamePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return fmt.Errorf("The name must not start with the reserved prefix %q", prefix)
+		}
+	}
+	for _, substr := range reservedVolumeNameSubstrings {
+		if strings.Contains(name, substr) {
+			return fmt.Errorf("The name must not contain the reserved string %q", substr)
+		}
+	}
+	// The device-mapper name is <vgname>-<lvname> where any '-' in
+	// either name is escaped as '--'.
+	dmlen := len(vgname) + strings.Count(vgname, "-") + 1 + len(name) + strings.Count(name, "-")
+	if dmlen > maxDeviceMapperNameLen {
+		return fmt.Errorf("The name is %d bytes long, the device-mapper name %d bytes long exceeds the maximum of %d bytes in volume group %q", len(name), dmlen, maxDeviceMapperNameLen, vgname)
+	}
+	return nil
+}
+
+var ErrUnsupportedFilesystem = statusError(codes.FailedPrecondition, newErrorInfo(ReasonFilesystemUnsupported), "The requested filesystem type is unknown.")
+
+// unsupportedFilesystemError returns the error with which requests for an
+// unsupported filesystem type are rejected. It has the code of
+// ErrUnsupportedFilesystem and names the supported filesystem types.
+func unsupportedFilesystemError(fstype string, supportedFilesystems map[string]string) error {
+	var supported []string
+	for k := range supportedFilesystems {
+		if k != "" {
+			supported = append(supported, k)
+		}
+	}
+	sort.Strings(supported)
+	return statusErrorf(
+		codes.FailedPrecondition,
+		newErrorInfo(ReasonFilesystemUnsupported, "fstype", fstype),
+		"The requested filesystem type %q is not supported, the supported filesystem types are %v.",
+		fstype, supported)
+}
+
+var ErrCapacityRangeUnspecified = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonInvalidCapacityRange),
 	"One of required_bytes or limit_bytes must "+
 		"be specified if capacity_range is specified.")
 
-var ErrCapacityRangeInvalidSize = status.Error(
+var ErrCapacityRangeInvalidSize = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonInvalidCapacityRange),
 	"The required_bytes cannot exceed the limit_bytes.")
 
 func validateCapacityRange(capacityRange *csi.CapacityRange) error {
@@ -154,8 +272,9 @@ func validateCapacityRange(capacityRange *csi.CapacityRange) error {
 		return nil
 	}
 	if capacityRange.GetRequiredBytes() > capacityRange.GetLimitBytes() {
-		return status.Errorf(
+		return statusErrorf(
 			codes.InvalidArgument,
+			newErrorInfo(ReasonInvalidCapacityRange),
 			"required_bytes: %d cannot exceed the limit_bytes: %d",
 			capacityRange.GetRequiredBytes(),
 			capacityRange.GetLimitBytes(),
@@ -179,7 +298,7 @@ func (v *controllerServerValidator) ControllerUnpublishVolume(
 func (v *controllerServerValidator) ValidateVolumeCapabilities(
 	ctx context.Context,
 	request *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	if err := validateValidateVolumeCapabilitiesRequest(request, v.removingVolumeGroup, v.supportedFilesystems); err != nil {
+	if err := validateValidateVolumeCapabilitiesRequest(request, v.removingVolumeGroup, v.filesystems()); err != nil {
 		return nil, err
 	}
 	return v.inner.ValidateVolumeCapabilities(ctx, request)
@@ -193,13 +312,40 @@ func validateValidateVolumeCapabilitiesRequest(request *csi.ValidateVolumeCapabi
 	if volumeId == "" {
 		return ErrMissingVolumeId
 	}
-	if err := validateVolumeCapabilities(request.GetVolumeCapabilities(), supportedFilesystems); err != nil {
-		return err
+	volumeCapabilities := request.GetVolumeCapabilities()
+	if len(volumeCapabilities) == 0 {
+		return ErrMissingVolumeCapabilities
+	}
+	// Unsupported filesystems and access modes are not errors here. The
+	// server reports them as denied in the response instead.
+	for _, volumeCapability := range volumeCapabilities {
+		if volumeCapability.GetAccessType() == nil {
+			return ErrMissingAccessType
+		}
+		if err := validateAccessMode(volumeCapability.GetAccessMode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateAccessMode checks that the access mode is present and known,
+// without regard to whether this plugin supports it.
+func validateAccessMode(accessMode *csi.VolumeCapability_AccessMode) error {
+	if accessMode == nil {
+		return ErrMissingAccessMode
+	}
+	mode := accessMode.GetMode()
+	if mode == csi.VolumeCapability_AccessMode_UNKNOWN {
+		return ErrMissingAccessModeMode
+	}
+	if !isKnownAccessMode(mode) {
+		return ErrInvalidAccessMode
 	}
 	return nil
 }
 
-var ErrMissingVolumeCapabilities = status.Error(codes.InvalidArgument, "The volume_capabilities field must be specified.")
+var ErrMissingVolumeCapabilities = statusError(codes.InvalidArgument, newErrorInfo(ReasonMissingField, "field", "volume_capabilities"), "The volume_capabilities field must be specified.")
 
 func validateVolumeCapabilities(volumeCapabilities []*csi.VolumeCapability, supportedFilesystems map[string]string) error {
 	if len(volumeCapabilities) == 0 {
@@ -207,33 +353,35 @@ func validateVolumeCapabilities(volumeCapabilities []*csi.VolumeCapability, supp
 	}
 	for _, volumeCapability := range volumeCapabilities {
 		const treatUnsupportedFsAsError = false
-		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, treatUnsupportedFsAsError, false); err != nil {
+		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, treatUnsupportedFsAsError); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-var ErrMissingAccessType = status.Error(
+var ErrMissingAccessType = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonMissingField, "field", "volume_capability.access_type"),
 	"The volume_capability.access_type field must be specified.")
-var ErrMissingAccessMode = status.Error(
+var ErrMissingAccessMode = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonMissingField, "field", "volume_capability.access_mode"),
 	"The volume_capability.access_mode field must be specified.")
-var ErrMissingAccessModeMode = status.Error(
+var ErrMissingAccessModeMode = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonMissingField, "field", "volume_capability.access_mode.mode"),
 	"The volume_capability.access_mode.mode field must be specified.")
-var ErrInvalidAccessMode = status.Error(
+var ErrInvalidAccessMode = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonInvalidAccessMode),
 	"The volume_capability.access_mode.mode is invalid.")
-var ErrUnsupportedAccessMode = status.Error(
+var ErrUnsupportedAccessMode = statusError(
 	codes.InvalidArgument,
+	newErrorInfo(ReasonUnsupportedAccessMode),
 	"The volume_capability.access_mode.mode is unsupported.")
-var ErrBlockVolNoRO = status.Error(
-	codes.InvalidArgument,
-	"Cannot publish block volume as readonly.")
 
-func validateVolumeCapability(volumeCapability *csi.VolumeCapability, supportedFilesystems map[string]string, unsupportedFsOK, readonly bool) error {
+func validateVolumeCapability(volumeCapability *csi.VolumeCapability, supportedFilesystems map[string]string, unsupportedFsOK bool) error {
 	accessType := volumeCapability.GetAccessType()
 	if accessType == nil {
 		return ErrMissingAccessType
@@ -244,14 +392,7 @@ func validateVolumeCapability(volumeCapability *csi.VolumeCapability, supportedF
 		// If unsupportedFsOK is true, we don't treat an unsupported
 		// filesystem as an error.
 		if _, ok := supportedFilesystems[fstype]; !ok && !unsupportedFsOK {
-			return ErrUnsupportedFilesystem
-		}
-	}
-	if block := volumeCapability.GetBlock(); block != nil {
-		readonly = readonly || volumeCapability.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
-		if readonly {
-			// A block device cannot be bind mounted readonly.
-			return ErrBlockVolNoRO
+			return unsupportedFilesystemError(fstype, supportedFilesystems)
 		}
 	}
 	accessMode := volumeCapability.GetAccessMode()
@@ -263,7 +404,9 @@ func validateVolumeCapability(volumeCapability *csi.VolumeCapability, supportedF
 		case csi.VolumeCapability_AccessMode_UNKNOWN:
 			return ErrMissingAccessModeMode
 		case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
-			csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER:
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			accessModeSingleNodeSingleWriter,
+			accessModeSingleNodeMultiWriter:
 			// Single node modes are satisfiable with this plugin.
 		case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
 			csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
@@ -293,7 +436,7 @@ func validateListVolumesRequest(request *csi.ListVolumesRequest) error {
 func (v *controllerServerValidator) GetCapacity(
 	ctx context.Context,
 	request *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	if err := validateGetCapacityRequest(request, v.supportedFilesystems); err != nil {
+	if err := validateGetCapacityRequest(request, v.filesystems()); err != nil {
 		return nil, err
 	}
 	return v.inner.GetCapacity(ctx, request)
@@ -305,7 +448,7 @@ func validateGetCapacityRequest(request *csi.GetCapacityRequest, supportedFilesy
 		// We don't treat "unsupported fs type" as an error for
 		// GetCapacity. We'll just return 0 capacity.
 		const ignoreUnsupportedFs = true
-		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, ignoreUnsupportedFs, false); err != nil {
+		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, ignoreUnsupportedFs); err != nil {
 			return err
 		}
 	}
@@ -349,26 +492,88 @@ type nodeServerValidator struct {
 	inner                csi.NodeServer
 	removingVolumeGroup  bool
 	supportedFilesystems map[string]string
+	createTargetPath     bool
+}
+
+// NodeServerValidator validates requests before passing them to inner. If
+// createTargetPath is true, NodePublishVolume accepts target paths that do
+// not exist yet, see CreateTargetPath.
+func NodeServerValidator(inner csi.NodeServer, removingVolumeGroup bool, supportedFilesystems map[string]string, createTargetPath bool) csi.NodeServer {
+	return &nodeServerValidator{inner, removingVolumeGroup, supportedFilesystems, createTargetPath}
 }
 
-func NodeServerValidator(inner csi.NodeServer, removingVolumeGroup bool, supportedFilesystems map[string]string) csi.NodeServer {
-	return &nodeServerValidator{inner, removingVolumeGroup, supportedFilesystems}
+// filesystems returns the filesystems supported by the inner server, which
+// may change at runtime, see ReloadConfig.
+func (v *nodeServerValidator) filesystems() map[string]string {
+	return supportedFilesystemsOf(v.inner, v.supportedFilesystems)
 }
 
 func (v *nodeServerValidator) NodePublishVolume(
 	ctx context.Context,
 	request *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	if err := validateNodePublishVolumeRequest(request, v.removingVolumeGroup, v.supportedFilesystems); err != nil {
+	if err := validateNodePublishVolumeRequest(request, v.removingVolumeGroup, v.filesystems(), v.createTargetPath); err != nil {
 		return nil, err
 	}
 	return v.inner.NodePublishVolume(ctx, request)
 }
 
-var ErrMissingTargetPath = status.Error(codes.InvalidArgument, "The target_path field must be specified.")
-var ErrMissingVolumeCapability = status.Error(codes.InvalidArgument, "The volume_capability field must be specified.")
-var ErrSpecifiedPublishInfo = status.Error(codes.InvalidArgument, "The publish_volume_info field must not be specified.")
+var ErrMissingTargetPath = statusError(codes.InvalidArgument, newErrorInfo(ReasonMissingField, "field", "target_path"), "The target_path field must be specified.")
+var ErrMissingVolumeCapability = statusError(codes.InvalidArgument, newErrorInfo(ReasonMissingField, "field", "volume_capability"), "The volume_capability field must be specified.")
+var ErrSpecifiedPublishInfo = statusError(codes.InvalidArgument, newErrorInfo(ReasonInvalidField, "field", "publish_volume_info"), "The publish_volume_info field must not be specified.")
+var ErrTargetPathNotAbsolute = statusError(codes.InvalidArgument, newErrorInfo(ReasonInvalidTargetPath), "The target_path field must be an absolute path.")
+var ErrTargetPathTraversal = statusError(codes.InvalidArgument, newErrorInfo(ReasonInvalidTargetPath), "The target_path field must not contain '..' elements.")
+var ErrTargetPathNotFound = statusError(codes.InvalidArgument, newErrorInfo(ReasonInvalidTargetPath), "The target_path does not exist.")
+var ErrTargetPathNotDirectory = statusError(codes.InvalidArgument, newErrorInfo(ReasonInvalidTargetPath), "The target_path of a mount volume must be a directory.")
+var ErrTargetPathNotFile = statusError(codes.InvalidArgument, newErrorInfo(ReasonInvalidTargetPath), "The target_path of a block volume must be a file.")
+
+// validateTargetPath checks that targetPath is an absolute path without
+// '..' elements. Such paths could otherwise be used to publish a volume
+// outside of the directory the CO intended.
+func validateTargetPath(targetPath string) error {
+	if targetPath == "" {
+		return ErrMissingTargetPath
+	}
+	if !filepath.IsAbs(targetPath) {
+		return ErrTargetPathNotAbsolute
+	}
+	for _, elem := range strings.Split(targetPath, string(filepath.Separator)) {
+		if elem == ".." {
+			return ErrTargetPathTraversal
+		}
+	}
+	return nil
+}
 
-func validateNodePublishVolumeRequest(request *csi.NodePublishVolumeRequest, removingVolumeGroup bool, supportedFilesystems map[string]string) error {
+// validateTargetPathType checks that targetPath exists and is of the type
+// the volume is published as: a directory for mount volumes and a file for
+// block volumes onto which the device is bind mounted. Symlinks are
+// rejected as the mount would follow them. If missingOK is true, a target
+// path that does not exist is accepted.
+func validateTargetPathType(targetPath string, volumeCapability *csi.VolumeCapability, missingOK bool) error {
+	info, err := os.Lstat(targetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if missingOK {
+				return nil
+			}
+			return ErrTargetPathNotFound
+		}
+		return statusErrorf(codes.InvalidArgument, newErrorInfo(ReasonInvalidTargetPath, "targetPath", targetPath), "Cannot stat the target_path: err=%v", err)
+	}
+	switch {
+	case volumeCapability.GetMount() != nil:
+		if !info.IsDir() {
+			return ErrTargetPathNotDirectory
+		}
+	case volumeCapability.GetBlock() != nil:
+		if info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+			return ErrTargetPathNotFile
+		}
+	}
+	return nil
+}
+
+func validateNodePublishVolumeRequest(request *csi.NodePublishVolumeRequest, removingVolumeGroup bool, supportedFilesystems map[string]string, createTargetPath bool) error {
 	if err := validateRemoving(removingVolumeGroup); err != nil {
 		return err
 	}
@@ -381,19 +586,22 @@ func validateNodePublishVolumeRequest(request *csi.NodePublishVolumeRequest, rem
 		return ErrSpecifiedPublishInfo
 	}
 	targetPath := request.GetTargetPath()
-	if targetPath == "" {
-		return ErrMissingTargetPath
+	if err := validateTargetPath(targetPath); err != nil {
+		return err
 	}
 	volumeCapability := request.GetVolumeCapability()
 	if volumeCapability == nil {
 		return ErrMissingVolumeCapability
 	} else {
 		const treatUnsupportedFsAsError = false
-		readonly := request.GetReadonly()
-		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, treatUnsupportedFsAsError, readonly); err != nil {
+		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, treatUnsupportedFsAsError); err != nil {
 			return err
 		}
 	}
+	// We check the target path last as it touches the filesystem.
+	if err := validateTargetPathType(targetPath, volumeCapability, createTargetPath); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -414,9 +622,9 @@ func validateNodeUnpublishVolumeRequest(request *csi.NodeUnpublishVolumeRequest,
 	if volumeId == "" {
 		return ErrMissingVolumeId
 	}
-	targetPath := request.GetTargetPath()
-	if targetPath == "" {
-		return ErrMissingTargetPath
+	// The target path need not exist as unpublishing is idempotent.
+	if err := validateTargetPath(request.GetTargetPath()); err != nil {
+		return err
 	}
 	return nil
 }
@@ -457,3 +665,38 @@ func (v *nodeServerValidator) NodeUnstageVolume(
 	request *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
 	return v.inner.NodeUnstageVolume(ctx, request)
 }
+
+// ModifyService RPCs
+
+type modifyServerValidator struct {
+	inner               ModifyServer
+	removingVolumeGroup bool
+}
+
+func ModifyServerValidator(inner ModifyServer, removingVolumeGroup bool) ModifyServer {
+	return &modifyServerValidator{inner, removingVolumeGroup}
+}
+
+func (v *modifyServerValidator) ModifyVolume(
+	ctx context.Context,
+	request *ModifyVolumeRequest) (*ModifyVolumeResponse, error) {
+	if err := validateModifyVolumeRequest(request, v.removingVolumeGroup); err != nil {
+		return nil, err
+	}
+	return v.inner.ModifyVolume(ctx, request)
+}
+
+var ErrMissingMutableParameters = statusError(codes.InvalidArgument, newErrorInfo(ReasonMissingField, "field", "mutable_parameters"), "The mutable_parameters field must be specified.")
+
+func validateModifyVolumeRequest(request *ModifyVolumeRequest, removingVolumeGroup bool) error {
+	if err := validateRemoving(removingVolumeGroup); err != nil {
+		return err
+	}
+	if request.GetVolumeId() == "" {
+		return ErrMissingVolumeId
+	}
+	if len(request.GetMutableParameters()) == 0 {
+		return ErrMissingMutableParameters
+	}
+	return nil
+}
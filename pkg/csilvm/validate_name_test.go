@@ -0,0 +1,46 @@
+package csilvm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLogicalVolumeName(t *testing.T) {
+	valid := []string{
+		"test-volume",
+		"a",
+		"A_b+c.d-1",
+		"my-snapshot",
+		strings.Repeat("a", maxDeviceMapperNameLen-len("vg0")-1),
+	}
+	for _, name := range valid {
+		if err := validateLogicalVolumeName("vg0", name); err != nil {
+			t.Fatalf("expected %q to be valid: %v", name, err)
+		}
+	}
+	invalid := []struct {
+		vgname, name, exp string
+	}{
+		{"vg0", "bad name", `invalid character ' ' at offset 3`},
+		{"vg0", "volume/1", `invalid character '/' at offset 6`},
+		{"vg0", "é", `invalid character 'é' at offset 0`},
+		{"vg0", "-volume", `must not start with '-'`},
+		{"vg0", ".", `"." is reserved`},
+		{"vg0", "..", `".." is reserved`},
+		{"vg0", "snapshot1", `reserved prefix "snapshot"`},
+		{"vg0", "pvmove0", `reserved prefix "pvmove"`},
+		{"vg0", "data_rimage_0", `reserved string "_rimage"`},
+		{"vg0", strings.Repeat("a", maxDeviceMapperNameLen-len("vg0")), "is 124 bytes long, the device-mapper name 128 bytes long"},
+		// Dashes are escaped in device-mapper names.
+		{"vg-0", "ab" + strings.Repeat("-", 60), "is 62 bytes long, the device-mapper name 128 bytes long"},
+	}
+	for _, tt := range invalid {
+		err := validateLogicalVolumeName(tt.vgname, tt.name)
+		if err == nil {
+			t.Fatalf("expected %q to be invalid", tt.name)
+		}
+		if !strings.Contains(err.Error(), tt.exp) {
+			t.Fatalf("expected error for %q to contain %q but got %q", tt.name, tt.exp, err)
+		}
+	}
+}
@@ -1,9 +1,11 @@
-// +build !unit
+// +build linux,!unit
 
 package csilvm
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -39,6 +41,21 @@ func TestCreateVolumeMissingName(t *testing.T) {
 	}
 }
 
+func TestCreateVolumeContentSource(t *testing.T) {
+	client, cleanup := startTestValidate()
+	defer cleanup()
+	req := testCreateVolumeRequest()
+	req.VolumeContentSource = &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{Id: "snapshot-1"},
+		},
+	}
+	_, err := client.CreateVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrContentSourceNotSupported) {
+		t.Fatal(err)
+	}
+}
+
 func TestCreateVolumeMissingVolumeCapabilities(t *testing.T) {
 	client, cleanup := startTestValidate()
 	defer cleanup()
@@ -94,6 +111,17 @@ func TestCreateVolumeVolumeCapabilitiesAccessModeUnsupported(t *testing.T) {
 	}
 }
 
+func TestCreateVolumeVolumeCapabilitiesAccessModeSingleNodeMultiWriter(t *testing.T) {
+	client, cleanup := startTestValidate()
+	defer cleanup()
+	req := testCreateVolumeRequest()
+	req.VolumeCapabilities[0].AccessMode.Mode = accessModeSingleNodeMultiWriter
+	_, err := client.CreateVolume(context.Background(), req)
+	if grpcErrorEqual(err, ErrInvalidAccessMode) || grpcErrorEqual(err, ErrUnsupportedAccessMode) {
+		t.Fatal(err)
+	}
+}
+
 func TestCreateVolumeVolumeCapabilitiesAccessModeInvalid(t *testing.T) {
 	client, cleanup := startTestValidate()
 	defer cleanup()
@@ -110,8 +138,9 @@ func TestCreateVolumeVolumeCapabilitiesReadonlyBlock(t *testing.T) {
 	defer cleanup()
 	req := testCreateVolumeRequest()
 	req.VolumeCapabilities[0].AccessMode.Mode = csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
-	_, err := client.CreateVolume(context.Background(), req)
-	if !grpcErrorEqual(err, ErrBlockVolNoRO) {
+	// The device is set readonly when a block volume is published
+	// readonly.
+	if _, err := client.CreateVolume(context.Background(), req); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -215,7 +244,9 @@ func TestValidateVolumeCapabilitiesNodeUnpublishVolume_MountVolume_BadFilesystem
 	defer cleanup()
 	req := testValidateVolumeCapabilitiesRequest("fake_volume_id", "ext4", nil)
 	_, err := client.ValidateVolumeCapabilities(context.Background(), req)
-	if !grpcErrorEqual(err, ErrUnsupportedFilesystem) {
+	// An unsupported filesystem is reported as denied by the server
+	// rather than rejected by the validator.
+	if !grpcErrorEqual(err, ErrVolumeNotFound) {
 		t.Fatal(err)
 	}
 }
@@ -348,6 +379,62 @@ func TestNodePublishVolumeMissingTargetPath(t *testing.T) {
 	}
 }
 
+func TestNodePublishVolumeRelativeTargetPath(t *testing.T) {
+	client, cleanup := startTestValidate()
+	defer cleanup()
+	req := testNodePublishVolumeRequest("fake_volume_id", "run/dcos/csilvm/mnt", "", nil)
+	_, err := client.NodePublishVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrTargetPathNotAbsolute) {
+		t.Fatal(err)
+	}
+}
+
+func TestNodePublishVolumeTargetPathTraversal(t *testing.T) {
+	client, cleanup := startTestValidate()
+	defer cleanup()
+	req := testNodePublishVolumeRequest("fake_volume_id", fakeMountDir+"/../../etc", "", nil)
+	_, err := client.NodePublishVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrTargetPathTraversal) {
+		t.Fatal(err)
+	}
+}
+
+func TestNodePublishVolumeTargetPathNotFound(t *testing.T) {
+	client, cleanup := startTestValidate()
+	defer cleanup()
+	req := testNodePublishVolumeRequest("fake_volume_id", fakeTargetPath, "", nil)
+	_, err := client.NodePublishVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrTargetPathNotFound) {
+		t.Fatal(err)
+	}
+}
+
+func TestNodePublishVolumeTargetPathWrongType(t *testing.T) {
+	client, cleanup := startTestValidate()
+	defer cleanup()
+	tmpdirPath, err := ioutil.TempDir("", "csilvm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdirPath)
+	filePath := filepath.Join(tmpdirPath, "file")
+	if err := ioutil.WriteFile(filePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A mount volume cannot be published to a file.
+	req := testNodePublishVolumeRequest("fake_volume_id", filePath, "", nil)
+	_, err = client.NodePublishVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrTargetPathNotDirectory) {
+		t.Fatal(err)
+	}
+	// A block volume cannot be published to a directory.
+	req = testNodePublishVolumeRequest("fake_volume_id", tmpdirPath, "block", nil)
+	_, err = client.NodePublishVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrTargetPathNotFile) {
+		t.Fatal(err)
+	}
+}
+
 func TestNodePublishVolumeMissingVolumeCapability(t *testing.T) {
 	client, cleanup := startTestValidate()
 	defer cleanup()
@@ -397,7 +484,7 @@ func TestNodePublishVolumeNodeUnpublishVolume_MountVolume_BadFilesystem(t *testi
 	defer cleanup()
 	req := testNodePublishVolumeRequest("fake_volume_id", fakeMountDir, "ext4", nil)
 	_, err := client.NodePublishVolume(context.Background(), req)
-	if !grpcErrorEqual(err, ErrUnsupportedFilesystem) {
+	if !grpcErrorEqual(err, unsupportedFilesystemError("ext4", map[string]string{"xfs": "xfs"})) {
 		t.Fatal(err)
 	}
 }
@@ -436,6 +523,16 @@ func TestNodeUnpublishVolumeMissingTargetPath(t *testing.T) {
 	}
 }
 
+func TestNodeUnpublishVolumeTargetPathTraversal(t *testing.T) {
+	client, cleanup := startTestValidate()
+	defer cleanup()
+	req := testNodeUnpublishVolumeRequest("fake_volume_id", fakeMountDir+"/../../etc")
+	_, err := client.NodeUnpublishVolume(context.Background(), req)
+	if !grpcErrorEqual(err, ErrTargetPathTraversal) {
+		t.Fatal(err)
+	}
+}
+
 func grpcErrorEqual(gotErr, expErr error) bool {
 	got, ok := status.FromError(gotErr)
 	if !ok {
@@ -0,0 +1,182 @@
+package csilvm
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// maxEventVolumes bounds the number of volumes whose events are kept. The
+// events of the volume with the oldest latest event are dropped first.
+const maxEventVolumes = 1024
+
+// VolumeEvent records a CreateVolume, DeleteVolume, NodePublishVolume or
+// NodeUnpublishVolume call for a volume.
+type VolumeEvent struct {
+	Time time.Time `json:"time"`
+	// Method is the RPC name, e.g., "CreateVolume".
+	Method string `json:"method"`
+	// VolumeID is empty for a CreateVolume that failed.
+	VolumeID string `json:"volumeId,omitempty"`
+	// Name is the name requested by CreateVolume.
+	Name       string  `json:"name,omitempty"`
+	TargetPath string  `json:"targetPath,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+	// Code, Reason and Error describe the error of a failed call.
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// VolumeEvents keeps the recent lifecycle events of each volume in memory so
+// that operators can find out what happened to a volume without searching
+// the node's logs. The events are recorded by its Interceptor and served by
+// its Handler.
+type VolumeEvents struct {
+	mu        sync.Mutex
+	perVolume int
+	// events maps a volume id or, for a CreateVolume that failed, the
+	// requested name to its events, oldest first.
+	events map[string][]VolumeEvent
+}
+
+// NewVolumeEvents returns a VolumeEvents that keeps the last perVolume
+// events of each volume.
+func NewVolumeEvents(perVolume int) *VolumeEvents {
+	return &VolumeEvents{
+		perVolume: perVolume,
+		events:    make(map[string][]VolumeEvent),
+	}
+}
+
+// add records the event under the given key.
+func (v *VolumeEvents) add(key string, event VolumeEvent) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	events, ok := v.events[key]
+	if !ok && len(v.events) >= maxEventVolumes {
+		v.evictOldest()
+	}
+	events = append(events, event)
+	if len(events) > v.perVolume {
+		events = append([]VolumeEvent(nil), events[len(events)-v.perVolume:]...)
+	}
+	v.events[key] = events
+}
+
+// evictOldest drops the events of the volume whose latest event is the
+// oldest.
+func (v *VolumeEvents) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, events := range v.events {
+		if t := events[len(events)-1].Time; oldestKey == "" || t.Before(oldest) {
+			oldestKey, oldest = key, t
+		}
+	}
+	delete(v.events, oldestKey)
+}
+
+// Events returns the events recorded for the volume id or CreateVolume
+// name, oldest first.
+func (v *VolumeEvents) Events(volume string) []VolumeEvent {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	events := make([]VolumeEvent, len(v.events[volume]))
+	copy(events, v.events[volume])
+	return events
+}
+
+// Volumes returns the sorted volume ids and CreateVolume names for which
+// events are recorded.
+func (v *VolumeEvents) Volumes() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	volumes := make([]string, 0, len(v.events))
+	for key := range v.events {
+		volumes = append(volumes, key)
+	}
+	sort.Strings(volumes)
+	return volumes
+}
+
+// volumeEvent returns the event of a call and the key under which it is
+// recorded, or false if the call is not a lifecycle event.
+func volumeEvent(req, resp interface{}) (string, VolumeEvent, bool) {
+	var event VolumeEvent
+	switch r := req.(type) {
+	case *csi.CreateVolumeRequest:
+		event.Method = "CreateVolume"
+		event.Name = r.GetName()
+		if cv, ok := resp.(*csi.CreateVolumeResponse); ok {
+			event.VolumeID = cv.GetVolume().GetId()
+		}
+	case *csi.DeleteVolumeRequest:
+		event.Method = "DeleteVolume"
+		event.VolumeID = r.GetVolumeId()
+	case *csi.NodePublishVolumeRequest:
+		event.Method = "NodePublishVolume"
+		event.VolumeID = r.GetVolumeId()
+		event.TargetPath = r.GetTargetPath()
+	case *csi.NodeUnpublishVolumeRequest:
+		event.Method = "NodeUnpublishVolume"
+		event.VolumeID = r.GetVolumeId()
+		event.TargetPath = r.GetTargetPath()
+	default:
+		return "", event, false
+	}
+	key := event.VolumeID
+	if key == "" {
+		key = event.Name
+	}
+	return key, event, key != ""
+}
+
+// Interceptor returns an interceptor that records the lifecycle events of
+// volumes along with a snapshot of the error of failed calls. A dry run of
+// CreateVolume is recorded under the requested name as it has no volume id.
+func (v *VolumeEvents) Interceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		key, event, ok := volumeEvent(req, resp)
+		if !ok {
+			return resp, err
+		}
+		event.Time = start.UTC()
+		event.DurationMs = float64(time.Since(start)) / float64(time.Millisecond)
+		if err != nil {
+			event.Code = status.Code(err).String()
+			if ei, ok := ErrorReason(err); ok {
+				event.Reason = ei.Reason
+			}
+			event.Error = err.Error()
+		}
+		v.add(key, event)
+		return resp, err
+	}
+}
+
+// Handler returns a read-only HTTP handler that serves the recorded events
+// of the volume id or CreateVolume name given by the "volume" query
+// parameter as JSON at /volume-events. Without the parameter it serves the
+// volumes for which events are recorded.
+func (v *VolumeEvents) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/volume-events", func(w http.ResponseWriter, r *http.Request) {
+		volume := r.URL.Query().Get("volume")
+		jsonHandler("volume events", func(ctx context.Context) (interface{}, error) {
+			if volume == "" {
+				return v.Volumes(), nil
+			}
+			return v.Events(volume), nil
+		})(w, r)
+	})
+	return mux
+}
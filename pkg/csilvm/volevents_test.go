@@ -0,0 +1,116 @@
+package csilvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestVolumeEventsRing(t *testing.T) {
+	v := NewVolumeEvents(2)
+	for i := 0; i < 3; i++ {
+		v.add("vol", VolumeEvent{Method: fmt.Sprintf("m%d", i)})
+	}
+	got := v.Events("vol")
+	if len(got) != 2 || got[0].Method != "m1" || got[1].Method != "m2" {
+		t.Fatalf("expected the last 2 events, got %+v", got)
+	}
+	if got := v.Events("other"); len(got) != 0 {
+		t.Fatalf("expected no events, got %+v", got)
+	}
+}
+
+func TestVolumeEventsEviction(t *testing.T) {
+	v := NewVolumeEvents(1)
+	start := time.Now()
+	for i := 0; i < maxEventVolumes; i++ {
+		v.add(fmt.Sprintf("vol%d", i), VolumeEvent{Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	// vol0 has the oldest latest event until it gets a new one.
+	v.add("vol0", VolumeEvent{Time: start.Add(time.Hour)})
+	v.add("new", VolumeEvent{Time: start.Add(time.Hour)})
+	if len(v.Volumes()) != maxEventVolumes {
+		t.Fatalf("expected %d volumes, got %d", maxEventVolumes, len(v.Volumes()))
+	}
+	if len(v.Events("vol0")) != 1 || len(v.Events("new")) != 1 {
+		t.Fatalf("expected vol0 and new to be kept")
+	}
+	if len(v.Events("vol1")) != 0 {
+		t.Fatalf("expected vol1 to be evicted")
+	}
+}
+
+func TestVolumeEventsInterceptor(t *testing.T) {
+	v := NewVolumeEvents(16)
+	intercept := v.Interceptor()
+	info := &grpc.UnaryServerInfo{}
+	call := func(req, resp interface{}, err error) {
+		intercept(context.Background(), req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return resp, err
+		})
+	}
+	s := NewServer("vg", nil, "xfs")
+	call(&csi.CreateVolumeRequest{Name: "too-big"}, nil,
+		statusErrorf(codes.OutOfRange, s.errorInfo(ReasonInsufficientCapacity), "not enough space"))
+	call(&csi.CreateVolumeRequest{Name: "web"}, &csi.CreateVolumeResponse{Volume: &csi.Volume{Id: "csilv1"}}, nil)
+	call(&csi.NodePublishVolumeRequest{VolumeId: "csilv1", TargetPath: "/mnt/web"}, &csi.NodePublishVolumeResponse{}, nil)
+	call(&csi.ListVolumesRequest{}, &csi.ListVolumesResponse{}, nil)
+	call(&csi.DeleteVolumeRequest{VolumeId: "csilv1"}, nil,
+		statusErrorf(codes.Internal, s.errorInfo(ReasonWipeFailed), "wipe failed"))
+
+	if got, want := v.Volumes(), []string{"csilv1", "too-big"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected volumes %v, got %v", want, got)
+	}
+	failed := v.Events("too-big")
+	if len(failed) != 1 || failed[0].Method != "CreateVolume" || failed[0].Code != "OutOfRange" ||
+		failed[0].Reason != ReasonInsufficientCapacity || failed[0].Error == "" || failed[0].Time.IsZero() {
+		t.Fatalf("unexpected events of the failed create: %+v", failed)
+	}
+	var methods []string
+	for _, event := range v.Events("csilv1") {
+		methods = append(methods, event.Method)
+	}
+	if want := []string{"CreateVolume", "NodePublishVolume", "DeleteVolume"}; !reflect.DeepEqual(methods, want) {
+		t.Fatalf("expected events %v, got %v", want, methods)
+	}
+	events := v.Events("csilv1")
+	if events[0].Name != "web" || events[1].TargetPath != "/mnt/web" || events[1].Code != "" || events[2].Reason != ReasonWipeFailed {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestVolumeEventsHandler(t *testing.T) {
+	v := NewVolumeEvents(16)
+	v.add("csilv1", VolumeEvent{Method: "CreateVolume", VolumeID: "csilv1"})
+	rec := httptest.NewRecorder()
+	v.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/volume-events?volume=csilv1", nil))
+	var events []VolumeEvent
+	if err := json.Unmarshal(rec.Body.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].VolumeID != "csilv1" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	rec = httptest.NewRecorder()
+	v.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/volume-events", nil))
+	var volumes []string
+	if err := json.Unmarshal(rec.Body.Bytes(), &volumes); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(volumes, []string{"csilv1"}) {
+		t.Fatalf("unexpected volumes: %v", volumes)
+	}
+	rec = httptest.NewRecorder()
+	v.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/volume-events", nil))
+	if rec.Code != 405 {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
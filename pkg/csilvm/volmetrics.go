@@ -0,0 +1,117 @@
+package csilvm
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/uber-go/tally"
+)
+
+const (
+	// layoutUnknown is the layout metric tag of requests that do not
+	// describe the layout of the volume, e.g., DeleteVolume.
+	layoutUnknown = "unknown"
+	// volumeSliceNone is the volume_slice metric tag of requests without a
+	// volume id, e.g., a CreateVolume that failed.
+	volumeSliceNone = "none"
+)
+
+// VolumeMetricSlices causes MetricsInterceptor to tag the request metrics of
+// CreateVolume, DeleteVolume, NodePublishVolume and NodeUnpublishVolume with
+// the volume_slice, one of n slices into which volume ids are hashed, and the
+// layout of the volume, if the request describes it, so that operators can
+// tell which volumes are failing or slow without one time series per volume.
+func VolumeMetricSlices(n int) InterceptorOpt {
+	return func(o *interceptorOpts) {
+		o.volumeSlices = n
+	}
+}
+
+// volumeSlice returns the slice of the n slices into which the volume id is
+// hashed: the first four bytes of the SHA-1 of the id, as a big-endian
+// integer, modulo n.
+func volumeSlice(id string, n int) string {
+	if id == "" {
+		return volumeSliceNone
+	}
+	sum := sha1.Sum([]byte(id))
+	return strconv.FormatUint(uint64(binary.BigEndian.Uint32(sum[:4])%uint32(n)), 10)
+}
+
+// layoutFromVolumeType returns the layout metric tag of the type parameter
+// of CreateVolume.
+func layoutFromVolumeType(voltype string) string {
+	switch voltype {
+	case "", "linear":
+		return "linear"
+	case "raid1":
+		return "raid1"
+	}
+	return layoutUnknown
+}
+
+// layoutFromAttributes returns the layout metric tag recorded by the layout
+// tag among the tags in the volume attributes.
+func layoutFromAttributes(attr map[string]string) string {
+	buf, err := base64.RawURLEncoding.DecodeString(attr[attrTags])
+	if err != nil {
+		return layoutUnknown
+	}
+	var tags []string
+	if err := json.Unmarshal(buf, &tags); err != nil {
+		return layoutUnknown
+	}
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, tagLayoutPrefix) {
+			return layoutFromVolumeType(strings.SplitN(strings.TrimPrefix(tag, tagLayoutPrefix), ".", 2)[0])
+		}
+	}
+	return layoutUnknown
+}
+
+// volumeMetricTags returns the volume_slice and layout metric tags of a
+// volume request and its response, or nil if the request is not one of
+// those tagged by VolumeMetricSlices.
+func volumeMetricTags(req, resp interface{}, n int) map[string]string {
+	var id, layout string
+	switch r := req.(type) {
+	case *csi.CreateVolumeRequest:
+		if cv, ok := resp.(*csi.CreateVolumeResponse); ok {
+			id = cv.GetVolume().GetId()
+		}
+		layout = layoutFromVolumeType(r.GetParameters()["type"])
+	case *csi.DeleteVolumeRequest:
+		id = r.GetVolumeId()
+		layout = layoutUnknown
+	case *csi.NodePublishVolumeRequest:
+		id = r.GetVolumeId()
+		layout = layoutFromAttributes(r.GetVolumeAttributes())
+	case *csi.NodeUnpublishVolumeRequest:
+		id = r.GetVolumeId()
+		layout = layoutUnknown
+	default:
+		return nil
+	}
+	return map[string]string{
+		"volume_slice": volumeSlice(id, n),
+		"layout":       layout,
+	}
+}
+
+// volumeScope returns the metrics scope of a request tagged as configured by
+// VolumeMetricSlices.
+func (o *interceptorOpts) volumeScope(scope tally.Scope, req, resp interface{}) tally.Scope {
+	if o.volumeSlices <= 0 {
+		return scope
+	}
+	tags := volumeMetricTags(req, resp, o.volumeSlices)
+	if tags == nil {
+		return scope
+	}
+	return scope.Tagged(tags)
+}
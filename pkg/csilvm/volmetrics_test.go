@@ -0,0 +1,104 @@
+package csilvm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/uber-go/tally"
+	"google.golang.org/grpc"
+)
+
+func TestVolumeSlice(t *testing.T) {
+	if got := volumeSlice("", 16); got != volumeSliceNone {
+		t.Fatalf("expected %q without a volume id but got %q", volumeSliceNone, got)
+	}
+	// The SHA-1 of "csilv123" begins with 0xc059a37d.
+	if got, exp := volumeSlice("csilv123", 16), "13"; got != exp {
+		t.Fatalf("expected slice %q but got %q", exp, got)
+	}
+	if a, b := volumeSlice("csilv123", 16), volumeSlice("csilv123", 16); a != b {
+		t.Fatalf("expected the slice to be deterministic but got %q and %q", a, b)
+	}
+}
+
+func TestVolumeMetricTags(t *testing.T) {
+	tags, err := attributesFromTags([]string{"VN.test", "LY.raid1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		req, resp interface{}
+		exp       map[string]string
+	}{
+		{
+			&csi.CreateVolumeRequest{Parameters: map[string]string{"type": "raid1"}},
+			&csi.CreateVolumeResponse{Volume: &csi.Volume{Id: "csilv123"}},
+			map[string]string{"volume_slice": volumeSlice("csilv123", 16), "layout": "raid1"},
+		},
+		{
+			&csi.CreateVolumeRequest{},
+			nil,
+			map[string]string{"volume_slice": volumeSliceNone, "layout": "linear"},
+		},
+		{
+			&csi.CreateVolumeRequest{Parameters: map[string]string{"type": "bogus"}},
+			nil,
+			map[string]string{"volume_slice": volumeSliceNone, "layout": layoutUnknown},
+		},
+		{
+			&csi.DeleteVolumeRequest{VolumeId: "csilv123"},
+			&csi.DeleteVolumeResponse{},
+			map[string]string{"volume_slice": volumeSlice("csilv123", 16), "layout": layoutUnknown},
+		},
+		{
+			&csi.NodePublishVolumeRequest{VolumeId: "csilv123", VolumeAttributes: tags},
+			&csi.NodePublishVolumeResponse{},
+			map[string]string{"volume_slice": volumeSlice("csilv123", 16), "layout": "raid1"},
+		},
+		{
+			&csi.NodeUnpublishVolumeRequest{VolumeId: "csilv123"},
+			nil,
+			map[string]string{"volume_slice": volumeSlice("csilv123", 16), "layout": layoutUnknown},
+		},
+		{
+			&csi.ListVolumesRequest{},
+			&csi.ListVolumesResponse{},
+			nil,
+		},
+	}
+	for i, tt := range cases {
+		if got := volumeMetricTags(tt.req, tt.resp, 16); !reflect.DeepEqual(got, tt.exp) {
+			t.Fatalf("test case %d: expected %v but got %v", i, tt.exp, got)
+		}
+	}
+}
+
+func TestMetricsInterceptorVolumeSlices(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	interceptor := MetricsInterceptor(scope, VolumeMetricSlices(16))
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Controller/DeleteVolume"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if _, err := interceptor(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "csilv123"}, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	for _, counter := range scope.Snapshot().Counters() {
+		if counter.Name() != "requests" {
+			continue
+		}
+		exp := map[string]string{
+			"method":       info.FullMethod,
+			"result_type":  resultTypeSuccess,
+			"volume_slice": "13",
+			"layout":       layoutUnknown,
+		}
+		if !reflect.DeepEqual(counter.Tags(), exp) {
+			t.Fatalf("expected tags %v but got %v", exp, counter.Tags())
+		}
+		return
+	}
+	t.Fatal("the requests counter was not reported")
+}
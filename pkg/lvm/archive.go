@@ -0,0 +1,145 @@
+package lvm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveDir is the directory to which LVM archives volume group metadata
+// before each change, see `archive_dir` in lvm.conf(5).
+var archiveDir = "/etc/lvm/archive"
+
+// archiveNameRegexp matches the archive file names of a volume group once
+// the "<vgname>_" prefix has been removed, e.g., "00012-1537396214.vg".
+var archiveNameRegexp = regexp.MustCompile(`^([0-9]+)-[0-9]+\.vg$`)
+
+// MetadataBackup is an archived copy of the metadata of a volume group.
+type MetadataBackup struct {
+	// Path is the path of the archive file.
+	Path string
+	// Sequence orders the archives of a volume group.
+	Sequence uint64
+	// Description is recorded by LVM and names the command that was
+	// about to change the volume group, e.g.,
+	// "Created *before* executing 'vgremove -f vg0'".
+	Description string
+	// CreatedAt is when the archive was written.
+	CreatedAt time.Time
+}
+
+// ListMetadataBackups returns the archived metadata of the named volume
+// group, newest first.
+func ListMetadataBackups(vgname string) ([]MetadataBackup, error) {
+	infos, err := ioutil.ReadDir(archiveDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []MetadataBackup
+	prefix := vgname + "_"
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !strings.HasPrefix(info.Name(), prefix) {
+			continue
+		}
+		// The prefix alone would also match the archives of, e.g.,
+		// "<vgname>_foo" so we check the rest of the name.
+		m := archiveNameRegexp.FindStringSubmatch(strings.TrimPrefix(info.Name(), prefix))
+		if m == nil {
+			continue
+		}
+		seq, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(archiveDir, info.Name())
+		backup, err := readMetadataBackup(path)
+		if err != nil {
+			return nil, fmt.Errorf("lvm: cannot read %v: %v", path, err)
+		}
+		backup.Sequence = seq
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Sequence > backups[j].Sequence
+	})
+	return backups, nil
+}
+
+func readMetadataBackup(path string) (MetadataBackup, error) {
+	backup := MetadataBackup{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return backup, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// The header precedes the first section.
+		if strings.HasSuffix(line, "{") {
+			break
+		}
+		key, value, ok := parseMetadataSetting(line)
+		if !ok {
+			continue
+		}
+		switch key {
+		case "description":
+			backup.Description = value
+		case "creation_time":
+			secs, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return backup, fmt.Errorf("invalid creation_time %q", value)
+			}
+			backup.CreatedAt = time.Unix(secs, 0).UTC()
+		}
+	}
+	return backup, scanner.Err()
+}
+
+// parseMetadataSetting parses a `key = value` line of LVM metadata.
+// Quotes around string values and trailing comments are removed.
+func parseMetadataSetting(line string) (key, value string, ok bool) {
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	key = strings.TrimSpace(parts[0])
+	value = strings.TrimSpace(parts[1])
+	if strings.HasPrefix(value, `"`) {
+		end := strings.LastIndex(value, `"`)
+		if end == 0 {
+			return "", "", false
+		}
+		return key, value[1:end], true
+	}
+	if i := strings.IndexByte(value, '#'); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return key, value, true
+}
+
+// RestoreVolumeGroup restores the metadata of the named volume group from
+// the given backup using vgcfgrestore and activates its logical volumes.
+// The volume group must not exist and its physical volumes must still be
+// present.
+func RestoreVolumeGroup(vgname string, backup MetadataBackup) error {
+	if err := run(context.Background(), "vgcfgrestore", nil, "--file", backup.Path, vgname); err != nil {
+		return err
+	}
+	if err := run(context.Background(), "vgchange", nil, "--activate", "y", vgname); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package lvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testArchive = `# Generated by LVM2 version 2.02.180(2)-RHEL7 (2018-07-20): Wed Sep 19 22:30:14 2018
+
+contents = "Text Format Volume Group"
+version = 1
+
+description = "Created *before* executing 'vgremove -f vg0'"
+
+creation_host = "node1"	# Linux node1 3.10.0-862.el7.x86_64 #1 SMP x86_64
+creation_time = 1537396214	# Wed Sep 19 22:30:14 2018
+
+vg0 {
+	id = "4nNtYI-0KqD-9bDB-fQP2-WKeN-1cQl-TEivRv"
+	description = "not part of the header"
+}
+`
+
+func TestListMetadataBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { archiveDir = orig }(archiveDir)
+	archiveDir = dir
+
+	for _, name := range []string{
+		"vg0_00001-1537396000.vg",
+		"vg0_00010-1537396214.vg",
+		"vg0_foo_00002-1537396100.vg",
+		"vg1_00003-1537396100.vg",
+		"vg0_00004.tmp",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(testArchive), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := ListMetadataBackups("vg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups but got %+v", backups)
+	}
+	if backups[0].Sequence != 10 || backups[1].Sequence != 1 {
+		t.Fatalf("Expected newest backup first but got %+v", backups)
+	}
+	b := backups[0]
+	if b.Path != filepath.Join(dir, "vg0_00010-1537396214.vg") {
+		t.Fatalf("Unexpected path %v", b.Path)
+	}
+	if exp := "Created *before* executing 'vgremove -f vg0'"; b.Description != exp {
+		t.Fatalf("Expected description %q but got %q", exp, b.Description)
+	}
+	if exp := time.Unix(1537396214, 0).UTC(); !b.CreatedAt.Equal(exp) {
+		t.Fatalf("Expected creation time %v but got %v", exp, b.CreatedAt)
+	}
+}
+
+func TestListMetadataBackupsNoArchiveDir(t *testing.T) {
+	defer func(orig string) { archiveDir = orig }(archiveDir)
+	archiveDir = "/nonexistent/csilvm/archive"
+	backups, err := ListMetadataBackups("vg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 0 {
+		t.Fatalf("Expected no backups but got %+v", backups)
+	}
+}
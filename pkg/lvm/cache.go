@@ -0,0 +1,97 @@
+package lvm
+
+import (
+	"fmt"
+)
+
+// CachePolicies are the dm-cache policies that AttachCachePool accepts.
+var CachePolicies = []string{"smq", "mq", "cleaner"}
+
+// ValidateCachePolicy returns an error unless policy is one of
+// CachePolicies.
+func ValidateCachePolicy(policy string) error {
+	for _, p := range CachePolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("lvm: cache policy must be one of %v", CachePolicies)
+}
+
+// PhysicalVolumesOpt restricts the allocation of the new logical volume to
+// the given physical volumes. A physical volume may also be given as
+// "@<tag>" to select all physical volumes with that tag.
+func PhysicalVolumesOpt(pvs ...string) CreateLogicalVolumeOpt {
+	return func(o *LVOpts) {
+		o.pvs = append(o.pvs, pvs...)
+	}
+}
+
+// CreateCachePool creates a cache pool with the given data size on the
+// given physical volumes. LVM allocates its metadata in addition. A cache
+// pool is not usable by itself; it caches the logical volume it is
+// attached to with AttachCachePool.
+func (vg *VolumeGroup) CreateCachePool(name string, sizeInBytes uint64, pvs []string) (*LogicalVolume, error) {
+	if err := ValidateLogicalVolumeName(name); err != nil {
+		return nil, err
+	}
+	args := []string{
+		"--type=cache-pool",
+		fmt.Sprintf("--size=%db", sizeInBytes),
+		"--name=" + name,
+		vg.name,
+	}
+	args = append(args, pvs...)
+	if err := run(vg.context(), "lvcreate", nil, args...); err != nil {
+		if isInsufficientSpace(err) {
+			recordError(ErrNoSpace)
+			return nil, ErrNoSpace
+		}
+		return nil, err
+	}
+	return &LogicalVolume{name: name, sizeInBytes: sizeInBytes, vg: vg, tagsKnown: true}, nil
+}
+
+// AttachCachePool attaches the cache pool to the logical volume so that
+// the logical volume is cached by dm-cache. The cache pool then becomes a
+// hidden part of the logical volume and is removed along with it. If
+// policy is empty, the LVM default policy is used.
+func (lv *LogicalVolume) AttachCachePool(pool *LogicalVolume, policy string) error {
+	args := []string{
+		"--yes",
+		"--type=cache",
+		"--cachepool=" + lv.vg.name + "/" + pool.name,
+	}
+	if policy != "" {
+		if err := ValidateCachePolicy(policy); err != nil {
+			return err
+		}
+		args = append(args, "--cachepolicy="+policy)
+	}
+	args = append(args, lv.vg.name+"/"+lv.name)
+	return run(lv.vg.context(), "lvconvert", nil, args...)
+}
+
+// DetachCachePool detaches the cache pool from the logical volume after
+// writing back any dirty blocks. The cache pool is kept and may be attached
+// again.
+func (lv *LogicalVolume) DetachCachePool() error {
+	return run(lv.vg.context(), "lvconvert", nil, "--yes", "--splitcache", lv.vg.name+"/"+lv.name)
+}
+
+// IsCached returns whether a cache pool is attached to the logical volume.
+func (lv *LogicalVolume) IsCached() (bool, error) {
+	result := new(lvsOutput)
+	if err := run(lv.vg.context(), "lvs", result, "--options=segtype", lv.vg.name+"/"+lv.name); err != nil {
+		if IsLogicalVolumeNotFound(err) {
+			return false, ErrLogicalVolumeNotFound
+		}
+		return false, err
+	}
+	for _, report := range result.Report {
+		for _, lv := range report.Lv {
+			return lv.Segtype == "cache", nil
+		}
+	}
+	return false, ErrLogicalVolumeNotFound
+}
@@ -0,0 +1,37 @@
+package lvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateCachePolicy(t *testing.T) {
+	for _, policy := range CachePolicies {
+		if err := ValidateCachePolicy(policy); err != nil {
+			t.Fatalf("%v: %v", policy, err)
+		}
+	}
+	for _, policy := range []string{"", "lru"} {
+		if err := ValidateCachePolicy(policy); err == nil {
+			t.Fatalf("expected %q to be rejected", policy)
+		}
+	}
+}
+
+func TestPhysicalVolumesOpt(t *testing.T) {
+	opts := new(LVOpts)
+	PhysicalVolumesOpt("/dev/a")(opts)
+	PhysicalVolumesOpt("@fast")(opts)
+	if exp := []string{"/dev/a", "@fast"}; !reflect.DeepEqual(opts.pvs, exp) {
+		t.Fatalf("expected %v, got %v", exp, opts.pvs)
+	}
+}
+
+func TestSplitTags(t *testing.T) {
+	if got, exp := splitTags("a, b,,c"), []string{"a", "b", "c"}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if got := splitTags(""); got != nil {
+		t.Fatalf("expected no tags, got %v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package lvm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// lvmconfig holds the configuration passed to every LVM command using
+// `--config`. It is empty unless SetDeviceFilter has been called.
+var lvmconfig string
+
+// SetDeviceFilter causes all LVM commands invoked by this package to run
+// with a private configuration whose `devices/global_filter` accepts only
+// the given devices and rejects all others. This prevents csilvm from
+// scanning, or refreshing the lvmetad cache for, devices that are managed
+// by the host or by other csilvm instances. Calling SetDeviceFilter with no
+// devices removes the filter.
+//
+// Like SetLockFilePath, this is intended to be called once at startup
+// before any LVM commands are run.
+func SetDeviceFilter(devices []string) error {
+	if len(devices) == 0 {
+		log.Printf("removing device filter")
+		lvmconfig = ""
+		return nil
+	}
+	filter, err := globalFilter(devices)
+	if err != nil {
+		return err
+	}
+	lvmconfig = "devices { global_filter = " + filter + " }"
+	log.Printf("using lvm config %q", lvmconfig)
+	return nil
+}
+
+// filterDeviceRegexp matches the device paths that SetDeviceFilter accepts.
+// Restricting the characters lets us avoid escaping them for both the
+// regular expression and the LVM config parser.
+var filterDeviceRegexp = regexp.MustCompile("^/[A-Za-z0-9_+.:/-]*$")
+
+// globalFilter returns a filter list that accepts the given devices and
+// rejects all others, e.g.,
+//
+//	[ "a|^/dev/sdb$|", "r|.*|" ]
+func globalFilter(devices []string) (string, error) {
+	var entries []string
+	for _, dev := range devices {
+		if dev == "" {
+			continue
+		}
+		if !filterDeviceRegexp.MatchString(dev) {
+			return "", fmt.Errorf("lvm: invalid device path for filter: %q", dev)
+		}
+		// Bracket expressions are used to match '.' and '+'
+		// literally as backslashes are unescaped by the LVM
+		// config parser.
+		pattern := strings.NewReplacer(".", "[.]", "+", "[+]").Replace(dev)
+		entries = append(entries, fmt.Sprintf("\"a|^%s$|\"", pattern))
+	}
+	entries = append(entries, "\"r|.*|\"")
+	return "[ " + strings.Join(entries, ", ") + " ]", nil
+}
@@ -0,0 +1,46 @@
+package lvm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGlobalFilter(t *testing.T) {
+	filter, err := globalFilter([]string{"/dev/sdb", "", "/dev/disk/by-id/nvme-a.b+c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const exp = `[ "a|^/dev/sdb$|", "a|^/dev/disk/by-id/nvme-a[.]b[+]c$|", "r|.*|" ]`
+	if filter != exp {
+		t.Fatalf("Expected %v but got %v", exp, filter)
+	}
+}
+
+func TestGlobalFilterInvalidDevice(t *testing.T) {
+	for _, dev := range []string{"sdb", `/dev/"sdb"`, "/dev/sd|b", "/dev/sd*"} {
+		if _, err := globalFilter([]string{dev}); err == nil {
+			t.Fatalf("Expected %q to be rejected", dev)
+		}
+	}
+}
+
+func TestSetDevicesFile(t *testing.T) {
+	defer SetDevicesFile("")
+	if err := SetDevicesFile("csilvm"); err != nil {
+		t.Fatal(err)
+	}
+	if got := DevicesFile(); got != "csilvm" {
+		t.Fatalf("Expected csilvm but got %v", got)
+	}
+	for _, name := range []string{"..", "../system.devices", "/etc/lvm/devices/csilvm", "csi lvm"} {
+		if err := SetDevicesFile(name); err == nil {
+			t.Fatalf("Expected %q to be rejected", name)
+		}
+	}
+	if err := SetDevicesFile(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToDevicesFile(context.Background(), "/dev/sdb"); err != ErrNoDevicesFile {
+		t.Fatalf("Expected %v but got %v", ErrNoDevicesFile, err)
+	}
+}
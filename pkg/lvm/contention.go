@@ -0,0 +1,125 @@
+package lvm
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// lockContentionMessages are the lowercase fragments of the LVM error
+// messages that report that a volume group or global lock is held by
+// another process or host, e.g., an LVM command run by the operator. A
+// message matches if one of its lines contains all fragments of an entry.
+// "Resource temporarily unavailable" is the message of any EAGAIN, e.g.,
+// from opening a device, so it only matches a failed flock of a lock file.
+var lockContentionMessages = [][]string{
+	{"held by another process"},
+	{"held by other host"},
+	{"can't get lock"},
+	{"flock failed", "resource temporarily unavailable"},
+}
+
+// IsLockContention returns whether the error is that of an LVM command that
+// failed as a lock it requires is held by another process. The command can
+// succeed if retried once the lock is released.
+func IsLockContention(err error) bool {
+	cmdErr, ok := err.(*CommandError)
+	if !ok {
+		return false
+	}
+	for _, line := range strings.Split(strings.ToLower(cmdErr.Stderr), "\n") {
+		for _, msg := range lockContentionMessages {
+			if containsAll(line, msg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsAll returns whether s contains all of the fragments.
+func containsAll(s string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if !strings.Contains(s, fragment) {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	// contentionRetryInitialDelay is the delay before the first retry of a
+	// command that failed due to lock contention. It doubles with every
+	// retry up to contentionRetryMaxDelay.
+	contentionRetryInitialDelay = 50 * time.Millisecond
+	contentionRetryMaxDelay     = 2 * time.Second
+)
+
+// contentionRetryTimeout bounds how long a command that failed due to lock
+// contention is retried if its context has no deadline.
+var contentionRetryTimeout = 10 * time.Second
+
+// SetContentionRetryTimeout sets how long LVM commands that fail as a lock
+// is held by another process are retried if their context has no deadline,
+// e.g., during startup. Commands whose context has a deadline are retried
+// as long as the next retry is expected to finish before it. Calling
+// SetContentionRetryTimeout with zero disables the retries of commands
+// without a deadline.
+//
+// Like SetLockFilePath, this is intended to be called once at startup
+// before any LVM commands are run.
+func SetContentionRetryTimeout(timeout time.Duration) {
+	log.Printf("using lvm lock contention retry timeout %v", timeout)
+	contentionRetryTimeout = timeout
+}
+
+// contentionRetryDelay returns the delay before the given retry, counting
+// from zero. The delay is jittered between half and all of the exponential
+// backoff so that concurrent retries do not contend again in lockstep.
+func contentionRetryDelay(retry int) time.Duration {
+	d := contentionRetryInitialDelay
+	for i := 0; i < retry && d < contentionRetryMaxDelay; i++ {
+		d *= 2
+	}
+	if d > contentionRetryMaxDelay {
+		d = contentionRetryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// run runs the LVM command. It is interrupted once ctx is done, in which
+// case ctx.Err() is returned. If the command fails due to lock contention
+// it is retried with exponential backoff until ctx's deadline or, if ctx
+// has none, the contention retry timeout. If the next retry is expected to
+// finish after that, the lock contention error is returned rather than
+// letting the deadline interrupt the retry.
+func run(ctx context.Context, cmd string, v interface{}, extraArgs ...string) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(contentionRetryTimeout)
+	}
+	for retry := 0; ; retry++ {
+		start := time.Now()
+		err := runOnce(ctx, cmd, v, extraArgs...)
+		if !IsLockContention(err) {
+			return err
+		}
+		// The next retry is expected to take as long as this
+		// attempt did.
+		delay := contentionRetryDelay(retry)
+		if time.Now().Add(delay + time.Since(start)).After(deadline) {
+			log.Printf("Giving up on %v after %d retries as a lock is held by another process: err=%v", cmd, retry, err)
+			return err
+		}
+		log.Printf("Retrying %v in %v as a lock is held by another process: err=%v", cmd, delay, err)
+		recordLockContention(cmd)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
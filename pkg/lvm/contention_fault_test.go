@@ -0,0 +1,51 @@
+// +build lvmfaults
+
+package lvm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunRetriesLockContention(t *testing.T) {
+	contention := LockContentionFault("lvs")
+	contention.Times = 2
+	defer InjectFault(contention)()
+	defer InjectFault(NotFoundFault("lvs", "lv1"))()
+	// The command is retried until the lock is released and then fails
+	// for another reason.
+	err := run(context.Background(), "lvs", nil, "vg/lv1")
+	if err == nil || IsLockContention(err) || !strings.Contains(err.Error(), "Failed to find logical volume") {
+		t.Fatalf("expected the command to be retried but got %v", err)
+	}
+}
+
+func TestRunGivesUpOnLockContention(t *testing.T) {
+	defer InjectFault(LockContentionFault("lvs"))()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	// The lock contention error is returned rather than the context's
+	// once the deadline would pass before the next retry.
+	start := time.Now()
+	if err := run(ctx, "lvs", nil, "vg/lv1"); !IsLockContention(err) {
+		t.Fatalf("expected a lock contention error but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("expected to give up before the deadline but took %v", elapsed)
+	}
+}
+
+func TestRunGivesUpOnLockContentionBeforeDeadline(t *testing.T) {
+	contention := LockContentionFault("lvs")
+	contention.Delay = 80 * time.Millisecond
+	defer InjectFault(contention)()
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	// A retry started before the deadline would be interrupted by it, so
+	// the lock contention error is returned instead of the context's.
+	if err := run(ctx, "lvs", nil, "vg/lv1"); !IsLockContention(err) {
+		t.Fatalf("expected a lock contention error but got %v", err)
+	}
+}
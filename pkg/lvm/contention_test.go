@@ -0,0 +1,48 @@
+package lvm
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsLockContention(t *testing.T) {
+	cases := []struct {
+		err error
+		exp bool
+	}{
+		{&CommandError{Command: "lvcreate", ExitCode: 5, Stderr: "VG data open failed: held by another process"}, true},
+		{&CommandError{Command: "vgs", ExitCode: 5, Stderr: "Global lock failed: held by other host."}, true},
+		{&CommandError{Command: "lvs", ExitCode: 5, Stderr: "Can't get lock for data"}, true},
+		{&CommandError{Command: "vgs", ExitCode: 5, Stderr: "/run/lock/lvm/V_data: flock failed: Resource temporarily unavailable\nCan't lock data"}, true},
+		{&CommandError{Command: "pvs", ExitCode: 5, Stderr: "/dev/sdb: open failed: Resource temporarily unavailable"}, false},
+		{&CommandError{Command: "lvremove", ExitCode: 5, Stderr: "Logical volume data/lv1 in use."}, false},
+		{errors.New("VG data open failed: held by another process"), false},
+		{nil, false},
+	}
+	for i, tt := range cases {
+		if got := IsLockContention(tt.err); got != tt.exp {
+			t.Fatalf("test case %d: expected %v but got %v", i, tt.exp, got)
+		}
+	}
+}
+
+func TestContentionRetryDelay(t *testing.T) {
+	for retry := 0; retry < 10; retry++ {
+		max := contentionRetryInitialDelay << uint(retry)
+		if max > contentionRetryMaxDelay {
+			max = contentionRetryMaxDelay
+		}
+		for i := 0; i < 100; i++ {
+			if d := contentionRetryDelay(retry); d < max/2 || d > max {
+				t.Fatalf("retry %d: expected a delay between %v and %v but got %v", retry, max/2, max, d)
+			}
+		}
+	}
+	if d := contentionRetryDelay(100); d > contentionRetryMaxDelay {
+		t.Fatalf("expected at most %v but got %v", contentionRetryMaxDelay, d)
+	}
+	if contentionRetryMaxDelay > time.Minute {
+		t.Fatalf("the maximum delay %v exceeds a minute", contentionRetryMaxDelay)
+	}
+}
@@ -0,0 +1,33 @@
+package lvm
+
+import (
+	"os"
+	"time"
+
+	"github.com/mesosphere/csilvm/pkg/udev"
+)
+
+// WaitForDevice waits until udev has created the device node or symlink at
+// path, e.g., the path of a logical volume that was just created or
+// activated. The logical volume's metadata, and therefore its path, is
+// available before udev creates the device node. Unlike `udevadm settle`,
+// WaitForDevice only waits for the events of that device. If udev events
+// cannot be received, it does not wait.
+func WaitForDevice(path string, timeout time.Duration) error {
+	// Start listening before checking whether the device exists so
+	// that we do not miss the event.
+	monitor, err := udev.NewMonitor()
+	if err != nil {
+		log.Printf("Cannot listen for udev events, not waiting for %v: err=%v", path, err)
+		return nil
+	}
+	defer monitor.Close()
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	log.Printf("Waiting up to %v for udev to create %v", timeout, path)
+	_, err = monitor.WaitFor(timeout, func(event udev.Event) bool {
+		return event.Action() != "remove" && event.HasDevice(path)
+	})
+	return err
+}
@@ -0,0 +1,66 @@
+package lvm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// devicesfile is the name of the devices file in /etc/lvm/devices passed to
+// every LVM command using `--devicesfile`. It is empty unless SetDevicesFile
+// has been called.
+var devicesfile string
+
+// ErrNoDevicesFile is returned by AddToDevicesFile if SetDevicesFile has
+// not been called.
+const ErrNoDevicesFile = simpleError("lvm: no devices file is set")
+
+// devicesFileRegexp matches the devices file names that SetDevicesFile
+// accepts. LVM requires the file to be in /etc/lvm/devices so the name
+// cannot contain a slash.
+var devicesFileRegexp = regexp.MustCompile("^[A-Za-z0-9_+.-]+$")
+
+// SetDevicesFile causes all LVM commands invoked by this package to use the
+// given devices file in /etc/lvm/devices, see lvmdevices(8), instead of the
+// host's system.devices, without relying on the deprecated lvm.conf filters.
+// LVM only sees the devices listed in the file, which are added by
+// AddToDevicesFile. Calling
+// SetDevicesFile with an empty name restores the host's configuration.
+//
+// Like SetDeviceFilter, this is intended to be called once at startup
+// before any LVM commands are run.
+func SetDevicesFile(name string) error {
+	if name == "" {
+		log.Printf("removing devices file")
+		devicesfile = ""
+		return nil
+	}
+	if !devicesFileRegexp.MatchString(name) || name == "." || name == ".." {
+		return fmt.Errorf("lvm: invalid devices file name: %q", name)
+	}
+	devicesfile = name
+	log.Printf("using lvm devices file %q", devicesfile)
+	return nil
+}
+
+// DevicesFile returns the name of the devices file set by SetDevicesFile.
+func DevicesFile() string {
+	return devicesfile
+}
+
+// AddToDevicesFile adds the devices to the devices file set by
+// SetDevicesFile, creating the file if it does not exist. LVM identifies
+// each device by its WWID or serial number if it has one so that the entry
+// follows the device if its name changes. Adding a device that is already
+// listed updates its entry.
+func AddToDevicesFile(ctx context.Context, devices ...string) error {
+	if devicesfile == "" {
+		return ErrNoDevicesFile
+	}
+	for _, dev := range devices {
+		if err := run(ctx, "lvmdevices", nil, "--adddev", dev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
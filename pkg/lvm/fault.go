@@ -0,0 +1,157 @@
+// +build lvmfaults
+
+package lvm
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault describes a failure of LVM commands that is injected with
+// InjectFault. Faults let tests exercise error paths that are hard to
+// trigger with real devices, e.g., the volume group running out of space
+// between planning and creating a volume. Fault injection is only built
+// with the lvmfaults build tag so that it never ships in the plugin.
+type Fault struct {
+	// Command is the LVM command that fails, e.g., "lvcreate".
+	Command string
+	// Arg, if set, restricts the fault to commands with an argument
+	// that contains it, e.g., the name of a logical volume.
+	Arg string
+	// Delay delays the command, e.g., to simulate a volume group that
+	// takes long to open. If the context of the command is done in the
+	// meantime, its error is returned as if the command had been
+	// interrupted.
+	Delay time.Duration
+	// Err, if set, is returned instead of running the command. Use a
+	// *CommandError to simulate a failed command, the errors of this
+	// package are derived from its Stderr as they are for real
+	// commands.
+	Err error
+	// Times is the number of commands that fail before the fault is
+	// exhausted. Zero means that the fault never is.
+	Times int
+}
+
+// NoSpaceFault returns a fault with which the command fails like LVM does
+// when the volume group has insufficient free space.
+func NoSpaceFault(command string) Fault {
+	return Fault{
+		Command: command,
+		Err: &CommandError{
+			Command:  command,
+			ExitCode: 5,
+			Stderr:   "Volume group has insufficient free space.",
+		},
+	}
+}
+
+// NotFoundFault returns a fault with which the command fails like LVM does
+// when the logical volume has disappeared.
+func NotFoundFault(command, lvname string) Fault {
+	return Fault{
+		Command: command,
+		Arg:     lvname,
+		Err: &CommandError{
+			Command:  command,
+			ExitCode: 5,
+			Stderr:   "Failed to find logical volume \"" + lvname + "\"",
+		},
+	}
+}
+
+// LockContentionFault returns a fault with which the command fails like LVM
+// does when another process holds the volume group lock.
+func LockContentionFault(command string) Fault {
+	return Fault{
+		Command: command,
+		Err: &CommandError{
+			Command:  command,
+			ExitCode: 5,
+			Stderr:   "VG open failed: held by another process",
+		},
+	}
+}
+
+type injectedFault struct {
+	Fault
+	count int
+}
+
+var (
+	faultsMu sync.Mutex
+	faults   []*injectedFault
+)
+
+// InjectFault makes the LVM commands that match f fail until the returned
+// function is called. It is intended for tests only. Faults are checked in
+// the order they were injected and the first one that matches applies.
+func InjectFault(f Fault) (remove func()) {
+	injected := &injectedFault{Fault: f}
+	faultsMu.Lock()
+	faults = append(faults, injected)
+	faultsMu.Unlock()
+	return func() {
+		faultsMu.Lock()
+		defer faultsMu.Unlock()
+		for i, fault := range faults {
+			if fault == injected {
+				faults = append(faults[:i], faults[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// matchFault returns the injected fault that applies to the command, if
+// any, and counts it.
+func matchFault(cmd string, args []string) (Fault, bool) {
+	faultsMu.Lock()
+	defer faultsMu.Unlock()
+	for _, f := range faults {
+		if f.Command != cmd {
+			continue
+		}
+		if f.Times > 0 && f.count >= f.Times {
+			continue
+		}
+		if f.Arg != "" && !containsArg(args, f.Arg) {
+			continue
+		}
+		f.count++
+		return f.Fault, true
+	}
+	return Fault{}, false
+}
+
+func containsArg(args []string, s string) bool {
+	for _, arg := range args {
+		if strings.Contains(arg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// injectFault applies the injected fault that matches the command, if any.
+// It returns the error with which the command fails, or nil if the
+// command runs.
+func injectFault(ctx context.Context, cmd string, args []string) error {
+	f, ok := matchFault(cmd, args)
+	if !ok {
+		return nil
+	}
+	log.Printf("Injecting fault into %v %v: %+v", cmd, strings.Join(args, " "), f)
+	if f.Delay > 0 {
+		t := time.NewTimer(f.Delay)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return f.Err
+}
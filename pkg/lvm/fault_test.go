@@ -0,0 +1,51 @@
+// +build lvmfaults
+
+package lvm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInjectFault(t *testing.T) {
+	remove := InjectFault(NotFoundFault("lvremove", "lv1"))
+	defer remove()
+	// The fault only applies to the given command and argument.
+	if _, ok := matchFault("lvchange", []string{"vg/lv1"}); ok {
+		t.Fatal("Expected no fault for lvchange")
+	}
+	if _, ok := matchFault("lvremove", []string{"-f", "vg/lv2"}); ok {
+		t.Fatal("Expected no fault for lv2")
+	}
+	err := run(context.Background(), "lvremove", nil, "-f", "vg/lv1")
+	if err == nil || !IsLogicalVolumeNotFound(err) {
+		t.Fatalf("Expected the injected error, got %v", err)
+	}
+	remove()
+	if _, ok := matchFault("lvremove", []string{"-f", "vg/lv1"}); ok {
+		t.Fatal("Expected the fault to be removed")
+	}
+}
+
+func TestInjectFaultTimes(t *testing.T) {
+	f := NoSpaceFault("lvcreate")
+	f.Times = 1
+	defer InjectFault(f)()
+	err := run(context.Background(), "lvcreate", nil, "--name=lv1", "vg")
+	if err == nil || !isInsufficientSpace(err) {
+		t.Fatalf("Expected the injected error, got %v", err)
+	}
+	if _, ok := matchFault("lvcreate", []string{"--name=lv1", "vg"}); ok {
+		t.Fatal("Expected the fault to be exhausted")
+	}
+}
+
+func TestInjectFaultDelay(t *testing.T) {
+	defer InjectFault(Fault{Command: "vgs", Delay: time.Hour})()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := run(ctx, "vgs", nil, "vg"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
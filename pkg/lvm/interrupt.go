@@ -0,0 +1,38 @@
+package lvm
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// runCommand starts the command and waits for it to complete. The command
+// is interrupted with SIGINT once ctx is done, in which case ctx.Err() is
+// returned unless the command completed regardless. LVM commands handle
+// SIGINT by aborting at the next safe point, leaving the metadata
+// consistent.
+func runCommand(ctx context.Context, c *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Printf("Interrupting: %v: err=%v", c, ctx.Err())
+			if err := c.Process.Signal(os.Interrupt); err != nil {
+				log.Printf("Cannot interrupt %v: err=%v", c, err)
+			}
+		case <-done:
+		}
+	}()
+	err := c.Wait()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package lvm
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRunCommandContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := runCommand(ctx, exec.Command("sleep", "60"))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v but got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("Expected the command to be interrupted but it ran for %v", elapsed)
+	}
+}
+
+func TestRunCommandContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := runCommand(ctx, exec.Command("true")); err != context.Canceled {
+		t.Fatalf("Expected %v but got %v", context.Canceled, err)
+	}
+}
+
+func TestRunCommandContextCompleted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := runCommand(ctx, exec.Command("true")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,126 @@
+package lvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBytesFreeOn(t *testing.T) {
+	const extent = 4 << 20
+	pvs := []PhysicalVolumeReport{
+		{Name: "/dev/a", BytesFree: 10 * extent},
+		{Name: "/dev/b", BytesFree: 6 * extent},
+	}
+	quad := []PhysicalVolumeReport{
+		{Name: "/dev/a", BytesFree: 10 * extent},
+		{Name: "/dev/b", BytesFree: 10 * extent},
+		{Name: "/dev/c", BytesFree: 10 * extent},
+		{Name: "/dev/d", BytesFree: 10 * extent},
+	}
+	for _, tt := range []struct {
+		layout VolumeLayout
+		pvs    []PhysicalVolumeReport
+		exp    uint64
+	}{
+		{VolumeLayout{}, pvs, 16 * extent},
+		{VolumeLayout{Type: VolumeTypeLinear}, pvs[:1], 10 * extent},
+		{VolumeLayout{}, nil, 0},
+		// Two copies, each with a metadata extent.
+		{VolumeLayout{Type: VolumeTypeRAID1}, pvs, 7 * extent},
+		// RAID1 requires at least two devices.
+		{VolumeLayout{Type: VolumeTypeRAID1}, pvs[:1], 0},
+		{VolumeLayout{Type: VolumeTypeRAID1}, []PhysicalVolumeReport{{Name: "/dev/a"}, {Name: "/dev/b", BytesFree: extent}}, 0},
+		// Striped volumes are allocated in full stripes.
+		{VolumeLayout{Stripes: 2}, append(pvs, PhysicalVolumeReport{Name: "/dev/c", BytesFree: 5 * extent}), 20 * extent},
+		// Every stripe requires a separate device.
+		{VolumeLayout{Type: VolumeTypeLinear, Stripes: 3}, pvs, 0},
+		// Two copies of two stripes, each with a metadata extent.
+		{VolumeLayout{Type: VolumeTypeRAID1, Stripes: 2}, quad, 18 * extent},
+		{VolumeLayout{Type: VolumeTypeRAID1, Stripes: 2}, quad[:3], 0},
+	} {
+		if got := tt.layout.BytesFreeOn(tt.pvs, extent); got != tt.exp {
+			t.Fatalf("%+v on %v: expected %v, got %v", tt.layout, tt.pvs, tt.exp, got)
+		}
+	}
+}
+
+func TestVolumeLayoutFlags(t *testing.T) {
+	for _, tt := range []struct {
+		layout VolumeLayout
+		exp    []string
+	}{
+		{VolumeLayout{}, nil},
+		{VolumeLayout{Stripes: 2}, []string{"--stripes=2"}},
+		{VolumeLayout{Type: VolumeTypeLinear, Stripes: 1}, []string{"--type=linear", "--stripes=1"}},
+		{VolumeLayout{Type: VolumeTypeLinear, Stripes: 2}, []string{"--type=striped", "--stripes=2"}},
+		{VolumeLayout{Type: VolumeTypeRAID1, Mirrors: 1}, []string{"--type=raid1", "--mirrors=1"}},
+		{VolumeLayout{Type: VolumeTypeRAID1, Stripes: 2}, []string{"--type=raid10", "--stripes=2"}},
+	} {
+		if got := tt.layout.Flags(); !reflect.DeepEqual(got, tt.exp) {
+			t.Fatalf("%+v: expected %v, got %v", tt.layout, tt.exp, got)
+		}
+	}
+}
+
+func TestBytesAllocatableOn(t *testing.T) {
+	const extent = 4 << 20
+	// The free extents are unevenly spread over the devices.
+	pvs := []PhysicalVolumeReport{
+		{Name: "/dev/a", BytesFree: 10 * extent},
+		{Name: "/dev/b", BytesFree: 2 * extent},
+		{Name: "/dev/c", BytesFree: 6 * extent},
+	}
+	for _, tt := range []struct {
+		layout VolumeLayout
+		pvs    []PhysicalVolumeReport
+		exp    uint64
+	}{
+		// A linear volume can span all devices.
+		{VolumeLayout{}, pvs, 18 * extent},
+		{VolumeLayout{Type: VolumeTypeLinear}, pvs[:1], 10 * extent},
+		{VolumeLayout{}, nil, 0},
+		// Both copies go on the devices with the most free extents,
+		// each with a metadata extent.
+		{VolumeLayout{Type: VolumeTypeRAID1}, pvs, 5 * extent},
+		{VolumeLayout{Type: VolumeTypeRAID1}, pvs[:2], 1 * extent},
+		{VolumeLayout{Type: VolumeTypeRAID1}, pvs[:1], 0},
+		// Every stripe requires a separate device.
+		{VolumeLayout{Stripes: 2}, pvs, 12 * extent},
+		{VolumeLayout{Type: VolumeTypeLinear, Stripes: 3}, pvs, 6 * extent},
+		{VolumeLayout{Type: VolumeTypeLinear, Stripes: 4}, pvs, 0},
+	} {
+		if got := tt.layout.BytesAllocatableOn(tt.pvs, extent); got != tt.exp {
+			t.Fatalf("%+v on %v: expected %v, got %v", tt.layout, tt.pvs, tt.exp, got)
+		}
+		if free := tt.layout.BytesFreeOn(tt.pvs, extent); free < tt.exp {
+			t.Fatalf("%+v on %v: expected at most %v bytes free, got %v", tt.layout, tt.pvs, free, tt.exp)
+		}
+	}
+}
+
+func TestVolumeLayoutFromSegtype(t *testing.T) {
+	for _, tt := range []struct {
+		segtype string
+		stripes uint64
+		exp     VolumeLayout
+	}{
+		{"linear", 1, VolumeLayout{Type: VolumeTypeLinear}},
+		{"striped", 2, VolumeLayout{Type: VolumeTypeLinear, Stripes: 2}},
+		{"raid1", 2, VolumeLayout{Type: VolumeTypeRAID1, Mirrors: 1}},
+		{"raid1", 3, VolumeLayout{Type: VolumeTypeRAID1, Mirrors: 2}},
+		{"raid10", 4, VolumeLayout{Type: VolumeTypeRAID1, Mirrors: 1, Stripes: 2}},
+	} {
+		got, err := volumeLayoutFromSegtype(tt.segtype, tt.stripes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.exp {
+			t.Fatalf("%v with %d stripes: expected %+v, got %+v", tt.segtype, tt.stripes, tt.exp, got)
+		}
+	}
+	for _, segtype := range []string{"cache", "thin", "raid5", "raid1"} {
+		if got, err := volumeLayoutFromSegtype(segtype, 1); err == nil {
+			t.Fatalf("%v: expected an error, got %+v", segtype, got)
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package lvm
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/gofrs/flock"
 )
 
+// lockRetryDelay is how often lockContext retries acquiring the lock.
+const lockRetryDelay = 100 * time.Millisecond
+
 var lvmlock *flock.Flock
 
 // SetLockFilePath sets the path to the LOCK file to use for preventing
@@ -28,3 +33,19 @@ func SetLockFilePath(filepath string) {
 	}
 	log.Printf("configured lock file")
 }
+
+// lockContext acquires the lock, giving up once ctx is done.
+func lockContext(ctx context.Context, lock *flock.Flock) error {
+	if ctx.Done() == nil {
+		// The context cannot be canceled so we block.
+		return lock.Lock()
+	}
+	ok, err := lock.TryLockContext(ctx, lockRetryDelay)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.Err()
+	}
+	return nil
+}
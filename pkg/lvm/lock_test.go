@@ -0,0 +1,38 @@
+package lvm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+func TestLockContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+	held := flock.New(path)
+	if err := held.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := lockContext(ctx, flock.New(path)); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v but got %v", context.DeadlineExceeded, err)
+	}
+	if err := held.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	lock := flock.New(path)
+	if err := lockContext(context.Background(), lock); err != nil {
+		t.Fatal(err)
+	}
+	lock.Unlock()
+}
@@ -0,0 +1,30 @@
+// +build !linux
+
+package lvm
+
+import (
+	"errors"
+)
+
+// LoopDevice represents a loop device such as `/dev/loop0` backed by a file.
+// Loop devices are only supported on linux.
+type LoopDevice struct{}
+
+// CreateLoopDevice returns an error as loop devices are only supported on
+// linux.
+func CreateLoopDevice(size uint64) (*LoopDevice, error) {
+	return nil, errors.New("lvm: loop devices are only supported on linux")
+}
+
+func (d *LoopDevice) Path() string {
+	return ""
+}
+
+func (d *LoopDevice) String() string {
+	return ""
+}
+
+// Close is a no-op.
+func (d *LoopDevice) Close() error {
+	return nil
+}
@@ -2,12 +2,17 @@ package lvm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"os/exec"
 	"regexp"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/trace"
 )
 
 // Control verbose output of all LVM CLI commands
@@ -31,6 +36,20 @@ type simpleError string
 
 func (s simpleError) Error() string { return string(s) }
 
+// CommandError is returned when an LVM command fails. Its message is the
+// standard error output of the command without warnings, as before it was
+// introduced, so that callers matching on the message are unaffected.
+type CommandError struct {
+	// Command is the name of the LVM command, e.g., lvcreate.
+	Command string
+	// ExitCode is the exit status of the command or -1 if it did not
+	// exit, e.g., because it could not be started.
+	ExitCode int
+	Stderr   string
+}
+
+func (e *CommandError) Error() string { return e.Stderr }
+
 const ErrNoSpace = simpleError("lvm: not enough free space")
 const ErrTooFewDisks = simpleError("lvm: not enough underlying devices")
 const ErrPhysicalVolumeNotFound = simpleError("lvm: physical volume not found")
@@ -55,15 +74,43 @@ type PhysicalVolume struct {
 
 // Remove removes the physical volume.
 func (pv *PhysicalVolume) Remove() error {
-	if err := run("pvremove", nil, pv.dev); err != nil {
+	if err := run(context.Background(), "pvremove", nil, pv.dev); err != nil {
 		return err
 	}
 	return nil
 }
 
+// VolumeGroupName returns the name of the volume group that the physical
+// volume belongs to. It returns the empty string if the physical volume
+// does not belong to a volume group.
+func (pv *PhysicalVolume) VolumeGroupName() (string, error) {
+	result := new(pvsOutput)
+	if err := run(context.Background(), "pvs", result, "--options=vg_name", pv.dev); err != nil {
+		if IsPhysicalVolumeNotFound(err) {
+			return "", ErrPhysicalVolumeNotFound
+		}
+		return "", err
+	}
+	for _, report := range result.Report {
+		for _, pv := range report.Pv {
+			return pv.VgName, nil
+		}
+	}
+	return "", ErrPhysicalVolumeNotFound
+}
+
+// AddTag adds the tag to the physical volume, e.g., to designate it for
+// cache pools.
+func (pv *PhysicalVolume) AddTag(tag string) error {
+	if err := ValidateTag(tag); err != nil {
+		return err
+	}
+	return run(context.Background(), "pvchange", nil, "--addtag="+tag, pv.dev)
+}
+
 // Check runs the pvck command on the physical volume.
 func (pv *PhysicalVolume) Check() error {
-	if err := run("pvck", nil, pv.dev); err != nil {
+	if err := run(context.Background(), "pvck", nil, pv.dev); err != nil {
 		return err
 	}
 	return nil
@@ -71,6 +118,24 @@ func (pv *PhysicalVolume) Check() error {
 
 type VolumeGroup struct {
 	name string
+	ctx  context.Context
+}
+
+// WithContext returns a copy of the volume group whose LVM commands, and
+// those of the logical volumes looked up or created through it, are
+// interrupted once ctx is done. They then return ctx.Err().
+func (vg *VolumeGroup) WithContext(ctx context.Context) *VolumeGroup {
+	vg2 := *vg
+	vg2.ctx = ctx
+	return &vg2
+}
+
+// context returns the context of the volume group's LVM commands.
+func (vg *VolumeGroup) context() context.Context {
+	if vg.ctx == nil {
+		return context.Background()
+	}
+	return vg.ctx
 }
 
 func (vg *VolumeGroup) Name() string {
@@ -79,7 +144,7 @@ func (vg *VolumeGroup) Name() string {
 
 // Check runs the vgck command on the volume group.
 func (vg *VolumeGroup) Check() error {
-	if err := run("vgck", nil, vg.name); err != nil {
+	if err := run(vg.context(), "vgck", nil, vg.name); err != nil {
 		return err
 	}
 	return nil
@@ -88,7 +153,7 @@ func (vg *VolumeGroup) Check() error {
 // BytesTotal returns the current size in bytes of the volume group.
 func (vg *VolumeGroup) BytesTotal() (uint64, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_size", vg.name); err != nil {
+	if err := run(vg.context(), "vgs", result, "--options=vg_size", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return 0, ErrVolumeGroupNotFound
 		}
@@ -115,7 +180,7 @@ func (vg *VolumeGroup) BytesFree(raid VolumeLayout) (uint64, error) {
 		return 0, nil
 	}
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_free,vg_free_count,vg_extent_size", vg.name); err != nil {
+	if err := run(vg.context(), "vgs", result, "--options=vg_free,vg_free_count,vg_extent_size", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return 0, ErrVolumeGroupNotFound
 		}
@@ -129,10 +194,77 @@ func (vg *VolumeGroup) BytesFree(raid VolumeLayout) (uint64, error) {
 	return 0, ErrVolumeGroupNotFound
 }
 
+// BytesFreeOn returns the unallocated space in bytes for logical volumes
+// with this layout that are restricted to the given physical volumes, see
+// PhysicalVolumesOpt.
+func (r VolumeLayout) BytesFreeOn(pvs []PhysicalVolumeReport, extentSize uint64) uint64 {
+	if len(pvs) < int(r.MinNumberOfDevices()) || extentSize == 0 {
+		return 0
+	}
+	var count uint64
+	for _, pv := range pvs {
+		count += pv.BytesFree / extentSize
+	}
+	return r.extentsFree(count) * extentSize
+}
+
+// BytesAllocatableOn returns the size in bytes of the largest logical
+// volume with this layout that can be allocated on the given physical
+// volumes. Unlike BytesFreeOn it does not assume that the free extents are
+// evenly spread over the devices. Every stripe and every raid image is
+// allocated on a separate device, so the size is bounded by the free space
+// of the devices with the least free space among those used. It is a lower
+// bound as LVM may place the extents of an image on more than one device.
+func (r VolumeLayout) BytesAllocatableOn(pvs []PhysicalVolumeReport, extentSize uint64) uint64 {
+	if len(pvs) < int(r.MinNumberOfDevices()) || extentSize == 0 {
+		return 0
+	}
+	free := make([]uint64, len(pvs))
+	for i, pv := range pvs {
+		free[i] = pv.BytesFree / extentSize
+	}
+	stripes := r.stripes()
+	copies := uint64(1)
+	if r.Type == VolumeTypeRAID1 {
+		copies = r.Mirrors + 1
+		if r.Mirrors == 0 {
+			copies = 2
+		}
+	}
+	if copies == 1 && stripes == 1 {
+		// A linear volume can span all devices.
+		var count uint64
+		for _, n := range free {
+			count += n
+		}
+		return count * extentSize
+	}
+	images := copies * stripes
+	if uint64(len(free)) < images {
+		return 0
+	}
+	sort.Slice(free, func(i, j int) bool { return free[i] > free[j] })
+	// Use the devices with the most free extents.
+	count := free[images-1]
+	if copies > 1 {
+		// Every raid image has a metadata subvolume of one extent.
+		if count < 1 {
+			return 0
+		}
+		count--
+	}
+	return count * stripes * extentSize
+}
+
+// extentsFree returns the number of extents of a logical volume with this
+// layout that fit in count free extents. Striped volumes are allocated in
+// full stripes, i.e., in multiples of the number of stripes. This assumes
+// that the free extents are evenly spread over the devices.
 func (r VolumeLayout) extentsFree(count uint64) uint64 {
+	stripes := r.stripes()
 	switch r.Type {
 	case VolumeTypeDefault, VolumeTypeLinear:
-		return count
+		return count / stripes * stripes
 	case VolumeTypeRAID1:
 		mirrors := r.Mirrors
 		if mirrors == 0 {
@@ -154,10 +286,16 @@ func (r VolumeLayout) extentsFree(count uint64) uint64 {
 		// lv_rimage_2, and lv_rimage_3).
 		//
 		// ~ https://access.redhat.com/documentation/en-us/red_hat_enterprise_linux/6/html/logical_volume_manager_administration/raid_volumes#create-raid
-		count -= copies
+		// A striped raid1 volume, i.e., a raid10 volume, has a data and
+		// metadata subvolume per stripe of every copy.
+		images := copies * stripes
+		if count < images {
+			return 0
+		}
+		count -= images
 		// Divide the remaining extents by the number of copies.
 		count /= copies
-		return count
+		return count / stripes * stripes
 	default:
 		panic(fmt.Sprintf("unsupported volume type: %v", r.Type))
 	}
@@ -166,7 +304,7 @@ func (r VolumeLayout) extentsFree(count uint64) uint64 {
 // ExtentSize returns the size in bytes of a single extent.
 func (vg *VolumeGroup) ExtentSize() (uint64, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_extent_size", vg.name); err != nil {
+	if err := run(vg.context(), "vgs", result, "--options=vg_extent_size", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return 0, ErrVolumeGroupNotFound
 		}
@@ -180,10 +318,29 @@ func (vg *VolumeGroup) ExtentSize() (uint64, error) {
 	return 0, ErrVolumeGroupNotFound
 }
 
+// RoundUpSize returns the size of a logical volume that is created with
+// the given size, i.e., the size rounded up to a multiple of the extent
+// size, along with the extent size. It does not create the logical volume.
+func (vg *VolumeGroup) RoundUpSize(sizeInBytes uint64) (size, extentSize uint64, err error) {
+	extentSize, err = vg.ExtentSize()
+	if err != nil {
+		return 0, 0, err
+	}
+	return roundUp(sizeInBytes, extentSize), extentSize, nil
+}
+
+// roundUp rounds size up to the nearest multiple of unit.
+func roundUp(size, unit uint64) uint64 {
+	if size%unit == 0 {
+		return size
+	}
+	return (size/unit + 1) * unit
+}
+
 // ExtentCount returns the number of extents.
 func (vg *VolumeGroup) ExtentCount() (uint64, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_extent_count", vg.name); err != nil {
+	if err := run(vg.context(), "vgs", result, "--options=vg_extent_count", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return 0, ErrVolumeGroupNotFound
 		}
@@ -210,7 +367,7 @@ func (vg *VolumeGroup) ExtentFreeCount(raid VolumeLayout) (uint64, error) {
 		return 0, nil
 	}
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_free_count,vg_extent_size", vg.name); err != nil {
+	if err := run(vg.context(), "vgs", result, "--options=vg_free_count,vg_extent_size", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return 0, ErrVolumeGroupNotFound
 		}
@@ -252,17 +409,30 @@ type VolumeLayout struct {
 	Type VolumeType
 	// Type corresponds to the --mirrors= option to lvcreate.
 	Mirrors uint64
-	// Type corresponds to the --stripes= option to lvcreate.
+	// Stripes corresponds to the --stripes= option to lvcreate. Each
+	// stripe is allocated on a separate device. Striped linear volumes
+	// are created with --type=striped and striped raid1 volumes with
+	// --type=raid10.
 	Stripes uint64
 	// Type corresponds to the --stripesize= option to lvcreate.
 	StripeSize uint64
 }
 
+// stripes returns the number of stripes, which is 1 if Stripes is
+// unspecified.
+func (c VolumeLayout) stripes() uint64 {
+	if c.Stripes == 0 {
+		return 1
+	}
+	return c.Stripes
+}
+
 func (c VolumeLayout) MinNumberOfDevices() uint64 {
 	switch c.Type {
 	case VolumeTypeDefault, VolumeTypeLinear:
-		// Linear volumes require no extra metadata extent.
-		return 1
+		// Linear volumes require no extra metadata extent. Every
+		// stripe requires a separate device.
+		return c.stripes()
 	case VolumeTypeRAID1:
 		mirrors := c.Mirrors
 		if mirrors == 0 {
@@ -270,7 +440,7 @@ func (c VolumeLayout) MinNumberOfDevices() uint64 {
 			// default value of 1.
 			mirrors = 1
 		}
-		return 2 * mirrors
+		return 2 * mirrors * c.stripes()
 	default:
 		panic(fmt.Sprintf("unsupported volume type: %v", c.Type))
 	}
@@ -281,9 +451,19 @@ func (c VolumeLayout) Flags() (fs []string) {
 	case VolumeTypeDefault:
 		// We return no --type flag if no config was specified.
 	case VolumeTypeLinear:
-		fs = append(fs, "--type=linear")
+		if c.stripes() > 1 {
+			// lvcreate rejects --stripes with --type=linear.
+			fs = append(fs, "--type=striped")
+		} else {
+			fs = append(fs, "--type=linear")
+		}
 	case VolumeTypeRAID1:
-		fs = append(fs, "--type=raid1")
+		if c.stripes() > 1 {
+			// lvcreate rejects --stripes with --type=raid1.
+			fs = append(fs, "--type=raid10")
+		} else {
+			fs = append(fs, "--type=raid1")
+		}
 	default:
 		panic(fmt.Sprintf("lvm: unexpected volume type: %v", c.Type))
 	}
@@ -320,14 +500,29 @@ func VolumeLayoutOpt(r VolumeLayout) CreateLogicalVolumeOpt {
 	}
 }
 
+// ActivationSkipOpt sets the activation skip flag on the new logical
+// volume so that it is not activated by `vgchange -ay`, e.g., by the
+// host's lvm2-activation services at boot. The logical volume is still
+// activated upon creation and must otherwise be activated using Activate.
+func ActivationSkipOpt() CreateLogicalVolumeOpt {
+	return func(o *LVOpts) {
+		o.activationSkip = true
+	}
+}
+
 type CreateLogicalVolumeOpt func(opts *LVOpts)
 
 type LVOpts struct {
-	volumeLayout VolumeLayout
+	volumeLayout   VolumeLayout
+	activationSkip bool
+	pvs            []string
 }
 
 func (o LVOpts) Flags() (opts []string) {
 	opts = append(opts, o.volumeLayout.Flags()...)
+	if o.activationSkip {
+		opts = append(opts, "--setactivationskip=y", "--ignoreactivationskip")
+	}
 	return opts
 }
 
@@ -340,19 +535,25 @@ func (o LVOpts) Flags() (opts []string) {
 // If sizeInBytes is zero the entire available space is allocated.
 //
 // Additional optional config items can be specified using CreateLogicalVolumeOpt
+//
+// The logical volume is created with its tags and layout by a single
+// lvcreate command so that it never exists without them.
 func (vg *VolumeGroup) CreateLogicalVolume(name string, sizeInBytes uint64, tags []string, optFns ...CreateLogicalVolumeOpt) (*LogicalVolume, error) {
 	if err := ValidateLogicalVolumeName(name); err != nil {
 		return nil, err
 	}
-	// Validate the tag.
+	// Validate the tags.
 	var args []string
+	var added []string
 	for _, tag := range tags {
-		if tag != "" {
-			if err := ValidateTag(tag); err != nil {
-				return nil, err
-			}
-			args = append(args, "--add-tag="+tag)
+		if tag == "" || containsTag(added, tag) {
+			continue
 		}
+		if err := ValidateTag(tag); err != nil {
+			return nil, err
+		}
+		args = append(args, "--addtag="+tag)
+		added = append(added, tag)
 	}
 	args = append(args, fmt.Sprintf("--size=%db", sizeInBytes))
 	args = append(args, "--name="+name)
@@ -364,16 +565,29 @@ func (vg *VolumeGroup) CreateLogicalVolume(name string, sizeInBytes uint64, tags
 		}
 	}
 	args = append(args, opts.Flags()...)
-	if err := run("lvcreate", nil, args...); err != nil {
+	args = append(args, opts.pvs...)
+	if err := run(vg.context(), "lvcreate", nil, args...); err != nil {
 		if isInsufficientSpace(err) {
+			recordError(ErrNoSpace)
 			return nil, ErrNoSpace
 		}
 		if isInsufficientDevices(err) {
+			recordError(ErrTooFewDisks)
 			return nil, ErrTooFewDisks
 		}
 		return nil, err
 	}
-	return &LogicalVolume{name, sizeInBytes, vg}, nil
+	return &LogicalVolume{name: name, sizeInBytes: sizeInBytes, vg: vg, tags: added, tagsKnown: true}, nil
+}
+
+// containsTag returns whether tags contains tag.
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
 }
 
 // ValidateLogicalVolumeName validates a volume group name. A valid volume
@@ -394,10 +608,32 @@ type lvsItem struct {
 	LvPath string `json:"lv_path"`
 	LvSize uint64 `json:"lv_size,string"`
 	LvTags string `json:"lv_tags"`
+	// LvHealthStatus is empty if the logical volume is healthy.
+	LvHealthStatus string `json:"lv_health_status"`
+	Segtype        string `json:"segtype"`
+	// Stripes is the number of stripes or, for raid volumes, images.
+	Stripes uint64 `json:"stripes,string"`
+	// LvPermissions is "writeable" or "read-only".
+	LvPermissions string `json:"lv_permissions"`
+	// LvDeviceOpen is "open" if the device is open.
+	LvDeviceOpen string `json:"lv_device_open"`
+	// Devices lists the devices of a segment, e.g., "/dev/sdb(0)".
+	Devices string `json:"devices"`
+	// RaidSyncAction is the current synchronization action of a raid
+	// logical volume, e.g., "idle" or "check".
+	RaidSyncAction string `json:"raid_sync_action"`
+	// RaidMismatchCount is the number of mismatches found by the last
+	// check of a raid logical volume.
+	RaidMismatchCount string `json:"raid_mismatch_count"`
 }
 
 func (lv lvsItem) tagList() (tags []string) {
-	for _, tag := range strings.Split(lv.LvTags, ",") {
+	return splitTags(lv.LvTags)
+}
+
+// splitTags splits the comma-separated tags reported by LVM.
+func splitTags(s string) (tags []string) {
+	for _, tag := range strings.Split(s, ",") {
 		tag = strings.TrimSpace(tag)
 		if tag != "" {
 			tags = append(tags, tag)
@@ -451,11 +687,46 @@ func LVMatchTag(tag string) func(lvsItem) bool {
 	}
 }
 
+// LVMatchNamePrefix matches the logical volumes whose name starts with
+// prefix.
+func LVMatchNamePrefix(prefix string) func(lvsItem) bool {
+	return func(lv lvsItem) bool {
+		return strings.HasPrefix(lv.Name, prefix)
+	}
+}
+
+// LVMatchName matches the logical volumes whose name is matched by match.
+func LVMatchName(match func(name string) bool) func(lvsItem) bool {
+	return func(lv lvsItem) bool {
+		return match(lv.Name)
+	}
+}
+
+// LVMatchNot matches the logical volumes that are not matched by match.
+func LVMatchNot(match func(lvsItem) bool) func(lvsItem) bool {
+	return func(lv lvsItem) bool {
+		return !match(lv)
+	}
+}
+
+// LVMatchAll matches the logical volumes that are matched by all of the
+// given functions.
+func LVMatchAll(matchers ...func(lvsItem) bool) func(lvsItem) bool {
+	return func(lv lvsItem) bool {
+		for _, match := range matchers {
+			if !match(lv) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 // FindLogicalVolume looks up the logical volume in the volume group
 // with the given name.
 func (vg *VolumeGroup) FindLogicalVolume(matchFirst func(lvsItem) bool) (*LogicalVolume, error) {
 	result := new(lvsOutput)
-	if err := run("lvs", result, "--options=lv_name,lv_size,vg_name,lv_tags", vg.Name()); err != nil {
+	if err := run(vg.context(), "lvs", result, "--options=lv_name,lv_size,vg_name,lv_tags", vg.Name()); err != nil {
 		if IsLogicalVolumeNotFound(err) {
 			return nil, ErrLogicalVolumeNotFound
 		}
@@ -469,7 +740,7 @@ func (vg *VolumeGroup) FindLogicalVolume(matchFirst func(lvsItem) bool) (*Logica
 			if matchFirst != nil && !matchFirst(lv) {
 				continue
 			}
-			return &LogicalVolume{lv.Name, lv.LvSize, vg}, nil
+			return &LogicalVolume{name: lv.Name, sizeInBytes: lv.LvSize, vg: vg, tags: lv.tagList(), tagsKnown: true}, nil
 		}
 	}
 	return nil, ErrLogicalVolumeNotFound
@@ -479,7 +750,7 @@ func (vg *VolumeGroup) FindLogicalVolume(matchFirst func(lvsItem) bool) (*Logica
 func (vg *VolumeGroup) ListLogicalVolumeNames() ([]string, error) {
 	var names []string
 	result := new(lvsOutput)
-	if err := run("lvs", result, "--options=lv_name,vg_name", vg.name); err != nil {
+	if err := run(vg.context(), "lvs", result, "--options=lv_name,vg_name", vg.name); err != nil {
 		return nil, err
 	}
 	for _, report := range result.Report {
@@ -492,6 +763,45 @@ func (vg *VolumeGroup) ListLogicalVolumeNames() ([]string, error) {
 	return names, nil
 }
 
+// LogicalVolumeReport describes a logical volume of a volume group as
+// reported by `lvs`.
+type LogicalVolumeReport struct {
+	Name        string
+	SizeInBytes uint64
+	Path        string
+	Tags        []string
+	// HealthStatus is empty if the logical volume is healthy, see
+	// LogicalVolume.HealthStatus.
+	HealthStatus string
+}
+
+// ReportLogicalVolumes returns the size, path, tags and health of the
+// logical volumes in this volume group. Unlike looking up each logical
+// volume, it runs `lvs` once, which matters for volume groups with many
+// logical volumes.
+func (vg *VolumeGroup) ReportLogicalVolumes() ([]LogicalVolumeReport, error) {
+	var reports []LogicalVolumeReport
+	result := new(lvsOutput)
+	if err := run(vg.context(), "lvs", result, "--options=lv_name,vg_name,lv_size,lv_path,lv_tags,lv_health_status", vg.name); err != nil {
+		return nil, err
+	}
+	for _, report := range result.Report {
+		for _, lv := range report.Lv {
+			if lv.VgName != vg.name {
+				continue
+			}
+			reports = append(reports, LogicalVolumeReport{
+				Name:         lv.Name,
+				SizeInBytes:  lv.LvSize,
+				Path:         lv.LvPath,
+				Tags:         lv.tagList(),
+				HealthStatus: lv.LvHealthStatus,
+			})
+		}
+	}
+	return reports, nil
+}
+
 func IsPhysicalVolumeNotFound(err error) bool {
 	return isPhysicalVolumeNotFound(err) ||
 		isNoPhysicalVolumeLabel(err)
@@ -544,7 +854,7 @@ func IsVolumeGroupNotFound(err error) bool {
 func (vg *VolumeGroup) ListPhysicalVolumeNames() ([]string, error) {
 	var names []string
 	result := new(pvsOutput)
-	if err := run("pvs", result, "--options=pv_name,vg_name"); err != nil {
+	if err := run(vg.context(), "pvs", result, "--options=pv_name,vg_name"); err != nil {
 		return nil, err
 	}
 	for _, report := range result.Report {
@@ -557,10 +867,98 @@ func (vg *VolumeGroup) ListPhysicalVolumeNames() ([]string, error) {
 	return names, nil
 }
 
+// PhysicalVolumeReport describes a physical volume of a volume group as
+// reported by `pvs`.
+type PhysicalVolumeReport struct {
+	Name        string
+	SizeInBytes uint64
+	BytesFree   uint64
+	// Missing is true if LVM cannot find the device of the physical
+	// volume. Its name is then reported as "[unknown]".
+	Missing bool
+	Tags    []string
+}
+
+// ReportPhysicalVolumes returns the size, free space and state of the
+// physical volumes in this volume group.
+func (vg *VolumeGroup) ReportPhysicalVolumes() ([]PhysicalVolumeReport, error) {
+	var reports []PhysicalVolumeReport
+	result := new(pvsOutput)
+	if err := run(vg.context(), "pvs", result, "--options=pv_name,vg_name,pv_size,pv_free,pv_attr,pv_tags"); err != nil {
+		return nil, err
+	}
+	for _, report := range result.Report {
+		for _, pv := range report.Pv {
+			if pv.VgName != vg.name {
+				continue
+			}
+			reports = append(reports, PhysicalVolumeReport{
+				Name:        pv.Name,
+				SizeInBytes: pv.PvSize,
+				BytesFree:   pv.PvFree,
+				// The third pv_attr character is 'm' if the
+				// physical volume is missing.
+				Missing: len(pv.PvAttr) > 2 && pv.PvAttr[2] == 'm',
+				Tags:    splitTags(pv.PvTags),
+			})
+		}
+	}
+	return reports, nil
+}
+
+// FreeSegmentsReport describes the unallocated space of a physical volume
+// as reported by `pvs --segments`.
+type FreeSegmentsReport struct {
+	Name string
+	// Count is the number of contiguous unallocated regions. The more
+	// there are for the same free space, the more fragmented it is.
+	Count uint64
+	// LargestBytes is the size in bytes of the largest contiguous
+	// unallocated region.
+	LargestBytes uint64
+}
+
+// ReportFreeSegments returns the contiguous unallocated regions of the
+// physical volumes in this volume group. Allocations that must be
+// contiguous or that place every stripe or raid image on a separate device
+// can fail even if BytesFree reports enough space when the free space is
+// fragmented.
+func (vg *VolumeGroup) ReportFreeSegments() ([]FreeSegmentsReport, error) {
+	var reports []FreeSegmentsReport
+	result := new(pvsegsOutput)
+	if err := run(vg.context(), "pvs", result, "--segments", "--options=pv_name,vg_name,vg_extent_size,pvseg_size,segtype"); err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	for _, report := range result.Report {
+		for _, seg := range report.Pvseg {
+			if seg.VgName != vg.name {
+				continue
+			}
+			i, ok := index[seg.Name]
+			if !ok {
+				i = len(reports)
+				index[seg.Name] = i
+				reports = append(reports, FreeSegmentsReport{Name: seg.Name})
+			}
+			if seg.Segtype != "free" {
+				continue
+			}
+			// The size of a segment is reported in extents.
+			size := seg.PvsegSize * seg.VgExtentSize
+			reports[i].Count++
+			if size > reports[i].LargestBytes {
+				reports[i].LargestBytes = size
+			}
+		}
+	}
+	return reports, nil
+}
+
 // Tags returns the volume group tags.
 func (vg *VolumeGroup) Tags() ([]string, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_tags", vg.name); err != nil {
+	if err := run(vg.context(), "vgs", result, "--options=vg_tags", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return nil, ErrVolumeGroupNotFound
 		}
@@ -582,9 +980,42 @@ func (vg *VolumeGroup) Tags() ([]string, error) {
 	return nil, ErrVolumeGroupNotFound
 }
 
+// Extend adds the given physical volumes to the volume group.
+func (vg *VolumeGroup) Extend(pvs ...*PhysicalVolume) error {
+	args := []string{vg.name}
+	for _, pv := range pvs {
+		args = append(args, pv.dev)
+	}
+	if err := run(vg.context(), "vgextend", nil, args...); err != nil {
+		if IsVolumeGroupNotFound(err) {
+			return ErrVolumeGroupNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Backup writes the metadata of the volume group to the file at path using
+// vgcfgbackup. The file can be passed to vgcfgrestore.
+func (vg *VolumeGroup) Backup(path string) error {
+	if err := run(vg.context(), "vgcfgbackup", nil, "--file", path, vg.name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Activate activates all logical volumes in the volume group, including
+// those whose activation skip flag is set.
+func (vg *VolumeGroup) Activate() error {
+	if err := run(vg.context(), "vgchange", nil, "--activate=y", "--ignoreactivationskip", vg.name); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Remove removes the volume group from disk.
 func (vg *VolumeGroup) Remove() error {
-	if err := run("vgremove", nil, "-f", vg.name); err != nil {
+	if err := run(vg.context(), "vgremove", nil, "-f", vg.name); err != nil {
 		return err
 	}
 	return nil
@@ -594,6 +1025,11 @@ type LogicalVolume struct {
 	name        string
 	sizeInBytes uint64
 	vg          *VolumeGroup
+	// tags are the tags the logical volume had when it was created or
+	// looked up, or when Tags or ChangeTags was last called, if
+	// tagsKnown is true. See CachedTags.
+	tags      []string
+	tagsKnown bool
 }
 
 func (lv *LogicalVolume) Name() string {
@@ -607,7 +1043,7 @@ func (lv *LogicalVolume) SizeInBytes() uint64 {
 // Path returns the device path for the logical volume.
 func (lv *LogicalVolume) Path() (string, error) {
 	result := new(lvsOutput)
-	if err := run("lvs", result, "--options=lv_path", lv.vg.name+"/"+lv.name); err != nil {
+	if err := run(lv.vg.context(), "lvs", result, "--options=lv_path", lv.vg.name+"/"+lv.name); err != nil {
 		if IsLogicalVolumeNotFound(err) {
 			return "", ErrLogicalVolumeNotFound
 		}
@@ -621,57 +1057,300 @@ func (lv *LogicalVolume) Path() (string, error) {
 	return "", ErrLogicalVolumeNotFound
 }
 
-// Tags returns the volume group tags.
+// Tags returns the logical volume tags as reported by `lvs` and refreshes
+// those returned by CachedTags.
 func (lv *LogicalVolume) Tags() ([]string, error) {
 	result := new(lvsOutput)
-	if err := run("lvs", result, "--options=lv_tags", lv.vg.name+"/"+lv.name); err != nil {
+	if err := run(lv.vg.context(), "lvs", result, "--options=lv_tags", lv.vg.name+"/"+lv.name); err != nil {
 		if IsLogicalVolumeNotFound(err) {
 			return nil, ErrLogicalVolumeNotFound
 		}
 		return nil, err
 	}
 	for _, report := range result.Report {
-		for _, lv := range report.Lv {
-			return lv.tagList(), nil
+		for _, item := range report.Lv {
+			lv.tags, lv.tagsKnown = item.tagList(), true
+			return lv.CachedTags()
 		}
 	}
 	return nil, ErrLogicalVolumeNotFound
 }
 
+// CachedTags returns the logical volume tags without running an LVM
+// command if they are known, i.e., if the logical volume was created or
+// looked up by this package. The tags may be stale if they were changed
+// other than through this LogicalVolume since, in which case Tags should be
+// used instead.
+func (lv *LogicalVolume) CachedTags() ([]string, error) {
+	if !lv.tagsKnown {
+		return lv.Tags()
+	}
+	return append([]string(nil), lv.tags...), nil
+}
+
+// HealthStatus returns the health of the logical volume as reported by the
+// lv_health_status field of `lvs`, e.g., "partial" or "refresh needed". It
+// returns the empty string if the logical volume is healthy.
+func (lv *LogicalVolume) HealthStatus() (string, error) {
+	result := new(lvsOutput)
+	if err := run(lv.vg.context(), "lvs", result, "--options=lv_health_status", lv.vg.name+"/"+lv.name); err != nil {
+		if IsLogicalVolumeNotFound(err) {
+			return "", ErrLogicalVolumeNotFound
+		}
+		return "", err
+	}
+	for _, report := range result.Report {
+		for _, lv := range report.Lv {
+			return lv.LvHealthStatus, nil
+		}
+	}
+	return "", ErrLogicalVolumeNotFound
+}
+
+// Activate activates the logical volume, ignoring its activation skip
+// flag. It is a no-op if the logical volume is already active.
+func (lv *LogicalVolume) Activate() error {
+	if err := run(lv.vg.context(), "lvchange", nil, "--activate=y", "--ignoreactivationskip", lv.vg.name+"/"+lv.name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ChangeTags adds the tags in add to and removes the tags in del from the
+// logical volume.
+func (lv *LogicalVolume) ChangeTags(add, del []string) error {
+	var args []string
+	for _, tag := range add {
+		if err := ValidateTag(tag); err != nil {
+			return err
+		}
+		args = append(args, "--addtag="+tag)
+	}
+	for _, tag := range del {
+		if err := ValidateTag(tag); err != nil {
+			return err
+		}
+		args = append(args, "--deltag="+tag)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	args = append(args, lv.vg.name+"/"+lv.name)
+	if err := run(lv.vg.context(), "lvchange", nil, args...); err != nil {
+		// The tags may have been changed partially.
+		lv.tagsKnown = false
+		return err
+	}
+	if lv.tagsKnown {
+		var tags []string
+		for _, tag := range lv.tags {
+			if !containsTag(del, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		for _, tag := range add {
+			if !containsTag(tags, tag) && !containsTag(del, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		lv.tags = tags
+	}
+	return nil
+}
+
+// IsReadonly returns whether the logical volume's permission is read-only.
+func (lv *LogicalVolume) IsReadonly() (bool, error) {
+	result := new(lvsOutput)
+	if err := run(lv.vg.context(), "lvs", result, "--options=lv_permissions", lv.vg.name+"/"+lv.name); err != nil {
+		if IsLogicalVolumeNotFound(err) {
+			return false, ErrLogicalVolumeNotFound
+		}
+		return false, err
+	}
+	for _, report := range result.Report {
+		for _, lv := range report.Lv {
+			return strings.HasPrefix(lv.LvPermissions, "read-only"), nil
+		}
+	}
+	return false, ErrLogicalVolumeNotFound
+}
+
+// Layout returns the layout of the logical volume, e.g., of a volume that
+// was not created with a VolumeLayout. Layouts other than linear, striped,
+// raid1 and raid10 are not supported.
+func (lv *LogicalVolume) Layout() (VolumeLayout, error) {
+	result := new(lvsOutput)
+	if err := run(lv.vg.context(), "lvs", result, "--options=segtype,stripes", lv.vg.name+"/"+lv.name); err != nil {
+		if IsLogicalVolumeNotFound(err) {
+			return VolumeLayout{}, ErrLogicalVolumeNotFound
+		}
+		return VolumeLayout{}, err
+	}
+	for _, report := range result.Report {
+		for _, lv := range report.Lv {
+			return volumeLayoutFromSegtype(lv.Segtype, lv.Stripes)
+		}
+	}
+	return VolumeLayout{}, ErrLogicalVolumeNotFound
+}
+
+// volumeLayoutFromSegtype returns the layout of a logical volume with the
+// given segment type and number of stripes as reported by lvs, i.e., the
+// layout that VolumeLayout.Flags creates it with.
+func volumeLayoutFromSegtype(segtype string, stripes uint64) (VolumeLayout, error) {
+	switch segtype {
+	case "linear":
+		return VolumeLayout{Type: VolumeTypeLinear}, nil
+	case "striped":
+		return VolumeLayout{Type: VolumeTypeLinear, Stripes: stripes}, nil
+	case "raid1":
+		// Each mirror is an additional image.
+		if stripes < 2 {
+			return VolumeLayout{}, fmt.Errorf("lvm: raid1 volume has %d images", stripes)
+		}
+		return VolumeLayout{Type: VolumeTypeRAID1, Mirrors: stripes - 1}, nil
+	case "raid10":
+		// raid10 keeps two copies of each stripe.
+		if stripes < 4 || stripes%2 != 0 {
+			return VolumeLayout{}, fmt.Errorf("lvm: raid10 volume has %d images", stripes)
+		}
+		return VolumeLayout{Type: VolumeTypeRAID1, Mirrors: 1, Stripes: stripes / 2}, nil
+	}
+	return VolumeLayout{}, fmt.Errorf("lvm: unsupported segment type %q", segtype)
+}
+
+// SetReadonly changes the permission of the logical volume to read-only
+// or read-write. lvchange fails if the permission is unchanged so callers
+// should check IsReadonly first.
+func (lv *LogicalVolume) SetReadonly(readonly bool) error {
+	permission := "rw"
+	if readonly {
+		permission = "r"
+	}
+	return run(lv.vg.context(), "lvchange", nil, "--permission="+permission, lv.vg.name+"/"+lv.name)
+}
+
+// ConvertToRAID1 converts the linear or raid1 logical volume to a raid1
+// logical volume with the given number of mirrors, e.g., 1 for two copies
+// of the data. The new images are synchronized in the background.
+func (lv *LogicalVolume) ConvertToRAID1(mirrors uint64) error {
+	args := []string{
+		"--yes",
+		"--type=raid1",
+		fmt.Sprintf("--mirrors=%d", mirrors),
+		lv.vg.name + "/" + lv.name,
+	}
+	if err := run(lv.vg.context(), "lvconvert", nil, args...); err != nil {
+		if isInsufficientSpace(err) {
+			recordError(ErrNoSpace)
+			return ErrNoSpace
+		}
+		if isInsufficientDevices(err) {
+			recordError(ErrTooFewDisks)
+			return ErrTooFewDisks
+		}
+		return err
+	}
+	return nil
+}
+
 func (lv *LogicalVolume) Remove() error {
-	if err := run("lvremove", nil, "-f", lv.vg.name+"/"+lv.name); err != nil {
+	if err := run(lv.vg.context(), "lvremove", nil, "-f", lv.vg.name+"/"+lv.name); err != nil {
+		if IsLogicalVolumeNotFound(err) {
+			return ErrLogicalVolumeNotFound
+		}
 		return err
 	}
 	return nil
 }
 
+// Rename renames the logical volume. Its device path changes accordingly.
+func (lv *LogicalVolume) Rename(name string) error {
+	if err := ValidateLogicalVolumeName(name); err != nil {
+		return err
+	}
+	if err := run(lv.vg.context(), "lvrename", nil, lv.vg.name, lv.name, name); err != nil {
+		return err
+	}
+	lv.name = name
+	return nil
+}
+
 // PVScan runs the `pvscan --cache <dev>` command. It scans for the
 // device at `dev` and adds it to the LVM metadata cache if `lvmetad`
 // is running. If `dev` is an empty string, it scans all devices.
-func PVScan(dev string) error {
+//
+// Concurrent scans of the same device are coalesced and scans are skipped
+// within the interval given to SetMinScanInterval unless Force is given.
+func PVScan(dev string, opts ...ScanOpt) error {
 	args := []string{"--cache"}
 	if dev != "" {
 		args = append(args, dev)
 	}
-	return run("pvscan", nil, args...)
+	return scans.scan("pvscan", dev, func() error {
+		return run(context.Background(), "pvscan", nil, args...)
+	}, opts...)
 }
 
 // VGScan runs the `vgscan --cache <name>` command. It scans for the
 // volume group and adds it to the LVM metadata cache if `lvmetad`
 // is running. If `name` is an empty string, it scans all volume groups.
-func VGScan(name string) error {
+//
+// Scans are scheduled like those of PVScan.
+func VGScan(name string, opts ...ScanOpt) error {
 	args := []string{"--cache"}
 	if name != "" {
 		args = append(args, name)
 	}
-	return run("vgscan", nil, args...)
+	return scans.scan("vgscan", name, func() error {
+		return run(context.Background(), "vgscan", nil, args...)
+	}, opts...)
+}
+
+// MinExtentSize is the smallest extent size accepted by ValidateExtentSize.
+const MinExtentSize uint64 = 1 << 10
+
+const ErrInvalidExtentSize = simpleError("lvm: Extent size must be a power of two of at least 1KiB")
+
+// ValidateExtentSize validates a physical extent size in bytes. LVM requires
+// extent sizes to be a power of two of at least one sector. We require at
+// least 1KiB.
+func ValidateExtentSize(size uint64) error {
+	if size < MinExtentSize || size&(size-1) != 0 {
+		return ErrInvalidExtentSize
+	}
+	return nil
+}
+
+type VolumeGroupOpt func(opts *VGOpts)
+
+type VGOpts struct {
+	extentSize uint64
+}
+
+func (o VGOpts) Flags() (opts []string) {
+	if o.extentSize != 0 {
+		opts = append(opts, fmt.Sprintf("--physicalextentsize=%db", o.extentSize))
+	}
+	return opts
+}
+
+// ExtentSize sets the physical extent size in bytes of the new volume
+// group. If it is not specified the LVM default (4MiB) is used.
+func ExtentSize(size uint64) VolumeGroupOpt {
+	return func(o *VGOpts) {
+		o.extentSize = size
+	}
 }
 
 // CreateVolumeGroup creates a new volume group.
+//
+// Additional optional config items can be specified using VolumeGroupOpt
 func CreateVolumeGroup(
 	name string,
 	pvs []*PhysicalVolume,
-	tags []string) (*VolumeGroup, error) {
+	tags []string,
+	optFns ...VolumeGroupOpt) (*VolumeGroup, error) {
 	var args []string
 	if err := ValidateVolumeGroupName(name); err != nil {
 		return nil, err
@@ -684,24 +1363,38 @@ func CreateVolumeGroup(
 			args = append(args, "--add-tag="+tag)
 		}
 	}
+	opts := new(VGOpts)
+	for _, fn := range optFns {
+		if fn != nil {
+			fn(opts)
+		}
+	}
+	if opts.extentSize != 0 {
+		if err := ValidateExtentSize(opts.extentSize); err != nil {
+			return nil, err
+		}
+	}
+	args = append(args, opts.Flags()...)
 	args = append(args, name)
 	for _, pv := range pvs {
 		args = append(args, pv.dev)
 	}
-	if err := run("vgcreate", nil, args...); err != nil {
+	if err := run(context.Background(), "vgcreate", nil, args...); err != nil {
 		return nil, err
 	}
 	// Perform a best-effort scan to trigger a lvmetad cache refresh.
 	// We ignore errors as for better or worse, the volume group now exists.
 	// Without this lvmetad can fail to pickup newly created volume groups.
 	// See https://bugzilla.redhat.com/show_bug.cgi?id=837599
-	if err := PVScan(""); err != nil {
+	// The scans are forced as a recent scan cannot have seen the new
+	// volume group.
+	if err := PVScan("", Force()); err != nil {
 		log.Printf("error during pvscan: %v", err)
 	}
-	if err := VGScan(""); err != nil {
+	if err := VGScan("", Force()); err != nil {
 		log.Printf("error during vgscan: %v", err)
 	}
-	return &VolumeGroup{name}, nil
+	return &VolumeGroup{name: name}, nil
 }
 
 // ValidateVolumeGroupName validates a volume group name. A valid volume group
@@ -749,7 +1442,7 @@ type vgsOutput struct {
 // LookupVolumeGroup returns the volume group with the given name.
 func LookupVolumeGroup(name string) (*VolumeGroup, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_name", name); err != nil {
+	if err := run(context.Background(), "vgs", result, "--options=vg_name", name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return nil, ErrVolumeGroupNotFound
 		}
@@ -757,7 +1450,7 @@ func LookupVolumeGroup(name string) (*VolumeGroup, error) {
 	}
 	for _, report := range result.Report {
 		for _, vg := range report.Vg {
-			return &VolumeGroup{vg.Name}, nil
+			return &VolumeGroup{name: vg.Name}, nil
 		}
 	}
 	return nil, ErrVolumeGroupNotFound
@@ -768,7 +1461,7 @@ func LookupVolumeGroup(name string) (*VolumeGroup, error) {
 // function.
 func ListVolumeGroupNames() ([]string, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result); err != nil {
+	if err := run(context.Background(), "vgs", result); err != nil {
 		return nil, err
 	}
 	var names []string
@@ -785,7 +1478,7 @@ func ListVolumeGroupNames() ([]string, error) {
 // function.
 func ListVolumeGroupUUIDs() ([]string, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_uuid"); err != nil {
+	if err := run(context.Background(), "vgs", result, "--options=vg_uuid"); err != nil {
 		return nil, err
 	}
 	var uuids []string
@@ -799,7 +1492,7 @@ func ListVolumeGroupUUIDs() ([]string, error) {
 
 // CreatePhysicalVolume creates a physical volume of the given device.
 func CreatePhysicalVolume(dev string) (*PhysicalVolume, error) {
-	if err := run("pvcreate", nil, dev); err != nil {
+	if err := run(context.Background(), "pvcreate", nil, dev); err != nil {
 		return nil, fmt.Errorf("lvm: CreatePhysicalVolume: %v", err)
 	}
 	return &PhysicalVolume{dev}, nil
@@ -810,14 +1503,30 @@ type pvsOutput struct {
 		Pv []struct {
 			Name   string `json:"pv_name"`
 			VgName string `json:"vg_name"`
+			PvSize uint64 `json:"pv_size,string"`
+			PvFree uint64 `json:"pv_free,string"`
+			PvAttr string `json:"pv_attr"`
+			PvTags string `json:"pv_tags"`
 		} `json:"pv"`
 	} `json:"report"`
 }
 
+type pvsegsOutput struct {
+	Report []struct {
+		Pvseg []struct {
+			Name         string `json:"pv_name"`
+			VgName       string `json:"vg_name"`
+			VgExtentSize uint64 `json:"vg_extent_size,string"`
+			PvsegSize    uint64 `json:"pvseg_size,string"`
+			Segtype      string `json:"segtype"`
+		} `json:"pvseg"`
+	} `json:"report"`
+}
+
 // ListPhysicalVolumes lists all physical volumes.
 func ListPhysicalVolumes() ([]*PhysicalVolume, error) {
 	result := new(pvsOutput)
-	if err := run("pvs", result); err != nil {
+	if err := run(context.Background(), "pvs", result); err != nil {
 		return nil, err
 	}
 	var pvs []*PhysicalVolume
@@ -832,7 +1541,7 @@ func ListPhysicalVolumes() ([]*PhysicalVolume, error) {
 // LookupPhysicalVolume returns a physical volume with the given name.
 func LookupPhysicalVolume(name string) (*PhysicalVolume, error) {
 	result := new(pvsOutput)
-	if err := run("pvs", result, "--options=pv_name", name); err != nil {
+	if err := run(context.Background(), "pvs", result, "--options=pv_name", name); err != nil {
 		if IsPhysicalVolumeNotFound(err) {
 			return nil, ErrPhysicalVolumeNotFound
 		}
@@ -849,7 +1558,46 @@ func LookupPhysicalVolume(name string) (*PhysicalVolume, error) {
 // Extent sizing for linear logical volumes:
 // https://github.com/Jajcus/lvm2/blob/266d6564d7a72fcff5b25367b7a95424ccf8089e/lib/metadata/metadata.c#L983
 
-func run(cmd string, v interface{}, extraArgs ...string) error {
+// runOnce runs the LVM command once. It is interrupted once ctx is done, in
+// which case ctx.Err() is returned.
+func runOnce(ctx context.Context, cmd string, v interface{}, extraArgs ...string) error {
+	var args []string
+	if v != nil {
+		args = append(args, "--reportformat=json")
+		args = append(args, "--units=b")
+		args = append(args, "--nosuffix")
+	}
+	if lvmconfig != "" {
+		args = append(args, "--config", lvmconfig)
+	}
+	if devicesfile != "" {
+		args = append(args, "--devicesfile", devicesfile)
+	}
+	args = append(args, extraArgs...)
+	if err := injectFault(ctx, cmd, extraArgs); err != nil {
+		return err
+	}
+	// The output of report commands is cached if SetReportCacheTTL has
+	// been called. Any other command may change the metadata.
+	cacheable := v != nil && reportCommands[cmd]
+	var key string
+	var generation uint64
+	if cacheable {
+		key = reportCacheKey(cmd, args)
+		var cached []byte
+		var ok bool
+		if cached, generation, ok = reports.get(key); ok {
+			log.Printf("Using cached output of %v %v", cmd, strings.Join(args, " "))
+			recordReportCacheHit(cmd)
+			if err := json.Unmarshal(cached, v); err != nil {
+				return fmt.Errorf("%v: [%v]", err, string(cached))
+			}
+			return nil
+		}
+	} else {
+		reports.invalidate()
+		defer reports.invalidate()
+	}
 	// lvmlock can be nil, as it is a global variable that is intended to be
 	// initialized from calling code outside this package. We have no way of
 	// knowing whether the caller performed that initialization and must
@@ -859,32 +1607,45 @@ func run(cmd string, v interface{}, extraArgs ...string) error {
 		// We use Lock instead of TryLock as we have no alternative way of
 		// making progress. We expect lvm2 command-line utilities invoked by
 		// this package to return within a reasonable amount of time.
-		if lerr := lvmlock.Lock(); lerr != nil {
+		lockStart := time.Now()
+		if lerr := lockContext(ctx, lvmlock); lerr != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
 			return fmt.Errorf("lvm: acquire lock failed: %v", lerr)
 		}
+		lockWait := time.Since(lockStart)
+		recordLockWait(lockWait)
+		if tr, ok := trace.FromContext(ctx); ok {
+			tr.LazyPrintf("waited %v for the lvm lock to run %v", lockWait, cmd)
+		}
 		defer func() {
 			if lerr := lvmlock.Unlock(); lerr != nil {
 				panic(fmt.Sprintf("lvm: release lock failed: %v", lerr))
 			}
 		}()
 	}
-	var args []string
-	if v != nil {
-		args = append(args, "--reportformat=json")
-		args = append(args, "--units=b")
-		args = append(args, "--nosuffix")
-	}
-	args = append(args, extraArgs...)
 	c := exec.Command(cmd, args...)
 	log.Printf("Executing: %v", c)
 	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
 	c.Stdout = stdout
 	c.Stderr = stderr
-	if err := c.Run(); err != nil {
+	start := time.Now()
+	err := runCommand(ctx, c)
+	elapsed := time.Since(start)
+	recordCommand(cmd, elapsed, err)
+	if tr, ok := trace.FromContext(ctx); ok {
+		tr.LazyPrintf("%v: %v err=%v", c, elapsed, err)
+	}
+	if err == ctx.Err() && err != nil {
+		log.Printf("Interrupted %v: err=%v", c, err)
+		return err
+	}
+	if err != nil {
 		errstr := ignoreWarnings(stderr.String())
 		log.Print("stdout: " + stdout.String())
 		log.Print("stderr: " + errstr)
-		return errors.New(errstr)
+		return &CommandError{Command: cmd, ExitCode: exitCode(err), Stderr: errstr}
 	}
 	stdoutbuf := stdout.Bytes()
 	stderrbuf := stderr.Bytes()
@@ -895,10 +1656,26 @@ func run(cmd string, v interface{}, extraArgs ...string) error {
 		if err := json.Unmarshal(stdoutbuf, v); err != nil {
 			return fmt.Errorf("%v: [%v]", err, string(stdoutbuf))
 		}
+		if cacheable {
+			reports.put(key, stdoutbuf, generation)
+		}
 	}
 	return nil
 }
 
+// exitCode returns the exit status of the command that failed with err or
+// -1 if it did not exit.
+func exitCode(err error) int {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return -1
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+		return status.ExitStatus()
+	}
+	return -1
+}
+
 func ignoreWarnings(str string) string {
 	lines := strings.Split(str, "\n")
 	result := make([]string, 0, len(lines))
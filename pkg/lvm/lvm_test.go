@@ -1,14 +1,17 @@
-// +build !unit
+// +build linux,!unit
 
 package lvm
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"reflect"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mesosphere/csilvm/pkg/cleanup"
@@ -79,6 +82,38 @@ func TestListPhysicalVolumes(t *testing.T) {
 	t.Fatal("Expected to find physical volume but did not.")
 }
 
+func TestListPhysicalVolumes_DeviceFilter(t *testing.T) {
+	var pvs []*PhysicalVolume
+	for i := 0; i < 2; i++ {
+		loop, err := CreateLoopDevice(pvsize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer loop.Close()
+		pv, err := CreatePhysicalVolume(loop.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer check(pv.Remove)
+		pvs = append(pvs, pv)
+	}
+	// Only the first physical volume should be visible.
+	if err := SetDeviceFilter([]string{pvs[0].dev}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDeviceFilter(nil)
+	listed, err := ListPhysicalVolumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].dev != pvs[0].dev {
+		t.Fatalf("Expected only %v but got %v", pvs[0].dev, listed)
+	}
+	if _, err := LookupPhysicalVolume(pvs[1].dev); err == nil {
+		t.Fatalf("Expected %v to be excluded by the filter", pvs[1].dev)
+	}
+}
+
 func TestLookupPhysicalVolume(t *testing.T) {
 	loop, err := CreateLoopDevice(pvsize)
 	if err != nil {
@@ -103,6 +138,30 @@ func TestLookupPhysicalVolume(t *testing.T) {
 	}
 }
 
+func TestPhysicalVolumeVolumeGroupName(t *testing.T) {
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	pv, err := LookupPhysicalVolume(loop.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	vgname, err := pv.VolumeGroupName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vgname != vg.Name() {
+		t.Fatalf("Expected volume group %v but got %v", vg.Name(), vgname)
+	}
+}
+
 func TestLookupPhysicalVolumeNonExistent(t *testing.T) {
 	loop, err := CreateLoopDevice(pvsize)
 	if err != nil {
@@ -284,6 +343,57 @@ func TestCreateVolumeGroup_BadTag(t *testing.T) {
 	}
 }
 
+func TestValidateExtentSize(t *testing.T) {
+	for _, size := range []uint64{1 << 10, 4 << 10, 4 << 20, 1 << 30} {
+		if err := ValidateExtentSize(size); err != nil {
+			t.Fatalf("Expected extent size %d to pass validation", size)
+		}
+	}
+	for _, size := range []uint64{0, 512, 3 << 20, (4 << 20) + 1} {
+		if err := ValidateExtentSize(size); err != ErrInvalidExtentSize {
+			t.Fatalf("Expected extent size %d to fail validation", size)
+		}
+	}
+}
+
+func TestCreateVolumeGroup_ExtentSize(t *testing.T) {
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	// Create the volume group.
+	const extentSize = 1 << 20
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil, ExtentSize(extentSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	size, err := vg.ExtentSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != extentSize {
+		t.Fatalf("Expected extent size %d but got %d", extentSize, size)
+	}
+}
+
+func TestCreateVolumeGroup_InvalidExtentSize(t *testing.T) {
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	// Create the volume group.
+	_, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil, ExtentSize(3<<20))
+	if err != ErrInvalidExtentSize {
+		t.Fatalf("Expected invalid extent size error, got %v", err)
+	}
+	if err == nil {
+		cleanup()
+	}
+}
+
 func TestCreateVolumeGroupInvalidName(t *testing.T) {
 	// Try to create the volume group with a bad name.
 	vg, err := CreateVolumeGroup("bad name :)", nil, nil)
@@ -407,6 +517,55 @@ func TestVolumeGroupBytesFree(t *testing.T) {
 	}
 }
 
+func TestVolumeGroupRoundUpSize(t *testing.T) {
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	extentSize, err := vg.ExtentSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		size uint64
+		exp  uint64
+	}{
+		{extentSize, extentSize},
+		{extentSize + 1, 2 * extentSize},
+		{2*extentSize - 1, 2 * extentSize},
+		{1, extentSize},
+	}
+	for i, tt := range cases {
+		size, gotExtentSize, err := vg.RoundUpSize(tt.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != tt.exp || gotExtentSize != extentSize {
+			t.Fatalf("test case %d: expected (%d, %d) but got (%d, %d)", i, tt.exp, extentSize, size, gotExtentSize)
+		}
+	}
+	// The logical volume has the rounded up size.
+	name := "test-lv-" + uuid.New().String()
+	lv, err := vg.CreateLogicalVolume(name, extentSize+1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check(lv.Remove)
+	lv, err = vg.LookupLogicalVolume(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lv.SizeInBytes() != 2*extentSize {
+		t.Fatalf("Expected size %d but got %d", 2*extentSize, lv.SizeInBytes())
+	}
+}
+
 func TestCreateLogicalVolume(t *testing.T) {
 	loop, err := CreateLoopDevice(pvsize)
 	if err != nil {
@@ -430,6 +589,81 @@ func TestCreateLogicalVolume(t *testing.T) {
 	defer check(lv.Remove)
 }
 
+func TestCreateLogicalVolume_ActivationSkip(t *testing.T) {
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	name := "test-lv-" + uuid.New().String()
+	lv, err := vg.CreateLogicalVolume(name, 4<<20, nil, ActivationSkipOpt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check(lv.Remove)
+	path, err := lv.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The logical volume is active after creation.
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	// Once deactivated, activating the volume group skips it.
+	if err := run(context.Background(), "lvchange", nil, "--activate=n", vg.name+"/"+name); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(context.Background(), "vgchange", nil, "--activate=y", vg.name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected %v to be inactive, err=%v", path, err)
+	}
+	if err := lv.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForDevice(t *testing.T) {
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	name := "test-lv-" + uuid.New().String()
+	lv, err := vg.CreateLogicalVolume(name, 4<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check(lv.Remove)
+	path, err := lv.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WaitForDevice(path, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := WaitForDevice(path+"-does-not-exist", time.Second); err == nil {
+		t.Fatal("Expected WaitForDevice to time out")
+	}
+}
+
 func TestCreateLogicalVolume_Tagged(t *testing.T) {
 	loop, err := CreateLoopDevice(pvsize)
 	if err != nil {
@@ -461,6 +695,57 @@ func TestCreateLogicalVolume_Tagged(t *testing.T) {
 	}
 }
 
+func TestLogicalVolume_CachedTags(t *testing.T) {
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	name := "test-lv-" + uuid.New().String()
+	// Empty and duplicate tags are skipped.
+	lv, err := vg.CreateLogicalVolume(name, 4<<20, []string{"a", "", "b", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check(lv.Remove)
+	tags, err := lv.CachedTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"a", "b"}; !reflect.DeepEqual(tags, exp) {
+		t.Fatalf("Expected cached tags %v but got %v", exp, tags)
+	}
+	if err := lv.ChangeTags([]string{"c"}, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err = lv.CachedTags(); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"b", "c"}; !reflect.DeepEqual(tags, exp) {
+		t.Fatalf("Expected cached tags %v but got %v", exp, tags)
+	}
+	// A looked up logical volume caches the tags reported by lvs.
+	found, err := vg.LookupLogicalVolume(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := found.CachedTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags, err = lv.Tags(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cached, tags) {
+		t.Fatalf("Expected cached tags %v but got %v", tags, cached)
+	}
+}
+
 func TestCreateLogicalVolume_BadTag(t *testing.T) {
 	loop, err := CreateLoopDevice(pvsize)
 	if err != nil {
@@ -681,6 +966,67 @@ func TestCreateLogicalVolume_VolumeLayout_RAID1(t *testing.T) {
 	}
 }
 
+func TestCreateLogicalVolume_CachePool(t *testing.T) {
+	loop1, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop1.Close()
+	loop2, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop2.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop1, loop2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	fast, err := LookupPhysicalVolume(loop2.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fast.AddTag("fast"); err != nil {
+		t.Fatal(err)
+	}
+	name := "test-lv-" + uuid.New().String()
+	lv, err := vg.CreateLogicalVolume(name, pvsize/2, nil, PhysicalVolumesOpt(loop1.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check(lv.Remove)
+	pool, err := vg.CreateCachePool(name+"-cache", pvsize/4, []string{"@fast"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.AttachCachePool(pool, "smq"); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := lv.IsCached(); err != nil || !cached {
+		t.Fatalf("Expected the volume to be cached (err=%v)", err)
+	}
+	// The cache pool is hidden once attached.
+	names, err := vg.ListLogicalVolumeNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{name}) {
+		t.Fatalf("Expected logical volumes %v but got %v", []string{name}, names)
+	}
+	if err := lv.DetachCachePool(); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := lv.IsCached(); err != nil || cached {
+		t.Fatalf("Expected the volume not to be cached (err=%v)", err)
+	}
+	if err := pool.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.AttachCachePool(pool, "bogus"); err == nil {
+		t.Fatal("Expected an invalid cache policy to be rejected")
+	}
+}
+
 func TestCreateLogicalVolume_VolumeLayout_RAID1_Mirrors2(t *testing.T) {
 	loop1, err := CreateLoopDevice(pvsize)
 	if err != nil {
@@ -728,6 +1074,58 @@ func TestCreateLogicalVolume_VolumeLayout_RAID1_Mirrors2(t *testing.T) {
 	}
 }
 
+func TestCheckRAID(t *testing.T) {
+	loop1, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop1.Close()
+	loop2, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop2.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop1, loop2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	name := "test-lv-" + uuid.New().String()
+	lv, err := vg.CreateLogicalVolume(name, pvsize/4, nil, VolumeLayoutOpt(VolumeLayout{Type: VolumeTypeRAID1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check(lv.Remove)
+	linear, err := vg.CreateLogicalVolume("test-lv-"+uuid.New().String(), pvsize/4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check(linear.Remove)
+	// The images are synchronized in the background after creation.
+	for i := 0; ; i++ {
+		reports, err := vg.ReportRAIDLogicalVolumes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 1 || reports[0].Name != name {
+			t.Fatalf("Expected only %v to be reported but got %+v", name, reports)
+		}
+		if !reports[0].Syncing() {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Expected %v to finish synchronizing", name)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := lv.CheckRAID(); err != nil {
+		t.Fatal(err)
+	}
+	if err := linear.CheckRAID(); err == nil {
+		t.Fatal("Expected checking a linear volume to fail")
+	}
+}
+
 func TestCreateLogicalVolume_VolumeLayout_RAID1_NotEnoughSpace(t *testing.T) {
 	loop1, err := CreateLoopDevice(pvsize)
 	if err != nil {
@@ -985,6 +1383,69 @@ func TestVolumeGroupListLogicalVolumeNames(t *testing.T) {
 	}
 }
 
+func TestVolumeGroupReportLogicalVolumes(t *testing.T) {
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	name1 := "test-lv-" + uuid.New().String()
+	lv1, err := vg.CreateLogicalVolume(name1, 8<<20, []string{"tag1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check(lv1.Remove)
+	name2 := "test-lv-" + uuid.New().String()
+	lv2, err := vg.CreateLogicalVolume(name2, 4<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check(lv2.Remove)
+	reports, err := vg.ReportLogicalVolumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("Expected 2 logical volumes but got %d.", len(reports))
+	}
+	for _, lv := range []*LogicalVolume{lv1, lv2} {
+		var report *LogicalVolumeReport
+		for i := range reports {
+			if reports[i].Name == lv.Name() {
+				report = &reports[i]
+			}
+		}
+		if report == nil {
+			t.Fatalf("Expected to find logical volume %v but did not.", lv.Name())
+		}
+		if report.SizeInBytes != lv.SizeInBytes() {
+			t.Fatalf("Expected size %v but got %v.", lv.SizeInBytes(), report.SizeInBytes)
+		}
+		path, err := lv.Path()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Path != path {
+			t.Fatalf("Expected path %v but got %v.", path, report.Path)
+		}
+		tags, err := lv.Tags()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(report.Tags, tags) {
+			t.Fatalf("Expected tags %v but got %v.", tags, report.Tags)
+		}
+		if report.HealthStatus != "" {
+			t.Fatalf("Expected a healthy logical volume but got %q.", report.HealthStatus)
+		}
+	}
+}
+
 func TestVolumeGroupListPhysicalVolumeNames(t *testing.T) {
 	loop1, err := CreateLoopDevice(pvsize)
 	if err != nil {
@@ -1013,7 +1474,71 @@ func TestVolumeGroupListPhysicalVolumeNames(t *testing.T) {
 	}
 }
 
-func createVolumeGroup(loopdevs []*LoopDevice, tags []string) (*VolumeGroup, func(), error) {
+func TestMoveLogicalVolume(t *testing.T) {
+	var loops []*LoopDevice
+	for i := 0; i < 3; i++ {
+		loop, err := CreateLoopDevice(pvsize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer loop.Close()
+		loops = append(loops, loop)
+	}
+	src, cleanup, err := createVolumeGroup(loops[:2], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	dst, cleanup2, err := createVolumeGroup(loops[2:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup2()
+	name := "test-lv-" + uuid.New().String()
+	lv, err := src.CreateLogicalVolume(name, 20<<20, []string{"some-tag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pvnames, err := lv.PhysicalVolumeNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pvnames) != 1 {
+		t.Fatalf("Expected the logical volume to be on one physical volume but got %v.", pvnames)
+	}
+	if err := MoveLogicalVolume(src, dst, name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.LookupLogicalVolume(name); err != ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected the logical volume to be gone from the source volume group but got err=%v.", err)
+	}
+	moved, err := dst.LookupLogicalVolume(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := moved.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"some-tag"}) {
+		t.Fatalf("Expected the tags to be moved but got %v.", tags)
+	}
+	dstpvs, err := dst.ListPhysicalVolumeNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(dstpvs)
+	exp := []string{pvnames[0], loops[2].Path()}
+	sort.Strings(exp)
+	if !reflect.DeepEqual(dstpvs, exp) {
+		t.Fatalf("Expected pvs %v but got %v.", exp, dstpvs)
+	}
+	if err := MoveLogicalVolume(src, dst, name); err != ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected ErrLogicalVolumeNotFound but got err=%v.", err)
+	}
+}
+
+func createVolumeGroup(loopdevs []*LoopDevice, tags []string, opts ...VolumeGroupOpt) (*VolumeGroup, func(), error) {
 	var err error
 	var cleanup cleanup.Steps
 	defer func() {
@@ -1037,10 +1562,45 @@ func createVolumeGroup(loopdevs []*LoopDevice, tags []string) (*VolumeGroup, fun
 	}
 	// Create a volume group containing the physical volume.
 	vgname := "test-vg-" + uuid.New().String()
-	vg, err := CreateVolumeGroup(vgname, pvs, tags)
+	vg, err := CreateVolumeGroup(vgname, pvs, tags, opts...)
 	if err != nil {
 		return nil, nil, err
 	}
 	cleanup.Add(vg.Remove)
 	return vg, cleanup.Unwind, nil
 }
+
+func TestVolumeGroupWithContext(t *testing.T) {
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	name := "test-lv-" + uuid.New().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	lv, err := vg.WithContext(ctx).CreateLogicalVolume(name, 4<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	// The logical volume inherits the volume group's context.
+	if err := lv.Remove(); err != context.Canceled {
+		t.Fatalf("Expected %v but got %v", context.Canceled, err)
+	}
+	if _, err := vg.WithContext(ctx).LookupLogicalVolume(name); err != context.Canceled {
+		t.Fatalf("Expected %v but got %v", context.Canceled, err)
+	}
+	// The original volume group is not affected.
+	lv, err = vg.LookupLogicalVolume(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.Remove(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,82 @@
+package lvm
+
+import (
+	"time"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	resultTypeSuccess = "success"
+	resultTypeError   = "error"
+)
+
+// latencyBuckets are the buckets of the LVM command latency histograms,
+// from 10ms to about 5 minutes.
+var latencyBuckets = tally.MustMakeExponentialDurationBuckets(10*time.Millisecond, 2, 16)
+
+// metrics is the scope to which the package reports metrics. It can be
+// set by calling SetMetrics and defaults to reporting no metrics.
+var metrics tally.Scope = tally.NoopScope
+
+// SetMetrics configures the package to report the latency and result of
+// each LVM command, the time spent waiting for the lvm lock and the number
+// of ErrNoSpace and ErrTooFewDisks errors to the lvm sub-scope of scope.
+func SetMetrics(scope tally.Scope) {
+	metrics = scope.SubScope("lvm")
+}
+
+// recordCommand reports that the named LVM command, e.g., lvcreate, ran for
+// d and failed if err is not nil.
+func recordCommand(cmd string, d time.Duration, err error) {
+	scope := metrics.Tagged(map[string]string{"command": cmd})
+	scope.SubScope("commands").Histogram("latency", latencyBuckets).RecordDuration(d)
+	resultType := resultTypeSuccess
+	if err != nil {
+		resultType = resultTypeError
+	}
+	scope.Tagged(map[string]string{"result_type": resultType}).Counter("commands").Inc(1)
+}
+
+// recordReportCacheHit counts a report command, e.g., lvs, whose cached
+// output was used instead of running it.
+func recordReportCacheHit(cmd string) {
+	metrics.Tagged(map[string]string{"command": cmd}).Counter("report_cache_hits").Inc(1)
+}
+
+// recordScanCoalesced counts a scan command, e.g., pvscan, that was not run
+// as a concurrent scan of the same target was waited for instead.
+func recordScanCoalesced(cmd string) {
+	metrics.Tagged(map[string]string{"command": cmd}).Counter("scans_coalesced").Inc(1)
+}
+
+// recordScanSkipped counts a scan command that was not run as the same
+// target was scanned within the minimum scan interval.
+func recordScanSkipped(cmd string) {
+	metrics.Tagged(map[string]string{"command": cmd}).Counter("scans_skipped").Inc(1)
+}
+
+// recordLockContention counts a retry of the LVM command, e.g., lvcreate,
+// that failed as a lock was held by another process.
+func recordLockContention(cmd string) {
+	metrics.Tagged(map[string]string{"command": cmd}).Counter("lock_contention_retries").Inc(1)
+}
+
+// recordLockWait reports the time spent waiting for the lvm lock.
+func recordLockWait(d time.Duration) {
+	metrics.SubScope("lock").Histogram("wait", latencyBuckets).RecordDuration(d)
+}
+
+// recordError counts an error returned by this package, e.g., ErrNoSpace.
+func recordError(err error) {
+	var name string
+	switch err {
+	case ErrNoSpace:
+		name = "no_space"
+	case ErrTooFewDisks:
+		name = "too_few_disks"
+	default:
+		return
+	}
+	metrics.Tagged(map[string]string{"error": name}).Counter("errors").Inc(1)
+}
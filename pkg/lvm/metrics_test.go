@@ -0,0 +1,52 @@
+package lvm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/uber-go/tally"
+)
+
+func TestMetrics(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	defer func(m tally.Scope) { metrics = m }(metrics)
+	SetMetrics(scope)
+	recordCommand("lvcreate", 20*time.Millisecond, nil)
+	recordCommand("lvcreate", time.Second, errors.New("failed"))
+	recordLockWait(time.Millisecond)
+	recordError(ErrNoSpace)
+	recordError(ErrNoSpace)
+	recordError(ErrTooFewDisks)
+	recordError(errors.New("other"))
+	snap := scope.Snapshot()
+	counters := map[string]int64{
+		"lvm.commands+command=lvcreate,result_type=success": 1,
+		"lvm.commands+command=lvcreate,result_type=error":   1,
+		"lvm.errors+error=no_space":                         2,
+		"lvm.errors+error=too_few_disks":                    1,
+	}
+	for name, expected := range counters {
+		c, ok := snap.Counters()[name]
+		if !ok || c.Value() != expected {
+			t.Fatalf("expected counter %v to be %d: %v", name, expected, snap.Counters())
+		}
+	}
+	if len(snap.Counters()) != len(counters) {
+		t.Fatalf("expected %d counters instead of %v", len(counters), snap.Counters())
+	}
+	h, ok := snap.Histograms()["lvm.commands.latency+command=lvcreate"]
+	if !ok {
+		t.Fatalf("expected the command latency histogram: %v", snap.Histograms())
+	}
+	var samples int64
+	for _, n := range h.Durations() {
+		samples += n
+	}
+	if samples != 2 {
+		t.Fatalf("expected 2 samples instead of %v", h.Durations())
+	}
+	if _, ok := snap.Histograms()["lvm.lock.wait+"]; !ok {
+		t.Fatalf("expected the lock wait histogram: %v", snap.Histograms())
+	}
+}
@@ -0,0 +1,159 @@
+package lvm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrLogicalVolumeExists is returned by MoveLogicalVolume if the target
+// volume group already has a logical volume of the same name.
+const ErrLogicalVolumeExists = simpleError("lvm: logical volume already exists")
+
+// ErrLogicalVolumeOpen is returned by MoveLogicalVolume if the logical
+// volume is open, e.g., mounted.
+const ErrLogicalVolumeOpen = simpleError("lvm: logical volume is open")
+
+// moveVolumeGroupPrefix prefixes the name of the temporary volume group to
+// which MoveLogicalVolume splits off the physical volumes of the logical
+// volume before merging them into the target volume group.
+const moveVolumeGroupPrefix = "move_"
+
+// MoveVolumeGroupName returns the name of the temporary volume group that
+// MoveLogicalVolume creates while moving the logical volume with the given
+// name. If MoveLogicalVolume is interrupted the volume group is left behind
+// and can be merged into the target with `vgmerge`.
+func MoveVolumeGroupName(name string) string {
+	return moveVolumeGroupPrefix + name
+}
+
+// IsOpen returns whether the logical volume's device is open, e.g., because
+// it is mounted or in use by a process.
+func (lv *LogicalVolume) IsOpen() (bool, error) {
+	result := new(lvsOutput)
+	if err := run(lv.vg.context(), "lvs", result, "--options=lv_device_open", lv.vg.name+"/"+lv.name); err != nil {
+		if IsLogicalVolumeNotFound(err) {
+			return false, ErrLogicalVolumeNotFound
+		}
+		return false, err
+	}
+	for _, report := range result.Report {
+		for _, lv := range report.Lv {
+			return lv.LvDeviceOpen == "open", nil
+		}
+	}
+	return false, ErrLogicalVolumeNotFound
+}
+
+// PhysicalVolumeNames returns the sorted names of the physical volumes on
+// which the logical volume has extents.
+func (lv *LogicalVolume) PhysicalVolumeNames() ([]string, error) {
+	result := new(lvsOutput)
+	if err := run(lv.vg.context(), "lvs", result, "--options=devices", lv.vg.name+"/"+lv.name); err != nil {
+		if IsLogicalVolumeNotFound(err) {
+			return nil, ErrLogicalVolumeNotFound
+		}
+		return nil, err
+	}
+	var devices []string
+	for _, report := range result.Report {
+		for _, lv := range report.Lv {
+			devices = append(devices, lv.Devices)
+		}
+	}
+	return parseDevices(strings.Join(devices, ",")), nil
+}
+
+// parseDevices returns the sorted, unique device names in the devices field
+// reported by `lvs`, e.g., "/dev/sdb(0),/dev/sdc(100)". Sub-volumes such as
+// the images of a raid1 volume, e.g., "lv_rimage_0(0)", are not physical
+// volumes and are skipped.
+func parseDevices(s string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, dev := range strings.Split(s, ",") {
+		dev = strings.TrimSpace(dev)
+		if i := strings.LastIndex(dev, "("); i >= 0 {
+			dev = dev[:i]
+		}
+		if !strings.HasPrefix(dev, "/") || seen[dev] {
+			continue
+		}
+		seen[dev] = true
+		names = append(names, dev)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Deactivate deactivates the logical volume. It is a no-op if the logical
+// volume is not active.
+func (lv *LogicalVolume) Deactivate() error {
+	return run(lv.vg.context(), "lvchange", nil, "--activate=n", lv.vg.name+"/"+lv.name)
+}
+
+// MoveLogicalVolume moves the logical volume with the given name from the
+// volume group src to the volume group dst along with the physical volumes
+// on which it has extents, so that its data is not copied and its name is
+// unchanged. The physical volumes must not have extents of any other logical
+// volume and src must keep at least one physical volume. The volume groups
+// must have the same extent size. The logical volume must not be open; it is
+// deactivated while it is moved and activated in dst afterwards.
+//
+// The physical volumes are first split off into a temporary volume group
+// named by MoveVolumeGroupName, which is then merged into dst. If the merge
+// fails the physical volumes are merged back into src.
+func MoveLogicalVolume(src, dst *VolumeGroup, name string) error {
+	lv, err := src.LookupLogicalVolume(name)
+	if err != nil {
+		return err
+	}
+	if _, err := dst.LookupLogicalVolume(name); err == nil {
+		return ErrLogicalVolumeExists
+	} else if err != ErrLogicalVolumeNotFound {
+		return err
+	}
+	srcExtentSize, err := src.ExtentSize()
+	if err != nil {
+		return err
+	}
+	dstExtentSize, err := dst.ExtentSize()
+	if err != nil {
+		return err
+	}
+	if srcExtentSize != dstExtentSize {
+		return fmt.Errorf("lvm: cannot move logical volume %v as the extent size %d of volume group %v differs from the extent size %d of volume group %v", name, srcExtentSize, src.name, dstExtentSize, dst.name)
+	}
+	open, err := lv.IsOpen()
+	if err != nil {
+		return err
+	}
+	if open {
+		return ErrLogicalVolumeOpen
+	}
+	log.Printf("Deactivating logical volume %v/%v to move it to volume group %v", src.name, name, dst.name)
+	if err := lv.Deactivate(); err != nil {
+		return err
+	}
+	tmp := MoveVolumeGroupName(name)
+	log.Printf("Splitting the physical volumes of logical volume %v/%v off into volume group %v", src.name, name, tmp)
+	if err := run(src.context(), "vgsplit", nil, "--name="+name, src.name, tmp); err != nil {
+		if aerr := lv.Activate(); aerr != nil {
+			log.Printf("Failed to reactivate logical volume %v/%v: err=%v", src.name, name, aerr)
+		}
+		return err
+	}
+	log.Printf("Merging volume group %v into volume group %v", tmp, dst.name)
+	if err := run(dst.context(), "vgmerge", nil, dst.name, tmp); err != nil {
+		log.Printf("Failed to merge volume group %v into volume group %v, merging it back into %v: err=%v", tmp, dst.name, src.name, err)
+		if merr := run(src.context(), "vgmerge", nil, src.name, tmp); merr != nil {
+			log.Printf("Failed to merge volume group %v back into volume group %v: err=%v", tmp, src.name, merr)
+		} else if aerr := lv.Activate(); aerr != nil {
+			log.Printf("Failed to reactivate logical volume %v/%v: err=%v", src.name, name, aerr)
+		}
+		return err
+	}
+	moved := &LogicalVolume{name: name, sizeInBytes: lv.sizeInBytes, vg: dst}
+	log.Printf("Activating logical volume %v/%v", dst.name, name)
+	return moved.Activate()
+}
@@ -0,0 +1,25 @@
+package lvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDevices(t *testing.T) {
+	cases := []struct {
+		devices string
+		exp     []string
+	}{
+		{"", nil},
+		{"/dev/sdb(0)", []string{"/dev/sdb"}},
+		{"/dev/sdc(100),/dev/sdb(0)", []string{"/dev/sdb", "/dev/sdc"}},
+		{"/dev/sdb(0),/dev/sdb(250)", []string{"/dev/sdb"}},
+		{"lv_rimage_0(0),lv_rimage_1(0)", nil},
+		{"/dev/mapper/mpatha(0)", []string{"/dev/mapper/mpatha"}},
+	}
+	for _, tt := range cases {
+		if got := parseDevices(tt.devices); !reflect.DeepEqual(got, tt.exp) {
+			t.Fatalf("expected %q to be parsed as %v but got %v", tt.devices, tt.exp, got)
+		}
+	}
+}
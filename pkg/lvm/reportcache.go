@@ -0,0 +1,108 @@
+package lvm
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// reportCommands are the LVM commands whose JSON output may be cached.
+// They only read LVM metadata.
+var reportCommands = map[string]bool{
+	"lvs": true,
+	"vgs": true,
+	"pvs": true,
+}
+
+// reports caches the output of the report commands. It is disabled unless
+// SetReportCacheTTL has been called.
+var reports = &reportCache{now: time.Now}
+
+// SetReportCacheTTL causes the output of the lvs, vgs and pvs commands
+// invoked by this package to be cached for ttl so that bursts of requests
+// do not each re-read the LVM metadata. Running any other LVM command
+// through this package invalidates the cache. Changes made by other
+// processes, e.g., an operator running lvremove, go unnoticed for at most
+// ttl. Calling SetReportCacheTTL with zero disables the cache.
+//
+// Like SetLockFilePath, this is intended to be called once at startup
+// before any LVM commands are run.
+func SetReportCacheTTL(ttl time.Duration) {
+	log.Printf("using lvm report cache ttl %v", ttl)
+	reports.setTTL(ttl)
+}
+
+// reportCache maps the arguments of a report command to its output.
+type reportCache struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	// generation is incremented whenever the cache is invalidated so
+	// that the output of a report that ran concurrently with a
+	// command that changed the metadata is not stored.
+	generation uint64
+	entries    map[string]reportCacheEntry
+	now        func() time.Time
+}
+
+type reportCacheEntry struct {
+	stdout  []byte
+	expires time.Time
+}
+
+// reportCacheKey returns the key of the report command with the given
+// arguments.
+func reportCacheKey(cmd string, args []string) string {
+	return cmd + "\x00" + strings.Join(args, "\x00")
+}
+
+func (c *reportCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	c.entries = nil
+	c.generation++
+}
+
+// get returns the cached output for key, if any, along with the current
+// generation that must be passed to put.
+func (c *reportCache) get(key string) (stdout []byte, generation uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return nil, c.generation, false
+	}
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, c.generation, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, c.generation, false
+	}
+	return entry.stdout, c.generation, true
+}
+
+// put caches the output for key unless the cache was invalidated since
+// generation was returned by get.
+func (c *reportCache) put(key string, stdout []byte, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 || generation != c.generation {
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]reportCacheEntry)
+	}
+	c.entries[key] = reportCacheEntry{stdout, c.now().Add(c.ttl)}
+}
+
+// invalidate removes all cached output.
+func (c *reportCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	c.entries = nil
+	c.generation++
+}
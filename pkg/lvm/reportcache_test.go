@@ -0,0 +1,57 @@
+package lvm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReportCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := &reportCache{now: func() time.Time { return now }}
+	key := reportCacheKey("lvs", []string{"--reportformat=json", "vg"})
+	// The cache is disabled by default.
+	_, generation, _ := c.get(key)
+	c.put(key, []byte("{}"), generation)
+	if _, _, ok := c.get(key); ok {
+		t.Fatal("Expected the disabled cache to be empty")
+	}
+	c.setTTL(time.Second)
+	_, generation, ok := c.get(key)
+	if ok {
+		t.Fatal("Expected a cache miss")
+	}
+	c.put(key, []byte("{}"), generation)
+	if stdout, _, ok := c.get(key); !ok || string(stdout) != "{}" {
+		t.Fatalf("Expected a cache hit, got %q, %v", stdout, ok)
+	}
+	if _, _, ok := c.get(reportCacheKey("lvs", []string{"--reportformat=json", "vg2"})); ok {
+		t.Fatal("Expected a cache miss for other arguments")
+	}
+	// Entries expire after the ttl.
+	now = now.Add(time.Second)
+	if _, _, ok := c.get(key); ok {
+		t.Fatal("Expected the entry to expire")
+	}
+	// Invalidating the cache removes the entries.
+	_, generation, _ = c.get(key)
+	c.put(key, []byte("{}"), generation)
+	c.invalidate()
+	if _, _, ok := c.get(key); ok {
+		t.Fatal("Expected the entry to be invalidated")
+	}
+	// Output of a report that started before the cache was invalidated
+	// is not stored.
+	_, generation, _ = c.get(key)
+	c.invalidate()
+	c.put(key, []byte("{}"), generation)
+	if _, _, ok := c.get(key); ok {
+		t.Fatal("Expected the stale output not to be cached")
+	}
+	// Disabling the cache removes the entries.
+	_, generation, _ = c.get(key)
+	c.put(key, []byte("{}"), generation)
+	c.setTTL(0)
+	if _, _, ok := c.get(key); ok {
+		t.Fatal("Expected the disabled cache to be empty")
+	}
+}
@@ -0,0 +1,132 @@
+package lvm
+
+import (
+	"sync"
+	"time"
+)
+
+// scans schedules the pvscan and vgscan commands run by PVScan and VGScan.
+var scans = &scanScheduler{now: time.Now}
+
+// SetMinScanInterval causes PVScan and VGScan to skip a scan of the same
+// device or volume group that succeeded less than interval ago, unless the
+// Force option is given. Concurrent scans of the same device or volume
+// group are always coalesced into a single command. Calling
+// SetMinScanInterval with zero, the default, disables the interval.
+//
+// Like SetLockFilePath, this is intended to be called once at startup
+// before any LVM commands are run.
+func SetMinScanInterval(interval time.Duration) {
+	log.Printf("using lvm minimum scan interval %v", interval)
+	scans.setMinInterval(interval)
+}
+
+// ScanOpt configures a call to PVScan or VGScan.
+type ScanOpt func(opts *scanOpts)
+
+type scanOpts struct {
+	force bool
+}
+
+// Force causes the scan to run even if one completed within the minimum
+// scan interval. If a scan is already running, a forced scan waits for it
+// and runs again as the running scan may have missed changes made before
+// the forced scan was requested.
+func Force() ScanOpt {
+	return func(o *scanOpts) {
+		o.force = true
+	}
+}
+
+// scanScheduler coalesces concurrent scans and enforces the minimum
+// interval between them.
+type scanScheduler struct {
+	mu          sync.Mutex
+	minInterval time.Duration
+	// states maps the scan command and its target to the state of
+	// its scans.
+	states map[string]*scanState
+	now    func() time.Time
+}
+
+type scanState struct {
+	// running is the scan in progress, if any.
+	running *scanCall
+	// last is the last completed scan, if any.
+	last *scanCall
+	// succeeded is when the last successful scan completed.
+	succeeded time.Time
+}
+
+type scanCall struct {
+	started time.Time
+	// done is closed once err is set.
+	done chan struct{}
+	err  error
+}
+
+func (s *scanScheduler) setMinInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.minInterval = interval
+}
+
+// scan calls fn to run the scan command, e.g., pvscan, of the target unless
+// a scan of the target is running, in which case it waits for that scan and
+// returns its result, or unless the last scan of the target succeeded
+// within the minimum interval. Forced scans only share the result of a scan
+// that started after they were requested.
+func (s *scanScheduler) scan(cmd, target string, fn func() error, optFns ...ScanOpt) error {
+	opts := new(scanOpts)
+	for _, optFn := range optFns {
+		optFn(opts)
+	}
+	key := cmd + "\x00" + target
+	s.mu.Lock()
+	requested := s.now()
+	if s.states == nil {
+		s.states = make(map[string]*scanState)
+	}
+	st, ok := s.states[key]
+	if !ok {
+		st = &scanState{}
+		s.states[key] = st
+	}
+	for st.running != nil {
+		call := st.running
+		s.mu.Unlock()
+		<-call.done
+		if !opts.force || !call.started.Before(requested) {
+			recordScanCoalesced(cmd)
+			return call.err
+		}
+		s.mu.Lock()
+		if last := st.last; !last.started.Before(requested) {
+			// Another forced scan that started after this one
+			// was requested has already completed.
+			s.mu.Unlock()
+			recordScanCoalesced(cmd)
+			return last.err
+		}
+	}
+	if !opts.force && !st.succeeded.IsZero() && requested.Sub(st.succeeded) < s.minInterval {
+		s.mu.Unlock()
+		recordScanSkipped(cmd)
+		return nil
+	}
+	call := &scanCall{started: s.now(), done: make(chan struct{})}
+	st.running = call
+	s.mu.Unlock()
+
+	call.err = fn()
+
+	s.mu.Lock()
+	st.running = nil
+	st.last = call
+	if call.err == nil {
+		st.succeeded = s.now()
+	}
+	s.mu.Unlock()
+	close(call.done)
+	return call.err
+}
@@ -0,0 +1,105 @@
+package lvm
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScanSchedulerMinInterval(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := &scanScheduler{now: func() time.Time { return now }}
+	runs := 0
+	fn := func() error {
+		runs++
+		return nil
+	}
+	// Without a minimum interval every scan runs.
+	s.scan("pvscan", "", fn)
+	s.scan("pvscan", "", fn)
+	if runs != 2 {
+		t.Fatalf("Expected 2 scans, got %d", runs)
+	}
+	s.setMinInterval(time.Second)
+	if err := s.scan("pvscan", "", fn); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 {
+		t.Fatalf("Expected the scan to be skipped, got %d scans", runs)
+	}
+	// Other targets and commands are scheduled separately.
+	s.scan("pvscan", "/dev/loop0", fn)
+	s.scan("vgscan", "", fn)
+	if runs != 4 {
+		t.Fatalf("Expected 4 scans, got %d", runs)
+	}
+	// Forced scans always run.
+	s.scan("pvscan", "", fn, Force())
+	if runs != 5 {
+		t.Fatalf("Expected the forced scan to run, got %d scans", runs)
+	}
+	now = now.Add(time.Second)
+	s.scan("pvscan", "", fn)
+	if runs != 6 {
+		t.Fatalf("Expected the scan to run after the interval, got %d scans", runs)
+	}
+	// Failed scans are not skipped.
+	failed := errors.New("failed")
+	if err := s.scan("vgscan", "vg", func() error { return failed }); err != failed {
+		t.Fatalf("Expected %v, got %v", failed, err)
+	}
+	s.scan("vgscan", "vg", fn)
+	if runs != 7 {
+		t.Fatalf("Expected the scan after a failure to run, got %d scans", runs)
+	}
+}
+
+func TestScanSchedulerCoalesces(t *testing.T) {
+	s := &scanScheduler{now: time.Now}
+	var mu sync.Mutex
+	runs := 0
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func() error {
+		mu.Lock()
+		runs++
+		mu.Unlock()
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.scan("pvscan", "", fn)
+	}()
+	<-started
+	// Scans requested while the first one runs wait for it.
+	const waiters = 5
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.scan("pvscan", "", fn)
+		}()
+	}
+	// Forced scans wait for it too and then run once more.
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.scan("pvscan", "", fn, Force())
+		}()
+	}
+	// Give the goroutines time to start waiting.
+	time.Sleep(50 * time.Millisecond)
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	wg.Wait()
+	if runs != 2 {
+		t.Fatalf("Expected 2 scans, got %d", runs)
+	}
+}
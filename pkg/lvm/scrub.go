@@ -0,0 +1,77 @@
+package lvm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// RAIDReport describes the synchronization state of a raid logical volume
+// as reported by `lvs`.
+type RAIDReport struct {
+	Name string
+	Tags []string
+	// SyncAction is the current synchronization action, e.g., "idle",
+	// "check", "repair", "resync" or "recover".
+	SyncAction string
+	// MismatchCount is the number of regions whose images differed
+	// during the last check.
+	MismatchCount uint64
+}
+
+// Syncing returns whether the raid logical volume is being checked,
+// repaired or otherwise synchronized.
+func (r RAIDReport) Syncing() bool {
+	switch r.SyncAction {
+	case "", "idle", "frozen":
+		return false
+	}
+	return true
+}
+
+// ReportRAIDLogicalVolumes returns the synchronization state of the raid
+// logical volumes in this volume group, e.g., raid1 and striped raid1
+// volumes. The images and metadata subvolumes of raid volumes are not
+// reported.
+func (vg *VolumeGroup) ReportRAIDLogicalVolumes() ([]RAIDReport, error) {
+	var reports []RAIDReport
+	result := new(lvsOutput)
+	if err := run(vg.context(), "lvs", result, "--options=lv_name,vg_name,lv_tags,segtype,raid_sync_action,raid_mismatch_count", vg.name); err != nil {
+		return nil, err
+	}
+	for _, report := range result.Report {
+		for _, lv := range report.Lv {
+			if lv.VgName != vg.name || !strings.HasPrefix(lv.Segtype, "raid") {
+				continue
+			}
+			var mismatches uint64
+			if lv.RaidMismatchCount != "" {
+				n, err := strconv.ParseUint(lv.RaidMismatchCount, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				mismatches = n
+			}
+			reports = append(reports, RAIDReport{
+				Name:          lv.Name,
+				Tags:          lv.tagList(),
+				SyncAction:    lv.RaidSyncAction,
+				MismatchCount: mismatches,
+			})
+		}
+	}
+	return reports, nil
+}
+
+// CheckRAID starts a check of the raid logical volume that reads all of its
+// images and counts the regions in which they differ, without repairing
+// them. The check runs in the background; its progress is reported by
+// ReportRAIDLogicalVolumes and it cannot be stopped by the plugin.
+func (lv *LogicalVolume) CheckRAID() error {
+	if err := run(lv.vg.context(), "lvchange", nil, "--syncaction=check", lv.vg.name+"/"+lv.name); err != nil {
+		if IsLogicalVolumeNotFound(err) {
+			return ErrLogicalVolumeNotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package lvm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// Version returns the version of the LVM tools as reported by
+// `lvm version`, e.g., "2.03.11(2) (2021-01-08)". Unlike other commands it
+// does not take the lock as it does not read or change any metadata.
+func Version(ctx context.Context) (string, error) {
+	c := exec.CommandContext(ctx, "lvm", "version")
+	log.Printf("Executing: %v", c)
+	out, err := c.Output()
+	if err != nil {
+		return "", err
+	}
+	return parseVersion(out)
+}
+
+// parseVersion returns the version in the output of `lvm version`.
+func parseVersion(out []byte) (string, error) {
+	const prefix = "LVM version:"
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimSpace(strings.TrimPrefix(line, prefix)), nil
+		}
+	}
+	return "", errors.New("lvm: cannot find the LVM version in the output of `lvm version`")
+}
@@ -0,0 +1,22 @@
+package lvm
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	out := []byte(`  LVM version:     2.03.11(2) (2021-01-08)
+  Library version: 1.02.175 (2021-01-08)
+  Driver version:  4.43.0
+`)
+	version, err := parseVersion(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "2.03.11(2) (2021-01-08)"; version != exp {
+		t.Fatalf("Expected %q but got %q", exp, version)
+	}
+	if _, err := parseVersion([]byte("Driver version: 4.43.0\n")); err == nil {
+		t.Fatal("Expected an error without an LVM version")
+	}
+}
@@ -0,0 +1,63 @@
+package udev
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// NewMonitor returns a Monitor subscribed to udev events. The caller must
+// call Close when done with it.
+func NewMonitor() (*Monitor, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: udevGroup,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	return &Monitor{fd}, nil
+}
+
+// Close closes the underlying netlink socket.
+func (m *Monitor) Close() error {
+	return syscall.Close(m.fd)
+}
+
+// Receive returns the next udev event. It returns ErrTimeout if no event is
+// received within the given timeout.
+func (m *Monitor) Receive(timeout time.Duration) (Event, error) {
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, maxMessageSize)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, ErrTimeout
+		}
+		tv := syscall.NsecToTimeval(remaining.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(m.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return nil, os.NewSyscallError("setsockopt", err)
+		}
+		n, from, err := syscall.Recvfrom(m.fd, buf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		if _, ok := from.(*syscall.SockaddrNetlink); !ok {
+			continue
+		}
+		event, err := parseEvent(buf[:n])
+		if err != nil {
+			// Skip messages we do not understand.
+			continue
+		}
+		return event, nil
+	}
+}
@@ -0,0 +1,22 @@
+// +build !linux
+
+package udev
+
+import (
+	"time"
+)
+
+// NewMonitor returns ErrNotSupported as udev is only available on linux.
+func NewMonitor() (*Monitor, error) {
+	return nil, ErrNotSupported
+}
+
+// Close is a no-op.
+func (m *Monitor) Close() error {
+	return nil
+}
+
+// Receive returns ErrNotSupported.
+func (m *Monitor) Receive(timeout time.Duration) (Event, error) {
+	return nil, ErrNotSupported
+}
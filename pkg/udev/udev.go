@@ -0,0 +1,134 @@
+// Package udev listens for udev events on the netlink socket used by
+// libudev. It allows waiting for the events of a specific device rather than
+// for the entire udev event queue to drain, as `udevadm settle` does.
+package udev
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+const (
+	// udevGroup is the netlink multicast group to which udevd
+	// broadcasts events once it has processed them, i.e., after device
+	// nodes and symlinks have been created.
+	udevGroup = 2
+	// udevMagic identifies the libudev netlink message format.
+	udevMagic = 0xfeedcafe
+	// maxMessageSize is large enough for any uevent.
+	maxMessageSize = 64 << 10
+)
+
+// nativeEndian is the byte order of the libudev header fields other than
+// the magic number.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// ErrTimeout is returned by Receive and WaitFor if no matching event is
+// received in time.
+var ErrTimeout = errors.New("udev: timed out waiting for event")
+
+// ErrNotSupported is returned by NewMonitor on platforms without udev.
+var ErrNotSupported = errors.New("udev: only supported on linux")
+
+// Event is a udev event. It maps property names, e.g., "ACTION", "DEVNAME",
+// "DEVLINKS" or "DM_NAME", to their values.
+type Event map[string]string
+
+// Action returns the event's action, e.g., "add" or "change".
+func (e Event) Action() string {
+	return e["ACTION"]
+}
+
+// HasDevice returns whether the event concerns the device with the given
+// path. The path may be the device node, e.g., /dev/dm-3, or any of its
+// symlinks, e.g., /dev/vg0/lv0.
+func (e Event) HasDevice(path string) bool {
+	if e["DEVNAME"] == path {
+		return true
+	}
+	for _, link := range strings.Fields(e["DEVLINKS"]) {
+		if link == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Monitor receives udev events. To avoid missing events, a Monitor should be
+// created before the operation that triggers them is performed.
+type Monitor struct {
+	fd int
+}
+
+// WaitFor waits until an event for which match returns true is received.
+// It returns ErrTimeout if no such event is received within the timeout.
+func (m *Monitor) WaitFor(timeout time.Duration, match func(Event) bool) (Event, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		event, err := m.Receive(time.Until(deadline))
+		if err != nil {
+			return nil, err
+		}
+		if match(event) {
+			return event, nil
+		}
+	}
+}
+
+// parseEvent parses a netlink uevent message. Both the libudev format sent
+// by udevd and the kernel format are supported.
+func parseEvent(msg []byte) (Event, error) {
+	var properties []byte
+	if bytes.HasPrefix(msg, []byte("libudev\x00")) {
+		// struct udev_monitor_netlink_header {
+		//   char prefix[8];
+		//   unsigned int magic;            // network byte order
+		//   unsigned int header_size;
+		//   unsigned int properties_off;
+		//   unsigned int properties_len;
+		//   ...
+		// };
+		if len(msg) < 24 {
+			return nil, fmt.Errorf("udev: message too short: %d bytes", len(msg))
+		}
+		if magic := binary.BigEndian.Uint32(msg[8:12]); magic != udevMagic {
+			return nil, fmt.Errorf("udev: unexpected magic %#x", magic)
+		}
+		off := int(nativeEndian.Uint32(msg[16:20]))
+		length := int(nativeEndian.Uint32(msg[20:24]))
+		if off < 24 || off+length > len(msg) {
+			return nil, errors.New("udev: invalid properties offset or length")
+		}
+		properties = msg[off : off+length]
+	} else {
+		// The kernel format starts with "ACTION@DEVPATH".
+		i := bytes.IndexByte(msg, 0)
+		if i < 0 || bytes.IndexByte(msg[:i], '@') < 0 {
+			return nil, errors.New("udev: unrecognized message")
+		}
+		properties = msg[i+1:]
+	}
+	event := make(Event)
+	for _, kv := range bytes.Split(properties, []byte{0}) {
+		parts := bytes.SplitN(kv, []byte{'='}, 2)
+		if len(parts) != 2 {
+			continue
+		}
+		event[string(parts[0])] = string(parts[1])
+	}
+	if event.Action() == "" {
+		return nil, errors.New("udev: event has no ACTION")
+	}
+	return event, nil
+}
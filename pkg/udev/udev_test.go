@@ -0,0 +1,68 @@
+package udev
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func libudevMessage(properties string) []byte {
+	const headerSize = 40
+	header := make([]byte, headerSize)
+	copy(header, "libudev\x00")
+	binary.BigEndian.PutUint32(header[8:12], udevMagic)
+	nativeEndian.PutUint32(header[12:16], headerSize)
+	nativeEndian.PutUint32(header[16:20], headerSize)
+	nativeEndian.PutUint32(header[20:24], uint32(len(properties)))
+	return append(header, properties...)
+}
+
+func TestParseEventLibudev(t *testing.T) {
+	msg := libudevMessage("ACTION=change\x00DEVNAME=/dev/dm-3\x00DEVLINKS=/dev/mapper/vg0-lv0 /dev/vg0/lv0\x00DM_NAME=vg0-lv0\x00")
+	event, err := parseEvent(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Action() != "change" {
+		t.Fatalf("unexpected action %q", event.Action())
+	}
+	for _, path := range []string{"/dev/dm-3", "/dev/vg0/lv0", "/dev/mapper/vg0-lv0"} {
+		if !event.HasDevice(path) {
+			t.Fatalf("expected event to concern %v: %v", path, event)
+		}
+	}
+	if event.HasDevice("/dev/vg0/lv1") {
+		t.Fatalf("unexpected match for /dev/vg0/lv1: %v", event)
+	}
+}
+
+func TestParseEventKernel(t *testing.T) {
+	msg := []byte("add@/devices/virtual/block/dm-3\x00ACTION=add\x00DEVNAME=dm-3\x00SUBSYSTEM=block\x00")
+	event, err := parseEvent(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Action() != "add" || event["SUBSYSTEM"] != "block" {
+		t.Fatalf("unexpected event %v", event)
+	}
+}
+
+func TestParseEventInvalid(t *testing.T) {
+	badMagic := libudevMessage("ACTION=add\x00")
+	binary.BigEndian.PutUint32(badMagic[8:12], 0xdeadbeef)
+	badOffset := libudevMessage("ACTION=add\x00")
+	nativeEndian.PutUint32(badOffset[20:24], 1000)
+	for _, msg := range [][]byte{
+		nil,
+		[]byte("libudev\x00"),
+		badMagic,
+		badOffset,
+		[]byte("garbage"),
+		libudevMessage("DEVNAME=/dev/dm-3\x00"),
+		bytes.Repeat([]byte{0}, 64),
+	} {
+		if _, err := parseEvent(msg); err == nil {
+			t.Fatalf("expected an error for %q", msg)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package wipe
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// oDirect is the open(2) flag that bypasses the page cache.
+const oDirect = syscall.O_DIRECT
+
+// logicalBlockSize returns the logical block size of the block device,
+// which is the alignment O_DIRECT requires.
+func logicalBlockSize(f *os.File) (uint64, error) {
+	var size int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blksszget, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0, os.NewSyscallError("ioctl", errno)
+	}
+	return uint64(size), nil
+}
+
+// isUnsupportedErrno returns whether errno indicates that the ioctl is not
+// supported by the device, rather than that it failed.
+func isUnsupportedErrno(errno syscall.Errno) bool {
+	return errno == syscall.ENOTTY || errno == syscall.EOPNOTSUPP || errno == syscall.EINVAL
+}
+
+// rangeIoctl issues an ioctl that takes a [start, length] byte range.
+func rangeIoctl(f *os.File, name string, req uintptr, offset, length uint64) error {
+	r := [2]uint64{offset, length}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(&r)))
+	if errno != 0 {
+		if isUnsupportedErrno(errno) {
+			return &unsupportedError{name, errno}
+		}
+		return os.NewSyscallError("ioctl", errno)
+	}
+	return nil
+}
+
+// checkDiscardZeroes returns an unsupportedError unless the device reports
+// that discarded blocks read back as zeros.
+func checkDiscardZeroes(f *os.File, name string) error {
+	var zeroes uint32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkdiscardz, uintptr(unsafe.Pointer(&zeroes)))
+	if errno != 0 {
+		if isUnsupportedErrno(errno) {
+			return &unsupportedError{name, errno}
+		}
+		return os.NewSyscallError("ioctl", errno)
+	}
+	if zeroes == 0 {
+		return &unsupportedError{name, fmt.Errorf("discarded blocks are not guaranteed to read as zeros")}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+// +build !linux
+
+package wipe
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoIoctls is the reason the ioctl methods are not supported on this
+// platform. Device then falls back to Copy.
+var errNoIoctls = errors.New("block device ioctls are only available on linux")
+
+// oDirect is zero as O_DIRECT is not used on this platform.
+const oDirect = 0
+
+func logicalBlockSize(f *os.File) (uint64, error) {
+	return 0, errNoIoctls
+}
+
+func rangeIoctl(f *os.File, name string, req uintptr, offset, length uint64) error {
+	return &unsupportedError{name, errNoIoctls}
+}
+
+func checkDiscardZeroes(f *os.File, name string) error {
+	return &unsupportedError{name, errNoIoctls}
+}
@@ -0,0 +1,270 @@
+// Package wipe overwrites the contents of block devices with zeros. It
+// supports several methods that differ in speed and in what the underlying
+// storage must support, and reports progress while wiping.
+package wipe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unsafe"
+)
+
+// DefaultBlockSize is the number of bytes wiped at a time, between checks
+// for cancellation and progress reports, unless another block size is given
+// to Device.
+const DefaultBlockSize = 64 << 20
+
+// sectorSize is the alignment the block device ioctls require of offsets
+// and lengths.
+const sectorSize = 512
+
+// ValidateBlockSize returns an error unless size is a non-zero multiple of
+// the 512 byte sector size.
+func ValidateBlockSize(size uint64) error {
+	if size == 0 || size%sectorSize != 0 {
+		return fmt.Errorf("wipe: block size %d is not a non-zero multiple of %d", size, sectorSize)
+	}
+	return nil
+}
+
+// Block device ioctls from <linux/fs.h>.
+const (
+	blkdiscard  = 0x1277 // _IO(0x12,119)
+	blkzeroout  = 0x127f // _IO(0x12,127)
+	blkdiscardz = 0x127c // _IO(0x12,124), BLKDISCARDZEROES
+	blksszget   = 0x1268 // _IO(0x12,104)
+)
+
+// Progress is called after each chunk with the number of bytes wiped so far
+// and the total number of bytes to wipe.
+type Progress func(wiped, total uint64)
+
+// Method is a way of wiping a device.
+type Method interface {
+	// Name identifies the method, e.g., "zeroout".
+	Name() string
+	// wipe zeros length bytes of f starting at offset.
+	wipe(f *os.File, offset, length uint64) error
+}
+
+// unsupportedError is returned by a Method that the device does not
+// support. Device then falls back to the next method.
+type unsupportedError struct {
+	method string
+	err    error
+}
+
+func (e *unsupportedError) Error() string {
+	return fmt.Sprintf("wipe: %s is not supported by the device: %v", e.method, e.err)
+}
+
+// Zeroout wipes the device with the BLKZEROOUT ioctl. The kernel offloads
+// the writes to the device if it supports WRITE ZEROES or WRITE SAME, and
+// otherwise writes zeros without copying them from userspace.
+var Zeroout Method = zeroout{}
+
+type zeroout struct{}
+
+func (zeroout) Name() string { return "zeroout" }
+
+func (z zeroout) wipe(f *os.File, offset, length uint64) error {
+	return rangeIoctl(f, z.Name(), blkzeroout, offset, length)
+}
+
+// Discard wipes the device with the BLKDISCARD ioctl. It is only used if
+// the device reports that discarded blocks read back as zeros.
+var Discard Method = discard{}
+
+type discard struct{}
+
+func (discard) Name() string { return "discard" }
+
+func (d discard) wipe(f *os.File, offset, length uint64) error {
+	if offset == 0 {
+		if err := checkDiscardZeroes(f, d.Name()); err != nil {
+			return err
+		}
+	}
+	return rangeIoctl(f, d.Name(), blkdiscard, offset, length)
+}
+
+// Copy wipes the device by writing zeros from userspace. It works for any
+// device but is the slowest method.
+var Copy Method = copyZeros{}
+
+type copyZeros struct{}
+
+// directIOAlignment is the alignment of the buffer written by Copy, which
+// O_DIRECT requires to be a multiple of the logical block size. It satisfies
+// both 512e and 4Kn devices.
+const directIOAlignment = 4096
+
+// zeros is the buffer written by Copy. It is never modified.
+var zeros = alignedBuffer(1<<20, directIOAlignment)
+
+// alignedBuffer returns a buffer of the given size whose first byte is
+// aligned to align bytes.
+func alignedBuffer(size, align int) []byte {
+	buf := make([]byte, size+align)
+	offset := int(uintptr(unsafe.Pointer(&buf[0])) & uintptr(align-1))
+	if offset != 0 {
+		offset = align - offset
+	}
+	return buf[offset : offset+size]
+}
+
+func (copyZeros) Name() string { return "copy" }
+
+func (copyZeros) wipe(f *os.File, offset, length uint64) error {
+	for length > 0 {
+		n := uint64(len(zeros))
+		if length < n {
+			n = length
+		}
+		if _, err := f.WriteAt(zeros[:n], int64(offset)); err != nil {
+			return err
+		}
+		offset += n
+		length -= n
+	}
+	return nil
+}
+
+// Methods lists the available methods.
+var Methods = []Method{Zeroout, Discard, Copy}
+
+// LookupMethod returns the method with the given name.
+func LookupMethod(name string) (Method, error) {
+	for _, m := range Methods {
+		if m.Name() == name {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("wipe: unknown method %q", name)
+}
+
+// Device overwrites the device at path with zeros. The methods are tried
+// in order; if the device does not support a method, the next one is used.
+// The device is wiped blockSize bytes at a time, or DefaultBlockSize if
+// blockSize is zero. If bytesPerSecond is not zero, Device pauses between
+// blocks so that the average rate does not exceed it. The progress callback
+// may be nil. Device returns the name of the method that wiped the device.
+func Device(ctx context.Context, path string, methods []Method, blockSize, bytesPerSecond uint64, progress Progress) (string, error) {
+	if len(methods) == 0 {
+		return "", fmt.Errorf("wipe: no methods given")
+	}
+	if blockSize == 0 {
+		blockSize = DefaultBlockSize
+	}
+	if err := ValidateBlockSize(blockSize); err != nil {
+		return "", err
+	}
+	f, total, err := openDevice(path, blockSize)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var unsupported error
+	for _, m := range methods {
+		err := wipe(ctx, f, m, total, blockSize, bytesPerSecond, progress)
+		if _, ok := err.(*unsupportedError); ok {
+			unsupported = err
+			continue
+		}
+		if err != nil {
+			return m.Name(), err
+		}
+		// Ensure the zeros reach the device before it is removed.
+		if err := f.Sync(); err != nil {
+			return m.Name(), err
+		}
+		return m.Name(), nil
+	}
+	return "", unsupported
+}
+
+// openDevice opens the device at path for writing and returns its size. A
+// block device is opened with O_DIRECT, so that Copy does not evict the
+// memory of applications from the page cache, if the block size and the
+// size of the device are multiples of its logical block size.
+func openDevice(path string, blockSize uint64) (*os.File, uint64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	total := uint64(size)
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if info.Mode()&os.ModeDevice == 0 || oDirect == 0 {
+		return f, total, nil
+	}
+	lbs, err := logicalBlockSize(f)
+	if err != nil || lbs == 0 || blockSize%lbs != 0 || total%lbs != 0 {
+		return f, total, nil
+	}
+	direct, err := os.OpenFile(path, os.O_WRONLY|oDirect, 0)
+	if err != nil {
+		// Fall back to writing through the page cache.
+		return f, total, nil
+	}
+	f.Close()
+	return direct, total, nil
+}
+
+// throttle waits until wiping wiped bytes since start no longer exceeds
+// bytesPerSecond, or returns early if ctx is done.
+func throttle(ctx context.Context, start time.Time, wiped, bytesPerSecond uint64) error {
+	if bytesPerSecond == 0 {
+		return nil
+	}
+	due := start.Add(time.Duration(float64(wiped) / float64(bytesPerSecond) * float64(time.Second)))
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func wipe(ctx context.Context, f *os.File, m Method, total, blockSize, bytesPerSecond uint64, progress Progress) error {
+	start := time.Now()
+	for offset := uint64(0); offset < total; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		length := blockSize
+		if total-offset < length {
+			length = total - offset
+		}
+		if err := m.wipe(f, offset, length); err != nil {
+			return err
+		}
+		offset += length
+		if progress != nil {
+			progress(offset, total)
+		}
+		if err := throttle(ctx, start, offset, bytesPerSecond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package wipe
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func testFile(t *testing.T, size int) string {
+	f, err := ioutil.TempFile("", "csilvm-wipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(bytes.Repeat([]byte{0xff}, size)); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestDevice(t *testing.T) {
+	const size = 3<<20 + 512
+	path := testFile(t, size)
+	defer os.Remove(path)
+	var calls int
+	var lastWiped, lastTotal uint64
+	progress := func(wiped, total uint64) {
+		calls++
+		lastWiped, lastTotal = wiped, total
+	}
+	// Regular files do not support BLKZEROOUT so Device falls back to
+	// copying zeros.
+	method, err := Device(context.Background(), path, []Method{Zeroout, Copy}, 0, 0, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != Copy.Name() {
+		t.Fatalf("Expected method %q but got %q", Copy.Name(), method)
+	}
+	if calls == 0 || lastWiped != size || lastTotal != size {
+		t.Fatalf("Expected final progress %d/%d but got %d/%d after %d calls", size, size, lastWiped, lastTotal, calls)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != size || !bytes.Equal(data, make([]byte, size)) {
+		t.Fatalf("Expected %d zero bytes", size)
+	}
+}
+
+func TestDeviceUnsupported(t *testing.T) {
+	path := testFile(t, 4096)
+	defer os.Remove(path)
+	_, err := Device(context.Background(), path, []Method{Zeroout, Discard}, 0, 0, nil)
+	if _, ok := err.(*unsupportedError); !ok {
+		t.Fatalf("Expected an unsupportedError but got %v", err)
+	}
+}
+
+func TestDeviceCanceled(t *testing.T) {
+	path := testFile(t, 4096)
+	defer os.Remove(path)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Device(ctx, path, []Method{Copy}, 0, 0, nil); err != context.Canceled {
+		t.Fatalf("Expected %v but got %v", context.Canceled, err)
+	}
+}
+
+func TestLookupMethod(t *testing.T) {
+	for _, m := range Methods {
+		got, err := LookupMethod(m.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != m {
+			t.Fatalf("Expected %v but got %v", m, got)
+		}
+	}
+	if _, err := LookupMethod("shred"); err == nil {
+		t.Fatal("Expected an error for an unknown method")
+	}
+}
+
+func TestDeviceBlockSize(t *testing.T) {
+	const size = 8 << 10
+	path := testFile(t, size)
+	defer os.Remove(path)
+	var calls int
+	progress := func(wiped, total uint64) { calls++ }
+	if _, err := Device(context.Background(), path, []Method{Copy}, 3<<10, 0, progress); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Fatalf("Expected 3 progress reports but got %d", calls)
+	}
+	for _, blockSize := range []uint64{100, 4097} {
+		if _, err := Device(context.Background(), path, []Method{Copy}, blockSize, 0, nil); err == nil {
+			t.Fatalf("Expected an error for block size %d", blockSize)
+		}
+	}
+}
+
+func TestDeviceRateLimit(t *testing.T) {
+	const size = 64 << 10
+	path := testFile(t, size)
+	defer os.Remove(path)
+	start := time.Now()
+	// Wiping 64KiB at 256KiB/s takes at least 250ms.
+	if _, err := Device(context.Background(), path, []Method{Copy}, 16<<10, 256<<10, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("Expected the wipe to be throttled but it took %v", elapsed)
+	}
+	// A throttled wipe is canceled while it waits.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Device(ctx, path, []Method{Copy}, 16<<10, 1<<10, nil); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v but got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestAlignedBuffer(t *testing.T) {
+	if len(zeros) != 1<<20 {
+		t.Fatalf("Expected a 1MiB buffer but got %d bytes", len(zeros))
+	}
+	if addr := uintptr(unsafe.Pointer(&zeros[0])); addr%directIOAlignment != 0 {
+		t.Fatalf("Expected the buffer to be aligned to %d bytes but got %#x", directIOAlignment, addr)
+	}
+}